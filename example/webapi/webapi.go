@@ -7,7 +7,7 @@ import (
 	"io/ioutil"
 	"net/http"
 
-	"github.com/BurntSushi/toml"
+	"github.com/pyama86/pftp/pftp"
 )
 
 type config struct {
@@ -58,8 +58,7 @@ func RequestToServer(requestURI string, param string) (*Response, error) {
 // Make request URL from config file and has request to server with username parameter.
 func GetDomainFromWebAPI(path string, param string) (*string, error) {
 	var conf config
-	_, err := toml.DecodeFile(path, &conf)
-	if err != nil {
+	if err := pftp.DecodeConfigFile(path, &conf); err != nil {
 		return nil, err
 	}
 
@@ -13,6 +13,8 @@ require (
 	github.com/pires/go-proxyproto v0.6.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/tevino/abool v1.2.0
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 	golang.org/x/sys v0.0.0-20210823070655-63515b42dcdf
+	gopkg.in/yaml.v3 v3.0.1
 )
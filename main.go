@@ -1,16 +1,44 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	logrus_stack "github.com/Gurpartap/logrus-stack"
 	"github.com/pyama86/pftp/example/webapi"
 	"github.com/pyama86/pftp/pftp"
+	"github.com/pyama86/pftp/pftp/adminclient"
 	"github.com/sirupsen/logrus"
 )
 
 var confFile = "./config.toml"
 
+const usage = `usage: pftp <command> [options] [args]
+
+commands:
+  serve               run ftp proxy (default)
+  check-config        validate config file
+  sessions list       list client sessions of running server
+  sessions kill <id>  send 421 to client session of running server and close it
+  routes test <user>  show origin decided for user by running server
+  drain               drain running server and wait until sessions hit zero (preStop hook)
+
+options:
+  -config path        config file (default: ./config.toml)
+  -admin addr         admin API of running server (default: admin_listen_addr of config)
+  -token token        bearer token of admin API (default: $PFTP_ADMIN_TOKEN)
+  -host name          host name of HOST command (routes test)
+  -grace seconds      wait of drain (default: drain_timeout of server)
+`
+
 func init() {
 	logrus.SetLevel(logrus.DebugLevel)
 	stackLevels := []logrus.Level{logrus.PanicLevel, logrus.FatalLevel}
@@ -18,6 +46,82 @@ func init() {
 }
 
 func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "pftp:", err)
+		os.Exit(1)
+	}
+}
+
+// commandFlags are options shared by subcommands
+type commandFlags struct {
+	set   *flag.FlagSet
+	admin string
+	token string
+	host  string
+	grace int
+}
+
+func parseFlags(args []string) (*commandFlags, error) {
+	f := &commandFlags{set: flag.NewFlagSet("pftp", flag.ContinueOnError)}
+	f.set.Usage = func() { fmt.Fprint(f.set.Output(), usage) }
+	f.set.StringVar(&confFile, "config", confFile, "config file")
+	f.set.StringVar(&f.admin, "admin", "", "admin API address of running server")
+	f.set.StringVar(&f.token, "token", os.Getenv("PFTP_ADMIN_TOKEN"), "bearer token of admin API")
+	f.set.StringVar(&f.host, "host", "", "host name of HOST command")
+	f.set.IntVar(&f.grace, "grace", -1, "seconds to wait drain")
+
+	return f, f.set.Parse(args)
+}
+
+func run(args []string, out io.Writer) error {
+	// run server when command is omitted for compatibility
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	subcommand := ""
+	if (command == "sessions" || command == "routes") && len(args) > 0 {
+		subcommand, args = args[0], args[1:]
+	}
+
+	f, err := parseFlags(args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	switch command + " " + subcommand {
+	case "serve ":
+		return serve()
+	case "check-config ":
+		if _, err := pftp.LoadConfig(confFile); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s is valid\n", confFile)
+		return nil
+	case "sessions list":
+		return listSessions(f, out)
+	case "sessions kill":
+		if f.set.NArg() != 1 {
+			return fmt.Errorf("usage: pftp sessions kill <id>")
+		}
+		return killSession(f, f.set.Arg(0), out)
+	case "routes test":
+		if f.set.NArg() != 1 {
+			return fmt.Errorf("usage: pftp routes test <user>")
+		}
+		return testRoute(f, f.set.Arg(0), out)
+	case "drain ":
+		return drain(f, out)
+	}
+
+	f.set.Usage()
+	return fmt.Errorf("unknown command: %s", strings.TrimSpace(command+" "+subcommand))
+}
+
+func serve() error {
 	ftpServer, err := pftp.NewFtpServer(confFile)
 	if err != nil {
 		logrus.Fatal(err)
@@ -27,6 +131,133 @@ func main() {
 	if err := ftpServer.Start(); err != nil {
 		logrus.Fatal(err)
 	}
+
+	return nil
+}
+
+// return base URL of admin API of running server
+func adminURL(f *commandFlags) (string, error) {
+	addr, scheme := f.admin, "http://"
+	if len(addr) == 0 {
+		c, err := pftp.LoadConfig(confFile)
+		if err != nil {
+			return "", err
+		}
+		addr = c.AdminListenAddr
+		if len(c.AdminTLSCert) > 0 {
+			scheme = "https://"
+		}
+	}
+	if len(addr) == 0 {
+		return "", fmt.Errorf("admin API is disabled. set admin_listen_addr or -admin")
+	}
+
+	// -admin may be URL of admin API served by TLS
+	if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+		return strings.TrimSuffix(addr, "/"), nil
+	}
+
+	// ":8021" is listening on all addresses
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+
+	return scheme + addr, nil
+}
+
+// return client of admin API of running server. requests have no timeout
+// when timeout is 0.
+func newAdminClient(f *commandFlags, timeout time.Duration) (*adminclient.Client, error) {
+	base, err := adminURL(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return adminclient.New(base,
+		adminclient.WithToken(f.token),
+		adminclient.WithHTTPClient(&http.Client{Timeout: timeout}),
+	), nil
+}
+
+func listSessions(f *commandFlags, out io.Writer) error {
+	c, err := newAdminClient(f, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	sessions, err := c.ListSessions(context.Background())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCLIENT\tUSER\tORIGIN\tCONNECTED")
+	for _, s := range sessions {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", s.ID, s.ClientAddr, s.User, s.Origin, s.ConnectedAt.Format(time.RFC3339))
+	}
+
+	return w.Flush()
+}
+
+func killSession(f *commandFlags, id string, out io.Writer) error {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return fmt.Errorf("session id must be number: %s", id)
+	}
+	c, err := newAdminClient(f, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	if err := c.KillSession(context.Background(), n); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "session %s is closed\n", id)
+
+	return nil
+}
+
+func testRoute(f *commandFlags, user string, out io.Writer) error {
+	c, err := newAdminClient(f, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	route, err := c.Route(context.Background(), user, f.host)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "user:\t%s\n", route.User)
+	if len(route.Host) > 0 {
+		fmt.Fprintf(w, "host:\t%s\n", route.Host)
+	}
+	fmt.Fprintf(w, "origin:\t%s\n", route.RemoteAddr)
+	if len(route.FailoverAddrs) > 0 {
+		fmt.Fprintf(w, "failover:\t%s\n", strings.Join(route.FailoverAddrs, ", "))
+	}
+	if len(route.OriginProxy) > 0 {
+		fmt.Fprintf(w, "origin proxy:\t%s\n", route.OriginProxy)
+	}
+	if len(route.RootPath) > 0 {
+		fmt.Fprintf(w, "root path:\t%s\n", route.RootPath)
+	}
+	fmt.Fprintf(w, "read only:\t%v\n", route.ReadOnly)
+
+	return w.Flush()
+}
+
+// drain waits as long as grace, so request has no timeout
+func drain(f *commandFlags, out io.Writer) error {
+	c, err := newAdminClient(f, 0)
+	if err != nil {
+		return err
+	}
+	active, err := c.Drain(context.Background(), time.Duration(f.grace)*time.Second)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "drained with %d active sessions\n", active)
+
+	return nil
 }
 
 // User function will setup Origin ftp server domain from ftp username
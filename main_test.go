@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_run(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.RequestURI() {
+		case "GET /sessions":
+			fmt.Fprint(rw, `[{"id":3,"client_addr":"127.0.0.1:50000","user":"vsuser","origin":"127.0.0.1:10021","connected_at":"2021-09-01T00:00:00Z"}]`)
+		case "DELETE /sessions/3":
+			rw.WriteHeader(http.StatusNoContent)
+		case "DELETE /sessions/5":
+			if r.Header.Get("Authorization") != "Bearer s3cret" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(rw, `{"error":"authentication required"}`)
+				return
+			}
+			rw.WriteHeader(http.StatusNoContent)
+		case "DELETE /sessions/4":
+			rw.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(rw, `{"error":"unknown session"}`)
+		case "POST /drain?grace=30":
+			if r.Header.Get("Authorization") != "Bearer s3cret" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(rw, `{"active_sessions":0}`)
+		case "GET /routes/vsuser?host=ftp.example":
+			fmt.Fprint(rw, `{"user":"vsuser","host":"ftp.example","remote_addr":"127.0.0.1:10021","failover_addrs":[],"read_only":true}`)
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer admin.Close()
+	addr := strings.TrimPrefix(admin.URL, "http://")
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "check_config",
+			args: []string{"check-config", "-config", "./config.toml"},
+			want: []string{"./config.toml is valid"},
+		},
+		{
+			name:    "check_config_missing",
+			args:    []string{"check-config", "-config", "./missing.toml"},
+			wantErr: "missing.toml",
+		},
+		{
+			name: "sessions_list",
+			args: []string{"sessions", "list", "-admin", addr},
+			want: []string{"ID  CLIENT", "3   127.0.0.1:50000  vsuser  127.0.0.1:10021  2021-09-01T00:00:00Z"},
+		},
+		{
+			name: "sessions_kill",
+			args: []string{"sessions", "kill", "-admin", addr, "3"},
+			want: []string{"session 3 is closed"},
+		},
+		{
+			name: "sessions_kill_token",
+			args: []string{"sessions", "kill", "-admin", admin.URL, "-token", "s3cret", "5"},
+			want: []string{"session 5 is closed"},
+		},
+		{
+			name:    "sessions_kill_no_token",
+			args:    []string{"sessions", "kill", "-admin", addr, "5"},
+			wantErr: "authentication required",
+		},
+		{
+			name:    "sessions_kill_unknown",
+			args:    []string{"sessions", "kill", "-admin", addr, "4"},
+			wantErr: "unknown session",
+		},
+		{
+			name: "routes_test",
+			args: []string{"routes", "test", "-admin", addr, "-host", "ftp.example", "vsuser"},
+			want: []string{"host:       ftp.example", "origin:     127.0.0.1:10021", "read only:  true"},
+		},
+		{
+			name: "drain",
+			args: []string{"drain", "-admin", addr, "-token", "s3cret", "-grace", "30"},
+			want: []string{"drained with 0 active sessions"},
+		},
+		{
+			name:    "unknown",
+			args:    []string{"sessions", "drop"},
+			wantErr: "unknown command: sessions drop",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(f string) { confFile = f }(confFile)
+
+			var out strings.Builder
+			err := run(tt.args, &out)
+			if len(tt.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("run() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output = %s, want %s", out.String(), want)
+				}
+			}
+		})
+	}
+}
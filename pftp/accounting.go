@@ -0,0 +1,272 @@
+package pftp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const accountingDayFormat = "2006-01-02"
+
+// Usage is transferred bytes and session count of user in a day (UTC)
+type Usage struct {
+	Day           string `json:"day"`
+	User          string `json:"user"`
+	UploadBytes   int64  `json:"upload_bytes"`
+	DownloadBytes int64  `json:"download_bytes"`
+	Sessions      int64  `json:"sessions"`
+}
+
+// AccountingStore persist usage of users.
+// Add is called with increments to add to stored usage.
+// Query return usage of user (all users if empty) between from and to days (inclusive).
+type AccountingStore interface {
+	Add(usage []Usage) error
+	Query(user string, from string, to string) ([]Usage, error)
+}
+
+// WithAccountingStore set store of usage accounting.
+// It is used instead of accounting_file (ex. SQLite or Redis store).
+func WithAccountingStore(s AccountingStore) Option {
+	return func(server *FtpServer) {
+		server.accountingStore = s
+	}
+}
+
+type usageKey struct {
+	day  string
+	user string
+}
+
+// accounting aggregate usage in memory and flush it to store periodically.
+// it is shared by all client sessions of server.
+type accounting struct {
+	mutex   sync.Mutex
+	store   AccountingStore
+	pending map[usageKey]*Usage
+	now     func() time.Time
+}
+
+func newAccounting(store AccountingStore) *accounting {
+	if store == nil {
+		return nil
+	}
+
+	return &accounting{
+		store:   store,
+		pending: map[usageKey]*Usage{},
+		now:     time.Now,
+	}
+}
+
+// return pending usage of user today
+func (a *accounting) usage(user string) *Usage {
+	key := usageKey{day: a.now().UTC().Format(accountingDayFormat), user: user}
+	u, ok := a.pending[key]
+	if !ok {
+		u = &Usage{Day: key.day, User: key.user}
+		a.pending[key] = u
+	}
+
+	return u
+}
+
+// add transferred bytes of user by direction
+func (a *accounting) addTransfer(user string, direction string, bytes int64) {
+	if a == nil || len(user) == 0 || bytes <= 0 {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	switch direction {
+	case uploadStream:
+		a.usage(user).UploadBytes += bytes
+	case downloadStream:
+		a.usage(user).DownloadBytes += bytes
+	}
+}
+
+// add session of user
+func (a *accounting) addSession(user string) {
+	if a == nil || len(user) == 0 {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.usage(user).Sessions++
+}
+
+// write pending usage to store. usage is kept when store failed.
+func (a *accounting) flush() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if len(a.pending) == 0 {
+		return nil
+	}
+
+	usage := make([]Usage, 0, len(a.pending))
+	for _, u := range a.pending {
+		usage = append(usage, *u)
+	}
+	if err := a.store.Add(usage); err != nil {
+		return err
+	}
+	a.pending = map[usageKey]*Usage{}
+
+	return nil
+}
+
+// return stored usage including pending one
+func (a *accounting) query(user string, from string, to string) ([]Usage, error) {
+	if err := a.flush(); err != nil {
+		return nil, err
+	}
+
+	return a.store.Query(user, from, to)
+}
+
+// flush usage every interval until stop is closed
+func (a *accounting) run(interval time.Duration, stop chan struct{}, log func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			if err := a.flush(); err != nil {
+				log(err)
+			}
+			return
+		}
+		if err := a.flush(); err != nil {
+			log(err)
+		}
+	}
+}
+
+// fileAccountingStore keep usage in JSON file
+type fileAccountingStore struct {
+	mutex      sync.Mutex // guards usage
+	writeMutex sync.Mutex // serializes Add while file is written
+	path       string
+	usage      map[usageKey]*Usage
+}
+
+func newFileAccountingStore(path string) (*fileAccountingStore, error) {
+	s := &fileAccountingStore{path: path, usage: map[usageKey]*Usage{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var usage []Usage
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, fmt.Errorf("accounting file %s is broken: %s", path, err.Error())
+	}
+	for i := range usage {
+		s.usage[usageKey{day: usage[i].Day, user: usage[i].User}] = &usage[i]
+	}
+
+	return s, nil
+}
+
+// Add usage and rewrite file. usage is changed in memory after file is
+// written, so failed Add can be retried without counting usage twice.
+func (s *fileAccountingStore) Add(usage []Usage) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	s.mutex.Lock()
+	added := make(map[usageKey]*Usage, len(s.usage))
+	for key, u := range s.usage {
+		copied := *u
+		added[key] = &copied
+	}
+	s.mutex.Unlock()
+
+	for _, u := range usage {
+		key := usageKey{day: u.Day, user: u.User}
+		stored, ok := added[key]
+		if !ok {
+			stored = &Usage{Day: u.Day, User: u.User}
+			added[key] = stored
+		}
+		stored.UploadBytes += u.UploadBytes
+		stored.DownloadBytes += u.DownloadBytes
+		stored.Sessions += u.Sessions
+	}
+
+	// write temporary file and rename it for not to break file on crash
+	data, err := json.Marshal(sortedUsage(added, "", "", ""))
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	s.mutex.Lock()
+	s.usage = added
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// Query usage by user and day range
+func (s *fileAccountingStore) Query(user string, from string, to string) ([]Usage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return sortedUsage(s.usage, user, from, to), nil
+}
+
+// return usage ordered by day and user
+func sortedUsage(all map[usageKey]*Usage, user string, from string, to string) []Usage {
+	usage := []Usage{}
+	for _, u := range all {
+		if (len(user) > 0 && u.User != user) || (len(from) > 0 && u.Day < from) || (len(to) > 0 && u.Day > to) {
+			continue
+		}
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Day != usage[j].Day {
+			return usage[i].Day < usage[j].Day
+		}
+		return usage[i].User < usage[j].User
+	})
+
+	return usage
+}
@@ -0,0 +1,107 @@
+package pftp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type failingAccountingStore struct{}
+
+func (failingAccountingStore) Add([]Usage) error { return errors.New("store is down") }
+func (failingAccountingStore) Query(string, string, string) ([]Usage, error) {
+	return nil, errors.New("store is down")
+}
+
+func Test_accounting_flush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounting.json")
+	store, err := newFileAccountingStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2021, 9, 1, 23, 0, 0, 0, time.UTC)
+	a := newAccounting(store)
+	a.now = func() time.Time { return now }
+
+	a.addSession("alice")
+	a.addTransfer("alice", uploadStream, 100)
+	a.addTransfer("alice", downloadStream, 30)
+	a.addTransfer("bob", downloadStream, 10)
+	a.addTransfer("", downloadStream, 10)
+	if err := a.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	a.addTransfer("alice", uploadStream, 5)
+	a.addSession("alice")
+
+	// pending usage is included in query
+	got, err := a.query("alice", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Usage{
+		{Day: "2021-09-01", User: "alice", UploadBytes: 100, DownloadBytes: 30, Sessions: 1},
+		{Day: "2021-09-02", User: "alice", UploadBytes: 5, Sessions: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("accounting.query() = %+v, want %+v", got, want)
+	}
+
+	// usage is persisted in file
+	reloaded, err := newFileAccountingStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = reloaded.Query("", "2021-09-01", "2021-09-01")
+	want = []Usage{
+		{Day: "2021-09-01", User: "alice", UploadBytes: 100, DownloadBytes: 30, Sessions: 1},
+		{Day: "2021-09-01", User: "bob", DownloadBytes: 10},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fileAccountingStore.Query() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_accounting_flush_failed(t *testing.T) {
+	a := newAccounting(failingAccountingStore{})
+	a.addTransfer("alice", uploadStream, 100)
+
+	if err := a.flush(); err == nil {
+		t.Fatal("accounting.flush() error = nil, want error")
+	}
+	if u := a.pending[usageKey{day: a.now().UTC().Format(accountingDayFormat), user: "alice"}]; u == nil || u.UploadBytes != 100 {
+		t.Errorf("pending usage is lost after flush failure: %+v", u)
+	}
+}
+
+func Test_fileAccountingStore_Add_failed(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newFileAccountingStore(filepath.Join(dir, "missing", "accounting.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage := []Usage{{Day: "2021-09-01", User: "alice", UploadBytes: 100}}
+	if err := store.Add(usage); err == nil {
+		t.Fatal("fileAccountingStore.Add() error = nil, want error")
+	}
+	if got, _ := store.Query("", "", ""); len(got) != 0 {
+		t.Errorf("usage is counted after failed write: %+v", got)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "missing"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(usage); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.Query("", "", ""); !reflect.DeepEqual(got, usage) {
+		t.Errorf("fileAccountingStore.Query() = %+v, want %+v", got, usage)
+	}
+}
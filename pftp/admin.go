@@ -0,0 +1,308 @@
+package pftp
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// adminError is body of admin API error response
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// return handler of admin API
+func (server *FtpServer) adminHandler() http.Handler {
+	router := httprouter.New()
+	observe := func(h httprouter.Handle) httprouter.Handle { return server.authorize(adminRoleObserver, h) }
+	operate := func(h httprouter.Handle) httprouter.Handle { return server.authorize(adminRoleOperator, h) }
+
+	router.GET("/metrics", observe(server.handleMetrics))
+	router.GET("/accounting", observe(server.handleAccounting))
+	router.GET("/origins", observe(server.handleOrigins))
+	router.POST("/origins/:origin/drain", server.audited("drain_origin", operate(server.handleDrainOrigin)))
+	router.DELETE("/origins/:origin/drain", server.audited("undrain_origin", operate(server.handleUndrainOrigin)))
+	router.GET("/stats.json", observe(server.handleStats))
+	router.GET("/sessions", observe(server.handleListSessions))
+	router.DELETE("/sessions/:id", server.audited("kill_session", operate(server.handleKillSession)))
+	router.GET("/sessions/:id/tail", server.audited("tail_session", operate(server.handleTailSession)))
+	router.GET("/sessions/:id/timings", observe(server.handleSessionTimings))
+	router.GET("/sessions/:id/capture", server.audited("capture_status", operate(server.handleCaptureStatus)))
+	router.POST("/sessions/:id/capture", server.audited("capture_session", operate(server.handleStartCapture)))
+	router.DELETE("/sessions/:id/capture", server.audited("stop_capture", operate(server.handleStopCapture)))
+	router.POST("/notices", server.audited("notify", operate(server.handleNotify)))
+	router.GET("/bans", observe(server.handleListBans))
+	router.DELETE("/bans/:ip", server.audited("clear_ban", operate(server.handleClearBan)))
+	router.GET("/blocks", observe(server.handleListBlocks))
+	router.POST("/blocks/:user", server.audited("block_user", operate(server.handleBlockUser)))
+	router.DELETE("/blocks/:user", server.audited("lift_block", operate(server.handleLiftBlock)))
+	router.GET("/routes/:user", observe(server.handleTestRoute))
+	// probe of orchestrator is not authenticated
+	router.GET("/readyz", server.handleReadiness)
+	router.POST("/drain", server.audited("drain", operate(server.handleDrain)))
+	router.GET("/standby", observe(server.handleStandby))
+	router.POST("/promote", server.audited("promote", operate(server.handlePromote)))
+	router.POST("/demote", server.audited("demote", operate(server.handleDemote)))
+	router.GET("/spool", observe(server.handleListSpool))
+	router.POST("/spool/:id", server.audited("retry_spool", operate(server.handleRetrySpool)))
+	router.DELETE("/spool/:id", server.audited("drop_spool", operate(server.handleDropSpool)))
+
+	return router
+}
+
+// wait for admin requests running at stop up to this
+const adminShutdownTimeout = 5 * time.Second
+
+// start admin API on admin_listen_addr in background
+func (server *FtpServer) startAdmin() error {
+	if len(server.config.AdminListenAddr) == 0 {
+		return nil
+	}
+
+	l, err := listenAdmin(server.config)
+	if err != nil {
+		return err
+	}
+
+	server.admin = &http.Server{Handler: server.adminHandler()}
+	if !server.adminAuthEnabled() {
+		server.logger.Warn("admin API is not authenticated. set admin_tokens or admin_client_ca")
+	}
+	server.logger.Info("Admin API listening address ", l.Addr())
+
+	go func() {
+		if err := server.admin.Serve(l); err != nil && err != http.ErrServerClosed {
+			server.logger.Error("admin API stopped: ", err.Error())
+		}
+	}()
+
+	return nil
+}
+
+// listen on admin_listen_addr with TLS of admin_tls_cert when it is set
+func listenAdmin(c *Config) (net.Listener, error) {
+	l, err := net.Listen("tcp", c.AdminListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.AdminTLSCert) == 0 {
+		return l, nil
+	}
+
+	config, err := adminTLSConfig(c)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return tls.NewListener(l, config), nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// GET /metrics
+// return counters in Prometheus text format
+func (server *FtpServer) handleMetrics(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if server.metrics != nil {
+		server.metrics.write(w)
+	}
+}
+
+// GET /accounting?user=name&from=2006-01-02&to=2006-01-02
+// return usage of users per day. all parameters are optional.
+func (server *FtpServer) handleAccounting(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if server.accounting == nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "accounting is not enabled"})
+		return
+	}
+
+	q := r.URL.Query()
+	from, to := q.Get("from"), q.Get("to")
+	for _, day := range []string{from, to} {
+		if _, err := time.Parse(accountingDayFormat, day); len(day) > 0 && err != nil {
+			writeJSON(w, http.StatusBadRequest, adminError{Error: "day must be YYYY-MM-DD"})
+			return
+		}
+	}
+
+	usage, err := server.accounting.query(q.Get("user"), from, to)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, usage)
+}
+
+// GET /origins
+// return connection statistics of origins
+func (server *FtpServer) handleOrigins(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, server.originStats.list())
+}
+
+// GET /stats.json
+// return snapshot of sessions, origins, limits and uptime for dashboards
+func (server *FtpServer) handleStats(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, server.stats())
+}
+
+// GET /sessions
+// return connected client sessions
+func (server *FtpServer) handleListSessions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, server.clients.list())
+}
+
+// DELETE /sessions/:id
+// send 421 to client session and close it
+func (server *FtpServer) handleKillSession(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: "session id must be number"})
+		return
+	}
+
+	if !server.clients.kill(id) {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "unknown session"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /notices {"message": "maintenance in 10 minutes", "sessions": [1, 2]}
+// send administrative notice to sessions with next reply. sessions is optional
+// and all sessions are notified when it is omitted.
+func (server *FtpServer) handleNotify(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req struct {
+		Message  string   `json:"message"`
+		Sessions []uint64 `json:"sessions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: "invalid request body"})
+		return
+	}
+	if len(strings.TrimSpace(req.Message)) == 0 {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: "message is empty"})
+		return
+	}
+
+	n := server.Notify(req.Message, req.Sessions...)
+	writeJSON(w, http.StatusOK, struct {
+		Notified int `json:"notified"`
+	}{n})
+}
+
+// GET /bans
+// return login failure records of client IPs. banned is true while ban is in effect.
+func (server *FtpServer) handleListBans(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if server.bans == nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "ban is not enabled"})
+		return
+	}
+
+	type ban struct {
+		IP     string `json:"ip"`
+		Banned bool   `json:"banned"`
+		IPRecord
+	}
+
+	now := server.bans.now()
+	bans := []ban{}
+	for ip, record := range server.bans.list() {
+		bans = append(bans, ban{IP: ip, Banned: now.Before(record.BannedUntil), IPRecord: record})
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+
+	writeJSON(w, http.StatusOK, bans)
+}
+
+// DELETE /bans/:ip
+// lift ban of client IP
+func (server *FtpServer) handleClearBan(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if server.bans == nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "ban is not enabled"})
+		return
+	}
+
+	if !server.bans.clear(ps.ByName("ip")) {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "unknown IP"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RouteInfo is origin decided for user by config and middleware
+type RouteInfo struct {
+	User          string   `json:"user"`
+	Host          string   `json:"host,omitempty"`
+	RemoteAddr    string   `json:"remote_addr"`
+	FailoverAddrs []string `json:"failover_addrs"`
+	OriginProxy   string   `json:"origin_proxy,omitempty"`
+	ReadOnly      bool     `json:"read_only"`
+	RootPath      string   `json:"root_path,omitempty"`
+}
+
+// GET /routes/:user?host=name
+// run HOST and USER middleware like client session and return decided route.
+// host is host name of HOST command.
+func (server *FtpServer) handleTestRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user := ps.ByName("user")
+	c := newContext(server.config)
+
+	var hostAddr string
+	if host := r.URL.Query().Get("host"); len(host) > 0 {
+		c.Host, c.RemoteAddr = routeHost(server.config, server.dynamic, host)
+		if m := server.middleware["HOST"]; m != nil {
+			if err := m(c, host); err != nil {
+				writeJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+				return
+			}
+		}
+		hostAddr = c.RemoteAddr
+	}
+
+	if server.config.DenyUnresolved {
+		c.RemoteAddr = hostAddr
+	}
+	if addr, ok := server.dynamic.userOrigin(user); ok {
+		c.RemoteAddr = addr
+	}
+	if a := server.anonymous; a != nil && a.isUser(user) {
+		c.Anonymous, c.ReadOnly = true, true
+		if len(a.config.Origin) > 0 {
+			c.RemoteAddr = a.config.Origin
+		}
+	}
+	if m := server.middleware["USER"]; m != nil {
+		if err := m(c, user); err != nil {
+			writeJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+			return
+		}
+	}
+	if c.Anonymous {
+		c.ReadOnly = true
+	}
+
+	writeJSON(w, http.StatusOK, RouteInfo{
+		User:          user,
+		Host:          c.Host,
+		RemoteAddr:    c.RemoteAddr,
+		FailoverAddrs: c.FailoverAddrs,
+		OriginProxy:   c.OriginProxy,
+		ReadOnly:      c.ReadOnly,
+		RootPath:      c.RootPath,
+	})
+}
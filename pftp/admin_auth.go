@@ -0,0 +1,143 @@
+package pftp
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// roles of admin API. operator can do everything observer can.
+const (
+	adminRoleObserver = "observer"
+	adminRoleOperator = "operator"
+)
+
+// validate roles of admin_tokens and admin_client_roles and TLS files of admin API
+func validateAdminAuth(c *Config) error {
+	for name, roles := range map[string]map[string]string{"admin_tokens": c.AdminTokens, "admin_client_roles": c.AdminClientRoles} {
+		for key, role := range roles {
+			if role != adminRoleObserver && role != adminRoleOperator {
+				return fmt.Errorf("configuration error: role %q of %s %q must be observer or operator", role, name, key)
+			}
+		}
+	}
+
+	if (len(c.AdminTLSCert) == 0) != (len(c.AdminTLSKey) == 0) {
+		return fmt.Errorf("configuration error: admin_tls_cert and admin_tls_key are required together")
+	}
+	if len(c.AdminClientCA) > 0 && len(c.AdminTLSCert) == 0 {
+		return fmt.Errorf("configuration error: admin_tls_cert is required by admin_client_ca")
+	}
+
+	return nil
+}
+
+// return true when admin API authenticates requests by tokens or client certificates
+func (server *FtpServer) adminAuthEnabled() bool {
+	return adminAuthConfigured(server.config)
+}
+
+func adminAuthConfigured(c *Config) bool {
+	return c != nil && (len(c.AdminTokens) > 0 || len(c.AdminClientCA) > 0)
+}
+
+// return TLS config of admin API. client certificates are verified by
+// admin_client_ca, and required when no token is configured.
+func adminTLSConfig(c *Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.AdminTLSCert, c.AdminTLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("admin TLS configuration error: %s", err.Error())
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(c.AdminClientCA) == 0 {
+		return config, nil
+	}
+
+	pem, err := ioutil.ReadFile(c.AdminClientCA)
+	if err != nil {
+		return nil, err
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("failed to parse admin client CA cert")
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if len(c.AdminTokens) > 0 {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
+
+// return role of request by verified client certificate or bearer token.
+// empty role is returned for unauthenticated request.
+func (server *FtpServer) adminRole(r *http.Request) string {
+	return adminRoleOf(server.config, r)
+}
+
+func adminRoleOf(c *Config, r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		if role, ok := c.AdminClientRoles[r.TLS.VerifiedChains[0][0].Subject.CommonName]; ok {
+			return role
+		}
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if len(token) == 0 || token == r.Header.Get("Authorization") {
+		return ""
+	}
+	for t, role := range c.AdminTokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return role
+		}
+	}
+
+	return ""
+}
+
+// key of context holding config of group for request passed by admin API of group
+type groupAdminConfigKey struct{}
+
+// return handler refusing request without role. requests are not
+// authenticated when admin API has no token and client CA. request passed
+// by admin API of group needs the role in config of group too.
+func (server *FtpServer) authorize(role string, h httprouter.Handle) httprouter.Handle {
+	h = authorizeAdmin(server.config, role, h)
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if c, ok := r.Context().Value(groupAdminConfigKey{}).(*Config); ok {
+			authorizeAdmin(c, role, h)(w, r, ps)
+			return
+		}
+		h(w, r, ps)
+	}
+}
+
+func authorizeAdmin(c *Config, role string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if !adminAuthConfigured(c) {
+			h(w, r, ps)
+			return
+		}
+
+		switch adminRoleOf(c, r) {
+		case "":
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, adminError{Error: "authentication required"})
+		case adminRoleOperator:
+			h(w, r, ps)
+		case role:
+			h(w, r, ps)
+		default:
+			writeJSON(w, http.StatusForbidden, adminError{Error: role + " role required"})
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package pftp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_FtpServer_authorize(t *testing.T) {
+	config := &Config{
+		AdminTokens:      map[string]string{"watch": "observer", "ops": "operator"},
+		AdminClientRoles: map[string]string{"oncall": "operator"},
+	}
+	tests := []struct {
+		name     string
+		config   *Config
+		method   string
+		path     string
+		token    string
+		cn       string
+		wantCode int
+	}{
+		{name: "not_authenticated", config: &Config{}, method: "DELETE", path: "/bans/192.0.2.1", wantCode: http.StatusNotFound},
+		{name: "no_token", config: config, method: "GET", path: "/sessions", wantCode: http.StatusUnauthorized},
+		{name: "wrong_token", config: config, method: "GET", path: "/sessions", token: "other", wantCode: http.StatusUnauthorized},
+		{name: "observer_reads", config: config, method: "GET", path: "/sessions", token: "watch", wantCode: http.StatusOK},
+		{name: "observer_kills", config: config, method: "DELETE", path: "/sessions/1", token: "watch", wantCode: http.StatusForbidden},
+		{name: "operator_kills", config: config, method: "DELETE", path: "/sessions/1", token: "ops", wantCode: http.StatusNotFound},
+		{name: "client_certificate", config: config, method: "DELETE", path: "/sessions/1", cn: "oncall", wantCode: http.StatusNotFound},
+		{name: "unknown_certificate", config: config, method: "GET", path: "/sessions", cn: "intern", wantCode: http.StatusUnauthorized},
+		{name: "readiness", config: config, method: "GET", path: "/readyz", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &FtpServer{config: tt.config, clients: newSessionRegistry()}
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if len(tt.token) > 0 {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if len(tt.cn) > 0 {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: tt.cn}}}}}
+			}
+
+			rec := httptest.NewRecorder()
+			server.adminHandler().ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+		})
+	}
+}
+
+func Test_validateAdminAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{name: "roles", config: &Config{AdminTokens: map[string]string{"a": "observer", "b": "operator"}}},
+		{name: "unknown_role", config: &Config{AdminTokens: map[string]string{"a": "admin"}}, wantErr: true},
+		{name: "cert_without_key", config: &Config{AdminTLSCert: "server.crt"}, wantErr: true},
+		{name: "client_ca_without_tls", config: &Config{AdminClientCA: "ca.crt"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateAdminAuth(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("validateAdminAuth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package pftp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_FtpServer_handleAccounting(t *testing.T) {
+	store, err := newFileAccountingStore(filepath.Join(t.TempDir(), "accounting.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Add([]Usage{
+		{Day: "2021-09-01", User: "alice", UploadBytes: 100, Sessions: 1},
+		{Day: "2021-09-02", User: "bob", DownloadBytes: 10, Sessions: 2},
+	})
+
+	tests := []struct {
+		name     string
+		server   *FtpServer
+		query    string
+		wantCode int
+		want     []Usage
+	}{
+		{
+			name:     "all",
+			server:   &FtpServer{accounting: newAccounting(store)},
+			wantCode: http.StatusOK,
+			want: []Usage{
+				{Day: "2021-09-01", User: "alice", UploadBytes: 100, Sessions: 1},
+				{Day: "2021-09-02", User: "bob", DownloadBytes: 10, Sessions: 2},
+			},
+		},
+		{
+			name:     "user_and_day",
+			server:   &FtpServer{accounting: newAccounting(store)},
+			query:    "?user=bob&from=2021-09-02&to=2021-09-30",
+			wantCode: http.StatusOK,
+			want:     []Usage{{Day: "2021-09-02", User: "bob", DownloadBytes: 10, Sessions: 2}},
+		},
+		{
+			name:     "wrong_day",
+			server:   &FtpServer{accounting: newAccounting(store)},
+			query:    "?from=yesterday",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "disabled",
+			server:   &FtpServer{},
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.server.adminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/accounting"+tt.query, nil))
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("GET /accounting code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.want == nil {
+				return
+			}
+
+			var got []Usage
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GET /accounting = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_FtpServer_handleBans(t *testing.T) {
+	g, _ := newBanGuard(&Config{MaxLoginFailures: 1, LoginFailureWindow: 60, BanDuration: 600}, nil, nil)
+	g.loginResult("192.0.2.1", false)
+	server := &FtpServer{bans: g}
+	handler := server.adminHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/bans", nil))
+	var bans []struct {
+		IP     string `json:"ip"`
+		Banned bool   `json:"banned"`
+		Bans   int    `json:"bans"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&bans); err != nil {
+		t.Fatal(err)
+	}
+	if len(bans) != 1 || bans[0].IP != "192.0.2.1" || !bans[0].Banned || bans[0].Bans != 1 {
+		t.Errorf("GET /bans = %+v", bans)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("DELETE", "/bans/192.0.2.1", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE /bans/192.0.2.1 code = %d", rec.Code)
+	}
+	if _, banned := g.banned("192.0.2.1"); banned {
+		t.Errorf("ban is not cleared by DELETE /bans/192.0.2.1")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("DELETE", "/bans/192.0.2.9", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE /bans/192.0.2.9 code = %d, want 404", rec.Code)
+	}
+}
+
+func Test_FtpServer_handleTestRoute(t *testing.T) {
+	c := DefaultConfig()
+	c.RemoteAddr = "127.0.0.1:21"
+	c.HostOrigins = map[string]string{"ftp.tenant.example": "127.0.0.1:30021"}
+	dynamic := newDynamicConfig()
+	dynamic.apply(map[string]string{
+		"routes/hosts/ftp.live.example": "127.0.0.1:40021",
+		"routes/users/liveuser":         "127.0.0.1:50021",
+	})
+	server := &FtpServer{
+		config:  c,
+		dynamic: dynamic,
+		middleware: middleware{
+			"USER": func(c *Context, user string) error {
+				switch user {
+				case "vsuser":
+					c.RemoteAddr = "127.0.0.1:10021"
+					c.ReadOnly = true
+				case "broken":
+					return errors.New("resolver is down")
+				}
+				return nil
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		want     RouteInfo
+	}{
+		{
+			name:     "middleware",
+			path:     "/routes/vsuser",
+			wantCode: http.StatusOK,
+			want:     RouteInfo{User: "vsuser", RemoteAddr: "127.0.0.1:10021", FailoverAddrs: []string{}, ReadOnly: true},
+		},
+		{
+			name:     "default",
+			path:     "/routes/prouser",
+			wantCode: http.StatusOK,
+			want:     RouteInfo{User: "prouser", RemoteAddr: "127.0.0.1:21", FailoverAddrs: []string{}},
+		},
+		{
+			name:     "host",
+			path:     "/routes/prouser?host=FTP.tenant.example",
+			wantCode: http.StatusOK,
+			want:     RouteInfo{User: "prouser", Host: "ftp.tenant.example", RemoteAddr: "127.0.0.1:30021", FailoverAddrs: []string{}},
+		},
+		{
+			name:     "dynamic_host",
+			path:     "/routes/prouser?host=ftp.live.example",
+			wantCode: http.StatusOK,
+			want:     RouteInfo{User: "prouser", Host: "ftp.live.example", RemoteAddr: "127.0.0.1:40021", FailoverAddrs: []string{}},
+		},
+		{
+			name:     "dynamic_user",
+			path:     "/routes/liveuser",
+			wantCode: http.StatusOK,
+			want:     RouteInfo{User: "liveuser", RemoteAddr: "127.0.0.1:50021", FailoverAddrs: []string{}},
+		},
+		{
+			name:     "error",
+			path:     "/routes/broken",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			server.adminHandler().ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("GET %s code = %d, want %d", tt.path, rec.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var got RouteInfo
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GET %s = %+v, want %+v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+// Package adminclient is client of admin API of pftp. tools controlling
+// running proxies use it instead of hand-written HTTP requests.
+//
+//	c := adminclient.New("http://127.0.0.1:8021", adminclient.WithToken(token))
+//	sessions, err := c.ListSessions(ctx)
+package adminclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyama86/pftp/pftp"
+)
+
+// Client request admin API of one server. it is safe for concurrent use.
+type Client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// Option configure Client
+type Option func(*Client)
+
+// WithToken send bearer token of admin_tokens
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithHTTPClient use h for requests, for example with client certificate of
+// admin_client_ca. h should not have Timeout to stream session tail.
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) { c.http = h }
+}
+
+// New return client of admin API at baseURL like "https://10.0.0.1:8021".
+// requests have no timeout and are canceled by their context.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{base: strings.TrimSuffix(baseURL, "/"), http: &http.Client{}}
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
+
+// Error is error response of admin API
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string // error of response body. empty when body has none
+}
+
+// ex) "DELETE /sessions/4: unknown session"
+func (e *Error) Error() string {
+	if len(e.Message) == 0 {
+		return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("%s %s: %s", e.Method, e.Path, e.Message)
+}
+
+// IsNotFound return true when err is 404 of admin API, like unknown session
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// send request with JSON body when body is not nil, and return response of
+// 2xx. caller closes body of response.
+func (c *Client) send(ctx context.Context, method string, path string, body interface{}) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		e := &Error{Method: method, Path: path, StatusCode: resp.StatusCode}
+		var res struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&res); err == nil {
+			e.Message = res.Error
+		}
+		return nil, e
+	}
+
+	return resp, nil
+}
+
+// request admin API and decode JSON response to v when v is not nil
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, v interface{}) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func sessionPath(id uint64) string {
+	return "/sessions/" + strconv.FormatUint(id, 10)
+}
+
+// ListSessions return connected client sessions
+func (c *Client) ListSessions(ctx context.Context) ([]pftp.SessionInfo, error) {
+	var sessions []pftp.SessionInfo
+	if err := c.do(ctx, http.MethodGet, "/sessions", nil, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// KillSession send 421 to client session and close it
+func (c *Client) KillSession(ctx context.Context, id uint64) error {
+	return c.do(ctx, http.MethodDelete, sessionPath(id), nil, nil)
+}
+
+// SessionTimings return time spent by session per kind. session_timings
+// must be enabled on server.
+func (c *Client) SessionTimings(ctx context.Context, id uint64) (map[string]pftp.TimingStat, error) {
+	var timings map[string]pftp.TimingStat
+	if err := c.do(ctx, http.MethodGet, sessionPath(id)+"/timings", nil, &timings); err != nil {
+		return nil, err
+	}
+
+	return timings, nil
+}
+
+// StartCapture start debug capture of session to file in capture_dir of
+// server. maxBytes limits the file under capture_max_bytes, and 0 means
+// capture_max_bytes.
+func (c *Client) StartCapture(ctx context.Context, id uint64, maxBytes int64) (*pftp.SessionCapture, error) {
+	req := struct {
+		MaxBytes int64 `json:"max_bytes,omitempty"`
+	}{maxBytes}
+
+	return c.capture(ctx, http.MethodPost, id, req)
+}
+
+// SessionCapture return state of capture of session
+func (c *Client) SessionCapture(ctx context.Context, id uint64) (*pftp.SessionCapture, error) {
+	return c.capture(ctx, http.MethodGet, id, nil)
+}
+
+// StopCapture stop capture of session and return its final state
+func (c *Client) StopCapture(ctx context.Context, id uint64) (*pftp.SessionCapture, error) {
+	return c.capture(ctx, http.MethodDelete, id, nil)
+}
+
+func (c *Client) capture(ctx context.Context, method string, id uint64, body interface{}) (*pftp.SessionCapture, error) {
+	var capture pftp.SessionCapture
+	if err := c.do(ctx, method, sessionPath(id)+"/capture", body, &capture); err != nil {
+		return nil, err
+	}
+
+	return &capture, nil
+}
+
+// Stats return snapshot of sessions, origins, limits and uptime
+func (c *Client) Stats(ctx context.Context) (*pftp.StatsSnapshot, error) {
+	var stats pftp.StatsSnapshot
+	if err := c.do(ctx, http.MethodGet, "/stats.json", nil, &stats); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// Origins return connection statistics of origins
+func (c *Client) Origins(ctx context.Context) ([]pftp.OriginStats, error) {
+	var origins []pftp.OriginStats
+	if err := c.do(ctx, http.MethodGet, "/origins", nil, &origins); err != nil {
+		return nil, err
+	}
+
+	return origins, nil
+}
+
+// Route return origin decided for user by config and middleware. host is
+// host name of HOST command and it is optional.
+func (c *Client) Route(ctx context.Context, user string, host string) (*pftp.RouteInfo, error) {
+	path := "/routes/" + url.PathEscape(user)
+	if len(host) > 0 {
+		path += "?host=" + url.QueryEscape(host)
+	}
+
+	var route pftp.RouteInfo
+	if err := c.do(ctx, http.MethodGet, path, nil, &route); err != nil {
+		return nil, err
+	}
+
+	return &route, nil
+}
+
+// Notify send administrative notice to sessions with their next reply. all
+// sessions are notified when sessions are omitted. return count of notified
+// sessions.
+func (c *Client) Notify(ctx context.Context, message string, sessions ...uint64) (int, error) {
+	req := struct {
+		Message  string   `json:"message"`
+		Sessions []uint64 `json:"sessions,omitempty"`
+	}{message, sessions}
+	var res struct {
+		Notified int `json:"notified"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/notices", req, &res); err != nil {
+		return 0, err
+	}
+
+	return res.Notified, nil
+}
+
+// ListBlocks return users whose logins are blocked
+func (c *Client) ListBlocks(ctx context.Context) ([]pftp.UserBlock, error) {
+	var blocks []pftp.UserBlock
+	if err := c.do(ctx, http.MethodGet, "/blocks", nil, &blocks); err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+// BlockUser close all sessions of user and block its logins for d. d is
+// rounded down to seconds and user_block_duration of server is used when it
+// is 0. return block and count of closed sessions.
+func (c *Client) BlockUser(ctx context.Context, user string, d time.Duration) (pftp.UserBlock, int, error) {
+	req := struct {
+		Duration int `json:"duration,omitempty"`
+	}{int(d / time.Second)}
+	var res struct {
+		pftp.UserBlock
+		Sessions int `json:"sessions"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/blocks/"+url.PathEscape(user), req, &res); err != nil {
+		return pftp.UserBlock{}, 0, err
+	}
+
+	return res.UserBlock, res.Sessions, nil
+}
+
+// LiftBlock lift block of user
+func (c *Client) LiftBlock(ctx context.Context, user string) error {
+	return c.do(ctx, http.MethodDelete, "/blocks/"+url.PathEscape(user), nil, nil)
+}
+
+// Drain start draining server and wait until sessions hit zero or grace
+// passed. drain_timeout of server is used when grace is negative. return
+// count of sessions still active.
+func (c *Client) Drain(ctx context.Context, grace time.Duration) (int, error) {
+	return c.drain(ctx, "/drain", grace)
+}
+
+// DrainOrigin skip origin (host:port) for new sessions and wait until
+// sessions connected to it hit zero or grace passed. drain_timeout of server
+// is used when grace is negative. return count of sessions still on origin.
+func (c *Client) DrainOrigin(ctx context.Context, origin string, grace time.Duration) (int, error) {
+	return c.drain(ctx, originDrainPath(origin), grace)
+}
+
+// UndrainOrigin let new sessions use origin drained by DrainOrigin again
+func (c *Client) UndrainOrigin(ctx context.Context, origin string) error {
+	return c.do(ctx, http.MethodDelete, originDrainPath(origin), nil, nil)
+}
+
+func originDrainPath(origin string) string {
+	return "/origins/" + url.PathEscape(origin) + "/drain"
+}
+
+func (c *Client) drain(ctx context.Context, path string, grace time.Duration) (int, error) {
+	if grace >= 0 {
+		path += "?grace=" + strconv.Itoa(int(grace/time.Second))
+	}
+
+	var res struct {
+		ActiveSessions int `json:"active_sessions"`
+	}
+	if err := c.do(ctx, http.MethodPost, path, nil, &res); err != nil {
+		return 0, err
+	}
+
+	return res.ActiveSessions, nil
+}
+
+// Standby return true while server is standby
+func (c *Client) Standby(ctx context.Context) (bool, error) {
+	return c.standby(ctx, http.MethodGet, "/standby")
+}
+
+// Promote make standby server active. return true when server is still
+// standby, which is possible when coordinator of server demoted it again.
+func (c *Client) Promote(ctx context.Context) (bool, error) {
+	return c.standby(ctx, http.MethodPost, "/promote")
+}
+
+// Demote make server standby. sessions logged in are kept.
+func (c *Client) Demote(ctx context.Context) (bool, error) {
+	return c.standby(ctx, http.MethodPost, "/demote")
+}
+
+func (c *Client) standby(ctx context.Context, method string, path string) (bool, error) {
+	var res struct {
+		State string `json:"state"`
+	}
+	if err := c.do(ctx, method, path, nil, &res); err != nil {
+		return false, err
+	}
+
+	return res.State == "standby", nil
+}
+
+// Ready return false while server is draining or standby
+func (c *Client) Ready(ctx context.Context) (bool, error) {
+	var res struct {
+		Ready bool `json:"ready"`
+	}
+	err := c.do(ctx, http.MethodGet, "/readyz", nil, &res)
+	var e *Error
+	if errors.As(err, &e) && e.StatusCode == http.StatusServiceUnavailable {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return res.Ready, nil
+}
@@ -0,0 +1,223 @@
+package adminclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pyama86/pftp/pftp"
+)
+
+func newTestAdmin(t *testing.T) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" && r.URL.Path != "/readyz" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(rw, `{"error":"authentication required"}`)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.Method + " " + r.URL.RequestURI() {
+		case "GET /sessions":
+			fmt.Fprint(rw, `[{"id":3,"client_addr":"127.0.0.1:50000","user":"vsuser","origin":"127.0.0.1:10021","connected_at":"2021-09-01T00:00:00Z"}]`)
+		case "DELETE /sessions/3":
+			rw.WriteHeader(http.StatusNoContent)
+		case "DELETE /sessions/4":
+			rw.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(rw, `{"error":"unknown session"}`)
+		case "GET /sessions/3/tail":
+			rw.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(rw, "data: {\"time\":\"2021-09-01T00:00:00Z\",\"direction\":\"from_client\",\"line\":\"PWD\"}\n\n")
+			fmt.Fprint(rw, "data: {\"time\":\"2021-09-01T00:00:01Z\",\"direction\":\"to_client\",\"line\":\"257 \\\"/\\\"\"}\n\n")
+			fmt.Fprint(rw, "event: end\ndata: {}\n\n")
+		case "GET /sessions/6/tail":
+			rw.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(rw, "data: {\"time\":\"2021-09-01T00:00:00Z\",\ndata: \"direction\":\"from_client\",\ndata: \"line\":\"PWD\"}\n\n")
+			fmt.Fprint(rw, "event: end\ndata: {}\n\n")
+		case "GET /sessions/5/tail":
+			rw.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(rw, "data: {\"time\":\"2021-09-01T00:00:00Z\",\"direction\":\"from_client\",\"line\":\"PWD\"}\n\n")
+		case "POST /sessions/3/capture":
+			if string(body) != `{"max_bytes":1024}` {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(rw, `{"session_id":3,"file":"/tmp/session-3.jsonl","bytes":0,"max_bytes":1024}`)
+		case "DELETE /sessions/3/capture":
+			fmt.Fprint(rw, `{"session_id":3,"file":"/tmp/session-3.jsonl","bytes":512,"max_bytes":1024}`)
+		case "POST /drain?grace=30":
+			fmt.Fprint(rw, `{"active_sessions":2}`)
+		case "POST /drain":
+			fmt.Fprint(rw, `{"active_sessions":0}`)
+		case "POST /origins/127.0.0.1:10021/drain?grace=60":
+			fmt.Fprint(rw, `{"active_sessions":1}`)
+		case "DELETE /origins/127.0.0.1:10021/drain":
+			rw.WriteHeader(http.StatusNoContent)
+		case "POST /blocks/vsuser":
+			if string(body) != `{"duration":600}` {
+				rw.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(rw, `{"error":"invalid request body"}`)
+				return
+			}
+			fmt.Fprint(rw, `{"user":"vsuser","until":"2021-09-01T00:10:00Z","sessions":2}`)
+		case "POST /notices":
+			if string(body) != `{"message":"maintenance"}` {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(rw, `{"notified":3}`)
+		case "GET /standby", "POST /demote":
+			fmt.Fprint(rw, `{"state":"standby"}`)
+		case "POST /promote":
+			fmt.Fprint(rw, `{"state":"active"}`)
+		case "GET /readyz":
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(rw, `{"ready":false}`)
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestClient(t *testing.T) {
+	s := newTestAdmin(t)
+	c := New(s.URL+"/", WithToken("s3cret"))
+	ctx := context.Background()
+
+	sessions, err := c.ListSessions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pftp.SessionInfo{{ID: 3, ClientAddr: "127.0.0.1:50000", User: "vsuser", Origin: "127.0.0.1:10021", ConnectedAt: time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)}}
+	if !reflect.DeepEqual(sessions, want) {
+		t.Errorf("ListSessions() = %v, want %v", sessions, want)
+	}
+
+	if err := c.KillSession(ctx, 3); err != nil {
+		t.Errorf("KillSession() error = %v", err)
+	}
+	err = c.KillSession(ctx, 4)
+	if !IsNotFound(err) || err.Error() != "DELETE /sessions/4: unknown session" {
+		t.Errorf("KillSession() error = %v, want unknown session", err)
+	}
+
+	if capture, err := c.StartCapture(ctx, 3, 1024); err != nil || capture.File != "/tmp/session-3.jsonl" || capture.MaxBytes != 1024 {
+		t.Errorf("StartCapture() = %+v, %v", capture, err)
+	}
+	if capture, err := c.StopCapture(ctx, 3); err != nil || capture.Bytes != 512 {
+		t.Errorf("StopCapture() = %+v, %v", capture, err)
+	}
+	if _, err := c.SessionCapture(ctx, 3); !IsNotFound(err) {
+		t.Errorf("SessionCapture() error = %v, want not found", err)
+	}
+
+	if n, err := c.Drain(ctx, 30*time.Second); err != nil || n != 2 {
+		t.Errorf("Drain() = %d, %v, want 2", n, err)
+	}
+	if n, err := c.Drain(ctx, -1); err != nil || n != 0 {
+		t.Errorf("Drain() = %d, %v, want 0", n, err)
+	}
+
+	if n, err := c.DrainOrigin(ctx, "127.0.0.1:10021", time.Minute); err != nil || n != 1 {
+		t.Errorf("DrainOrigin() = %d, %v, want 1", n, err)
+	}
+	if err := c.UndrainOrigin(ctx, "127.0.0.1:10021"); err != nil {
+		t.Errorf("UndrainOrigin() error = %v", err)
+	}
+
+	block, n, err := c.BlockUser(ctx, "vsuser", 10*time.Minute)
+	if err != nil || block.User != "vsuser" || !block.Until.Equal(time.Date(2021, 9, 1, 0, 10, 0, 0, time.UTC)) || n != 2 {
+		t.Errorf("BlockUser() = %v, %d, %v", block, n, err)
+	}
+
+	if n, err := c.Notify(ctx, "maintenance"); err != nil || n != 3 {
+		t.Errorf("Notify() = %d, %v, want 3", n, err)
+	}
+
+	if standby, err := c.Standby(ctx); err != nil || !standby {
+		t.Errorf("Standby() = %v, %v, want true", standby, err)
+	}
+	if standby, err := c.Promote(ctx); err != nil || standby {
+		t.Errorf("Promote() = %v, %v, want false", standby, err)
+	}
+	if standby, err := c.Demote(ctx); err != nil || !standby {
+		t.Errorf("Demote() = %v, %v, want true", standby, err)
+	}
+
+	if ready, err := c.Ready(ctx); err != nil || ready {
+		t.Errorf("Ready() = %v, %v, want false", ready, err)
+	}
+
+	_, err = New(s.URL).ListSessions(ctx)
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusUnauthorized || e.Message != "authentication required" {
+		t.Errorf("ListSessions() error = %v, want authentication required", err)
+	}
+}
+
+func TestTailSession(t *testing.T) {
+	s := newTestAdmin(t)
+	c := New(s.URL, WithToken("s3cret"))
+
+	tests := []struct {
+		name    string
+		id      uint64
+		want    []string
+		wantErr error
+	}{
+		{
+			name:    "ended",
+			id:      3,
+			want:    []string{"from_client PWD", `to_client 257 "/"`},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "multi_line_data",
+			id:      6,
+			want:    []string{"from_client PWD"},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "closed",
+			id:      5,
+			want:    []string{"from_client PWD"},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := c.TailSession(context.Background(), tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stream.Close()
+
+			got := []string{}
+			for {
+				l, err := stream.Next()
+				if err != nil {
+					if err != tt.wantErr {
+						t.Errorf("Next() error = %v, want %v", err, tt.wantErr)
+					}
+					break
+				}
+				got = append(got, l.Direction+" "+l.Line)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lines = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := c.TailSession(context.Background(), 4); !IsNotFound(err) {
+		t.Errorf("TailSession() error = %v, want not found", err)
+	}
+}
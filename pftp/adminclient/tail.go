@@ -0,0 +1,68 @@
+package adminclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pyama86/pftp/pftp"
+)
+
+// TailStream is control connection lines of session streamed by server
+type TailStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+// TailSession stream control connection lines of session until it ends. it
+// needs operator role. cancel ctx or Close stream to stop it.
+func (c *Client) TailSession(ctx context.Context, id uint64) (*TailStream, error) {
+	resp, err := c.send(ctx, http.MethodGet, sessionPath(id)+"/tail", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TailStream{body: resp.Body, scanner: bufio.NewScanner(resp.Body)}, nil
+}
+
+// Next wait next line of session. it returns io.EOF when session ended, and
+// io.ErrUnexpectedEOF when stream was closed before it.
+func (s *TailStream) Next() (pftp.TailLine, error) {
+	var event string
+	var data []string
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		switch {
+		case len(line) == 0:
+			// blank line ends server-sent event
+			if event == "end" {
+				return pftp.TailLine{}, io.EOF
+			}
+			if len(data) == 0 {
+				event = ""
+				continue
+			}
+			// data lines of one event are joined by newline
+			var l pftp.TailLine
+			err := json.Unmarshal([]byte(strings.Join(data, "\n")), &l)
+			return l, err
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := s.scanner.Err(); err != nil {
+		return pftp.TailLine{}, err
+	}
+
+	return pftp.TailLine{}, io.ErrUnexpectedEOF
+}
+
+// Close stop stream
+func (s *TailStream) Close() error {
+	return s.body.Close()
+}
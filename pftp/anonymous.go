@@ -0,0 +1,146 @@
+package pftp
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// AnonymousConfig is guest access by anonymous FTP (RFC 1635) of listener
+type AnonymousConfig struct {
+	Enabled bool     `toml:"enabled"`
+	Users   []string `toml:"users"`
+	// origin of anonymous sessions. empty uses routing of other users.
+	Origin         string `toml:"origin"`
+	IdleTimeout    int    `toml:"idle_timeout"`
+	MaxSessions    int32  `toml:"max_sessions"`
+	TransferRate   int64  `toml:"transfer_rate"`
+	AccountingUser string `toml:"accounting_user"`
+}
+
+// anonymousAccess count anonymous sessions of server against max_sessions
+type anonymousAccess struct {
+	config   *AnonymousConfig
+	sessions int32 // accessed atomically
+}
+
+func newAnonymousAccess(c *Config) *anonymousAccess {
+	if c.Anonymous == nil || !c.Anonymous.Enabled {
+		return nil
+	}
+
+	return &anonymousAccess{config: c.Anonymous}
+}
+
+// return true when user logs in anonymously
+func (a *anonymousAccess) isUser(user string) bool {
+	for _, u := range a.config.Users {
+		if strings.EqualFold(u, user) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// take session slot. false when max_sessions is reached.
+func (a *anonymousAccess) acquire() bool {
+	for {
+		n := atomic.LoadInt32(&a.sessions)
+		if a.config.MaxSessions > 0 && n >= a.config.MaxSessions {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&a.sessions, n, n+1) {
+			return true
+		}
+	}
+}
+
+func (a *anonymousAccess) release() {
+	atomic.AddInt32(&a.sessions, -1)
+}
+
+// start anonymous session by USER of anonymous user. it is routed to
+// anonymous origin, read-only and limited by [anonymous]. session stays
+// anonymous until it ends.
+func (c *clientHandler) startAnonymous() *result {
+	if c.anonymous == nil {
+		return nil
+	}
+
+	anonymous := c.anonymous.isUser(c.param)
+	if c.context.Anonymous {
+		if anonymous {
+			return nil
+		}
+		return &result{
+			code: 530,
+			msg:  "Cannot change user of anonymous session",
+			err:  fmt.Errorf("user %s after anonymous login", c.param),
+			log:  c.log,
+		}
+	}
+	if !anonymous {
+		return nil
+	}
+
+	if !c.anonymous.acquire() {
+		return &result{
+			code: 530,
+			msg:  c.message(msgMaxConnections),
+			err:  fmt.Errorf("exceeded anonymous session limit"),
+			log:  c.log,
+		}
+	}
+
+	config := c.anonymous.config
+	c.context.Anonymous = true
+	c.context.ReadOnly = true
+	c.context.TransferRate = config.TransferRate
+	if len(config.Origin) > 0 {
+		c.context.RemoteAddr = config.Origin
+	}
+	c.log.info("anonymous session started")
+	c.metrics.inc("pftp_anonymous_sessions_total", "Count of anonymous sessions.")
+
+	return nil
+}
+
+// release slot of anonymous session
+func (c *clientHandler) endAnonymous() {
+	if c.anonymous != nil && c.context != nil && c.context.Anonymous {
+		c.anonymous.release()
+	}
+}
+
+// return idle timeout (sec) of session. anonymous sessions have their own.
+func (c *clientHandler) idleTimeout() int {
+	if c.anonymous != nil && c.context.Anonymous {
+		return c.anonymous.config.IdleTimeout
+	}
+
+	return c.config.IdleTimeout
+}
+
+// return user of accounting. anonymous sessions are accounted to one user
+// apart from others.
+func (c *clientHandler) accountingUser() string {
+	if c.anonymous != nil && c.context.Anonymous {
+		return c.anonymous.config.AccountingUser
+	}
+
+	return c.user
+}
+
+// sleep until bytes copied from started are in transfer rate (bytes/sec)
+func (d *dataHandler) pace(started time.Time, copied int64) {
+	if d.transferRate <= 0 {
+		return
+	}
+
+	wait := time.Duration(float64(copied)/float64(d.transferRate)*float64(time.Second)) - time.Since(started)
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,70 @@
+package pftp
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_clientHandler_startAnonymous(t *testing.T) {
+	config := &Config{Anonymous: &AnonymousConfig{
+		Enabled:        true,
+		Users:          []string{"anonymous", "ftp"},
+		Origin:         "anon:21",
+		IdleTimeout:    60,
+		MaxSessions:    1,
+		TransferRate:   1024,
+		AccountingUser: "guest",
+	}}
+	access := newAnonymousAccess(config)
+	newClient := func() *clientHandler {
+		return &clientHandler{config: config, anonymous: access, context: newContext(config), log: &logger{}, user: "ftp"}
+	}
+
+	c := newClient()
+	c.param = "Anonymous"
+	if r := c.startAnonymous(); r != nil {
+		t.Fatalf("startAnonymous() = %+v", r)
+	}
+	if !c.context.Anonymous || !c.context.ReadOnly || c.context.RemoteAddr != "anon:21" || c.context.TransferRate != 1024 {
+		t.Errorf("context = %+v", c.context)
+	}
+	if c.idleTimeout() != 60 || c.accountingUser() != "guest" {
+		t.Errorf("idleTimeout() = %d, accountingUser() = %s", c.idleTimeout(), c.accountingUser())
+	}
+	c.param = "bob"
+	if r := c.startAnonymous(); r == nil || r.code != 530 {
+		t.Errorf("startAnonymous() of other user after anonymous = %+v", r)
+	}
+
+	other := newClient()
+	other.param = "ftp"
+	if r := other.startAnonymous(); r == nil || r.code != 530 {
+		t.Errorf("startAnonymous() over max_sessions = %+v", r)
+	}
+	c.endAnonymous()
+	if r := other.startAnonymous(); r != nil {
+		t.Errorf("startAnonymous() after release = %+v", r)
+	}
+
+	user := newClient()
+	user.param = "bob"
+	if r := user.startAnonymous(); r != nil || user.context.Anonymous || user.context.ReadOnly {
+		t.Errorf("startAnonymous() of user = %+v, context = %+v", r, user.context)
+	}
+}
+
+func Test_dataHandler_pace(t *testing.T) {
+	d := &dataHandler{transferRate: 1000}
+	started := time.Now()
+	d.pace(started, 100)
+	if elapsed := time.Since(started); elapsed < 90*time.Millisecond {
+		t.Errorf("pace() of 100 bytes at 1000 bytes/sec waited %s", elapsed)
+	}
+
+	d.transferRate = 0
+	started = time.Now()
+	d.pace(started, 1<<30)
+	if elapsed := time.Since(started); elapsed > 10*time.Millisecond {
+		t.Errorf("pace() without rate waited %s", elapsed)
+	}
+}
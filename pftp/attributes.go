@@ -0,0 +1,158 @@
+package pftp
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// policies of chmod_policy and timestamp_policy
+const (
+	attributeAllow = "allow"
+	attributeDeny  = "deny"
+	attributeClamp = "clamp"
+)
+
+// layout of MFMT and SITE UTIME timestamps (UTC)
+const attributeTimeLayout = "20060102150405"
+
+// parse octal mode like "0644"
+func parseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 07777 {
+		return 0, fmt.Errorf("mode must be octal up to 7777: %s", s)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// return indexes of timestamps in words of MFMT and SITE UTIME parameter.
+// ex) "MFMT 20210901000000 file" -> [0],
+// "SITE UTIME file 20210901000000 20210901000000 20210901000000 UTC" -> [2 3 4],
+// "SITE UTIME 20210901000000 file" -> [1]
+func timestampArguments(command string, words []string) []int {
+	switch {
+	case command == "MFMT":
+		return []int{0}
+	case command != "SITE" || len(words) < 2 || !strings.EqualFold(words[0], "UTIME"):
+		return nil
+	case len(words) >= 6 && strings.EqualFold(words[len(words)-1], "UTC"):
+		// path atime mtime ctime UTC
+		n := len(words)
+		return []int{n - 4, n - 3, n - 2}
+	case isAttributeTime(words[1]):
+		return []int{1}
+	}
+
+	return []int{len(words) - 1}
+}
+
+// return byte offsets of words as strings.Fields, so words can be replaced
+// keeping other parts (ex. spaces in file name) of parameter.
+func fieldOffsets(s string) [][2]int {
+	offsets := [][2]int{}
+	start := -1
+	for i, r := range s {
+		switch {
+		case unicode.IsSpace(r) && start >= 0:
+			offsets = append(offsets, [2]int{start, i})
+			start = -1
+		case !unicode.IsSpace(r) && start < 0:
+			start = i
+		}
+	}
+	if start >= 0 {
+		offsets = append(offsets, [2]int{start, len(s)})
+	}
+
+	return offsets
+}
+
+func isAttributeTime(s string) bool {
+	_, err := time.Parse(attributeTimeLayout, strings.SplitN(s, ".", 2)[0])
+	return err == nil
+}
+
+// apply Context.ChmodPolicy to SITE CHMOD and Context.TimestampPolicy to MFMT
+// and SITE UTIME. clamp rewrites command, so mode is masked by ChmodMaxMode
+// and timestamps in future are set to now.
+// return reply when command is rejected by proxy.
+func (c *clientHandler) enforceAttributePolicy() *result {
+	words := strings.Fields(c.param)
+	chmod := c.command == "SITE" && len(words) > 0 && strings.EqualFold(words[0], "CHMOD")
+	timestamps := timestampArguments(c.command, words)
+
+	policy := c.context.TimestampPolicy
+	if chmod {
+		policy = c.context.ChmodPolicy
+	} else if len(timestamps) == 0 {
+		return nil
+	}
+
+	denied := &result{
+		code: 550,
+		msg:  c.message(msgAttributeDenied),
+	}
+	label := c.command
+	if c.command == "SITE" {
+		label = "SITE " + strings.ToUpper(words[0])
+	}
+
+	switch policy {
+	case attributeDeny:
+		c.metrics.inc("pftp_attribute_commands_total", "SITE CHMOD, MFMT and SITE UTIME by policy action.", "command", label, "action", attributeDeny)
+		return denied
+	case attributeClamp:
+	default:
+		return nil
+	}
+
+	// only mode and timestamps are replaced in original parameter
+	replaced := map[int]string{}
+	if chmod {
+		if len(words) < 3 {
+			return nil
+		}
+		mode, err := parseFileMode(words[1])
+		if err != nil {
+			// symbolic modes can not be clamped
+			return denied
+		}
+		if clamped := fmt.Sprintf("%03o", mode&c.context.ChmodMaxMode); clamped != words[1] {
+			replaced[1] = clamped
+		}
+	} else {
+		now := time.Now().UTC()
+		for _, i := range timestamps {
+			if i >= len(words) {
+				return nil
+			}
+			t, err := time.Parse(attributeTimeLayout, strings.SplitN(words[i], ".", 2)[0])
+			if err == nil && t.After(now) {
+				replaced[i] = now.Format(attributeTimeLayout)
+			}
+		}
+	}
+
+	if len(replaced) > 0 {
+		param := ""
+		last := 0
+		for i, o := range fieldOffsets(c.param) {
+			if w, ok := replaced[i]; ok {
+				param += c.param[last:o[0]] + w
+				last = o[1]
+			}
+		}
+		param += c.param[last:]
+
+		c.log.info("%s is rewritten by policy: %s -> %s", label, c.param, param)
+		c.metrics.inc("pftp_attribute_commands_total", "SITE CHMOD, MFMT and SITE UTIME by policy action.", "command", label, "action", attributeClamp)
+		c.param = param
+		c.line = c.command + " " + param + "\r\n"
+	}
+
+	return nil
+}
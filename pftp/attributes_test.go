@@ -0,0 +1,110 @@
+package pftp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_clientHandler_enforceAttributePolicy(t *testing.T) {
+	future := time.Now().UTC().Add(48 * time.Hour).Format(attributeTimeLayout)
+	now := time.Now().UTC().Format(attributeTimeLayout)[:10] // compare to hour
+
+	tests := []struct {
+		name       string
+		chmod      string
+		timestamp  string
+		line       string
+		wantCode   int
+		wantLine   string
+		wantPrefix bool // wantLine is prefix of line, for timestamps of now
+		wantSuffix string
+	}{
+		{name: "chmod_allow", chmod: attributeAllow, line: "SITE CHMOD 777 f", wantLine: "SITE CHMOD 777 f"},
+		{name: "chmod_default", line: "SITE CHMOD 777 f", wantLine: "SITE CHMOD 777 f"},
+		{name: "chmod_deny", chmod: attributeDeny, line: "SITE CHMOD 600 f", wantCode: 550},
+		{name: "chmod_clamp", chmod: attributeClamp, line: "SITE CHMOD 777 my file", wantLine: "SITE CHMOD 644 my file\r\n"},
+		{name: "chmod_clamp_spaces", chmod: attributeClamp, line: "SITE CHMOD 777 my  file ", wantLine: "SITE CHMOD 644 my  file \r\n"},
+		{name: "chmod_clamp_setuid", chmod: attributeClamp, line: "site chmod 4755 f", wantLine: "SITE chmod 644 f\r\n"},
+		{name: "chmod_clamp_within", chmod: attributeClamp, line: "SITE CHMOD 600 f", wantLine: "SITE CHMOD 600 f"},
+		{name: "chmod_clamp_symbolic", chmod: attributeClamp, line: "SITE CHMOD u+x f", wantCode: 550},
+		{name: "chmod_policy_other_site", chmod: attributeDeny, line: "SITE IDLE 60", wantLine: "SITE IDLE 60"},
+		{name: "timestamp_deny_mfmt", timestamp: attributeDeny, line: "MFMT 20210901000000 f", wantCode: 550},
+		{name: "timestamp_deny_utime", timestamp: attributeDeny, line: "SITE UTIME 20210901000000 f", wantCode: 550},
+		{name: "timestamp_deny_chmod_allowed", timestamp: attributeDeny, line: "SITE CHMOD 644 f", wantLine: "SITE CHMOD 644 f"},
+		{name: "timestamp_clamp_past", timestamp: attributeClamp, line: "MFMT 20210901000000 f", wantLine: "MFMT 20210901000000 f"},
+		{name: "timestamp_clamp_future", timestamp: attributeClamp, line: "MFMT " + future + " f", wantLine: "MFMT " + now, wantPrefix: true},
+		{name: "timestamp_clamp_spaces", timestamp: attributeClamp, line: "MFMT " + future + " my  file", wantLine: "MFMT " + now, wantPrefix: true, wantSuffix: " my  file\r\n"},
+		{name: "timestamp_clamp_utime_path_last", timestamp: attributeClamp, line: "SITE UTIME " + future + " f", wantLine: "SITE UTIME " + now, wantPrefix: true},
+		{name: "timestamp_clamp_utime_path_first", timestamp: attributeClamp, line: "SITE UTIME f " + future, wantLine: "SITE UTIME f " + now, wantPrefix: true},
+		{
+			name:       "timestamp_clamp_utime_five",
+			timestamp:  attributeClamp,
+			line:       "SITE UTIME f 20210901000000 " + future + " 20210901000000 UTC",
+			wantLine:   "SITE UTIME f 20210901000000 " + now,
+			wantPrefix: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{
+				config:  &Config{},
+				context: &Context{ChmodPolicy: tt.chmod, ChmodMaxMode: 0644, TimestampPolicy: tt.timestamp},
+				log:     &logger{},
+			}
+			c.parseLine(tt.line)
+
+			r := c.enforceAttributePolicy()
+			if tt.wantCode > 0 {
+				if r == nil || r.code != tt.wantCode {
+					t.Fatalf("enforceAttributePolicy() = %+v, want code %d", r, tt.wantCode)
+				}
+				return
+			}
+			if r != nil {
+				t.Fatalf("enforceAttributePolicy() = %+v, want nil", r)
+			}
+			if tt.wantPrefix {
+				if len(c.line) < len(tt.wantLine) || c.line[:len(tt.wantLine)] != tt.wantLine {
+					t.Errorf("line = %q, want prefix %q", c.line, tt.wantLine)
+				}
+				if !strings.HasSuffix(c.line, tt.wantSuffix) {
+					t.Errorf("line = %q, want suffix %q", c.line, tt.wantSuffix)
+				}
+				return
+			}
+			if c.line != tt.wantLine {
+				t.Errorf("line = %q, want %q", c.line, tt.wantLine)
+			}
+		})
+	}
+}
+
+func Test_timestampArguments(t *testing.T) {
+	tests := []struct {
+		name  string
+		param string
+		want  []int
+	}{
+		{name: "utime_time_first", param: "UTIME 20210901000000 f", want: []int{1}},
+		{name: "utime_path_first", param: "UTIME f 20210901000000", want: []int{2}},
+		{name: "utime_five", param: "UTIME f 20210901000000 20210901000000 20210901000000 UTC", want: []int{2, 3, 4}},
+		{name: "utime_only", param: "UTIME", want: nil},
+		{name: "chmod", param: "CHMOD 644 f", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{}
+			c.parseLine("SITE " + tt.param)
+			got := timestampArguments("SITE", strings.Fields(c.param))
+			if len(got) != len(tt.want) {
+				t.Fatalf("timestampArguments() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("timestampArguments() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
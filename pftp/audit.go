@@ -0,0 +1,207 @@
+package pftp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// request body of audited action is limited to this size. larger body is
+// refused with 413
+const auditMaxBody = 64 * 1024
+
+// phases of audit records. start is written before action runs, so long
+// actions (tail, drain) are recorded even when process dies during them.
+const (
+	auditPhaseStart = "start"
+	auditPhaseDone  = "done"
+)
+
+var errAuditLogClosed = errors.New("audit log is closed")
+
+// auditLog append admin actions to admin_audit_log as JSON lines.
+// nil auditLog writes nothing.
+type auditLog struct {
+	mutex  sync.Mutex
+	file   *os.File
+	closed bool
+}
+
+func newAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditLog{file: f}, nil
+}
+
+// write record and sync it, so record is kept when process dies after action
+func (a *auditLog) write(r *AdminActionEvent) error {
+	if a == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.closed {
+		return errAuditLogClosed
+	}
+	if _, err := a.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	return a.file.Sync()
+}
+
+// close file. records of admin requests still running are refused after it.
+func (a *auditLog) close() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+
+	return a.file.Close()
+}
+
+// record admin action to audit log, logger and event bus
+func (server *FtpServer) recordAdminAction(r *AdminActionEvent) {
+	// logger is nil when unit test
+	if server.logger != nil {
+		server.logger.Infof("admin action %s by %s: status=%d params=%v", r.Action, r.Actor, r.Status, r.Params)
+	}
+	if err := server.auditLog.write(r); err != nil && server.logger != nil {
+		server.logger.Error("cannot write audit log: ", err.Error())
+	}
+	server.events.emit(r)
+}
+
+// record signal which stopped or drained server
+func (server *FtpServer) recordSignal(name string, action string) {
+	server.recordAdminAction(&AdminActionEvent{
+		Time:   time.Now(),
+		Actor:  "signal:" + name,
+		Action: action,
+	})
+}
+
+// return identity of admin API request. tokens are secret, so their hash
+// is recorded.
+// ex) "cert:oncall.example.com", "token:3f2a9c01b7de", "anonymous"
+func (server *FtpServer) adminActor(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		return "cert:" + r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != r.Header.Get("Authorization") && len(token) > 0 {
+		sum := sha256.Sum256([]byte(token))
+		return "token:" + hex.EncodeToString(sum[:])[:12]
+	}
+
+	return "anonymous"
+}
+
+// statusRecorder keep status code of admin API response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// streamed response (session tail) is flushed through recorder
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// return handler recording action with path parameters, query and body of
+// request. refused requests are recorded as well.
+func (server *FtpServer) audited(action string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		record := &AdminActionEvent{
+			Time:       time.Now(),
+			Actor:      server.adminActor(r),
+			RemoteAddr: r.RemoteAddr,
+			Action:     action,
+			Params:     map[string]string{},
+		}
+		if server.adminAuthEnabled() {
+			record.Role = server.adminRole(r)
+		}
+		for _, p := range ps {
+			record.Params[p.Key] = p.Value
+		}
+		for k, v := range r.URL.Query() {
+			record.Params[k] = strings.Join(v, ",")
+		}
+		// body over auditMaxBody is refused, so handler and audit log never
+		// get part of it
+		refused := 0
+		if r.Body != nil && r.ContentLength != 0 {
+			b, err := ioutil.ReadAll(io.LimitReader(r.Body, auditMaxBody+1))
+			switch {
+			case err != nil:
+				refused = http.StatusBadRequest
+			case len(b) > auditMaxBody:
+				refused = http.StatusRequestEntityTooLarge
+			case len(b) > 0:
+				record.Params["body"] = string(b)
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		}
+
+		// start record is only written to audit log
+		start := *record
+		start.Phase = auditPhaseStart
+		if err := server.auditLog.write(&start); err != nil && server.logger != nil {
+			server.logger.Error("cannot write audit log: ", err.Error())
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		if refused != 0 {
+			writeJSON(rec, refused, adminError{Error: strings.ToLower(http.StatusText(refused))})
+		} else {
+			h(rec, r, ps)
+		}
+		record.Phase = auditPhaseDone
+		record.Status = rec.status
+		if record.Status == 0 {
+			record.Status = http.StatusOK
+		}
+		server.recordAdminAction(record)
+	}
+}
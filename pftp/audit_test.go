@@ -0,0 +1,159 @@
+package pftp
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_auditLog_close(t *testing.T) {
+	audit, err := newAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := audit.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// request still running after close does not write to closed file
+	if err := audit.write(&AdminActionEvent{Action: "drain"}); err != errAuditLogClosed {
+		t.Errorf("write() after close = %v, want %v", err, errAuditLogClosed)
+	}
+	if err := audit.close(); err != nil {
+		t.Errorf("close() again = %v", err)
+	}
+}
+
+func Test_FtpServer_audited(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       string
+		wantAction string
+		wantActor  string
+		wantRole   string
+		wantParams map[string]string
+		wantStatus int
+	}{
+		{
+			name:       "kill_session",
+			method:     "DELETE",
+			path:       "/sessions/9",
+			token:      "ops",
+			wantAction: "kill_session",
+			wantActor:  "token:",
+			wantRole:   "operator",
+			wantParams: map[string]string{"id": "9"},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "notify",
+			method:     "POST",
+			path:       "/notices",
+			token:      "ops",
+			body:       `{"message":"maintenance"}`,
+			wantAction: "notify",
+			wantActor:  "token:",
+			wantRole:   "operator",
+			wantParams: map[string]string{"body": `{"message":"maintenance"}`},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "body_too_large",
+			method:     "POST",
+			path:       "/notices",
+			token:      "ops",
+			body:       `{"message":"` + strings.Repeat("a", auditMaxBody) + `"}`,
+			wantAction: "notify",
+			wantActor:  "token:",
+			wantRole:   "operator",
+			wantParams: map[string]string{"body": ""},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "refused",
+			method:     "POST",
+			path:       "/drain?grace=0",
+			token:      "watch",
+			wantAction: "drain",
+			wantActor:  "token:",
+			wantRole:   "observer",
+			wantParams: map[string]string{"grace": "0"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "anonymous",
+			method:     "DELETE",
+			path:       "/bans/192.0.2.1",
+			wantAction: "clear_ban",
+			wantActor:  "anonymous",
+			wantParams: map[string]string{"ip": "192.0.2.1"},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			audit, err := newAuditLog(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer audit.close()
+			server := &FtpServer{
+				config:   &Config{AdminTokens: map[string]string{"watch": "observer", "ops": "operator"}},
+				clients:  newSessionRegistry(),
+				events:   newEventBus(),
+				auditLog: audit,
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if len(tt.token) > 0 {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			server.adminHandler().ServeHTTP(httptest.NewRecorder(), req)
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			s := bufio.NewScanner(f)
+			records := []AdminActionEvent{}
+			for s.Scan() {
+				var r AdminActionEvent
+				if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+					t.Fatal(err)
+				}
+				records = append(records, r)
+			}
+			if len(records) != 2 {
+				t.Fatalf("audit records = %+v, want start and done", records)
+			}
+			if start := records[0]; start.Phase != auditPhaseStart || start.Action != tt.wantAction || start.Status != 0 {
+				t.Errorf("start record = %+v", start)
+			}
+			got := records[1]
+			if got.Phase != auditPhaseDone || got.Action != tt.wantAction || !strings.HasPrefix(got.Actor, tt.wantActor) || got.Role != tt.wantRole || got.Status != tt.wantStatus {
+				t.Errorf("record = %+v, want %s by %s (%s) status %d", got, tt.wantAction, tt.wantActor, tt.wantRole, tt.wantStatus)
+			}
+			if strings.Contains(got.Actor, tt.token) && len(tt.token) > 0 {
+				t.Errorf("actor %s has token", got.Actor)
+			}
+			for k, v := range tt.wantParams {
+				if got.Params[k] != v {
+					t.Errorf("params[%s] = %q, want %q", k, got.Params[k], v)
+				}
+			}
+			if e := (<-server.events.ch).(*AdminActionEvent); e.Action != tt.wantAction || e.Phase != auditPhaseDone {
+				t.Errorf("event = %+v, want %s done", e, tt.wantAction)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package pftp
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// max shift of ban duration for repeated bans (duration * 64)
+const maxBanEscalation = 6
+
+// IPRecord is login failure history and ban state of client IP
+type IPRecord struct {
+	// Failures is count of login failures in current window
+	Failures    int       `json:"failures"`
+	WindowStart time.Time `json:"window_start"`
+	// TotalFailures and Bans are reputation of IP. they are not reset, and
+	// record is removed when IP is not seen for 64 times of ban_duration.
+	TotalFailures int       `json:"total_failures"`
+	Bans          int       `json:"bans"`
+	BannedUntil   time.Time `json:"banned_until"`
+	LastSeen      time.Time `json:"last_seen"`
+}
+
+// BanStore persist records of banned client IPs across restarts. record is
+// saved when IP is banned or its ban is lifted, and deleted when it expires.
+type BanStore interface {
+	Load() (map[string]*IPRecord, error)
+	Save(ip string, r *IPRecord) error
+	Delete(ip string) error
+}
+
+// WithBanStore set store of login failure records.
+// It is used instead of ban_db.
+func WithBanStore(s BanStore) Option {
+	return func(server *FtpServer) {
+		server.banStore = s
+	}
+}
+
+// banGuard ban client IPs which failed login too many times.
+// it is shared by all client sessions of server.
+type banGuard struct {
+	mutex       sync.Mutex
+	records     map[string]*IPRecord
+	store       BanStore
+	maxFailures int
+	window      time.Duration
+	duration    time.Duration
+	retention   time.Duration // records not seen for this are removed
+	nextPrune   time.Time
+	log         func(error)
+	now         func() time.Time
+}
+
+// make guard and load records from store. store can be nil.
+func newBanGuard(c *Config, store BanStore, log func(error)) (*banGuard, error) {
+	if c.MaxLoginFailures <= 0 {
+		return nil, nil
+	}
+
+	g := &banGuard{
+		records:     map[string]*IPRecord{},
+		store:       store,
+		maxFailures: c.MaxLoginFailures,
+		window:      time.Duration(c.LoginFailureWindow) * time.Second,
+		duration:    time.Duration(c.BanDuration) * time.Second,
+		log:         log,
+		now:         time.Now,
+	}
+	g.retention = g.duration << maxBanEscalation
+	if g.retention < g.window {
+		g.retention = g.window
+	}
+
+	if store != nil {
+		records, err := store.Load()
+		if err != nil {
+			return nil, err
+		}
+		g.records = records
+	}
+
+	return g, nil
+}
+
+// return host part of address
+func clientIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// return end of ban when ip is banned
+func (g *banGuard) banned(ip string) (time.Time, bool) {
+	if g == nil {
+		return time.Time{}, false
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	r, ok := g.records[ip]
+	if !ok || !g.now().Before(r.BannedUntil) {
+		return time.Time{}, false
+	}
+
+	return r.BannedUntil, true
+}
+
+// record result of login from ip. return true when ip is banned by this failure.
+// only bans are persisted, and store is written outside of lock.
+func (g *banGuard) loginResult(ip string, success bool) bool {
+	if g == nil {
+		return false
+	}
+
+	g.mutex.Lock()
+	now := g.now()
+	expired := g.prune(now)
+	r, ok := g.records[ip]
+	if !ok {
+		if success {
+			g.mutex.Unlock()
+			g.remove(expired)
+			return false
+		}
+		r = &IPRecord{}
+		g.records[ip] = r
+	}
+	r.LastSeen = now
+
+	banned := false
+	if success {
+		r.Failures = 0
+	} else {
+		if now.Sub(r.WindowStart) > g.window {
+			r.Failures = 0
+			r.WindowStart = now
+		}
+		r.Failures++
+		r.TotalFailures++
+
+		// repeated ban of same IP lasts longer
+		if r.Failures >= g.maxFailures {
+			shift := r.Bans
+			if shift > maxBanEscalation {
+				shift = maxBanEscalation
+			}
+			r.Bans++
+			r.BannedUntil = now.Add(g.duration << uint(shift))
+			r.Failures = 0
+			banned = true
+		}
+	}
+	saved := *r
+	g.mutex.Unlock()
+
+	if banned {
+		g.save(ip, &saved)
+	}
+	g.remove(expired)
+
+	return banned
+}
+
+// remove records not seen for retention. it is called with mutex held at
+// most once in login_failure_window, and return removed IPs.
+func (g *banGuard) prune(now time.Time) []string {
+	if now.Before(g.nextPrune) {
+		return nil
+	}
+	g.nextPrune = now.Add(g.window)
+
+	var expired []string
+	for ip, r := range g.records {
+		last := r.LastSeen
+		if r.BannedUntil.After(last) {
+			last = r.BannedUntil
+		}
+		if now.Sub(last) > g.retention {
+			delete(g.records, ip)
+			expired = append(expired, ip)
+		}
+	}
+
+	return expired
+}
+
+func (g *banGuard) save(ip string, r *IPRecord) {
+	if g.store == nil {
+		return
+	}
+	if err := g.store.Save(ip, r); err != nil {
+		g.log(err)
+	}
+}
+
+func (g *banGuard) remove(ips []string) {
+	if g.store == nil {
+		return
+	}
+	for _, ip := range ips {
+		if err := g.store.Delete(ip); err != nil {
+			g.log(err)
+		}
+	}
+}
+
+// return copy of records
+func (g *banGuard) list() map[string]IPRecord {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	records := map[string]IPRecord{}
+	for ip, r := range g.records {
+		records[ip] = *r
+	}
+
+	return records
+}
+
+// lift ban of ip. reputation is kept. return false when ip is not known.
+func (g *banGuard) clear(ip string) bool {
+	g.mutex.Lock()
+	r, ok := g.records[ip]
+	if !ok {
+		g.mutex.Unlock()
+		return false
+	}
+	r.Failures = 0
+	r.BannedUntil = time.Time{}
+	saved := *r
+	g.mutex.Unlock()
+
+	g.save(ip, &saved)
+
+	return true
+}
+
+var banBucket = []byte("bans")
+
+// boltBanStore keep records in BoltDB file
+type boltBanStore struct {
+	db *bolt.DB
+}
+
+func newBoltBanStore(path string) (*boltBanStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(banBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltBanStore{db: db}, nil
+}
+
+// Load all records
+func (s *boltBanStore) Load() (map[string]*IPRecord, error) {
+	records := map[string]*IPRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(banBucket).ForEach(func(k, v []byte) error {
+			r := &IPRecord{}
+			if err := json.Unmarshal(v, r); err != nil {
+				return err
+			}
+			records[string(k)] = r
+			return nil
+		})
+	})
+
+	return records, err
+}
+
+// Save record of ip
+func (s *boltBanStore) Save(ip string, r *IPRecord) error {
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(banBucket).Put([]byte(ip), v)
+	})
+}
+
+// Delete record of ip
+func (s *boltBanStore) Delete(ip string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(banBucket).Delete([]byte(ip))
+	})
+}
+
+// Close database
+func (s *boltBanStore) Close() error {
+	return s.db.Close()
+}
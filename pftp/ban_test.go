@@ -0,0 +1,157 @@
+package pftp
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+func Test_banGuard_loginResult(t *testing.T) {
+	now := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	g, _ := newBanGuard(&Config{MaxLoginFailures: 3, LoginFailureWindow: 60, BanDuration: 600}, nil, nil)
+	g.now = func() time.Time { return now }
+
+	// failures out of window are not counted
+	g.loginResult("192.0.2.1", false)
+	now = now.Add(2 * time.Minute)
+	g.loginResult("192.0.2.1", false)
+	if g.loginResult("192.0.2.1", false) {
+		t.Fatal("banGuard.loginResult() banned by failures out of window")
+	}
+
+	// success resets failures
+	g.loginResult("192.0.2.1", true)
+	g.loginResult("192.0.2.1", false)
+	g.loginResult("192.0.2.1", false)
+	if _, banned := g.banned("192.0.2.1"); banned {
+		t.Fatal("banGuard.banned() = true after success")
+	}
+
+	if !g.loginResult("192.0.2.1", false) {
+		t.Fatal("banGuard.loginResult() = false, want ban")
+	}
+	if until, banned := g.banned("192.0.2.1"); !banned || !until.Equal(now.Add(10*time.Minute)) {
+		t.Errorf("banGuard.banned() = %s, %v", until, banned)
+	}
+	if _, banned := g.banned("192.0.2.2"); banned {
+		t.Errorf("banGuard.banned() = true for other IP")
+	}
+
+	// second ban lasts longer
+	now = now.Add(11 * time.Minute)
+	if _, banned := g.banned("192.0.2.1"); banned {
+		t.Fatal("banGuard.banned() = true after ban duration")
+	}
+	for i := 0; i < 3; i++ {
+		g.loginResult("192.0.2.1", false)
+	}
+	if until, _ := g.banned("192.0.2.1"); !until.Equal(now.Add(20 * time.Minute)) {
+		t.Errorf("second ban until %s, want %s", until, now.Add(20*time.Minute))
+	}
+
+	// clear keeps reputation
+	if !g.clear("192.0.2.1") {
+		t.Fatal("banGuard.clear() = false")
+	}
+	if _, banned := g.banned("192.0.2.1"); banned {
+		t.Errorf("banGuard.banned() = true after clear")
+	}
+	if r := g.list()["192.0.2.1"]; r.Bans != 2 || r.TotalFailures != 9 {
+		t.Errorf("reputation after clear = %+v", r)
+	}
+}
+
+func Test_boltBanStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.db")
+	c := &Config{MaxLoginFailures: 1, LoginFailureWindow: 60, BanDuration: 600}
+
+	store, err := newBoltBanStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := newBanGuard(c, store, func(err error) { t.Error(err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.loginResult("192.0.2.1", false)
+	store.Close()
+
+	// ban survives restart
+	store, err = newBoltBanStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	g, err = newBanGuard(c, store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, banned := g.banned("192.0.2.1"); !banned {
+		t.Errorf("banGuard.banned() = false after reload")
+	}
+}
+
+func Test_proxyServer_processOriginReply_loginResult(t *testing.T) {
+	results := []bool{}
+	s := &proxyServer{
+		config:         &Config{},
+		log:            &logger{},
+		inDataTransfer: abool.New(),
+		loginResult:    func(success bool) { results = append(results, success) },
+	}
+
+	s.pushCommand("USER")
+	s.processOriginReply("331 Please specify the password.\r\n")
+	s.pushCommand("PASS")
+	s.processOriginReply("530 Login incorrect.\r\n")
+	s.pushCommand("PASS")
+	s.processOriginReply("230 Login successful.\r\n")
+
+	if len(results) != 2 || results[0] || !results[1] {
+		t.Errorf("login results = %v, want [false true]", results)
+	}
+}
+
+type memoryBanStore struct {
+	records map[string]IPRecord
+}
+
+func (s *memoryBanStore) Load() (map[string]*IPRecord, error) { return map[string]*IPRecord{}, nil }
+func (s *memoryBanStore) Save(ip string, r *IPRecord) error {
+	s.records[ip] = *r
+	return nil
+}
+func (s *memoryBanStore) Delete(ip string) error {
+	delete(s.records, ip)
+	return nil
+}
+
+func Test_banGuard_prune(t *testing.T) {
+	now := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	store := &memoryBanStore{records: map[string]IPRecord{}}
+	g, _ := newBanGuard(&Config{MaxLoginFailures: 2, LoginFailureWindow: 60, BanDuration: 60}, store, nil)
+	g.now = func() time.Time { return now }
+
+	// failures without ban are not persisted
+	g.loginResult("192.0.2.1", false)
+	g.loginResult("192.0.2.2", false)
+	if len(store.records) != 0 {
+		t.Fatalf("store = %v, want no records before ban", store.records)
+	}
+	g.loginResult("192.0.2.2", false)
+	if _, ok := store.records["192.0.2.2"]; !ok {
+		t.Fatalf("ban is not persisted")
+	}
+
+	// records are removed after 64 times of ban duration
+	now = now.Add(66 * time.Minute)
+	g.loginResult("192.0.2.3", true)
+	if records := g.list(); len(records) != 0 {
+		t.Errorf("records = %v, want expired", records)
+	}
+	if len(store.records) != 0 {
+		t.Errorf("store = %v, want expired", store.records)
+	}
+}
@@ -0,0 +1,88 @@
+package pftp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// return true when ip is in allowlist of IPs and CIDRs
+func inAllowlist(ip net.IP, allowlist []string) bool {
+	for _, a := range allowlist {
+		if _, ipnet, err := net.ParseCIDR(a); err == nil {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		} else if allowed := net.ParseIP(a); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validatePortAllowlist(allowlist []string) error {
+	for _, a := range allowlist {
+		if _, _, err := net.ParseCIDR(a); err != nil && net.ParseIP(a) == nil {
+			return fmt.Errorf("configuration error: port allowlist %s is wrong", a)
+		}
+	}
+
+	return nil
+}
+
+// reject PORT and EPRT whose address is not client's own IP (FTP bounce attack).
+// return nil when command is not PORT or EPRT. address which cannot be parsed
+// is rejected because origin may parse it differently.
+func (c *clientHandler) checkPortTarget() *result {
+	var target string
+	var err error
+	switch c.command {
+	case "PORT":
+		target, _, err = parseLineToAddr(strings.TrimSpace(c.param))
+	case "EPRT":
+		target, _, err = parseEPRTtoAddr(strings.TrimSpace(c.param))
+	default:
+		return nil
+	}
+	if err != nil {
+		return &result{
+			code: 501,
+			msg:  "Syntax error in parameters or arguments",
+		}
+	}
+
+	ip := net.ParseIP(target)
+	if ip == nil || ip.Equal(net.ParseIP(clientIP(c.srcIP))) || inAllowlist(ip, c.config.PortAllowlist) {
+		return nil
+	}
+
+	// proxy connects to client IP instead of private address
+	if c.config.DataChanProxy && !isPublicIP(ip) {
+		return nil
+	}
+
+	// address of other server is FXP. it is bounce attack unless it is allowed
+	if c.fxpAllowed(ip) {
+		c.reportFXP(fxpPortTarget, c.command, target, fxpAllowed)
+		return nil
+	}
+
+	c.log.info("%s to %s is rejected. it is not client IP", c.command, target)
+	c.events.emit(&SecurityEvent{
+		Time:       time.Now(),
+		SessionID:  c.id,
+		ClientAddr: c.srcIP,
+		User:       c.log.user,
+		Command:    c.command,
+		Target:     target,
+		Reason:     "port_bounce",
+	})
+	c.reportFXP(fxpPortTarget, c.command, target, fxpBlocked)
+
+	return &result{
+		code: 500,
+		msg:  fmt.Sprintf("Illegal %s command", c.command),
+	}
+}
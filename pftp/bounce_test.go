@@ -0,0 +1,68 @@
+package pftp
+
+import (
+	"testing"
+)
+
+func Test_clientHandler_checkPortTarget(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		allowlist []string
+		dataProxy bool
+		wantCode  int
+	}{
+		{name: "port_client_ip", line: "PORT 203,0,113,7,4,1"},
+		{name: "port_other_ip", line: "PORT 198,51,100,20,0,25", wantCode: 500},
+		{name: "port_private_ip", line: "PORT 10,0,0,5,4,1", wantCode: 500},
+		{name: "port_private_ip_data_proxy", line: "PORT 10,0,0,5,4,1", dataProxy: true},
+		{name: "port_public_ip_data_proxy", line: "PORT 198,51,100,20,0,25", dataProxy: true, wantCode: 500},
+		{name: "eprt_client_ip", line: "EPRT |1|203.0.113.7|1025|"},
+		{name: "eprt_other_ip", line: "EPRT |1|198.51.100.20|25|", wantCode: 500},
+		{name: "eprt_ipv6", line: "EPRT |2|2001:db8::1|25|", wantCode: 500},
+		{name: "allowlist_ip", line: "PORT 198,51,100,20,0,25", allowlist: []string{"198.51.100.20"}},
+		{name: "allowlist_cidr", line: "EPRT |1|198.51.100.20|25|", allowlist: []string{"198.51.100.0/24"}},
+		{name: "allowlist_other", line: "PORT 198,51,100,20,0,25", allowlist: []string{"192.0.2.0/24"}, wantCode: 500},
+		{name: "invalid", line: "PORT 1,2,3", wantCode: 501},
+		{name: "leading_zeros", line: "PORT 010,000,000,001,0,25", wantCode: 501},
+		{name: "embedded_space", line: "PORT 198,51,100, 20,0,25", wantCode: 501},
+		{name: "eprt_invalid", line: "EPRT |1|198.51.100.20|x|", wantCode: 501},
+		{name: "pasv", line: "PASV"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := newEventBus()
+			c := &clientHandler{
+				config: &Config{PortAllowlist: tt.allowlist, DataChanProxy: tt.dataProxy},
+				srcIP:  "203.0.113.7:50000",
+				log:    &logger{},
+				events: events,
+			}
+			c.parseLine(tt.line)
+
+			r := c.checkPortTarget()
+			if tt.wantCode == 0 {
+				if r != nil {
+					t.Fatalf("checkPortTarget() = %+v, want nil", r)
+				}
+				return
+			}
+			if r == nil || r.code != tt.wantCode {
+				t.Fatalf("checkPortTarget() = %+v, want code %d", r, tt.wantCode)
+			}
+			if tt.wantCode == 501 {
+				return
+			}
+
+			select {
+			case e := <-events.ch:
+				se, ok := e.(*SecurityEvent)
+				if !ok || se.Reason != "port_bounce" || se.Command != c.command {
+					t.Errorf("event = %+v, want port_bounce of %s", e, c.command)
+				}
+			default:
+				t.Error("security event is not emitted")
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package pftp
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errOriginCircuitOpen is result of connection to origins whose circuits are all open
+var errOriginCircuitOpen = errors.New("circuit of origin is open")
+
+// states of origin circuit
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half_open"
+)
+
+// circuitBreaker stop routing sessions to origin failed repeatedly.
+// after cooldown, one trial session is routed (half-open) and its result
+// closes or opens circuit again. it is shared by all client sessions of server.
+type circuitBreaker struct {
+	mutex     sync.Mutex
+	origins   map[string]*circuitState
+	threshold int
+	cooldown  time.Duration
+	events    *eventBus
+	now       func() time.Time
+}
+
+type circuitState struct {
+	state    string
+	failures int // consecutive failures
+	openedAt time.Time
+	trialAt  time.Time
+}
+
+func newCircuitBreaker(c *Config, events *eventBus) *circuitBreaker {
+	if c.CircuitBreakerFailures <= 0 {
+		return nil
+	}
+
+	return &circuitBreaker{
+		origins:   map[string]*circuitState{},
+		threshold: c.CircuitBreakerFailures,
+		cooldown:  time.Duration(c.CircuitBreakerCooldown) * time.Second,
+		events:    events,
+		now:       time.Now,
+	}
+}
+
+// return true when session can be routed to origin
+func (b *circuitBreaker) allow(addr string) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	st, ok := b.origins[addr]
+	if !ok {
+		return true
+	}
+
+	now := b.now()
+	switch st.state {
+	case circuitOpen:
+		if now.Sub(st.openedAt) < b.cooldown {
+			return false
+		}
+		b.change(addr, st, circuitHalfOpen)
+		st.trialAt = now
+		return true
+	case circuitHalfOpen:
+		// trial which never reported is given up after cooldown
+		if now.Sub(st.trialAt) < b.cooldown {
+			return false
+		}
+		st.trialAt = now
+		return true
+	}
+
+	return true
+}
+
+// report result of connection to origin
+func (b *circuitBreaker) report(addr string, ok bool) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	st, found := b.origins[addr]
+	if ok {
+		if found {
+			if st.state != circuitClosed {
+				st.failures = 0
+				b.change(addr, st, circuitClosed)
+			}
+			delete(b.origins, addr)
+		}
+		return
+	}
+
+	if !found {
+		st = &circuitState{state: circuitClosed}
+		b.origins[addr] = st
+	}
+	st.failures++
+	if st.state == circuitHalfOpen || (st.state == circuitClosed && st.failures >= b.threshold) {
+		st.openedAt = b.now()
+		b.change(addr, st, circuitOpen)
+	}
+}
+
+func (b *circuitBreaker) change(addr string, st *circuitState, state string) {
+	st.state = state
+	b.events.emit(&OriginCircuitEvent{
+		Time:     b.now(),
+		Origin:   addr,
+		State:    state,
+		Failures: st.failures,
+	})
+}
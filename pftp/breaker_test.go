@@ -0,0 +1,59 @@
+package pftp
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_circuitBreaker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	events := newEventBus()
+	b := newCircuitBreaker(&Config{CircuitBreakerFailures: 3, CircuitBreakerCooldown: 30}, events)
+	b.now = func() time.Time { return now }
+
+	steps := []struct {
+		name      string
+		elapsed   time.Duration
+		report    *bool
+		wantAllow bool
+		wantState string // state in emitted event. empty means no event.
+	}{
+		{name: "failure_1", report: boolp(false), wantAllow: true},
+		{name: "failure_2", report: boolp(false), wantAllow: true},
+		{name: "failure_3", report: boolp(false), wantAllow: false, wantState: circuitOpen},
+		{name: "cooling_down", elapsed: 29 * time.Second, wantAllow: false},
+		{name: "trial", elapsed: 30 * time.Second, wantAllow: true, wantState: circuitHalfOpen},
+		{name: "trial_failed", elapsed: 30 * time.Second, report: boolp(false), wantAllow: false, wantState: circuitOpen},
+		{name: "second_trial", elapsed: 60 * time.Second, wantAllow: true, wantState: circuitHalfOpen},
+		{name: "only_one_trial", elapsed: 61 * time.Second, wantAllow: false},
+		{name: "recovered", elapsed: 61 * time.Second, report: boolp(true), wantAllow: true, wantState: circuitClosed},
+	}
+	for _, step := range steps {
+		now = time.Unix(1000, 0).Add(step.elapsed)
+
+		if step.report != nil {
+			b.report("origin:21", *step.report)
+		}
+		// allow of half-open circuit starts trial. check it only once.
+		if got := b.allow("origin:21"); got != step.wantAllow {
+			t.Errorf("%s: circuitBreaker.allow() = %v, want %v", step.name, got, step.wantAllow)
+		}
+
+		state := ""
+		if len(events.ch) > 0 {
+			state = (<-events.ch).(*OriginCircuitEvent).State
+		}
+		if state != step.wantState {
+			t.Errorf("%s: event state = %q, want %q", step.name, state, step.wantState)
+		}
+	}
+
+	var nilBreaker *circuitBreaker
+	if !nilBreaker.allow("origin:21") {
+		t.Errorf("nil circuitBreaker.allow() = false")
+	}
+}
+
+func boolp(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,123 @@
+package pftp
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// originCapabilities is FEAT and SYST result of origin
+type originCapabilities struct {
+	features []string
+	system   string
+	expires  time.Time
+}
+
+// return true when origin supports capability.
+// ex) "EPSV", "UTF8", "MLSD", "TLS", "MODE Z"
+func (o *originCapabilities) supports(capability string) bool {
+	switch strings.ToUpper(capability) {
+	case "MLSD":
+		// MLSD is advertised as MLST feature (RFC 3659)
+		return hasFeature(o.features, "MLST")
+	case "TLS":
+		for _, feature := range o.features {
+			if f := strings.Fields(strings.ToUpper(feature)); len(f) == 2 && f[0] == "AUTH" && strings.Contains(f[1], "TLS") {
+				return true
+			}
+		}
+		return false
+	case "MODE Z":
+		for _, feature := range o.features {
+			if strings.EqualFold(strings.Join(strings.Fields(feature), " "), "MODE Z") {
+				return true
+			}
+		}
+		return false
+	}
+
+	return hasFeature(o.features, capability)
+}
+
+// capabilityCache keep capabilities of origins for capability_cache_ttl.
+// it is shared by all client sessions of server.
+type capabilityCache struct {
+	mutex   sync.Mutex
+	origins map[string]*originCapabilities
+	probing map[string]bool
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+func newCapabilityCache(c *Config) *capabilityCache {
+	if c.CapabilityCacheTTL <= 0 {
+		return nil
+	}
+
+	return &capabilityCache{
+		origins: map[string]*originCapabilities{},
+		probing: map[string]bool{},
+		ttl:     time.Duration(c.CapabilityCacheTTL) * time.Second,
+		now:     time.Now,
+	}
+}
+
+// return capabilities of origin. false when not cached or expired.
+func (m *capabilityCache) get(addr string) (*originCapabilities, bool) {
+	if m == nil {
+		return nil, false
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	o, ok := m.origins[addr]
+	if !ok || !m.now().Before(o.expires) {
+		return nil, false
+	}
+
+	return o, true
+}
+
+// store capabilities of origin. empty system keeps cached one.
+func (m *capabilityCache) set(addr string, features []string, system string) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if o, ok := m.origins[addr]; ok && len(system) == 0 {
+		system = o.system
+	}
+	m.origins[addr] = &originCapabilities{
+		features: append([]string{}, features...),
+		system:   system,
+		expires:  m.now().Add(m.ttl),
+	}
+}
+
+// return true when caller should probe origin.
+// only one probe runs for each origin.
+func (m *capabilityCache) startProbe(addr string) bool {
+	if m == nil {
+		return false
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if o, ok := m.origins[addr]; (ok && m.now().Before(o.expires)) || m.probing[addr] {
+		return false
+	}
+	m.probing[addr] = true
+
+	return true
+}
+
+func (m *capabilityCache) finishProbe(addr string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.probing, addr)
+}
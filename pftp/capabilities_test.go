@@ -0,0 +1,123 @@
+package pftp
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_originCapabilities_supports(t *testing.T) {
+	o := &originCapabilities{features: []string{"EPSV", "UTF8", "MLST type*;size*;modify*;", "AUTH TLS", "MODE Z"}}
+
+	tests := []struct {
+		capability string
+		want       bool
+	}{
+		{capability: "EPSV", want: true},
+		{capability: "utf8", want: true},
+		{capability: "MLSD", want: true},
+		{capability: "TLS", want: true},
+		{capability: "MODE Z", want: true},
+		{capability: "LANG", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.capability, func(t *testing.T) {
+			if got := o.supports(tt.capability); got != tt.want {
+				t.Errorf("originCapabilities.supports() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if (&originCapabilities{features: []string{"AUTH SSL"}}).supports("TLS") {
+		t.Errorf("originCapabilities.supports(TLS) = true for AUTH SSL")
+	}
+}
+
+func Test_capabilityCache(t *testing.T) {
+	if newCapabilityCache(&Config{}) != nil {
+		t.Fatal("newCapabilityCache() is not nil without TTL")
+	}
+
+	now := time.Now()
+	m := newCapabilityCache(&Config{CapabilityCacheTTL: 60})
+	m.now = func() time.Time { return now }
+
+	if !m.startProbe("origin:21") || m.startProbe("origin:21") {
+		t.Fatal("capabilityCache.startProbe() should allow only one probe")
+	}
+	m.set("origin:21", []string{"EPSV"}, "UNIX Type: L8")
+	m.finishProbe("origin:21")
+
+	// FEAT reply of session keeps SYST result
+	m.set("origin:21", []string{"EPSV", "UTF8"}, "")
+	o, ok := m.get("origin:21")
+	if !ok || !reflect.DeepEqual(o.features, []string{"EPSV", "UTF8"}) || o.system != "UNIX Type: L8" {
+		t.Errorf("capabilityCache.get() = %+v, %v", o, ok)
+	}
+	if m.startProbe("origin:21") {
+		t.Errorf("capabilityCache.startProbe() = true for cached origin")
+	}
+
+	now = now.Add(61 * time.Second)
+	if _, ok := m.get("origin:21"); ok {
+		t.Errorf("capabilityCache.get() = true after TTL")
+	}
+	if !m.startProbe("origin:21") {
+		t.Errorf("capabilityCache.startProbe() = false after TTL")
+	}
+}
+
+func Test_proxyServer_probeOrigin(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("220-Welcome\r\n220 ready\r\n"))
+		replies := map[string]string{
+			"FEAT\r\n": "211-Features:\r\n EPSV\r\n MLST type*;\r\n211 End\r\n",
+			"SYST\r\n": "215 UNIX Type: L8\r\n",
+			"QUIT\r\n": "221 Goodbye.\r\n",
+		}
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(replies[line]))
+		}
+	}()
+
+	addr := l.Addr().String()
+	s := &proxyServer{
+		config:       &Config{},
+		log:          &logger{},
+		capabilities: newCapabilityCache(&Config{CapabilityCacheTTL: 60}),
+	}
+	if err := s.probeOrigin("127.0.0.1:10000", addr); err != nil {
+		t.Fatalf("proxyServer.probeOrigin() error = %v", err)
+	}
+
+	o, ok := s.capabilities.get(addr)
+	if !ok || !o.supports("MLSD") || !o.supports("EPSV") || o.system != "UNIX Type: L8" {
+		t.Errorf("capabilities after probe = %+v, %v", o, ok)
+	}
+
+	// cached capabilities are loaded to session
+	s.originAddr = addr
+	s.loadCapabilities("127.0.0.1:10000")
+	if !reflect.DeepEqual(s.getFeatures(), []string{"EPSV", "MLST type*;"}) || s.getSystem() != "UNIX Type: L8" {
+		t.Errorf("proxyServer.loadCapabilities() features = %v, system = %s", s.getFeatures(), s.getSystem())
+	}
+}
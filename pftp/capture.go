@@ -0,0 +1,329 @@
+package pftp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// kinds of session capture records
+const (
+	captureLine     = "line"
+	captureTransfer = "transfer"
+)
+
+// records buffered for capture file. records are dropped while file writes
+// are slower than session.
+const captureBufferSize = 256
+
+// CaptureRecord is JSON line of session capture file. line is control
+// connection line redacted like session tail, and transfer is data transfer
+// finished by data channel proxy whose Direction is upload or download.
+type CaptureRecord struct {
+	Time      time.Time     `json:"time"`
+	Kind      string        `json:"kind"`
+	Direction string        `json:"direction"`
+	Line      string        `json:"line,omitempty"`
+	Path      string        `json:"path,omitempty"`
+	Bytes     int64         `json:"bytes,omitempty"`
+	Duration  time.Duration `json:"duration,omitempty"`
+}
+
+// SessionCapture is state of session capture in admin API. Truncated is
+// true when capture reached MaxBytes and later records were dropped, and
+// Dropped is count of records dropped while file writes were slow.
+type SessionCapture struct {
+	SessionID uint64    `json:"session_id"`
+	File      string    `json:"file"`
+	StartedAt time.Time `json:"started_at"`
+	Bytes     int64     `json:"bytes"`
+	MaxBytes  int64     `json:"max_bytes"`
+	Truncated bool      `json:"truncated"`
+	Dropped   int64     `json:"dropped"`
+}
+
+// sessionCapture write control lines and data transfers of one session to
+// file in capture_dir until it is stopped, session ends or max bytes.
+// records are queued by session and written to file by its own goroutine,
+// so session is not blocked by file I/O.
+type sessionCapture struct {
+	mutex   sync.Mutex
+	file    *os.File
+	records chan []byte // closed when capture ends
+	done    chan struct{}
+	ended   bool
+	queued  int64 // bytes of records queued, limited by max bytes
+	state   SessionCapture
+}
+
+func newSessionCapture(dir string, id uint64, maxBytes int64) (*sessionCapture, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	name := filepath.Join(dir, fmt.Sprintf("session-%d-%s.jsonl", id, now.UTC().Format("20060102T150405Z")))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &sessionCapture{
+		file:    f,
+		records: make(chan []byte, captureBufferSize),
+		done:    make(chan struct{}),
+		state:   SessionCapture{SessionID: id, File: name, StartedAt: now, MaxBytes: maxBytes},
+	}
+	go c.run()
+
+	return c, nil
+}
+
+// write records queued to file until capture ends. rest of records are
+// discarded after write error.
+func (c *sessionCapture) run() {
+	defer close(c.done)
+	defer c.file.Close()
+
+	failed := false
+	for b := range c.records {
+		if failed {
+			continue
+		}
+		n, err := c.file.Write(b)
+		c.mutex.Lock()
+		c.state.Bytes += int64(n)
+		c.mutex.Unlock()
+		if err != nil {
+			failed = true
+			c.end()
+		}
+	}
+}
+
+// queue record. capture ends when record exceeds max bytes.
+func (c *sessionCapture) write(r CaptureRecord) {
+	if c == nil {
+		return
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ended {
+		return
+	}
+	if c.queued+int64(len(b)) > c.state.MaxBytes {
+		c.state.Truncated = true
+		c.endLocked()
+		return
+	}
+	select {
+	case c.records <- b:
+		c.queued += int64(len(b))
+	default:
+		c.state.Dropped++
+	}
+}
+
+func (c *sessionCapture) end() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.endLocked()
+}
+
+// it is called with mutex locked
+func (c *sessionCapture) endLocked() {
+	if !c.ended {
+		c.ended = true
+		close(c.records)
+	}
+}
+
+// end capture and wait until queued records are written
+func (c *sessionCapture) close() SessionCapture {
+	c.end()
+	<-c.done
+
+	return c.status()
+}
+
+func (c *sessionCapture) status() SessionCapture {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.state
+}
+
+// attach capture to session. false when session is captured already or ended.
+func (t *sessionTail) startCapture(c *sessionCapture) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed || t.capture != nil {
+		return false
+	}
+	t.capture = c
+
+	return true
+}
+
+// detach capture from session. nil when session is not captured.
+func (t *sessionTail) stopCapture() *sessionCapture {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	c := t.capture
+	t.capture = nil
+
+	return c
+}
+
+func (t *sessionTail) captured() *sessionCapture {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.capture
+}
+
+// write data transfer of session to capture
+func (t *sessionTail) captureTransfer(direction string, d *dataHandler) {
+	if t == nil {
+		return
+	}
+
+	t.captured().write(CaptureRecord{
+		Time:      time.Now(),
+		Kind:      captureTransfer,
+		Direction: direction,
+		Path:      d.path,
+		Bytes:     d.transferredBytes(),
+		Duration:  d.duration,
+	})
+}
+
+// return tail of session for capture. it writes error response and returns
+// nil when session can not be captured.
+func (server *FtpServer) captureTail(w http.ResponseWriter, ps httprouter.Params) (uint64, *sessionTail) {
+	if !server.adminAuthEnabled() {
+		writeJSON(w, http.StatusForbidden, adminError{Error: "session capture needs admin authentication"})
+		return 0, nil
+	}
+
+	id, err := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: "session id must be number"})
+		return 0, nil
+	}
+	t, ok := server.clients.tail(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "unknown session"})
+		return 0, nil
+	}
+
+	return id, t
+}
+
+// POST /sessions/:id/capture
+// start capture of session to file in capture_dir. max_bytes of request
+// limits the file under capture_max_bytes.
+func (server *FtpServer) handleStartCapture(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if len(server.config.CaptureDir) == 0 {
+		writeJSON(w, http.StatusForbidden, adminError{Error: "session capture needs capture_dir"})
+		return
+	}
+	var req struct {
+		MaxBytes int64 `json:"max_bytes"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, adminError{Error: "invalid request body"})
+			return
+		}
+	}
+	if req.MaxBytes < 0 {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: "max_bytes must not be negative"})
+		return
+	}
+	if req.MaxBytes == 0 || req.MaxBytes > server.config.CaptureMaxBytes {
+		req.MaxBytes = server.config.CaptureMaxBytes
+	}
+
+	id, t := server.captureTail(w, ps)
+	if t == nil {
+		return
+	}
+	if t.captured() != nil {
+		writeJSON(w, http.StatusConflict, adminError{Error: "session is already captured"})
+		return
+	}
+	c, err := newSessionCapture(server.config.CaptureDir, id, req.MaxBytes)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, adminError{Error: "cannot open capture file: " + err.Error()})
+		return
+	}
+	state := c.status()
+	if !t.startCapture(c) {
+		c.close()
+		os.Remove(state.File)
+		writeJSON(w, http.StatusConflict, adminError{Error: "session is already captured or ended"})
+		return
+	}
+
+	server.logger.Infof("capture of session %d is started to %s", id, state.File)
+	writeJSON(w, http.StatusOK, state)
+}
+
+// GET /sessions/:id/capture
+// return state of capture of session
+func (server *FtpServer) handleCaptureStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	_, t := server.captureTail(w, ps)
+	if t == nil {
+		return
+	}
+	c := t.captured()
+	if c == nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "session is not captured"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, c.status())
+}
+
+// DELETE /sessions/:id/capture
+// stop capture of session and return its final state
+func (server *FtpServer) handleStopCapture(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, t := server.captureTail(w, ps)
+	if t == nil {
+		return
+	}
+	c := t.stopCapture()
+	if c == nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "session is not captured"})
+		return
+	}
+
+	state := c.close()
+	server.logger.Infof("capture of session %d is stopped after %d bytes", id, state.Bytes)
+	writeJSON(w, http.StatusOK, state)
+}
+
+func validateCapture(c *Config) error {
+	if c.CaptureMaxBytes < 0 {
+		return fmt.Errorf("configuration error: capture_max_bytes must not be negative")
+	}
+	if c.CaptureMaxBytes == 0 {
+		c.CaptureMaxBytes = 10 << 20
+	}
+
+	return nil
+}
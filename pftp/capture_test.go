@@ -0,0 +1,137 @@
+package pftp
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func readCapture(t *testing.T, name string) []CaptureRecord {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records := []CaptureRecord{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var r CaptureRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			t.Fatalf("capture line %q: %v", s.Text(), err)
+		}
+		records = append(records, r)
+	}
+
+	return records
+}
+
+func Test_sessionTail_capture(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxBytes      int64
+		wantRecords   int
+		wantTruncated bool
+	}{
+		{name: "all", maxBytes: 1 << 20, wantRecords: 4},
+		{name: "truncated", maxBytes: 150, wantRecords: 1, wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tail := newSessionTail(newRedactor(&Config{}), 0)
+			c, err := newSessionCapture(t.TempDir(), 3, tt.maxBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tail.startCapture(c) || tail.startCapture(c) {
+				t.Fatal("startCapture() must succeed only once")
+			}
+
+			tail.command(tailFromClient, "PASS secret\r\n")
+			tail.reply(tailToClient, "230 Logged in\r\n")
+			tail.command(tailFromClient, "STOR a.txt\r\n")
+			tail.captureTransfer(uploadStream, &dataHandler{path: "a.txt", transferred: 1024, duration: time.Second})
+			tail.close()
+
+			state := c.status()
+			if state.Truncated != tt.wantTruncated || tail.captured() != nil {
+				t.Errorf("state = %+v, want truncated %v and detached", state, tt.wantTruncated)
+			}
+			records := readCapture(t, state.File)
+			if len(records) != tt.wantRecords {
+				t.Fatalf("records = %+v, want %d", records, tt.wantRecords)
+			}
+			if r := records[0]; r.Kind != captureLine || r.Direction != tailFromClient || r.Line != "PASS ********" {
+				t.Errorf("first record = %+v, want redacted PASS", r)
+			}
+			if tt.wantRecords < 4 {
+				return
+			}
+			if r := records[3]; r.Kind != captureTransfer || r.Direction != uploadStream || r.Path != "a.txt" || r.Bytes != 1024 || r.Duration != time.Second {
+				t.Errorf("transfer record = %+v", r)
+			}
+		})
+	}
+}
+
+func Test_FtpServer_handleCapture(t *testing.T) {
+	operator := map[string]string{"t0ken": "operator"}
+	tests := []struct {
+		name     string
+		tokens   map[string]string
+		dir      bool
+		requests []string // "METHOD path body"
+		wantCode int      // of last request
+		want     string
+	}{
+		{name: "no_dir", tokens: operator, requests: []string{"POST /sessions/1/capture"}, wantCode: http.StatusForbidden},
+		{name: "not_authenticated", dir: true, requests: []string{"POST /sessions/1/capture"}, wantCode: http.StatusForbidden},
+		{name: "unknown_session", tokens: operator, dir: true, requests: []string{"POST /sessions/2/capture"}, wantCode: http.StatusNotFound},
+		{name: "negative_max_bytes", tokens: operator, dir: true, requests: []string{`POST /sessions/1/capture {"max_bytes":-1}`}, wantCode: http.StatusBadRequest},
+		{name: "start", tokens: operator, dir: true, requests: []string{`POST /sessions/1/capture {"max_bytes":1024}`}, wantCode: http.StatusOK, want: `"max_bytes":1024`},
+		{name: "max_bytes_capped", tokens: operator, dir: true, requests: []string{`POST /sessions/1/capture {"max_bytes":99999}`}, wantCode: http.StatusOK, want: `"max_bytes":2048`},
+		{name: "already_captured", tokens: operator, dir: true, requests: []string{"POST /sessions/1/capture", "POST /sessions/1/capture"}, wantCode: http.StatusConflict},
+		{name: "status", tokens: operator, dir: true, requests: []string{"POST /sessions/1/capture", "GET /sessions/1/capture"}, wantCode: http.StatusOK, want: `"session_id":1`},
+		{name: "stop", tokens: operator, dir: true, requests: []string{"POST /sessions/1/capture", "DELETE /sessions/1/capture", "GET /sessions/1/capture"}, wantCode: http.StatusNotFound},
+		{name: "stop_not_captured", tokens: operator, dir: true, requests: []string{"DELETE /sessions/1/capture"}, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{AdminTokens: tt.tokens, CaptureMaxBytes: 2048}
+			if tt.dir {
+				config.CaptureDir = t.TempDir()
+			}
+			c := &clientHandler{id: 1, tail: newSessionTail(nil, 0)}
+			server := &FtpServer{config: config, clients: newSessionRegistry(), logger: logrus.StandardLogger()}
+			server.clients.add(c)
+			defer server.clients.remove(c)
+
+			var rec *httptest.ResponseRecorder
+			for _, r := range tt.requests {
+				words := strings.SplitN(r, " ", 3)
+				body := ""
+				if len(words) == 3 {
+					body = words[2]
+				}
+				req := httptest.NewRequest(words[0], words[1], strings.NewReader(body))
+				req.Header.Set("Authorization", "Bearer t0ken")
+				rec = httptest.NewRecorder()
+				server.adminHandler().ServeHTTP(rec, req)
+			}
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("body = %s, want %q", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package pftp
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net"
@@ -19,6 +21,9 @@ type handleFunc struct {
 	suspend bool
 }
 
+// reply text of 522 defined in RFC 2428
+const networkProtocolNotSupported = "Network protocol not supported, use (1,2)"
+
 var handlers map[string]*handleFunc
 
 func init() {
@@ -32,6 +37,12 @@ func init() {
 	handlers["EPRT"] = &handleFunc{(*clientHandler).handleDATA, false}
 	handlers["PASV"] = &handleFunc{(*clientHandler).handleDATA, false}
 	handlers["EPSV"] = &handleFunc{(*clientHandler).handleDATA, false}
+	handlers["NOOP"] = &handleFunc{(*clientHandler).handleNOOP, false}
+	handlers["SITE"] = &handleFunc{(*clientHandler).handleSITE, false}
+	handlers["HOST"] = &handleFunc{(*clientHandler).handleHOST, false}
+	handlers["LANG"] = &handleFunc{(*clientHandler).handleLANG, false}
+	handlers["MODE"] = &handleFunc{(*clientHandler).handleMODE, false}
+	handlers["FEAT"] = &handleFunc{(*clientHandler).handleFEAT, false}
 
 	// handle data transfer begin commands
 	handlers["RETR"] = &handleFunc{(*clientHandler).handleTransfer, false}
@@ -46,17 +57,20 @@ func init() {
 type clientHandler struct {
 	id                  uint64
 	conn                net.Conn
-	config              *config
+	config              *Config
 	tlsDatas            *tlsDataSet
 	controlInTLS        *abool.AtomicBool
 	transferInTLS       *abool.AtomicBool
 	middleware          middleware
+	hooks               *hooks
+	events              *eventBus
 	writer              *bufio.Writer
 	reader              *bufio.Reader
 	line                string
 	command             string
 	param               string
 	proxy               *proxyServer
+	shadow              *shadowOrigin
 	context             *Context
 	currentConnection   *int32
 	connCounts          int32
@@ -65,40 +79,134 @@ type clientHandler struct {
 	srcIP               string
 	previousTLSCommands []string
 	inDataTransfer      *abool.AtomicBool
+	lastActivity        int64 // unix nano time of last client command. accessed atomically
+	lastNoopForward     time.Time
+	messages            *messageCatalog
+	transfers           *transferLimiter
+	sessions            *sessionLimiter
+	health              *originHealth
+	breaker             *circuitBreaker
+	originStats         *originStats
+	capabilities        *capabilityCache
+	redactor            *redactor
+	tail                *sessionTail
+	timings             *SessionTimings // nil when session_timings is disabled
+	counters            *TransferCounters
+	accounting          *accounting
+	bans                *banGuard
+	tarpit              *tarpit
+	schedules           *scheduleClock
+	dynamic             *dynamicConfig
+	spool               *spool
+	resources           *resourceWatchdog
+	disk                *diskWatchdog
+	throughput          *throughputStats
+	uploads             *uploadHistory
+	dataListeners       *dataListenerGC
+	dataPorts           *dataPortAllocator
+	anonymous           *anonymousAccess
+	dialects            dialectSet
+	draining            *abool.AtomicBool // refuse logins while server is draining
+	standby             *abool.AtomicBool // refuse logins while server is standby
+	resumption          *resumptionCodec
+	metrics             *metrics
+	user                string // username sent by USER command
+	epsvAll             bool   // EPSV ALL received
+	hostAddr            string // origin resolved by HOST command
+	connectedAt         time.Time
+	summaryMutex        sync.Mutex // guard summary read by admin API and server stop
+	summary             SessionInfo
+	summaryLocale       string
+	summaryUser         string // raw user of USER for killing sessions by user
+	userBlocks          *userBlocks
+	binaryType          bool          // TYPE I or L is in effect
+	restOffset          int64         // REST offset for next transfer
+	alloSize            int64         // size announced by ALLO for next upload, -1 when it is not
+	modeZ               modeZ         // MODE Z of client and origin legs
+	closeReason         string        // guarded by summaryMutex
+	notices             []string      // administrative notices for next reply. guarded by summaryMutex
+	loginLines          []string      // USER and PASS for other origin sessions of striped transfer
+	transferred         int64         // data bytes of session. accessed atomically.
+	sessionStarted      bool          // connect hook accepted session
+	closing             chan struct{} // closed by closeWithNotice
+	closingOnce         sync.Once
 }
 
-func newClientHandler(connection net.Conn, c *config, sharedTLSData *tlsData, m middleware, id uint64, currentConnection *int32) *clientHandler {
+func newClientHandler(connection net.Conn, server *FtpServer, id uint64, currentConnection *int32) *clientHandler {
+	c := server.config
 	p := &clientHandler{
 		id:                id,
 		conn:              connection,
 		config:            c,
 		controlInTLS:      abool.New(),
 		transferInTLS:     abool.New(),
-		middleware:        m,
+		closing:           make(chan struct{}),
+		middleware:        server.middleware,
+		hooks:             server.hooks,
+		events:            server.events,
+		messages:          server.messages,
+		transfers:         server.transfers,
+		sessions:          server.sessions,
+		health:            server.health,
+		breaker:           server.breaker,
+		originStats:       server.originStats,
+		capabilities:      server.capabilities,
+		redactor:          server.redactor,
+		tail:              newSessionTail(server.redactor, c.SessionHistory),
+		timings:           newSessionTimings(c),
+		counters:          newTransferCounters(),
+		accounting:        server.accounting,
+		bans:              server.bans,
+		tarpit:            server.tarpit,
+		schedules:         server.schedules,
+		dynamic:           server.dynamic,
+		spool:             server.spool,
+		resources:         server.resources,
+		disk:              server.disk,
+		throughput:        server.throughput,
+		uploads:           server.uploads,
+		dataListeners:     server.dataListeners,
+		dataPorts:         server.dataPorts,
+		userBlocks:        server.userBlocks,
+		anonymous:         server.anonymous,
+		dialects:          server.dialects,
+		draining:          server.draining,
+		standby:           server.standby,
+		resumption:        server.resumption,
+		metrics:           server.metrics,
 		writer:            bufio.NewWriter(connection),
 		reader:            bufio.NewReader(connection),
 		context:           newContext(c),
 		currentConnection: currentConnection,
 		mutex:             &sync.Mutex{},
-		log:               &logger{fromip: connection.RemoteAddr().String(), user: "-", id: id},
-		srcIP:             connection.RemoteAddr().String(),
+		log:               &logger{fromip: hostPort(connection.RemoteAddr()), user: "-", id: id, out: server.logger},
+		srcIP:             hostPort(connection.RemoteAddr()),
 		inDataTransfer:    abool.New(),
+		lastActivity:      time.Now().UnixNano(),
+		alloSize:          -1,
+		connectedAt:       time.Now(),
 	}
 
+	// origin dialer given by option is default of all routes
+	p.context.OriginDialer = server.originDialer
+	p.context.Timings = p.timings
+	p.context.Transfers = p.counters
+
 	// increase current connection count
 	p.connCounts = atomic.AddInt32(p.currentConnection, 1)
 	p.log.info("FTP Client connected. clientIP: %s. current connection count: %d", p.conn.RemoteAddr(), p.connCounts)
 
 	// is masquerade IP not setted, set local IP of client connection
 	if len(p.config.MasqueradeIP) == 0 {
-		p.config.MasqueradeIP = strings.Split(connection.LocalAddr().String(), ":")[0]
+		p.config.MasqueradeIP = addrIP(connection.LocalAddr())
 	}
 
 	// make TLS configs by shared pftp server conf(for client) and client own conf(for origin)
 	p.tlsDatas = &tlsDataSet{
-		forClient: sharedTLSData,
+		forClient: server.serverTLSData,
 		forOrigin: buildTLSConfigForOrigin(),
 	}
+	p.tlsDatas.forOrigin.config.KeyLogWriter = server.originKeyLog.writer()
 
 	return p
 }
@@ -114,12 +222,111 @@ func (c *clientHandler) Close() error {
 	return nil
 }
 
+// store summary of session for other goroutines.
+// it must be called from session goroutine.
+func (c *clientHandler) updateSummary() {
+	c.summaryMutex.Lock()
+	defer c.summaryMutex.Unlock()
+
+	c.summary = SessionInfo{
+		ID:          c.id,
+		ClientAddr:  c.srcIP,
+		User:        c.log.user,
+		ConnectedAt: c.connectedAt,
+	}
+	if c.proxy != nil {
+		c.summary.Origin = c.proxy.originAddr
+	}
+	c.summaryLocale = c.context.Locale
+	c.summaryUser = c.user
+}
+
+// return summary of session
+func (c *clientHandler) sessionInfo() SessionInfo {
+	c.summaryMutex.Lock()
+	defer c.summaryMutex.Unlock()
+
+	return c.summary
+}
+
+// store reason of closing session. first reason is kept because
+// closing by one side makes errors on other side.
+func (c *clientHandler) setCloseReason(reason string) {
+	c.summaryMutex.Lock()
+	defer c.summaryMutex.Unlock()
+
+	if len(c.closeReason) == 0 {
+		c.closeReason = reason
+	}
+}
+
+// emit event of closed session
+func (c *clientHandler) emitDisconnect() {
+	c.setCloseReason(closeReasonClientClosed)
+
+	e := &ClientDisconnectEvent{
+		Time:       time.Now(),
+		SessionID:  c.id,
+		ClientAddr: c.srcIP,
+		User:       c.log.user,
+		Duration:   time.Since(c.connectedAt),
+		Bytes:      atomic.LoadInt64(&c.transferred),
+	}
+	if c.proxy != nil {
+		e.Origin = c.proxy.originAddr
+	}
+	c.summaryMutex.Lock()
+	e.Reason = c.closeReason
+	c.summaryMutex.Unlock()
+	e.History = c.abnormalHistory(e.Reason)
+
+	c.events.emit(e)
+}
+
+// noticeWriteTimeout is write deadline of 421 sent by closeWithNotice
+const noticeWriteTimeout = time.Second
+
+// send 421 to client and close client connection. session goroutines
+// end by closed connection. it is called from other goroutines.
+func (c *clientHandler) closeWithNotice(reason string) {
+	c.setCloseReason(reason)
+	c.closingOnce.Do(func() {
+		if c.closing != nil {
+			close(c.closing)
+		}
+	})
+
+	msg := defaultMessages[msgServiceClosing]
+	c.summaryMutex.Lock()
+	if c.messages != nil {
+		msg = c.messages.format(c.summaryLocale, msgServiceClosing, messageVars{
+			ClientAddr: c.srcIP,
+			SessionID:  c.id,
+		})
+	}
+	c.summaryMutex.Unlock()
+
+	// session goroutine may hold mutex while it is blocked by slow client,
+	// so notice is written to connection directly with short deadline.
+	if c.conn != nil {
+		c.conn.SetWriteDeadline(time.Now().Add(noticeWriteTimeout))
+		c.conn.Write([]byte(formatReply(421, msg)))
+	}
+	connectionCloser(c, c.log)
+}
+
 func (c *clientHandler) setClientDeadLine(t int) {
 	// do not time out during transfer data
 	if c.inDataTransfer.IsSet() {
 		c.conn.SetDeadline(time.Time{})
 	} else {
-		c.conn.SetDeadline(time.Now().Add(time.Duration(t) * time.Second))
+		// idle time is counted from last client activity
+		// (keepalive NOOP may not be counted as activity)
+		since := c.lastActive()
+		if atomic.LoadInt64(&c.lastActivity) == 0 {
+			since = time.Now()
+		}
+		c.conn.SetDeadline(since.Add(time.Duration(t) * time.Second))
 	}
 }
 
@@ -128,19 +335,37 @@ func (c *clientHandler) handleCommands() error {
 		// decrease current connection count
 		c.log.info("FTP Client disconnect. clientIP: %s. current connection count: %d", c.conn.RemoteAddr(), atomic.AddInt32(c.currentConnection, -1))
 
+		// count logged in session for accounting
+		if c.proxy != nil && c.proxy.isLoggedIn() {
+			c.accounting.addSession(c.accountingUser())
+			c.metrics.inc("pftp_sessions_total", "Count of logged in sessions.", "origin", c.proxy.originAddr, "user", c.log.user)
+		}
+
+		c.endAnonymous()
+		if c.timings != nil {
+			c.log.debug("session timings: %s", c.timings)
+		}
+
 		// close each connection again
 		connectionCloser(c, c.log)
 		if c.proxy != nil {
 			connectionCloser(c.proxy, c.log)
 		}
+		if c.shadow != nil {
+			connectionCloser(c.shadow, c.log)
+		}
+
+		c.endSession()
+		c.emitDisconnect()
 	}()
 
-	// Check max client. If exceeded, send 530 error to client and disconnect
-	if c.connCounts > c.config.MaxConnections {
+	// Check max client. If exceeded, send 421 error to client and disconnect
+	if c.connCounts > c.dynamic.maxConns(c.config.MaxConnections) {
+		c.setCloseReason(closeReasonPolicyKill)
 		err := fmt.Errorf("exceeded client connection limit")
 		r := result{
-			code: 530,
-			msg:  "max client exceeded",
+			code: 421,
+			msg:  c.message(msgMaxConnections),
 			err:  err,
 			log:  c.log,
 		}
@@ -151,10 +376,114 @@ func (c *clientHandler) handleCommands() error {
 		return err
 	}
 
+	// refuse new session before process hits file descriptor or memory limit
+	if c.resources.underPressure() {
+		c.setCloseReason(closeReasonResourcePressure)
+		c.metrics.inc("pftp_resource_rejected_sessions_total", "Sessions refused by resource pressure.")
+		err := fmt.Errorf("server is under resource pressure")
+		r := result{
+			code: 421,
+			msg:  c.message(msgOverloaded),
+			err:  err,
+			log:  c.log,
+		}
+		if err := r.Response(c); err != nil {
+			c.log.err("cannot send response to client")
+		}
+
+		return err
+	}
+
+	// refuse new session while server is draining or standby
+	if r := c.refuseDraining(); r != nil {
+		if err := r.Response(c); err != nil {
+			c.log.err("cannot send response to client")
+		}
+
+		return r.err
+	}
+	if r := c.refuseStandby(); r != nil {
+		if err := r.Response(c); err != nil {
+			c.log.err("cannot send response to client")
+		}
+
+		return r.err
+	}
+
+	// reject client IP banned by login failures
+	if until, banned := c.bans.banned(clientIP(c.srcIP)); banned {
+		c.setCloseReason(closeReasonPolicyKill)
+		err := fmt.Errorf("client IP is banned until %s", until.Format(time.RFC3339))
+		r := result{
+			code: 421,
+			msg:  c.message(msgBanned),
+			err:  err,
+			log:  c.log,
+		}
+		if err := r.Response(c); err != nil {
+			c.log.err("cannot send response to client")
+		}
+
+		return err
+	}
+
+	// over soft limit, wait in queue until other session ends
+	release, ok := c.sessions.acquire(time.Duration(c.config.ConnectionQueueWait)*time.Second, func() {
+		c.log.info("exceeded soft client connection limit. wait for session slot")
+		if err := c.writeMessage(120, c.message(msgServerBusy)); err != nil {
+			c.log.err("cannot send response to client")
+		}
+	})
+	if !ok {
+		c.setCloseReason(closeReasonPolicyKill)
+		err := fmt.Errorf("waiting for client connection slot is expired")
+		r := result{
+			code: 421,
+			msg:  c.message(msgMaxConnections),
+			err:  err,
+			log:  c.log,
+		}
+		if err := r.Response(c); err != nil {
+			c.log.err("cannot send response to client")
+		}
+
+		return err
+	}
+	defer release()
+
+	// implicit FTPS client starts TLS before welcome message. it is detected
+	// after admission checks so refused clients do not hold handshakes.
+	if err := c.detectImplicitTLS(); err != nil {
+		return err
+	}
+
+	// let embedder allocate resources of session before origin is connected
+	if r := c.startSession(); r != nil {
+		if err := r.Response(c); err != nil {
+			c.log.err("cannot send response to client")
+		}
+
+		return r.err
+	}
+
 	eg := errgroup.Group{}
 
 	err := c.connectProxy()
 	if err != nil {
+		c.setCloseReason(closeReasonOriginFailure)
+
+		// tell client to retry later instead of closing silently
+		if err == errOriginCircuitOpen {
+			r := result{
+				code: 421,
+				msg:  c.message(msgOriginTimeout),
+				err:  err,
+				log:  c.log,
+			}
+			if err := r.Response(c); err != nil {
+				c.log.err("cannot send response to client")
+			}
+		}
 		return err
 	}
 
@@ -196,6 +525,13 @@ func (c *clientHandler) getResponseFromOrigin() error {
 	for {
 		err = c.proxy.responseProxy()
 		if err != nil {
+			// origin closed connection, failed or did not reply in time
+			if command, ok := c.proxy.timedOutCommand(err); ok && isTransferCommand(command) {
+				c.setCloseReason(closeReasonTransferTimeout)
+			} else {
+				c.setCloseReason(closeReasonOriginFailure)
+			}
+
 			if err == io.EOF {
 				c.log.debug("EOF from origin connection")
 				err = nil
@@ -213,6 +549,7 @@ func (c *clientHandler) getResponseFromOrigin() error {
 			if !<-c.proxy.waitSwitching {
 				err = fmt.Errorf("switch origin to %s is failed", c.context.RemoteAddr)
 				c.log.err(err.Error())
+				c.setCloseReason(closeReasonOriginFailure)
 
 				break
 			}
@@ -240,8 +577,8 @@ func (c *clientHandler) readClientCommands() error {
 	}()
 
 	for {
-		if c.config.IdleTimeout > 0 {
-			c.setClientDeadLine(c.config.IdleTimeout)
+		if t := c.idleTimeout(); t > 0 {
+			c.setClientDeadLine(t)
 		}
 
 		line, err := c.reader.ReadString('\n')
@@ -252,15 +589,27 @@ func (c *clientHandler) readClientCommands() error {
 				lastError = nil
 			} else if c.command == "QUIT" {
 				lastError = nil
+			} else if err == errClientRenegotiation {
+				// TLS read side is broken but reply can be written
+				r := result{
+					code: 421,
+					msg:  c.message(msgTLSRenegotiation),
+					err:  err,
+					log:  c.log,
+				}
+				if err := r.Response(c); err != nil {
+					c.log.err("cannot send response to client")
+				}
 			} else {
 				switch err := err.(type) {
 				case net.Error:
 					nErr := net.Error(err)
 					if nErr.Timeout() {
+						c.setCloseReason(closeReasonIdleTimeout)
 						c.conn.SetDeadline(time.Now().Add(time.Minute))
 						r := result{
 							code: 421,
-							msg:  "command timeout : closing control connection",
+							msg:  c.message(msgIdleTimeout),
 							err:  err,
 							log:  c.log,
 						}
@@ -291,6 +640,18 @@ func (c *clientHandler) readClientCommands() error {
 
 			break
 		} else {
+			if c.config.NoopKeepsAlive || strings.ToUpper(getCommand(line)[0]) != "NOOP" {
+				atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+			}
+
+			// before forwarding because origin closes connection by QUIT
+			if strings.ToUpper(getCommand(line)[0]) == "QUIT" {
+				c.setCloseReason(closeReasonQuit)
+				c.quitDuringTransfer()
+			} else {
+				c.tarpitDelay()
+			}
+
 			commandResponse := c.handleCommand(line)
 			if commandResponse != nil {
 				if err = commandResponse.Response(c); err != nil {
@@ -316,14 +677,35 @@ func (c *clientHandler) writeLine(line string) error {
 	}
 
 	c.log.debug("send to client: %s", line)
+	c.tail.reply(tailToClient, line)
 	return nil
 }
 
 func (c *clientHandler) writeMessage(code int, message string) error {
-	line := fmt.Sprintf("%d %s", code, message)
+	// multi-line message is sent as multi-line reply
+	line := strings.TrimSuffix(c.withNotices(formatReply(code, message)), "\r\n")
 	return c.writeLine(line)
 }
 
+// return text of proxy generated reply from message catalog
+func (c *clientHandler) message(key string) string {
+	if c.messages == nil {
+		m, err := newMessageCatalog(c.config)
+		if err != nil {
+			c.log.err(err.Error())
+			m, _ = newMessageCatalog(&Config{WelcomeMsg: c.config.WelcomeMsg, UnresolvedMsg: c.config.UnresolvedMsg})
+		}
+		c.messages = m
+	}
+
+	return c.messages.format(c.context.Locale, key, messageVars{
+		Command:    c.command,
+		User:       c.user,
+		ClientAddr: c.srcIP,
+		SessionID:  c.id,
+	})
+}
+
 func (c *clientHandler) handleCommand(line string) (r *result) {
 	c.parseLine(line)
 	defer func() {
@@ -336,9 +718,69 @@ func (c *clientHandler) handleCommand(line string) (r *result) {
 	}()
 
 	c.commandLog(line)
+	c.trackTransferParams()
+	c.trackLogin()
+
+	// refuse login of session connected before draining or demotion and close it
+	if c.command == "USER" && !c.proxy.isLoggedIn() {
+		r := c.refuseDraining()
+		if r == nil {
+			r = c.refuseStandby()
+		}
+		if r != nil {
+			if err := r.Response(c); err != nil {
+				c.log.err("cannot send response to client")
+			}
+			connectionCloser(c, c.log)
+
+			return nil
+		}
+	}
+
+	// in strict mode, origin must be resolved by HOST or USER middleware every time.
+	// do not fall back to default remote address.
+	if c.config.DenyUnresolved && c.command == "USER" {
+		c.context.RemoteAddr = c.hostAddr
+	}
+
+	// route by host name given by HOST command. middleware can override it.
+	if c.command == "HOST" && !c.proxy.isLoggedIn() {
+		c.context.Host, c.context.RemoteAddr = routeHost(c.config, c.dynamic, c.param)
+	}
+
+	// refuse blocked user before its session is routed
+	if c.command == "USER" && !c.proxy.isLoggedIn() {
+		if r := c.refuseBlockedUser(); r != nil {
+			return r
+		}
+	}
+
+	// route by user given by dynamic config. middleware can override it.
+	if c.command == "USER" {
+		if addr, ok := c.dynamic.userOrigin(c.param); ok {
+			c.context.RemoteAddr = addr
+		}
+	}
+
+	// anonymous users are routed to anonymous origin. middleware can override it.
+	if c.command == "USER" && !c.proxy.isLoggedIn() {
+		if r := c.startAnonymous(); r != nil {
+			return r
+		}
+	}
+
+	// resumption token is valid only for user it was issued to
+	if c.command == "USER" && c.context.Resumption != nil && c.context.Resumption.User != c.param {
+		c.context.Resumption = nil
+	}
+
+	c.syncContext()
 
 	if c.middleware[c.command] != nil {
-		if err := c.middleware[c.command](c.context, c.param); err != nil {
+		start := time.Now()
+		err := c.middleware[c.command](c.context, c.param)
+		c.timings.Add(timingMiddleware, time.Since(start))
+		if err != nil {
 			return &result{
 				code: 500,
 				msg:  fmt.Sprintf("Internal error: %s", err),
@@ -346,6 +788,57 @@ func (c *clientHandler) handleCommand(line string) (r *result) {
 		}
 	}
 
+	for _, notice := range c.context.Notices {
+		c.notify(notice)
+	}
+	c.context.Notices = nil
+
+	if c.command == "HOST" && !c.proxy.isLoggedIn() {
+		c.hostAddr = c.context.RemoteAddr
+	}
+	if c.context.Anonymous {
+		c.context.ReadOnly = true
+	}
+
+	// reject mutating commands when session is read-only
+	if c.context.ReadOnly && isMutatingCommand(c.command, c.param) {
+		return &result{
+			code: 550,
+			msg:  c.message(msgReadOnly),
+		}
+	}
+
+	// deny login and mutating commands outside of access schedules
+	if r := c.enforceSchedules(); r != nil {
+		return r
+	}
+
+	if r := c.enforceTransferParams(); r != nil {
+		return r
+	}
+
+	// allow, deny or rewrite changes of file modes and timestamps
+	if r := c.enforceAttributePolicy(); r != nil {
+		return r
+	}
+	line = c.line
+
+	// keep path arguments within root path given by middleware
+	if len(c.context.RootPath) > 0 && c.proxy.isLoggedIn() {
+		if r := c.enforceRootPath(); r != nil {
+			return r
+		}
+		line = c.line
+	}
+
+	// hide masked paths from commands as well as listings
+	if r := c.enforceMaskedPaths(); r != nil {
+		return r
+	}
+	c.trackCwd()
+
+	c.proxy.setReplyRetry(c.context.ReplyRetries[c.command])
+
 	cmd := handlers[c.command]
 	if cmd != nil {
 		if cmd.suspend {
@@ -365,26 +858,128 @@ func (c *clientHandler) handleCommand(line string) (r *result) {
 		}
 	}
 
+	if isShadowCommand(c.command) {
+		c.shadow.mirror(line)
+	}
+
 	return nil
 }
 
+// record login result of client IP and ban it by too many failures
+func (c *clientHandler) loginResult(success bool) {
+	ip := clientIP(c.srcIP)
+	c.tarpit.loginResult(ip, success)
+	if !c.bans.loginResult(ip, success) {
+		return
+	}
+
+	until, _ := c.bans.banned(ip)
+	c.log.info("client IP %s is banned until %s by login failures", ip, until.Format(time.RFC3339))
+	c.events.emit(&ClientBannedEvent{
+		Time:       time.Now(),
+		SessionID:  c.id,
+		ClientAddr: c.srcIP,
+		User:       c.log.user,
+		Until:      until,
+	})
+}
+
+// update protocol details on context for middleware
+func (c *clientHandler) syncContext() {
+	c.context.ClientAddr = c.srcIP
+	if c.proxy != nil {
+		c.context.OriginFeatures = c.proxy.getFeatures()
+		c.context.OriginSystem = c.proxy.getSystem()
+		c.proxy.setLocale(c.context.Locale)
+	}
+}
+
+// connect to shadow origin if middleware set it.
+// shadow failure never affects client session.
+func (c *clientHandler) connectShadow() {
+	if len(c.context.ShadowAddr) == 0 || c.shadow != nil {
+		return
+	}
+
+	s, err := newShadowOrigin(c.context.ShadowAddr, c.log)
+	if err != nil {
+		c.log.err("cannot connect to shadow origin %s: %s", c.context.ShadowAddr, err.Error())
+		return
+	}
+
+	c.shadow = s
+}
+
+// return opaque ID of connected origin. Context.BackendID set by middleware
+// is used as is, otherwise it is made from origin address.
+func (c *clientHandler) backendID() string {
+	if len(c.context.BackendID) > 0 {
+		return c.context.BackendID
+	}
+
+	sum := sha256.Sum256([]byte(c.config.BackendIDSalt + c.proxy.originAddr))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
 func (c *clientHandler) connectProxy() error {
 	if c.proxy != nil {
-		err := c.proxy.switchOrigin(c.srcIP, c.context.RemoteAddr, c.previousTLSCommands)
+		from := c.proxy.originAddr
+		start := time.Now()
+		c.proxy.slowStart = time.Duration(c.context.SlowStartDuration) * time.Second
+		c.proxy.originProxy = c.context.OriginProxy
+		c.proxy.originDialer = c.context.OriginDialer
+		c.proxy.tlsPins = c.context.OriginTLSPins
+		err := c.proxy.switchOrigin(c.srcIP, c.context.RemoteAddr, c.context.FailoverAddrs, c.previousTLSCommands)
+
+		e := &OriginSwitchEvent{
+			Time:      time.Now(),
+			SessionID: c.id,
+			User:      c.log.user,
+			From:      from,
+			To:        c.proxy.originAddr,
+			Duration:  time.Since(start),
+		}
+		if err != nil {
+			e.To = c.context.RemoteAddr
+			e.Error = err.Error()
+		}
+		c.events.emit(e)
+
 		if err != nil {
 			return err
 		}
 	} else {
 		p, err := newProxyServer(
 			&proxyServerConfig{
-				clientReader:   c.reader,
-				clientWriter:   c.writer,
-				tlsDatas:       c.tlsDatas,
-				originAddr:     c.context.RemoteAddr,
-				mutex:          c.mutex,
-				log:            c.log,
-				config:         c.config,
-				inDataTransfer: c.inDataTransfer,
+				clientReader:      c.reader,
+				clientWriter:      c.writer,
+				tlsDatas:          c.tlsDatas,
+				originAddr:        c.context.RemoteAddr,
+				failoverAddrs:     c.context.FailoverAddrs,
+				mutex:             c.mutex,
+				log:               c.log,
+				config:            c.config,
+				inDataTransfer:    c.inDataTransfer,
+				welcomeMsg:        c.message(msgWelcome),
+				notReadyMsg:       c.message(msgOriginNotReady),
+				originTimeoutMsg:  c.message(msgOriginTimeout),
+				dataConnectionMsg: c.message(msgDataConnection),
+				originProxy:       c.context.OriginProxy,
+				originDialer:      c.context.OriginDialer,
+				tlsPins:           c.context.OriginTLSPins,
+				health:            c.health,
+				breaker:           c.breaker,
+				dynamic:           c.dynamic,
+				dialects:          c.dialects,
+				originStats:       c.originStats,
+				capabilities:      c.capabilities,
+				redactor:          c.redactor,
+				tail:              c.tail,
+				timings:           c.timings,
+				loginResult:       c.loginResult,
+				withNotices:       c.withNotices,
+				events:            c.events,
+				sessionID:         c.id,
 			})
 		if err != nil {
 			return err
@@ -392,9 +987,24 @@ func (c *clientHandler) connectProxy() error {
 		c.proxy = p
 	}
 
+	c.proxy.loadCapabilities(c.srcIP)
+	c.updateSummary()
+
 	return nil
 }
 
+// return true when command modifies files or directories on origin
+func isMutatingCommand(command string, param string) bool {
+	switch command {
+	case "STOR", "STOU", "APPE", "DELE", "RNFR", "RNTO", "MKD", "XMKD", "RMD", "XRMD":
+		return true
+	case "SITE":
+		return strings.ToUpper(strings.SplitN(strings.TrimSpace(param), " ", 2)[0]) == "CHMOD"
+	}
+
+	return false
+}
+
 // Get command from command line
 func getCommand(line string) []string {
 	return strings.SplitN(strings.Trim(line, "\r\n"), " ", 2)
@@ -404,6 +1014,7 @@ func (c *clientHandler) parseLine(line string) {
 	params := getCommand(line)
 	c.line = line
 	c.command = strings.ToUpper(params[0])
+	c.param = ""
 	if len(params) > 1 {
 		c.param = params[1]
 	}
@@ -411,9 +1022,6 @@ func (c *clientHandler) parseLine(line string) {
 
 // Hide parameters from log
 func (c *clientHandler) commandLog(line string) {
-	if strings.Compare(strings.ToUpper(getCommand(line)[0]), secureCommand) == 0 {
-		c.log.info("read from client: %s ********\r\n", secureCommand)
-	} else {
-		c.log.info("read from client: %s", strings.TrimSuffix(line, "\r\n"))
-	}
+	c.log.info("read from client: %s", c.redactor.line(line))
+	c.tail.command(tailFromClient, line)
 }
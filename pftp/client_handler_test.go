@@ -21,7 +21,7 @@ func Test_clientHandler_handleCommands(t *testing.T) {
 	go test.LaunchTestServer(&server, conn, done, serverready, t)
 
 	type fields struct {
-		config *config
+		config *Config
 	}
 
 	tests := []struct {
@@ -34,7 +34,7 @@ func Test_clientHandler_handleCommands(t *testing.T) {
 		{
 			name: "idle_timeout",
 			fields: fields{
-				config: &config{
+				config: &Config{
 					IdleTimeout: 1,
 					RemoteAddr:  "127.0.0.1:21",
 				},
@@ -45,7 +45,7 @@ func Test_clientHandler_handleCommands(t *testing.T) {
 		{
 			name: "max_connection",
 			fields: fields{
-				config: &config{
+				config: &Config{
 					IdleTimeout:    1,
 					MaxConnections: 0,
 					RemoteAddr:     "127.0.0.1:21",
@@ -65,9 +65,7 @@ func Test_clientHandler_handleCommands(t *testing.T) {
 			defer c.Close()
 			clientHandler := newClientHandler(
 				<-conn,
-				tt.fields.config,
-				nil,
-				nil,
+				&FtpServer{config: tt.fields.config},
 				1,
 				&cn,
 			)
@@ -97,7 +95,7 @@ func Test_clientHandler_handleCommand(t *testing.T) {
 	go test.LaunchTestServer(&server, conn, done, serverready, t)
 
 	type fields struct {
-		config *config
+		config *Config
 	}
 	type args struct {
 		line string
@@ -116,7 +114,7 @@ func Test_clientHandler_handleCommand(t *testing.T) {
 		{
 			name: "user_ok",
 			fields: fields{
-				config: &config{
+				config: &Config{
 					IdleTimeout: 3,
 					RemoteAddr:  "127.0.0.1:21",
 				},
@@ -128,7 +126,7 @@ func Test_clientHandler_handleCommand(t *testing.T) {
 		{
 			name: "proxy_invalid_proxyheader",
 			fields: fields{
-				config: &config{
+				config: &Config{
 					IdleTimeout: 5,
 					RemoteAddr:  "127.0.0.1:21",
 				},
@@ -147,7 +145,7 @@ func Test_clientHandler_handleCommand(t *testing.T) {
 		{
 			name: "proxy_invalid_source_ip",
 			fields: fields{
-				config: &config{
+				config: &Config{
 					IdleTimeout: 5,
 					RemoteAddr:  "127.0.0.1:21",
 				},
@@ -166,7 +164,7 @@ func Test_clientHandler_handleCommand(t *testing.T) {
 		{
 			name: "proxy_ok",
 			fields: fields{
-				config: &config{
+				config: &Config{
 					IdleTimeout: 5,
 					RemoteAddr:  "127.0.0.1:21",
 				},
@@ -193,9 +191,7 @@ func Test_clientHandler_handleCommand(t *testing.T) {
 
 			clientHandler := newClientHandler(
 				<-conn,
-				tt.fields.config,
-				nil,
-				nil,
+				&FtpServer{config: tt.fields.config},
 				1,
 				&cn,
 			)
@@ -223,7 +219,7 @@ func Test_clientHandler_TLS_error_type_bug(t *testing.T) {
 	go test.LaunchTestServer(&server, conn, done, serverready, t)
 
 	type fields struct {
-		config *config
+		config *Config
 	}
 
 	tests := []struct {
@@ -236,12 +232,12 @@ func Test_clientHandler_TLS_error_type_bug(t *testing.T) {
 		{
 			name: "tls_err_type_check",
 			fields: fields{
-				config: &config{
+				config: &Config{
 					IdleTimeout:    1,
 					MaxConnections: 5,
 					RemoteAddr:     "127.0.0.1:21",
 					WelcomeMsg:     "TLS test server",
-					TLS: &tlsPair{
+					TLS: &TLSConfig{
 						Cert: "../tls/server.crt",
 						Key:  "../tls/server.key",
 					},
@@ -267,9 +263,7 @@ func Test_clientHandler_TLS_error_type_bug(t *testing.T) {
 			go func() {
 				clientHandler := newClientHandler(
 					<-conn,
-					tt.fields.config,
-					serverTLSConfig,
-					nil,
+					&FtpServer{config: tt.fields.config, serverTLSData: serverTLSConfig},
 					1,
 					&cn,
 				)
@@ -351,7 +345,7 @@ func Test_clientHandler_TLS_Session_Resumption(t *testing.T) {
 	go test.LaunchTestServer(&server, conn, done, serverready, t)
 
 	type fields struct {
-		config *config
+		config *Config
 	}
 
 	tests := []struct {
@@ -364,12 +358,12 @@ func Test_clientHandler_TLS_Session_Resumption(t *testing.T) {
 		{
 			name: "err_type_check",
 			fields: fields{
-				config: &config{
+				config: &Config{
 					IdleTimeout:    1,
 					MaxConnections: 5,
 					RemoteAddr:     "127.0.0.1:21",
 					WelcomeMsg:     "TLS test server",
-					TLS: &tlsPair{
+					TLS: &TLSConfig{
 						Cert: "../tls/server.crt",
 						Key:  "../tls/server.key",
 					},
@@ -395,9 +389,7 @@ func Test_clientHandler_TLS_Session_Resumption(t *testing.T) {
 			go func() {
 				clientHandler := newClientHandler(
 					<-conn,
-					tt.fields.config,
-					serverTLSConfig,
-					nil,
+					&FtpServer{config: tt.fields.config, serverTLSData: serverTLSConfig},
 					1,
 					&cn,
 				)
@@ -461,9 +453,7 @@ func Test_clientHandler_TLS_Session_Resumption(t *testing.T) {
 			go func() {
 				clientHandler := newClientHandler(
 					<-conn,
-					tt.fields.config,
-					serverTLSConfig,
-					nil,
+					&FtpServer{config: tt.fields.config, serverTLSData: serverTLSConfig},
 					1,
 					&cn,
 				)
@@ -0,0 +1,112 @@
+package pftp
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// return timeout of first reply to command. 0 means no timeout.
+// per command timeout is used before pre_auth_command_timeout.
+func (c *Config) commandTimeout(command string, loggedIn bool) time.Duration {
+	if t, ok := c.CommandTimeouts[command]; ok {
+		return time.Duration(t) * time.Second
+	}
+	if !loggedIn && c.PreAuthCommandTimeout > 0 {
+		return time.Duration(c.PreAuthCommandTimeout) * time.Second
+	}
+
+	return 0
+}
+
+// return oldest command still waiting its first reply and its timeout
+func (s *proxyServer) pendingCommandTimeout() (string, time.Duration) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	if len(s.inflight) == 0 || s.headReplied {
+		return "", 0
+	}
+
+	return s.inflight[0], s.config.commandTimeout(s.inflight[0], s.isLoggedin)
+}
+
+// set read deadline of origin by timeout of command waiting reply.
+// deadline is set back to proxy_timeout when timeout is disarmed.
+func (s *proxyServer) armCommandTimeout() {
+	command, timeout := s.pendingCommandTimeout()
+	if timeout <= 0 || s.inDataTransfer.IsSet() {
+		if s.setTimedCommand("") {
+			s.origin.SetReadDeadline(s.idleDeadline())
+		}
+		return
+	}
+
+	s.setTimedCommand(command)
+	s.origin.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// return true when other command was timed
+func (s *proxyServer) setTimedCommand(command string) bool {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	armed := len(s.timedCommand) > 0
+	s.timedCommand = command
+	return armed
+}
+
+// return read deadline of origin without command timeout. zero means none.
+func (s *proxyServer) idleDeadline() time.Time {
+	if s.config.ProxyTimeout <= 0 || s.inDataTransfer.IsSet() {
+		return time.Time{}
+	}
+
+	return time.Now().Add(time.Duration(s.config.ProxyTimeout) * time.Second)
+}
+
+// return command which timed out when err is caused by its timeout
+func (s *proxyServer) timedOutCommand(err error) (string, bool) {
+	nErr, ok := err.(net.Error)
+	if !ok || !nErr.Timeout() {
+		return "", false
+	}
+
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	return s.timedCommand, len(s.timedCommand) > 0
+}
+
+// reply timeout of command to client. transfer commands get 425 and others get 421.
+func (s *proxyServer) replyCommandTimeout(command string) {
+	timeout := s.config.commandTimeout(command, s.isLoggedin)
+	s.log.err("origin did not reply to %s in %s", command, timeout)
+
+	code, msg := 421, s.originTimeoutMsg
+	if isTransferCommand(command) {
+		code, msg = 425, s.dataConnectionMsg
+	}
+	if err := s.sendToClient(strings.TrimSuffix(formatReply(code, msg), "\r\n")); err != nil {
+		s.log.err("cannot send response to client")
+	}
+
+	s.events.emit(&CommandTimeoutEvent{
+		Time:      time.Now(),
+		SessionID: s.sessionID,
+		Origin:    s.originAddr,
+		Command:   command,
+		Timeout:   timeout,
+		Code:      code,
+		History:   s.tail.recent(),
+	})
+}
+
+// return true when command starts data transfer
+func isTransferCommand(command string) bool {
+	switch command {
+	case "RETR", "STOR", "STOU", "APPE", "LIST", "NLST", "MLSD":
+		return true
+	}
+
+	return false
+}
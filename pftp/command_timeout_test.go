@@ -0,0 +1,143 @@
+package pftp
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+func Test_Config_commandTimeout(t *testing.T) {
+	c := &Config{CommandTimeouts: map[string]int{"LIST": 30, "USER": 0}, PreAuthCommandTimeout: 10}
+
+	tests := []struct {
+		name     string
+		command  string
+		loggedIn bool
+		want     time.Duration
+	}{
+		{name: "per_command", command: "LIST", loggedIn: true, want: 30 * time.Second},
+		{name: "pre_auth", command: "PASS", want: 10 * time.Second},
+		{name: "per_command_before_pre_auth", command: "USER", want: 0},
+		{name: "no_timeout", command: "PWD", loggedIn: true, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.commandTimeout(tt.command, tt.loggedIn); got != tt.want {
+				t.Errorf("Config.commandTimeout() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_proxyServer_commandTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  string
+		wantCode int
+		want     string
+	}{
+		{
+			name:     "transfer_command",
+			command:  "LIST",
+			wantCode: 425,
+			want:     "425 Can't open data connection\r\n",
+		},
+		{
+			name:     "other_command",
+			command:  "CWD",
+			wantCode: 421,
+			want:     "421 Service not available (origin not responding)\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin, originPeer := net.Pipe()
+			defer originPeer.Close()
+
+			// origin reads commands and never replies
+			go func() {
+				reader := bufio.NewReader(originPeer)
+				for {
+					if _, err := reader.ReadString('\n'); err != nil {
+						return
+					}
+				}
+			}()
+
+			var client bytes.Buffer
+			events := newEventBus()
+			s := &proxyServer{
+				config:            &Config{CommandTimeouts: map[string]int{tt.command: 1}},
+				log:               &logger{},
+				origin:            origin,
+				originReader:      bufio.NewReader(origin),
+				originWriter:      bufio.NewWriter(origin),
+				clientWriter:      bufio.NewWriter(&client),
+				mutex:             &sync.Mutex{},
+				passThrough:       true,
+				isLoggedin:        true,
+				inDataTransfer:    abool.New(),
+				stopChan:          make(chan struct{}),
+				stopChanDone:      make(chan struct{}),
+				events:            events,
+				originTimeoutMsg:  defaultMessages[msgOriginTimeout],
+				dataConnectionMsg: defaultMessages[msgDataConnection],
+			}
+
+			done := make(chan error)
+			go func() { done <- s.startProxy() }()
+
+			if err := s.sendToOrigin(tt.command + "\r\n"); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Errorf("proxyServer.startProxy() error = nil, want timeout")
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatal("command timeout did not expire")
+			}
+
+			if got := client.String(); got != tt.want {
+				t.Errorf("reply to client = %q, want %q", got, tt.want)
+			}
+			if e, ok := (<-events.ch).(*CommandTimeoutEvent); !ok || e.Command != tt.command || e.Code != tt.wantCode {
+				t.Errorf("CommandTimeoutEvent = %+v", e)
+			}
+		})
+	}
+}
+
+func Test_proxyServer_armCommandTimeout_disarm(t *testing.T) {
+	origin, originPeer := net.Pipe()
+	defer origin.Close()
+	defer originPeer.Close()
+
+	s := &proxyServer{
+		config:         &Config{CommandTimeouts: map[string]int{"CWD": 1}},
+		origin:         origin,
+		isLoggedin:     true,
+		inDataTransfer: abool.New(),
+		inflight:       []string{"CWD"},
+	}
+	s.armCommandTimeout()
+
+	// reply of CWD disarms timeout and origin is idle longer than it
+	s.inflight = nil
+	s.armCommandTimeout()
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		originPeer.Write([]byte("220 ok\r\n"))
+	}()
+
+	if _, err := bufio.NewReader(origin).ReadString('\n'); err != nil {
+		t.Errorf("read after disarmed timeout = %v", err)
+	}
+}
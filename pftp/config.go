@@ -3,12 +3,12 @@ package pftp
 import (
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/sirupsen/logrus"
-
-	"github.com/BurntSushi/toml"
 )
 
 const (
@@ -17,51 +17,528 @@ const (
 	PortRangeLength = 2
 )
 
-type config struct {
-	ListenAddr      string   `toml:"listen_addr"`
-	RemoteAddr      string   `toml:"remote_addr"`
-	IdleTimeout     int      `toml:"idle_timeout"`
-	ProxyTimeout    int      `toml:"proxy_timeout"`
-	TransferTimeout int      `toml:"transfer_timeout"`
-	MaxConnections  int32    `toml:"max_connections"`
-	ProxyProtocol   bool     `toml:"send_proxy_protocol"`
-	WelcomeMsg      string   `toml:"welcome_message"`
-	KeepaliveTime   int      `toml:"keepalive_time"`
-	DataChanProxy   bool     `toml:"data_channel_proxy"`
-	DataPortRange   string   `toml:"data_listen_port_range"`
-	MasqueradeIP    string   `toml:"masquerade_ip"`
-	TransferMode    string   `toml:"transfer_mode"`
-	IgnorePassiveIP bool     `toml:"ignore_passive_ip"`
-	TLS             *tlsPair `toml:"tls"`
+// Config is pftp server configuration.
+// Use DefaultConfig to get config filled with default values.
+type Config struct {
+	ListenAddr                 string                       `toml:"listen_addr"`
+	RemoteAddr                 string                       `toml:"remote_addr"`
+	IdleTimeout                int                          `toml:"idle_timeout"`
+	ProxyTimeout               int                          `toml:"proxy_timeout"`
+	TransferTimeout            int                          `toml:"transfer_timeout"`
+	QuitDuringTransfer         string                       `toml:"quit_during_transfer"`
+	DataListenerTimeout        int                          `toml:"data_listener_timeout"`
+	MaxConnections             int32                        `toml:"max_connections"`
+	FDLimitPolicy              string                       `toml:"fd_limit_policy"`
+	SoftMaxConnections         int32                        `toml:"soft_max_connections"`
+	ConnectionQueueWait        int                          `toml:"connection_queue_wait"`
+	ProxyProtocol              bool                         `toml:"send_proxy_protocol"`
+	WelcomeMsg                 string                       `toml:"welcome_message"`
+	KeepaliveTime              int                          `toml:"keepalive_time"`
+	DataChanProxy              bool                         `toml:"data_channel_proxy"`
+	DataPortRange              string                       `toml:"data_listen_port_range"`
+	DataPortStrategy           string                       `toml:"data_port_strategy"`
+	DataPortRetries            int                          `toml:"data_port_retries"`
+	DataPortRetryInterval      int                          `toml:"data_port_retry_interval"`
+	MasqueradeIP               string                       `toml:"masquerade_ip"`
+	TransferMode               string                       `toml:"transfer_mode"`
+	IgnorePassiveIP            bool                         `toml:"ignore_passive_ip"`
+	ReadOnly                   bool                         `toml:"read_only"`
+	ForceBinary                bool                         `toml:"force_binary"`
+	StrictTransferMode         bool                         `toml:"strict_transfer_mode"`
+	ChmodPolicy                string                       `toml:"chmod_policy"`
+	ChmodMaxMode               string                       `toml:"chmod_max_mode"`
+	TimestampPolicy            string                       `toml:"timestamp_policy"`
+	ListingSort                bool                         `toml:"listing_sort"`
+	ListingHideDotfiles        bool                         `toml:"listing_hide_dotfiles"`
+	ListingHidePatterns        []string                     `toml:"listing_hide_patterns"`
+	ListingBufferSize          int                          `toml:"listing_buffer_size"`
+	UploadTempSuffix           string                       `toml:"upload_temp_suffix"`
+	UploadVerify               string                       `toml:"upload_verify"`
+	UploadVerifyAction         string                       `toml:"upload_verify_action"`
+	AccessSchedules            []string                     `toml:"access_schedules"`
+	WriteSchedules             []string                     `toml:"write_schedules"`
+	ScheduleTimezone           string                       `toml:"schedule_timezone"`
+	DynamicConfig              string                       `toml:"dynamic_config"`
+	DynamicConfigToken         string                       `toml:"dynamic_config_token"`
+	DrainTimeout               int                          `toml:"drain_timeout"`
+	Standby                    bool                         `toml:"standby"`
+	StandbyCheckScript         string                       `toml:"standby_check_script"`
+	StandbyCheckInterval       int                          `toml:"standby_check_interval"`
+	DenyUnresolved             bool                         `toml:"deny_unresolved_origin"`
+	UnresolvedMsg              string                       `toml:"unresolved_origin_message"`
+	ShadowAddr                 string                       `toml:"shadow_addr"`
+	ShadowUploads              bool                         `toml:"shadow_mirror_uploads"`
+	MirrorUploadAddr           string                       `toml:"upload_mirror_addr"`
+	MirrorUploadUser           string                       `toml:"upload_mirror_user"`
+	MirrorUploadPass           string                       `toml:"upload_mirror_pass"`
+	LocalNoop                  bool                         `toml:"local_noop"`
+	NoopForwardInterval        int                          `toml:"noop_forward_interval"`
+	NoopKeepsAlive             bool                         `toml:"noop_keeps_alive"`
+	CommandTimeouts            map[string]int               `toml:"command_timeouts"`
+	PreAuthCommandTimeout      int                          `toml:"pre_auth_command_timeout"`
+	OriginGreetingTimeout      int                          `toml:"origin_greeting_timeout"`
+	OriginProxy                string                       `toml:"origin_proxy"`
+	CircuitBreakerFailures     int                          `toml:"circuit_breaker_failures"`
+	CircuitBreakerCooldown     int                          `toml:"circuit_breaker_cooldown"`
+	OriginConnectionsThreshold int                          `toml:"origin_connections_threshold"`
+	OriginErrorRateThreshold   float64                      `toml:"origin_error_rate_threshold"`
+	FailoverAddrs              []string                     `toml:"failover_addrs"`
+	HostOrigins                map[string]string            `toml:"host_origins"`
+	ParallelConnectDelay       int                          `toml:"parallel_connect_delay"`
+	LenientReplies             bool                         `toml:"lenient_replies"`
+	OriginNotReady             string                       `toml:"origin_not_ready"`
+	OriginNotReadyMaxWait      int                          `toml:"origin_not_ready_max_wait"`
+	StalledTransferTimeout     int                          `toml:"stalled_transfer_timeout"`
+	TransferResumeRetries      int                          `toml:"transfer_resume_retries"`
+	TransferKeepalive          int                          `toml:"transfer_keepalive"`
+	PassiveIPMap               map[string]string            `toml:"passive_ip_map"`
+	PassiveUnroutableIP        string                       `toml:"passive_unroutable_ip"`
+	PassiveIPOverrides         map[string]string            `toml:"passive_unroutable_ip_overrides"`
+	PortAllowlist              []string                     `toml:"port_allowlist"`
+	FXPAllowedPeers            map[string][]string          `toml:"fxp_allowed_peers"`
+	MaxOriginTransfers         int                          `toml:"max_origin_transfers"`
+	OriginTransferLimits       map[string]int               `toml:"origin_transfer_limits"`
+	TransferQueueWait          int                          `toml:"transfer_queue_wait"`
+	SlowStartDuration          int                          `toml:"slow_start_duration"`
+	SlowStartRate              float64                      `toml:"slow_start_rate"`
+	ExposeBackendID            bool                         `toml:"expose_backend_id"`
+	BackendIDSalt              string                       `toml:"backend_id_salt"`
+	CapabilityCacheTTL         int                          `toml:"capability_cache_ttl"`
+	UnsolicitedReplies         map[string]string            `toml:"unsolicited_replies"`
+	OriginDialects             map[string][]string          `toml:"origin_dialects"`
+	ReplyRetries               map[string][]string          `toml:"reply_retries"`
+	ReplyRetryLimit            int                          `toml:"reply_retry_limit"`
+	ReplyRetryBackoff          int                          `toml:"reply_retry_backoff"`
+	TransferStreams            int                          `toml:"transfer_streams"`
+	TransferStreamMinSize      int64                        `toml:"transfer_stream_min_size"`
+	TransferStreamDir          string                       `toml:"transfer_stream_dir"`
+	SpoolUploads               bool                         `toml:"spool_uploads"`
+	ClientModeZ                bool                         `toml:"client_mode_z"`
+	OriginModeZ                bool                         `toml:"origin_mode_z"`
+	SpoolDir                   string                       `toml:"spool_dir"`
+	SpoolMaxBytes              int64                        `toml:"spool_max_bytes"`
+	SpoolWorkers               int                          `toml:"spool_workers"`
+	SpoolRetryLimit            int                          `toml:"spool_retry_limit"`
+	SpoolRetryBackoff          int                          `toml:"spool_retry_backoff"`
+	UnsolicitedReplyMsg        string                       `toml:"unsolicited_reply_message"`
+	RedactCommands             []string                     `toml:"redact_commands"`
+	HashUsernames              bool                         `toml:"hash_usernames"`
+	UsernameHashSalt           string                       `toml:"username_hash_salt"`
+	MaxLoginFailures           int                          `toml:"max_login_failures"`
+	LoginFailureWindow         int                          `toml:"login_failure_window"`
+	BanDuration                int                          `toml:"ban_duration"`
+	BanDB                      string                       `toml:"ban_db"`
+	TarpitThreshold            int                          `toml:"tarpit_threshold"`
+	TarpitDelay                int                          `toml:"tarpit_delay"`
+	TarpitMaxDelay             int                          `toml:"tarpit_max_delay"`
+	ResumptionTokenTTL         int                          `toml:"resumption_token_ttl"`
+	ResumptionSecret           string                       `toml:"resumption_secret"`
+	AdminListenAddr            string                       `toml:"admin_listen_addr"`
+	AdminTokens                map[string]string            `toml:"admin_tokens"`
+	AdminTLSCert               string                       `toml:"admin_tls_cert"`
+	AdminTLSKey                string                       `toml:"admin_tls_key"`
+	AdminClientCA              string                       `toml:"admin_client_ca"`
+	AdminClientRoles           map[string]string            `toml:"admin_client_roles"`
+	AdminAuditLog              string                       `toml:"admin_audit_log"`
+	ThroughputBuckets          []float64                    `toml:"throughput_buckets"`
+	ThroughputMinBytes         int64                        `toml:"throughput_min_bytes"`
+	ThroughputSummaryInterval  int                          `toml:"throughput_summary_interval"`
+	SLOInterval                int                          `toml:"slo_interval"`
+	SLOLoginTarget             float64                      `toml:"slo_login_target"`
+	SLOTransferTarget          float64                      `toml:"slo_transfer_target"`
+	SLOLatencyBuckets          []float64                    `toml:"slo_latency_buckets"`
+	DuplicateUploadWindow      int                          `toml:"duplicate_upload_window"`
+	RejectDuplicateUploads     bool                         `toml:"reject_duplicate_uploads"`
+	MaxOpenFDs                 int                          `toml:"max_open_fds"`
+	MaxRSSBytes                int64                        `toml:"max_rss_bytes"`
+	ResourceCheckInterval      int                          `toml:"resource_check_interval"`
+	MinFreeDiskBytes           int64                        `toml:"min_free_disk_bytes"`
+	MinFreeDiskPercent         float64                      `toml:"min_free_disk_percent"`
+	ResourceShedSessions       int                          `toml:"resource_shed_sessions"`
+	ResourceShedIdle           int                          `toml:"resource_shed_idle"`
+	MetricsAggregateOrigins    bool                         `toml:"metrics_aggregate_origins"`
+	MetricsUserLabels          bool                         `toml:"metrics_user_labels"`
+	MetricsMaxUsers            int                          `toml:"metrics_max_users"`
+	AccountingFile             string                       `toml:"accounting_file"`
+	AccountingFlushInterval    int                          `toml:"accounting_flush_interval"`
+	Messages                   map[string]string            `toml:"messages"`
+	Locale                     string                       `toml:"locale"`
+	Locales                    map[string]map[string]string `toml:"locales"`
+	TLS                        *TLSConfig                   `toml:"tls"`
+	OriginTLSPins              map[string][]TLSPin          `toml:"origin_tls_pins"`
+	TLSKeylogFile              string                       `toml:"tls_keylog_file"`
+	OriginTLSKeylogFile        string                       `toml:"origin_tls_keylog_file"`
+	DebugTLSKeylogAllowed      bool                         `toml:"debug_tls_keylog_allowed"`
+	Production                 bool                         `toml:"production"`
+	Anonymous                  *AnonymousConfig             `toml:"anonymous"`
+	TLSAutoDetect              bool                         `toml:"tls_auto_detect"`
+	TLSDetectTimeout           int                          `toml:"tls_detect_timeout"`
+	ProbeRules                 []ProbeRule                  `toml:"probe_rules"`
+	ProbeDetectTimeout         int                          `toml:"probe_detect_timeout"`
+	SessionTimings             bool                         `toml:"session_timings"`
+	SessionHistory             int                          `toml:"session_history"`
+	CaptureDir                 string                       `toml:"capture_dir"`
+	CaptureMaxBytes            int64                        `toml:"capture_max_bytes"`
+	UserBlockDuration          int                          `toml:"user_block_duration"`
 }
 
-type tlsPair struct {
+// TLSConfig is TLS configuration for client connection
+type TLSConfig struct {
 	Cert        string `toml:"cert"`
 	Key         string `toml:"key"`
 	CACert      string `toml:"ca_cert"`
 	CipherSuite string `toml:"cipher_suite"`
 	MinProtocol string `toml:"min_protocol"`
 	MaxProtocol string `toml:"max_protocol"`
+	// legacy clients asked for client certificate in first handshake
+	EarlyClientCertClients []string `toml:"early_client_cert_clients"`
 }
 
-func loadConfig(path string) (*config, error) {
-	var c config
+// load config file and overlay files on it in order.
+// keys of overlay replace same keys and tables are merged key by key.
+func loadConfig(path string, overlays ...string) (*Config, error) {
+	var c Config
 	defaultConfig(&c)
 
-	_, err := toml.DecodeFile(path, &c)
-	if err != nil {
+	for _, p := range append([]string{path}, overlays...) {
+		if err := DecodeConfigFile(p, &c); err != nil {
+			return nil, fmt.Errorf("%s: %s", p, err.Error())
+		}
+	}
+
+	if err := c.validate(); err != nil {
 		return nil, err
 	}
 
+	return &c, nil
+}
+
+// LoadConfig load and validate config file. overlays of environments in
+// PFTP_ENV are merged on it like NewFtpServer.
+func LoadConfig(confFile string) (*Config, error) {
+	return loadConfig(confFile, configOverlays(confFile)...)
+}
+
+// return overlay files of environments in PFTP_ENV (comma separated).
+// ex) PFTP_ENV=prod and path config.toml -> [config.prod.toml]
+func configOverlays(path string) []string {
+	env := os.Getenv("PFTP_ENV")
+	if len(env) == 0 {
+		return nil
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	overlays := []string{}
+	for _, e := range strings.Split(env, ",") {
+		if e = strings.TrimSpace(e); len(e) > 0 {
+			overlays = append(overlays, base+"."+e+ext)
+		}
+	}
+
+	return overlays
+}
+
+// DefaultConfig return new config filled with default values
+func DefaultConfig() *Config {
+	var c Config
+	defaultConfig(&c)
+
+	return &c
+}
+
+// validate config and normalize values
+func (c *Config) validate() error {
 	// validate Data listen port randg
 	if err := dataPortRangeValidation(c.DataPortRange); err != nil {
 		logrus.Debug(err)
 		c.DataPortRange = ""
 	}
+	switch c.DataPortStrategy {
+	case "":
+		c.DataPortStrategy = dataPortRandom
+	case dataPortRandom, dataPortSequential, dataPortLRU:
+	default:
+		return fmt.Errorf("configuration error: data_port_strategy must be random, sequential or lru")
+	}
+	if c.DataPortRetries < -1 {
+		return fmt.Errorf("configuration error: data_port_retries must be -1 (no retry) or more")
+	}
+	if c.DataPortRetryInterval < 0 {
+		return fmt.Errorf("configuration error: data_port_retry_interval must not be negative")
+	}
+	if err := validateProbeRules(c.ProbeRules); err != nil {
+		return err
+	}
+	if c.ProbeDetectTimeout <= 0 {
+		c.ProbeDetectTimeout = 100
+	}
+	switch c.QuitDuringTransfer {
+	case "":
+		c.QuitDuringTransfer = quitFinishTransfer
+	case quitFinishTransfer, quitAbortTransfer:
+	default:
+		return fmt.Errorf("configuration error: quit_during_transfer must be finish or abort")
+	}
+	for name, policy := range map[string]*string{"chmod_policy": &c.ChmodPolicy, "timestamp_policy": &c.TimestampPolicy} {
+		switch *policy {
+		case "":
+			*policy = attributeAllow
+		case attributeAllow, attributeDeny, attributeClamp:
+		default:
+			return fmt.Errorf("configuration error: %s must be allow, deny or clamp", name)
+		}
+	}
+	if len(c.ChmodMaxMode) == 0 {
+		c.ChmodMaxMode = "0644"
+	}
+	if _, err := parseFileMode(c.ChmodMaxMode); err != nil {
+		return fmt.Errorf("configuration error: chmod_max_mode: %s", err.Error())
+	}
+	if c.TarpitThreshold > 0 {
+		if c.LoginFailureWindow <= 0 {
+			return fmt.Errorf("configuration error: tarpit_threshold needs login_failure_window")
+		}
+		if c.TarpitDelay <= 0 || c.TarpitMaxDelay < c.TarpitDelay {
+			return fmt.Errorf("configuration error: tarpit_delay must be positive and not over tarpit_max_delay")
+		}
+	}
+	if c.SessionHistory < 0 {
+		return fmt.Errorf("configuration error: session_history must not be negative")
+	}
+	if err := validateCapture(c); err != nil {
+		return err
+	}
+	if c.UserBlockDuration <= 0 {
+		c.UserBlockDuration = 3600
+	}
 
 	// validate Masquerade IP
 	if (len(c.MasqueradeIP) > 0) && (net.ParseIP(c.MasqueradeIP)) == nil {
-		return nil, fmt.Errorf("configuration error: Masquerade IP is wrong")
+		return fmt.Errorf("configuration error: Masquerade IP is wrong")
+	}
+
+	// queue is between soft and hard limit. max_connections 0 is derived
+	// from file descriptor limit at startup.
+	if c.SoftMaxConnections > 0 && c.MaxConnections > 0 && c.SoftMaxConnections >= c.MaxConnections {
+		return fmt.Errorf("configuration error: soft_max_connections must be less than max_connections")
+	}
+
+	switch c.FDLimitPolicy {
+	case "":
+		c.FDLimitPolicy = fdLimitClamp
+	case fdLimitClamp, fdLimitWarn:
+	default:
+		return fmt.Errorf("configuration error: fd_limit_policy must be clamp or warn")
+	}
+
+	switch c.OriginNotReady {
+	case "":
+		c.OriginNotReady = originNotReadyWait
+	case originNotReadyWait, originNotReadyRetry, originNotReadyFailover:
+	default:
+		return fmt.Errorf("configuration error: origin_not_ready must be wait, retry or failover")
+	}
+	if c.OriginNotReadyMaxWait <= 0 {
+		c.OriginNotReadyMaxWait = 60
+	}
+
+	// tokens must be readable by next process
+	if c.ResumptionTokenTTL > 0 && len(c.ResumptionSecret) == 0 {
+		return fmt.Errorf("configuration error: resumption_secret is required by resumption_token_ttl")
+	}
+
+	if c.AccountingFlushInterval <= 0 {
+		c.AccountingFlushInterval = 60
+	}
+
+	// validate passive IP translation map
+	for from, to := range c.PassiveIPMap {
+		if net.ParseIP(from) == nil || net.ParseIP(to) == nil {
+			return fmt.Errorf("configuration error: passive IP map %s = %s is wrong", from, to)
+		}
+	}
+
+	switch c.PassiveUnroutableIP {
+	case "":
+		c.PassiveUnroutableIP = passiveUnroutableControl
+	case passiveUnroutableControl, passiveUnroutableRewrite, passiveUnroutableReject:
+	default:
+		return fmt.Errorf("configuration error: passive_unroutable_ip must be control, rewrite or reject")
+	}
+	for origin, ip := range c.PassiveIPOverrides {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("configuration error: passive unroutable IP override %s = %s is wrong", origin, ip)
+		}
+	}
+
+	if err := validatePortAllowlist(c.PortAllowlist); err != nil {
+		return err
+	}
+	if err := validateFXPAllowedPeers(c.FXPAllowedPeers); err != nil {
+		return err
+	}
+	if err := validateTLSPins(c.OriginTLSPins); err != nil {
+		return err
+	}
+
+	if err := validateListingPatterns(c.ListingHidePatterns); err != nil {
+		return err
+	}
+	if err := validateUploadVerify(c); err != nil {
+		return err
+	}
+
+	// validate access schedules and their time zone
+	if err := validateSchedules(append(append([]string{}, c.AccessSchedules...), c.WriteSchedules...)); err != nil {
+		return err
+	}
+	if _, err := newScheduleClock(c); err != nil {
+		return err
+	}
+
+	if c.OriginErrorRateThreshold < 0 || c.OriginErrorRateThreshold > 1 {
+		return fmt.Errorf("configuration error: origin_error_rate_threshold must be between 0 and 1")
+	}
+
+	if err := validateAdminAuth(c); err != nil {
+		return err
+	}
+
+	// implicit TLS needs certificate
+	if c.TLSAutoDetect && c.TLS == nil {
+		return fmt.Errorf("configuration error: tls_auto_detect needs [tls]")
+	}
+	if c.TLSDetectTimeout <= 0 {
+		c.TLSDetectTimeout = 500
+	}
+	if c.TLS != nil {
+		for _, a := range c.TLS.EarlyClientCertClients {
+			if _, _, err := net.ParseCIDR(a); err != nil && net.ParseIP(a) == nil {
+				return fmt.Errorf("configuration error: early_client_cert_clients %s is wrong", a)
+			}
+		}
+	}
+
+	if a := c.Anonymous; a != nil && a.Enabled {
+		if len(a.Users) == 0 {
+			a.Users = []string{"anonymous", "ftp"}
+		}
+		if len(a.Origin) > 0 {
+			if err := validateNetworkAddr("anonymous origin", a.Origin); err != nil {
+				return err
+			}
+		}
+		if a.IdleTimeout <= 0 {
+			a.IdleTimeout = 120
+		}
+		if len(a.AccountingUser) == 0 {
+			a.AccountingUser = "anonymous"
+		}
+	}
+
+	// validate unix domain socket addresses
+	if err := validateNetworkAddr("listen_addr", c.ListenAddr); err != nil {
+		return err
+	}
+	if err := validateNetworkAddr("remote_addr", c.RemoteAddr); err != nil {
+		return err
+	}
+
+	// validate egress proxy to origins
+	if err := validateEgressProxy(c.OriginProxy); err != nil {
+		return err
+	}
+
+	// validate unsolicited reply handling
+	if err := validateUnsolicitedReplies(c.UnsolicitedReplies, c.UnsolicitedReplyMsg); err != nil {
+		return err
+	}
+
+	// transient replies retried per command
+	retries, err := validateReplyRetries(c.ReplyRetries)
+	if err != nil {
+		return err
+	}
+	c.ReplyRetries = retries
+	if c.ReplyRetryLimit <= 0 {
+		c.ReplyRetryLimit = 3
+	}
+	if c.ReplyRetryBackoff <= 0 {
+		c.ReplyRetryBackoff = 500
+	}
+	if c.TransferStreamMinSize <= 0 {
+		c.TransferStreamMinSize = 64 << 20
+	}
+	if c.SpoolMaxBytes <= 0 {
+		c.SpoolMaxBytes = 10 << 30
+	}
+	if c.SpoolWorkers <= 0 {
+		c.SpoolWorkers = 4
+	}
+	if c.SpoolRetryLimit <= 0 {
+		c.SpoolRetryLimit = 10
+	}
+	if c.SpoolRetryBackoff <= 0 {
+		c.SpoolRetryBackoff = 30
+	}
+	for i, le := range c.ThroughputBuckets {
+		if le <= 0 || (i > 0 && le <= c.ThroughputBuckets[i-1]) {
+			return fmt.Errorf("configuration error: throughput_buckets must be positive and ascending")
+		}
+	}
+	if c.ThroughputMinBytes <= 0 {
+		c.ThroughputMinBytes = 64 * 1024
+	}
+	if c.ThroughputSummaryInterval <= 0 {
+		c.ThroughputSummaryInterval = 60
+	}
+	if c.SLOLoginTarget == 0 {
+		c.SLOLoginTarget = 0.99
+	}
+	if c.SLOTransferTarget == 0 {
+		c.SLOTransferTarget = 0.99
+	}
+	if c.SLOLoginTarget < 0 || c.SLOLoginTarget >= 1 || c.SLOTransferTarget < 0 || c.SLOTransferTarget >= 1 {
+		return fmt.Errorf("configuration error: slo_login_target and slo_transfer_target must be between 0 and 1")
+	}
+	for i, le := range c.SLOLatencyBuckets {
+		if le <= 0 || (i > 0 && le <= c.SLOLatencyBuckets[i-1]) {
+			return fmt.Errorf("configuration error: slo_latency_buckets must be positive and ascending")
+		}
+	}
+	if c.ResourceCheckInterval <= 0 {
+		c.ResourceCheckInterval = 5
+	}
+	if c.StandbyCheckInterval < 0 {
+		return fmt.Errorf("configuration error: standby_check_interval must not be negative")
+	}
+	if c.ResourceShedSessions <= 0 {
+		c.ResourceShedSessions = 5
+	}
+	if c.ResourceShedIdle <= 0 {
+		c.ResourceShedIdle = 60
+	}
+	if err := validateDiskThresholds(c); err != nil {
+		return err
+	}
+
+	// commands of timeouts are case insensitive
+	if len(c.CommandTimeouts) > 0 {
+		timeouts := map[string]int{}
+		for command, t := range c.CommandTimeouts {
+			timeouts[strings.ToUpper(command)] = t
+		}
+		c.CommandTimeouts = timeouts
+	}
+
+	// host names of HOST command are case insensitive
+	if len(c.HostOrigins) > 0 {
+		hosts := map[string]string{}
+		for host, addr := range c.HostOrigins {
+			hosts[strings.ToLower(host)] = addr
+		}
+		c.HostOrigins = hosts
 	}
 
 	// validate Transfer mode config
@@ -76,24 +553,54 @@ func loadConfig(path string) (*config, error) {
 	case "CLIENT":
 		break
 	default:
-		return nil, fmt.Errorf("configuration error: Transfer mode config is wrong")
+		return fmt.Errorf("configuration error: Transfer mode config is wrong")
 	}
 
-	return &c, nil
+	return nil
 }
 
-func defaultConfig(config *config) {
+func defaultConfig(config *Config) {
 	config.ListenAddr = "127.0.0.1:2121"
 	config.IdleTimeout = 900
 	config.ProxyTimeout = 900
 	config.TransferTimeout = 900
+	config.QuitDuringTransfer = quitFinishTransfer
+	config.ChmodPolicy = attributeAllow
+	config.ChmodMaxMode = "0644"
+	config.TimestampPolicy = attributeAllow
+	config.UploadVerify = verifyOff
+	config.UploadVerifyAction = verifyWarn
 	config.KeepaliveTime = 900
 	config.ProxyProtocol = false
 	config.DataChanProxy = false
 	config.DataPortRange = ""
+	config.DataPortStrategy = dataPortRandom
+	config.DataPortRetries = connectionTimeout
+	config.DataPortRetryInterval = 1000
 	config.WelcomeMsg = "FTP proxy ready"
 	config.TransferMode = "CLIENT"
 	config.IgnorePassiveIP = false
+	config.ReadOnly = false
+	config.DenyUnresolved = false
+	config.UnresolvedMsg = "Access denied"
+	config.LocalNoop = false
+	config.NoopForwardInterval = 60
+	config.NoopKeepsAlive = true
+	config.OriginGreetingTimeout = connectionTimeout
+	config.SlowStartRate = 10
+	config.ConnectionQueueWait = 30
+	config.CircuitBreakerCooldown = 30
+	config.AccountingFlushInterval = 60
+	config.MetricsMaxUsers = 100
+	config.LoginFailureWindow = 300
+	config.TarpitDelay = 500
+	config.TarpitMaxDelay = 10000
+	config.BanDuration = 600
+	config.UserBlockDuration = 3600
+	config.StandbyCheckInterval = 2
+	config.CaptureMaxBytes = 10 << 20
+	config.UnsolicitedReplyMsg = "{{.Text}}"
+	config.ListingBufferSize = 8 * 1024 * 1024
 }
 
 func dataPortRangeValidation(r string) error {
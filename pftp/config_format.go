@@ -0,0 +1,151 @@
+package pftp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DecodeConfigFile decode config file to v by toml tags of v. format is
+// detected by extension: .json is JSON, .yaml and .yml are YAML and others
+// are TOML. JSON and YAML are converted to TOML, so all formats are decoded
+// and validated in same way.
+func DecodeConfigFile(path string, v interface{}) error {
+	var tree map[string]interface{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		if err := d.Decode(&tree); err != nil {
+			return err
+		}
+	case ".yaml", ".yml":
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(b, &tree); err != nil {
+			return err
+		}
+	default:
+		_, err := toml.DecodeFile(path, v)
+		return err
+	}
+
+	table, err := tomlValue(tree, reflect.TypeOf(v))
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(table); err != nil {
+		return err
+	}
+	_, err = toml.Decode(buf.String(), v)
+
+	return err
+}
+
+// convert decoded JSON or YAML value to value encodable as TOML.
+// integral numbers are integers unless t is float, and null values are
+// removed. t is type decoding value, nil when it is not known.
+func tomlValue(v interface{}, t reflect.Type) (interface{}, error) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		table := map[string]interface{}{}
+		for key, value := range v {
+			if value == nil {
+				continue
+			}
+			tv, err := tomlValue(value, tomlFieldType(t, key))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err.Error())
+			}
+			table[key] = tv
+		}
+		return table, nil
+	case map[interface{}]interface{}:
+		// YAML map with keys like 421
+		table := map[string]interface{}{}
+		for key, value := range v {
+			table[fmt.Sprint(key)] = value
+		}
+		return tomlValue(table, t)
+	case []interface{}:
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+		array := make([]interface{}, 0, len(v))
+		for _, value := range v {
+			tv, err := tomlValue(value, elem)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, tv)
+		}
+		return array, nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil && !isFloatType(t) {
+			return n, nil
+		}
+		return v.Float64()
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < math.MaxInt64 && !isFloatType(t) {
+			return int64(v), nil
+		}
+		return v, nil
+	case int:
+		if isFloatType(t) {
+			return float64(v), nil
+		}
+		return int64(v), nil
+	case nil:
+		return nil, fmt.Errorf("null in array is not supported")
+	}
+
+	return v, nil
+}
+
+// return type of key in struct or map type t. nil when it is not known.
+func tomlFieldType(t reflect.Type, key string) reflect.Type {
+	if t == nil {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem()
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("toml"), ",")[0]
+			if name == key || (len(name) == 0 && strings.EqualFold(f.Name, key)) {
+				return f.Type
+			}
+		}
+	}
+
+	return nil
+}
+
+func isFloatType(t reflect.Type) bool {
+	return t != nil && (t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64)
+}
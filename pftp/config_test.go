@@ -0,0 +1,184 @@
+package pftp
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_loadConfig_overlays(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, body string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	base := write("config.toml", `
+remote_addr = "base:21"
+idle_timeout = 60
+failover_addrs = ["a:21", "b:21"]
+
+[messages]
+welcome = "hello"
+banned = "go away"
+`)
+	prod := write("config.prod.toml", `
+remote_addr = "prod:21"
+failover_addrs = ["c:21"]
+
+[messages]
+banned = "banned in prod"
+`)
+	broken := write("config.broken.toml", `remote_addr = `)
+
+	tests := []struct {
+		name     string
+		overlays []string
+		want     func(c *Config) bool
+		wantErr  bool
+	}{
+		{
+			name: "base_only",
+			want: func(c *Config) bool {
+				return c.RemoteAddr == "base:21" && len(c.FailoverAddrs) == 2
+			},
+		},
+		{
+			name:     "prod",
+			overlays: []string{prod},
+			want: func(c *Config) bool {
+				return c.RemoteAddr == "prod:21" && c.IdleTimeout == 60 &&
+					reflect.DeepEqual(c.FailoverAddrs, []string{"c:21"}) &&
+					reflect.DeepEqual(c.Messages, map[string]string{"welcome": "hello", "banned": "banned in prod"})
+			},
+		},
+		{
+			name:     "missing",
+			overlays: []string{filepath.Join(dir, "config.dev.toml")},
+			wantErr:  true,
+		},
+		{
+			name:     "broken",
+			overlays: []string{broken},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := loadConfig(base, tt.overlays...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !tt.want(c) {
+				t.Errorf("loadConfig() = %+v", c)
+			}
+		})
+	}
+}
+
+func Test_configOverlays(t *testing.T) {
+	tests := []struct {
+		env  string
+		want []string
+	}{
+		{env: "", want: nil},
+		{env: "prod", want: []string{"/etc/pftp/config.prod.toml"}},
+		{env: "prod, tokyo", want: []string{"/etc/pftp/config.prod.toml", "/etc/pftp/config.tokyo.toml"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			os.Setenv("PFTP_ENV", tt.env)
+			defer os.Unsetenv("PFTP_ENV")
+
+			if got := configOverlays("/etc/pftp/config.toml"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("configOverlays() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loadConfig_formats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.toml": `
+remote_addr = "origin:21"
+idle_timeout = 60
+failover_addrs = ["a:21"]
+origin_error_rate_threshold = 0.5
+slow_start_rate = 2.0
+throughput_buckets = [1.0, 2.5]
+
+[unsolicited_replies]
+421 = "suppress"
+
+[tls]
+cert = "server.crt"
+`,
+		"config.json": `{
+  "remote_addr": "origin:21",
+  "idle_timeout": 60,
+  "failover_addrs": ["a:21"],
+  "origin_error_rate_threshold": 0.5,
+  "slow_start_rate": 2,
+  "throughput_buckets": [1, 2.5],
+  "origin_proxy": null,
+  "unsolicited_replies": {"421": "suppress"},
+  "tls": {"cert": "server.crt"}
+}`,
+		"config.yml": `
+remote_addr: origin:21
+idle_timeout: 60
+failover_addrs:
+  - a:21
+origin_error_rate_threshold: 0.5
+slow_start_rate: 2
+throughput_buckets: [1, 2.5]
+unsolicited_replies:
+  421: suppress
+tls:
+  cert: server.crt
+`,
+		"broken.json": `{"idle_timeout": "60"}`,
+		"invalid.yaml": `max_connections: 10
+soft_max_connections: 20
+`,
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "config.toml"},
+		{name: "config.json"},
+		{name: "config.yml"},
+		{name: "broken.json", wantErr: true},
+		{name: "invalid.yaml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := loadConfig(filepath.Join(dir, tt.name))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if c.RemoteAddr != "origin:21" || c.IdleTimeout != 60 || c.OriginErrorRateThreshold != 0.5 ||
+				c.SlowStartRate != 2 || !reflect.DeepEqual(c.ThroughputBuckets, []float64{1, 2.5}) ||
+				!reflect.DeepEqual(c.FailoverAddrs, []string{"a:21"}) ||
+				!reflect.DeepEqual(c.UnsolicitedReplies, map[string]string{"421": "suppress"}) ||
+				c.TLS == nil || c.TLS.Cert != "server.crt" {
+				t.Errorf("loadConfig() = %+v", c)
+			}
+		})
+	}
+}
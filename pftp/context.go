@@ -1,12 +1,217 @@
 package pftp
 
+import "os"
+
 // Context struct got remote server address
 type Context struct {
 	RemoteAddr string
+	// FailoverAddrs are tried in order when RemoteAddr is not available
+	FailoverAddrs []string
+	// OriginProxy is egress proxy URL (http:// or socks5://) used to reach
+	// origins. empty means direct connection.
+	OriginProxy string
+	// OriginDialer dials origins instead of OS network stack when set.
+	// It is initialized by WithOriginDialer option and can be changed per route.
+	OriginDialer OriginDialer
+	// BackendID is opaque origin ID shown to client when expose_backend_id
+	// is enabled. empty means it is made from origin address.
+	BackendID string
+	// Host is host name sent by HOST command (RFC 7151) in lower case.
+	// empty when client did not send HOST.
+	Host string
+	// SlowStartDuration is seconds to ramp new sessions to recovered
+	// origin in RemoteAddr and FailoverAddrs. 0 means disabled.
+	SlowStartDuration int
+	// ReadOnly blocks all mutating commands when true.
+	// It is initialized from config and can be changed by middleware.
+	ReadOnly bool
+	// Anonymous is true after USER of anonymous user in [anonymous]. such
+	// session is read-only even when middleware changes ReadOnly.
+	Anonymous bool
+	// TransferRate limits bytes/sec of each data connection. 0 means unlimited.
+	// It is set for anonymous sessions and can be changed by middleware.
+	// It works only with data_channel_proxy.
+	TransferRate int64
+	// Timings records time spent by session when session_timings is enabled.
+	// nil otherwise. middleware can add its own kinds of time.
+	Timings *SessionTimings
+	// Transfers counts bytes and file transfers of session relayed by data
+	// channel proxy. bytes of transfer in progress are counted live.
+	Transfers *TransferCounters
+	// ForceBinary rejects TYPE other than I with 504 and sends TYPE I to origin
+	// before transfers. It is initialized from config and can be changed by middleware.
+	ForceBinary bool
+	// ChmodPolicy is allow, deny or clamp of SITE CHMOD. clamp masks mode by
+	// ChmodMaxMode (0644 makes 0777 0644). TimestampPolicy is allow, deny or
+	// clamp of MFMT and SITE UTIME, and clamp sets timestamps in future to now.
+	// They are initialized from config and can be changed by middleware.
+	ChmodPolicy     string
+	ChmodMaxMode    os.FileMode
+	TimestampPolicy string
+	// StrictTransferMode rejects MODE other than S and STRU other than F with 504.
+	// It is initialized from config and can be changed by middleware.
+	StrictTransferMode bool
+	// ListingSort, ListingHideDotfiles and ListingHidePatterns are policies of
+	// LIST, NLST and MLSD listings relayed by data channel proxy. pattern without
+	// "/" is matched with file names and pattern with "/" with absolute paths.
+	// commands to paths matched with ListingHidePatterns are rejected with 550.
+	// They are initialized from config.
+	ListingSort         bool
+	ListingHideDotfiles bool
+	ListingHidePatterns []string
+	// UploadTempSuffix is added to file name of STOR sent to origin. file is renamed
+	// to requested name by RNFR and RNTO after origin completed upload, so partial
+	// files are never seen with final name. empty means disabled.
+	// It is initialized from config and can be changed by middleware.
+	UploadTempSuffix string
+	// UploadVerify compares completed STOR with SIZE ("size") and also XMD5 or
+	// HASH ("checksum") of origin, and UploadVerifyAction is reply on mismatch:
+	// "warn" replies 451 instead of 226 and "log" only reports it. upload in
+	// ASCII type is not verified. "off" means disabled.
+	// They are initialized from config and can be changed by middleware.
+	UploadVerify       string
+	UploadVerifyAction string
+	// AccessSchedules are cron-like windows "minute hour day month weekday" when
+	// login is allowed, and WriteSchedules are windows of mutating commands.
+	// empty means any time. They are initialized from config and can be changed
+	// by middleware (USER middleware for AccessSchedules).
+	AccessSchedules []string
+	WriteSchedules  []string
+	// OriginTLSPins are pins of certificates of origin checked on TLS connections
+	// to origin. empty means pins of origin in origin_tls_pins config are used.
+	// It can be set by middleware per route before origin is connected.
+	OriginTLSPins []TLSPin
+	// ShadowAddr is address of shadow origin. commands are mirrored
+	// to it and its responses are discarded. empty means disabled.
+	ShadowAddr string
+	// PassiveIPMap translates IP advertised in origin PASV reply to
+	// IP used for data connection. it is initialized from config.
+	PassiveIPMap map[string]string
+	// TransferKeepalive is TCP keepalive period in seconds of control
+	// connections during data transfer. 0 means keepalive_time is used.
+	TransferKeepalive int
+	// Locale selects messages of proxy generated replies from [locales] config.
+	// empty means default messages. it is initialized from config.
+	Locale string
+	// RootPath jails session in this directory of origin. path arguments are
+	// resolved by proxy and paths outside of it are rejected with 550.
+	// commands whose paths proxy does not know are rejected too.
+	// empty means disabled.
+	RootPath string
+	// UploadMirror receives copy of uploaded files. nil means disabled.
+	UploadMirror UploadMirror
+	// Resumption is routing of previous session given by SITE RESUME before login.
+	// nil when client sent no valid token or token of other user. middleware of USER
+	// can set RemoteAddr from it without querying resolver.
+	Resumption *Resumption
+	// ResumptionData is opaque data stored in resumption token issued by SITE TOKEN.
+	ResumptionData string
+	// ReplyRetries is transient reply codes of origin retried per command
+	// (ex. {"DELE": {"450"}}). command is resent after backoff up to
+	// reply_retry_limit times before reply is sent to client. data transfer
+	// commands are not retried. it is initialized from config.
+	ReplyRetries map[string][]string
+	// TransferStreams is number of origin data connections used at once by RETR
+	// of large file. 0 and 1 mean single connection. it is initialized from config.
+	TransferStreams int
+	// SpoolUploads receives STOR into spool and replies 226 before file is
+	// delivered to origin in background. it needs spool_dir or spool store
+	// and is initialized from config.
+	SpoolUploads bool
+	// ClientModeZ accepts MODE Z of client and compresses client leg of data
+	// connections by proxy. OriginModeZ sends MODE Z to origin and compresses
+	// origin leg. they are decided separately and initialized from config.
+	ClientModeZ bool
+	OriginModeZ bool
+	// Notices are sent to session as leading lines of next 200, 226, 230 or 250
+	// reply. middleware can append them. they are cleared after middleware call.
+	Notices []string
+
+	// negotiated protocol details. these are set by pftp and
+	// updated before each middleware call. changing them has no effect.
+
+	// TLS is true when client control connection is on TLS
+	TLS bool
+	// TLSImplicit is true when TLS was detected on connect by tls_auto_detect
+	TLSImplicit bool
+	// TLSVersion and TLSCipherSuite are name of negotiated TLS parameters
+	TLSVersion     string
+	TLSCipherSuite string
+	// TLSServerName is SNI and TLSNegotiatedProtocol is ALPN protocol sent by client
+	TLSServerName         string
+	TLSNegotiatedProtocol string
+	// ProxyProtocol is true when client connection had PROXY protocol header
+	ProxyProtocol bool
+	// SessionID is ID of session shown by admin API and events. ClientAddr is
+	// address of client, which is given by PROXY protocol header when it is sent.
+	SessionID  uint64
+	ClientAddr string
+	// DataMode is client data connection mode in effect (PORT, EPRT, PASV or EPSV)
+	DataMode string
+	// OriginFeatures is FEAT set of origin. it is empty until FEAT is sent to origin.
+	OriginFeatures []string
+	// OriginSystem is SYST reply text of origin. it is empty until capabilities are cached.
+	OriginSystem string
 }
 
-func newContext(c *config) *Context {
+func newContext(c *Config) *Context {
+	mode, _ := parseFileMode(c.ChmodMaxMode)
 	return &Context{
-		RemoteAddr: c.RemoteAddr,
+		RemoteAddr:          c.RemoteAddr,
+		FailoverAddrs:       append([]string{}, c.FailoverAddrs...),
+		OriginProxy:         c.OriginProxy,
+		ReadOnly:            c.ReadOnly,
+		ForceBinary:         c.ForceBinary,
+		StrictTransferMode:  c.StrictTransferMode,
+		ChmodPolicy:         c.ChmodPolicy,
+		ChmodMaxMode:        mode,
+		TimestampPolicy:     c.TimestampPolicy,
+		ListingSort:         c.ListingSort,
+		ListingHideDotfiles: c.ListingHideDotfiles,
+		ListingHidePatterns: append([]string{}, c.ListingHidePatterns...),
+		UploadTempSuffix:    c.UploadTempSuffix,
+		UploadVerify:        c.UploadVerify,
+		UploadVerifyAction:  c.UploadVerifyAction,
+		AccessSchedules:     append([]string{}, c.AccessSchedules...),
+		WriteSchedules:      append([]string{}, c.WriteSchedules...),
+		SlowStartDuration:   c.SlowStartDuration,
+		ShadowAddr:          c.ShadowAddr,
+		Locale:              c.Locale,
+		PassiveIPMap:        copyPassiveIPMap(c.PassiveIPMap),
+		ReplyRetries:        copyReplyRetries(c.ReplyRetries),
+		TransferStreams:     c.TransferStreams,
+		SpoolUploads:        c.SpoolUploads,
+		ClientModeZ:         c.ClientModeZ,
+		OriginModeZ:         c.OriginModeZ,
+		TransferKeepalive:   c.TransferKeepalive,
+		UploadMirror:        newFTPUploadMirror(c),
+	}
+}
+
+// return copy of passive_ip_map, so middleware changes only its session
+func copyPassiveIPMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	copied := make(map[string]string, len(m))
+	for from, to := range m {
+		copied[from] = to
 	}
+
+	return copied
+}
+
+// return copy of reply_retries, so middleware changes only its session
+func copyReplyRetries(m map[string][]string) map[string][]string {
+	if m == nil {
+		return nil
+	}
+
+	copied := make(map[string][]string, len(m))
+	for command, codes := range m {
+		copied[command] = append([]string{}, codes...)
+	}
+
+	return copied
 }
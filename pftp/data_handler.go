@@ -5,11 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tevino/abool"
@@ -25,13 +25,49 @@ const (
 type dataHandler struct {
 	clientConn         connector
 	originConn         connector
-	config             *config
+	config             *Config
 	log                *logger
 	tlsDataSet         *tlsDataSet
 	needTLSForTransfer *abool.AtomicBool
 	inDataTransfer     *abool.AtomicBool
 	closed             bool
 	mutex              *sync.Mutex
+	mirrors            []*mirrorWriter
+	events             *eventBus
+	metrics            *metrics
+	sessionID          uint64
+	lastActivity       int64         // unix nano time of last data read. accessed atomically
+	duration           time.Duration // of copying data. set when StartDataTransfer returns
+	transferred        int64         // accessed atomically
+	passiveIPMap       map[string]string
+	transferKeepalive  int    // seconds. 0 means use keepalive_time
+	originProxy        string // egress proxy URL to reach origin
+	originDialer       OriginDialer
+	originAddr         string           // key of passive_unroutable_ip_overrides
+	transferRate       int64            // bytes/sec of each direction. 0 means unlimited
+	idleTimeout        int              // of control connection. 0 means idle_timeout
+	resume             *transferResume  // nil when RETR is not resumed
+	listing            *listingPolicy   // nil when listing is streamed as is
+	stripes            *stripedTransfer // nil when RETR is not striped
+	spool              *spoolWriter     // nil when STOR is not spooled
+	clientModeZ        bool             // legs compressed by MODE Z
+	originModeZ        bool
+	path               string // path of file transfer and REST offset of RETR
+	offset             int64
+	clientAborted      bool // ABOR received during transfer. guarded by mutex
+	listeners          *dataListenerGC
+	ports              *dataPortAllocator
+	listenedAt         time.Time // client listener opened
+	accepting          bool      // client listener accepts after transfer command. guarded by mutex
+
+	// check of other hosts connecting to client listener (FXP). clientIP of
+	// session may differ from peer of control connection by PROXY protocol.
+	// not checked when allowPeer is nil
+	clientIP  string
+	allowPeer func(ip string) bool
+
+	// hash of uploaded data verified with origin after STOR. nil when not verified
+	digest *uploadDigest
 }
 
 type connector struct {
@@ -49,7 +85,7 @@ type connector struct {
 }
 
 // Make listener for data connection
-func newDataHandler(config *config, log *logger, clientConn net.Conn, originConn net.Conn, mode string, tlsDataSet *tlsDataSet, transferOverTLS *abool.AtomicBool, inDataTransfer *abool.AtomicBool) (*dataHandler, error) {
+func newDataHandler(config *Config, log *logger, clientConn net.Conn, originConn net.Conn, mode string, tlsDataSet *tlsDataSet, transferOverTLS *abool.AtomicBool, inDataTransfer *abool.AtomicBool, ports *dataPortAllocator) (*dataHandler, error) {
 	var err error
 
 	d := &dataHandler{
@@ -76,15 +112,18 @@ func newDataHandler(config *config, log *logger, clientConn net.Conn, originConn
 		needTLSForTransfer: transferOverTLS,
 		closed:             false,
 		mutex:              &sync.Mutex{},
+		passiveIPMap:       config.PassiveIPMap,
+		transferKeepalive:  config.TransferKeepalive,
+		ports:              ports,
 	}
 
 	if d.originConn.communicationConn != nil {
-		d.originConn.originalRemoteIP, _, _ = net.SplitHostPort(originConn.RemoteAddr().String())
+		d.originConn.originalRemoteIP = addrIP(originConn.RemoteAddr())
 		d.originConn.localIP, d.originConn.localPort, _ = net.SplitHostPort(originConn.LocalAddr().String())
 	}
 
 	if d.clientConn.communicationConn != nil {
-		d.clientConn.originalRemoteIP, _, _ = net.SplitHostPort(clientConn.RemoteAddr().String())
+		d.clientConn.originalRemoteIP = addrIP(clientConn.RemoteAddr())
 		d.clientConn.localIP, d.clientConn.localPort, _ = net.SplitHostPort(clientConn.LocalAddr().String())
 	}
 
@@ -102,6 +141,7 @@ func newDataHandler(config *config, log *logger, clientConn net.Conn, originConn
 			return nil, err
 		}
 		d.clientConn.needsListen = true
+		d.listenedAt = time.Now()
 	}
 
 	// init origin connection
@@ -137,66 +177,41 @@ func checkNeedListen(clientMode string, originMode string, isClient bool) bool {
 	return false
 }
 
-// get listen port
-func getListenPort(dataPortRange string) string {
-	// random port select
-	if len(dataPortRange) == 0 {
-		return ""
-	}
-
-	portRange := strings.Split(dataPortRange, "-")
-	min, _ := strconv.Atoi(strings.TrimSpace(portRange[0]))
-	max, _ := strconv.Atoi(strings.TrimSpace(portRange[1]))
-
-	// return min port number
-	if min == max {
-		return strconv.Itoa(min)
-	}
-
-	// random select in min - max range
-	return strconv.Itoa(min + rand.Intn(max-min))
-}
-
 // assign listen port create listener
 func (d *dataHandler) setNewListener() (*net.TCPListener, error) {
-	var listener *net.TCPListener
-	var lAddr *net.TCPAddr
-	var err error
-
-	// reallocate listener port when selected port is busy until LISTEN_TIMEOUT
-	counter := 0
-	for {
-		counter++
+	ports := d.ports
+	if ports == nil {
+		ports = newDataPortAllocator(d.config, d.metrics)
+	}
 
-		lAddr, err = net.ResolveTCPAddr("tcp", ":"+getListenPort(d.config.DataPortRange))
+	// reallocate listener port when selected port is busy until data_port_retries
+	for counter := 1; ; counter++ {
+		lAddr, err := net.ResolveTCPAddr("tcp", ":"+ports.port())
 		if err != nil {
 			d.log.err("cannot resolve TCPAddr")
 			return nil, err
 		}
 
-		if listener, err = net.ListenTCP("tcp", lAddr); err != nil {
-			if counter > connectionTimeout {
-				d.log.err("cannot set listener")
-
-				return nil, err
-			}
-
-			d.log.debug("cannot use choosen port. try to select another port after 1 second... (%d/%d)", counter, connectionTimeout)
-
-			time.Sleep(time.Duration(1) * time.Second)
-			continue
-
-		} else {
+		listener, err := net.ListenTCP("tcp", lAddr)
+		if err == nil {
 			d.log.debug("data listen port selected: '%s'", lAddr.String())
-			break
+			return listener, nil
+		}
+		if counter > ports.retries {
+			d.log.err("cannot set listener")
+			return nil, err
 		}
-	}
 
-	return listener, nil
+		ports.collision()
+		d.log.debug("cannot use choosen port. try to select another port after %s... (%d/%d)", ports.interval, counter, ports.retries)
+		time.Sleep(ports.interval)
+	}
 }
 
 // close all connection and listener
 func (d *dataHandler) Close() error {
+	// after unlock, for reap of listeners locks handlers
+	defer d.listeners.remove(d)
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
@@ -227,6 +242,7 @@ func (d *dataHandler) Close() error {
 
 	// close listener
 	if d.clientConn.listener != nil {
+		d.ports.release(d.clientConn.listener.Addr().(*net.TCPAddr).Port)
 		if err := d.clientConn.listener.Close(); err != nil {
 			if !strings.Contains(err.Error(), alreadyClosedMsg) {
 				lastErr = fmt.Errorf("client data listener close error: %s", err.Error())
@@ -235,6 +251,7 @@ func (d *dataHandler) Close() error {
 		d.clientConn.listener = nil
 	}
 	if d.originConn.listener != nil {
+		d.ports.release(d.originConn.listener.Addr().(*net.TCPAddr).Port)
 		if err := d.originConn.listener.Close(); err != nil {
 			if !strings.Contains(err.Error(), alreadyClosedMsg) {
 				lastErr = fmt.Errorf("origin data listener close error: %s", err.Error())
@@ -251,6 +268,32 @@ func (d *dataHandler) Close() error {
 	return lastErr
 }
 
+// set TCP keepalive period of client and origin control connection
+func (d *dataHandler) setControlKeepAlive(period time.Duration) {
+	for _, conn := range []net.Conn{d.clientConn.communicationConn, d.originConn.communicationConn} {
+		if conn != nil && !setKeepAlivePeriod(conn, period) {
+			d.log.debug("cannot set keepalive to %s control connection", conn.RemoteAddr())
+		}
+	}
+}
+
+// return client side listen port. false when listener is not available.
+func (d *dataHandler) clientListenPort() (string, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.closed || d.clientConn.listener == nil {
+		return "", false
+	}
+
+	_, port, err := net.SplitHostPort(d.clientConn.listener.Addr().String())
+	if err != nil {
+		return "", false
+	}
+
+	return port, true
+}
+
 // return current handler closed state
 func (d *dataHandler) isClosed() bool {
 	d.mutex.Lock()
@@ -259,6 +302,11 @@ func (d *dataHandler) isClosed() bool {
 	return d.closed
 }
 
+// return bytes transferred by data connection
+func (d *dataHandler) transferredBytes() int64 {
+	return atomic.LoadInt64(&d.transferred)
+}
+
 // return true when handler start transfer progress
 func (d *dataHandler) isStarted() bool {
 	d.mutex.Lock()
@@ -267,7 +315,8 @@ func (d *dataHandler) isStarted() bool {
 	return d.inDataTransfer.IsSet()
 }
 
-// Make listener for data connection
+// Make listener for data connection. return nil when data is transferred
+// to end, and error when transfer failed or was aborted.
 func (d *dataHandler) StartDataTransfer(direction string) error {
 	var err error
 
@@ -306,26 +355,93 @@ func (d *dataHandler) StartDataTransfer(direction string) error {
 	}
 
 	d.log.debug("start %s data transfer", direction)
+	d.wrapModeZ(direction)
 
 	// do not timeout communication connection during data transfer
 	d.clientConn.communicationConn.SetDeadline(time.Time{})
 	d.originConn.communicationConn.SetDeadline(time.Time{})
 
-	if err := d.run(); err != nil {
-		if !strings.Contains(err.Error(), alreadyClosedMsg) {
-			d.log.err("got error on %s data transfer: %s", direction, err.Error())
+	// keep control connections alive by short TCP keepalive during
+	// long transfer. some middleboxes drop idle control connection.
+	if d.transferKeepalive > 0 {
+		d.setControlKeepAlive(time.Duration(d.transferKeepalive) * time.Second)
+		defer d.setControlKeepAlive(time.Duration(d.config.KeepaliveTime) * time.Second)
+	}
+
+	start := time.Now()
+	defer func() { d.duration = time.Since(start) }()
+	atomic.StoreInt64(&d.lastActivity, start.UnixNano())
+	stopWatch := make(chan struct{})
+	go d.watchStall(direction, stopWatch)
+	defer close(stopWatch)
+
+	if rerr := d.run(); d.reportPartial(direction, rerr) {
+		d.log.debug("%s data transfer ended by client", direction)
+		err = errTransferAborted
+	} else if rerr != nil {
+		if !strings.Contains(rerr.Error(), alreadyClosedMsg) {
+			d.log.err("got error on %s data transfer: %s", direction, rerr.Error())
+			err = rerr
 		}
 	} else {
 		d.log.debug("%s data transfer finished", direction)
 	}
+	d.mutex.Lock()
+	if d.clientAborted && err == nil {
+		err = errTransferAborted
+	}
+	d.mutex.Unlock()
 
 	// set timeout to each connection
-	d.clientConn.communicationConn.SetDeadline(time.Now().Add(time.Duration(d.config.IdleTimeout) * time.Second))
+	idle := d.config.IdleTimeout
+	if d.idleTimeout > 0 {
+		idle = d.idleTimeout
+	}
+	d.clientConn.communicationConn.SetDeadline(time.Now().Add(time.Duration(idle) * time.Second))
 	d.originConn.communicationConn.SetDeadline(time.Now().Add(time.Duration(d.config.ProxyTimeout) * time.Second))
 
 	return err
 }
 
+// emit stalled transfer event when no bytes moved for stalled_transfer_timeout.
+// event is emitted once per stall and again after transfer resumed and stalled.
+func (d *dataHandler) watchStall(direction string, stop chan struct{}) {
+	if d.config.StalledTransferTimeout <= 0 {
+		return
+	}
+
+	timeout := time.Duration(d.config.StalledTransferTimeout) * time.Second
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	stalled := false
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&d.lastActivity)))
+			if idle < timeout {
+				stalled = false
+				continue
+			}
+			if stalled {
+				continue
+			}
+			stalled = true
+
+			d.log.info("%s data transfer stalled for %s", direction, idle.Truncate(time.Second))
+			d.events.emit(&StalledTransferEvent{
+				Time:        time.Now(),
+				SessionID:   d.sessionID,
+				Direction:   direction,
+				Transferred: atomic.LoadInt64(&d.transferred),
+				IdleFor:     idle,
+			})
+		}
+	}
+}
+
 // make client connection
 func (d *dataHandler) clientListenOrDial(clientConnected chan error) error {
 	// if client connect needs listen, open listener
@@ -336,12 +452,16 @@ func (d *dataHandler) clientListenOrDial(clientConnected chan error) error {
 			return errors.New("abort: data handler already closed")
 		}
 		listener := d.clientConn.listener
+		d.accepting = true
 		d.mutex.Unlock()
 
 		// set listener timeout
 		listener.SetDeadline(time.Now().Add(time.Duration(connectionTimeout) * time.Second))
 
 		conn, err := listener.AcceptTCP()
+		if err == nil {
+			err = d.checkPassivePeer(conn)
+		}
 		clientConnected <- err
 		if err != nil {
 			return err
@@ -397,7 +517,7 @@ func (d *dataHandler) clientListenOrDial(clientConnected chan error) error {
 	}
 
 	if d.needTLSForTransfer.IsSet() {
-		if d.tlsDataSet.forClient.getTLSConfig() == nil {
+		if d.tlsDataSet.clientTLSConfig() == nil {
 			return errors.New("cannot get client TLS config for data transfer. abort data transfer")
 		}
 
@@ -409,10 +529,14 @@ func (d *dataHandler) clientListenOrDial(clientConnected chan error) error {
 		dataConn := d.clientConn.dataConn
 		d.mutex.Unlock()
 
-		tlsConn := tls.Server(dataConn, d.tlsDataSet.forClient.getTLSConfig())
+		var hello *tls.ClientHelloInfo
+		rc := newRenegotiationConn(dataConn, reportRenegotiation(d.events, d.metrics, d.log, d.sessionID, dataConn.RemoteAddr().String(), "data"))
+		tlsConn := tls.Server(rc, recordClientHello(d.tlsDataSet.clientTLSConfig(), &hello))
 		if err := tlsConn.Handshake(); err != nil {
+			reportTLSError(d.events, d.metrics, d.sessionID, dataConn.RemoteAddr().String(), "data", err, hello)
 			return fmt.Errorf("TLS client data connection handshake got error: %v", err)
 		}
+		rc.established = true
 		d.log.debug("TLS data connection with client has set. TLS protocol version: %s and Cipher Suite: %s. (resumed?: %v)", getTLSProtocolName(tlsConn.ConnectionState().Version), tls.CipherSuiteName(tlsConn.ConnectionState().CipherSuite), tlsConn.ConnectionState().DidResume)
 
 		d.clientConn.dataConn = tlsConn
@@ -430,8 +554,9 @@ func (d *dataHandler) clientListenOrDial(clientConnected chan error) error {
 
 // make origin connection
 func (d *dataHandler) originListenOrDial(clientConnected chan error) error {
-	// if client data connection got error, abort origin connection too
-	if <-clientConnected != nil {
+	// if client data connection got error, abort origin connection too.
+	// spooled upload has no origin data connection.
+	if <-clientConnected != nil || d.spool != nil {
 		return nil
 	}
 
@@ -473,29 +598,39 @@ func (d *dataHandler) originListenOrDial(clientConnected chan error) error {
 		d.originConn.dataConn = conn
 
 	} else {
-		var conn net.Conn
-		var err error
-
-		conn, err = net.DialTimeout(
-			"tcp",
-			net.JoinHostPort(d.originConn.remoteIP, d.originConn.remotePort),
-			time.Duration(connectionTimeout)*time.Second,
-		)
+		conn, err := d.dialOriginData()
 		if err != nil {
-			return fmt.Errorf("cannot connect to origin data address: %v, %s", conn, err.Error())
+			return err
 		}
 
-		d.log.debug("connected to origin %s", conn.RemoteAddr().String())
+		d.originConn.dataConn = conn
+	}
 
-		// set linger 0 and tcp keepalive setting between origin connection
-		tcpConn := conn.(*net.TCPConn)
-		tcpConn.SetKeepAlive(true)
-		tcpConn.SetKeepAlivePeriod(time.Duration(d.config.KeepaliveTime) * time.Second)
-		tcpConn.SetLinger(0)
+	return d.secureOriginData()
+}
 
-		d.originConn.dataConn = tcpConn
+// dial to origin data address given by PASV or EPSV reply
+func (d *dataHandler) dialOriginData() (net.Conn, error) {
+	conn, err := dialEgress(
+		d.originDialer,
+		d.originProxy,
+		net.JoinHostPort(d.originConn.remoteIP, d.originConn.remotePort),
+		time.Duration(connectionTimeout)*time.Second,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to origin data address: %v, %s", conn, err.Error())
 	}
 
+	d.log.debug("connected to origin %s", conn.RemoteAddr().String())
+
+	// set linger 0 and tcp keepalive setting between origin connection
+	setOriginSocketOptions(conn, time.Duration(d.config.KeepaliveTime)*time.Second)
+
+	return conn, nil
+}
+
+// start TLS on origin data connection when PROT P and set transfer timeout
+func (d *dataHandler) secureOriginData() error {
 	// set TLS session.
 	if d.needTLSForTransfer.IsSet() {
 		if d.tlsDataSet.forOrigin.getTLSConfig() == nil {
@@ -531,15 +666,28 @@ func (d *dataHandler) originListenOrDial(clientConnected chan error) error {
 
 // make full duplex connection between client and origin sockets
 func (d *dataHandler) run() error {
+	if d.spool != nil {
+		return d.copySpooled(d.config.TransferTimeout)
+	}
+
 	eg := errgroup.Group{}
 
-	// origin to client
+	// origin to client (origin connection of RETR may be resumed)
 	eg.Go(func() error {
-		return d.copyPackets(d.clientConn.dataConn, d.originConn.dataConn, d.config.TransferTimeout)
+		return d.copyFromOrigin(d.config.TransferTimeout)
 	})
-	// client to origin
+	// client to origin (uploaded data is copied to mirrors too). mirrors
+	// are aborted when upload failed or was aborted.
 	eg.Go(func() error {
-		return d.copyPackets(d.originConn.dataConn, d.clientConn.dataConn, d.config.TransferTimeout)
+		err := d.copyPackets(d.originConn.dataConn, d.clientConn.dataConn, d.config.TransferTimeout, d.mirrors)
+		for _, m := range d.mirrors {
+			if err != nil || d.isClosed() {
+				m.abort()
+			} else {
+				m.close()
+			}
+		}
+		return err
 	})
 
 	// wait until copy goroutine end
@@ -551,9 +699,10 @@ func (d *dataHandler) run() error {
 // send src packet to dst.
 // replace io.Copy function to manual coding because io.Copy
 // function can not increase src conn's deadline per each read.
-func (d *dataHandler) copyPackets(dst net.Conn, src net.Conn, timeout int) error {
+func (d *dataHandler) copyPackets(dst net.Conn, src net.Conn, timeout int, mirrors []*mirrorWriter) error {
 	lastErr := error(nil)
 	buff := make([]byte, bufferSize)
+	started, copied := time.Now(), int64(0)
 
 	for {
 		// check about aborted from outside of handler
@@ -563,11 +712,24 @@ func (d *dataHandler) copyPackets(dst net.Conn, src net.Conn, timeout int) error
 
 		n, err := src.Read(buff)
 		if n > 0 {
-			// stop coping when failed to write dst socket
-			if _, err := dst.Write(buff[:n]); err != nil {
+			atomic.StoreInt64(&d.lastActivity, time.Now().UnixNano())
+
+			// stop coping when failed to write dst socket. src is closed too,
+			// so rest of data is not read. only written bytes are counted.
+			w, err := dst.Write(buff[:n])
+			atomic.AddInt64(&d.transferred, int64(w))
+			if err != nil {
 				dst.Close()
+				src.Close()
+				lastErr = &destinationError{err}
 				break
 			}
+			d.digest.write(buff[:n])
+			for _, m := range mirrors {
+				m.write(buff[:n])
+			}
+			copied += int64(w)
+			d.pace(started, copied)
 			// increase data transfer timeout
 			src.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
 		}
@@ -576,7 +738,7 @@ func (d *dataHandler) copyPackets(dst net.Conn, src net.Conn, timeout int) error
 				// got EOF from src, send EOF to dst
 				lastErr = sendEOF(dst)
 			} else {
-				lastErr = err
+				lastErr = &sourceError{err}
 			}
 
 			break
@@ -608,7 +770,12 @@ func (d *dataHandler) parseEPRTcommand(line string) error {
 	// - IPv6 : "EPRT |2|h1::h2:h3:h4:h5|port|\r\n"
 	var err error
 
-	d.clientConn.remoteIP, d.clientConn.remotePort, err = parseEPRTtoAddr(strings.Split(strings.Trim(line, "\r\n"), " ")[1])
+	params := strings.SplitN(strings.Trim(line, "\r\n"), " ", 2)
+	if len(params) != 2 {
+		return fmt.Errorf("invalid data address")
+	}
+
+	d.clientConn.remoteIP, d.clientConn.remotePort, err = parseEPRTtoAddr(params[1])
 
 	// if received ip is not public IP, ignore it
 	if !isPublicIP(net.ParseIP(d.clientConn.remoteIP)) {
@@ -632,6 +799,16 @@ func (d *dataHandler) parsePASVresponse(line string) error {
 
 	d.originConn.remoteIP, d.originConn.remotePort, err = parseLineToAddr(line[startIndex+1 : endIndex])
 
+	// translate received ip when origin is behind NAT
+	if ip, ok := d.passiveIPMap[d.originConn.remoteIP]; ok {
+		d.originConn.remoteIP = ip
+		return err
+	}
+
+	if err == nil && unroutablePassiveIP(d.originConn.remoteIP, d.originConn.originalRemoteIP) {
+		return d.resolveUnroutablePassiveIP()
+	}
+
 	// if received ip is not public IP, ignore it
 	if !isPublicIP(net.ParseIP(d.originConn.remoteIP)) || d.config.IgnorePassiveIP {
 		d.originConn.remoteIP = d.originConn.originalRemoteIP
@@ -693,9 +870,14 @@ func parseLineToAddr(line string) (string, string, error) {
 
 // parse EPRT command from client
 func parseEPRTtoAddr(line string) (string, string, error) {
-	addr := strings.Split(line, "|")
+	// RFC 2428 allows any printable ASCII except number as delimiter. "|" is common.
+	if len(line) == 0 || line[0] < 33 || line[0] > 126 || (line[0] >= '0' && line[0] <= '9') {
+		return "", "", fmt.Errorf("invalid data address")
+	}
+
+	addr := strings.Split(line, line[:1])
 
-	if len(addr) != 5 {
+	if len(addr) != 5 || len(addr[0]) != 0 || len(addr[4]) != 0 {
 		return "", "", fmt.Errorf("invalid data address")
 	}
 
@@ -711,10 +893,14 @@ func parseEPRTtoAddr(line string) (string, string, error) {
 	}
 
 	switch netProtocol {
-	case "1", "2":
-		// use protocol 1 means IPv4. 2 means IPv6
-		// net.ParseIP for validate IP
-		if net.ParseIP(IP) == nil {
+	case "1":
+		// protocol 1 means IPv4. address must be dotted decimal
+		if ip := net.ParseIP(IP); ip == nil || ip.To4() == nil || strings.Contains(IP, ":") {
+			return "", "", fmt.Errorf("invalid data address")
+		}
+	case "2":
+		// protocol 2 means IPv6
+		if ip := net.ParseIP(IP); ip == nil || !strings.Contains(IP, ":") {
 			return "", "", fmt.Errorf("invalid data address")
 		}
 	default:
@@ -3,6 +3,7 @@ package pftp
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/tevino/abool"
 )
@@ -11,7 +12,7 @@ func Test_dataHandler_parsePORTcommand(t *testing.T) {
 	type fields struct {
 		line   string
 		mode   string
-		config *config
+		config *Config
 	}
 
 	type want struct {
@@ -31,7 +32,7 @@ func Test_dataHandler_parsePORTcommand(t *testing.T) {
 			fields: fields{
 				line:   "PORT 256,777,0,10,235,64\r\n",
 				mode:   "PORT",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "",
@@ -45,7 +46,7 @@ func Test_dataHandler_parsePORTcommand(t *testing.T) {
 			fields: fields{
 				line:   "PORT 10,10,10,10,530,64\r\n",
 				mode:   "PORT",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "",
@@ -59,7 +60,7 @@ func Test_dataHandler_parsePORTcommand(t *testing.T) {
 			fields: fields{
 				line:   "PORT (10,10,10,10,100,10(\r\n",
 				mode:   "PORT",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "",
@@ -73,7 +74,7 @@ func Test_dataHandler_parsePORTcommand(t *testing.T) {
 			fields: fields{
 				line:   "PORT 1,1,1,1,100,10\r\n",
 				mode:   "PORT",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "1.1.1.1",
@@ -99,6 +100,7 @@ func Test_dataHandler_parsePORTcommand(t *testing.T) {
 				nil,
 				transferInTLS,
 				inDataTransfer,
+				nil,
 			)
 			err := d.parsePORTcommand(tt.fields.line)
 			if (err != nil) != tt.wantErr {
@@ -124,7 +126,7 @@ func Test_dataHandler_parseEPRTcommand(t *testing.T) {
 	type fields struct {
 		line   string
 		mode   string
-		config *config
+		config *Config
 	}
 
 	type want struct {
@@ -144,7 +146,7 @@ func Test_dataHandler_parseEPRTcommand(t *testing.T) {
 			fields: fields{
 				line:   "EPRT |1|256.777.0.10|25610|\r\n",
 				mode:   "EPRT",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "",
@@ -158,7 +160,7 @@ func Test_dataHandler_parseEPRTcommand(t *testing.T) {
 			fields: fields{
 				line:   "EPRT |1|10.10.10.10|73000|\r\n",
 				mode:   "EPRT",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "",
@@ -172,7 +174,7 @@ func Test_dataHandler_parseEPRTcommand(t *testing.T) {
 			fields: fields{
 				line:   "EPRT |3|10.10.10.10|25610|\r\n",
 				mode:   "EPRT",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "",
@@ -186,7 +188,49 @@ func Test_dataHandler_parseEPRTcommand(t *testing.T) {
 			fields: fields{
 				line:   "EPRT |1|10.10.10.10|25610||\r\n",
 				mode:   "EPRT",
-				config: &config{},
+				config: &Config{},
+			},
+			want: want{
+				ip:   "",
+				port: "",
+				err:  "invalid data address",
+			},
+			wantErr: true,
+		},
+		{
+			name: "eprt_mode_ipv6_address_with_ipv4_protocol",
+			fields: fields{
+				line:   "EPRT |1|2001:db8::1|25610|\r\n",
+				mode:   "EPRT",
+				config: &Config{},
+			},
+			want: want{
+				ip:   "",
+				port: "",
+				err:  "invalid data address",
+			},
+			wantErr: true,
+		},
+		{
+			name: "eprt_mode_ipv4_address_with_ipv6_protocol",
+			fields: fields{
+				line:   "EPRT |2|1.1.1.1|25610|\r\n",
+				mode:   "EPRT",
+				config: &Config{},
+			},
+			want: want{
+				ip:   "",
+				port: "",
+				err:  "invalid data address",
+			},
+			wantErr: true,
+		},
+		{
+			name: "eprt_mode_no_argument",
+			fields: fields{
+				line:   "EPRT\r\n",
+				mode:   "EPRT",
+				config: &Config{},
 			},
 			want: want{
 				ip:   "",
@@ -195,12 +239,54 @@ func Test_dataHandler_parseEPRTcommand(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "eprt_mode_numeric_delimiter",
+			fields: fields{
+				line:   "EPRT 1121.1.1.1125610|\r\n",
+				mode:   "EPRT",
+				config: &Config{},
+			},
+			want: want{
+				ip:   "",
+				port: "",
+				err:  "invalid data address",
+			},
+			wantErr: true,
+		},
+		{
+			name: "eprt_mode_other_delimiter",
+			fields: fields{
+				line:   "EPRT !1!1.1.1.1!25610!\r\n",
+				mode:   "EPRT",
+				config: &Config{},
+			},
+			want: want{
+				ip:   "1.1.1.1",
+				port: "25610",
+				err:  "",
+			},
+			wantErr: false,
+		},
+		{
+			name: "eprt_mode_ipv6_parse_ok",
+			fields: fields{
+				line:   "EPRT |2|2001:4860:4860::8888|25610|\r\n",
+				mode:   "EPRT",
+				config: &Config{},
+			},
+			want: want{
+				ip:   "",
+				port: "25610",
+				err:  "",
+			},
+			wantErr: false,
+		},
 		{
 			name: "eprt_mode_parse_ok",
 			fields: fields{
 				line:   "EPRT |1|1.1.1.1|25610|\r\n",
 				mode:   "EPRT",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "1.1.1.1",
@@ -226,6 +312,7 @@ func Test_dataHandler_parseEPRTcommand(t *testing.T) {
 				nil,
 				transferInTLS,
 				inDataTransfer,
+				nil,
 			)
 			err := d.parseEPRTcommand(tt.fields.line)
 			if (err != nil) != tt.wantErr {
@@ -251,7 +338,7 @@ func Test_dataHandler_parsePASVresponse(t *testing.T) {
 	type fields struct {
 		line   string
 		mode   string
-		config *config
+		config *Config
 	}
 
 	type want struct {
@@ -271,7 +358,7 @@ func Test_dataHandler_parsePASVresponse(t *testing.T) {
 			fields: fields{
 				line:   "227 Entering Passive Mode (256,777,0,10,235,64).\r\n",
 				mode:   "PASV",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "",
@@ -285,7 +372,7 @@ func Test_dataHandler_parsePASVresponse(t *testing.T) {
 			fields: fields{
 				line:   "227 Entering Passive Mode (10,10,10,10,530,64).\r\n",
 				mode:   "PASV",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "",
@@ -299,7 +386,7 @@ func Test_dataHandler_parsePASVresponse(t *testing.T) {
 			fields: fields{
 				line:   "227 Entering Passive Mode 10,10,10,10,100,10\r\n",
 				mode:   "PASV",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "",
@@ -313,7 +400,7 @@ func Test_dataHandler_parsePASVresponse(t *testing.T) {
 			fields: fields{
 				line:   "227 Entering Passive Mode (20,30,40,50,100,10).\r\n",
 				mode:   "PASV",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "20.30.40.50",
@@ -327,7 +414,7 @@ func Test_dataHandler_parsePASVresponse(t *testing.T) {
 			fields: fields{
 				line:   "227 Entering Passive Mode (10,30,40,50,100,10).\r\n",
 				mode:   "PASV",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "",
@@ -341,7 +428,7 @@ func Test_dataHandler_parsePASVresponse(t *testing.T) {
 			fields: fields{
 				line: "227 Entering Passive Mode (20,30,40,50,100,10).\r\n",
 				mode: "PASV",
-				config: &config{
+				config: &Config{
 					IgnorePassiveIP: true,
 				},
 			},
@@ -352,6 +439,39 @@ func Test_dataHandler_parsePASVresponse(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "passive_mode_parse_translate_nat_ip",
+			fields: fields{
+				line: "227 Entering Passive Mode (10,0,0,5,100,10).\r\n",
+				mode: "PASV",
+				config: &Config{
+					PassiveIPMap: map[string]string{"10.0.0.5": "203.0.113.5"},
+				},
+			},
+			want: want{
+				ip:   "203.0.113.5",
+				port: "25610",
+				err:  "",
+			},
+			wantErr: false,
+		},
+		{
+			name: "passive_mode_parse_translate_before_ignore",
+			fields: fields{
+				line: "227 Entering Passive Mode (20,30,40,50,100,10).\r\n",
+				mode: "PASV",
+				config: &Config{
+					IgnorePassiveIP: true,
+					PassiveIPMap:    map[string]string{"20.30.40.50": "192.168.1.5"},
+				},
+			},
+			want: want{
+				ip:   "192.168.1.5",
+				port: "25610",
+				err:  "",
+			},
+			wantErr: false,
+		},
 	}
 
 	transferInTLS := abool.New()
@@ -369,6 +489,7 @@ func Test_dataHandler_parsePASVresponse(t *testing.T) {
 				nil,
 				transferInTLS,
 				inDataTransfer,
+				nil,
 			)
 			err := d.parsePASVresponse(tt.fields.line)
 			if (err != nil) != tt.wantErr {
@@ -394,7 +515,7 @@ func Test_dataHandler_parseEPSV(t *testing.T) {
 	type fields struct {
 		line   string
 		mode   string
-		config *config
+		config *Config
 	}
 
 	type want struct {
@@ -414,7 +535,7 @@ func Test_dataHandler_parseEPSV(t *testing.T) {
 			fields: fields{
 				line:   "229 Entering Extended Passive Mode (|||70000|)\r\n",
 				mode:   "EPSV",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "",
@@ -428,7 +549,7 @@ func Test_dataHandler_parseEPSV(t *testing.T) {
 			fields: fields{
 				line:   "229 Entering Extended Passive Mode (|||70000|\r\n",
 				mode:   "EPSV",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "",
@@ -442,7 +563,7 @@ func Test_dataHandler_parseEPSV(t *testing.T) {
 			fields: fields{
 				line:   "229 Entering Extended Passive Mode (|||25610|)\r\n",
 				mode:   "EPSV",
-				config: &config{},
+				config: &Config{},
 			},
 			want: want{
 				ip:   "",
@@ -468,6 +589,7 @@ func Test_dataHandler_parseEPSV(t *testing.T) {
 				nil,
 				transferInTLS,
 				inDataTransfer,
+				nil,
 			)
 			err := d.parseEPSVresponse(tt.fields.line)
 			if (err != nil) != tt.wantErr {
@@ -488,3 +610,29 @@ func Test_dataHandler_parseEPSV(t *testing.T) {
 		})
 	}
 }
+
+func Test_dataHandler_watchStall(t *testing.T) {
+	events := newEventBus()
+	d := &dataHandler{
+		config:       &Config{StalledTransferTimeout: 1},
+		log:          &logger{},
+		events:       events,
+		sessionID:    1,
+		lastActivity: time.Now().Add(-2 * time.Second).UnixNano(),
+		transferred:  100,
+	}
+
+	stop := make(chan struct{})
+	go d.watchStall(uploadStream, stop)
+	defer close(stop)
+
+	select {
+	case e := <-events.ch:
+		ev, ok := e.(*StalledTransferEvent)
+		if !ok || ev.Direction != uploadStream || ev.Transferred != 100 {
+			t.Errorf("dataHandler.watchStall() event = %v, want StalledTransferEvent", e)
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("dataHandler.watchStall() did not emit event")
+	}
+}
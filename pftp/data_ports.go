@@ -0,0 +1,115 @@
+package pftp
+
+import (
+	"container/list"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// strategies of data_port_strategy
+const (
+	dataPortRandom     = "random"
+	dataPortSequential = "sequential"
+	dataPortLRU        = "lru"
+)
+
+// dataPortAllocator choose ports of data listeners in data_listen_port_range.
+// "sequential" walks the range in order and "lru" takes port closed longest
+// ago, for firewalls and conntrack which misbehave on immediate port reuse.
+// port in use is retried data_port_retries times after data_port_retry_interval.
+// 0 retries means default and -1 means no retry.
+type dataPortAllocator struct {
+	mutex    sync.Mutex
+	strategy string
+	min      int
+	max      int // 0 when range is not set and kernel chooses port
+	next     int
+	order    *list.List // ports of lru from least recently used
+	ports    map[int]*list.Element
+	retries  int
+	interval time.Duration
+	metrics  *metrics
+}
+
+func newDataPortAllocator(c *Config, m *metrics) *dataPortAllocator {
+	a := &dataPortAllocator{
+		strategy: c.DataPortStrategy,
+		retries:  c.DataPortRetries,
+		interval: time.Duration(c.DataPortRetryInterval) * time.Millisecond,
+		metrics:  m,
+	}
+	if len(a.strategy) == 0 {
+		a.strategy = dataPortRandom
+	}
+	switch {
+	case a.retries == 0:
+		a.retries = connectionTimeout
+	case a.retries < 0:
+		a.retries = 0
+	}
+
+	// wrong range is full range like config validation
+	if portRange := strings.Split(c.DataPortRange, "-"); dataPortRangeValidation(c.DataPortRange) == nil && len(portRange) == PortRangeLength {
+		a.min, _ = strconv.Atoi(strings.TrimSpace(portRange[0]))
+		a.max, _ = strconv.Atoi(strings.TrimSpace(portRange[1]))
+	}
+	a.next = a.min
+	if a.strategy == dataPortLRU && a.max > 0 {
+		a.order = list.New()
+		a.ports = map[int]*list.Element{}
+		for port := a.min; port <= a.max; port++ {
+			a.ports[port] = a.order.PushBack(port)
+		}
+	}
+
+	return a
+}
+
+// return port of next listener. "" lets kernel choose port.
+func (a *dataPortAllocator) port() string {
+	if a.max == 0 {
+		return ""
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var port int
+	switch a.strategy {
+	case dataPortSequential:
+		port = a.next
+		a.next++
+		if a.next > a.max {
+			a.next = a.min
+		}
+	case dataPortLRU:
+		e := a.order.Front()
+		a.order.MoveToBack(e)
+		port = e.Value.(int)
+	default:
+		port = a.min + rand.Intn(a.max-a.min+1)
+	}
+
+	return strconv.Itoa(port)
+}
+
+// mark port of closed listener as recently used
+func (a *dataPortAllocator) release(port int) {
+	if a == nil || a.order == nil {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if e, ok := a.ports[port]; ok {
+		a.order.MoveToBack(e)
+	}
+}
+
+// count port which was in use
+func (a *dataPortAllocator) collision() {
+	a.metrics.inc("pftp_data_port_collisions_total", "Data listener ports which were in use.", "strategy", a.strategy)
+}
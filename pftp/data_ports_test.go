@@ -0,0 +1,126 @@
+package pftp
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func Test_dataPortAllocator_port(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *Config
+		released []int
+		want     []string
+	}{
+		{
+			name:   "no range",
+			config: &Config{DataPortStrategy: dataPortSequential},
+			want:   []string{"", ""},
+		},
+		{
+			name:   "wrong range",
+			config: &Config{DataPortRange: "20-10", DataPortStrategy: dataPortLRU},
+			want:   []string{""},
+		},
+		{
+			name:   "sequential wraps",
+			config: &Config{DataPortRange: "10-12", DataPortStrategy: dataPortSequential},
+			want:   []string{"10", "11", "12", "10"},
+		},
+		{
+			name:     "lru takes port closed longest ago",
+			config:   &Config{DataPortRange: "10-12", DataPortStrategy: dataPortLRU},
+			released: []int{10, 99},
+			want:     []string{"11", "12", "10", "11"},
+		},
+		{
+			name:   "random in single port range",
+			config: &Config{DataPortRange: "10-10"},
+			want:   []string{"10", "10"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newDataPortAllocator(tt.config, nil)
+			for _, port := range tt.released {
+				a.release(port)
+			}
+			got := []string{}
+			for range tt.want {
+				got = append(got, a.port())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("port() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dataPortAllocator_random(t *testing.T) {
+	a := newDataPortAllocator(&Config{DataPortRange: "10-11"}, nil)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		seen[a.port()] = true
+	}
+	if !reflect.DeepEqual(seen, map[string]bool{"10": true, "11": true}) {
+		t.Errorf("random ports = %v", seen)
+	}
+}
+
+func Test_dataHandler_setNewListener_retry(t *testing.T) {
+	busy, err := net.ListenTCP("tcp", &net.TCPAddr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	port := busy.Addr().(*net.TCPAddr).Port
+
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{
+			name:   "sequential moves to next port",
+			config: &Config{DataPortRange: strconv.Itoa(port) + "-" + strconv.Itoa(port+1), DataPortStrategy: dataPortSequential, DataPortRetries: 1},
+		},
+		{
+			name:    "no retries",
+			config:  &Config{DataPortRange: strconv.Itoa(port) + "-" + strconv.Itoa(port), DataPortRetries: -1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dataHandler{config: tt.config, log: &logger{}, ports: newDataPortAllocator(tt.config, nil)}
+			listener, err := d.setNewListener()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setNewListener() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				defer listener.Close()
+				if got := listener.Addr().(*net.TCPAddr).Port; got != port+1 {
+					t.Errorf("listener port = %d, want %d", got, port+1)
+				}
+			}
+		})
+	}
+}
+
+func Test_newDataPortAllocator_retries(t *testing.T) {
+	tests := []struct {
+		retries int
+		want    int
+	}{
+		{retries: 0, want: connectionTimeout},
+		{retries: -1, want: 0},
+		{retries: 5, want: 5},
+	}
+	for _, tt := range tests {
+		if got := newDataPortAllocator(&Config{DataPortRetries: tt.retries}, nil).retries; got != tt.want {
+			t.Errorf("retries of data_port_retries %d = %d, want %d", tt.retries, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package pftp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Dialect rewrite commands and replies for origin server with non-standard
+// behavior. dialects are given to origins by origin_dialects, so compatibility
+// hacks do not live in middleware.
+type Dialect interface {
+	// Command return command line sent to origin instead of line from client
+	Command(line string) string
+	// Reply return reply handled by proxy instead of reply of command from origin
+	Reply(command string, reply string) string
+}
+
+// DialectFuncs is Dialect made of functions. nil function does not rewrite.
+type DialectFuncs struct {
+	CommandFunc func(line string) string
+	ReplyFunc   func(command string, reply string) string
+}
+
+// Command call CommandFunc
+func (d DialectFuncs) Command(line string) string {
+	if d.CommandFunc == nil {
+		return line
+	}
+
+	return d.CommandFunc(line)
+}
+
+// Reply call ReplyFunc
+func (d DialectFuncs) Reply(command string, reply string) string {
+	if d.ReplyFunc == nil {
+		return reply
+	}
+
+	return d.ReplyFunc(command, reply)
+}
+
+// WithDialect add named dialect usable in origin_dialects.
+// it overrides built-in dialect of same name.
+func WithDialect(name string, d Dialect) Option {
+	return func(server *FtpServer) {
+		if server.customDialects == nil {
+			server.customDialects = map[string]Dialect{}
+		}
+		server.customDialects[name] = d
+	}
+}
+
+// built-in dialects
+var builtinDialects = map[string]Dialect{
+	// origin enables UTF-8 by SITE UTF8 ON instead of OPTS UTF8 ON
+	"site_utf8": DialectFuncs{
+		CommandFunc: func(line string) string {
+			if strings.EqualFold(strings.Join(strings.Fields(line), " "), "OPTS UTF8 ON") {
+				return "SITE UTF8 ON\r\n"
+			}
+			return line
+		},
+	},
+	// origin replies 250 instead of 226 when data transfer completed
+	"transfer_250": DialectFuncs{
+		ReplyFunc: func(command string, reply string) string {
+			if isTransferCommand(command) {
+				return replaceReplyCode(reply, "250", "226")
+			}
+			return reply
+		},
+	},
+}
+
+// replace code of reply including lines of multi-line reply
+func replaceReplyCode(reply string, from string, to string) string {
+	if !strings.HasPrefix(reply, from) {
+		return reply
+	}
+
+	lines := strings.SplitAfter(reply, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, from+"-") || strings.HasPrefix(line, from+" ") {
+			lines[i] = to + line[len(from):]
+		}
+	}
+
+	return strings.Join(lines, "")
+}
+
+// dialectSet is dialects of each origin. it is shared by all client sessions of server.
+type dialectSet map[string][]Dialect
+
+// resolve dialect names of origin_dialects by custom and built-in dialects
+func newDialectSet(c *Config, custom map[string]Dialect) (dialectSet, error) {
+	if len(c.OriginDialects) == 0 {
+		return nil, nil
+	}
+
+	origins := make([]string, 0, len(c.OriginDialects))
+	for origin := range c.OriginDialects {
+		origins = append(origins, origin)
+	}
+	sort.Strings(origins)
+
+	set := dialectSet{}
+	for _, origin := range origins {
+		for _, name := range c.OriginDialects[origin] {
+			d, ok := custom[name]
+			if !ok {
+				d, ok = builtinDialects[name]
+			}
+			if !ok {
+				return nil, fmt.Errorf("configuration error: unknown dialect %s of origin %s", name, origin)
+			}
+			set[origin] = append(set[origin], d)
+		}
+	}
+
+	return set, nil
+}
+
+// rewrite command line sent to origin by its dialects in order
+func (ds dialectSet) command(origin string, line string) string {
+	for _, d := range ds[origin] {
+		line = d.Command(line)
+	}
+
+	return line
+}
+
+// rewrite reply from origin by its dialects in order
+func (ds dialectSet) reply(origin string, command string, reply string) string {
+	for _, d := range ds[origin] {
+		reply = d.Reply(command, reply)
+	}
+
+	return reply
+}
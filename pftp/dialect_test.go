@@ -0,0 +1,109 @@
+package pftp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tevino/abool"
+)
+
+func Test_newDialectSet(t *testing.T) {
+	custom := map[string]Dialect{
+		"upper": DialectFuncs{CommandFunc: strings.ToUpper},
+	}
+	tests := []struct {
+		name     string
+		dialects map[string][]string
+		line     string
+		command  string
+		reply    string
+		wantLine string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "site_utf8",
+			dialects: map[string][]string{"origin:21": {"site_utf8"}},
+			line:     "opts utf8 on\r\n",
+			wantLine: "SITE UTF8 ON\r\n",
+		},
+		{
+			name:     "transfer_250",
+			dialects: map[string][]string{"origin:21": {"transfer_250"}},
+			command:  "RETR",
+			reply:    "250-Transfer done\r\n250 OK\r\n",
+			want:     "226-Transfer done\r\n226 OK\r\n",
+		},
+		{
+			name:     "transfer_250_other_command",
+			dialects: map[string][]string{"origin:21": {"transfer_250"}},
+			command:  "CWD",
+			reply:    "250 OK\r\n",
+			want:     "250 OK\r\n",
+		},
+		{
+			name:     "custom_and_builtin_in_order",
+			dialects: map[string][]string{"origin:21": {"upper", "site_utf8"}},
+			line:     "opts utf8 on\r\n",
+			wantLine: "SITE UTF8 ON\r\n",
+		},
+		{
+			name:     "other_origin",
+			dialects: map[string][]string{"other:21": {"site_utf8"}},
+			line:     "OPTS UTF8 ON\r\n",
+			wantLine: "OPTS UTF8 ON\r\n",
+		},
+		{
+			name:     "unknown",
+			dialects: map[string][]string{"origin:21": {"vsftpd_2"}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds, err := newDialectSet(&Config{OriginDialects: tt.dialects}, custom)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newDialectSet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := ds.command("origin:21", tt.line); got != tt.wantLine {
+				t.Errorf("dialectSet.command() = %q, want %q", got, tt.wantLine)
+			}
+			if got := ds.reply("origin:21", tt.command, tt.reply); got != tt.want {
+				t.Errorf("dialectSet.reply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_proxyServer_processOriginReply_dialect(t *testing.T) {
+	ds, err := newDialectSet(&Config{OriginDialects: map[string][]string{"origin:21": {"transfer_250"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &proxyServer{
+		config:         &Config{DataChanProxy: true},
+		log:            &logger{},
+		isLoggedin:     true,
+		inDataTransfer: abool.New(),
+		originAddr:     "origin:21",
+		dialects:       ds,
+	}
+	s.pushCommand("RETR")
+
+	s.processOriginReply("150 Opening data connection.\r\n")
+	if !s.inDataTransfer.IsSet() {
+		t.Fatal("transfer is not in progress after 150")
+	}
+
+	// 250 of origin completes transfer as 226
+	if got, _ := s.processOriginReply("250 Transfer OK.\r\n"); got != "226 Transfer OK.\r\n" {
+		t.Errorf("proxyServer.processOriginReply() = %q, want 226", got)
+	}
+	if s.inDataTransfer.IsSet() {
+		t.Error("transfer is still in progress after rewritten 226")
+	}
+}
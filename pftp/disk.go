@@ -0,0 +1,168 @@
+package pftp
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// states of DiskPressureEvent
+const (
+	diskStateLow    = "low"
+	diskStateNormal = "normal"
+)
+
+// diskUsage is free and total bytes of file system
+type diskUsage struct {
+	free  int64 // available to unprivileged user
+	total int64
+}
+
+func readDiskUsage(dir string) (diskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return diskUsage{}, err
+	}
+
+	return diskUsage{free: int64(st.Bavail) * int64(st.Bsize), total: int64(st.Blocks) * int64(st.Bsize)}, nil
+}
+
+// diskWatchdog check free space of directories written by proxy (spool_dir
+// and transfer_stream_dir) against min_free_disk_bytes and
+// min_free_disk_percent. while free space of directory is under them, new
+// files are not written in it, so spools are refused instead of being
+// truncated by full disk.
+type diskWatchdog struct {
+	minFree    int64
+	minPercent float64
+	dirs       []string
+	mutex      sync.Mutex
+	low        map[string]bool // guarded by mutex
+	read       func(dir string) (diskUsage, error)
+	events     *eventBus
+	metrics    *metrics
+	log        func(format string, args ...interface{})
+}
+
+// return nil when thresholds are not set or there is no directory to watch
+func newDiskWatchdog(c *Config, dirs []string, events *eventBus, m *metrics) *diskWatchdog {
+	if (c.MinFreeDiskBytes <= 0 && c.MinFreeDiskPercent <= 0) || len(dirs) == 0 {
+		return nil
+	}
+
+	return &diskWatchdog{
+		minFree:    c.MinFreeDiskBytes,
+		minPercent: c.MinFreeDiskPercent,
+		dirs:       dirs,
+		low:        map[string]bool{},
+		read:       readDiskUsage,
+		events:     events,
+		metrics:    m,
+		log:        func(string, ...interface{}) {},
+	}
+}
+
+// return directory of stripe buffers of transfer_stream_dir
+func streamBufferDir(c *Config) string {
+	if len(c.TransferStreamDir) > 0 {
+		return c.TransferStreamDir
+	}
+
+	return os.TempDir()
+}
+
+// return true while free space of dir is under threshold
+func (w *diskWatchdog) lowSpace(dir string) bool {
+	if w == nil {
+		return false
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.low[dir]
+}
+
+// check directories at once and every interval until stop is closed
+func (w *diskWatchdog) run(interval time.Duration, stop chan struct{}) {
+	if w == nil {
+		return
+	}
+
+	w.check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// update state of each directory. event is emitted when free space got
+// under threshold and when it recovered. directory which can not be checked
+// keeps its state.
+func (w *diskWatchdog) check() {
+	for _, dir := range w.dirs {
+		u, err := w.read(dir)
+		if err != nil {
+			w.log("cannot check free disk space of %s: %s", dir, err.Error())
+			continue
+		}
+		w.metrics.set("pftp_disk_free_bytes", "Free bytes of file systems of spool and stream directories.", float64(u.free), "dir", dir)
+
+		low := (w.minFree > 0 && u.free < w.minFree) ||
+			(w.minPercent > 0 && u.total > 0 && float64(u.free)*100/float64(u.total) < w.minPercent)
+
+		w.mutex.Lock()
+		changed := w.low[dir] != low
+		w.low[dir] = low
+		w.mutex.Unlock()
+		if !changed {
+			continue
+		}
+
+		state := diskStateNormal
+		if low {
+			state = diskStateLow
+		}
+		w.log("free disk space of %s is %s: %d of %d bytes", dir, state, u.free, u.total)
+		w.events.emit(&DiskPressureEvent{
+			Time:           time.Now(),
+			Dir:            dir,
+			State:          state,
+			FreeBytes:      u.free,
+			TotalBytes:     u.total,
+			MinFreeBytes:   w.minFree,
+			MinFreePercent: w.minPercent,
+		})
+	}
+}
+
+func validateDiskThresholds(c *Config) error {
+	if c.MinFreeDiskPercent < 0 || c.MinFreeDiskPercent >= 100 {
+		return fmt.Errorf("configuration error: min_free_disk_percent must be 0 or more and less than 100")
+	}
+
+	return nil
+}
+
+// return directories written by proxy. spool_dir of file spool store and
+// directory of stripe buffers when striped transfers are enabled.
+func (server *FtpServer) diskDirs() []string {
+	dirs := []string{}
+	if store, ok := server.spoolStore.(*fileSpoolStore); ok {
+		dirs = append(dirs, store.dir)
+	}
+	if server.config.TransferStreams > 1 || len(server.config.TransferStreamDir) > 0 {
+		if dir := streamBufferDir(server.config); len(dirs) == 0 || dirs[0] != dir {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
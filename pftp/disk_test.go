@@ -0,0 +1,95 @@
+package pftp
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_diskWatchdog_check(t *testing.T) {
+	tests := []struct {
+		name       string
+		minFree    int64
+		minPercent float64
+		usages     []diskUsage
+		wantLow    bool
+		wantEvents []string
+	}{
+		{name: "enough", minFree: 100, usages: []diskUsage{{free: 500, total: 1000}}},
+		{name: "bytes_low", minFree: 100, usages: []diskUsage{{free: 50, total: 1000}}, wantLow: true, wantEvents: []string{diskStateLow}},
+		{name: "percent_low", minPercent: 10, usages: []diskUsage{{free: 50, total: 1000}}, wantLow: true, wantEvents: []string{diskStateLow}},
+		{
+			name:       "recovered",
+			minFree:    100,
+			usages:     []diskUsage{{free: 50, total: 1000}, {free: 50, total: 1000}, {free: 200, total: 1000}},
+			wantEvents: []string{diskStateLow, diskStateNormal},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := newEventBus()
+			w := newDiskWatchdog(&Config{MinFreeDiskBytes: tt.minFree, MinFreeDiskPercent: tt.minPercent}, []string{"/spool"}, events, nil)
+			for _, u := range tt.usages {
+				u := u
+				w.read = func(string) (diskUsage, error) { return u, nil }
+				w.check()
+			}
+
+			if w.lowSpace("/spool") != tt.wantLow {
+				t.Errorf("lowSpace() = %v, want %v", w.lowSpace("/spool"), tt.wantLow)
+			}
+			got := []string{}
+			for len(events.ch) > 0 {
+				got = append(got, (<-events.ch).(*DiskPressureEvent).State)
+			}
+			if len(got) != len(tt.wantEvents) {
+				t.Fatalf("events = %v, want %v", got, tt.wantEvents)
+			}
+			for i := range got {
+				if got[i] != tt.wantEvents[i] {
+					t.Errorf("events = %v, want %v", got, tt.wantEvents)
+				}
+			}
+		})
+	}
+}
+
+func Test_diskWatchdog_checkError(t *testing.T) {
+	w := newDiskWatchdog(&Config{MinFreeDiskBytes: 100}, []string{"/spool"}, newEventBus(), nil)
+	w.read = func(string) (diskUsage, error) { return diskUsage{free: 1, total: 10}, nil }
+	w.check()
+	w.read = func(string) (diskUsage, error) { return diskUsage{}, errors.New("no such file") }
+	w.check()
+
+	if !w.lowSpace("/spool") {
+		t.Error("state must be kept when free space can not be checked")
+	}
+}
+
+func Test_spool_lowDisk(t *testing.T) {
+	low := false
+	s := newTestSpool(t, &Config{})
+	s.lowDisk = func() bool { return low }
+
+	w, err := s.create(&spoolItem{Path: "/a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	low = true
+	if _, err := w.Write([]byte("hello")); err != errSpoolFull {
+		t.Errorf("Write() error = %v, want %v", err, errSpoolFull)
+	}
+	w.finish(errSpoolFull)
+	if _, err := s.create(&spoolItem{Path: "/b.txt"}); err != errSpoolFull {
+		t.Errorf("create() error = %v, want %v", err, errSpoolFull)
+	}
+}
+
+func Test_readDiskUsage(t *testing.T) {
+	u, err := readDiskUsage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.total <= 0 || u.free < 0 || u.free > u.total {
+		t.Errorf("readDiskUsage() = %+v", u)
+	}
+}
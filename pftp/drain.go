@@ -0,0 +1,206 @@
+package pftp
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// interval to check sessions while draining
+var drainPollInterval = time.Second
+
+// phases of DrainEvent
+const (
+	drainPhaseStart    = "start"
+	drainPhaseProgress = "progress"
+	drainPhaseDone     = "done"
+)
+
+// Draining return true after server started draining
+func (server *FtpServer) Draining() bool {
+	return server.draining != nil && server.draining.IsSet()
+}
+
+// start draining. readiness is false and new logins are refused with 421 after it.
+func (server *FtpServer) beginDrain(grace time.Duration) {
+	if !server.draining.SetToIf(false, true) {
+		return
+	}
+
+	server.logger.Info("start draining. wait for sessions up to ", grace)
+	server.events.emit(&DrainEvent{
+		Time:           time.Now(),
+		Phase:          drainPhaseStart,
+		ActiveSessions: atomic.LoadInt32(&server.currentConnection),
+		Remaining:      grace,
+	})
+}
+
+// return sessions of server, or sessions connected to origin when it is given
+func (server *FtpServer) activeSessions(origin string) int32 {
+	if len(origin) > 0 {
+		return server.clients.countOrigin(origin)
+	}
+
+	return atomic.LoadInt32(&server.currentConnection)
+}
+
+// wait until sessions (of origin when it is given) hit zero or grace passed.
+// progress is emitted when number of sessions changed. return sessions left.
+func (server *FtpServer) waitDrained(origin string, grace time.Duration) int32 {
+	deadline := time.Now().Add(grace)
+	last := server.activeSessions(origin)
+	for {
+		active := server.activeSessions(origin)
+		if active != last {
+			last = active
+			server.events.emit(&DrainEvent{
+				Time:           time.Now(),
+				Origin:         origin,
+				Phase:          drainPhaseProgress,
+				ActiveSessions: active,
+				Remaining:      time.Until(deadline),
+			})
+		}
+
+		if active <= 0 || !time.Now().Before(deadline) {
+			reason := "completed"
+			if active > 0 {
+				reason = "deadline"
+			}
+			server.events.emit(&DrainEvent{
+				Time:           time.Now(),
+				Origin:         origin,
+				Phase:          drainPhaseDone,
+				ActiveSessions: active,
+				Reason:         reason,
+			})
+			return active
+		}
+
+		time.Sleep(drainPollInterval)
+	}
+}
+
+// Drain flip readiness to false, refuse new logins and stop server when
+// sessions hit zero or grace passed. sessions left at the time are closed by 421.
+func (server *FtpServer) Drain(grace time.Duration) error {
+	server.beginDrain(grace)
+	server.waitDrained("", grace)
+
+	return server.stop()
+}
+
+// DrainOrigin skip origin for new sessions in same way as drained/<origin> of
+// dynamic config, and wait until sessions connected to it hit zero or grace
+// passed. return sessions left on origin. it is kept until UndrainOrigin.
+func (server *FtpServer) DrainOrigin(addr string, grace time.Duration) int32 {
+	server.dynamic.drain(addr, true)
+
+	server.logger.Info("start draining origin ", addr, ". wait for sessions up to ", grace)
+	server.events.emit(&DrainEvent{
+		Time:           time.Now(),
+		Origin:         addr,
+		Phase:          drainPhaseStart,
+		ActiveSessions: server.activeSessions(addr),
+		Remaining:      grace,
+	})
+
+	return server.waitDrained(addr, grace)
+}
+
+// UndrainOrigin let new sessions use origin drained by DrainOrigin again
+func (server *FtpServer) UndrainOrigin(addr string) {
+	server.dynamic.drain(addr, false)
+	server.logger.Info("origin ", addr, " is undrained")
+}
+
+// return grace of drain_timeout
+func (server *FtpServer) drainGrace() time.Duration {
+	return time.Duration(server.config.DrainTimeout) * time.Second
+}
+
+// GET /readyz
+// return 503 while server is draining or standby for readiness probe
+func (server *FtpServer) handleReadiness(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if server.Draining() || server.Standby() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]bool{"ready": false})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"ready": true})
+}
+
+// POST /drain?grace=300
+// start draining and wait until sessions hit zero or grace seconds passed.
+// preStop hook runs it by "pftp drain". server keeps running until SIGTERM
+// or Stop. grace is drain_timeout when it is omitted.
+func (server *FtpServer) handleDrain(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	grace, ok := server.graceParam(w, r)
+	if !ok {
+		return
+	}
+
+	server.beginDrain(grace)
+	active := server.waitDrained("", grace)
+
+	writeJSON(w, http.StatusOK, map[string]int32{"active_sessions": active})
+}
+
+// POST /origins/:origin/drain?grace=300
+// skip origin for new sessions and wait until its sessions hit zero or grace
+// seconds passed. grace is drain_timeout when it is omitted.
+func (server *FtpServer) handleDrainOrigin(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	grace, ok := server.graceParam(w, r)
+	if !ok {
+		return
+	}
+
+	active := server.DrainOrigin(ps.ByName("origin"), grace)
+
+	writeJSON(w, http.StatusOK, map[string]int32{"active_sessions": active})
+}
+
+// DELETE /origins/:origin/drain
+// let new sessions use drained origin again
+func (server *FtpServer) handleUndrainOrigin(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	server.UndrainOrigin(ps.ByName("origin"))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// return grace of query, or drain_timeout when it is omitted. reply 400 and
+// return false when it is not seconds.
+func (server *FtpServer) graceParam(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	s := r.URL.Query().Get("grace")
+	if len(s) == 0 {
+		return server.drainGrace(), true
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: "grace must be seconds"})
+		return 0, false
+	}
+
+	return time.Duration(n) * time.Second, true
+}
+
+// refuse login while server is draining
+func (c *clientHandler) refuseDraining() *result {
+	if c.draining == nil || !c.draining.IsSet() {
+		return nil
+	}
+
+	c.setCloseReason(closeReasonShutdown)
+	return &result{
+		code: 421,
+		msg:  c.message(msgDraining),
+		err:  fmt.Errorf("server is draining"),
+		log:  c.log,
+	}
+}
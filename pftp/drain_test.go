@@ -0,0 +1,163 @@
+package pftp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+func Test_FtpServer_handleDrain(t *testing.T) {
+	drainPollInterval = time.Millisecond
+	defer func() { drainPollInterval = time.Second }()
+
+	server, err := NewFtpServerWithConfig(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := server.adminHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /readyz code = %d, want 200", rec.Code)
+	}
+
+	// two sessions end while draining
+	atomic.StoreInt32(&server.currentConnection, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&server.currentConnection, -1)
+		}
+	}()
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/drain?grace=5", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "{\"active_sessions\":0}\n" {
+		t.Errorf("POST /drain = %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz code = %d while draining, want 503", rec.Code)
+	}
+
+	// drain requests are emitted as admin_action events as well
+	nextDrainEvent := func() (*DrainEvent, bool) {
+		for {
+			switch e := (<-server.Events()).(type) {
+			case *AdminActionEvent:
+			case *DrainEvent:
+				return e, true
+			default:
+				return nil, false
+			}
+		}
+	}
+
+	want := []string{drainPhaseStart, drainPhaseProgress, drainPhaseProgress, drainPhaseDone}
+	for _, phase := range want {
+		e, ok := nextDrainEvent()
+		if !ok || e.Phase != phase {
+			t.Fatalf("drain event = %+v, want phase %s", e, phase)
+		}
+		if phase == drainPhaseDone && e.Reason != "completed" {
+			t.Errorf("drain done reason = %s, want completed", e.Reason)
+		}
+	}
+
+	// deadline passes with session left
+	atomic.StoreInt32(&server.currentConnection, 1)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/drain?grace=0", nil))
+	if rec.Body.String() != "{\"active_sessions\":1}\n" {
+		t.Errorf("POST /drain = %s, want 1 active session", rec.Body.String())
+	}
+	if e, _ := nextDrainEvent(); e == nil || e.Phase != drainPhaseDone || e.Reason != "deadline" {
+		t.Errorf("drain event = %+v, want deadline", e)
+	}
+
+	// link or crawler must not drain server
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/drain", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /drain code = %d, want 405", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/drain?grace=soon", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /drain code = %d with wrong grace, want 400", rec.Code)
+	}
+}
+
+func Test_FtpServer_handleDrainOrigin(t *testing.T) {
+	drainPollInterval = time.Millisecond
+	defer func() { drainPollInterval = time.Second }()
+
+	server, err := NewFtpServerWithConfig(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := server.adminHandler()
+
+	// session of other origin is not waited
+	a := &clientHandler{id: 1, summary: SessionInfo{ID: 1, Origin: "127.0.0.1:10021"}}
+	server.clients.add(a)
+	server.clients.add(&clientHandler{id: 2, summary: SessionInfo{ID: 2, Origin: "127.0.0.1:10022"}})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		server.clients.remove(a)
+	}()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/origins/127.0.0.1:10021/drain?grace=5", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "{\"active_sessions\":0}\n" {
+		t.Errorf("POST /origins/:origin/drain = %d %s", rec.Code, rec.Body.String())
+	}
+	if !server.dynamic.isDrained("127.0.0.1:10021") || server.dynamic.isDrained("127.0.0.1:10022") {
+		t.Error("only drained origin must be skipped")
+	}
+	if server.Draining() {
+		t.Error("server must not be draining by origin drain")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/origins/127.0.0.1:10022/drain?grace=0", nil))
+	if rec.Body.String() != "{\"active_sessions\":1}\n" {
+		t.Errorf("POST /origins/:origin/drain = %s, want 1 active session", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("DELETE", "/origins/127.0.0.1:10021/drain", nil))
+	if rec.Code != http.StatusNoContent || server.dynamic.isDrained("127.0.0.1:10021") {
+		t.Errorf("DELETE /origins/:origin/drain = %d, origin must be undrained", rec.Code)
+	}
+}
+
+func Test_clientHandler_refuseDraining(t *testing.T) {
+	draining := abool.New()
+	c := &clientHandler{
+		config:   &Config{},
+		context:  &Context{},
+		log:      &logger{},
+		draining: draining,
+	}
+
+	if r := c.refuseDraining(); r != nil {
+		t.Errorf("clientHandler.refuseDraining() = %+v before draining", r)
+	}
+
+	draining.Set()
+	if r := c.refuseDraining(); r == nil || r.code != 421 {
+		t.Errorf("clientHandler.refuseDraining() = %+v, want 421", r)
+	}
+	if c.closeReason != closeReasonShutdown {
+		t.Errorf("close reason = %s, want %s", c.closeReason, closeReasonShutdown)
+	}
+}
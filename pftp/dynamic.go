@@ -0,0 +1,334 @@
+package pftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DynamicSource watch key values of dynamic config. Watch call apply with all
+// keys (relative to prefix) and values each time they change until ctx is done.
+type DynamicSource interface {
+	Watch(ctx context.Context, apply func(map[string]string)) error
+}
+
+// WithDynamicSource set source of dynamic config used instead of dynamic_config.
+func WithDynamicSource(s DynamicSource) Option {
+	return func(server *FtpServer) {
+		server.dynamicSource = s
+	}
+}
+
+// dynamicConfig is routes, limits and drained origins applied live from
+// dynamic config source. keys are routes/hosts/<host> and routes/users/<user>
+// (origin of HOST and USER), limits/max_connections and drained/<origin>
+// ("false" to undrain). it is shared by all client sessions of server.
+// origins drained by admin API are kept when source changes.
+type dynamicConfig struct {
+	mutex          sync.RWMutex
+	hostOrigins    map[string]string
+	userOrigins    map[string]string
+	maxConnections int32
+	drained        map[string]bool
+	adminDrained   map[string]bool
+}
+
+func newDynamicConfig() *dynamicConfig {
+	return &dynamicConfig{adminDrained: map[string]bool{}}
+}
+
+// replace settings by key values of source
+func (d *dynamicConfig) apply(kv map[string]string) []error {
+	hosts := map[string]string{}
+	users := map[string]string{}
+	drained := map[string]bool{}
+	var maxConnections int32
+	var errs []error
+
+	for key, value := range kv {
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(key, "routes/hosts/"):
+			hosts[strings.ToLower(strings.TrimPrefix(key, "routes/hosts/"))] = value
+		case strings.HasPrefix(key, "routes/users/"):
+			users[strings.TrimPrefix(key, "routes/users/")] = value
+		case key == "limits/max_connections":
+			n, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || n < 0 {
+				errs = append(errs, fmt.Errorf("dynamic config %s is not number: %s", key, value))
+				continue
+			}
+			maxConnections = int32(n)
+		case strings.HasPrefix(key, "drained/"):
+			drained[strings.TrimPrefix(key, "drained/")] = value != "false"
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.hostOrigins = hosts
+	d.userOrigins = users
+	d.maxConnections = maxConnections
+	d.drained = drained
+
+	return errs
+}
+
+func (d *dynamicConfig) hostOrigin(host string) (string, bool) {
+	if d == nil {
+		return "", false
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	addr, ok := d.hostOrigins[host]
+
+	return addr, ok
+}
+
+func (d *dynamicConfig) userOrigin(user string) (string, bool) {
+	if d == nil {
+		return "", false
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	addr, ok := d.userOrigins[user]
+
+	return addr, ok
+}
+
+// return max connections of dynamic config, or n when it is not set
+func (d *dynamicConfig) maxConns(n int32) int32 {
+	if d == nil {
+		return n
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	if d.maxConnections > 0 {
+		return d.maxConnections
+	}
+
+	return n
+}
+
+func (d *dynamicConfig) isDrained(addr string) bool {
+	if d == nil {
+		return false
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.drained[addr] || d.adminDrained[addr]
+}
+
+// drain or undrain origin by admin API
+func (d *dynamicConfig) drain(addr string, drained bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if drained {
+		d.adminDrained[addr] = true
+	} else {
+		delete(d.adminDrained, addr)
+	}
+}
+
+// watch source and apply it until ctx is done. watch is restarted after errors.
+func (d *dynamicConfig) run(ctx context.Context, source DynamicSource, log func(error)) {
+	for {
+		err := source.Watch(ctx, func(kv map[string]string) {
+			for _, err := range d.apply(kv) {
+				log(err)
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// return source of dynamic_config URL.
+// ex) consul://127.0.0.1:8500/pftp/, etcd://127.0.0.1:2379/pftp/
+func newDynamicSource(rawURL string, token string) (DynamicSource, error) {
+	if len(rawURL) == 0 {
+		return nil, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("configuration error: dynamic_config is wrong: %s", err.Error())
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	client := &http.Client{Timeout: 10 * time.Minute}
+
+	switch u.Scheme {
+	case "consul":
+		return &consulSource{addr: "http://" + u.Host, prefix: prefix, token: token, client: client, interval: 5 * time.Second}, nil
+	case "etcd":
+		return &etcdSource{addr: "http://" + u.Host, prefix: prefix, client: client, interval: 5 * time.Second}, nil
+	}
+
+	return nil, fmt.Errorf("configuration error: dynamic_config scheme %s is not supported", u.Scheme)
+}
+
+// consulSource watch Consul KV prefix by blocking queries. when query returns
+// without index or with same index (not blocked), next query waits interval.
+type consulSource struct {
+	addr     string
+	prefix   string
+	token    string
+	client   *http.Client
+	interval time.Duration
+}
+
+func (s *consulSource) Watch(ctx context.Context, apply func(map[string]string)) error {
+	index := "0"
+	for {
+		q := url.Values{"recurse": {"true"}, "index": {index}, "wait": {"5m"}}
+		req, err := http.NewRequest(http.MethodGet, s.addr+"/v1/kv/"+s.prefix+"?"+q.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		if len(s.token) > 0 {
+			req.Header.Set("X-Consul-Token", s.token)
+		}
+
+		resp, err := s.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+
+		var entries []struct {
+			Key   string
+			Value []byte // base64 in JSON
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = json.NewDecoder(resp.Body).Decode(&entries)
+		case http.StatusNotFound:
+			// no keys under prefix
+		default:
+			err = fmt.Errorf("consul returned %s", resp.Status)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		// without index, keys may be changed at every query
+		next := resp.Header.Get("X-Consul-Index")
+		if len(next) == 0 || next != index {
+			kv := map[string]string{}
+			for _, e := range entries {
+				kv[strings.TrimPrefix(e.Key, s.prefix)] = string(e.Value)
+			}
+			apply(kv)
+		}
+
+		if len(next) == 0 || next == index {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(s.interval):
+			}
+		}
+		if len(next) > 0 {
+			index = next
+		}
+	}
+}
+
+// etcdSource poll etcd keyspace of prefix by v3 JSON gateway
+type etcdSource struct {
+	addr     string
+	prefix   string
+	client   *http.Client
+	interval time.Duration
+}
+
+// return end of range including all keys with prefix
+func etcdRangeEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+
+	// all keys
+	return "\x00"
+}
+
+func (s *etcdSource) Watch(ctx context.Context, apply func(map[string]string)) error {
+	revision := ""
+	for {
+		body, _ := json.Marshal(map[string]string{
+			"key":       base64.StdEncoding.EncodeToString([]byte(s.prefix)),
+			"range_end": base64.StdEncoding.EncodeToString([]byte(etcdRangeEnd(s.prefix))),
+		})
+		req, err := http.NewRequest(http.MethodPost, s.addr+"/v3/kv/range", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+
+		var r struct {
+			Header struct {
+				Revision string `json:"revision"`
+			} `json:"header"`
+			Kvs []struct {
+				Key   []byte `json:"key"`
+				Value []byte `json:"value"`
+			} `json:"kvs"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("etcd returned %s", resp.Status)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&r)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if r.Header.Revision != revision {
+			revision = r.Header.Revision
+			kv := map[string]string{}
+			for _, e := range r.Kvs {
+				kv[strings.TrimPrefix(string(e.Key), s.prefix)] = string(e.Value)
+			}
+			apply(kv)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.interval):
+		}
+	}
+}
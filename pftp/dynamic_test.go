@@ -0,0 +1,225 @@
+package pftp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_dynamicConfig_apply(t *testing.T) {
+	d := newDynamicConfig()
+	errs := d.apply(map[string]string{
+		"routes/hosts/FTP.example.com": "10.0.0.1:21",
+		"routes/users/alice":           "10.0.0.2:21\n",
+		"limits/max_connections":       "5",
+		"drained/10.0.0.3:21":          "",
+		"drained/10.0.0.4:21":          "false",
+		"unknown":                      "x",
+	})
+	if len(errs) != 0 {
+		t.Fatalf("dynamicConfig.apply() errors = %v", errs)
+	}
+
+	if addr, ok := d.hostOrigin("ftp.example.com"); !ok || addr != "10.0.0.1:21" {
+		t.Errorf("hostOrigin() = %s, %v", addr, ok)
+	}
+	if addr, ok := d.userOrigin("alice"); !ok || addr != "10.0.0.2:21" {
+		t.Errorf("userOrigin() = %s, %v", addr, ok)
+	}
+	if n := d.maxConns(10); n != 5 {
+		t.Errorf("maxConns() = %d, want 5", n)
+	}
+	if !d.isDrained("10.0.0.3:21") || d.isDrained("10.0.0.4:21") {
+		t.Errorf("isDrained() is wrong: %v", d.drained)
+	}
+
+	// removed keys are reset
+	if errs := d.apply(map[string]string{"limits/max_connections": "x"}); len(errs) != 1 {
+		t.Errorf("dynamicConfig.apply() errors = %v, want 1 error", errs)
+	}
+	if _, ok := d.hostOrigin("ftp.example.com"); ok {
+		t.Errorf("hostOrigin() is not removed")
+	}
+	if n := d.maxConns(10); n != 10 {
+		t.Errorf("maxConns() = %d, want default 10", n)
+	}
+
+	var nilConfig *dynamicConfig
+	if nilConfig.maxConns(3) != 3 || nilConfig.isDrained("a") {
+		t.Errorf("nil dynamicConfig is not default")
+	}
+}
+
+func Test_newDynamicSource(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    DynamicSource
+		wantErr bool
+	}{
+		{url: ""},
+		{url: "consul://127.0.0.1:8500/pftp/", want: &consulSource{addr: "http://127.0.0.1:8500", prefix: "pftp/", token: "t", interval: 5 * time.Second}},
+		{url: "etcd://127.0.0.1:2379/pftp/", want: &etcdSource{addr: "http://127.0.0.1:2379", prefix: "pftp/", interval: 5 * time.Second}},
+		{url: "zk://127.0.0.1/pftp", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got, err := newDynamicSource(tt.url, "t")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newDynamicSource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			switch s := got.(type) {
+			case *consulSource:
+				s.client = nil
+			case *etcdSource:
+				s.client = nil
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newDynamicSource() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_consulSource_Watch(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/kv/pftp/" || r.URL.Query().Get("recurse") != "true" || r.Header.Get("X-Consul-Token") != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.Header().Set("X-Consul-Index", "7")
+			json.NewEncoder(w).Encode([]map[string]interface{}{
+				{"Key": "pftp/routes/users/alice", "Value": base64.StdEncoding.EncodeToString([]byte("10.0.0.2:21"))},
+			})
+		default:
+			if r.URL.Query().Get("index") != "7" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("X-Consul-Index", "8")
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &consulSource{addr: ts.URL, prefix: "pftp/", token: "secret", client: ts.Client()}
+	var got []map[string]string
+	err := s.Watch(ctx, func(kv map[string]string) {
+		got = append(got, kv)
+		if len(got) == 2 {
+			cancel()
+		}
+	})
+	if err == nil {
+		t.Errorf("consulSource.Watch() returned nil after cancel")
+	}
+
+	want := []map[string]string{{"routes/users/alice": "10.0.0.2:21"}, {}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("consulSource.Watch() applied %v, want %v", got, want)
+	}
+}
+
+func Test_consulSource_Watch_backoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		index     string
+		maxCalls  int32
+		applyEach bool
+	}{
+		{name: "missing_index", maxCalls: 3, applyEach: true},
+		{name: "unchanged_index", index: "7", maxCalls: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				if len(tt.index) > 0 {
+					w.Header().Set("X-Consul-Index", tt.index)
+				}
+				w.WriteHeader(http.StatusNotFound)
+			}))
+			defer ts.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			s := &consulSource{addr: ts.URL, prefix: "pftp/", client: ts.Client(), interval: 50 * time.Millisecond}
+			applied := 0
+			go func() {
+				time.Sleep(120 * time.Millisecond)
+				cancel()
+			}()
+			s.Watch(ctx, func(kv map[string]string) { applied++ })
+
+			// query is not repeated at once without new index
+			n := atomic.LoadInt32(&calls)
+			if n > tt.maxCalls {
+				t.Errorf("consul is called %d times, want at most %d", n, tt.maxCalls)
+			}
+			// keys are applied at each query without index, once with same index
+			want := int32(1)
+			if tt.applyEach {
+				want = n
+			}
+			if int32(applied) != want {
+				t.Errorf("consulSource.Watch() applied %d times, want %d", applied, want)
+			}
+		})
+	}
+}
+
+func Test_etcdSource_Watch(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/v3/kv/range" ||
+			req["key"] != base64.StdEncoding.EncodeToString([]byte("pftp/")) ||
+			req["range_end"] != base64.StdEncoding.EncodeToString([]byte("pftp0")) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// revision changes at third call
+		revision := "3"
+		if atomic.AddInt32(&calls, 1) >= 3 {
+			revision = "4"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"header": map[string]string{"revision": revision},
+			"kvs": []map[string]string{
+				{"key": base64.StdEncoding.EncodeToString([]byte("pftp/drained/10.0.0.3:21")), "value": base64.StdEncoding.EncodeToString([]byte(revision))},
+			},
+		})
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &etcdSource{addr: ts.URL, prefix: "pftp/", client: ts.Client(), interval: time.Millisecond}
+	var got []map[string]string
+	s.Watch(ctx, func(kv map[string]string) {
+		got = append(got, kv)
+		if len(got) == 2 {
+			cancel()
+		}
+	})
+
+	want := []map[string]string{{"drained/10.0.0.3:21": "3"}, {"drained/10.0.0.3:21": "4"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("etcdSource.Watch() applied %v, want %v", got, want)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("etcd is called %d times, want 3", n)
+	}
+}
@@ -0,0 +1,198 @@
+package pftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// OriginDialer dial connection to origin or egress proxy
+type OriginDialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dial addr by dialer (nil means OS network stack) directly or through egress proxy.
+// proxyURL is "http://[user:pass@]host:port" (HTTP CONNECT) or "socks5://[user:pass@]host:port".
+// returned connection is connection to proxy made by dialer.
+func dialEgress(dialer OriginDialer, proxyURL string, addr string, timeout time.Duration) (net.Conn, error) {
+	if dialer == nil {
+		dialer = (&net.Dialer{}).DialContext
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if len(proxyURL) == 0 {
+		return dialNetworkAddr(ctx, dialer, addr)
+	}
+	if isUnixAddr(addr) {
+		return nil, fmt.Errorf("%s cannot be connected through origin proxy", addr)
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("origin proxy %s is wrong: %s", proxyURL, err.Error())
+	}
+
+	conn, err := dialer(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	switch u.Scheme {
+	case "http":
+		err = httpConnect(conn, u, addr)
+	case "socks5":
+		err = socks5Connect(conn, u, addr)
+	default:
+		err = fmt.Errorf("origin proxy scheme %s is not supported", u.Scheme)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot connect to %s through origin proxy %s: %s", addr, u.Host, err.Error())
+	}
+	conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+// validate origin proxy URL
+func validateEgressProxy(proxyURL string) error {
+	if len(proxyURL) == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "socks5") || len(u.Port()) == 0 {
+		return fmt.Errorf("configuration error: origin proxy %s is wrong. use http://host:port or socks5://host:port", proxyURL)
+	}
+
+	return nil
+}
+
+// open tunnel by HTTP CONNECT
+func httpConnect(conn net.Conn, u *url.URL, addr string) error {
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + pass))
+		req += "Proxy-Authorization: Basic " + auth + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		return err
+	}
+
+	// read response byte by byte. origin greeting may follow it immediately
+	// and it must be left in connection.
+	var res []byte
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(res, []byte("\r\n\r\n")) {
+		if len(res) > 4096 {
+			return errors.New("too long response from proxy")
+		}
+		if _, err := conn.Read(b); err != nil {
+			return err
+		}
+		res = append(res, b[0])
+	}
+
+	// ex) "HTTP/1.1 200 Connection established"
+	status := bytes.SplitN(res, []byte(" "), 3)
+	if len(status) < 2 || string(status[1]) != "200" {
+		return fmt.Errorf("proxy refused CONNECT: %s", bytes.SplitN(res, []byte("\r\n"), 2)[0])
+	}
+
+	return nil
+}
+
+// open tunnel by SOCKS5 CONNECT (RFC 1928) with optional
+// username/password authentication (RFC 1929)
+func socks5Connect(conn net.Conn, u *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	methods := []byte{0x00}
+	if u.User != nil {
+		methods = []byte{0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	res := make([]byte, 2)
+	if _, err := io.ReadFull(conn, res); err != nil {
+		return err
+	}
+	if res[0] != 0x05 || res[1] != methods[0] {
+		return errors.New("socks5 proxy does not accept authentication method")
+	}
+
+	if u.User != nil {
+		user := u.User.Username()
+		pass, _ := u.User.Password()
+		req := append([]byte{0x01, byte(len(user))}, user...)
+		req = append(append(req, byte(len(pass))), pass...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, res); err != nil {
+			return err
+		}
+		if res[1] != 0x00 {
+			return errors.New("socks5 authentication failed")
+		}
+	}
+
+	// CONNECT request. IP is sent as is and name is resolved by proxy
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		req = append(append(req, 0x03, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 0x01), ip4...)
+	} else {
+		req = append(append(req, 0x04), ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// reply: VER REP RSV ATYP BND.ADDR BND.PORT
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0x00 {
+		return fmt.Errorf("socks5 proxy refused CONNECT: code %d", head[1])
+	}
+
+	var skip int
+	switch head[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return err
+		}
+		skip = int(l[0])
+	default:
+		return errors.New("wrong socks5 reply")
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+
+	return err
+}
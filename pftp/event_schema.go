@@ -0,0 +1,90 @@
+package pftp
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// EventSchemaVersion is version of JSON schema of events. it is increased
+// when field is renamed or removed or changes meaning. fields are added
+// without increasing it, so consumers should ignore unknown fields.
+// durations are nanoseconds and times are RFC 3339.
+const EventSchemaVersion = 1
+
+// EventRecord is JSON envelope of event written to sinks
+// ex) {"schema_version":1,"type":"drain","event":{"time":"...","phase":"start",...}}
+type EventRecord struct {
+	SchemaVersion int             `json:"schema_version"`
+	Type          string          `json:"type"`
+	Event         json.RawMessage `json:"event"`
+}
+
+// constructors of events by type name. new event type must be added here.
+var eventTypes = map[string]func() Event{
+	"origin_unresolved":   func() Event { return &OriginUnresolvedEvent{} },
+	"upload_mirror_error": func() Event { return &UploadMirrorErrorEvent{} },
+	"server_start":        func() Event { return &ServerStartEvent{} },
+	"listener_error":      func() Event { return &ListenerErrorEvent{} },
+	"server_shutdown":     func() Event { return &ServerShutdownEvent{} },
+	"origin_switch":       func() Event { return &OriginSwitchEvent{} },
+	"stalled_transfer":    func() Event { return &StalledTransferEvent{} },
+	"origin_notice":       func() Event { return &OriginNoticeEvent{} },
+	"client_banned":       func() Event { return &ClientBannedEvent{} },
+	"tls_handshake_error": func() Event { return &TLSHandshakeErrorEvent{} },
+	"origin_threshold":    func() Event { return &OriginThresholdEvent{} },
+	"origin_circuit":      func() Event { return &OriginCircuitEvent{} },
+	"command_reply":       func() Event { return &CommandReplyEvent{} },
+	"command_timeout":     func() Event { return &CommandTimeoutEvent{} },
+	"security":            func() Event { return &SecurityEvent{} },
+	"fxp":                 func() Event { return &FXPEvent{} },
+	"client_disconnect":   func() Event { return &ClientDisconnectEvent{} },
+	"transfer_resume":     func() Event { return &TransferResumeEvent{} },
+	"drain":               func() Event { return &DrainEvent{} },
+	"standby":             func() Event { return &StandbyEvent{} },
+	"command_retry":       func() Event { return &CommandRetryEvent{} },
+	"partial_transfer":    func() Event { return &PartialTransferEvent{} },
+	"integrity_mismatch":  func() Event { return &IntegrityMismatchEvent{} },
+	"spool":               func() Event { return &SpoolEvent{} },
+	"admin_action":        func() Event { return &AdminActionEvent{} },
+	"resource_pressure":   func() Event { return &ResourcePressureEvent{} },
+	"disk_pressure":       func() Event { return &DiskPressureEvent{} },
+	"throughput_summary":  func() Event { return &ThroughputSummaryEvent{} },
+	"upload":              func() Event { return &UploadEvent{} },
+	"origin_not_ready":    func() Event { return &OriginNotReadyEvent{} },
+	"tls_renegotiation":   func() Event { return &TLSRenegotiationEvent{} },
+	"user_block":          func() Event { return &UserBlockEvent{} },
+	"slo_summary":         func() Event { return &SLOSummaryEvent{} },
+}
+
+// MarshalEvent encode event in EventRecord of EventSchemaVersion
+func MarshalEvent(e Event) ([]byte, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&EventRecord{SchemaVersion: EventSchemaVersion, Type: e.EventType(), Event: b})
+}
+
+// UnmarshalEvent decode EventRecord made by MarshalEvent. record of unknown
+// type or newer schema version than this pftp is error.
+func UnmarshalEvent(b []byte) (Event, error) {
+	var r EventRecord
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	if r.SchemaVersion <= 0 || r.SchemaVersion > EventSchemaVersion {
+		return nil, fmt.Errorf("unsupported event schema version %d", r.SchemaVersion)
+	}
+	newEvent, ok := eventTypes[r.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown event type %q", r.Type)
+	}
+
+	e := newEvent()
+	if err := json.Unmarshal(r.Event, e); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
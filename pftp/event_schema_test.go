@@ -0,0 +1,59 @@
+package pftp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_eventTypes(t *testing.T) {
+	var tagged func(reflect.Type)
+	tagged = func(typ reflect.Type) {
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if len(f.Tag.Get("json")) == 0 {
+				t.Errorf("%s.%s has no json tag", typ.Name(), f.Name)
+			}
+			if f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct {
+				tagged(f.Type.Elem())
+			}
+		}
+	}
+
+	for name, newEvent := range eventTypes {
+		e := newEvent()
+		if e.EventType() != name {
+			t.Errorf("eventTypes[%q] is %s event", name, e.EventType())
+		}
+		tagged(reflect.TypeOf(e).Elem())
+	}
+}
+
+func Test_UnmarshalEvent(t *testing.T) {
+	want := &ThroughputSummaryEvent{
+		Time:     time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC),
+		Interval: time.Minute,
+		Routes:   []ThroughputSummary{{Direction: "download", Origin: "origin:21", Transfers: 2, P50: 1.5}},
+	}
+	b, err := MarshalEvent(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnmarshalEvent(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmarshalEvent() = %+v, want %+v", got, want)
+	}
+
+	for _, record := range []string{
+		`{"schema_version":2,"type":"drain","event":{}}`,
+		`{"type":"drain","event":{}}`,
+		`{"schema_version":1,"type":"unknown","event":{}}`,
+	} {
+		if _, err := UnmarshalEvent([]byte(record)); err == nil {
+			t.Errorf("UnmarshalEvent(%s) is not error", record)
+		}
+	}
+}
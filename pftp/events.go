@@ -0,0 +1,560 @@
+package pftp
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	eventBufferSize = 1024
+)
+
+// Event is implemented by all events emitted by pftp server
+type Event interface {
+	EventType() string
+}
+
+// OriginUnresolvedEvent is emitted when no origin could be resolved for user
+type OriginUnresolvedEvent struct {
+	Time       time.Time `json:"time"`
+	SessionID  uint64    `json:"session_id"`
+	ClientAddr string    `json:"client_addr"`
+	User       string    `json:"user"`
+}
+
+// EventType return event type name
+func (e *OriginUnresolvedEvent) EventType() string { return "origin_unresolved" }
+
+// UploadMirrorErrorEvent is emitted when upload mirror failed.
+// primary upload is not affected by it.
+type UploadMirrorErrorEvent struct {
+	Time      time.Time `json:"time"`
+	SessionID uint64    `json:"session_id"`
+	Command   string    `json:"command"`
+	Path      string    `json:"path"`
+	Error     string    `json:"error"`
+}
+
+// EventType return event type name
+func (e *UploadMirrorErrorEvent) EventType() string { return "upload_mirror_error" }
+
+// ServerStartEvent is emitted when server starts accepting connections
+type ServerStartEvent struct {
+	Time time.Time `json:"time"`
+	Addr string    `json:"addr"`
+}
+
+// EventType return event type name
+func (e *ServerStartEvent) EventType() string { return "server_start" }
+
+// ListenerErrorEvent is emitted when listener failed to accept connection
+type ListenerErrorEvent struct {
+	Time  time.Time `json:"time"`
+	Addr  string    `json:"addr"`
+	Error string    `json:"error"`
+}
+
+// EventType return event type name
+func (e *ListenerErrorEvent) EventType() string { return "listener_error" }
+
+// ServerShutdownEvent is emitted when server stopped accepting connections.
+// ActiveSessions is count of sessions still connected at the time.
+type ServerShutdownEvent struct {
+	Time           time.Time     `json:"time"`
+	Uptime         time.Duration `json:"uptime"`
+	TotalSessions  uint64        `json:"total_sessions"`
+	ActiveSessions int32         `json:"active_sessions"`
+}
+
+// EventType return event type name
+func (e *ServerShutdownEvent) EventType() string { return "server_shutdown" }
+
+// OriginSwitchEvent is emitted when session switched origin on login
+type OriginSwitchEvent struct {
+	Time      time.Time     `json:"time"`
+	SessionID uint64        `json:"session_id"`
+	User      string        `json:"user"`
+	From      string        `json:"from"`
+	To        string        `json:"to"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error"`
+}
+
+// EventType return event type name
+func (e *OriginSwitchEvent) EventType() string { return "origin_switch" }
+
+// StalledTransferEvent is emitted when no bytes moved on data transfer
+// for stalled_transfer_timeout seconds
+type StalledTransferEvent struct {
+	Time        time.Time     `json:"time"`
+	SessionID   uint64        `json:"session_id"`
+	Direction   string        `json:"direction"`
+	Transferred int64         `json:"transferred"`
+	IdleFor     time.Duration `json:"idle_for"`
+}
+
+// EventType return event type name
+func (e *StalledTransferEvent) EventType() string { return "stalled_transfer" }
+
+// OriginNoticeEvent is emitted when origin sent reply without command
+// (ex. shutdown notice or idle warning). Action is how pftp handled it.
+type OriginNoticeEvent struct {
+	Time      time.Time `json:"time"`
+	SessionID uint64    `json:"session_id"`
+	Origin    string    `json:"origin"`
+	Code      string    `json:"code"`
+	Message   string    `json:"message"`
+	Action    string    `json:"action"`
+}
+
+// EventType return event type name
+func (e *OriginNoticeEvent) EventType() string { return "origin_notice" }
+
+type eventBus struct {
+	ch chan Event
+	// called with every event before it is sent to channel. they are
+	// added while server is built and must not block.
+	observers []func(Event)
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		ch: make(chan Event, eventBufferSize),
+	}
+}
+
+// add observer of events. it must be called before events are emitted.
+func (b *eventBus) observe(f func(Event)) {
+	if b == nil {
+		return
+	}
+
+	b.observers = append(b.observers, f)
+}
+
+// send event to channel without blocking.
+// if nobody read events and buffer is full, event will be dropped.
+func (b *eventBus) emit(e Event) {
+	// eventBus is nil when unit test
+	if b == nil {
+		return
+	}
+
+	for _, observe := range b.observers {
+		observe(e)
+	}
+
+	select {
+	case b.ch <- e:
+	default:
+		logrus.Debugf("event buffer is full. drop %s event", e.EventType())
+	}
+}
+
+// ClientBannedEvent is emitted when client IP is banned by login failures
+type ClientBannedEvent struct {
+	Time       time.Time `json:"time"`
+	SessionID  uint64    `json:"session_id"`
+	ClientAddr string    `json:"client_addr"`
+	User       string    `json:"user"`
+	Until      time.Time `json:"until"`
+}
+
+// EventType return event type name
+func (e *ClientBannedEvent) EventType() string { return "client_banned" }
+
+// TLSHandshakeErrorEvent is emitted when TLS handshake with client failed.
+// ClientHello parameters are empty when client did not send ClientHello.
+type TLSHandshakeErrorEvent struct {
+	Time         time.Time `json:"time"`
+	SessionID    uint64    `json:"session_id"`
+	ClientAddr   string    `json:"client_addr"`
+	Channel      string    `json:"channel"` // control or data
+	Reason       string    `json:"reason"`  // protocol_version, no_shared_cipher, bad_certificate, plain_text, timeout, closed or other
+	Error        string    `json:"error"`
+	ServerName   string    `json:"server_name"`
+	Versions     []string  `json:"versions"`
+	CipherSuites []string  `json:"cipher_suites"`
+	ALPN         []string  `json:"alpn"`
+}
+
+// EventType return event type name
+func (e *TLSHandshakeErrorEvent) EventType() string { return "tls_handshake_error" }
+
+// OriginThresholdEvent is emitted when statistics of origin crossed threshold.
+// Metric is connections or error_rate. Exceeded is false when it went back below threshold.
+type OriginThresholdEvent struct {
+	Time      time.Time `json:"time"`
+	Origin    string    `json:"origin"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Exceeded  bool      `json:"exceeded"`
+}
+
+// EventType return event type name
+func (e *OriginThresholdEvent) EventType() string { return "origin_threshold" }
+
+// OriginCircuitEvent is emitted when circuit of origin changed state.
+// State is open, half_open or closed. Failures is count of consecutive failures.
+type OriginCircuitEvent struct {
+	Time     time.Time `json:"time"`
+	Origin   string    `json:"origin"`
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+}
+
+// EventType return event type name
+func (e *OriginCircuitEvent) EventType() string { return "origin_circuit" }
+
+// CommandReplyEvent is emitted when origin sent final reply to command.
+// Preliminary is true when 1xx reply came before it. Latency is time from
+// sending command to final reply, including data transfer of transfer commands.
+type CommandReplyEvent struct {
+	Time        time.Time     `json:"time"`
+	SessionID   uint64        `json:"session_id"`
+	Origin      string        `json:"origin"`
+	Command     string        `json:"command"`
+	Code        int           `json:"code"`
+	Preliminary bool          `json:"preliminary"`
+	Latency     time.Duration `json:"latency"`
+}
+
+// EventType return event type name
+func (e *CommandReplyEvent) EventType() string { return "command_reply" }
+
+// CommandTimeoutEvent is emitted when origin did not reply to command
+// in its command timeout. Code is reply sent to client. History is last
+// control lines of session by session_history.
+type CommandTimeoutEvent struct {
+	Time      time.Time     `json:"time"`
+	SessionID uint64        `json:"session_id"`
+	Origin    string        `json:"origin"`
+	Command   string        `json:"command"`
+	Timeout   time.Duration `json:"timeout"`
+	Code      int           `json:"code"`
+	History   []TailLine    `json:"history,omitempty"`
+}
+
+// EventType return event type name
+func (e *CommandTimeoutEvent) EventType() string { return "command_timeout" }
+
+// SecurityEvent is emitted when client command is rejected as attack.
+// Reason is port_bounce when PORT or EPRT address is not client IP.
+type SecurityEvent struct {
+	Time       time.Time `json:"time"`
+	SessionID  uint64    `json:"session_id"`
+	ClientAddr string    `json:"client_addr"`
+	User       string    `json:"user"`
+	Command    string    `json:"command"`
+	Target     string    `json:"target"`
+	Reason     string    `json:"reason"`
+}
+
+// EventType return event type name
+func (e *SecurityEvent) EventType() string { return "security" }
+
+// FXPEvent is emitted when client makes site-to-site transfer (FXP) between
+// origin and other server. Kind is port_target when PORT or EPRT address is
+// other host, or passive_peer when other host connects to passive address.
+// Action is allowed or blocked.
+type FXPEvent struct {
+	Time       time.Time `json:"time"`
+	SessionID  uint64    `json:"session_id"`
+	ClientAddr string    `json:"client_addr"`
+	User       string    `json:"user"`
+	Origin     string    `json:"origin"`
+	Command    string    `json:"command"`
+	Peer       string    `json:"peer"`
+	Kind       string    `json:"kind"`
+	Action     string    `json:"action"`
+}
+
+// EventType return event type name
+func (e *FXPEvent) EventType() string { return "fxp" }
+
+// ClientDisconnectEvent is emitted when client session is closed.
+// Reason is client_quit, client_closed, idle_timeout, transfer_timeout,
+// origin_failure, policy_kill, server_shutdown, resource_pressure or standby. Bytes is sum of data transferred.
+// History is last control lines by session_history when reason is origin_failure or transfer_timeout.
+type ClientDisconnectEvent struct {
+	Time       time.Time     `json:"time"`
+	SessionID  uint64        `json:"session_id"`
+	ClientAddr string        `json:"client_addr"`
+	User       string        `json:"user"`
+	Origin     string        `json:"origin"`
+	Reason     string        `json:"reason"`
+	Duration   time.Duration `json:"duration"`
+	Bytes      int64         `json:"bytes"`
+	History    []TailLine    `json:"history,omitempty"`
+}
+
+// EventType return event type name
+func (e *ClientDisconnectEvent) EventType() string { return "client_disconnect" }
+
+// TransferResumeEvent is emitted when RETR is resumed on new origin data
+// connection after origin data connection failed. Offset is REST offset
+// sent to origin. Error is empty when resume succeeded.
+type TransferResumeEvent struct {
+	Time      time.Time `json:"time"`
+	SessionID uint64    `json:"session_id"`
+	Origin    string    `json:"origin"`
+	Offset    int64     `json:"offset"`
+	Attempt   int       `json:"attempt"`
+	Error     string    `json:"error"`
+}
+
+// EventType return event type name
+func (e *TransferResumeEvent) EventType() string { return "transfer_resume" }
+
+// DrainEvent is emitted when server started draining (Phase "start"), when
+// number of sessions changed while draining ("progress") and when it finished
+// ("done"). Reason of done is completed or deadline, and Remaining is time
+// left to grace deadline. Origin is set when one origin is drained by admin API.
+type DrainEvent struct {
+	Time           time.Time     `json:"time"`
+	Origin         string        `json:"origin,omitempty"`
+	Phase          string        `json:"phase"`
+	ActiveSessions int32         `json:"active_sessions"`
+	Remaining      time.Duration `json:"remaining"`
+	Reason         string        `json:"reason"`
+}
+
+// EventType return event type name
+func (e *DrainEvent) EventType() string { return "drain" }
+
+// StandbyEvent is emitted when server got standby or active. Source is
+// admin (admin API), coordinator (HA coordinator or standby_check_script)
+// or api (Promote and Demote of embedder).
+type StandbyEvent struct {
+	Time           time.Time `json:"time"`
+	State          string    `json:"state"`
+	Source         string    `json:"source"`
+	ActiveSessions int32     `json:"active_sessions"`
+}
+
+// EventType return event type name
+func (e *StandbyEvent) EventType() string { return "standby" }
+
+// CommandRetryEvent is emitted when command is resent to origin after
+// transient reply Code. Attempt starts from 1.
+type CommandRetryEvent struct {
+	Time      time.Time `json:"time"`
+	SessionID uint64    `json:"session_id"`
+	Origin    string    `json:"origin"`
+	Command   string    `json:"command"`
+	Code      string    `json:"code"`
+	Attempt   int       `json:"attempt"`
+}
+
+// EventType return event type name
+func (e *CommandRetryEvent) EventType() string { return "command_retry" }
+
+// PartialTransferEvent is emitted when client ended RETR before end of file
+// by closing data connection or ABOR. Bytes is sent to client from REST Offset.
+// Reason is client_closed or abort.
+type PartialTransferEvent struct {
+	Time      time.Time `json:"time"`
+	SessionID uint64    `json:"session_id"`
+	Path      string    `json:"path"`
+	Offset    int64     `json:"offset"`
+	Bytes     int64     `json:"bytes"`
+	Reason    string    `json:"reason"`
+}
+
+// EventType return event type name
+func (e *PartialTransferEvent) EventType() string { return "partial_transfer" }
+
+// IntegrityMismatchEvent is emitted when upload stored on origin does not
+// match data proxied to it by upload_verify. Check is size or algorithm of
+// checksum (ex. md5), and Expected is value of proxied data.
+// Action is warn or log.
+type IntegrityMismatchEvent struct {
+	Time       time.Time `json:"time"`
+	SessionID  uint64    `json:"session_id"`
+	ClientAddr string    `json:"client_addr"`
+	User       string    `json:"user"`
+	Origin     string    `json:"origin"`
+	Path       string    `json:"path"`
+	Check      string    `json:"check"`
+	Expected   string    `json:"expected"`
+	Actual     string    `json:"actual"`
+	Action     string    `json:"action"`
+}
+
+// EventType return event type name
+func (e *IntegrityMismatchEvent) EventType() string { return "integrity_mismatch" }
+
+// SpoolEvent is emitted when spooled upload is queued, delivered to origin,
+// scheduled for retry after failed delivery or failed by spool_retry_limit.
+// State is queued, delivered, retry or failed.
+type SpoolEvent struct {
+	Time      time.Time `json:"time"`
+	SessionID uint64    `json:"session_id"`
+	ID        string    `json:"id"`
+	User      string    `json:"user"`
+	Origin    string    `json:"origin"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	State     string    `json:"state"`
+	Attempt   int       `json:"attempt"`
+	Error     string    `json:"error"`
+}
+
+// EventType return event type name
+func (e *SpoolEvent) EventType() string { return "spool" }
+
+// AdminActionEvent is emitted when admin action is requested and is recorded
+// to admin_audit_log as JSON line. Actor is cert:<common name>, token:<hash>,
+// signal:<name> or anonymous, and Status is HTTP status of response. Action is
+// kill_session, tail_session, capture_session, capture_status, stop_capture,
+// notify, clear_ban, block_user, lift_block, drain, drain_origin,
+// undrain_origin, promote, demote, retry_spool, drop_spool or stop. admin_audit_log has record of Phase "start" (Status 0)
+// before each request runs as well, and event is emitted only when it is "done".
+type AdminActionEvent struct {
+	Time       time.Time         `json:"time"`
+	Actor      string            `json:"actor"`
+	Role       string            `json:"role,omitempty"`
+	RemoteAddr string            `json:"remote_addr,omitempty"`
+	Action     string            `json:"action"`
+	Phase      string            `json:"phase,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	Status     int               `json:"status"`
+}
+
+// EventType return event type name
+func (e *AdminActionEvent) EventType() string { return "admin_action" }
+
+// ResourcePressureEvent is emitted when open file descriptors or RSS got over
+// max_open_fds or max_rss_bytes (State "high"), when they got under them
+// ("normal") and when accept failed by file descriptor limit ("accept_error").
+// ShedSessions is number of idle sessions closed.
+type ResourcePressureEvent struct {
+	Time         time.Time `json:"time"`
+	State        string    `json:"state"`
+	OpenFDs      int       `json:"open_fds"`
+	MaxOpenFDs   int       `json:"max_open_fds"`
+	RSSBytes     int64     `json:"rss_bytes"`
+	MaxRSSBytes  int64     `json:"max_rss_bytes"`
+	ShedSessions int       `json:"shed_sessions"`
+	Error        string    `json:"error"`
+}
+
+// EventType return event type name
+func (e *ResourcePressureEvent) EventType() string { return "resource_pressure" }
+
+// DiskPressureEvent is emitted when free space of file system of Dir (spool
+// or stream buffer directory) got under min_free_disk_bytes or
+// min_free_disk_percent (State "low"), and when it recovered ("normal").
+type DiskPressureEvent struct {
+	Time           time.Time `json:"time"`
+	Dir            string    `json:"dir"`
+	State          string    `json:"state"`
+	FreeBytes      int64     `json:"free_bytes"`
+	TotalBytes     int64     `json:"total_bytes"`
+	MinFreeBytes   int64     `json:"min_free_bytes"`
+	MinFreePercent float64   `json:"min_free_percent"`
+}
+
+// EventType return event type name
+func (e *DiskPressureEvent) EventType() string { return "disk_pressure" }
+
+// ThroughputSummaryEvent is emitted each throughput_summary_interval with
+// throughput of file transfers ended in Interval. it is not emitted when no
+// file was transferred.
+type ThroughputSummaryEvent struct {
+	Time     time.Time           `json:"time"`
+	Interval time.Duration       `json:"interval"`
+	Routes   []ThroughputSummary `json:"routes"`
+}
+
+// EventType return event type name
+func (e *ThroughputSummaryEvent) EventType() string { return "throughput_summary" }
+
+// UploadEvent is emitted at end of STOR when duplicate_upload_window is set.
+// Key correlates upload by path, size, user and time, and duplicates of
+// upload in the window have its Key and Duplicate. rejected duplicates are
+// emitted with Rejected and size announced by ALLO (-1 when unknown).
+type UploadEvent struct {
+	Time              time.Time `json:"time"`
+	SessionID         uint64    `json:"session_id"`
+	User              string    `json:"user"`
+	Origin            string    `json:"origin"`
+	Path              string    `json:"path"`
+	Size              int64     `json:"size"`
+	Completed         bool      `json:"completed"`
+	Key               string    `json:"key"`
+	Duplicate         bool      `json:"duplicate"`
+	Rejected          bool      `json:"rejected"`
+	PreviousSessionID uint64    `json:"previous_session_id"`
+}
+
+// EventType return event type name
+func (e *UploadEvent) EventType() string { return "upload" }
+
+// OriginNotReadyEvent is emitted when origin greeted with 120 (service ready
+// in nnn minutes). Action is origin_not_ready policy taken, and "failover"
+// when Delay is over origin_not_ready_max_wait.
+type OriginNotReadyEvent struct {
+	Time      time.Time     `json:"time"`
+	SessionID uint64        `json:"session_id"`
+	Origin    string        `json:"origin"`
+	Reply     string        `json:"reply"`
+	Delay     time.Duration `json:"delay"`
+	Action    string        `json:"action"`
+}
+
+// EventType return event type name
+func (e *OriginNotReadyEvent) EventType() string { return "origin_not_ready" }
+
+// TLSRenegotiationEvent is emitted when client started TLS renegotiation on
+// control or data connection after handshake. connection is closed because
+// it is not supported.
+type TLSRenegotiationEvent struct {
+	Time       time.Time `json:"time"`
+	SessionID  uint64    `json:"session_id"`
+	ClientAddr string    `json:"client_addr"`
+	Channel    string    `json:"channel"`
+}
+
+// EventType return event type name
+func (e *TLSRenegotiationEvent) EventType() string { return "tls_renegotiation" }
+
+// UserBlockEvent is emitted when user is blocked or its block is lifted by
+// admin API, and when login of blocked user is refused. Sessions is count of
+// sessions closed by block.
+type UserBlockEvent struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user"`
+	Action     string    `json:"action"`
+	Until      time.Time `json:"until"`
+	Sessions   int       `json:"sessions"`
+	SessionID  uint64    `json:"session_id"`
+	ClientAddr string    `json:"client_addr"`
+}
+
+// EventType return event type name
+func (e *UserBlockEvent) EventType() string { return "user_block" }
+
+// SLOSummaryEvent is emitted each slo_interval with success rates of logins
+// and transfers, and p95 latency of other commands in Interval. burn rates
+// are failure rates by error budgets of slo_login_target and
+// slo_transfer_target. it is not emitted when no command was replied.
+type SLOSummaryEvent struct {
+	Time                time.Time     `json:"time"`
+	Interval            time.Duration `json:"interval"`
+	Logins              int           `json:"logins"`
+	LoginFailures       int           `json:"login_failures"`
+	LoginSuccessRate    float64       `json:"login_success_rate"`
+	LoginBurnRate       float64       `json:"login_burn_rate"`
+	Transfers           int           `json:"transfers"`
+	TransferFailures    int           `json:"transfer_failures"`
+	TransferSuccessRate float64       `json:"transfer_success_rate"`
+	TransferBurnRate    float64       `json:"transfer_burn_rate"`
+	Commands            int           `json:"commands"`
+	CommandLatencyP95   time.Duration `json:"command_latency_p95"`
+}
+
+// EventType return event type name
+func (e *SLOSummaryEvent) EventType() string { return "slo_summary" }
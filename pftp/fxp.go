@@ -0,0 +1,91 @@
+package pftp
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// kinds and actions of FXPEvent
+const (
+	fxpPortTarget  = "port_target"
+	fxpPassivePeer = "passive_peer"
+	fxpAllowed     = "allowed"
+	fxpBlocked     = "blocked"
+)
+
+// key of fxp_allowed_peers for peers allowed with all origins
+const fxpAnyOrigin = "*"
+
+func validateFXPAllowedPeers(peers map[string][]string) error {
+	for origin, list := range peers {
+		for _, a := range list {
+			if _, _, err := net.ParseCIDR(a); err != nil && net.ParseIP(a) == nil {
+				return fmt.Errorf("configuration error: FXP peer %s of origin %s is wrong", a, origin)
+			}
+		}
+	}
+
+	return nil
+}
+
+// return true when site-to-site transfer between origin and peer is allowed
+// by fxp_allowed_peers
+func fxpPairAllowed(allowed map[string][]string, origin string, peer net.IP) bool {
+	return inAllowlist(peer, allowed[origin]) || inAllowlist(peer, allowed[fxpAnyOrigin])
+}
+
+func (c *clientHandler) fxpOrigin() string {
+	if c.proxy == nil {
+		return ""
+	}
+
+	return c.proxy.originAddr
+}
+
+// return true when FXP between origin of session and peer is allowed
+func (c *clientHandler) fxpAllowed(peer net.IP) bool {
+	return fxpPairAllowed(c.config.FXPAllowedPeers, c.fxpOrigin(), peer)
+}
+
+func (c *clientHandler) reportFXP(kind string, command string, peer string, action string) {
+	origin := c.fxpOrigin()
+	c.log.info("FXP of %s between %s and %s is %s", command, origin, peer, action)
+	c.metrics.inc("pftp_fxp_total", "Site-to-site transfers (FXP) by kind and action.", "kind", kind, "action", action)
+	c.events.emit(&FXPEvent{
+		Time:       time.Now(),
+		SessionID:  c.id,
+		ClientAddr: c.srcIP,
+		User:       c.log.user,
+		Origin:     origin,
+		Command:    command,
+		Peer:       peer,
+		Kind:       kind,
+		Action:     action,
+	})
+}
+
+// check data connection from peer to passive listener of command, which is
+// FXP when client gave passive address to other server. return true when it
+// is allowed.
+func (c *clientHandler) allowPassivePeer(command string, peer string) bool {
+	action := fxpBlocked
+	if ip := net.ParseIP(peer); ip != nil && c.fxpAllowed(ip) {
+		action = fxpAllowed
+	}
+	c.reportFXP(fxpPassivePeer, command, peer, action)
+
+	return action == fxpAllowed
+}
+
+// reject data connection to passive listener from host other than client.
+// it is FXP when client gave passive address to other server.
+func (d *dataHandler) checkPassivePeer(conn net.Conn) error {
+	ip := addrIP(conn.RemoteAddr())
+	if d.allowPeer == nil || ip == d.clientIP || ip == d.clientConn.originalRemoteIP || d.allowPeer(ip) {
+		return nil
+	}
+
+	conn.Close()
+	return fmt.Errorf("data connection from %s is rejected. it is not client IP", ip)
+}
@@ -0,0 +1,111 @@
+package pftp
+
+import (
+	"net"
+	"testing"
+)
+
+func Test_clientHandler_checkPortTarget_fxp(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		peers      map[string][]string
+		wantCode   int
+		wantAction string
+	}{
+		{name: "no_peers", line: "PORT 198,51,100,20,0,25", wantCode: 500, wantAction: fxpBlocked},
+		{name: "origin_peer", line: "PORT 198,51,100,20,0,25", peers: map[string][]string{"10.0.0.5:21": {"198.51.100.20"}}, wantAction: fxpAllowed},
+		{name: "any_origin_peer", line: "EPRT |1|198.51.100.20|25|", peers: map[string][]string{"*": {"198.51.100.0/24"}}, wantAction: fxpAllowed},
+		{name: "other_origin_peer", line: "PORT 198,51,100,20,0,25", peers: map[string][]string{"10.0.0.6:21": {"198.51.100.20"}}, wantCode: 500, wantAction: fxpBlocked},
+		{name: "client_ip", line: "PORT 203,0,113,7,4,1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := newEventBus()
+			c := &clientHandler{
+				config: &Config{FXPAllowedPeers: tt.peers},
+				srcIP:  "203.0.113.7:50000",
+				log:    &logger{},
+				events: events,
+				proxy:  &proxyServer{originAddr: "10.0.0.5:21"},
+			}
+			c.parseLine(tt.line)
+
+			r := c.checkPortTarget()
+			if tt.wantCode == 0 && r != nil || tt.wantCode > 0 && (r == nil || r.code != tt.wantCode) {
+				t.Fatalf("checkPortTarget() = %+v, want code %d", r, tt.wantCode)
+			}
+
+			var got *FXPEvent
+			for len(events.ch) > 0 {
+				if e, ok := (<-events.ch).(*FXPEvent); ok {
+					got = e
+				}
+			}
+			if len(tt.wantAction) == 0 {
+				if got != nil {
+					t.Errorf("event = %+v, want no FXP", got)
+				}
+				return
+			}
+			if got == nil || got.Action != tt.wantAction || got.Kind != fxpPortTarget || got.Origin != "10.0.0.5:21" {
+				t.Errorf("event = %+v, want %s %s", got, fxpPortTarget, tt.wantAction)
+			}
+		})
+	}
+}
+
+func Test_dataHandler_checkPassivePeer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tests := []struct {
+		name      string
+		clientIP  string
+		controlIP string
+		allow     bool
+		check     bool
+		wantErr   bool
+	}{
+		{name: "client_ip", clientIP: "127.0.0.1", check: true},
+		{name: "control_peer_ip", clientIP: "203.0.113.7", controlIP: "127.0.0.1", check: true},
+		{name: "other_host", clientIP: "203.0.113.7", check: true, wantErr: true},
+		{name: "other_host_allowed", clientIP: "203.0.113.7", check: true, allow: true},
+		{name: "not_checked", clientIP: "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			conn, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			d := &dataHandler{clientIP: tt.clientIP}
+			d.clientConn.originalRemoteIP = tt.controlIP
+			var peer string
+			if tt.check {
+				d.allowPeer = func(ip string) bool {
+					peer = ip
+					return tt.allow
+				}
+			}
+
+			err = d.checkPassivePeer(conn)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkPassivePeer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (tt.wantErr || tt.allow) && peer != "127.0.0.1" {
+				t.Errorf("peer = %q, want 127.0.0.1", peer)
+			}
+		})
+	}
+}
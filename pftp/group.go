@@ -0,0 +1,381 @@
+package pftp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+var serverNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// GroupServer is state of server in ServerGroup
+type GroupServer struct {
+	Name       string `json:"name"`
+	ListenAddr string `json:"listen_addr"`
+	Sessions   int32  `json:"sessions"`
+	Draining   bool   `json:"draining"`
+	Standby    bool   `json:"standby"`
+}
+
+type groupMember struct {
+	name   string
+	server *FtpServer
+	admin  http.Handler
+}
+
+// ServerGroup run independent virtual servers (ex. small tenants) in one
+// process. each server has own listener, config and middleware. group
+// serves one admin API for all of them and stops them together.
+//
+// admin API of group is on admin_listen_addr of group config and
+// authenticated by its admin_* keys. it has /metrics of all servers labeled
+// by server name, /servers and /readyz, and other endpoints of each server
+// under /servers/:name/ authenticated by config of the server.
+type ServerGroup struct {
+	mutex   sync.Mutex
+	config  *Config
+	members []*groupMember
+	started bool
+	admin   *http.Server
+	logger  logrus.FieldLogger
+}
+
+// NewServerGroup create group of servers. only admin_* keys of c are used.
+// admin API is disabled when admin_listen_addr is empty.
+func NewServerGroup(c *Config) (*ServerGroup, error) {
+	if c == nil {
+		c = &Config{}
+	}
+	if err := validateAdminAuth(c); err != nil {
+		return nil, err
+	}
+
+	return &ServerGroup{config: c, logger: logrus.StandardLogger()}, nil
+}
+
+// Add create server of name by config and add it to group. name is label of
+// metrics and logs, and path of its admin API. servers can not be added after
+// Start.
+func (g *ServerGroup) Add(name string, c *Config, opts ...Option) (*FtpServer, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !serverNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("server name must be letters, digits, '_', '.' or '-': %q", name)
+	}
+	if g.started {
+		return nil, fmt.Errorf("server group is already started")
+	}
+	for _, m := range g.members {
+		if m.name == name {
+			return nil, fmt.Errorf("server %s already exists in group", name)
+		}
+	}
+
+	server, err := NewFtpServerWithConfig(c, append(opts, withServerName(name))...)
+	if err != nil {
+		return nil, fmt.Errorf("server %s: %s", name, err.Error())
+	}
+	g.members = append(g.members, &groupMember{name: name, server: server, admin: server.adminHandler()})
+
+	return server, nil
+}
+
+// label metrics and logs of server by name. it is applied after other options.
+func withServerName(name string) Option {
+	return func(server *FtpServer) {
+		server.metrics.serverLabels = []string{"server", name}
+		server.logger = server.logger.WithField("server", name)
+	}
+}
+
+// Server return server of name in group. nil when it is unknown.
+func (g *ServerGroup) Server(name string) *FtpServer {
+	if m := g.member(name); m != nil {
+		return m.server
+	}
+
+	return nil
+}
+
+func (g *ServerGroup) member(name string) *groupMember {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for _, m := range g.members {
+		if m.name == name {
+			return m
+		}
+	}
+
+	return nil
+}
+
+func (g *ServerGroup) servers() []*FtpServer {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	servers := make([]*FtpServer, 0, len(g.members))
+	for _, m := range g.members {
+		servers = append(servers, m.server)
+	}
+
+	return servers
+}
+
+// Start listen and serve all servers until signal like FtpServer.Start.
+// SIGTERM drains servers with drain_timeout and stops others, and SIGHUP
+// stops all. when one server stops by error, others are stopped as well.
+func (g *ServerGroup) Start() error {
+	g.mutex.Lock()
+	if len(g.members) == 0 {
+		g.mutex.Unlock()
+		return fmt.Errorf("server group has no server")
+	}
+	g.started = true
+	g.mutex.Unlock()
+
+	servers := g.servers()
+	for i, server := range servers {
+		if err := server.listen(); err != nil {
+			for _, s := range servers[:i] {
+				s.getListener().Close()
+			}
+			return err
+		}
+	}
+	if err := g.startAdmin(); err != nil {
+		for _, s := range servers {
+			s.getListener().Close()
+		}
+		return err
+	}
+
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *FtpServer) {
+			err := server.serve()
+			if server.shutdown.IsSet() {
+				err = nil
+			}
+			errs <- err
+		}(server)
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP, syscall.SIGTERM)
+	defer signal.Stop(ch)
+
+	var lastError error
+	remaining := len(servers)
+	select {
+	case sig := <-ch:
+		if sig == syscall.SIGTERM {
+			lastError = g.drainAll("SIGTERM", func(s *FtpServer) time.Duration { return s.drainGrace() })
+		} else {
+			for _, server := range servers {
+				server.recordSignal("SIGHUP", "stop")
+			}
+			lastError = g.Stop()
+		}
+	case err := <-errs:
+		// nil is returned after Stop or Drain of group
+		remaining--
+		lastError = err
+		if err != nil {
+			g.Stop()
+		}
+	}
+
+	for ; remaining > 0; remaining-- {
+		if err := <-errs; err != nil && lastError == nil {
+			lastError = err
+		}
+	}
+
+	return lastError
+}
+
+// Stop stop all servers and admin API of group
+func (g *ServerGroup) Stop() error {
+	var lastError error
+	for _, server := range g.servers() {
+		if err := server.stop(); err != nil {
+			lastError = err
+		}
+	}
+	g.stopAdmin()
+
+	return lastError
+}
+
+// Drain drain all servers at once and stop them after sessions hit zero or
+// grace passed
+func (g *ServerGroup) Drain(grace time.Duration) error {
+	return g.drainAll("", func(*FtpServer) time.Duration { return grace })
+}
+
+// drain servers with grace and stop them. server without grace is stopped
+// at once. actions are recorded when they are started by signal.
+func (g *ServerGroup) drainAll(sig string, grace func(*FtpServer) time.Duration) error {
+	eg := errgroup.Group{}
+	for _, server := range g.servers() {
+		server := server
+		eg.Go(func() error {
+			d := grace(server)
+			if len(sig) > 0 {
+				action := "stop"
+				if d > 0 {
+					action = "drain"
+				}
+				server.recordSignal(sig, action)
+			}
+			if d > 0 {
+				return server.Drain(d)
+			}
+			return server.stop()
+		})
+	}
+	err := eg.Wait()
+	g.stopAdmin()
+
+	return err
+}
+
+// return state of servers in order of Add
+func (g *ServerGroup) status() []GroupServer {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	status := make([]GroupServer, 0, len(g.members))
+	for _, m := range g.members {
+		addr := m.server.config.ListenAddr
+		if l := m.server.getListener(); l != nil {
+			addr = l.Addr().String()
+		}
+		status = append(status, GroupServer{
+			Name:       m.name,
+			ListenAddr: addr,
+			Sessions:   atomic.LoadInt32(&m.server.currentConnection),
+			Draining:   m.server.Draining(),
+			Standby:    m.server.Standby(),
+		})
+	}
+
+	return status
+}
+
+// return handler of admin API of group
+func (g *ServerGroup) adminHandler() http.Handler {
+	router := httprouter.New()
+	observe := func(h httprouter.Handle) httprouter.Handle { return authorizeAdmin(g.config, adminRoleObserver, h) }
+
+	router.GET("/metrics", observe(g.handleMetrics))
+	router.GET("/servers", observe(g.handleListServers))
+	// probe of orchestrator is not authenticated
+	router.GET("/readyz", g.handleReadiness)
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+		router.Handle(method, "/servers/:name/*path", g.handleServerAdmin)
+	}
+
+	return router
+}
+
+// start admin API of group on admin_listen_addr in background
+func (g *ServerGroup) startAdmin() error {
+	if len(g.config.AdminListenAddr) == 0 {
+		return nil
+	}
+
+	l, err := listenAdmin(g.config)
+	if err != nil {
+		return err
+	}
+	if !adminAuthConfigured(g.config) {
+		g.logger.Warn("admin API of server group is not authenticated. set admin_tokens or admin_client_ca")
+	}
+	g.logger.Info("Admin API of server group listening address ", l.Addr())
+
+	g.mutex.Lock()
+	g.admin = &http.Server{Handler: g.adminHandler()}
+	admin := g.admin
+	g.mutex.Unlock()
+	go func() {
+		if err := admin.Serve(l); err != nil && err != http.ErrServerClosed {
+			g.logger.Error("admin API of server group stopped: ", err.Error())
+		}
+	}()
+
+	return nil
+}
+
+func (g *ServerGroup) stopAdmin() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.admin != nil {
+		g.admin.Close()
+		g.admin = nil
+	}
+}
+
+// GET /metrics
+// return metrics of all servers in Prometheus text format. metrics have
+// server label.
+func (g *ServerGroup) handleMetrics(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	ms := []*metrics{}
+	for _, server := range g.servers() {
+		ms = append(ms, server.metrics)
+	}
+	writeMetrics(w, ms...)
+}
+
+// GET /servers
+// return servers of group with their sessions
+func (g *ServerGroup) handleListServers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, g.status())
+}
+
+// GET /readyz
+// return 503 when all servers are draining or standby
+func (g *ServerGroup) handleReadiness(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	for _, server := range g.servers() {
+		if !server.Draining() && !server.Standby() {
+			writeJSON(w, http.StatusOK, map[string]bool{"ready": true})
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusServiceUnavailable, map[string]bool{"ready": false})
+}
+
+// /servers/:name/*path
+// pass request to admin API of server. ex) DELETE /servers/a/sessions/3 is
+// DELETE /sessions/3 of server a. request needs role of group config in
+// addition to role of server config.
+func (g *ServerGroup) handleServerAdmin(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	m := g.member(ps.ByName("name"))
+	if m == nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "unknown server"})
+		return
+	}
+
+	u := *r.URL
+	u.Path, u.RawPath = ps.ByName("path"), ""
+	req := r.Clone(context.WithValue(r.Context(), groupAdminConfigKey{}, g.config))
+	req.URL = &u
+	m.admin.ServeHTTP(w, req)
+}
@@ -0,0 +1,180 @@
+package pftp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestGroup(t *testing.T) *ServerGroup {
+	g, err := NewServerGroup(&Config{AdminTokens: map[string]string{"group": adminRoleObserver, "token-a": adminRoleOperator}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		c := DefaultConfig()
+		c.AdminTokens = map[string]string{"token-" + name: adminRoleOperator}
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server, err := g.Add(name, c, WithListener(l))
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.metrics.inc("pftp_test_total", "Test counter.", "reason", "x")
+	}
+
+	return g
+}
+
+func Test_ServerGroup_Add(t *testing.T) {
+	g := newTestGroup(t)
+
+	tests := []struct {
+		name    string
+		server  string
+		wantErr bool
+	}{
+		{name: "new", server: "c"},
+		{name: "duplicate", server: "a", wantErr: true},
+		{name: "empty", server: "", wantErr: true},
+		{name: "slash", server: "a/b", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := g.Add(tt.server, DefaultConfig())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Add() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	if g.Server("a") == nil || g.Server("x") != nil {
+		t.Errorf("Server() does not return servers by name")
+	}
+}
+
+func Test_ServerGroup_adminHandler(t *testing.T) {
+	g := newTestGroup(t)
+	h := g.adminHandler()
+
+	tests := []struct {
+		name     string
+		path     string
+		token    string
+		wantCode int
+		want     []string
+	}{
+		{name: "servers", path: "/servers", token: "group", wantCode: http.StatusOK, want: []string{`"name":"a"`, `"name":"b"`}},
+		{name: "servers_unauthenticated", path: "/servers", wantCode: http.StatusUnauthorized},
+		{
+			name:     "metrics",
+			path:     "/metrics",
+			token:    "group",
+			wantCode: http.StatusOK,
+			want:     []string{"# HELP pftp_test_total Test counter.\n# TYPE pftp_test_total counter\npftp_test_total{server=\"a\",reason=\"x\"} 1\npftp_test_total{server=\"b\",reason=\"x\"} 1\n"},
+		},
+		{name: "server_endpoint", path: "/servers/a/sessions", token: "token-a", wantCode: http.StatusOK, want: []string{"[]"}},
+		{name: "server_endpoint_group_token", path: "/servers/a/sessions", token: "group", wantCode: http.StatusUnauthorized},
+		{name: "server_endpoint_other_token", path: "/servers/b/sessions", token: "token-a", wantCode: http.StatusUnauthorized},
+		{name: "server_endpoint_member_token_only", path: "/servers/b/sessions", token: "token-b", wantCode: http.StatusUnauthorized},
+		{name: "unknown_server", path: "/servers/x/sessions", token: "token-a", wantCode: http.StatusNotFound},
+		{name: "readyz", path: "/readyz", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if len(tt.token) > 0 {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("body = %s, want %q", rec.Body.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func Test_ServerGroup_adminHandler_memberWithoutAuth(t *testing.T) {
+	g, err := NewServerGroup(&Config{AdminTokens: map[string]string{"observer": adminRoleObserver, "operator": adminRoleOperator}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Add("a", DefaultConfig()); err != nil {
+		t.Fatal(err)
+	}
+	h := g.adminHandler()
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		wantCode int
+	}{
+		{name: "unauthenticated", method: http.MethodGet, path: "/servers/a/sessions", wantCode: http.StatusUnauthorized},
+		{name: "observer_get", method: http.MethodGet, path: "/servers/a/sessions", token: "observer", wantCode: http.StatusOK},
+		{name: "unauthenticated_post", method: http.MethodPost, path: "/servers/a/notices", wantCode: http.StatusUnauthorized},
+		{name: "observer_post", method: http.MethodPost, path: "/servers/a/notices", token: "observer", wantCode: http.StatusForbidden},
+		{name: "operator_post", method: http.MethodPost, path: "/servers/a/notices", token: "operator", wantCode: http.StatusBadRequest},
+		{name: "observer_get_of_operator_endpoint", method: http.MethodGet, path: "/servers/a/sessions/1/capture", token: "observer", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if len(tt.token) > 0 {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+		})
+	}
+}
+
+func Test_ServerGroup_Start(t *testing.T) {
+	g := newTestGroup(t)
+
+	done := make(chan error, 1)
+	go func() { done <- g.Start() }()
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := g.Add("late", DefaultConfig()); err == nil {
+		t.Errorf("Add() after Start must fail")
+	}
+
+	status := g.status()
+	if len(status) != 2 || !strings.HasPrefix(status[0].ListenAddr, "127.0.0.1:") {
+		t.Errorf("status() = %+v", status)
+	}
+
+	if err := g.Drain(time.Millisecond); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start() does not return after Drain")
+	}
+	for _, name := range []string{"a", "b"} {
+		if !g.Server(name).Draining() {
+			t.Errorf("server %s is not drained", name)
+		}
+	}
+}
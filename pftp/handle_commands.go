@@ -5,9 +5,13 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"path"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 func (c *clientHandler) handleUSER() *result {
@@ -16,16 +20,45 @@ func (c *clientHandler) handleUSER() *result {
 		if c.proxy.isLoggedIn() {
 			return &result{
 				code: 500,
-				msg:  "Already logged in",
+				msg:  c.message(msgAlreadyLoggedIn),
 				err:  fmt.Errorf("already logged in"),
 				log:  c.log,
 			}
 		}
 	}
 
-	c.log.user = c.param
+	c.user = c.param
+	c.log.user = c.redactor.user(c.param)
+	c.updateSummary()
+
+	// deny login when middleware could not resolve origin
+	if c.config.DenyUnresolved && len(c.context.RemoteAddr) == 0 {
+		c.events.emit(&OriginUnresolvedEvent{
+			Time:       time.Now(),
+			SessionID:  c.id,
+			ClientAddr: c.srcIP,
+			User:       c.log.user,
+		})
+
+		return &result{
+			code: 530,
+			msg:  c.message(msgUnresolvedOrigin),
+			err:  fmt.Errorf("origin not resolved"),
+			log:  c.log,
+		}
+	}
 
 	if err := c.connectProxy(); err != nil {
+		// origin connected but did not send welcome message, or it failed repeatedly
+		if err == errOriginGreetingTimeout || err == errOriginCircuitOpen {
+			return &result{
+				code: 421,
+				msg:  c.message(msgOriginTimeout),
+				err:  err,
+				log:  c.log,
+			}
+		}
+
 		// user not found
 		if err.Error() == "user id not found" {
 			return &result{
@@ -38,24 +71,30 @@ func (c *clientHandler) handleUSER() *result {
 
 		return &result{
 			code: 530,
-			msg:  "I can't deal with you (proxy error)",
+			msg:  c.message(msgProxyError),
 			err:  err,
 			log:  c.log,
 		}
 	}
 
+	if c.config.ExposeBackendID {
+		c.proxy.setBackendID(c.backendID())
+	}
+
 	// unsuspend proxy before send command to origin
 	c.proxy.unsuspend()
 
 	if err := c.proxy.sendToOrigin(c.line); err != nil {
 		return &result{
 			code: 530,
-			msg:  "I can't deal with you (proxy error)",
+			msg:  c.message(msgProxyError),
 			err:  err,
 			log:  c.log,
 		}
 	}
 
+	c.connectShadow()
+
 	return nil
 }
 
@@ -75,9 +114,11 @@ func (c *clientHandler) handleAUTH() *result {
 			}
 		}
 
-		tlsConn := tls.Server(c.conn, c.tlsDatas.forClient.getTLSConfig())
-		err := tlsConn.Handshake()
-		if err != nil {
+		c.buildConnTLSConfig()
+		if err := c.startControlTLS(c.conn); err != nil {
+			if err == errTLSRejected {
+				return nil
+			}
 			return &result{
 				code: 550,
 				msg:  "TLS Handshake Error",
@@ -85,38 +126,115 @@ func (c *clientHandler) handleAUTH() *result {
 				log:  c.log,
 			}
 		}
+		c.previousTLSCommands = append(c.previousTLSCommands, c.line)
 
-		c.log.debug("TLS control connection finished with client. TLS protocol version: %s and Cipher Suite: %s", getTLSProtocolName(tlsConn.ConnectionState().Version), tls.CipherSuiteName(tlsConn.ConnectionState().CipherSuite))
+		return nil
+	}
+	return &result{
+		code: 550,
+		msg:  "Cannot get a TLS config",
+	}
+}
 
-		c.conn = tlsConn
-		c.reader = bufio.NewReader(c.conn)
-		c.writer = bufio.NewWriter(c.conn)
+// errTLSRejected is returned when TLS handshake hook rejected and closed connection
+var errTLSRejected = errors.New("TLS connection rejected")
+
+// start TLS on control connection read from conn
+func (c *clientHandler) startControlTLS(conn net.Conn) error {
+	var hello *tls.ClientHelloInfo
+	rc := newRenegotiationConn(conn, reportRenegotiation(c.events, c.metrics, c.log, c.id, c.srcIP, "control"))
+	tlsConn := tls.Server(rc, recordClientHello(c.tlsDatas.clientTLSConfig(), &hello))
+	err := tlsConn.Handshake()
+	if err != nil {
+		reportTLSError(c.events, c.metrics, c.id, c.srcIP, "control", err, hello)
+		return err
+	}
+	rc.established = true
+
+	c.context.TLSServerName = tlsConn.ConnectionState().ServerName
+	c.context.TLSNegotiatedProtocol = tlsConn.ConnectionState().NegotiatedProtocol
+
+	// connection level TLS hook. reject connection when it returns error
+	if c.hooks != nil && c.hooks.tlsHandshake != nil {
+		if err := c.hooks.tlsHandshake(c.context, tlsConn.ConnectionState()); err != nil {
+			c.log.err("TLS connection rejected: %s", err.Error())
+
+			c.mutex.Lock()
+			c.conn = tlsConn
+			c.writer = bufio.NewWriter(c.conn)
+			c.mutex.Unlock()
+			if err := c.writeMessage(421, c.message(msgTLSRejected)); err != nil {
+				c.log.err("cannot send response to client")
+			}
+			connectionCloser(c, c.log)
 
-		// if proxy server attached, change proxy handler's client reader & writer to TLS conn
-		if c.proxy != nil {
-			c.proxy.clientReader = c.reader
-			c.proxy.clientWriter = c.writer
+			return errTLSRejected
 		}
+	}
 
-		c.previousTLSCommands = append(c.previousTLSCommands, c.line)
+	c.log.debug("TLS control connection finished with client. TLS protocol version: %s and Cipher Suite: %s", getTLSProtocolName(tlsConn.ConnectionState().Version), tls.CipherSuiteName(tlsConn.ConnectionState().CipherSuite))
 
-		c.controlInTLS.Set()
+	c.mutex.Lock()
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(c.conn)
+	c.writer = bufio.NewWriter(c.conn)
+	c.mutex.Unlock()
 
-		c.tlsDatas.serverName = tlsConn.ConnectionState().ServerName
-		c.tlsDatas.version = tlsConn.ConnectionState().Version
-		c.tlsDatas.cipherSuite = tlsConn.ConnectionState().CipherSuite
+	// if proxy server attached, change proxy handler's client reader & writer to TLS conn
+	if c.proxy != nil {
+		c.proxy.clientReader = c.reader
+		c.proxy.clientWriter = c.writer
+	}
 
-		// set specific client TLS informations to origin TLS config
-		c.tlsDatas.forOrigin.setServerName(c.tlsDatas.serverName)
-		c.tlsDatas.forOrigin.setSpecificTLSVersion(c.tlsDatas.version)
-		c.tlsDatas.forOrigin.setCipherSUite(c.tlsDatas.cipherSuite)
+	c.controlInTLS.Set()
 
-		return nil
+	c.tlsDatas.serverName = tlsConn.ConnectionState().ServerName
+	c.tlsDatas.version = tlsConn.ConnectionState().Version
+	c.tlsDatas.cipherSuite = tlsConn.ConnectionState().CipherSuite
+
+	c.context.TLS = true
+	c.context.TLSVersion = getTLSProtocolName(c.tlsDatas.version)
+	c.context.TLSCipherSuite = tls.CipherSuiteName(c.tlsDatas.cipherSuite)
+
+	// set specific client TLS informations to origin TLS config
+	c.tlsDatas.forOrigin.setServerName(c.tlsDatas.serverName)
+	c.tlsDatas.forOrigin.setSpecificTLSVersion(c.tlsDatas.version)
+	c.tlsDatas.forOrigin.setCipherSUite(c.tlsDatas.cipherSuite)
+
+	return nil
+}
+
+// build per-connection TLS config when TLS config hook is set or client is
+// in early_client_cert_clients.
+// hook is called with connection's ClientHello before handshake.
+func (c *clientHandler) buildConnTLSConfig() {
+	hook := c.hooks != nil && c.hooks.tlsConfig != nil
+	legacy := c.earlyClientCert()
+	if (!hook && !legacy) || c.tlsDatas.forClientConn != nil {
+		return
 	}
-	return &result{
-		code: 550,
-		msg:  "Cannot get a TLS config",
+
+	base := c.tlsDatas.forClient.getTLSConfig()
+	if legacy {
+		// client certificate requested by renegotiation is sent in first handshake
+		base = base.Clone()
+		base.ClientAuth = tls.RequestClientCert
 	}
+	if !hook {
+		c.tlsDatas.forClientConn = base
+		return
+	}
+
+	conf := base.Clone()
+	conf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		connConf := base.Clone()
+		if err := c.hooks.tlsConfig(c.context, hello, connConf); err != nil {
+			return nil, err
+		}
+		return connConf, nil
+	}
+
+	c.tlsDatas.forClientConn = conf
 }
 
 // response PBSZ to client and store command line when connect by TLS & not loggined
@@ -145,7 +263,7 @@ func (c *clientHandler) handlePBSZ() *result {
 			if err := c.proxy.sendToOrigin(c.line); err != nil {
 				return &result{
 					code: 530,
-					msg:  "I can't deal with you (proxy error)",
+					msg:  c.message(msgProxyError),
 					err:  err,
 					log:  c.log,
 				}
@@ -200,7 +318,7 @@ func (c *clientHandler) handlePROT() *result {
 			if err := c.proxy.sendToOrigin(c.line); err != nil {
 				return &result{
 					code: 530,
-					msg:  "I can't deal with you (proxy error)",
+					msg:  c.message(msgProxyError),
 					err:  err,
 					log:  c.log,
 				}
@@ -225,34 +343,90 @@ func (c *clientHandler) handleTransfer() *result {
 	if !c.proxy.isLoggedIn() {
 		return &result{
 			code: 530,
-			msg:  "Please login with USER and PASS",
+			msg:  c.message(msgLoginRequired),
 		}
 	}
 
 	if !c.proxy.isDataHandlerAvailable() {
 		return &result{
 			code: 425,
-			msg:  "Can't open data connection",
+			msg:  c.message(msgDataConnection),
 		}
 	}
 
 	if c.proxy.isDataTransferStarted() {
 		return &result{
 			code: 450,
-			msg:  fmt.Sprintf("%s: data transfer in progress", c.command),
+			msg:  c.message(msgTransferInProgress),
+		}
+	}
+
+	// retried upload of same file may be rejected
+	upload, r := c.startUpload()
+	if r != nil {
+		return r
+	}
+
+	// spooled upload is delivered to origin later
+	dataConnector := c.proxy.getDataHandler()
+	if item := c.newSpoolItem(dataConnector); item != nil {
+		return c.spoolUpload(dataConnector, item, upload)
+	}
+
+	// protect origin from too many concurrent transfers
+	release, ok := c.transfers.acquire(c.proxy.originAddr, time.Duration(c.config.TransferQueueWait)*time.Second)
+	if !ok {
+		c.log.info("concurrent transfer limit of origin %s exceeded", c.proxy.originAddr)
+		return &result{
+			code: 450,
+			msg:  c.message(msgOriginBusy),
 		}
 	}
 
 	// start data transfer by direction
+	dataConnector.clientModeZ, dataConnector.originModeZ = c.modeZ.client, c.modeZ.origin
+	dataConnector.stripes = c.newStripedTransfer(dataConnector)
+	if dataConnector.stripes == nil {
+		dataConnector.resume = c.newTransferResume(dataConnector)
+	}
+	dataConnector.listing = c.newListingPolicy()
+	rest := c.restOffset
+	c.restOffset = 0
+	user, labelUser, origin := c.accountingUser(), c.log.user, c.proxy.originAddr
+	var check *integrityCheck
 	switch c.command {
 	case "RETR", "LIST", "MLSD", "NLST":
+		if c.command == "RETR" {
+			dataConnector.path, dataConnector.offset = c.param, rest
+		}
+
 		// set transfer direction to download
-		go c.proxy.dataConnector.StartDataTransfer(downloadStream)
+		go func() {
+			defer release()
+			c.counters.start(dataConnector, downloadStream)
+			err := dataConnector.StartDataTransfer(downloadStream)
+			// data connection may fail before stripes start
+			dataConnector.stripes.end(errStripeAborted)
+			c.countTransfer(user, labelUser, origin, downloadStream, dataConnector, err == nil)
+		}()
 	case "STOR", "STOU", "APPE":
+		dataConnector.path = c.param
+		c.attachUploadMirrors(dataConnector, rest)
+		check = c.newIntegrityCheck(dataConnector, rest)
+
 		// set transfer direction to upload
-		go c.proxy.dataConnector.StartDataTransfer(uploadStream)
+		go func() {
+			defer release()
+			c.counters.start(dataConnector, uploadStream)
+			err := dataConnector.StartDataTransfer(uploadStream)
+			c.countTransfer(user, labelUser, origin, uploadStream, dataConnector, err == nil)
+			c.endUpload(upload, dataConnector.transferredBytes(), err == nil)
+		}()
+	default:
+		release()
 	}
 
+	c.landUpload(check, rest)
 	if err := c.proxy.sendToOrigin(c.line); err != nil {
 		return &result{
 			code: 500,
@@ -262,6 +436,266 @@ func (c *clientHandler) handleTransfer() *result {
 	return nil
 }
 
+// add bytes of data transfer to accounting, metrics and session total.
+// throughput is recorded for file transfers. ok is result of transfer.
+func (c *clientHandler) countTransfer(user string, labelUser string, origin string, direction string, d *dataHandler, ok bool) {
+	n := d.transferredBytes()
+	if len(d.path) > 0 {
+		c.throughput.observe(direction, origin, labelUser, n, d.duration)
+	}
+	c.accounting.addTransfer(user, direction, n)
+	c.timings.Add(timingDataCopy, d.duration)
+	c.metrics.add("pftp_transfer_bytes_total", "Bytes transferred by data connections.", float64(n),
+		"direction", direction, "origin", origin, "user", labelUser)
+	atomic.AddInt64(&c.transferred, n)
+	c.counters.end(d, direction, ok)
+	c.tail.captureTransfer(direction, d)
+}
+
+// copy uploaded data to shadow origin and upload mirror
+func (c *clientHandler) attachUploadMirrors(d *dataHandler, rest int64) {
+	var mirrors []*mirrorWriter
+
+	if c.shadow != nil && c.config.ShadowUploads {
+		line := c.line
+		mirrors = append(mirrors, newMirrorWriter(func() (io.WriteCloser, error) {
+			return c.shadow.startUpload(line)
+		}, c.log, nil))
+	}
+
+	if c.context.UploadMirror != nil && len(c.param) > 0 {
+		ctx, command, file := c.context, c.command, c.param
+		if cwd := c.currentDir(); len(cwd) > 0 && !strings.HasPrefix(file, "/") {
+			file = path.Join(cwd, file)
+		}
+		mirrorError := func(err error) {
+			c.events.emit(&UploadMirrorErrorEvent{
+				Time:      time.Now(),
+				SessionID: c.id,
+				Command:   command,
+				Path:      file,
+				Error:     err.Error(),
+			})
+		}
+
+		// mirror would get only rest of file as whole file
+		if rest > 0 {
+			c.log.info("upload of %s resumed from %d is not mirrored", file, rest)
+			mirrorError(errMirrorResumed)
+		} else {
+			mirrors = append(mirrors, newMirrorWriter(func() (io.WriteCloser, error) {
+				return ctx.UploadMirror.OpenUpload(ctx, command, file)
+			}, c.log, mirrorError))
+		}
+	}
+
+	d.mirrors = mirrors
+}
+
+// accept HOST command (RFC 7151) before login. origin is resolved by
+// host_origins config and HOST middleware, then connected on USER command.
+func (c *clientHandler) handleHOST() *result {
+	if c.proxy.isLoggedIn() {
+		return &result{
+			code: 503,
+			msg:  "HOST not allowed after login",
+		}
+	}
+
+	if len(c.context.Host) == 0 {
+		return &result{
+			code: 501,
+			msg:  "Syntax error in parameters or arguments",
+		}
+	}
+
+	return &result{
+		code: 220,
+		msg:  fmt.Sprintf("Host %s accepted", c.context.Host),
+	}
+}
+
+// resolve host name given by HOST command to origin by dynamic config and
+// host_origins config. unknown host is routed to default origin.
+func routeHost(config *Config, dynamic *dynamicConfig, param string) (string, string) {
+	host := strings.ToLower(strings.TrimSpace(param))
+	if addr, ok := dynamic.hostOrigin(host); ok {
+		return host, addr
+	}
+	if addr, ok := config.HostOrigins[host]; ok {
+		return host, addr
+	}
+
+	return host, config.RemoteAddr
+}
+
+// negotiate reply language by LANG command (RFC 2640).
+// when origin supports LANG or no locales are configured, command is
+// forwarded to origin and proxy follows the language if it has same locale.
+func (c *clientHandler) handleLANG() *result {
+	tag := strings.TrimSpace(c.param)
+
+	locale, ok := c.config.Locale, true
+	if len(tag) > 0 {
+		locale, ok = matchLocale(c.config.Locales, tag)
+	}
+
+	if len(c.config.Locales) == 0 || hasFeature(c.context.OriginFeatures, "LANG") {
+		if ok {
+			c.context.Locale = locale
+		}
+
+		if err := c.proxy.sendToOrigin(c.line); err != nil {
+			return &result{
+				code: 500,
+				msg:  fmt.Sprintf("Internal error: %s", err),
+			}
+		}
+
+		return nil
+	}
+
+	if !ok {
+		return &result{
+			code: 504,
+			msg:  fmt.Sprintf("Unsupported parameter %s", tag),
+		}
+	}
+
+	c.context.Locale = locale
+	c.proxy.setLocale(locale)
+
+	if len(locale) == 0 {
+		locale = defaultLanguage
+	}
+
+	return &result{
+		code: 200,
+		msg:  fmt.Sprintf("Language set to %s", strings.ToUpper(locale)),
+	}
+}
+
+// answer SITE WHICHBACKEND at proxy when backend ID exposure is enabled,
+// and SITE TOKEN and SITE RESUME when resumption token is enabled.
+// other SITE commands are forwarded to origin.
+func (c *clientHandler) handleSITE() *result {
+	if words := strings.Fields(c.param); c.resumption != nil && len(words) > 0 {
+		switch strings.ToUpper(words[0]) {
+		case "TOKEN":
+			return c.issueResumption()
+		case "RESUME":
+			return c.acceptResumption(words[1:])
+		}
+	}
+
+	if c.config.ExposeBackendID && strings.EqualFold(strings.TrimSpace(c.param), "WHICHBACKEND") {
+		if !c.proxy.isLoggedIn() {
+			return &result{
+				code: 530,
+				msg:  c.message(msgLoginRequired),
+			}
+		}
+
+		return &result{
+			code: 200,
+			msg:  "Backend: " + c.backendID(),
+		}
+	}
+
+	if err := c.proxy.sendToOrigin(c.line); err != nil {
+		return &result{
+			code: 500,
+			msg:  fmt.Sprintf("Internal error: %s", err),
+		}
+	}
+
+	return nil
+}
+
+// issue resumption token of current user and origin for reconnection after restart
+func (c *clientHandler) issueResumption() *result {
+	if !c.proxy.isLoggedIn() {
+		return &result{
+			code: 530,
+			msg:  c.message(msgLoginRequired),
+		}
+	}
+
+	token, err := c.resumption.issue(Resumption{
+		User:       c.user,
+		RemoteAddr: c.proxy.originAddr,
+		Data:       c.context.ResumptionData,
+	})
+	if err != nil {
+		return &result{
+			code: 451,
+			msg:  "cannot issue resumption token",
+			err:  err,
+			log:  c.log,
+		}
+	}
+
+	return &result{
+		code: 200,
+		msg:  "RESUME " + token,
+	}
+}
+
+// accept resumption token before login. middleware of USER finds it on Context.
+func (c *clientHandler) acceptResumption(params []string) *result {
+	if c.proxy.isLoggedIn() {
+		return &result{
+			code: 503,
+			msg:  "SITE RESUME not allowed after login",
+		}
+	}
+	if len(params) != 1 {
+		return &result{
+			code: 501,
+			msg:  "Syntax error in parameters or arguments",
+		}
+	}
+
+	r, err := c.resumption.open(params[0])
+	if err != nil {
+		return &result{
+			code: 501,
+			msg:  err.Error(),
+		}
+	}
+	c.context.Resumption = r
+
+	return &result{
+		code: 200,
+		msg:  "Resumption token accepted",
+	}
+}
+
+// answer NOOP at proxy when local NOOP is enabled.
+// NOOP is forwarded to origin at most once per interval for keep origin session alive.
+func (c *clientHandler) handleNOOP() *result {
+	if c.config.LocalNoop {
+		interval := time.Duration(c.config.NoopForwardInterval) * time.Second
+		if c.config.NoopForwardInterval <= 0 || !c.proxy.isLoggedIn() || time.Since(c.lastNoopForward) < interval {
+			return &result{
+				code: 200,
+				msg:  "NOOP ok",
+			}
+		}
+
+		c.lastNoopForward = time.Now()
+	}
+
+	if err := c.proxy.sendToOrigin(c.line); err != nil {
+		return &result{
+			code: 500,
+			msg:  fmt.Sprintf("Internal error: %s", err),
+		}
+	}
+
+	return nil
+}
+
 func (c *clientHandler) handlePROXY() *result {
 	params := strings.SplitN(strings.Trim(c.line, "\r\n"), " ", 6)
 	if len(params) != 6 {
@@ -281,6 +715,41 @@ func (c *clientHandler) handlePROXY() *result {
 	}
 
 	c.srcIP = params[2] + ":" + params[4]
+	c.context.ProxyProtocol = true
+
+	return nil
+}
+
+// check EPSV parameter and EPSV ALL state by RFC 2428.
+// after EPSV ALL, all data commands other than EPSV are rejected.
+func (c *clientHandler) checkExtendedDataCommand() *result {
+	if c.command == "EPSV" && len(strings.TrimSpace(c.param)) > 0 {
+		switch strings.ToUpper(strings.TrimSpace(c.param)) {
+		case "ALL":
+			c.epsvAll = true
+
+			// origin does not know EPSV ALL when data channel is proxied
+			if c.config.DataChanProxy {
+				return &result{
+					code: 200,
+					msg:  "EPSV ALL command successful",
+				}
+			}
+		case "1", "2":
+		default:
+			return &result{
+				code: 522,
+				msg:  networkProtocolNotSupported,
+			}
+		}
+	}
+
+	if c.epsvAll && c.command != "EPSV" {
+		return &result{
+			code: 501,
+			msg:  fmt.Sprintf("%s not allowed after EPSV ALL", c.command),
+		}
+	}
 
 	return nil
 }
@@ -290,10 +759,18 @@ func (c *clientHandler) handleDATA() *result {
 	if !c.proxy.isLoggedIn() {
 		return &result{
 			code: 530,
-			msg:  "Please login with USER and PASS",
+			msg:  c.message(msgLoginRequired),
 		}
 	}
 
+	if res := c.checkExtendedDataCommand(); res != nil {
+		return res
+	}
+
+	if res := c.checkPortTarget(); res != nil {
+		return res
+	}
+
 	// if data channel proxy used
 	if c.config.DataChanProxy {
 		var toOriginMsg string
@@ -304,9 +781,10 @@ func (c *clientHandler) handleDATA() *result {
 		if c.proxy.isDataTransferStarted() {
 			return &result{
 				code: 450,
-				msg:  fmt.Sprintf("%s: data transfer in progress", c.command),
+				msg:  c.message(msgTransferInProgress),
 			}
 		}
+		c.negotiateOriginModeZ()
 
 		// make new listener and store listener port
 		dataHandler, err := newDataHandler(
@@ -318,6 +796,7 @@ func (c *clientHandler) handleDATA() *result {
 			c.tlsDatas,
 			c.transferInTLS,
 			c.inDataTransfer,
+			c.dataPorts,
 		)
 		if err != nil {
 			return &result{
@@ -328,14 +807,38 @@ func (c *clientHandler) handleDATA() *result {
 			}
 		}
 
+		dataHandler.events = c.events
+		dataHandler.metrics = c.metrics
+		dataHandler.sessionID = c.id
+		dataHandler.passiveIPMap = c.context.PassiveIPMap
+		dataHandler.transferKeepalive = c.context.TransferKeepalive
+		dataHandler.originDialer = c.proxy.originDialer
+		dataHandler.originAddr = c.proxy.originAddr
+		dataHandler.transferRate = c.context.TransferRate
+		dataHandler.idleTimeout = c.idleTimeout()
+		dataHandler.clientIP = clientIP(c.srcIP)
+		command := c.command
+		dataHandler.allowPeer = func(ip string) bool { return c.allowPassivePeer(command, ip) }
+		if dataHandler.clientConn.needsListen {
+			c.dataListeners.add(dataHandler)
+		}
+		if len(c.proxy.originProxy) > 0 || c.proxy.originDialer != nil {
+			// remote address of control connection may be egress proxy or overlay network
+			dataHandler.originProxy = c.proxy.originProxy
+			dataHandler.originConn.originalRemoteIP, _, _ = net.SplitHostPort(c.proxy.originAddr)
+			if isUnixAddr(c.proxy.originAddr) {
+				dataHandler.originConn.originalRemoteIP = unixPeerIP
+			}
+		}
 		c.proxy.SetDataHandler(dataHandler)
+		c.context.DataMode = c.command
 
 		switch c.command {
 		case "PORT":
-			if err := c.proxy.dataConnector.parsePORTcommand(c.line); err != nil {
+			if err := dataHandler.parsePORTcommand(c.line); err != nil {
 				c.log.err(err.Error())
 
-				c.proxy.dataConnector.Close()
+				c.proxy.cancelDataHandler(dataHandler)
 
 				return &result{
 					code: 501,
@@ -345,15 +848,15 @@ func (c *clientHandler) handleDATA() *result {
 				}
 			}
 		case "EPRT":
-			if err := c.proxy.dataConnector.parseEPRTcommand(c.line); err != nil {
+			if err := dataHandler.parseEPRTcommand(c.line); err != nil {
 				c.log.err(err.Error())
 
-				c.proxy.dataConnector.Close()
+				c.proxy.cancelDataHandler(dataHandler)
 
 				if err.Error() == "unknown network protocol" {
 					return &result{
 						code: 522,
-						msg:  err.Error(),
+						msg:  networkProtocolNotSupported,
 						err:  err,
 						log:  c.log,
 					}
@@ -368,19 +871,21 @@ func (c *clientHandler) handleDATA() *result {
 			}
 		}
 
-		if !c.proxy.isDataHandlerAvailable() {
+		if dataHandler.isClosed() {
+			c.proxy.cancelDataHandler(dataHandler)
+
 			return &result{
 				code: 425,
-				msg:  "Can't open data connection",
+				msg:  c.message(msgDataConnection),
 			}
 		}
 
 		// if origin connect mode is PORT or CLIENT(with client use some kind of active mode)
-		if c.proxy.dataConnector.originConn.needsListen {
-			_, lPort, _ := net.SplitHostPort(c.proxy.dataConnector.originConn.listener.Addr().String())
+		if dataHandler.originConn.needsListen {
+			_, lPort, _ := net.SplitHostPort(dataHandler.originConn.listener.Addr().String())
 			listenPort, _ := strconv.Atoi(lPort)
 
-			listenIP := strings.Split(c.proxy.GetConn().LocalAddr().String(), ":")[0]
+			listenIP := addrIP(c.proxy.GetConn().LocalAddr())
 
 			// prepare PORT command line to origin
 			// only use PORT command because connect to server support IPv4 now
@@ -400,7 +905,7 @@ func (c *clientHandler) handleDATA() *result {
 		if err := c.proxy.sendToOrigin(toOriginMsg); err != nil {
 			c.log.err(err.Error())
 
-			c.proxy.dataConnector.Close()
+			c.proxy.cancelDataHandler(dataHandler)
 
 			return &result{
 				code: 500,
@@ -415,11 +920,13 @@ func (c *clientHandler) handleDATA() *result {
 
 			return &result{
 				code: 530,
-				msg:  "I can't deal with you (proxy error)",
+				msg:  c.message(msgProxyError),
 				err:  err,
 				log:  c.log,
 			}
 		}
+
+		c.context.DataMode = c.command
 	}
 
 	return nil
@@ -10,7 +10,7 @@ import (
 
 func Test_clientHandler_handleAUTH(t *testing.T) {
 	type fields struct {
-		config *config
+		config *Config
 	}
 
 	type res struct {
@@ -28,7 +28,7 @@ func Test_clientHandler_handleAUTH(t *testing.T) {
 		{
 			name: "undefined",
 			fields: fields{
-				config: &config{},
+				config: &Config{},
 			},
 			want: &res{
 				code: 550,
@@ -66,7 +66,7 @@ func Test_clientHandler_handleAUTH(t *testing.T) {
 
 func Test_clientHandler_handlePBSZ(t *testing.T) {
 	type fields struct {
-		config *config
+		config *Config
 	}
 
 	type res struct {
@@ -84,7 +84,7 @@ func Test_clientHandler_handlePBSZ(t *testing.T) {
 		{
 			name: "none_tls",
 			fields: fields{
-				config: &config{},
+				config: &Config{},
 			},
 			want: &res{
 				code: 503,
@@ -118,7 +118,7 @@ func Test_clientHandler_handlePBSZ(t *testing.T) {
 
 func Test_clientHandler_handlePROT(t *testing.T) {
 	type fields struct {
-		config *config
+		config *Config
 	}
 
 	type res struct {
@@ -136,7 +136,7 @@ func Test_clientHandler_handlePROT(t *testing.T) {
 		{
 			name: "none_tls",
 			fields: fields{
-				config: &config{},
+				config: &Config{},
 			},
 			want: &res{
 				code: 503,
@@ -177,7 +177,7 @@ func Test_clientHandler_handleUSER(t *testing.T) {
 
 	type fields struct {
 		conn    net.Conn
-		config  *config
+		config  *Config
 		context *Context
 		line    string
 	}
@@ -190,7 +190,7 @@ func Test_clientHandler_handleUSER(t *testing.T) {
 			name: "ok",
 			fields: fields{
 				conn:   c,
-				config: &config{},
+				config: &Config{},
 				context: &Context{
 					RemoteAddr: "127.0.0.1:21",
 				},
@@ -201,7 +201,7 @@ func Test_clientHandler_handleUSER(t *testing.T) {
 			name: "not connect",
 			fields: fields{
 				conn:   c,
-				config: &config{},
+				config: &Config{},
 				context: &Context{
 					RemoteAddr: "127.0.0.1:28080",
 				},
@@ -231,3 +231,268 @@ func Test_clientHandler_handleUSER(t *testing.T) {
 		})
 	}
 }
+
+func Test_isMutatingCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		param   string
+		want    bool
+	}{
+		{name: "stor", command: "STOR", param: "file.txt", want: true},
+		{name: "rnto", command: "RNTO", param: "file.txt", want: true},
+		{name: "site_chmod", command: "SITE", param: "chmod 777 file.txt", want: true},
+		{name: "site_help", command: "SITE", param: "HELP", want: false},
+		{name: "retr", command: "RETR", param: "file.txt", want: false},
+		{name: "list", command: "LIST", param: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isMutatingCommand(tt.command, tt.param); got != tt.want {
+				t.Errorf("isMutatingCommand() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_handleUSER_deny_unresolved(t *testing.T) {
+	events := newEventBus()
+	c := &clientHandler{
+		config: &Config{
+			DenyUnresolved: true,
+			UnresolvedMsg:  "Access denied",
+		},
+		context: &Context{},
+		events:  events,
+		log:     &logger{},
+		param:   "pftp",
+	}
+
+	got := c.handleUSER()
+	if got == nil || got.code != 530 || got.msg != "Access denied" {
+		t.Errorf("clientHandler.handleUSER() = %v, want 530 Access denied", got)
+	}
+
+	select {
+	case e := <-events.ch:
+		if ev, ok := e.(*OriginUnresolvedEvent); !ok || ev.User != "pftp" {
+			t.Errorf("clientHandler.handleUSER() event = %v, want OriginUnresolvedEvent", e)
+		}
+	default:
+		t.Errorf("clientHandler.handleUSER() did not emit event")
+	}
+}
+
+func Test_clientHandler_handleNOOP(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		proxy  *proxyServer
+	}{
+		{
+			name:   "never_forward",
+			config: &Config{LocalNoop: true, NoopForwardInterval: 0},
+		},
+		{
+			name:   "not_logged_in",
+			config: &Config{LocalNoop: true, NoopForwardInterval: 60},
+			proxy:  &proxyServer{isLoggedin: false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{
+				config: tt.config,
+				proxy:  tt.proxy,
+				line:   "NOOP\r\n",
+			}
+			got := c.handleNOOP()
+			if got == nil || got.code != 200 {
+				t.Errorf("clientHandler.handleNOOP() = %v, want 200", got)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_handleSITE_whichbackend(t *testing.T) {
+	tests := []struct {
+		name     string
+		context  *Context
+		loggedIn bool
+		wantCode int
+		wantMsg  string
+	}{
+		{
+			name:     "hashed_origin_address",
+			context:  &Context{},
+			loggedIn: true,
+			wantCode: 200,
+			wantMsg:  "Backend: f2deb3007bb5",
+		},
+		{
+			name:     "middleware_backend_id",
+			context:  &Context{BackendID: "tokyo-01"},
+			loggedIn: true,
+			wantCode: 200,
+			wantMsg:  "Backend: tokyo-01",
+		},
+		{
+			name:     "not_logged_in",
+			context:  &Context{},
+			loggedIn: false,
+			wantCode: 530,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{
+				config:  &Config{ExposeBackendID: true},
+				context: tt.context,
+				proxy:   &proxyServer{isLoggedin: tt.loggedIn, originAddr: "127.0.0.1:21"},
+				log:     &logger{},
+				command: "SITE",
+				param:   "whichbackend",
+			}
+			got := c.handleSITE()
+			if got == nil || got.code != tt.wantCode {
+				t.Fatalf("clientHandler.handleSITE() = %v, want %d", got, tt.wantCode)
+			}
+			if len(tt.wantMsg) > 0 && got.msg != tt.wantMsg {
+				t.Errorf("clientHandler.handleSITE() msg = %q, want %q", got.msg, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_checkExtendedDataCommand(t *testing.T) {
+	// steps are sent in order on same session
+	tests := []struct {
+		name   string
+		config *Config
+		steps  []string
+		want   []int
+	}{
+		{
+			name:   "epsv_all_locks_extended_passive",
+			config: &Config{DataChanProxy: true},
+			steps:  []string{"EPSV ALL", "PASV", "PORT 1,1,1,1,100,10", "EPRT |1|1.1.1.1|25610|", "EPSV"},
+			want:   []int{200, 501, 501, 501, 0},
+		},
+		{
+			name:   "epsv_all_forwarded_without_data_channel_proxy",
+			config: &Config{},
+			steps:  []string{"EPSV ALL", "PASV", "EPSV"},
+			want:   []int{0, 501, 0},
+		},
+		{
+			name:   "epsv_network_protocol",
+			config: &Config{DataChanProxy: true},
+			steps:  []string{"EPSV 1", "EPSV 2", "EPSV 3", "PASV"},
+			want:   []int{0, 0, 522, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{config: tt.config}
+
+			for i, step := range tt.steps {
+				c.parseLine(step + "\r\n")
+
+				got := 0
+				if res := c.checkExtendedDataCommand(); res != nil {
+					got = res.code
+				}
+				if got != tt.want[i] {
+					t.Errorf("clientHandler.checkExtendedDataCommand(%q) = %d, want %d", step, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_clientHandler_handleHOST(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     *Config
+		middleware middleware
+		loggedIn   bool
+		prev       string
+		line       string
+		wantCode   int
+		wantAddr   string
+	}{
+		{
+			name:     "host_origins",
+			config:   &Config{RemoteAddr: "default:21", HostOrigins: map[string]string{"ftp.tenant.example": "tenant:21"}},
+			line:     "HOST FTP.tenant.example\r\n",
+			wantCode: 220,
+			wantAddr: "tenant:21",
+		},
+		{
+			name:     "unknown_host",
+			config:   &Config{RemoteAddr: "default:21", HostOrigins: map[string]string{"ftp.tenant.example": "tenant:21"}},
+			line:     "HOST ftp.other.example\r\n",
+			wantCode: 220,
+			wantAddr: "default:21",
+		},
+		{
+			name:     "unknown_host_after_known_host",
+			config:   &Config{RemoteAddr: "default:21", HostOrigins: map[string]string{"ftp.tenant.example": "tenant:21"}},
+			prev:     "HOST ftp.tenant.example\r\n",
+			line:     "HOST ftp.other.example\r\n",
+			wantCode: 220,
+			wantAddr: "default:21",
+		},
+		{
+			name:   "middleware_overrides_host_origins",
+			config: &Config{RemoteAddr: "default:21", HostOrigins: map[string]string{"ftp.tenant.example": "tenant:21"}},
+			middleware: middleware{"HOST": func(ctx *Context, host string) error {
+				ctx.RemoteAddr = "middleware:21"
+				return nil
+			}},
+			line:     "HOST ftp.tenant.example\r\n",
+			wantCode: 220,
+			wantAddr: "middleware:21",
+		},
+		{
+			name:     "no_host_name",
+			config:   &Config{RemoteAddr: "default:21"},
+			line:     "HOST\r\n",
+			wantCode: 501,
+			wantAddr: "default:21",
+		},
+		{
+			name:     "after_login",
+			config:   &Config{RemoteAddr: "default:21", HostOrigins: map[string]string{"ftp.tenant.example": "tenant:21"}},
+			loggedIn: true,
+			line:     "HOST ftp.tenant.example\r\n",
+			wantCode: 503,
+			wantAddr: "default:21",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{
+				config:     tt.config,
+				middleware: tt.middleware,
+				context:    newContext(tt.config),
+				proxy:      &proxyServer{isLoggedin: tt.loggedIn},
+				log:        &logger{},
+			}
+
+			if len(tt.prev) > 0 {
+				c.handleCommand(tt.prev)
+			}
+			got := c.handleCommand(tt.line)
+			if got == nil || got.code != tt.wantCode {
+				t.Fatalf("clientHandler.handleCommand(HOST) = %v, want %d", got, tt.wantCode)
+			}
+			if c.context.RemoteAddr != tt.wantAddr {
+				t.Errorf("Context.RemoteAddr = %s, want %s", c.context.RemoteAddr, tt.wantAddr)
+			}
+			if tt.wantCode == 220 && c.hostAddr != tt.wantAddr {
+				t.Errorf("clientHandler.hostAddr = %s, want %s", c.hostAddr, tt.wantAddr)
+			}
+		})
+	}
+}
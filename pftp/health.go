@@ -0,0 +1,96 @@
+package pftp
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// originHealth track origin connection failures and ramp new sessions
+// to recovered origins gradually. it is shared by all client sessions of server.
+type originHealth struct {
+	mutex   sync.Mutex
+	origins map[string]*originState
+	maxRate float64 // sessions per second at the end of slow start
+	now     func() time.Time
+}
+
+type originState struct {
+	down        bool
+	recoveredAt time.Time
+	tokens      float64
+	lastRefill  time.Time
+}
+
+func newOriginHealth(c *Config) *originHealth {
+	return &originHealth{
+		origins: map[string]*originState{},
+		maxRate: c.SlowStartRate,
+		now:     time.Now,
+	}
+}
+
+// report result of connection to origin
+func (h *originHealth) report(addr string, ok bool) {
+	if h == nil {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	state, found := h.origins[addr]
+	if !ok {
+		if !found {
+			state = &originState{}
+			h.origins[addr] = state
+		}
+		state.down = true
+		return
+	}
+
+	if found && state.down {
+		// origin recovered. start slow start from now
+		now := h.now()
+		state.down = false
+		state.recoveredAt = now
+		state.lastRefill = now
+		state.tokens = 0
+	}
+}
+
+// return true when new session can be routed to origin.
+// during slow start, sessions are admitted by token bucket whose
+// rate grows linearly from 0 to maxRate in slowStart duration.
+func (h *originHealth) admit(addr string, slowStart time.Duration) bool {
+	if h == nil || slowStart <= 0 || h.maxRate <= 0 {
+		return true
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	state, found := h.origins[addr]
+	if !found || state.down || state.recoveredAt.IsZero() {
+		// unknown or down origin is tried as usual to detect recovery
+		return true
+	}
+
+	now := h.now()
+	elapsed := now.Sub(state.recoveredAt)
+	if elapsed >= slowStart {
+		delete(h.origins, addr)
+		return true
+	}
+
+	rate := h.maxRate * elapsed.Seconds() / slowStart.Seconds()
+	state.tokens = math.Min(math.Max(rate, 1), state.tokens+rate*now.Sub(state.lastRefill).Seconds())
+	state.lastRefill = now
+
+	if state.tokens < 1 {
+		return false
+	}
+	state.tokens--
+
+	return true
+}
@@ -0,0 +1,86 @@
+package pftp
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_originHealth_admit(t *testing.T) {
+	now := time.Unix(1000, 0)
+	h := newOriginHealth(&Config{SlowStartRate: 10})
+	h.now = func() time.Time { return now }
+
+	slowStart := 10 * time.Second
+
+	// healthy origin is not limited
+	for i := 0; i < 100; i++ {
+		if !h.admit("origin:21", slowStart) {
+			t.Fatalf("originHealth.admit() = false for healthy origin")
+		}
+	}
+
+	// down origin is tried to detect recovery
+	h.report("origin:21", false)
+	if !h.admit("origin:21", slowStart) {
+		t.Fatalf("originHealth.admit() = false for down origin")
+	}
+
+	h.report("origin:21", true)
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		count   int
+		want    int
+	}{
+		{
+			name:    "just_recovered",
+			elapsed: 0,
+			count:   5,
+			want:    0,
+		},
+		{
+			// rate at 5s is 5/sec. tokens are capped by rate
+			name:    "half_way",
+			elapsed: 5 * time.Second,
+			count:   20,
+			want:    5,
+		},
+		{
+			name:    "slow_start_finished",
+			elapsed: 10 * time.Second,
+			count:   100,
+			want:    100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = time.Unix(1000, 0).Add(tt.elapsed)
+
+			got := 0
+			for i := 0; i < tt.count; i++ {
+				if h.admit("origin:21", slowStart) {
+					got++
+				}
+			}
+			if got != tt.want {
+				t.Errorf("originHealth.admit() admitted %d sessions, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_originHealth_disabled(t *testing.T) {
+	h := newOriginHealth(&Config{SlowStartRate: 10})
+	h.report("origin:21", false)
+	h.report("origin:21", true)
+
+	if !h.admit("origin:21", 0) {
+		t.Errorf("originHealth.admit() = false without slow start duration")
+	}
+
+	var nilHealth *originHealth
+	if !nilHealth.admit("origin:21", time.Minute) {
+		t.Errorf("originHealth.admit() = false for nil health")
+	}
+}
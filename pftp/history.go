@@ -0,0 +1,70 @@
+package pftp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// close reasons whose events and logs have recent control lines
+var abnormalCloseReasons = map[string]bool{
+	closeReasonOriginFailure:   true,
+	closeReasonTransferTimeout: true,
+}
+
+// add line to history ring. it is called with mutex locked.
+func (t *sessionTail) remember(l TailLine) {
+	if t.historySize <= 0 {
+		return
+	}
+
+	if len(t.history) < t.historySize {
+		t.history = append(t.history, l)
+		return
+	}
+	t.history[t.historyNext] = l
+	t.historyNext = (t.historyNext + 1) % t.historySize
+}
+
+// return last lines of session from oldest. nil when history is disabled.
+func (t *sessionTail) recent() []TailLine {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.history) == 0 {
+		return nil
+	}
+
+	lines := make([]TailLine, 0, len(t.history))
+	lines = append(lines, t.history[t.historyNext:]...)
+	lines = append(lines, t.history[:t.historyNext]...)
+
+	return lines
+}
+
+// ex) "10:00:00.000 from_client RETR a.txt"
+func formatHistory(lines []TailLine) string {
+	formatted := make([]string, 0, len(lines))
+	for _, l := range lines {
+		formatted = append(formatted, fmt.Sprintf("%s %s %s", l.Time.Format("15:04:05.000"), l.Direction, l.Line))
+	}
+
+	return strings.Join(formatted, "\n")
+}
+
+// log recent control lines when session ended abnormally, and return them
+// for disconnect event
+func (c *clientHandler) abnormalHistory(reason string) []TailLine {
+	if !abnormalCloseReasons[reason] {
+		return nil
+	}
+
+	lines := c.tail.recent()
+	if len(lines) > 0 {
+		c.log.info("session closed by %s. last %d control lines:\n%s", reason, len(lines), formatHistory(lines))
+	}
+
+	return lines
+}
@@ -0,0 +1,73 @@
+package pftp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_sessionTail_recent(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		replies []string
+		want    []string
+	}{
+		{name: "disabled", size: 0, replies: []string{"200 a"}, want: nil},
+		{name: "not full", size: 3, replies: []string{"200 a", "200 b"}, want: []string{"200 a", "200 b"}},
+		{name: "wraps", size: 3, replies: []string{"200 a", "200 b", "200 c", "200 d", "200 e"}, want: []string{"200 c", "200 d", "200 e"}},
+		{name: "multi-line reply", size: 2, replies: []string{"230-Welcome\r\n230 Logged in\r\n"}, want: []string{"230-Welcome", "230 Logged in"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tail := newSessionTail(nil, tt.size)
+			for _, r := range tt.replies {
+				tail.reply(tailFromOrigin, r)
+			}
+			var got []string
+			for _, l := range tail.recent() {
+				got = append(got, l.Line)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("recent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_emitDisconnect_history(t *testing.T) {
+	tests := []struct {
+		reason      string
+		wantHistory bool
+	}{
+		{reason: closeReasonOriginFailure, wantHistory: true},
+		{reason: closeReasonTransferTimeout, wantHistory: true},
+		{reason: closeReasonQuit, wantHistory: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			events := newEventBus()
+			redactor := newRedactor(&Config{})
+			c := &clientHandler{
+				tail:        newSessionTail(redactor, 10),
+				events:      events,
+				log:         &logger{},
+				closeReason: tt.reason,
+			}
+			c.tail.command(tailFromClient, "PASS secret")
+			c.tail.command(tailFromClient, "RETR a.txt")
+			c.emitDisconnect()
+
+			e := (<-events.ch).(*ClientDisconnectEvent)
+			if (len(e.History) > 0) != tt.wantHistory {
+				t.Fatalf("History = %+v, want history %v", e.History, tt.wantHistory)
+			}
+			if tt.wantHistory {
+				got := formatHistory(e.History)
+				if strings.Contains(got, "secret") || !strings.HasSuffix(got, "from_client RETR a.txt") {
+					t.Errorf("history = %q", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package pftp
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checks of upload_verify and actions of upload_verify_action
+const (
+	verifyOff      = "off"
+	verifySize     = "size"
+	verifyChecksum = "checksum"
+	verifyWarn     = "warn"
+	verifyLog      = "log"
+)
+
+// results of upload verification in metrics
+const (
+	verifyMatched    = "matched"
+	verifyMismatched = "mismatched"
+	verifyUnverified = "unverified"
+)
+
+// uploadDigest hashes uploaded data as it is written to origin. algorithms
+// are named as in HASH command (draft-bryan-ftpext-hash).
+type uploadDigest struct {
+	mutex     sync.Mutex
+	algorithm string
+	hash      hash.Hash
+}
+
+// return digest of algorithm. nil when algorithm is not supported.
+func newUploadDigest(algorithm string) *uploadDigest {
+	var h hash.Hash
+	switch strings.ToUpper(algorithm) {
+	case "MD5":
+		h = md5.New()
+	case "SHA-1":
+		h = sha1.New()
+	case "SHA-256":
+		h = sha256.New()
+	default:
+		return nil
+	}
+
+	return &uploadDigest{algorithm: strings.ToUpper(algorithm), hash: h}
+}
+
+func (u *uploadDigest) write(p []byte) {
+	if u == nil {
+		return
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.hash.Write(p)
+}
+
+// return hex digest by algorithm. false when algorithm is not hashed.
+func (u *uploadDigest) sum(algorithm string) (string, bool) {
+	if u == nil || !strings.EqualFold(u.algorithm, algorithm) {
+		return "", false
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return hex.EncodeToString(u.hash.Sum(nil)), true
+}
+
+// integrityCheck is STOR verified with origin after it completed
+type integrityCheck struct {
+	action     string
+	path       string // name on origin
+	offset     int64  // of REST. checksum is not verified when resumed
+	checksum   bool   // verify checksum as well as size
+	data       *dataHandler
+	reply      string // sent instead of 226 by warn action
+	user       string
+	clientAddr string
+	metrics    *metrics
+}
+
+// return check of STOR by Context.UploadVerify. nil when disabled or data
+// is changed by origin (ASCII type) or relayed compressed (MODE Z of both legs).
+func (c *clientHandler) newIntegrityCheck(d *dataHandler, offset int64) *integrityCheck {
+	d.digest = nil
+	mode := c.context.UploadVerify
+	if c.command != "STOR" || len(c.param) == 0 || len(mode) == 0 || mode == verifyOff {
+		return nil
+	}
+	if !c.binaryType || (d.clientModeZ && d.originModeZ) {
+		c.log.debug("upload of %s is not verified out of binary type or in MODE Z", c.param)
+		return nil
+	}
+
+	// only algorithm which origin answers is hashed
+	checksum := mode == verifyChecksum && offset == 0
+	if checksum {
+		d.digest = newUploadDigest(c.proxy.checksumAlgorithm())
+	}
+
+	return &integrityCheck{
+		action:     c.context.UploadVerifyAction,
+		path:       c.param,
+		offset:     offset,
+		checksum:   checksum,
+		data:       d,
+		reply:      "451 " + c.message(msgIntegrityMismatch),
+		user:       c.user,
+		clientAddr: c.srcIP,
+		metrics:    c.metrics,
+	}
+}
+
+// compare completed upload with SIZE and checksum of origin. false when
+// origin has other data. upload which origin can not tell is not failed.
+func (s *proxyServer) verifyUpload(check *integrityCheck) bool {
+	if check == nil {
+		return true
+	}
+
+	size := check.offset + check.data.transferredBytes()
+	r, err := s.internalCommand("SIZE " + check.path + "\r\n")
+	if err != nil {
+		s.log.err("cannot verify upload of %s: %s", check.path, err.Error())
+		s.countVerification(check, verifyUnverified)
+		return true
+	}
+	stored, ok := parseSizeReply(r)
+	if !ok {
+		s.log.debug("origin did not tell size of %s: %s", check.path, strings.TrimSpace(r))
+		s.countVerification(check, verifyUnverified)
+		return true
+	}
+	if stored != size {
+		return s.integrityMismatch(check, "size", strconv.FormatInt(size, 10), strconv.FormatInt(stored, 10))
+	}
+
+	if !check.checksum {
+		s.countVerification(check, verifyMatched)
+		return true
+	}
+	algorithm, checksum, ok := s.originChecksum(check.path)
+	want, hashed := check.data.digest.sum(algorithm)
+	if !ok || !hashed {
+		s.log.debug("origin did not tell checksum of %s", check.path)
+		s.countVerification(check, verifyUnverified)
+		return true
+	}
+	if !strings.EqualFold(want, checksum) {
+		return s.integrityMismatch(check, strings.ToLower(algorithm), want, strings.ToLower(checksum))
+	}
+
+	s.countVerification(check, verifyMatched)
+	return true
+}
+
+// return features of origin, or cached ones before FEAT is sent
+func (s *proxyServer) originFeatures() []string {
+	features := s.getFeatures()
+	if o, ok := s.capabilities.get(s.originAddr); ok && len(features) == 0 {
+		features = o.features
+	}
+
+	return features
+}
+
+// return algorithm which originChecksum will answer by features of origin.
+// selected one (marked by "*") of HASH, or first one when none is marked.
+// ex) "HASH SHA-1;SHA-256*;MD5" -> SHA-256. empty when origin supports neither.
+func (s *proxyServer) checksumAlgorithm() string {
+	if s == nil {
+		return ""
+	}
+
+	features := s.originFeatures()
+	if hasFeature(features, "XMD5") {
+		return "MD5"
+	}
+	for _, feature := range features {
+		words := strings.Fields(feature)
+		if len(words) < 2 || !strings.EqualFold(words[0], "HASH") {
+			continue
+		}
+		algorithms := strings.Split(words[1], ";")
+		for _, a := range algorithms {
+			if strings.HasSuffix(a, "*") {
+				return strings.TrimSuffix(a, "*")
+			}
+		}
+		return algorithms[0]
+	}
+
+	return ""
+}
+
+// return algorithm and hex checksum of path by XMD5 or HASH of origin.
+// false when origin supports neither or failed.
+func (s *proxyServer) originChecksum(path string) (string, string, bool) {
+	features := s.originFeatures()
+
+	switch {
+	case hasFeature(features, "XMD5"):
+		r, err := s.internalCommand("XMD5 " + path + "\r\n")
+		if err != nil || !strings.HasPrefix(r, "2") {
+			return "", "", false
+		}
+		// ex) "250 D41D8CD98F00B204E9800998ECF8427E"
+		for _, word := range strings.Fields(r)[1:] {
+			if _, err := hex.DecodeString(word); err == nil && len(word) == md5.Size*2 {
+				return "MD5", word, true
+			}
+		}
+	case hasFeature(features, "HASH"):
+		r, err := s.internalCommand("HASH " + path + "\r\n")
+		if err != nil || !strings.HasPrefix(r, "213") {
+			return "", "", false
+		}
+		// ex) "213 SHA-256 0-49 169cd22282da7f147cb491e559e9dd file.txt"
+		if words := strings.Fields(r); len(words) >= 4 {
+			return words[1], words[3], true
+		}
+	}
+
+	return "", "", false
+}
+
+// parse "213 1234" reply of SIZE
+func parseSizeReply(reply string) (int64, bool) {
+	words := strings.Fields(reply)
+	if len(words) < 2 || words[0] != "213" {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(words[1], 10, 64)
+
+	return size, err == nil
+}
+
+func (s *proxyServer) integrityMismatch(check *integrityCheck, kind string, expected string, actual string) bool {
+	s.log.err("upload of %s does not match origin by %s: proxied %s, origin %s (%s)", check.path, kind, expected, actual, check.action)
+	s.countVerification(check, verifyMismatched)
+	s.events.emit(&IntegrityMismatchEvent{
+		Time:       time.Now(),
+		SessionID:  s.sessionID,
+		ClientAddr: check.clientAddr,
+		User:       check.user,
+		Origin:     s.originAddr,
+		Path:       check.path,
+		Check:      kind,
+		Expected:   expected,
+		Actual:     actual,
+		Action:     check.action,
+	})
+
+	return false
+}
+
+func (s *proxyServer) countVerification(check *integrityCheck, result string) {
+	check.metrics.inc("pftp_upload_verifications_total", "Uploads verified with SIZE and checksum of origin by result.",
+		"origin", s.originAddr, "result", result)
+}
+
+func validateUploadVerify(c *Config) error {
+	switch c.UploadVerify {
+	case "":
+		c.UploadVerify = verifyOff
+	case verifyOff, verifySize, verifyChecksum:
+	default:
+		return fmt.Errorf("configuration error: upload_verify must be off, size or checksum")
+	}
+	switch c.UploadVerifyAction {
+	case "":
+		c.UploadVerifyAction = verifyWarn
+	case verifyWarn, verifyLog:
+	default:
+		return fmt.Errorf("configuration error: upload_verify_action must be warn or log")
+	}
+
+	return nil
+}
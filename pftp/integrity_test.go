@@ -0,0 +1,266 @@
+package pftp
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+func Test_clientHandler_newIntegrityCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		command    string
+		binary     bool
+		modeZ      bool
+		offset     int64
+		features   []string
+		want       bool
+		wantDigest bool
+	}{
+		{name: "size", mode: verifySize, command: "STOR", binary: true, features: []string{"XMD5"}, want: true},
+		{name: "checksum", mode: verifyChecksum, command: "STOR", binary: true, features: []string{"XMD5"}, want: true, wantDigest: true},
+		{name: "checksum_no_feature", mode: verifyChecksum, command: "STOR", binary: true, want: true},
+		{name: "checksum_resumed", mode: verifyChecksum, command: "STOR", binary: true, offset: 10, want: true},
+		{name: "off", mode: verifyOff, command: "STOR", binary: true},
+		{name: "ascii", mode: verifyChecksum, command: "STOR"},
+		{name: "mode_z", mode: verifyChecksum, command: "STOR", binary: true, modeZ: true},
+		{name: "appe", mode: verifyChecksum, command: "APPE", binary: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{
+				config:     &Config{},
+				context:    &Context{UploadVerify: tt.mode, UploadVerifyAction: verifyWarn},
+				log:        &logger{},
+				command:    tt.command,
+				param:      "a.txt",
+				binaryType: tt.binary,
+				proxy:      &proxyServer{features: tt.features},
+			}
+			d := &dataHandler{clientModeZ: tt.modeZ, originModeZ: tt.modeZ, digest: newUploadDigest("MD5")}
+
+			check := c.newIntegrityCheck(d, tt.offset)
+			if (check != nil) != tt.want {
+				t.Fatalf("newIntegrityCheck() = %+v, want %v", check, tt.want)
+			}
+			if (d.digest != nil) != tt.wantDigest {
+				t.Errorf("digest = %v, want %v", d.digest != nil, tt.wantDigest)
+			}
+			if check != nil && (check.path != "a.txt" || check.offset != tt.offset || check.reply[:4] != "451 ") {
+				t.Errorf("check = %+v", check)
+			}
+		})
+	}
+}
+
+func Test_proxyServer_checksumAlgorithm(t *testing.T) {
+	tests := []struct {
+		name     string
+		features []string
+		want     string
+	}{
+		{name: "xmd5", features: []string{"SIZE", "XMD5", "HASH SHA-256*;MD5"}, want: "MD5"},
+		{name: "hash_selected", features: []string{"HASH SHA-1;SHA-256*;MD5"}, want: "SHA-256"},
+		{name: "hash_first", features: []string{"HASH SHA-1;SHA-256"}, want: "SHA-1"},
+		{name: "none", features: []string{"SIZE", "MDTM"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &proxyServer{features: tt.features}
+			if got := s.checksumAlgorithm(); got != tt.want {
+				t.Errorf("checksumAlgorithm() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseSizeReply(t *testing.T) {
+	tests := []struct {
+		reply  string
+		want   int64
+		wantOK bool
+	}{
+		{reply: "213 1234\r\n", want: 1234, wantOK: true},
+		{reply: "550 No such file.\r\n"},
+		{reply: "213 big\r\n"},
+		{reply: "213\r\n"},
+	}
+	for _, tt := range tests {
+		got, ok := parseSizeReply(tt.reply)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseSizeReply(%q) = %d, %v, want %d, %v", tt.reply, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func Test_proxyServer_finishLanding_verify(t *testing.T) {
+	tests := []struct {
+		name         string
+		temp         string
+		features     []string
+		action       string
+		digest       bool
+		replies      []string
+		wantCommands []string
+		wantClient   string
+		wantCheck    string // of mismatch event
+	}{
+		{
+			name:         "size_matched",
+			action:       verifyWarn,
+			replies:      []string{"213 5\r\n"},
+			wantCommands: []string{"SIZE a.txt\r\n"},
+			wantClient:   "226 Transfer complete.\r\n",
+		},
+		{
+			name:         "size_mismatched",
+			action:       verifyWarn,
+			replies:      []string{"213 3\r\n"},
+			wantCommands: []string{"SIZE a.txt\r\n"},
+			wantClient:   "451 mismatch\r\n",
+			wantCheck:    "size",
+		},
+		{
+			name:         "size_mismatched_log",
+			action:       verifyLog,
+			replies:      []string{"213 3\r\n"},
+			wantCommands: []string{"SIZE a.txt\r\n"},
+			wantClient:   "226 Transfer complete.\r\n",
+			wantCheck:    "size",
+		},
+		{
+			name:         "size_unsupported",
+			action:       verifyWarn,
+			replies:      []string{"502 Command not implemented.\r\n"},
+			wantCommands: []string{"SIZE a.txt\r\n"},
+			wantClient:   "226 Transfer complete.\r\n",
+		},
+		{
+			name:         "xmd5_matched",
+			features:     []string{"SIZE", "XMD5"},
+			action:       verifyWarn,
+			digest:       true,
+			replies:      []string{"213 5\r\n", "250 5D41402ABC4B2A76B9719D911017C592\r\n"},
+			wantCommands: []string{"SIZE a.txt\r\n", "XMD5 a.txt\r\n"},
+			wantClient:   "226 Transfer complete.\r\n",
+		},
+		{
+			name:         "hash_mismatched",
+			features:     []string{"HASH SHA-256*;MD5"},
+			action:       verifyWarn,
+			digest:       true,
+			replies:      []string{"213 5\r\n", "213 SHA-256 0-4 2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9825 a.txt\r\n"},
+			wantCommands: []string{"SIZE a.txt\r\n", "HASH a.txt\r\n"},
+			wantClient:   "451 mismatch\r\n",
+			wantCheck:    "sha-256",
+		},
+		{
+			name:         "no_checksum_feature",
+			action:       verifyWarn,
+			digest:       true,
+			replies:      []string{"213 5\r\n"},
+			wantCommands: []string{"SIZE a.txt\r\n"},
+			wantClient:   "226 Transfer complete.\r\n",
+		},
+		{
+			name:         "renamed_after_verify",
+			temp:         "a.txt.part",
+			action:       verifyWarn,
+			replies:      []string{"213 5\r\n", "350 Ready for RNTO.\r\n", "250 Rename successful.\r\n"},
+			wantCommands: []string{"SIZE a.txt.part\r\n", "RNFR a.txt.part\r\n", "RNTO a.txt\r\n"},
+			wantClient:   "226 Transfer complete.\r\n",
+		},
+		{
+			name:         "not_renamed_when_mismatched",
+			temp:         "a.txt.part",
+			action:       verifyWarn,
+			replies:      []string{"213 6\r\n"},
+			wantCommands: []string{"SIZE a.txt.part\r\n"},
+			wantClient:   "451 mismatch\r\n",
+			wantCheck:    "size",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originConn, originPeer := net.Pipe()
+			defer originConn.Close()
+			defer originPeer.Close()
+			clientConn, clientPeer := net.Pipe()
+			defer clientConn.Close()
+			defer clientPeer.Close()
+
+			events := newEventBus()
+			s := &proxyServer{
+				config:         &Config{},
+				log:            &logger{},
+				isLoggedin:     true,
+				inDataTransfer: abool.New(),
+				originWriter:   bufio.NewWriter(originConn),
+				clientWriter:   bufio.NewWriter(clientConn),
+				mutex:          &sync.Mutex{},
+				features:       tt.features,
+				events:         events,
+			}
+
+			d := &dataHandler{transferred: 5}
+			if tt.digest {
+				d.digest = newUploadDigest(s.checksumAlgorithm())
+				d.digest.write([]byte("hello"))
+			}
+			check := &integrityCheck{action: tt.action, path: "a.txt", checksum: tt.digest, data: d, reply: "451 mismatch"}
+			if len(tt.temp) > 0 {
+				check.path = tt.temp
+			}
+			s.pushLanding(landing{temp: tt.temp, final: "a.txt", check: check})
+			s.pushCommand("STOR")
+
+			// origin replies to commands sent by proxy
+			commands := make(chan string, len(tt.replies))
+			go func() {
+				r := bufio.NewReader(originPeer)
+				for _, reply := range tt.replies {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					commands <- line
+					s.processOriginReply(reply)
+				}
+			}()
+
+			if got, forward := s.processOriginReply("226 Transfer complete.\r\n"); forward {
+				t.Errorf("proxyServer.processOriginReply(STOR) = %q is forwarded before verification", got)
+			}
+
+			clientPeer.SetDeadline(time.Now().Add(3 * time.Second))
+			got, err := bufio.NewReader(clientPeer).ReadString('\n')
+			if err != nil || got != tt.wantClient {
+				t.Errorf("reply to client = %q, %v, want %q", got, err, tt.wantClient)
+			}
+			for _, want := range tt.wantCommands {
+				if c := <-commands; c != want {
+					t.Errorf("command = %q, want %q", c, want)
+				}
+			}
+
+			// command replies are emitted as well
+			var mismatch *IntegrityMismatchEvent
+			for len(events.ch) > 0 {
+				if e, ok := (<-events.ch).(*IntegrityMismatchEvent); ok {
+					mismatch = e
+				}
+			}
+			if (mismatch != nil) != (len(tt.wantCheck) > 0) {
+				t.Fatalf("integrity_mismatch event = %+v, want %q", mismatch, tt.wantCheck)
+			}
+			if mismatch != nil && (mismatch.Check != tt.wantCheck || mismatch.Action != tt.action) {
+				t.Errorf("event = %+v, want mismatch of %s", mismatch, tt.wantCheck)
+			}
+		})
+	}
+}
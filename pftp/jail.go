@@ -0,0 +1,224 @@
+package pftp
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// resolve path argument against current directory and return absolute
+// path. return false when it is outside of root.
+func jailedPath(root string, cwd string, arg string) (string, bool) {
+	p := arg
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join(cwd, p)
+	}
+	p = path.Clean(p)
+
+	return p, withinRoot(root, p)
+}
+
+func withinRoot(root string, p string) bool {
+	return root == "/" || p == root || strings.HasPrefix(p, root+"/")
+}
+
+// return index of path in words of command parameter. -1 means command takes no path
+// or its path is not known.
+// ex) "SITE CHMOD 644 file" -> 2, "MFMT 20210901000000 file" -> 1
+func pathArgument(command string, words []string) int {
+	switch command {
+	case "CWD", "RETR", "STOR", "APPE", "DELE", "RNFR", "RNTO",
+		"MKD", "XMKD", "RMD", "XRMD", "SIZE", "MDTM", "MLST", "STAT",
+		"SMNT", "HASH", "MD5", "XMD5", "XCRC", "XSHA1", "XSHA256", "XSHA512":
+		return 0
+	case "LIST", "NLST", "MLSD":
+		// skip options like "-la"
+		for i, w := range words {
+			if !strings.HasPrefix(w, "-") {
+				return i
+			}
+		}
+		return len(words)
+	case "MFMT", "MFCT", "MFF":
+		return 1
+	case "SITE":
+		if len(words) == 0 {
+			return -1
+		}
+		switch strings.ToUpper(words[0]) {
+		case "CHMOD", "CHGRP", "CHOWN":
+			return 2
+		case "MKDIR", "RMDIR", "CPFR", "CPTO":
+			return 1
+		}
+	}
+
+	return -1
+}
+
+// return true when command is known to take no path. commands which are not
+// known are rejected within root, because their paths cannot be checked.
+// ex) "SITE SYMLINK a b" and "SITE UTIME" take several paths.
+func takesNoPath(command string, words []string) bool {
+	switch command {
+	case "USER", "PASS", "ACCT", "QUIT", "REIN", "NOOP", "SYST", "FEAT", "OPTS", "HELP",
+		"TYPE", "MODE", "STRU", "REST", "ALLO", "ABOR", "PORT", "EPRT", "PASV", "EPSV",
+		"AUTH", "PBSZ", "PROT", "CCC", "LANG", "CLNT", "HOST", "PROXY":
+		return true
+	case "SITE":
+		if len(words) == 0 {
+			return true
+		}
+		switch strings.ToUpper(words[0]) {
+		case "HELP", "IDLE", "UMASK", "WHO", "ZONE":
+			return true
+		}
+	}
+
+	return false
+}
+
+// keep path arguments of command within Context.RootPath. paths are sent
+// to origin as absolute paths resolved against directory tracked by proxy,
+// so origin's own working directory does not matter.
+// return reply when command is answered or rejected by proxy.
+func (c *clientHandler) enforceRootPath() *result {
+	root := path.Clean("/" + c.context.RootPath)
+	cwd := c.proxy.getCwd()
+	if !withinRoot(root, cwd) {
+		cwd = root
+		c.proxy.setCwd(cwd)
+	}
+
+	denied := &result{
+		code: 550,
+		msg:  fmt.Sprintf("%s: permission denied (outside of root)", c.command),
+	}
+
+	switch c.command {
+	case "PWD", "XPWD":
+		return &result{
+			code: 257,
+			msg:  fmt.Sprintf("\"%s\" is current directory", cwd),
+		}
+	case "XCWD":
+		c.command = "CWD"
+	case "CDUP", "XCUP":
+		c.command, c.param = "CWD", ".."
+	case "STOU":
+		// unique name is made in origin's working directory
+		return denied
+	}
+
+	i := pathArgument(c.command, strings.Split(c.param, " "))
+	if i < 0 {
+		if takesNoPath(c.command, strings.Fields(c.param)) {
+			return nil
+		}
+		return &result{
+			code: 550,
+			msg:  fmt.Sprintf("%s: permission denied (not allowed within root)", c.command),
+		}
+	}
+	if c.command == "STAT" && len(c.param) == 0 {
+		return nil
+	}
+
+	// path is rest of parameter after i words. it may contain spaces.
+	// empty path means current directory.
+	words := strings.SplitN(c.param, " ", i+1)
+	if len(words) <= i {
+		if c.command != "LIST" && c.command != "NLST" && c.command != "MLSD" {
+			return nil
+		}
+		words = append(words, "")
+	}
+	p, ok := jailedPath(root, cwd, words[i])
+	if !ok {
+		return denied
+	}
+	words[i] = p
+
+	c.param = strings.Join(words, " ")
+	c.line = c.command + " " + c.param + "\r\n"
+
+	return nil
+}
+
+// track directory of origin by CWD and CDUP sent to origin after login.
+// relative target is unknown while current directory is not known yet.
+func (c *clientHandler) trackCwd() {
+	target := c.param
+	switch c.command {
+	case "CWD", "XCWD":
+	case "CDUP", "XCUP":
+		target = ".."
+	default:
+		return
+	}
+	if c.proxy == nil || !c.proxy.isLoggedIn() {
+		return
+	}
+
+	cwd := c.proxy.getCwd()
+	switch {
+	case strings.HasPrefix(target, "/"):
+		cwd = path.Clean(target)
+	case len(cwd) > 0:
+		cwd = path.Join(cwd, target)
+	}
+	c.proxy.pushCwd(cwd)
+}
+
+// return true when command changes directory of origin
+func isCwdCommand(command string) bool {
+	switch command {
+	case "CWD", "XCWD", "CDUP", "XCUP":
+		return true
+	}
+
+	return false
+}
+
+// directory of origin tracked by proxy. empty while it is not known.
+func (s *proxyServer) getCwd() string {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	return s.cwd
+}
+
+func (s *proxyServer) setCwd(cwd string) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.cwd = cwd
+	s.pendingCwds = nil
+}
+
+// set directory learned from PWD of origin unless CWD is in flight
+func (s *proxyServer) learnCwd(cwd string) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	if len(s.pendingCwds) == 0 {
+		s.cwd = cwd
+	}
+}
+
+// store target of CWD sent to origin until its reply
+func (s *proxyServer) pushCwd(cwd string) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.pendingCwds = append(s.pendingCwds, cwd)
+}
+
+// change directory by reply of CWD sent by pushCwd
+func (s *proxyServer) finishCwd(ok bool) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	if len(s.pendingCwds) == 0 {
+		return
+	}
+	if ok {
+		s.cwd = s.pendingCwds[0]
+	}
+	s.pendingCwds = s.pendingCwds[1:]
+}
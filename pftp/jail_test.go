@@ -0,0 +1,72 @@
+package pftp
+
+import (
+	"testing"
+)
+
+func Test_clientHandler_enforceRootPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		root     string
+		cwd      string
+		line     string
+		wantLine string
+		wantCode int
+		wantCwd  string // pending CWD target
+	}{
+		{name: "relative", root: "/home/a", cwd: "/home/a/dir", line: "RETR file.txt", wantLine: "RETR /home/a/dir/file.txt\r\n"},
+		{name: "space", root: "/home/a", cwd: "/home/a", line: "DELE my file.txt", wantLine: "DELE /home/a/my file.txt\r\n"},
+		{name: "escape", root: "/home/a", cwd: "/home/a/dir", line: "RETR ../../b/secret", wantCode: 550},
+		{name: "absolute_outside", root: "/home/a", cwd: "/home/a", line: "STOR /etc/passwd", wantCode: 550},
+		{name: "similar_prefix", root: "/home/a", cwd: "/home/a", line: "RETR /home/ab/file", wantCode: 550},
+		{name: "absolute_inside", root: "/home/a/", cwd: "/home/a", line: "MKD /home/a/new", wantLine: "MKD /home/a/new\r\n"},
+		{name: "first_command", root: "/home/a", line: "SIZE file", wantLine: "SIZE /home/a/file\r\n"},
+		{name: "list_options", root: "/home/a", cwd: "/home/a/dir", line: "LIST -la", wantLine: "LIST -la /home/a/dir\r\n"},
+		{name: "list_path", root: "/home/a", cwd: "/home/a", line: "NLST sub dir", wantLine: "NLST /home/a/sub dir\r\n"},
+		{name: "site_chmod", root: "/home/a", cwd: "/home/a", line: "SITE CHMOD 644 ../x", wantCode: 550},
+		{name: "site_other", root: "/home/a", cwd: "/home/a", line: "SITE IDLE 60", wantLine: "SITE IDLE 60"},
+		{name: "site_symlink", root: "/home/a", cwd: "/home/a", line: "SITE SYMLINK /etc/passwd p", wantCode: 550},
+		{name: "site_utime", root: "/home/a", cwd: "/home/a", line: "SITE UTIME 20210901000000 ../x", wantCode: 550},
+		{name: "site_cpfr", root: "/home/a", cwd: "/home/a", line: "SITE CPFR ../x", wantCode: 550},
+		{name: "xmd5", root: "/home/a", cwd: "/home/a", line: "XMD5 ../../etc/passwd", wantCode: 550},
+		{name: "mff", root: "/home/a", cwd: "/home/a", line: "MFF modify=20210901000000; f", wantLine: "MFF modify=20210901000000; /home/a/f\r\n"},
+		{name: "unknown", root: "/home/a", cwd: "/home/a", line: "XFOO /etc", wantCode: 550},
+		{name: "mfmt", root: "/home/a", cwd: "/home/a", line: "MFMT 20210901000000 f", wantLine: "MFMT 20210901000000 /home/a/f\r\n"},
+		{name: "cwd", root: "/home/a", cwd: "/home/a", line: "XCWD sub", wantLine: "CWD /home/a/sub\r\n", wantCwd: "/home/a/sub"},
+		{name: "cdup", root: "/home/a", cwd: "/home/a/sub", line: "CDUP", wantLine: "CWD /home/a\r\n", wantCwd: "/home/a"},
+		{name: "cdup_at_root", root: "/home/a", cwd: "/home/a", line: "CDUP", wantCode: 550},
+		{name: "pwd", root: "/home/a", cwd: "/home/a/sub", line: "PWD", wantCode: 257},
+		{name: "stou", root: "/home/a", cwd: "/home/a", line: "STOU", wantCode: 550},
+		{name: "stat_server", root: "/home/a", cwd: "/home/a", line: "STAT", wantLine: "STAT"},
+		{name: "no_path", root: "/home/a", cwd: "/home/a", line: "TYPE I", wantLine: "TYPE I"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{
+				context: &Context{RootPath: tt.root},
+				proxy:   &proxyServer{cwd: tt.cwd, isLoggedin: true},
+			}
+			c.parseLine(tt.line)
+
+			r := c.enforceRootPath()
+			if tt.wantCode > 0 {
+				if r == nil || r.code != tt.wantCode {
+					t.Fatalf("enforceRootPath() = %+v, want code %d", r, tt.wantCode)
+				}
+				return
+			}
+			if r != nil {
+				t.Fatalf("enforceRootPath() = %+v, want nil", r)
+			}
+			if c.line != tt.wantLine {
+				t.Errorf("line = %q, want %q", c.line, tt.wantLine)
+			}
+
+			c.trackCwd()
+			c.proxy.finishCwd(true)
+			if len(tt.wantCwd) > 0 && c.proxy.getCwd() != tt.wantCwd {
+				t.Errorf("cwd after CWD = %q, want %q", c.proxy.getCwd(), tt.wantCwd)
+			}
+		})
+	}
+}
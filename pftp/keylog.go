@@ -0,0 +1,95 @@
+package pftp
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// keyLog append TLS secrets of connections in NSS key log format, so captured
+// traffic can be decrypted by Wireshark during investigation in test
+// environments. nil keyLog writes nothing.
+type keyLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func newKeyLog(path string) (*keyLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyLog{file: f}, nil
+}
+
+// Write is called by crypto/tls with one line of secrets. error of file
+// never fails handshake, and secrets are dropped after close.
+func (k *keyLog) Write(p []byte) (int, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.file != nil {
+		k.file.Write(p)
+	}
+
+	return len(p), nil
+}
+
+// return writer of tls.Config.KeyLogWriter. nil when key log is disabled.
+func (k *keyLog) writer() io.Writer {
+	if k == nil {
+		return nil
+	}
+
+	return k
+}
+
+func (k *keyLog) close() error {
+	if k == nil {
+		return nil
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.file == nil {
+		return nil
+	}
+	err := k.file.Close()
+	k.file = nil
+
+	return err
+}
+
+// open tls_keylog_file of client connections and origin_tls_keylog_file of
+// origin connections. server refuses to start with them unless
+// debug_tls_keylog_allowed is set, and always in production mode.
+func (server *FtpServer) openKeyLogs() error {
+	c := server.config
+	if len(c.TLSKeylogFile) == 0 && len(c.OriginTLSKeylogFile) == 0 {
+		return nil
+	}
+	if c.Production {
+		return errors.New("configuration error: tls_keylog_file and origin_tls_keylog_file are not allowed in production mode")
+	}
+	if !c.DebugTLSKeylogAllowed {
+		return errors.New("configuration error: tls_keylog_file and origin_tls_keylog_file need debug_tls_keylog_allowed")
+	}
+
+	var err error
+	if len(c.TLSKeylogFile) > 0 {
+		if server.clientKeyLog, err = newKeyLog(c.TLSKeylogFile); err != nil {
+			return err
+		}
+		server.logger.Warnf("TLS secrets of client connections are written to %s. captured traffic can be decrypted", c.TLSKeylogFile)
+	}
+	if len(c.OriginTLSKeylogFile) > 0 {
+		if server.originKeyLog, err = newKeyLog(c.OriginTLSKeylogFile); err != nil {
+			server.clientKeyLog.close()
+			return err
+		}
+		server.logger.Warnf("TLS secrets of origin connections are written to %s. captured traffic can be decrypted", c.OriginTLSKeylogFile)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package pftp
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func Test_FtpServer_openKeyLogs(t *testing.T) {
+	tests := []struct {
+		name       string
+		client     bool
+		origin     bool
+		production bool
+		notAllowed bool
+		wantClient bool
+		wantOrigin bool
+		wantErr    bool
+	}{
+		{name: "disabled"},
+		{name: "client", client: true, wantClient: true},
+		{name: "origin", origin: true, wantOrigin: true},
+		{name: "both", client: true, origin: true, wantClient: true, wantOrigin: true},
+		{name: "not_allowed", client: true, notAllowed: true, wantErr: true},
+		{name: "production", client: true, origin: true, production: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c := &Config{Production: tt.production, DebugTLSKeylogAllowed: !tt.notAllowed}
+			if tt.client {
+				c.TLSKeylogFile = filepath.Join(dir, "client.keys")
+			}
+			if tt.origin {
+				c.OriginTLSKeylogFile = filepath.Join(dir, "origin.keys")
+			}
+			server := &FtpServer{config: c, logger: logrus.New()}
+
+			if err := server.openKeyLogs(); (err != nil) != tt.wantErr {
+				t.Fatalf("openKeyLogs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			defer server.clientKeyLog.close()
+			defer server.originKeyLog.close()
+			if (server.clientKeyLog != nil) != tt.wantClient || (server.originKeyLog != nil) != tt.wantOrigin {
+				t.Errorf("client key log = %v, origin key log = %v", server.clientKeyLog, server.originKeyLog)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "client.keys")); (err == nil) != tt.wantClient {
+				t.Errorf("client key log file exists = %v, want %v", err == nil, tt.wantClient)
+			}
+		})
+	}
+}
+
+func Test_keyLog_handshake(t *testing.T) {
+	cert, _ := loadTestCertificate(t)
+	path := filepath.Join(t.TempDir(), "origin.keys")
+	k, err := newKeyLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+	}()
+
+	origin := buildTLSConfigForOrigin()
+	origin.config.KeyLogWriter = k.writer()
+	if err := tls.Client(client, origin.getTLSConfig()).Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	k.close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "CLIENT_") && !strings.Contains(string(b), "_TRAFFIC_SECRET") {
+		t.Errorf("key log = %q, want NSS key log lines", b)
+	}
+
+	// secrets after close are dropped without error
+	if n, err := k.Write([]byte("CLIENT_RANDOM x y\n")); err != nil || n != 18 {
+		t.Errorf("Write() after close = %d, %v", n, err)
+	}
+	var none *keyLog
+	if none.writer() != nil {
+		t.Error("writer() of nil key log must be nil")
+	}
+}
@@ -0,0 +1,96 @@
+package pftp
+
+import (
+	"strings"
+)
+
+// landing is upload stored with temporary name and renamed to final
+// name after origin completed it. empty temp means no rename.
+// check is verified before rename when upload_verify is set.
+type landing struct {
+	temp  string
+	final string
+	check *integrityCheck
+}
+
+// send STOR to temporary name when upload_temp_suffix is set.
+// landing is queued for every STOR to match it with reply of origin.
+// STOR after REST appends to existing file, so it is not landed like APPE.
+func (c *clientHandler) landUpload(check *integrityCheck, rest int64) {
+	if c.command != "STOR" {
+		return
+	}
+
+	l := landing{}
+	suffix := c.context.UploadTempSuffix
+	if len(suffix) > 0 && len(c.param) > 0 && rest == 0 && !strings.HasSuffix(c.param, suffix) {
+		l = landing{temp: c.param + suffix, final: c.param}
+		c.line = "STOR " + l.temp + "\r\n"
+	}
+	if check != nil {
+		if len(l.temp) > 0 {
+			check.path = l.temp
+		}
+		l.check = check
+	}
+
+	c.proxy.pushLanding(l)
+}
+
+func (s *proxyServer) pushLanding(l landing) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.landings = append(s.landings, l)
+}
+
+func (s *proxyServer) popLanding() landing {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	if len(s.landings) == 0 {
+		return landing{}
+	}
+
+	l := s.landings[0]
+	s.landings = s.landings[1:]
+
+	return l
+}
+
+// verify completed upload, rename it to final name and send reply of STOR
+// to client. it runs in other goroutine because replies of internal commands
+// are read by proxy. upload mismatched by warn action is not renamed.
+func (s *proxyServer) finishLanding(l landing, reply string) {
+	if !s.verifyUpload(l.check) && l.check.action == verifyWarn {
+		reply = l.check.reply
+	} else if len(l.temp) > 0 {
+		reply = s.renameLanding(l, reply)
+	}
+
+	if err := s.sendToClient(strings.TrimRight(reply, "\r\n")); err != nil {
+		s.log.err("cannot send response to client")
+	}
+}
+
+// rename upload to final name. return reply of STOR sent to client.
+// commands of client wait until RNTO is sent because origin expects RNTO
+// right after RNFR.
+func (s *proxyServer) renameLanding(l landing, reply string) string {
+	s.sequenceMutex.Lock()
+	defer s.sequenceMutex.Unlock()
+
+	r, err := s.internalCommand("RNFR " + l.temp + "\r\n")
+	if err == nil && strings.HasPrefix(r, "350") {
+		r, err = s.internalCommand("RNTO " + l.final + "\r\n")
+	}
+
+	if err != nil || !strings.HasPrefix(r, "2") {
+		cause := strings.TrimSpace(r)
+		if err != nil {
+			cause = err.Error()
+		}
+		s.log.err("cannot rename uploaded %s to %s: %s", l.temp, l.final, cause)
+		reply = "451 Requested action aborted: uploaded file is left as " + l.temp + "\r\n"
+	}
+
+	return reply
+}
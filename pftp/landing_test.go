@@ -0,0 +1,111 @@
+package pftp
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+func Test_clientHandler_landUpload(t *testing.T) {
+	tests := []struct {
+		name     string
+		suffix   string
+		command  string
+		param    string
+		rest     int64
+		wantLine string
+		want     landing
+	}{
+		{name: "renamed", suffix: ".part", command: "STOR", param: "a.txt", wantLine: "STOR a.txt.part\r\n", want: landing{temp: "a.txt.part", final: "a.txt"}},
+		{name: "disabled", command: "STOR", param: "a.txt", wantLine: "STOR a.txt\r\n"},
+		{name: "resumed", suffix: ".part", command: "STOR", param: "a.txt", rest: 100, wantLine: "STOR a.txt\r\n"},
+		{name: "append", suffix: ".part", command: "APPE", param: "a.txt", wantLine: "APPE a.txt\r\n"},
+		{name: "already_temp_name", suffix: ".part", command: "STOR", param: "a.part", wantLine: "STOR a.part\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{
+				context: &Context{UploadTempSuffix: tt.suffix},
+				proxy:   &proxyServer{},
+				command: tt.command,
+				param:   tt.param,
+				line:    tt.command + " " + tt.param + "\r\n",
+			}
+
+			c.landUpload(nil, tt.rest)
+			if c.line != tt.wantLine {
+				t.Errorf("line = %q, want %q", c.line, tt.wantLine)
+			}
+			if got := c.proxy.popLanding(); got != tt.want {
+				t.Errorf("landing = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_proxyServer_finishLanding(t *testing.T) {
+	tests := []struct {
+		name       string
+		rnto       string
+		wantClient string
+	}{
+		{name: "renamed", rnto: "250 Rename successful.\r\n", wantClient: "226 Transfer complete.\r\n"},
+		{name: "rename_failed", rnto: "550 Permission denied.\r\n", wantClient: "451 Requested action aborted: uploaded file is left as a.txt.part\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originConn, originPeer := net.Pipe()
+			defer originConn.Close()
+			defer originPeer.Close()
+			clientConn, clientPeer := net.Pipe()
+			defer clientConn.Close()
+			defer clientPeer.Close()
+
+			s := &proxyServer{
+				config:         &Config{},
+				log:            &logger{},
+				isLoggedin:     true,
+				inDataTransfer: abool.New(),
+				originWriter:   bufio.NewWriter(originConn),
+				clientWriter:   bufio.NewWriter(clientConn),
+				mutex:          &sync.Mutex{},
+			}
+			s.pushLanding(landing{temp: "a.txt.part", final: "a.txt"})
+			s.pushCommand("STOR")
+
+			// origin replies to renames sent by proxy
+			commands := make(chan string, 2)
+			go func() {
+				r := bufio.NewReader(originPeer)
+				for _, reply := range []string{"350 Ready for RNTO.\r\n", tt.rnto} {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					commands <- line
+					s.processOriginReply(reply)
+				}
+			}()
+
+			if got, forward := s.processOriginReply("226 Transfer complete.\r\n"); forward {
+				t.Errorf("proxyServer.processOriginReply(STOR) = %q is forwarded before rename", got)
+			}
+
+			clientPeer.SetDeadline(time.Now().Add(3 * time.Second))
+			got, err := bufio.NewReader(clientPeer).ReadString('\n')
+			if err != nil || got != tt.wantClient {
+				t.Errorf("reply to client = %q, %v, want %q", got, err, tt.wantClient)
+			}
+			if c := <-commands; c != "RNFR a.txt.part\r\n" {
+				t.Errorf("first command = %q, want RNFR", c)
+			}
+			if c := <-commands; c != "RNTO a.txt\r\n" {
+				t.Errorf("second command = %q, want RNTO", c)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package pftp
+
+import (
+	"sort"
+	"strings"
+)
+
+// language tag of default messages in LANG negotiation (RFC 2640)
+const defaultLanguage = "EN"
+
+// return LANG feature line. current language is marked by "*"
+// ex) "LANG EN*;JA"
+func langFeature(locales map[string]map[string]string, current string) string {
+	tags := []string{}
+	for locale := range locales {
+		tags = append(tags, strings.ToUpper(locale))
+	}
+	sort.Strings(tags)
+	tags = append([]string{defaultLanguage}, tags...)
+
+	if len(current) == 0 {
+		current = defaultLanguage
+	}
+	for i, tag := range tags {
+		if strings.EqualFold(tag, current) {
+			tags[i] += "*"
+			break
+		}
+	}
+
+	return "LANG " + strings.Join(tags, ";")
+}
+
+// return locale of language tag. tag with subtag (ex. "ja-JP") matches
+// locale of primary tag ("ja") when the locale itself is not defined.
+// "" is returned for default language.
+func matchLocale(locales map[string]map[string]string, tag string) (string, bool) {
+	candidates := []string{tag}
+	if i := strings.Index(tag, "-"); i > 0 {
+		candidates = append(candidates, tag[:i])
+	}
+
+	for _, candidate := range candidates {
+		for locale := range locales {
+			if strings.EqualFold(locale, candidate) {
+				return locale, true
+			}
+		}
+		if strings.EqualFold(candidate, defaultLanguage) {
+			return "", true
+		}
+	}
+
+	return "", false
+}
+
+// return true when feature list has feature
+func hasFeature(features []string, name string) bool {
+	for _, feature := range features {
+		if strings.EqualFold(strings.SplitN(feature, " ", 2)[0], name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// add feature line to FEAT reply
+// ex) "211 No features\r\n" -> "211-Features:\r\n LANG EN*\r\n211 End\r\n"
+func addFeature(reply string, feature string) string {
+	if !strings.HasPrefix(reply, "211-") {
+		return "211-Features:\r\n " + feature + "\r\n211 End\r\n"
+	}
+
+	end := strings.LastIndex(strings.TrimRight(reply, "\r\n"), "\n")
+	if end == -1 {
+		return reply
+	}
+
+	return reply[:end+1] + " " + feature + "\r\n" + reply[end+1:]
+}
@@ -0,0 +1,134 @@
+package pftp
+
+import "testing"
+
+var testLocales = map[string]map[string]string{
+	"ja": {msgLoginRequired: "ログインしてください"},
+	"fr": {msgLoginRequired: "Veuillez vous connecter"},
+}
+
+func Test_langFeature(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		want    string
+	}{
+		{
+			name:    "default",
+			current: "",
+			want:    "LANG EN*;FR;JA",
+		},
+		{
+			name:    "ja",
+			current: "ja",
+			want:    "LANG EN;FR;JA*",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := langFeature(testLocales, tt.current); got != tt.want {
+				t.Errorf("langFeature() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_matchLocale(t *testing.T) {
+	tests := []struct {
+		tag    string
+		want   string
+		wantOk bool
+	}{
+		{tag: "ja", want: "ja", wantOk: true},
+		{tag: "JA-jp", want: "ja", wantOk: true},
+		{tag: "en", want: "", wantOk: true},
+		{tag: "en-US", want: "", wantOk: true},
+		{tag: "de", want: "", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			got, ok := matchLocale(testLocales, tt.tag)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("matchLocale(%s) = %q, %v, want %q, %v", tt.tag, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_addFeature(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  string
+	}{
+		{
+			name:  "multi_line",
+			reply: "211-Features:\r\n EPSV\r\n UTF8\r\n211 End\r\n",
+			want:  "211-Features:\r\n EPSV\r\n UTF8\r\n LANG EN*\r\n211 End\r\n",
+		},
+		{
+			name:  "no_features",
+			reply: "211 No features\r\n",
+			want:  "211-Features:\r\n LANG EN*\r\n211 End\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addFeature(tt.reply, "LANG EN*"); got != tt.want {
+				t.Errorf("addFeature() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_handleLANG(t *testing.T) {
+	tests := []struct {
+		name       string
+		param      string
+		wantCode   int
+		wantLocale string
+	}{
+		{
+			name:       "set_locale",
+			param:      "ja-JP",
+			wantCode:   200,
+			wantLocale: "ja",
+		},
+		{
+			name:       "default_language",
+			param:      "EN",
+			wantCode:   200,
+			wantLocale: "",
+		},
+		{
+			name:       "reset_to_config_locale",
+			param:      "",
+			wantCode:   200,
+			wantLocale: "fr",
+		},
+		{
+			name:       "unsupported",
+			param:      "de",
+			wantCode:   504,
+			wantLocale: "ja",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{
+				config:  &Config{Locale: "fr", Locales: testLocales},
+				context: &Context{Locale: "ja"},
+				proxy:   &proxyServer{},
+				param:   tt.param,
+			}
+
+			got := c.handleLANG()
+			if got == nil || got.code != tt.wantCode {
+				t.Fatalf("clientHandler.handleLANG() = %v, want %d", got, tt.wantCode)
+			}
+			if c.context.Locale != tt.wantLocale {
+				t.Errorf("Context.Locale = %q, want %q", c.context.Locale, tt.wantLocale)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package pftp
+
+import "fmt"
+
+type connectFunc func(*Context) error
+type disconnectFunc func(*Context)
+
+// OnConnect set function called when client session starts, after connection
+// limits and bans are checked and before origin is connected. it is for
+// allocating external resources of session like license seats. Returning
+// error refuses the session with 421.
+func (server *FtpServer) OnConnect(f connectFunc) {
+	server.hooks.connect = f
+}
+
+// OnDisconnect set function called once when session accepted by OnConnect
+// ends, after its connections are closed. it is called even when OnConnect
+// is not set, so resources can be released by Context of the session.
+func (server *FtpServer) OnDisconnect(f disconnectFunc) {
+	server.hooks.disconnect = f
+}
+
+// call connect hook and mark session started. return reply when hook refused
+// session.
+func (c *clientHandler) startSession() *result {
+	c.context.SessionID = c.id
+	c.syncContext()
+	if c.hooks != nil && c.hooks.connect != nil {
+		if err := c.hooks.connect(c.context); err != nil {
+			c.setCloseReason(closeReasonPolicyKill)
+			return &result{
+				code: 421,
+				msg:  c.message(msgSessionRefused),
+				err:  fmt.Errorf("session is refused by connect hook: %s", err.Error()),
+				log:  c.log,
+			}
+		}
+	}
+	c.sessionStarted = true
+
+	return nil
+}
+
+// call disconnect hook of started session
+func (c *clientHandler) endSession() {
+	if !c.sessionStarted {
+		return
+	}
+	c.sessionStarted = false
+
+	if c.hooks != nil && c.hooks.disconnect != nil {
+		c.syncContext()
+		c.hooks.disconnect(c.context)
+	}
+}
@@ -0,0 +1,65 @@
+package pftp
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_clientHandler_session_hooks(t *testing.T) {
+	tests := []struct {
+		name           string
+		connect        connectFunc
+		wantCode       int
+		wantDisconnect int
+	}{
+		{name: "accepted", connect: func(c *Context) error { return nil }, wantDisconnect: 1},
+		{name: "no_connect_hook", wantDisconnect: 1},
+		{name: "refused", connect: func(c *Context) error { return errors.New("no seat") }, wantCode: 421},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var connected *Context
+			disconnected := 0
+			h := &hooks{
+				disconnect: func(ctx *Context) {
+					if connected != nil && ctx != connected {
+						t.Errorf("disconnect hook got other context")
+					}
+					if ctx.SessionID != 7 || ctx.ClientAddr != "192.0.2.1:50000" {
+						t.Errorf("disconnect hook got session %d of %s", ctx.SessionID, ctx.ClientAddr)
+					}
+					disconnected++
+				},
+			}
+			if tt.connect != nil {
+				h.connect = func(ctx *Context) error {
+					connected = ctx
+					return tt.connect(ctx)
+				}
+			}
+			c := &clientHandler{
+				id:      7,
+				srcIP:   "192.0.2.1:50000",
+				config:  &Config{},
+				context: &Context{},
+				hooks:   h,
+				log:     &logger{},
+			}
+
+			r := c.startSession()
+			if tt.wantCode > 0 {
+				if r == nil || r.code != tt.wantCode {
+					t.Fatalf("startSession() = %+v, want code %d", r, tt.wantCode)
+				}
+			} else if r != nil {
+				t.Fatalf("startSession() = %+v, want nil", r)
+			}
+
+			c.endSession()
+			c.endSession()
+			if disconnected != tt.wantDisconnect {
+				t.Errorf("disconnect hook is called %d times, want %d", disconnected, tt.wantDisconnect)
+			}
+		})
+	}
+}
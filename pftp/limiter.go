@@ -0,0 +1,128 @@
+package pftp
+
+import (
+	"sync"
+	"time"
+)
+
+// transferLimiter limit concurrent data transfers per origin.
+// it is shared by all client sessions of server.
+type transferLimiter struct {
+	mutex        sync.Mutex
+	slots        map[string]chan struct{}
+	limits       map[string]int
+	defaultLimit int
+}
+
+func newTransferLimiter(c *Config) *transferLimiter {
+	if c.MaxOriginTransfers <= 0 && len(c.OriginTransferLimits) == 0 {
+		return nil
+	}
+
+	return &transferLimiter{
+		slots:        map[string]chan struct{}{},
+		limits:       c.OriginTransferLimits,
+		defaultLimit: c.MaxOriginTransfers,
+	}
+}
+
+// return slots of origin. nil means origin is not limited.
+func (l *transferLimiter) originSlots(origin string) chan struct{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if slots, ok := l.slots[origin]; ok {
+		return slots
+	}
+
+	limit, ok := l.limits[origin]
+	if !ok {
+		limit = l.defaultLimit
+	}
+
+	var slots chan struct{}
+	if limit > 0 {
+		slots = make(chan struct{}, limit)
+	}
+	l.slots[origin] = slots
+
+	return slots
+}
+
+// take transfer slot of origin. wait until slot is released at most wait.
+// return release function of the slot and false when no slot is available.
+func (l *transferLimiter) acquire(origin string, wait time.Duration) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	slots := l.originSlots(origin)
+	if slots == nil {
+		return func() {}, true
+	}
+
+	release := func() { <-slots }
+
+	select {
+	case slots <- struct{}{}:
+		return release, true
+	default:
+	}
+
+	if wait <= 0 {
+		return nil, false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case slots <- struct{}{}:
+		return release, true
+	case <-timer.C:
+		return nil, false
+	}
+}
+
+// sessionLimiter admit client sessions up to soft_max_connections.
+// sessions over soft limit wait for slot until hard limit(max_connections).
+type sessionLimiter struct {
+	slots chan struct{}
+}
+
+func newSessionLimiter(c *Config) *sessionLimiter {
+	if c.SoftMaxConnections <= 0 {
+		return nil
+	}
+
+	return &sessionLimiter{slots: make(chan struct{}, c.SoftMaxConnections)}
+}
+
+// take session slot. when no slot is available, call busy and
+// wait until slot is released at most wait.
+// return release function of the slot and false when wait is expired.
+func (l *sessionLimiter) acquire(wait time.Duration, busy func()) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	release := func() { <-l.slots }
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, true
+	default:
+	}
+
+	busy()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, true
+	case <-timer.C:
+		return nil, false
+	}
+}
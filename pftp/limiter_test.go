@@ -0,0 +1,112 @@
+package pftp
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_transferLimiter_acquire(t *testing.T) {
+	l := newTransferLimiter(&Config{
+		MaxOriginTransfers:   2,
+		OriginTransferLimits: map[string]int{"legacy:21": 1, "unlimited:21": 0},
+	})
+
+	tests := []struct {
+		name   string
+		origin string
+		count  int
+		want   int
+	}{
+		{
+			name:   "default_limit",
+			origin: "origin:21",
+			count:  3,
+			want:   2,
+		},
+		{
+			name:   "origin_limit",
+			origin: "legacy:21",
+			count:  3,
+			want:   1,
+		},
+		{
+			name:   "unlimited",
+			origin: "unlimited:21",
+			count:  10,
+			want:   10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := 0
+			for i := 0; i < tt.count; i++ {
+				if _, ok := l.acquire(tt.origin, 0); ok {
+					got++
+				}
+			}
+			if got != tt.want {
+				t.Errorf("transferLimiter.acquire() succeeded %d times, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_transferLimiter_acquire_wait(t *testing.T) {
+	l := newTransferLimiter(&Config{MaxOriginTransfers: 1})
+
+	release, ok := l.acquire("origin:21", 0)
+	if !ok {
+		t.Fatal("transferLimiter.acquire() = false, want true")
+	}
+
+	if _, ok := l.acquire("origin:21", 10*time.Millisecond); ok {
+		t.Errorf("transferLimiter.acquire() = true while slot is used, want false")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+	if _, ok := l.acquire("origin:21", time.Second); !ok {
+		t.Errorf("transferLimiter.acquire() = false after release, want true")
+	}
+}
+
+func Test_transferLimiter_nil(t *testing.T) {
+	l := newTransferLimiter(&Config{})
+	if l != nil {
+		t.Fatalf("newTransferLimiter() = %v, want nil without limits", l)
+	}
+	if _, ok := l.acquire("origin:21", 0); !ok {
+		t.Errorf("transferLimiter.acquire() = false, want true without limits")
+	}
+}
+
+func Test_sessionLimiter_acquire(t *testing.T) {
+	l := newSessionLimiter(&Config{SoftMaxConnections: 1})
+
+	busy := 0
+	release, ok := l.acquire(0, func() { busy++ })
+	if !ok || busy != 0 {
+		t.Fatalf("sessionLimiter.acquire() = %v with busy %d, want true without busy", ok, busy)
+	}
+
+	// queued session is not admitted until wait expires
+	if _, ok := l.acquire(10*time.Millisecond, func() { busy++ }); ok || busy != 1 {
+		t.Errorf("sessionLimiter.acquire() = %v with busy %d, want false with busy", ok, busy)
+	}
+
+	// queued session takes released slot
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+	if _, ok := l.acquire(time.Second, func() { busy++ }); !ok || busy != 2 {
+		t.Errorf("sessionLimiter.acquire() = %v with busy %d, want true with busy", ok, busy)
+	}
+
+	// no soft limit
+	if _, ok := newSessionLimiter(&Config{}).acquire(0, func() { busy++ }); !ok || busy != 2 {
+		t.Errorf("nil sessionLimiter.acquire() = %v, want true", ok)
+	}
+}
@@ -0,0 +1,106 @@
+package pftp
+
+import (
+	"sync"
+	"time"
+)
+
+// dataListenerGC close passive data listeners which client did not connect
+// to in data_listener_timeout, so their ports return to data_port_range.
+// transfer command to closed listener gets 425. listener accepting client
+// after transfer command has its own deadline and is kept.
+type dataListenerGC struct {
+	mutex    sync.Mutex
+	timeout  time.Duration
+	handlers map[*dataHandler]struct{}
+	metrics  *metrics
+}
+
+func newDataListenerGC(c *Config, m *metrics) *dataListenerGC {
+	if c.DataListenerTimeout <= 0 {
+		return nil
+	}
+
+	return &dataListenerGC{
+		timeout:  time.Duration(c.DataListenerTimeout) * time.Second,
+		handlers: map[*dataHandler]struct{}{},
+		metrics:  m,
+	}
+}
+
+// watch client listener of d
+func (g *dataListenerGC) add(d *dataHandler) {
+	if g == nil {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	d.listeners = g
+	g.handlers[d] = struct{}{}
+}
+
+func (g *dataListenerGC) remove(d *dataHandler) {
+	if g == nil {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.handlers, d)
+}
+
+// reap listeners every quarter of timeout until stop is closed
+func (g *dataListenerGC) run(stop chan struct{}) {
+	if g == nil {
+		return
+	}
+
+	interval := g.timeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			g.reap(time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// close listeners older than timeout and return count of them
+func (g *dataListenerGC) reap(now time.Time) int {
+	g.mutex.Lock()
+	stale := []*dataHandler{}
+	for d := range g.handlers {
+		if d.staleListener(now, g.timeout) {
+			stale = append(stale, d)
+		}
+	}
+	open := len(g.handlers) - len(stale)
+	g.mutex.Unlock()
+
+	for _, d := range stale {
+		d.log.info("close data listener not connected by client in %s", g.timeout)
+		connectionCloser(d, d.log)
+	}
+	g.metrics.set("pftp_data_listeners", "Passive data listeners waiting client connection.", float64(open))
+	if len(stale) > 0 {
+		g.metrics.add("pftp_data_listeners_reaped_total", "Passive data listeners closed because client did not connect.", float64(len(stale)))
+	}
+
+	return len(stale)
+}
+
+// return true when client listener waits connection longer than timeout
+// without transfer command
+func (d *dataHandler) staleListener(now time.Time, timeout time.Duration) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return !d.closed && !d.accepting && d.clientConn.listener != nil && now.Sub(d.listenedAt) >= timeout
+}
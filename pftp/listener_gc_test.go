@@ -0,0 +1,45 @@
+package pftp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+func Test_dataListenerGC_reap(t *testing.T) {
+	client, clientPeer := net.Pipe()
+	defer client.Close()
+	defer clientPeer.Close()
+	origin, originPeer := net.Pipe()
+	defer origin.Close()
+	defer originPeer.Close()
+
+	g := newDataListenerGC(&Config{DataListenerTimeout: 60}, newMetrics(&Config{}))
+	newHandler := func() *dataHandler {
+		d, err := newDataHandler(&Config{}, &logger{}, client, origin, "PASV", nil, abool.New(), abool.New(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.add(d)
+		return d
+	}
+	stale, accepting, closed := newHandler(), newHandler(), newHandler()
+	accepting.accepting = true
+	closed.Close()
+	defer accepting.Close()
+
+	if n := g.reap(time.Now()); n != 0 {
+		t.Errorf("reap() = %d before timeout, want 0", n)
+	}
+	if n := g.reap(time.Now().Add(time.Minute)); n != 1 {
+		t.Errorf("reap() = %d after timeout, want 1", n)
+	}
+	if !stale.isClosed() || accepting.isClosed() {
+		t.Errorf("stale closed = %v, accepting closed = %v", stale.isClosed(), accepting.isClosed())
+	}
+	if _, ok := g.handlers[stale]; ok || len(g.handlers) != 1 {
+		t.Errorf("handlers = %v, want only accepting one", g.handlers)
+	}
+}
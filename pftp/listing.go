@@ -0,0 +1,213 @@
+package pftp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// listingPolicy rewrite directory listing of LIST, NLST and MLSD
+// sent by origin before relaying it to client.
+type listingPolicy struct {
+	command      string
+	sort         bool
+	hideDotfiles bool
+	hidePatterns []string
+	dir          string // directory of listing. empty when it is not known
+	bufferSize   int    // max bytes buffered to sort listing
+}
+
+// return listing policy of session for listing command. nil means listing is streamed as is.
+func (c *clientHandler) newListingPolicy() *listingPolicy {
+	switch c.command {
+	case "LIST", "NLST", "MLSD":
+	default:
+		return nil
+	}
+	if !c.context.ListingSort && !c.context.ListingHideDotfiles && len(c.context.ListingHidePatterns) == 0 {
+		return nil
+	}
+
+	return &listingPolicy{
+		command:      c.command,
+		sort:         c.context.ListingSort,
+		hideDotfiles: c.context.ListingHideDotfiles,
+		hidePatterns: c.context.ListingHidePatterns,
+		dir:          c.listingDir(),
+		bufferSize:   c.config.ListingBufferSize,
+	}
+}
+
+// validate patterns of listing_hide_patterns
+func validateListingPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("configuration error: listing hide pattern %s is wrong", p)
+		}
+	}
+
+	return nil
+}
+
+// return file name of listing line. empty means line is not entry (ex: "total 8").
+// ex) LIST "-rw-r--r-- 1 ftp ftp 10 Jan 01 00:00 a b.txt" -> "a b.txt"
+// ex) LIST "01-01-21  10:00AM  <DIR>  dir" -> "dir"
+// ex) MLSD "type=file;size=10; a.txt" -> "a.txt"
+func listingName(command string, line string) string {
+	line = strings.TrimRight(line, "\r\n")
+
+	switch command {
+	case "NLST":
+		return line
+	case "MLSD":
+		if i := strings.Index(line, "; "); i >= 0 {
+			return line[i+2:]
+		}
+		return strings.TrimPrefix(line, " ")
+	}
+
+	// unix format has name after 8 fields and DOS format after 3 fields
+	skip := 8
+	if len(line) > 0 && line[0] >= '0' && line[0] <= '9' {
+		skip = 3
+	}
+	rest := line
+	for i := 0; i < skip; i++ {
+		rest = strings.TrimLeft(rest, " ")
+		j := strings.Index(rest, " ")
+		if j < 0 {
+			return ""
+		}
+		rest = rest[j:]
+	}
+	name := strings.TrimLeft(rest, " ")
+
+	// symbolic link is shown as "name -> target"
+	if strings.HasPrefix(line, "l") {
+		if i := strings.Index(name, " -> "); i >= 0 {
+			name = name[:i]
+		}
+	}
+
+	return name
+}
+
+// return true when line of listing should not be sent to client
+func (p *listingPolicy) hidden(line string) bool {
+	name := listingName(p.command, line)
+	if len(name) == 0 {
+		return false
+	}
+
+	if p.hideDotfiles && strings.HasPrefix(path.Base(name), ".") {
+		return true
+	}
+	if len(p.dir) > 0 && !strings.HasPrefix(name, "/") {
+		name = path.Join(p.dir, name)
+	}
+
+	return maskedPath(p.hidePatterns, name)
+}
+
+// sort lines by file name. lines which are not entries stay first.
+func (p *listingPolicy) sortLines(lines []string) {
+	sort.SliceStable(lines, func(i, j int) bool {
+		return listingName(p.command, lines[i]) < listingName(p.command, lines[j])
+	})
+}
+
+// copy listing from origin to client with listing policy. listing is buffered
+// up to listing_buffer_size for sorting, and streamed unsorted beyond it.
+func (d *dataHandler) copyListing(timeout int) error {
+	d.mutex.Lock()
+	src := d.originConn.dataConn
+	d.mutex.Unlock()
+	dst := d.clientConn.dataConn
+
+	sorting := d.listing.sort
+	lines := []string{}
+	size := 0
+	write := func(lines ...string) error {
+		for _, line := range lines {
+			if _, err := io.WriteString(dst, line); err != nil {
+				dst.Close()
+				src.Close()
+				return &destinationError{err}
+			}
+		}
+		return nil
+	}
+
+	pending := []byte{}
+	buff := make([]byte, bufferSize)
+	for {
+		// check about aborted from outside of handler
+		if d.isClosed() {
+			return nil
+		}
+
+		n, err := src.Read(buff)
+		if n > 0 {
+			atomic.StoreInt64(&d.lastActivity, time.Now().UnixNano())
+			atomic.AddInt64(&d.transferred, int64(n))
+			pending = append(pending, buff[:n]...)
+		}
+
+		// last line may not have line break
+		if err == io.EOF && len(pending) > 0 && pending[len(pending)-1] != '\n' {
+			pending = append(pending, '\n')
+		}
+		for {
+			i := bytes.IndexByte(pending, '\n')
+			if i < 0 {
+				break
+			}
+			line := string(pending[:i+1])
+			pending = pending[i+1:]
+
+			if d.listing.hidden(line) {
+				continue
+			}
+			if !sorting {
+				if err := write(line); err != nil {
+					return err
+				}
+				continue
+			}
+
+			lines = append(lines, line)
+			size += len(line)
+			if size > d.listing.bufferSize {
+				d.log.info("listing is larger than %d bytes. it is sent without sorting", d.listing.bufferSize)
+				sorting = false
+				if err := write(lines...); err != nil {
+					return err
+				}
+				lines = nil
+			}
+		}
+		if n > 0 {
+			// increase data transfer timeout
+			src.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				return &sourceError{err}
+			}
+			if sorting {
+				d.listing.sortLines(lines)
+				if err := write(lines...); err != nil {
+					return err
+				}
+			}
+			// got EOF from src, send EOF to dst
+			return sendEOF(dst)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package pftp
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func Test_listingName(t *testing.T) {
+	tests := []struct {
+		command string
+		line    string
+		want    string
+	}{
+		{command: "LIST", line: "-rw-r--r--    1 ftp      ftp            10 Jan 01 00:00 a b.txt\r\n", want: "a b.txt"},
+		{command: "LIST", line: "lrwxrwxrwx    1 ftp      ftp             4 Jan 01 00:00 link -> file\r\n", want: "link"},
+		{command: "LIST", line: "01-01-21  10:00AM       <DIR>          dir\r\n", want: "dir"},
+		{command: "LIST", line: "total 8\r\n", want: ""},
+		{command: "NLST", line: "dir/.hidden\r\n", want: "dir/.hidden"},
+		{command: "MLSD", line: "type=file;size=10; a.txt\r\n", want: "a.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := listingName(tt.command, tt.line); got != tt.want {
+				t.Errorf("listingName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dataHandler_copyListing(t *testing.T) {
+	listing := "total 8\r\n" +
+		"-rw-r--r-- 1 ftp ftp 10 Jan 01 00:00 b.txt\r\n" +
+		"-rw-r--r-- 1 ftp ftp 10 Jan 01 00:00 .profile\r\n" +
+		"-rw-r--r-- 1 ftp ftp 10 Jan 01 00:00 c.tmp\r\n" +
+		"drwxr-xr-x 2 ftp ftp 10 Jan 01 00:00 a\r\n" +
+		"-rw-r--r-- 1 ftp ftp 10 Jan 01 00:00 d.txt"
+
+	tests := []struct {
+		name   string
+		policy *listingPolicy
+		want   string
+	}{
+		{
+			name:   "sort_and_hide",
+			policy: &listingPolicy{command: "LIST", sort: true, hideDotfiles: true, hidePatterns: []string{"*.tmp"}, bufferSize: 1024},
+			want: "total 8\r\n" +
+				"drwxr-xr-x 2 ftp ftp 10 Jan 01 00:00 a\r\n" +
+				"-rw-r--r-- 1 ftp ftp 10 Jan 01 00:00 b.txt\r\n" +
+				"-rw-r--r-- 1 ftp ftp 10 Jan 01 00:00 d.txt\n",
+		},
+		{
+			name:   "too_large_to_sort",
+			policy: &listingPolicy{command: "LIST", sort: true, hideDotfiles: true, bufferSize: 10},
+			want: "total 8\r\n" +
+				"-rw-r--r-- 1 ftp ftp 10 Jan 01 00:00 b.txt\r\n" +
+				"-rw-r--r-- 1 ftp ftp 10 Jan 01 00:00 c.tmp\r\n" +
+				"drwxr-xr-x 2 ftp ftp 10 Jan 01 00:00 a\r\n" +
+				"-rw-r--r-- 1 ftp ftp 10 Jan 01 00:00 d.txt\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originConn, originPeer := net.Pipe()
+			defer originConn.Close()
+			clientConn, clientPeer := net.Pipe()
+
+			d := &dataHandler{
+				originConn: connector{dataConn: originConn},
+				clientConn: connector{dataConn: clientConn},
+				listing:    tt.policy,
+				mutex:      &sync.Mutex{},
+				log:        &logger{},
+			}
+
+			go func() {
+				io.WriteString(originPeer, listing[:50])
+				io.WriteString(originPeer, listing[50:])
+				originPeer.Close()
+			}()
+			received := make(chan []byte, 1)
+			go func() {
+				b, _ := io.ReadAll(clientPeer)
+				received <- b
+			}()
+
+			if err := d.copyFromOrigin(10); err != nil {
+				t.Errorf("dataHandler.copyListing() = %v", err)
+			}
+			clientConn.Close()
+			got := <-received
+			if string(got) != tt.want {
+				t.Errorf("dataHandler.copyListing() sent %q, want %q", got, tt.want)
+			}
+			if n := d.transferredBytes(); n != int64(len(listing)) {
+				t.Errorf("transferred bytes = %d, want %d", n, len(listing))
+			}
+		})
+	}
+}
+
+func Test_clientHandler_newListingPolicy(t *testing.T) {
+	c := &clientHandler{
+		config:  &Config{ListingBufferSize: 100},
+		context: &Context{ListingHidePatterns: []string{"*.tmp"}},
+		proxy:   &proxyServer{},
+		command: "MLSD",
+	}
+	want := &listingPolicy{command: "MLSD", hidePatterns: []string{"*.tmp"}, bufferSize: 100}
+	if got := c.newListingPolicy(); !reflect.DeepEqual(got, want) {
+		t.Errorf("clientHandler.newListingPolicy() = %+v, want %+v", got, want)
+	}
+
+	c.command = "RETR"
+	if got := c.newListingPolicy(); got != nil {
+		t.Errorf("clientHandler.newListingPolicy() for RETR = %+v, want nil", got)
+	}
+
+	c.command, c.context = "LIST", &Context{}
+	if got := c.newListingPolicy(); got != nil {
+		t.Errorf("clientHandler.newListingPolicy() without policy = %+v, want nil", got)
+	}
+}
+
+func Test_dataHandler_copyListing_client_closed(t *testing.T) {
+	originConn, originPeer := net.Pipe()
+	defer originPeer.Close()
+	clientConn, clientPeer := net.Pipe()
+	clientPeer.Close()
+
+	d := &dataHandler{
+		originConn: connector{dataConn: originConn},
+		clientConn: connector{dataConn: clientConn},
+		listing:    &listingPolicy{command: "LIST", bufferSize: 1024},
+		mutex:      &sync.Mutex{},
+		log:        &logger{},
+	}
+
+	go io.WriteString(originPeer, "-rw-r--r-- 1 ftp ftp 10 Jan 01 00:00 a.txt\r\n")
+	err := d.copyFromOrigin(10)
+	if _, ok := err.(*destinationError); !ok {
+		t.Errorf("dataHandler.copyListing() = %v, want destinationError", err)
+	}
+}
@@ -10,19 +10,28 @@ type logger struct {
 	fromip string
 	user   string
 	id     uint64
+	out    logrus.FieldLogger
+}
+
+// return output logger. use logrus standard logger when not set
+func (l *logger) output() logrus.FieldLogger {
+	if l.out == nil {
+		return logrus.StandardLogger()
+	}
+	return l.out
 }
 
 func (l *logger) debug(format string, args ...interface{}) {
 	format = fmt.Sprintf("[%d] user:%s addr:%s %s", l.id, l.user, l.fromip, format)
-	logrus.Debugf(format, args...)
+	l.output().Debugf(format, args...)
 }
 
 func (l *logger) info(format string, args ...interface{}) {
 	format = fmt.Sprintf("[%d] user:%s addr:%s %s", l.id, l.user, l.fromip, format)
-	logrus.Infof(format, args...)
+	l.output().Infof(format, args...)
 }
 
 func (l *logger) err(format string, args ...interface{}) {
 	format = fmt.Sprintf("[%d] user:%s addr:%s %s", l.id, l.user, l.fromip, format)
-	logrus.Errorf(format, args...)
+	l.output().Errorf(format, args...)
 }
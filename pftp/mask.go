@@ -0,0 +1,109 @@
+package pftp
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// return true when path is masked by hide patterns. pattern without "/" is
+// matched with each element of path, and pattern with "/" is matched with
+// absolute path and its parent directories.
+// ex) "lost+found" masks "/data/lost+found/a", "/home/*" masks "/home/other/a"
+func maskedPath(patterns []string, p string) bool {
+	if len(patterns) == 0 || len(p) == 0 {
+		return false
+	}
+
+	p = path.Clean(p)
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			for _, elem := range strings.Split(p, "/") {
+				if ok, _ := path.Match(pattern, elem); ok && len(elem) > 0 {
+					return true
+				}
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(p, "/") {
+			continue
+		}
+		for dir := p; dir != "/"; dir = path.Dir(dir) {
+			if ok, _ := path.Match(pattern, dir); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// return absolute path of current directory of origin. it is asked to
+// origin by PWD when it is not tracked yet. empty when it is not known.
+func (c *clientHandler) currentDir() string {
+	if cwd := c.proxy.getCwd(); len(cwd) > 0 || !c.proxy.isLoggedIn() {
+		return cwd
+	}
+
+	reply, err := c.proxy.internalCommand("PWD\r\n")
+	if err != nil || replyCode(reply) != "257" {
+		return ""
+	}
+	dir, ok := parsePWDReply(reply)
+	if !ok || !path.IsAbs(dir) {
+		return ""
+	}
+	c.proxy.learnCwd(path.Clean(dir))
+
+	return path.Clean(dir)
+}
+
+// return absolute directory of listing command. empty when it is not known.
+func (c *clientHandler) listingDir() string {
+	words := strings.Split(c.param, " ")
+	i := pathArgument(c.command, words)
+	dir := ""
+	if i >= 0 && i < len(words) {
+		dir = strings.Join(words[i:], " ")
+	}
+
+	if strings.HasPrefix(dir, "/") {
+		return path.Clean(dir)
+	}
+	if cwd := c.currentDir(); len(cwd) > 0 {
+		return path.Join(cwd, dir)
+	}
+
+	return ""
+}
+
+// reject commands to masked paths with 550 as if they do not exist.
+// return reply when command is rejected by proxy.
+func (c *clientHandler) enforceMaskedPaths() *result {
+	if len(c.context.ListingHidePatterns) == 0 {
+		return nil
+	}
+
+	words := strings.Split(c.param, " ")
+	i := pathArgument(c.command, words)
+	if i < 0 || i >= len(words) {
+		return nil
+	}
+
+	arg := strings.SplitN(c.param, " ", i+1)[i]
+	p := arg
+	if !strings.HasPrefix(p, "/") {
+		if cwd := c.currentDir(); len(cwd) > 0 {
+			p = path.Join(cwd, p)
+		}
+	}
+	if !maskedPath(c.context.ListingHidePatterns, p) {
+		return nil
+	}
+
+	return &result{
+		code: 550,
+		msg:  fmt.Sprintf("%s: No such file or directory", arg),
+	}
+}
@@ -0,0 +1,113 @@
+package pftp
+
+import (
+	"testing"
+)
+
+func Test_maskedPath(t *testing.T) {
+	patterns := []string{".banner", "lost+found", "/home/*", "/shared/tenant-[ab]"}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: ".banner", want: true},
+		{path: "/pub/.banner", want: true},
+		{path: "/data/lost+found/file", want: true},
+		{path: "/home/other", want: true},
+		{path: "/home/other/dir/file", want: true},
+		{path: "/home", want: false},
+		{path: "/shared/tenant-a/file", want: true},
+		{path: "/shared/tenant-c/file", want: false},
+		{path: "home/other", want: false},
+		{path: "/pub/file.txt", want: false},
+		{path: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := maskedPath(patterns, tt.path); got != tt.want {
+				t.Errorf("maskedPath(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_enforceMaskedPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		root     string
+		cwd      string
+		command  string
+		param    string
+		wantCode int
+	}{
+		{name: "masked_name", command: "RETR", param: ".banner", wantCode: 550},
+		{name: "masked_dir", command: "CWD", param: "/home/other", wantCode: 550},
+		{name: "relative_unknown_dir", command: "CWD", param: "other", wantCode: 0},
+		{name: "relative_in_root", root: "/", cwd: "/home", command: "CWD", param: "other", wantCode: 550},
+		{name: "listing_option", command: "LIST", param: "-la lost+found", wantCode: 550},
+		{name: "not_masked", command: "STOR", param: "/pub/file", wantCode: 0},
+		{name: "no_path", command: "PWD", wantCode: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{
+				context: &Context{RootPath: tt.root, ListingHidePatterns: []string{".banner", "lost+found", "/home/*"}},
+				proxy:   &proxyServer{cwd: tt.cwd},
+				command: tt.command,
+				param:   tt.param,
+			}
+
+			r := c.enforceMaskedPaths()
+			if tt.wantCode == 0 && r != nil {
+				t.Errorf("clientHandler.enforceMaskedPaths() = %+v, want nil", r)
+			} else if tt.wantCode != 0 && (r == nil || r.code != tt.wantCode) {
+				t.Errorf("clientHandler.enforceMaskedPaths() = %+v, want code %d", r, tt.wantCode)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_enforceMaskedPaths_trackedCwd(t *testing.T) {
+	c := &clientHandler{
+		context: &Context{ListingHidePatterns: []string{"/home/*"}},
+		proxy:   &proxyServer{isLoggedin: true},
+	}
+
+	for _, line := range []string{"CWD /srv", "CWD ../home"} {
+		c.parseLine(line)
+		if r := c.enforceMaskedPaths(); r != nil {
+			t.Fatalf("enforceMaskedPaths() of %s = %+v, want nil", line, r)
+		}
+		c.trackCwd()
+		c.proxy.finishCwd(true)
+	}
+	if got := c.proxy.getCwd(); got != "/home" {
+		t.Fatalf("cwd = %q, want /home", got)
+	}
+
+	for _, line := range []string{"RETR alice/secret", "LIST alice", "CWD alice"} {
+		c.parseLine(line)
+		if r := c.enforceMaskedPaths(); r == nil || r.code != 550 {
+			t.Errorf("enforceMaskedPaths() of %s = %+v, want code 550", line, r)
+		}
+	}
+	if got := c.listingDir(); got != "/home/alice" {
+		t.Errorf("listingDir() = %q, want /home/alice", got)
+	}
+}
+
+func Test_listingPolicy_hidden_path(t *testing.T) {
+	p := &listingPolicy{command: "LIST", hidePatterns: []string{"/home/other"}, dir: "/home"}
+	if !p.hidden("drwxr-xr-x 2 ftp ftp 10 Jan 01 00:00 other\r\n") {
+		t.Errorf("listingPolicy.hidden() = false for /home/other")
+	}
+	if p.hidden("drwxr-xr-x 2 ftp ftp 10 Jan 01 00:00 mine\r\n") {
+		t.Errorf("listingPolicy.hidden() = true for /home/mine")
+	}
+
+	// path pattern does not match when directory is not known
+	p.dir = ""
+	if p.hidden("drwxr-xr-x 2 ftp ftp 10 Jan 01 00:00 other\r\n") {
+		t.Errorf("listingPolicy.hidden() = true without directory")
+	}
+}
@@ -0,0 +1,192 @@
+package pftp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// keys of proxy generated replies. operators can override
+// each text by [messages] table in config.
+const (
+	msgWelcome              = "welcome"
+	msgMaxConnections       = "max_connections"
+	msgIdleTimeout          = "idle_timeout"
+	msgReadOnly             = "read_only"
+	msgUnresolvedOrigin     = "unresolved_origin"
+	msgOriginTimeout        = "origin_timeout"
+	msgProxyError           = "proxy_error"
+	msgLoginRequired        = "login_required"
+	msgAlreadyLoggedIn      = "already_logged_in"
+	msgTransferInProgress   = "transfer_in_progress"
+	msgDataConnection       = "data_connection_failed"
+	msgTLSRejected          = "tls_rejected"
+	msgTLSRenegotiation     = "tls_renegotiation"
+	msgOriginBusy           = "origin_busy"
+	msgServerBusy           = "server_busy"
+	msgBanned               = "banned"
+	msgServiceClosing       = "service_closing"
+	msgUnsupportedParameter = "unsupported_parameter"
+	msgOutsideSchedule      = "outside_schedule"
+	msgDraining             = "draining"
+	msgSpooled              = "spooled"
+	msgSpoolFull            = "spool_full"
+	msgOverloaded           = "overloaded"
+	msgDuplicateUpload      = "duplicate_upload"
+	msgOriginNotReady       = "origin_not_ready"
+	msgUserBlocked          = "user_blocked"
+	msgAttributeDenied      = "attribute_denied"
+	msgSessionRefused       = "session_refused"
+	msgStandby              = "standby"
+	msgIntegrityMismatch    = "integrity_mismatch"
+)
+
+var defaultMessages = map[string]string{
+	msgMaxConnections:       "max client exceeded",
+	msgIdleTimeout:          "command timeout : closing control connection",
+	msgReadOnly:             "{{.Command}}: permission denied (read-only)",
+	msgOriginTimeout:        "Service not available (origin not responding)",
+	msgProxyError:           "I can't deal with you (proxy error)",
+	msgLoginRequired:        "Please login with USER and PASS",
+	msgAlreadyLoggedIn:      "Already logged in",
+	msgTransferInProgress:   "{{.Command}}: data transfer in progress",
+	msgDataConnection:       "Can't open data connection",
+	msgTLSRejected:          "TLS connection rejected",
+	msgTLSRenegotiation:     "TLS renegotiation is not supported",
+	msgOriginBusy:           "{{.Command}}: too many transfers to server. Retry after a few seconds",
+	msgServerBusy:           "server busy, retrying",
+	msgBanned:               "Too many login failures. Try again later",
+	msgServiceClosing:       "Service closing control connection",
+	msgUnsupportedParameter: "{{.Command}}: command not implemented for that parameter",
+	msgOutsideSchedule:      "{{.Command}}: not allowed at this time",
+	msgDraining:             "Service is shutting down. Try again later",
+	msgSpooled:              "Transfer complete. File is queued for delivery",
+	msgSpoolFull:            "{{.Command}}: insufficient storage space",
+	msgOverloaded:           "Service not available (server overloaded). Try again later",
+	msgDuplicateUpload:      "Same file was uploaded recently",
+	msgOriginNotReady:       "", // empty relays 120 text of origin
+	msgUserBlocked:          "Login of this user is temporarily blocked",
+	msgAttributeDenied:      "{{.Command}}: permission denied (file attributes are managed by server)",
+	msgSessionRefused:       "Service not available. Try again later",
+	msgStandby:              "Service is in standby. Try again later",
+	msgIntegrityMismatch:    "{{.Command}}: uploaded file does not match data received by server. Upload again",
+}
+
+// messageVars are variables available in message templates
+type messageVars struct {
+	Command    string
+	User       string
+	ClientAddr string
+	SessionID  uint64
+}
+
+// messageCatalog keep templates of proxy generated replies.
+// templates[""] is default messages and others are per locale messages.
+type messageCatalog struct {
+	templates map[string]map[string]*template.Template
+}
+
+// make catalog by default messages, welcome_message, unresolved_origin_message
+// and overrides in [messages] and [locales.*] tables.
+// unknown key or broken template is error.
+func newMessageCatalog(c *Config) (*messageCatalog, error) {
+	texts := map[string]string{}
+	for key, text := range defaultMessages {
+		texts[key] = text
+	}
+	texts[msgWelcome] = c.WelcomeMsg
+	texts[msgUnresolvedOrigin] = c.UnresolvedMsg
+
+	for key, text := range c.Messages {
+		if _, ok := texts[key]; !ok {
+			return nil, fmt.Errorf("configuration error: unknown message key %s", key)
+		}
+		texts[key] = text
+	}
+
+	m := &messageCatalog{templates: map[string]map[string]*template.Template{}}
+
+	var err error
+	if m.templates[""], err = parseMessages("", texts); err != nil {
+		return nil, err
+	}
+
+	for locale, localeTexts := range c.Locales {
+		if len(locale) == 0 {
+			return nil, fmt.Errorf("configuration error: empty locale name")
+		}
+		for key := range localeTexts {
+			if _, ok := texts[key]; !ok {
+				return nil, fmt.Errorf("configuration error: unknown message key %s in locale %s", key, locale)
+			}
+		}
+		if m.templates[locale], err = parseMessages(locale, localeTexts); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := m.templates[c.Locale]; !ok {
+		return nil, fmt.Errorf("configuration error: locale %s is not defined", c.Locale)
+	}
+
+	return m, nil
+}
+
+// parse message texts of one locale
+func parseMessages(locale string, texts map[string]string) (map[string]*template.Template, error) {
+	templates := map[string]*template.Template{}
+	for key, text := range texts {
+		name := key
+		if len(locale) > 0 {
+			name = locale + "." + key
+		}
+
+		t, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("configuration error: message %s is wrong: %s", name, err.Error())
+		}
+		// check variables in template
+		if err := t.Execute(&bytes.Buffer{}, messageVars{}); err != nil {
+			return nil, fmt.Errorf("configuration error: message %s is wrong: %s", name, err.Error())
+		}
+		templates[key] = t
+	}
+
+	return templates, nil
+}
+
+// return message text of key in locale. when locale does not have
+// the message, default message is used. multi-line text is allowed.
+func (m *messageCatalog) format(locale string, key string, vars messageVars) string {
+	t, ok := m.templates[locale][key]
+	if !ok {
+		if t, ok = m.templates[""][key]; !ok {
+			return key
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, vars); err != nil {
+		return defaultMessages[key]
+	}
+
+	return buf.String()
+}
+
+// make reply lines. if message has multiple lines, make multi-line reply
+// ex) "230-line1\r\n230 line2\r\n"
+func formatReply(code int, message string) string {
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(message, "\r\n", "\n"), "\n"), "\n")
+
+	var b strings.Builder
+	for i, line := range lines {
+		if i == len(lines)-1 {
+			fmt.Fprintf(&b, "%d %s\r\n", code, line)
+		} else {
+			fmt.Fprintf(&b, "%d-%s\r\n", code, line)
+		}
+	}
+
+	return b.String()
+}
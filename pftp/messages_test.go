@@ -0,0 +1,149 @@
+package pftp
+
+import "testing"
+
+func Test_messageCatalog_format(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages map[string]string
+		locales  map[string]map[string]string
+		locale   string
+		key      string
+		vars     messageVars
+		want     string
+	}{
+		{
+			name: "default",
+			key:  msgReadOnly,
+			vars: messageVars{Command: "STOR"},
+			want: "STOR: permission denied (read-only)",
+		},
+		{
+			name: "welcome_message_config",
+			key:  msgWelcome,
+			want: "FTP proxy ready",
+		},
+		{
+			name:     "override",
+			messages: map[string]string{msgMaxConnections: "{{.User}} from {{.ClientAddr}}: too many connections"},
+			key:      msgMaxConnections,
+			vars:     messageVars{User: "pftp", ClientAddr: "127.0.0.1:10000"},
+			want:     "pftp from 127.0.0.1:10000: too many connections",
+		},
+		{
+			name:     "override_welcome",
+			messages: map[string]string{msgWelcome: "Welcome to example.com\nsession {{.SessionID}}"},
+			key:      msgWelcome,
+			vars:     messageVars{SessionID: 3},
+			want:     "Welcome to example.com\nsession 3",
+		},
+		{
+			name:    "locale",
+			locales: map[string]map[string]string{"ja": {msgReadOnly: "{{.Command}}: 読み取り専用です"}},
+			locale:  "ja",
+			key:     msgReadOnly,
+			vars:    messageVars{Command: "DELE"},
+			want:    "DELE: 読み取り専用です",
+		},
+		{
+			name:    "locale_fallback_to_default",
+			locales: map[string]map[string]string{"ja": {msgReadOnly: "読み取り専用です"}},
+			locale:  "ja",
+			key:     msgLoginRequired,
+			want:    "Please login with USER and PASS",
+		},
+		{
+			name:    "unknown_locale",
+			locales: map[string]map[string]string{"ja": {msgLoginRequired: "ログインしてください"}},
+			locale:  "fr",
+			key:     msgLoginRequired,
+			want:    "Please login with USER and PASS",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultConfig()
+			c.Messages = tt.messages
+			c.Locales = tt.locales
+
+			m, err := newMessageCatalog(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.format(tt.locale, tt.key, tt.vars); got != tt.want {
+				t.Errorf("messageCatalog.format() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newMessageCatalog_error(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages map[string]string
+		locales  map[string]map[string]string
+		locale   string
+	}{
+		{
+			name:     "unknown_key",
+			messages: map[string]string{"no_such_message": "hello"},
+		},
+		{
+			name:     "broken_template",
+			messages: map[string]string{msgWelcome: "hello {{.User"},
+		},
+		{
+			name:     "unknown_variable",
+			messages: map[string]string{msgWelcome: "hello {{.Password}}"},
+		},
+		{
+			name:    "unknown_key_in_locale",
+			locales: map[string]map[string]string{"ja": {"no_such_message": "こんにちは"}},
+		},
+		{
+			name:   "undefined_locale",
+			locale: "ja",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultConfig()
+			c.Messages = tt.messages
+			c.Locales = tt.locales
+			c.Locale = tt.locale
+
+			if _, err := newMessageCatalog(c); err == nil {
+				t.Errorf("newMessageCatalog() error = nil, want error")
+			}
+		})
+	}
+}
+
+func Test_formatReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    int
+		message string
+		want    string
+	}{
+		{
+			name:    "single_line",
+			code:    220,
+			message: "FTP proxy ready",
+			want:    "220 FTP proxy ready\r\n",
+		},
+		{
+			name:    "multi_line",
+			code:    530,
+			message: "Access denied\r\nContact support\n",
+			want:    "530-Access denied\r\n530 Contact support\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatReply(tt.code, tt.message); got != tt.want {
+				t.Errorf("formatReply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
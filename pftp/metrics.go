@@ -0,0 +1,240 @@
+package pftp
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// label value of users over metrics_max_users
+const otherUsersLabel = "other"
+
+// metrics keep counters and gauges of server exposed by admin API /metrics
+// in Prometheus text format. nil metrics ignore all updates.
+// origin and user labels are dropped or limited by config to keep cardinality low.
+type metrics struct {
+	mutex            sync.Mutex
+	families         map[string]*metricFamily
+	aggregateOrigins bool
+	userLabels       bool
+	maxUsers         int
+	users            map[string]bool // users having own label
+	serverLabels     []string        // labels of all metrics, ex. server of ServerGroup
+}
+
+type metricFamily struct {
+	help       string
+	kind       string                // counter, gauge or histogram
+	values     map[string]float64    // rendered labels -> value
+	buckets    []float64             // upper bounds of histogram buckets
+	histograms map[string]*histogram // rendered labels -> histogram
+}
+
+// histogram is observations counted by bucket. counts[i] is observations
+// less than or equal to buckets[i] and not cumulative.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(buckets []float64, value float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets))
+	}
+	for i, le := range buckets {
+		if value <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func newMetrics(c *Config) *metrics {
+	return &metrics{
+		families:         map[string]*metricFamily{},
+		aggregateOrigins: c.MetricsAggregateOrigins,
+		userLabels:       c.MetricsUserLabels,
+		maxUsers:         c.MetricsMaxUsers,
+		users:            map[string]bool{},
+	}
+}
+
+// add 1 to counter. labels are name and value pairs.
+// ex) m.inc("pftp_tls_handshake_errors_total", "help", "reason", "no_shared_cipher")
+func (m *metrics) inc(name string, help string, labels ...string) {
+	m.add(name, help, 1, labels...)
+}
+
+// add value to counter
+func (m *metrics) add(name string, help string, value float64, labels ...string) {
+	m.update(name, help, "counter", labels, func(v float64) float64 { return v + value })
+}
+
+// set value of gauge
+func (m *metrics) set(name string, help string, value float64, labels ...string) {
+	m.update(name, help, "gauge", labels, func(float64) float64 { return value })
+}
+
+// add value to histogram of buckets. buckets of first observation are kept.
+func (m *metrics) observe(name string, help string, buckets []float64, value float64, labels ...string) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	family, ok := m.families[name]
+	if !ok {
+		family = &metricFamily{help: help, kind: "histogram", buckets: buckets, histograms: map[string]*histogram{}}
+		m.families[name] = family
+	}
+	l := formatLabels(m.limitLabels(labels))
+	h, ok := family.histograms[l]
+	if !ok {
+		h = &histogram{}
+		family.histograms[l] = h
+	}
+	h.observe(family.buckets, value)
+}
+
+func (m *metrics) update(name string, help string, kind string, labels []string, f func(float64) float64) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	family, ok := m.families[name]
+	if !ok {
+		family = &metricFamily{help: help, kind: kind, values: map[string]float64{}}
+		m.families[name] = family
+	}
+	l := formatLabels(m.limitLabels(labels))
+	family.values[l] = f(family.values[l])
+}
+
+// drop origin and user labels disabled by config. users after first
+// maxUsers users are counted as "other".
+func (m *metrics) limitLabels(labels []string) []string {
+	limited := append(make([]string, 0, len(m.serverLabels)+len(labels)), m.serverLabels...)
+	for i := 0; i+1 < len(labels); i += 2 {
+		name, value := labels[i], labels[i+1]
+		switch name {
+		case "origin":
+			if m.aggregateOrigins {
+				continue
+			}
+		case "user":
+			if !m.userLabels {
+				continue
+			}
+			if m.maxUsers > 0 && !m.users[value] {
+				if len(m.users) >= m.maxUsers {
+					value = otherUsersLabel
+				} else {
+					m.users[value] = true
+				}
+			}
+		}
+		limited = append(limited, name, value)
+	}
+
+	return limited
+}
+
+// ex) ["reason", "timeout"] -> `{reason="timeout"}`
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// write all metrics ordered by name and labels
+func (m *metrics) write(w io.Writer) {
+	writeMetrics(w, m)
+}
+
+// write metrics of servers ordered by name and labels. families of same name
+// share HELP and TYPE, so each metrics should have own server labels.
+func writeMetrics(w io.Writer, ms ...*metrics) {
+	families := map[string][]*metricFamily{}
+	for _, m := range ms {
+		if m == nil {
+			continue
+		}
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		for name, f := range m.families {
+			families[name] = append(families[name], f)
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		first := families[name][0]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, first.help, name, first.kind)
+		for _, f := range families[name] {
+			if f.kind == "histogram" {
+				f.writeHistograms(w, name)
+				continue
+			}
+
+			labels := make([]string, 0, len(f.values))
+			for l := range f.values {
+				labels = append(labels, l)
+			}
+			sort.Strings(labels)
+			for _, l := range labels {
+				fmt.Fprintf(w, "%s%s %g\n", name, l, f.values[l])
+			}
+		}
+	}
+}
+
+// write cumulative buckets, sum and count of histograms ordered by labels
+func (f *metricFamily) writeHistograms(w io.Writer, name string) {
+	labels := make([]string, 0, len(f.histograms))
+	for l := range f.histograms {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+
+	for _, l := range labels {
+		h := f.histograms[l]
+		// le label is added to labels of histogram
+		prefix := "{"
+		if len(l) > 0 {
+			prefix = strings.TrimSuffix(l, "}") + ","
+		}
+		var cumulative uint64
+		for i, le := range f.buckets {
+			if h.counts != nil {
+				cumulative += h.counts[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%sle=\"%g\"} %d\n", name, prefix, le, cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%sle=\"+Inf\"} %d\n", name, prefix, h.count)
+		fmt.Fprintf(w, "%s_sum%s %g\n", name, l, h.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", name, l, h.count)
+	}
+}
@@ -0,0 +1,63 @@
+package pftp
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_metrics_limitLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		want   []string
+	}{
+		{
+			name:   "default",
+			config: &Config{MetricsMaxUsers: 2},
+			want: []string{
+				`pftp_sessions_total{origin="a:21"} 3`,
+				`pftp_sessions_total{origin="b:21"} 1`,
+			},
+		},
+		{
+			name:   "aggregate_origins",
+			config: &Config{MetricsAggregateOrigins: true},
+			want: []string{
+				`pftp_sessions_total 4`,
+			},
+		},
+		{
+			name:   "user_labels",
+			config: &Config{MetricsUserLabels: true, MetricsMaxUsers: 2},
+			want: []string{
+				`pftp_sessions_total{origin="a:21",user="alice"} 2`,
+				`pftp_sessions_total{origin="a:21",user="other"} 1`,
+				`pftp_sessions_total{origin="b:21",user="bob"} 1`,
+			},
+		},
+		{
+			name:   "unlimited_users",
+			config: &Config{MetricsUserLabels: true, MetricsAggregateOrigins: true},
+			want: []string{
+				`pftp_sessions_total{user="alice"} 2`,
+				`pftp_sessions_total{user="bob"} 1`,
+				`pftp_sessions_total{user="carol"} 1`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMetrics(tt.config)
+			for _, s := range [][]string{{"a:21", "alice"}, {"b:21", "bob"}, {"a:21", "carol"}, {"a:21", "alice"}} {
+				m.inc("pftp_sessions_total", "Count of logged in sessions.", "origin", s[0], "user", s[1])
+			}
+
+			var b strings.Builder
+			m.write(&b)
+			got := strings.Split(strings.TrimSpace(b.String()), "\n")[2:]
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("metrics = %s, want %s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
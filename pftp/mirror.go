@@ -0,0 +1,339 @@
+package pftp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+const (
+	// number of buffered chunks per mirror. when mirror destination is
+	// slower than origin and buffer is full, mirror is abandoned.
+	mirrorBufferChunks = 256
+)
+
+// UploadMirror open secondary destination of uploaded file.
+// command is one of STOR, STOU or APPE and path is parameter sent by client,
+// resolved to absolute path when directory of origin is known.
+// pftp mirrors to plain FTP server only. implement it to mirror uploads to
+// other storage like S3 bucket or FTP over TLS.
+// uploads resumed by REST are not mirrored.
+type UploadMirror interface {
+	OpenUpload(ctx *Context, command string, path string) (io.WriteCloser, error)
+}
+
+// errMirrorResumed is reported for upload resumed by REST, which is not mirrored
+var errMirrorResumed = errors.New("upload resumed by REST is not mirrored")
+
+// UploadAborter can be implemented by writer of UploadMirror. Abort is called
+// instead of Close when original upload or mirror failed, so partial file is
+// not committed as complete. writer without it is closed.
+type UploadAborter interface {
+	Abort() error
+}
+
+// ftpUploadMirror mirror uploads to other FTP server by new session per upload
+type ftpUploadMirror struct {
+	addr string
+	user string
+	pass string
+}
+
+func newFTPUploadMirror(c *Config) UploadMirror {
+	if len(c.MirrorUploadAddr) == 0 {
+		return nil
+	}
+
+	return &ftpUploadMirror{
+		addr: c.MirrorUploadAddr,
+		user: c.MirrorUploadUser,
+		pass: c.MirrorUploadPass,
+	}
+}
+
+// OpenUpload login to mirror FTP server and open passive data connection
+func (m *ftpUploadMirror) OpenUpload(ctx *Context, command string, path string) (io.WriteCloser, error) {
+	network, addr := splitNetworkAddr(m.addr)
+	conn, err := net.DialTimeout(network, addr, time.Duration(connectionTimeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(time.Duration(connectionTimeout) * time.Second))
+
+	reader := bufio.NewReader(conn)
+
+	fail := func(err error) (io.WriteCloser, error) {
+		conn.Close()
+		return nil, err
+	}
+
+	if _, err := readMirrorReply(reader, "220"); err != nil {
+		return fail(err)
+	}
+
+	steps := []struct {
+		line   string
+		expect string
+	}{
+		{"USER " + m.user, "331"},
+		{"PASS " + m.pass, "230"},
+		{"TYPE I", "200"},
+		{"PASV", "227"},
+	}
+
+	var pasv string
+	loggedIn := false
+	for _, step := range steps {
+		if loggedIn && step.expect == "230" {
+			continue
+		}
+		if _, err := conn.Write([]byte(step.line + "\r\n")); err != nil {
+			return fail(err)
+		}
+		res, err := readMirrorReply(reader, step.expect)
+		if err != nil {
+			// some servers allow login without password
+			if step.expect == "331" && strings.HasPrefix(res, "230") {
+				loggedIn = true
+				continue
+			}
+			return fail(err)
+		}
+		pasv = res
+	}
+
+	startIndex := strings.Index(pasv, "(")
+	endIndex := strings.LastIndex(pasv, ")")
+	if startIndex == -1 || endIndex == -1 {
+		return fail(errors.New("invalid mirror data address"))
+	}
+
+	ip, port, err := parseLineToAddr(pasv[startIndex+1 : endIndex])
+	if err != nil {
+		return fail(err)
+	}
+
+	// if received ip is not public IP, use control connection IP
+	if !isPublicIP(net.ParseIP(ip)) {
+		ip = addrIP(conn.RemoteAddr())
+	}
+
+	dataConn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, port), time.Duration(connectionTimeout)*time.Second)
+	if err != nil {
+		return fail(fmt.Errorf("cannot connect to mirror data address: %s", err.Error()))
+	}
+
+	if _, err := conn.Write([]byte(command + " " + path + "\r\n")); err != nil {
+		dataConn.Close()
+		return fail(err)
+	}
+	if _, err := readMirrorReply(reader, "1"); err != nil {
+		dataConn.Close()
+		return fail(err)
+	}
+
+	// no deadline during data transfer
+	conn.SetDeadline(time.Time{})
+
+	return &ftpMirrorUpload{control: conn, reader: reader, data: dataConn, command: command, path: path}, nil
+}
+
+// ftpMirrorUpload is data connection of mirror upload
+type ftpMirrorUpload struct {
+	control net.Conn
+	reader  *bufio.Reader
+	data    net.Conn
+	command string
+	path    string
+}
+
+func (u *ftpMirrorUpload) Write(b []byte) (int, error) {
+	return u.data.Write(b)
+}
+
+// Close data connection and wait transfer complete reply
+func (u *ftpMirrorUpload) Close() error {
+	defer u.control.Close()
+
+	if err := u.data.Close(); err != nil {
+		return err
+	}
+
+	u.control.SetDeadline(time.Now().Add(time.Duration(connectionTimeout) * time.Second))
+	if _, err := readMirrorReply(u.reader, "226"); err != nil {
+		return err
+	}
+	u.control.Write([]byte("QUIT\r\n"))
+
+	return nil
+}
+
+// Abort stop mirror upload by ABOR and delete partial file of STOR.
+// file of APPE is kept because it existed before upload.
+func (u *ftpMirrorUpload) Abort() error {
+	defer u.control.Close()
+
+	u.control.SetDeadline(time.Now().Add(time.Duration(connectionTimeout) * time.Second))
+	if _, err := u.control.Write([]byte("ABOR\r\n")); err != nil {
+		u.data.Close()
+		return err
+	}
+	u.data.Close()
+
+	// transfer reply (426 or 226) is followed by reply of ABOR
+	if _, err := readMirrorReply(u.reader, ""); err != nil {
+		return err
+	}
+	if line, err := readMirrorReply(u.reader, ""); err != nil || !strings.HasPrefix(line, "2") {
+		if err == nil {
+			err = fmt.Errorf("unexpected mirror reply: %s", strings.TrimSuffix(line, "\r\n"))
+		}
+		return err
+	}
+
+	if u.command == "STOR" {
+		if _, err := u.control.Write([]byte("DELE " + u.path + "\r\n")); err != nil {
+			return err
+		}
+		if _, err := readMirrorReply(u.reader, "2"); err != nil {
+			return err
+		}
+	}
+	u.control.Write([]byte("QUIT\r\n"))
+
+	return nil
+}
+
+// read reply from mirror server and check it starts with expected code
+func readMirrorReply(reader *bufio.Reader, expect string) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	// skip multi-line reply until last line
+	if len(line) >= 4 && line[3] == '-' {
+		code := line[:3]
+		for {
+			l, err := reader.ReadString('\n')
+			if err != nil {
+				return "", err
+			}
+			if len(l) >= 4 && l[:3] == code && l[3] == ' ' {
+				line = l
+				break
+			}
+		}
+	}
+
+	if !strings.HasPrefix(line, expect) {
+		return line, fmt.Errorf("unexpected mirror reply: %s", strings.TrimSuffix(line, "\r\n"))
+	}
+
+	return line, nil
+}
+
+// mirrorWriter copy data to mirror destination asynchronously.
+// mirror failure or slowness never affects original data transfer.
+type mirrorWriter struct {
+	chunks    chan []byte
+	failed    *abool.AtomicBool
+	aborted   *abool.AtomicBool
+	closeOnce sync.Once
+	log       *logger
+	onError   func(error)
+}
+
+// open mirror destination in background and start copy goroutine
+func newMirrorWriter(open func() (io.WriteCloser, error), log *logger, onError func(error)) *mirrorWriter {
+	m := &mirrorWriter{
+		chunks:  make(chan []byte, mirrorBufferChunks),
+		failed:  abool.New(),
+		aborted: abool.New(),
+		log:     log,
+		onError: onError,
+	}
+
+	go func() {
+		w, err := open()
+		if err != nil {
+			m.fail(err)
+			for range m.chunks {
+			}
+			return
+		}
+
+		for b := range m.chunks {
+			if m.failed.IsSet() {
+				continue
+			}
+			if _, err := w.Write(b); err != nil {
+				m.fail(err)
+			}
+		}
+
+		if m.failed.IsSet() || m.aborted.IsSet() {
+			if a, ok := w.(UploadAborter); ok {
+				if err := a.Abort(); err != nil {
+					m.log.err("cannot abort upload mirror: %s", err.Error())
+				}
+				return
+			}
+		}
+		if err := w.Close(); err != nil && !m.failed.IsSet() {
+			m.fail(err)
+		}
+	}()
+
+	return m
+}
+
+func (m *mirrorWriter) fail(err error) {
+	if m.failed.SetToIf(false, true) {
+		m.log.err("upload mirror failed: %s", err.Error())
+		if m.onError != nil {
+			m.onError(err)
+		}
+	}
+}
+
+func (m *mirrorWriter) write(b []byte) {
+	if m == nil || m.failed.IsSet() {
+		return
+	}
+
+	chunk := make([]byte, len(b))
+	copy(chunk, b)
+
+	select {
+	case m.chunks <- chunk:
+	default:
+		m.fail(errors.New("mirror destination is too slow"))
+	}
+}
+
+// end mirror after original upload completed
+func (m *mirrorWriter) close() {
+	if m == nil {
+		return
+	}
+
+	m.closeOnce.Do(func() { close(m.chunks) })
+}
+
+// end mirror after original upload failed
+func (m *mirrorWriter) abort() {
+	if m == nil {
+		return
+	}
+
+	m.aborted.Set()
+	m.close()
+}
@@ -0,0 +1,218 @@
+package pftp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+	closed chan struct{}
+}
+
+func (b *bufferCloser) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func Test_mirrorWriter(t *testing.T) {
+	buf := &bufferCloser{closed: make(chan struct{})}
+	m := newMirrorWriter(func() (io.WriteCloser, error) { return buf, nil }, &logger{}, func(err error) {
+		t.Errorf("mirrorWriter got error: %v", err)
+	})
+
+	m.write([]byte("hello "))
+	m.write([]byte("mirror"))
+	m.close()
+	<-buf.closed
+
+	if got := buf.String(); got != "hello mirror" {
+		t.Errorf("mirrorWriter wrote %q, want %q", got, "hello mirror")
+	}
+}
+
+func Test_mirrorWriter_open_error(t *testing.T) {
+	errc := make(chan error, 1)
+	m := newMirrorWriter(func() (io.WriteCloser, error) {
+		return nil, errors.New("cannot open")
+	}, &logger{}, func(err error) { errc <- err })
+
+	m.write([]byte("data"))
+	m.close()
+
+	if err := <-errc; err.Error() != "cannot open" {
+		t.Errorf("mirrorWriter error = %v, want cannot open", err)
+	}
+}
+
+type abortBuffer struct {
+	bufferCloser
+	aborted chan struct{}
+}
+
+func (b *abortBuffer) Abort() error {
+	close(b.aborted)
+	return nil
+}
+
+func Test_mirrorWriter_abort(t *testing.T) {
+	buf := &abortBuffer{bufferCloser: bufferCloser{closed: make(chan struct{})}, aborted: make(chan struct{})}
+	m := newMirrorWriter(func() (io.WriteCloser, error) { return buf, nil }, &logger{}, nil)
+
+	m.write([]byte("partial"))
+	m.abort()
+
+	select {
+	case <-buf.aborted:
+	case <-buf.closed:
+		t.Error("aborted mirror is closed as complete")
+	}
+}
+
+func Test_ftpMirrorUpload_Abort(t *testing.T) {
+	control, server := net.Pipe()
+	data, dataPeer := net.Pipe()
+	defer server.Close()
+	defer dataPeer.Close()
+
+	lines := make(chan string, 3)
+	go func() {
+		r := bufio.NewReader(server)
+		for _, reply := range []string{"426 Transfer aborted.\r\n226 Abort successful.\r\n", "250 Deleted.\r\n", ""} {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- line
+			server.Write([]byte(reply))
+		}
+	}()
+
+	u := &ftpMirrorUpload{control: control, reader: bufio.NewReader(control), data: data, command: "STOR", path: "/pub/a.txt"}
+	if err := u.Abort(); err != nil {
+		t.Fatalf("Abort() error = %v", err)
+	}
+	for _, want := range []string{"ABOR\r\n", "DELE /pub/a.txt\r\n", "QUIT\r\n"} {
+		if got := <-lines; got != want {
+			t.Errorf("sent %q, want %q", got, want)
+		}
+	}
+}
+
+type mirrorFunc func(ctx *Context, command string, path string) (io.WriteCloser, error)
+
+func (f mirrorFunc) OpenUpload(ctx *Context, command string, path string) (io.WriteCloser, error) {
+	return f(ctx, command, path)
+}
+
+func Test_ftpUploadMirror_OpenUpload_without_password(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	dataListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dataListener.Close()
+	_, port, _ := net.SplitHostPort(dataListener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	commands := make(chan string, 10)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("220 ready\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			commands <- line
+			switch getCommand(line)[0] {
+			case "USER":
+				conn.Write([]byte("230 Logged in without password.\r\n"))
+			case "PASS":
+				conn.Write([]byte("503 Already logged in.\r\n"))
+			case "TYPE":
+				conn.Write([]byte("200 Binary.\r\n"))
+			case "PASV":
+				conn.Write([]byte(fmt.Sprintf("227 Entering Passive Mode (127,0,0,1,%d,%d).\r\n", p/256, p%256)))
+			case "STOR":
+				conn.Write([]byte("150 Ok to send data.\r\n"))
+			}
+		}
+	}()
+
+	m := &ftpUploadMirror{addr: l.Addr().String(), user: "anonymous", pass: "guest"}
+	w, err := m.OpenUpload(&Context{}, "STOR", "/a.txt")
+	if err != nil {
+		t.Fatalf("ftpUploadMirror.OpenUpload() error = %v", err)
+	}
+	w.(*ftpMirrorUpload).control.Close()
+	w.(*ftpMirrorUpload).data.Close()
+
+	for _, want := range []string{"USER anonymous\r\n", "TYPE I\r\n", "PASV\r\n", "STOR /a.txt\r\n"} {
+		if got := <-commands; got != want {
+			t.Errorf("sent %q, want %q", got, want)
+		}
+	}
+}
+
+func Test_clientHandler_attachUploadMirrors_rest(t *testing.T) {
+	tests := []struct {
+		name       string
+		rest       int64
+		wantMirror bool
+	}{
+		{name: "stor", wantMirror: true},
+		{name: "resumed_stor", rest: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []Event
+			bus := newEventBus()
+			bus.observe(func(e Event) { events = append(events, e) })
+			mirror := mirrorFunc(func(ctx *Context, command string, path string) (io.WriteCloser, error) {
+				return &bufferCloser{closed: make(chan struct{})}, nil
+			})
+			c := &clientHandler{
+				config:  &Config{},
+				context: &Context{UploadMirror: mirror},
+				proxy:   &proxyServer{cwd: "/pub"},
+				events:  bus,
+				log:     &logger{},
+				command: "STOR",
+				param:   "a.txt",
+			}
+
+			d := &dataHandler{}
+			c.attachUploadMirrors(d, tt.rest)
+			for _, m := range d.mirrors {
+				m.close()
+			}
+			if got := len(d.mirrors) == 1; got != tt.wantMirror {
+				t.Errorf("upload is mirrored = %v, want %v", got, tt.wantMirror)
+			}
+			if !tt.wantMirror {
+				if len(events) != 1 {
+					t.Fatalf("events = %v, want UploadMirrorErrorEvent", events)
+				}
+				if e, ok := events[0].(*UploadMirrorErrorEvent); !ok || e.Path != "/pub/a.txt" {
+					t.Errorf("event = %+v, want UploadMirrorErrorEvent of /pub/a.txt", events[0])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package pftp
+
+import (
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// modeZ is MODE Z (deflate) state of client and origin legs of session.
+// each leg is compressed separately and proxy inflates and deflates data
+// between them.
+type modeZ struct {
+	client     bool   // client sent MODE Z
+	origin     bool   // origin accepted MODE Z
+	originAddr string // origin MODE Z was sent to
+}
+
+// handle MODE S and Z by proxy when compression is decided per leg.
+// other modes are sent to origin.
+func (c *clientHandler) handleMODE() *result {
+	if c.config.DataChanProxy && (c.context.ClientModeZ || c.context.OriginModeZ) {
+		switch strings.ToUpper(strings.TrimSpace(c.param)) {
+		case "S":
+			c.modeZ.client = false
+			return &result{
+				code: 200,
+				msg:  "Mode set to S.",
+			}
+		case "Z":
+			if !c.context.ClientModeZ {
+				return &result{
+					code: 504,
+					msg:  c.message(msgUnsupportedParameter),
+				}
+			}
+			c.modeZ.client = true
+			return &result{
+				code: 200,
+				msg:  "Mode set to Z.",
+			}
+		}
+	}
+
+	if err := c.proxy.sendToOrigin(c.line); err != nil {
+		return &result{
+			code: 500,
+			msg:  fmt.Sprintf("Internal error: %s", err),
+		}
+	}
+
+	return nil
+}
+
+// advertise MODE Z by client_mode_z when MODE is answered by proxy
+func (c *clientHandler) handleFEAT() *result {
+	answered := c.config.DataChanProxy && (c.context.ClientModeZ || c.context.OriginModeZ)
+	c.proxy.setModeZFeature(answered, c.context.ClientModeZ)
+
+	if err := c.proxy.sendToOrigin(c.line); err != nil {
+		return &result{
+			code: 500,
+			msg:  fmt.Sprintf("Internal error: %s", err),
+		}
+	}
+
+	return nil
+}
+
+// send MODE Z to origin once per origin before first data connection.
+// it is not sent between REST and transfer command.
+func (c *clientHandler) negotiateOriginModeZ() {
+	if !c.context.OriginModeZ || c.modeZ.originAddr == c.proxy.originAddr {
+		return
+	}
+	c.modeZ.originAddr = c.proxy.originAddr
+	c.modeZ.origin = false
+
+	reply, err := c.proxy.internalCommand("MODE Z\r\n")
+	if err != nil {
+		c.log.err("cannot set MODE Z of origin: %s", err.Error())
+		return
+	}
+	if !strings.HasPrefix(reply, "200") {
+		c.log.info("origin refused MODE Z: %s", strings.TrimSpace(reply))
+		return
+	}
+	c.modeZ.origin = true
+}
+
+func (s *proxyServer) setModeZFeature(answered bool, advertised bool) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.modeZAnswered, s.modeZAdvertised = answered, advertised
+}
+
+// add or remove MODE Z of FEAT reply when MODE is answered by proxy
+func (s *proxyServer) modeZFeature(reply string, features []string) string {
+	s.stateMutex.Lock()
+	answered, advertised := s.modeZAnswered, s.modeZAdvertised
+	s.stateMutex.Unlock()
+	if !answered {
+		return reply
+	}
+
+	origin := (&originCapabilities{features: features}).supports("MODE Z")
+	switch {
+	case advertised && !origin:
+		return addFeature(reply, "MODE Z")
+	case !advertised && origin:
+		return removeFeature(reply, "MODE Z")
+	}
+
+	return reply
+}
+
+// remove feature line from FEAT reply
+func removeFeature(reply string, feature string) string {
+	lines := strings.SplitAfter(reply, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if strings.HasPrefix(line, " ") && strings.EqualFold(strings.Join(strings.Fields(line), " "), feature) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	return strings.Join(kept, "")
+}
+
+// wrap legs of data connection compressed by MODE Z. data is passed as is
+// when both legs are compressed.
+func (d *dataHandler) wrapModeZ(direction string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.clientModeZ && !d.originModeZ && d.clientConn.dataConn != nil {
+		d.clientConn.dataConn = newDeflateConn(d.clientConn.dataConn, direction == downloadStream)
+	}
+	if d.originConn.dataConn != nil {
+		d.originConn.dataConn = d.originLeg(d.originConn.dataConn, direction == uploadStream)
+	}
+}
+
+// return origin data connection inflated and deflated by MODE Z of origin leg
+func (d *dataHandler) originLeg(conn net.Conn, writes bool) net.Conn {
+	if !d.originModeZ || d.clientModeZ {
+		return conn
+	}
+
+	return newDeflateConn(conn, writes)
+}
+
+// deflateConn is data connection in MODE Z. read data is inflated and
+// written data is deflated as zlib stream.
+type deflateConn struct {
+	net.Conn
+	reader io.ReadCloser
+	writer *zlib.Writer
+	ended  bool
+}
+
+// writes is true when data is sent to conn. empty stream is sent for empty
+// file then. read only leg sends nothing.
+func newDeflateConn(conn net.Conn, writes bool) *deflateConn {
+	c := &deflateConn{Conn: conn}
+	if writes {
+		c.writer = zlib.NewWriter(conn)
+	}
+
+	return c
+}
+
+func (c *deflateConn) Read(p []byte) (int, error) {
+	if c.reader == nil {
+		r, err := zlib.NewReader(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		c.reader = r
+	}
+
+	return c.reader.Read(p)
+}
+
+func (c *deflateConn) Write(p []byte) (int, error) {
+	if c.writer == nil {
+		c.writer = zlib.NewWriter(c.Conn)
+	}
+
+	return c.writer.Write(p)
+}
+
+// end zlib stream
+func (c *deflateConn) end() error {
+	if c.writer == nil || c.ended {
+		return nil
+	}
+	c.ended = true
+
+	return c.writer.Close()
+}
+
+// end zlib stream and send EOF
+func (c *deflateConn) CloseWrite() error {
+	if err := c.end(); err != nil {
+		return err
+	}
+
+	return sendEOF(c.Conn)
+}
+
+// close without end of stream, so aborted transfer is not taken as whole file
+func (c *deflateConn) Close() error {
+	return c.Conn.Close()
+}
@@ -0,0 +1,181 @@
+package pftp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+func Test_dataHandler_wrapModeZ(t *testing.T) {
+	content := bytes.Repeat([]byte("compressible data "), 5000)
+	deflate := func(b []byte) []byte {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(b)
+		w.Close()
+		return buf.Bytes()
+	}
+	inflate := func(r io.Reader) []byte {
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil
+		}
+		b, _ := io.ReadAll(zr)
+		return b
+	}
+
+	tests := []struct {
+		name        string
+		clientModeZ bool
+		originModeZ bool
+		sent        []byte
+		read        func(io.Reader) []byte
+	}{
+		{name: "client_leg", clientModeZ: true, sent: content, read: inflate},
+		{name: "origin_leg", originModeZ: true, sent: deflate(content), read: func(r io.Reader) []byte { b, _ := io.ReadAll(r); return b }},
+		{name: "both_legs", clientModeZ: true, originModeZ: true, sent: deflate(content), read: inflate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConn, clientPeer := net.Pipe()
+			defer clientPeer.Close()
+			originConn, originPeer := net.Pipe()
+
+			d := &dataHandler{
+				config:      &Config{},
+				log:         &logger{},
+				mutex:       &sync.Mutex{},
+				clientConn:  connector{dataConn: clientConn},
+				originConn:  connector{dataConn: originConn},
+				clientModeZ: tt.clientModeZ,
+				originModeZ: tt.originModeZ,
+			}
+			d.wrapModeZ(downloadStream)
+
+			go func() {
+				originPeer.Write(tt.sent)
+				originPeer.Close()
+			}()
+			received := make(chan []byte)
+			go func() { received <- tt.read(clientPeer) }()
+
+			go func() {
+				d.copyPackets(d.clientConn.dataConn, d.originConn.dataConn, 10, nil)
+				d.clientConn.dataConn.Close()
+			}()
+
+			select {
+			case got := <-received:
+				if !bytes.Equal(got, content) {
+					t.Errorf("client received %d bytes, want %d", len(got), len(content))
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatal("client did not receive content")
+			}
+		})
+	}
+}
+
+func Test_proxyServer_modeZFeature(t *testing.T) {
+	tests := []struct {
+		name       string
+		answered   bool
+		advertised bool
+		reply      string
+		want       string
+	}{
+		{
+			name:       "add",
+			answered:   true,
+			advertised: true,
+			reply:      "211-Features:\r\n EPSV\r\n211 End\r\n",
+			want:       "211-Features:\r\n EPSV\r\n MODE Z\r\n211 End\r\n",
+		},
+		{
+			name:     "remove",
+			answered: true,
+			reply:    "211-Features:\r\n MODE Z\r\n EPSV\r\n211 End\r\n",
+			want:     "211-Features:\r\n EPSV\r\n211 End\r\n",
+		},
+		{
+			name:  "not_answered",
+			reply: "211-Features:\r\n MODE Z\r\n211 End\r\n",
+			want:  "211-Features:\r\n MODE Z\r\n211 End\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &proxyServer{}
+			s.setModeZFeature(tt.answered, tt.advertised)
+			if got := s.modeZFeature(tt.reply, parseFeatures(tt.reply)); got != tt.want {
+				t.Errorf("proxyServer.modeZFeature() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_handleMODE(t *testing.T) {
+	tests := []struct {
+		name        string
+		context     *Context
+		param       string
+		wantCode    int
+		wantModeZ   bool
+		wantForward bool
+	}{
+		{name: "client_mode_z", context: &Context{ClientModeZ: true}, param: "Z", wantCode: 200, wantModeZ: true},
+		{name: "stream", context: &Context{OriginModeZ: true}, param: "S", wantCode: 200},
+		{name: "client_leg_not_compressed", context: &Context{OriginModeZ: true}, param: "Z", wantCode: 504},
+		{name: "disabled", context: &Context{}, param: "Z", wantForward: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originConn, originPeer := net.Pipe()
+			defer originConn.Close()
+			defer originPeer.Close()
+
+			c := &clientHandler{
+				config:  &Config{DataChanProxy: true},
+				log:     &logger{},
+				context: tt.context,
+				command: "MODE",
+				param:   tt.param,
+				line:    "MODE " + tt.param + "\r\n",
+				proxy: &proxyServer{
+					config:         &Config{},
+					log:            &logger{},
+					inDataTransfer: abool.New(),
+					originWriter:   bufio.NewWriter(originConn),
+					mutex:          &sync.Mutex{},
+				},
+			}
+			forwarded := make(chan string, 1)
+			go func() {
+				b := make([]byte, 64)
+				n, _ := originPeer.Read(b)
+				forwarded <- string(b[:n])
+			}()
+
+			res := c.handleMODE()
+			if tt.wantForward {
+				if res != nil {
+					t.Fatalf("handleMODE() = %+v, want forwarded", res)
+				}
+				if got := <-forwarded; got != c.line {
+					t.Errorf("forwarded %q, want %q", got, c.line)
+				}
+				return
+			}
+			if res == nil || res.code != tt.wantCode || c.modeZ.client != tt.wantModeZ {
+				t.Errorf("handleMODE() = %+v, modeZ %v, want %d, %v", res, c.modeZ.client, tt.wantCode, tt.wantModeZ)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package pftp
+
+import (
+	"strings"
+)
+
+// return true when administrative notices can be added to reply of code.
+// replies whose text is parsed by clients (PASV, PWD, SIZE, FEAT ...) are skipped.
+func noticeReplyCode(code string) bool {
+	switch code {
+	case "200", "226", "230", "250":
+		return true
+	}
+
+	return false
+}
+
+// add notices as leading lines of multi-line reply
+// ex) "250 CWD ok\r\n" -> "250-maintenance in 10 minutes\r\n250 CWD ok\r\n"
+func addNotices(reply string, code string, notices []string) string {
+	var b strings.Builder
+	for _, notice := range notices {
+		for _, line := range strings.Split(strings.ReplaceAll(notice, "\r\n", "\n"), "\n") {
+			b.WriteString(code + "-" + line + "\r\n")
+		}
+	}
+
+	return b.String() + reply
+}
+
+// queue notice sent with next reply to client
+func (c *clientHandler) notify(message string) {
+	c.summaryMutex.Lock()
+	defer c.summaryMutex.Unlock()
+
+	c.notices = append(c.notices, message)
+}
+
+// return queued notices and clear them
+func (c *clientHandler) takeNotices() []string {
+	c.summaryMutex.Lock()
+	defer c.summaryMutex.Unlock()
+
+	notices := c.notices
+	c.notices = nil
+
+	return notices
+}
+
+// add queued notices to reply when reply can carry them
+func (c *clientHandler) withNotices(reply string) string {
+	code := replyCode(reply)
+	if !noticeReplyCode(code) || strings.HasPrefix(reply, code+"-") {
+		return reply
+	}
+
+	if notices := c.takeNotices(); len(notices) > 0 {
+		return addNotices(reply, code, notices)
+	}
+
+	return reply
+}
+
+// queue notice to sessions of ids, or all sessions when ids is empty.
+// return number of notified sessions.
+func (r *sessionRegistry) notify(message string, ids []uint64) int {
+	if r == nil {
+		return 0
+	}
+
+	r.mutex.Lock()
+	clients := []*clientHandler{}
+	if len(ids) == 0 {
+		for _, c := range r.clients {
+			clients = append(clients, c)
+		}
+	} else {
+		for _, id := range ids {
+			if c, ok := r.clients[id]; ok {
+				clients = append(clients, c)
+			}
+		}
+	}
+	r.mutex.Unlock()
+
+	for _, c := range clients {
+		c.notify(message)
+	}
+
+	return len(clients)
+}
+
+// Notify queue administrative notice to connected sessions of ids, or all
+// sessions when ids is omitted. notice is sent as leading lines of next
+// 200, 226, 230 or 250 reply of each session. return number of notified sessions.
+func (server *FtpServer) Notify(message string, ids ...uint64) int {
+	return server.clients.notify(message, ids)
+}
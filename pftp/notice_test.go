@@ -0,0 +1,151 @@
+package pftp
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tevino/abool"
+)
+
+func Test_clientHandler_withNotices(t *testing.T) {
+	tests := []struct {
+		name    string
+		notices []string
+		reply   string
+		want    string
+		wantLen int
+	}{
+		{
+			name:    "single_line",
+			notices: []string{"maintenance in 10 minutes"},
+			reply:   "250 CWD command successful.\r\n",
+			want:    "250-maintenance in 10 minutes\r\n250 CWD command successful.\r\n",
+		},
+		{
+			name:    "multi_line_notice",
+			notices: []string{"maintenance\nin 10 minutes", "bye"},
+			reply:   "200 NOOP ok.\r\n",
+			want:    "200-maintenance\r\n200-in 10 minutes\r\n200-bye\r\n200 NOOP ok.\r\n",
+		},
+		{
+			name:    "parsed_by_client",
+			notices: []string{"maintenance"},
+			reply:   "227 Entering Passive Mode (127,0,0,1,4,1).\r\n",
+			want:    "227 Entering Passive Mode (127,0,0,1,4,1).\r\n",
+			wantLen: 1,
+		},
+		{
+			name:    "multi_line_reply",
+			notices: []string{"maintenance"},
+			reply:   "230-Backend: a\r\n230 Login successful.\r\n",
+			want:    "230-Backend: a\r\n230 Login successful.\r\n",
+			wantLen: 1,
+		},
+		{
+			name:    "error_reply",
+			notices: []string{"maintenance"},
+			reply:   "550 No such file.\r\n",
+			want:    "550 No such file.\r\n",
+			wantLen: 1,
+		},
+		{
+			name:  "no_notices",
+			reply: "250 CWD command successful.\r\n",
+			want:  "250 CWD command successful.\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{}
+			for _, n := range tt.notices {
+				c.notify(n)
+			}
+
+			if got := c.withNotices(tt.reply); got != tt.want {
+				t.Errorf("clientHandler.withNotices() = %q, want %q", got, tt.want)
+			}
+			if n := len(c.takeNotices()); n != tt.wantLen {
+				t.Errorf("left notices = %d, want %d", n, tt.wantLen)
+			}
+		})
+	}
+}
+
+func Test_proxyServer_processOriginReply_notices(t *testing.T) {
+	c := &clientHandler{}
+	s := &proxyServer{
+		config:         &Config{},
+		log:            &logger{},
+		isLoggedin:     true,
+		inDataTransfer: abool.New(),
+		withNotices:    c.withNotices,
+	}
+	c.notify("maintenance in 10 minutes")
+
+	// notice waits reply which can carry it
+	s.pushCommand("PWD")
+	if got, _ := s.processOriginReply("257 \"/\" is current directory.\r\n"); got != "257 \"/\" is current directory.\r\n" {
+		t.Errorf("proxyServer.processOriginReply(PWD) = %q", got)
+	}
+	s.pushCommand("NOOP")
+	if got, _ := s.processOriginReply("200 NOOP ok.\r\n"); got != "200-maintenance in 10 minutes\r\n200 NOOP ok.\r\n" {
+		t.Errorf("proxyServer.processOriginReply(NOOP) = %q", got)
+	}
+
+	// unsolicited reply is not command boundary
+	c.notify("again")
+	if got, _ := s.processOriginReply("200 hello\r\n"); got != "200 hello\r\n" {
+		t.Errorf("proxyServer.processOriginReply(unsolicited) = %q", got)
+	}
+}
+
+func Test_FtpServer_handleNotify(t *testing.T) {
+	server, err := NewFtpServerWithConfig(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := []*clientHandler{{id: 1}, {id: 2}, {id: 3}}
+	for _, c := range clients {
+		server.clients.add(c)
+	}
+
+	tests := []struct {
+		name         string
+		body         string
+		wantCode     int
+		wantNotified int
+		wantNotices  []int
+	}{
+		{name: "selected", body: `{"message": "bye", "sessions": [2, 5]}`, wantCode: 200, wantNotified: 1, wantNotices: []int{0, 1, 0}},
+		{name: "all", body: `{"message": "maintenance"}`, wantCode: 200, wantNotified: 3, wantNotices: []int{1, 2, 1}},
+		{name: "empty_message", body: `{"message": " "}`, wantCode: 400, wantNotices: []int{1, 2, 1}},
+		{name: "invalid_body", body: `message`, wantCode: 400, wantNotices: []int{1, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			server.adminHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/notices", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("POST /notices code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == 200 {
+				var got struct {
+					Notified int `json:"notified"`
+				}
+				if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || got.Notified != tt.wantNotified {
+					t.Errorf("POST /notices notified = %d, %v, want %d", got.Notified, err, tt.wantNotified)
+				}
+			}
+			for i, c := range clients {
+				c.summaryMutex.Lock()
+				n := len(c.notices)
+				c.summaryMutex.Unlock()
+				if n != tt.wantNotices[i] {
+					t.Errorf("notices of session %d = %d, want %d", c.id, n, tt.wantNotices[i])
+				}
+			}
+		})
+	}
+}
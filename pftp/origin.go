@@ -0,0 +1,302 @@
+package pftp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// errOriginNotDialed is result of candidate origin not dialed
+// because other origin completed greeting first
+var errOriginNotDialed = errors.New("origin not dialed")
+
+// errUnexpectedReply is result of origin session reply not expected by proxy
+var errUnexpectedReply = errors.New("unexpected reply")
+
+// originConnection is origin control connection which sent greeting
+type originConnection struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	addr    string
+	lenient bool // read replies by lenient_replies
+}
+
+// dial origin, send proxy protocol header and read welcome message.
+// origin greeting with 120 is handled by origin_not_ready.
+func (s *proxyServer) dialOrigin(clientAddr string, originAddr string) (*originConnection, error) {
+	o, err := s.dialOriginOnce(clientAddr, originAddr)
+	if err != nil {
+		return s.retryOriginReady(clientAddr, originAddr, err)
+	}
+
+	return o, nil
+}
+
+func (s *proxyServer) dialOriginOnce(clientAddr string, originAddr string) (*originConnection, error) {
+	dialedAt := time.Now()
+	conn, err := dialEgress(s.originDialer, s.originProxy, originAddr, time.Duration(connectionTimeout)*time.Second)
+	if err != nil {
+		s.originStats.dial(originAddr, err)
+		return nil, err
+	}
+
+	// set linger 0 and tcp keepalive setting between switched origin connection
+	setOriginSocketOptions(conn, time.Duration(s.config.KeepaliveTime)*time.Second)
+	conn = s.originStats.track(originAddr, conn)
+	reader := bufio.NewReader(conn)
+
+	// Send proxy protocol v1 header when set proxy protocol true
+	if s.config.ProxyProtocol {
+		s.log.debug("send proxy protocol to origin")
+		if err := s.sendProxyHeader(conn, clientAddr, originAddr); err != nil {
+			conn.Close()
+			s.originStats.dial(originAddr, err)
+			return nil, err
+		}
+	}
+
+	// do not wait slow origin's welcome message forever
+	if s.config.OriginGreetingTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(time.Duration(s.config.OriginGreetingTimeout) * time.Second))
+	}
+
+	// Read welcome message from ftp connection
+	res, err := readReplyMode(reader, s.lenientReplies())
+	if err == nil && strings.HasPrefix(res, "120") {
+		res, err = s.waitOriginReady(conn, reader, originAddr, res)
+	}
+	if _, ok := err.(*originNotReadyError); ok {
+		conn.Close()
+		s.originStats.dial(originAddr, err)
+		return nil, err
+	}
+	if err != nil {
+		conn.Close()
+		s.originStats.dial(originAddr, err)
+		if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+			return nil, errOriginGreetingTimeout
+		}
+		return nil, errors.New("cannot connect to new origin server")
+	}
+	conn.SetReadDeadline(time.Time{})
+	s.originStats.dial(originAddr, nil)
+	s.originStats.greeting(originAddr, time.Since(dialedAt))
+
+	s.log.debug("response from new origin %s: %s", originAddr, strings.TrimSuffix(res, "\r\n"))
+
+	return &originConnection{conn: conn, reader: reader, addr: originAddr, lenient: s.lenientReplies()}, nil
+}
+
+// originSession is origin session opened by proxy itself for striped
+// transfers and spooled uploads
+type originSession struct {
+	*originConnection
+	writer *bufio.Writer
+}
+
+func newOriginSession(o *originConnection) *originSession {
+	return &originSession{originConnection: o, writer: bufio.NewWriter(o.conn)}
+}
+
+// send command and return reply. error when reply is not one of codes.
+func (o *originSession) command(line string, codes ...string) (string, error) {
+	o.conn.SetDeadline(time.Now().Add(time.Duration(connectionTimeout) * time.Second))
+	if _, err := o.writer.WriteString(line + "\r\n"); err != nil {
+		return "", err
+	}
+	if err := o.writer.Flush(); err != nil {
+		return "", err
+	}
+
+	reply, err := o.reply(codes...)
+	if err == errUnexpectedReply {
+		err = fmt.Errorf("%s is refused: %s", getCommand(line)[0], strings.TrimSpace(reply))
+	}
+
+	return reply, err
+}
+
+// read reply. errUnexpectedReply when reply is not one of codes.
+func (o *originSession) reply(codes ...string) (string, error) {
+	o.conn.SetReadDeadline(time.Now().Add(time.Duration(connectionTimeout) * time.Second))
+	reply, err := readReplyMode(o.reader, o.lenient)
+	if err != nil {
+		return "", err
+	}
+	for _, code := range codes {
+		if strings.HasPrefix(reply, code) {
+			return reply, nil
+		}
+	}
+
+	return reply, errUnexpectedReply
+}
+
+// log in by USER and PASS lines of client
+func (o *originSession) login(lines []string) error {
+	reply, err := o.command(lines[0], "230", "331")
+	if err != nil || strings.HasPrefix(reply, "230") {
+		return err
+	}
+	_, err = o.command(lines[1], "230")
+
+	return err
+}
+
+// load capabilities of current origin from cache. if not cached,
+// probe origin in background for next sessions.
+func (s *proxyServer) loadCapabilities(clientAddr string) {
+	addr := s.originAddr
+	if o, ok := s.capabilities.get(addr); ok {
+		if s.getFeatures() == nil {
+			s.setFeatures(append([]string{}, o.features...))
+		}
+		s.setSystem(o.system)
+		return
+	}
+
+	if s.capabilities.startProbe(addr) {
+		go func() {
+			defer s.capabilities.finishProbe(addr)
+			if err := s.probeOrigin(clientAddr, addr); err != nil {
+				s.log.debug("cannot probe capabilities of origin %s: %s", addr, err.Error())
+			}
+		}()
+	}
+}
+
+// connect to origin by another connection and store FEAT and SYST result
+func (s *proxyServer) probeOrigin(clientAddr string, addr string) error {
+	o, err := s.dialOrigin(clientAddr, addr)
+	if err != nil {
+		return err
+	}
+	defer o.conn.Close()
+
+	o.conn.SetDeadline(time.Now().Add(time.Duration(connectionTimeout) * time.Second))
+
+	writer := bufio.NewWriter(o.conn)
+	command := func(line string) (string, error) {
+		if _, err := writer.WriteString(line + "\r\n"); err != nil {
+			return "", err
+		}
+		if err := writer.Flush(); err != nil {
+			return "", err
+		}
+		return readReplyMode(o.reader, o.lenient)
+	}
+
+	res, err := command("FEAT")
+	if err != nil {
+		return err
+	}
+	features := []string{}
+	if replyCode(res) == "211" {
+		features = parseFeatures(res)
+	}
+
+	system := ""
+	if res, err = command("SYST"); err != nil {
+		return err
+	}
+	if replyCode(res) == "215" {
+		system = strings.TrimSpace(strings.TrimPrefix(strings.TrimSuffix(res, "\r\n"), "215"))
+	}
+
+	command("QUIT")
+
+	s.capabilities.set(addr, features, system)
+	s.log.debug("origin %s capabilities: features=%v system=%s", addr, features, system)
+
+	return nil
+}
+
+// dial candidate origins in parallel. each dial starts delay after previous one,
+// or immediately when previous one failed. failures of dials before previous
+// one are stale and do not skip delay. first origin completing greeting is
+// used and others are closed.
+func (s *proxyServer) dialOriginsParallel(clientAddr string, addrs []string, delay time.Duration) (*originConnection, error) {
+	type dialResult struct {
+		o    *originConnection
+		addr string
+		err  error
+	}
+
+	results := make(chan dialResult, len(addrs))
+	failed := make(chan int, len(addrs)) // index of failed dial
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		for i, addr := range addrs {
+			if i > 0 {
+				timer := time.NewTimer(delay)
+			wait:
+				for {
+					select {
+					case <-timer.C:
+						break wait
+					case n := <-failed:
+						if n < i-1 {
+							continue
+						}
+						timer.Stop()
+						break wait
+					case <-stop:
+						timer.Stop()
+						for _, addr := range addrs[i:] {
+							results <- dialResult{addr: addr, err: errOriginNotDialed}
+						}
+						return
+					}
+				}
+			}
+
+			go func(i int, addr string) {
+				o, err := s.dialOrigin(clientAddr, addr)
+				if err != nil {
+					failed <- i
+				}
+				results <- dialResult{o: o, addr: addr, err: err}
+			}(i, addr)
+		}
+	}()
+
+	// report result and close connection of origins lost the race
+	cleanup := func(r dialResult) {
+		if r.err == errOriginNotDialed {
+			return
+		}
+		s.health.report(r.addr, r.err == nil)
+		s.breaker.report(r.addr, r.err == nil)
+		if r.o != nil {
+			r.o.conn.Close()
+		}
+	}
+
+	lastError := error(nil)
+	for received := 0; received < len(addrs); received++ {
+		r := <-results
+		if r.err != nil {
+			cleanup(r)
+			s.log.err("cannot connect to origin %s: %s", r.addr, r.err.Error())
+			lastError = r.err
+			continue
+		}
+
+		s.health.report(r.addr, true)
+		s.breaker.report(r.addr, true)
+		go func(remaining int) {
+			for i := 0; i < remaining; i++ {
+				cleanup(<-results)
+			}
+		}(len(addrs) - received - 1)
+
+		return r.o, nil
+	}
+
+	return nil, lastError
+}
@@ -0,0 +1,116 @@
+package pftp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// policies of origin_not_ready for 120 greeting of origin
+const (
+	originNotReadyWait     = "wait"
+	originNotReadyRetry    = "retry"
+	originNotReadyFailover = "failover"
+)
+
+// ex) "120 Service ready in 5 minutes."
+var serviceReadyDelayPattern = regexp.MustCompile(`(?i)(\d+)\s*min`)
+
+// originNotReadyError is returned by dial of origin greeting with 120.
+// failover origins are tried by it.
+type originNotReadyError struct {
+	reply string
+	delay time.Duration
+}
+
+func (e *originNotReadyError) Error() string {
+	return fmt.Sprintf("origin is not ready: %s", strings.TrimSpace(e.reply))
+}
+
+// return delay of 120 reply. 0 when reply has no delay.
+func serviceReadyDelay(reply string) time.Duration {
+	m := serviceReadyDelayPattern.FindStringSubmatch(reply)
+	if m == nil {
+		return 0
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0
+	}
+
+	return time.Duration(n) * time.Minute
+}
+
+// return time to wait origin in 120 reply. origin_not_ready_max_wait is
+// used when reply has no delay, and false when delay is over it.
+func (s *proxyServer) originReadyDelay(reply string) (time.Duration, bool) {
+	max := time.Duration(s.config.OriginNotReadyMaxWait) * time.Second
+	delay := serviceReadyDelay(reply)
+	if delay == 0 {
+		return max, true
+	}
+
+	return delay, delay <= max
+}
+
+// handle 120 greeting of origin by origin_not_ready. "wait" reads 220 which
+// follows it while client is held, and others return originNotReadyError.
+func (s *proxyServer) waitOriginReady(conn net.Conn, reader *bufio.Reader, addr string, reply string) (string, error) {
+	delay, ok := s.originReadyDelay(reply)
+	action := s.config.OriginNotReady
+	if !ok {
+		action = originNotReadyFailover
+	}
+	s.log.info("origin %s is not ready (%s): %s", addr, action, strings.TrimSpace(reply))
+	s.events.emit(&OriginNotReadyEvent{
+		Time:      time.Now(),
+		SessionID: s.sessionID,
+		Origin:    addr,
+		Reply:     strings.TrimSpace(reply),
+		Delay:     delay,
+		Action:    action,
+	})
+	if action != originNotReadyWait {
+		return "", &originNotReadyError{reply: reply, delay: delay}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(delay))
+	for strings.HasPrefix(reply, "120") {
+		var err error
+		if reply, err = readReplyMode(reader, s.lenientReplies()); err != nil {
+			return "", err
+		}
+	}
+
+	return reply, nil
+}
+
+// dial origin again after delay of 120 greeting when origin_not_ready is
+// "retry". client is told by 120 (origin_not_ready message, or text of
+// origin) before it waits. origin still not ready is failed.
+func (s *proxyServer) retryOriginReady(clientAddr string, originAddr string, err error) (*originConnection, error) {
+	notReady, ok := err.(*originNotReadyError)
+	if !ok || s.config.OriginNotReady != originNotReadyRetry {
+		return nil, err
+	}
+	if _, ok := s.originReadyDelay(notReady.reply); !ok {
+		return nil, err
+	}
+
+	reply := notReady.reply
+	if len(s.notReadyMsg) > 0 {
+		reply = formatReply(120, s.notReadyMsg)
+	}
+	if err := s.sendToClient(strings.TrimRight(reply, "\r\n")); err != nil {
+		return nil, err
+	}
+
+	s.log.info("dial origin %s again after %s", originAddr, notReady.delay)
+	time.Sleep(notReady.delay)
+
+	return s.dialOriginOnce(clientAddr, originAddr)
+}
@@ -0,0 +1,149 @@
+package pftp
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// start fake origin greeting nth connection by greetings[n]
+func startGreetingOrigin(t *testing.T, greetings ...string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			i := int(atomic.AddInt32(&n, 1)) - 1
+			go func() {
+				defer conn.Close()
+				if i < len(greetings) {
+					conn.Write([]byte(greetings[i]))
+				}
+				time.Sleep(2 * time.Second)
+			}()
+		}
+	}()
+
+	return l
+}
+
+func Test_serviceReadyDelay(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  time.Duration
+	}{
+		{"120 Service ready in 5 minutes.\r\n", 5 * time.Minute},
+		{"120 ready in 10 min\r\n", 10 * time.Minute},
+		{"120 Service starting\r\n", 0},
+	}
+	for _, tt := range tests {
+		if got := serviceReadyDelay(tt.reply); got != tt.want {
+			t.Errorf("serviceReadyDelay(%q) = %s, want %s", tt.reply, got, tt.want)
+		}
+	}
+}
+
+func Test_proxyServer_dialOrigin_notReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		maxWait    int
+		greetings  []string
+		notReady   string // origin_not_ready message
+		wantErr    bool
+		wantAction string
+		wantClient string
+	}{
+		{
+			name:       "wait",
+			policy:     originNotReadyWait,
+			maxWait:    120,
+			greetings:  []string{"120 Service ready in 1 minutes.\r\n220 ready\r\n"},
+			wantAction: originNotReadyWait,
+		},
+		{
+			name:       "wait_over_max",
+			policy:     originNotReadyWait,
+			maxWait:    60,
+			greetings:  []string{"120 Service ready in 5 minutes.\r\n220 ready\r\n"},
+			wantErr:    true,
+			wantAction: originNotReadyFailover,
+		},
+		{
+			name:       "failover",
+			policy:     originNotReadyFailover,
+			maxWait:    60,
+			greetings:  []string{"120 Service ready in 1 minutes.\r\n"},
+			wantErr:    true,
+			wantAction: originNotReadyFailover,
+		},
+		{
+			name:       "retry",
+			policy:     originNotReadyRetry,
+			maxWait:    1,
+			greetings:  []string{"120 Service starting\r\n", "220 ready\r\n"},
+			wantAction: originNotReadyRetry,
+			wantClient: "120 Service starting\r\n",
+		},
+		{
+			name:       "retry_message",
+			policy:     originNotReadyRetry,
+			maxWait:    1,
+			greetings:  []string{"120 Service starting\r\n", "220 ready\r\n"},
+			notReady:   "origin is starting. please wait",
+			wantAction: originNotReadyRetry,
+			wantClient: "120 origin is starting. please wait\r\n",
+		},
+		{
+			name:       "retry_not_ready",
+			policy:     originNotReadyRetry,
+			maxWait:    1,
+			greetings:  []string{"120 Service starting\r\n", "120 Service starting\r\n"},
+			wantErr:    true,
+			wantAction: originNotReadyRetry,
+			wantClient: "120 Service starting\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := startGreetingOrigin(t, tt.greetings...)
+			defer l.Close()
+
+			var client bytes.Buffer
+			s := &proxyServer{
+				config:       &Config{OriginGreetingTimeout: 5, OriginNotReady: tt.policy, OriginNotReadyMaxWait: tt.maxWait},
+				log:          &logger{},
+				events:       newEventBus(),
+				clientWriter: bufio.NewWriter(&client),
+				mutex:        &sync.Mutex{},
+				notReadyMsg:  tt.notReady,
+			}
+			o, err := s.dialOrigin("127.0.0.1:10000", l.Addr().String())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("proxyServer.dialOrigin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if o != nil {
+				o.conn.Close()
+			}
+			if client.String() != tt.wantClient {
+				t.Errorf("sent to client = %q, want %q", client.String(), tt.wantClient)
+			}
+
+			e := (<-s.events.ch).(*OriginNotReadyEvent)
+			if e.Action != tt.wantAction || e.Origin != l.Addr().String() {
+				t.Errorf("event = %+v, want action %s", e, tt.wantAction)
+			}
+		})
+	}
+}
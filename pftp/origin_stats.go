@@ -0,0 +1,220 @@
+package pftp
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// count of recent dials used for error rate of origin
+const originErrorRateWindow = 20
+
+// OriginStats is connection statistics of origin address
+type OriginStats struct {
+	Addr string `json:"addr"`
+	// Connections is count of live control connections to origin
+	Connections int    `json:"connections"`
+	Dials       uint64 `json:"dials"`
+	DialErrors  uint64 `json:"dial_errors"`
+	// ErrorRate is rate of failed dials in recent dials
+	ErrorRate float64 `json:"error_rate"`
+	// GreetingLatency is mean time from dial to greeting of origin
+	GreetingLatency time.Duration `json:"greeting_latency"`
+}
+
+type originStat struct {
+	connections     int
+	dials           uint64
+	dialErrors      uint64
+	recent          []bool // results of recent dials. true is failure.
+	greetings       uint64
+	greetingTotal   time.Duration
+	overConnections bool
+	overErrorRate   bool
+}
+
+func (o *originStat) errorRate() float64 {
+	if len(o.recent) == 0 {
+		return 0
+	}
+
+	failures := 0
+	for _, failed := range o.recent {
+		if failed {
+			failures++
+		}
+	}
+
+	return float64(failures) / float64(len(o.recent))
+}
+
+// originStats track connections to each origin and emit events when
+// thresholds are crossed. it is shared by all client sessions of server.
+type originStats struct {
+	mutex                sync.Mutex
+	origins              map[string]*originStat
+	connectionsThreshold int
+	errorRateThreshold   float64
+	events               *eventBus
+	metrics              *metrics
+}
+
+func newOriginStats(c *Config, events *eventBus, m *metrics) *originStats {
+	return &originStats{
+		origins:              map[string]*originStat{},
+		connectionsThreshold: c.OriginConnectionsThreshold,
+		errorRateThreshold:   c.OriginErrorRateThreshold,
+		events:               events,
+		metrics:              m,
+	}
+}
+
+func (s *originStats) get(addr string) *originStat {
+	o, ok := s.origins[addr]
+	if !ok {
+		o = &originStat{}
+		s.origins[addr] = o
+	}
+	return o
+}
+
+// record result of dial to origin
+func (s *originStats) dial(addr string, err error) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	o := s.get(addr)
+	o.dials++
+	s.metrics.inc("pftp_origin_dials_total", "Count of dials to origin.", "origin", addr)
+	if err != nil {
+		o.dialErrors++
+		s.metrics.inc("pftp_origin_dial_errors_total", "Count of failed dials to origin.", "origin", addr)
+	}
+
+	o.recent = append(o.recent, err != nil)
+	if len(o.recent) > originErrorRateWindow {
+		o.recent = o.recent[1:]
+	}
+
+	// error rate of few dials is not reliable
+	if s.errorRateThreshold > 0 && len(o.recent) >= originErrorRateWindow/2 {
+		rate := o.errorRate()
+		if over := rate >= s.errorRateThreshold; over != o.overErrorRate {
+			o.overErrorRate = over
+			s.emitThreshold(addr, "error_rate", rate, s.errorRateThreshold, over)
+		}
+	}
+}
+
+// record time from dial to greeting of origin
+func (s *originStats) greeting(addr string, latency time.Duration) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	o := s.get(addr)
+	o.greetings++
+	o.greetingTotal += latency
+	s.metrics.add("pftp_origin_greeting_seconds_total", "Sum of seconds from dial to greeting of origin.", latency.Seconds(), "origin", addr)
+	s.metrics.inc("pftp_origin_greetings_total", "Count of greetings received from origin.", "origin", addr)
+}
+
+// change count of live connections to origin
+func (s *originStats) connection(addr string, delta int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	o := s.get(addr)
+	o.connections += delta
+	s.metrics.set("pftp_origin_connections", "Count of live control connections to origin.", float64(o.connections), "origin", addr)
+
+	if s.connectionsThreshold > 0 {
+		if over := o.connections >= s.connectionsThreshold; over != o.overConnections {
+			o.overConnections = over
+			s.emitThreshold(addr, "connections", float64(o.connections), float64(s.connectionsThreshold), over)
+		}
+	}
+}
+
+func (s *originStats) emitThreshold(addr string, metric string, value float64, threshold float64, exceeded bool) {
+	s.events.emit(&OriginThresholdEvent{
+		Time:      time.Now(),
+		Origin:    addr,
+		Metric:    metric,
+		Value:     value,
+		Threshold: threshold,
+		Exceeded:  exceeded,
+	})
+}
+
+// count conn as live connection to origin until it is closed
+func (s *originStats) track(addr string, conn net.Conn) net.Conn {
+	if s == nil {
+		return conn
+	}
+
+	s.connection(addr, 1)
+
+	return &trackedConn{Conn: conn, closed: func() { s.connection(addr, -1) }}
+}
+
+// return statistics of all origins ordered by address
+func (s *originStats) list() []OriginStats {
+	stats := []OriginStats{}
+	if s == nil {
+		return stats
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for addr, o := range s.origins {
+		st := OriginStats{
+			Addr:        addr,
+			Connections: o.connections,
+			Dials:       o.dials,
+			DialErrors:  o.dialErrors,
+			ErrorRate:   o.errorRate(),
+		}
+		if o.greetings > 0 {
+			st.GreetingLatency = o.greetingTotal / time.Duration(o.greetings)
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+
+	return stats
+}
+
+// trackedConn call closed once when connection is closed
+type trackedConn struct {
+	net.Conn
+	once   sync.Once
+	closed func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.closed)
+	return c.Conn.Close()
+}
+
+// NetConn return wrapped connection to set socket options
+func (c *trackedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// CloseWrite send EOF when wrapped connection supports it
+func (c *trackedConn) CloseWrite() error {
+	if v, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return v.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package pftp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_originStats_dial(t *testing.T) {
+	errDial := errors.New("connection refused")
+
+	tests := []struct {
+		name      string
+		threshold float64
+		results   []error
+		want      OriginStats
+		wantEvent []bool // Exceeded of emitted events in order
+	}{
+		{
+			name:    "no_threshold",
+			results: []error{nil, errDial, nil, nil},
+			want:    OriginStats{Addr: "origin:21", Dials: 4, DialErrors: 1, ErrorRate: 0.25},
+		},
+		{
+			name:      "too_few_dials",
+			threshold: 0.5,
+			results:   []error{errDial, errDial, errDial},
+			want:      OriginStats{Addr: "origin:21", Dials: 3, DialErrors: 3, ErrorRate: 1},
+		},
+		{
+			name:      "exceeded",
+			threshold: 0.5,
+			results:   []error{errDial, errDial, errDial, errDial, errDial, nil, nil, nil, nil, nil},
+			want:      OriginStats{Addr: "origin:21", Dials: 10, DialErrors: 5, ErrorRate: 0.5},
+			wantEvent: []bool{true},
+		},
+		{
+			name:      "recovered",
+			threshold: 0.5,
+			results:   []error{errDial, errDial, errDial, errDial, errDial, nil, nil, nil, nil, nil, nil},
+			want:      OriginStats{Addr: "origin:21", Dials: 11, DialErrors: 5, ErrorRate: 5.0 / 11},
+			wantEvent: []bool{true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := newEventBus()
+			s := newOriginStats(&Config{OriginErrorRateThreshold: tt.threshold}, events, newMetrics(&Config{}))
+			for _, err := range tt.results {
+				s.dial("origin:21", err)
+			}
+
+			if got := s.list(); !reflect.DeepEqual(got, []OriginStats{tt.want}) {
+				t.Errorf("list() = %+v, want %+v", got, tt.want)
+			}
+
+			got := []bool{}
+			for len(events.ch) > 0 {
+				e := (<-events.ch).(*OriginThresholdEvent)
+				if e.Metric != "error_rate" || e.Origin != "origin:21" {
+					t.Errorf("event = %+v", e)
+				}
+				got = append(got, e.Exceeded)
+			}
+			if len(got) != len(tt.wantEvent) || (len(got) > 0 && !reflect.DeepEqual(got, tt.wantEvent)) {
+				t.Errorf("events exceeded = %v, want %v", got, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func Test_originStats_track(t *testing.T) {
+	events := newEventBus()
+	m := newMetrics(&Config{})
+	s := newOriginStats(&Config{OriginConnectionsThreshold: 2}, events, m)
+
+	conns := []net.Conn{}
+	for i := 0; i < 2; i++ {
+		c, _ := net.Pipe()
+		conns = append(conns, s.track("origin:21", c))
+	}
+	s.greeting("origin:21", 10*time.Millisecond)
+	s.greeting("origin:21", 30*time.Millisecond)
+
+	if got := s.list()[0]; got.Connections != 2 || got.GreetingLatency != 20*time.Millisecond {
+		t.Errorf("list() = %+v, want 2 connections and 20ms latency", got)
+	}
+	if e := (<-events.ch).(*OriginThresholdEvent); !e.Exceeded || e.Value != 2 {
+		t.Errorf("event = %+v, want exceeded with 2 connections", e)
+	}
+
+	// closing twice is counted once
+	conns[0].Close()
+	conns[0].Close()
+	if got := s.list()[0].Connections; got != 1 {
+		t.Errorf("connections = %d, want 1", got)
+	}
+	if e := (<-events.ch).(*OriginThresholdEvent); e.Exceeded {
+		t.Errorf("event = %+v, want back below threshold", e)
+	}
+
+	b := &bytes.Buffer{}
+	m.write(b)
+	for _, want := range []string{
+		"# TYPE pftp_origin_connections gauge",
+		`pftp_origin_connections{origin="origin:21"} 1`,
+		`pftp_origin_greetings_total{origin="origin:21"} 2`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics do not contain %q: %s", want, b.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	(&FtpServer{originStats: s}).adminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/origins", nil))
+	var got []OriginStats
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || len(got) != 1 || got[0].Connections != 1 {
+		t.Errorf("GET /origins = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,65 @@
+package pftp
+
+import (
+	"errors"
+	"time"
+)
+
+// errTransferAborted is result of data transfer aborted or ended by client
+var errTransferAborted = errors.New("data transfer is ended by client")
+
+// destinationError is write error of destination connection in copyPackets and copyListing
+type destinationError struct {
+	error
+}
+
+// close data connections at once because client aborted transfer,
+// so rest of file is not read from origin.
+func (s *proxyServer) abortDataTransfer() {
+	s.dataMutex.Lock()
+	d := s.dataConnector
+	s.dataMutex.Unlock()
+	if d == nil || !d.isStarted() {
+		return
+	}
+
+	d.mutex.Lock()
+	d.clientAborted = true
+	d.mutex.Unlock()
+	connectionCloser(d, s.log)
+}
+
+// report RETR ended by client before origin sent whole file. clients read
+// byte range by REST and RETR, and close data connection or send ABOR after it.
+// return true when transfer is partial.
+func (d *dataHandler) reportPartial(direction string, err error) bool {
+	if direction != downloadStream || len(d.path) == 0 {
+		return false
+	}
+
+	d.mutex.Lock()
+	aborted := d.clientAborted
+	d.mutex.Unlock()
+
+	reason := "abort"
+	if !aborted {
+		if _, ok := err.(*destinationError); !ok {
+			return false
+		}
+		reason = "client_closed"
+	}
+
+	n := d.transferredBytes()
+	d.log.info("client ended RETR %s after %d bytes from offset %d (%s)", d.path, n, d.offset, reason)
+	d.metrics.add("pftp_partial_downloads_total", "Downloads ended by client before end of file.", 1, "reason", reason)
+	d.events.emit(&PartialTransferEvent{
+		Time:      time.Now(),
+		SessionID: d.sessionID,
+		Path:      d.path,
+		Offset:    d.offset,
+		Bytes:     n,
+		Reason:    reason,
+	})
+
+	return true
+}
@@ -0,0 +1,98 @@
+package pftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_dataHandler_copyPackets_clientClosed(t *testing.T) {
+	clientConn, clientPeer := net.Pipe()
+	defer clientConn.Close()
+	originConn, originPeer := net.Pipe()
+	defer originPeer.Close()
+
+	d := &dataHandler{config: &Config{}, log: &logger{}, mutex: &sync.Mutex{}}
+
+	// origin sends large file, client reads first bytes of it only
+	originWritten := make(chan error)
+	go func() {
+		chunk := bytes.Repeat([]byte("x"), 1024)
+		for i := 0; i < 1024; i++ {
+			if _, err := originPeer.Write(chunk); err != nil {
+				originWritten <- err
+				return
+			}
+		}
+		originWritten <- nil
+	}()
+	go func() {
+		io.ReadFull(clientPeer, make([]byte, 10))
+		clientPeer.Close()
+	}()
+
+	done := make(chan error)
+	go func() { done <- d.copyPackets(clientConn, originConn, 10, nil) }()
+	select {
+	case err := <-done:
+		if _, ok := err.(*destinationError); !ok {
+			t.Errorf("copyPackets() error = %v, want destinationError", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("copyPackets() did not end")
+	}
+
+	if n := d.transferredBytes(); n != 10 {
+		t.Errorf("transferred = %d, want 10 delivered bytes", n)
+	}
+	originPeer.SetDeadline(time.Now().Add(3 * time.Second))
+	if err := <-originWritten; err == nil {
+		t.Error("origin data connection is not closed")
+	}
+}
+
+func Test_dataHandler_reportPartial(t *testing.T) {
+	tests := []struct {
+		name      string
+		direction string
+		path      string
+		aborted   bool
+		err       error
+		want      string
+	}{
+		{name: "client_closed", direction: downloadStream, path: "a.bin", err: &destinationError{errors.New("broken pipe")}, want: "client_closed"},
+		{name: "abort", direction: downloadStream, path: "a.bin", aborted: true, want: "abort"},
+		{name: "completed", direction: downloadStream, path: "a.bin"},
+		{name: "origin_error", direction: downloadStream, path: "a.bin", err: &sourceError{errors.New("reset")}},
+		{name: "listing", direction: downloadStream, err: &destinationError{errors.New("broken pipe")}},
+		{name: "upload", direction: uploadStream, path: "a.bin", err: &destinationError{errors.New("broken pipe")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dataHandler{
+				log:           &logger{},
+				mutex:         &sync.Mutex{},
+				events:        newEventBus(),
+				path:          tt.path,
+				offset:        100,
+				transferred:   10,
+				clientAborted: tt.aborted,
+			}
+
+			if got := d.reportPartial(tt.direction, tt.err); got != (len(tt.want) > 0) {
+				t.Fatalf("reportPartial() = %v, want %v", got, len(tt.want) > 0)
+			}
+			if len(tt.want) == 0 {
+				return
+			}
+			e := (<-d.events.ch).(*PartialTransferEvent)
+			if e.Reason != tt.want || e.Offset != 100 || e.Bytes != 10 {
+				t.Errorf("event = %+v, want %s of 10 bytes from 100", e, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package pftp
+
+import (
+	"errors"
+	"net"
+)
+
+// policies of passive_unroutable_ip for 0.0.0.0 or loopback IP in origin PASV reply
+const (
+	passiveUnroutableControl = "control"
+	passiveUnroutableRewrite = "rewrite"
+	passiveUnroutableReject  = "reject"
+)
+
+var errUnroutablePassiveIP = errors.New("origin advertised unroutable passive IP")
+
+// return true when IP advertised in PASV reply can not reach origin, that is
+// 0.0.0.0, or loopback while origin control connection is not loopback.
+func unroutablePassiveIP(advertised string, control string) bool {
+	ip := net.ParseIP(advertised)
+	if ip == nil {
+		return false
+	}
+	if ip.IsUnspecified() {
+		return true
+	}
+
+	return ip.IsLoopback() && !net.ParseIP(control).IsLoopback()
+}
+
+// replace unroutable IP of PASV reply by passive_unroutable_ip. "rewrite" uses
+// IP of passive_unroutable_ip_overrides for origin, and control connection IP
+// when origin is not in it.
+func (d *dataHandler) resolveUnroutablePassiveIP() error {
+	policy := passiveUnroutableControl
+	if d.config != nil && len(d.config.PassiveUnroutableIP) > 0 {
+		policy = d.config.PassiveUnroutableIP
+	}
+	d.metrics.inc("pftp_passive_unroutable_ip_total", "Unroutable IPs advertised in origin PASV replies.", "action", policy)
+
+	switch policy {
+	case passiveUnroutableReject:
+		return errUnroutablePassiveIP
+	case passiveUnroutableRewrite:
+		if ip, ok := d.config.PassiveIPOverrides[d.originAddr]; ok {
+			d.originConn.remoteIP = ip
+			return nil
+		}
+	}
+	d.originConn.remoteIP = d.originConn.originalRemoteIP
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package pftp
+
+import (
+	"testing"
+
+	"github.com/tevino/abool"
+)
+
+func Test_dataHandler_parsePASVresponse_unroutable(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		controlIP string
+		config    *Config
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "unspecified_control",
+			line:      "227 Entering Passive Mode (0,0,0,0,100,10).\r\n",
+			controlIP: "203.0.113.1",
+			config:    &Config{},
+			want:      "203.0.113.1",
+		},
+		{
+			name:      "loopback_rewrite",
+			line:      "227 Entering Passive Mode (127,0,0,1,100,10).\r\n",
+			controlIP: "10.0.0.5",
+			config: &Config{
+				PassiveUnroutableIP: passiveUnroutableRewrite,
+				PassiveIPOverrides:  map[string]string{"10.0.0.5:21": "203.0.113.5"},
+			},
+			want: "203.0.113.5",
+		},
+		{
+			name:      "rewrite_without_override",
+			line:      "227 Entering Passive Mode (0,0,0,0,100,10).\r\n",
+			controlIP: "10.0.0.6",
+			config: &Config{
+				PassiveUnroutableIP: passiveUnroutableRewrite,
+				PassiveIPOverrides:  map[string]string{"10.0.0.5:21": "203.0.113.5"},
+			},
+			want: "10.0.0.6",
+		},
+		{
+			name:      "reject",
+			line:      "227 Entering Passive Mode (0,0,0,0,100,10).\r\n",
+			controlIP: "203.0.113.1",
+			config:    &Config{PassiveUnroutableIP: passiveUnroutableReject},
+			wantErr:   true,
+		},
+		{
+			// origin on same host advertises its right address
+			name:      "loopback_of_local_origin",
+			line:      "227 Entering Passive Mode (127,0,0,1,100,10).\r\n",
+			controlIP: "127.0.0.1",
+			config:    &Config{PassiveUnroutableIP: passiveUnroutableReject},
+			want:      "127.0.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, _ := newDataHandler(tt.config, nil, nil, nil, "PASV", nil, abool.New(), abool.New(), nil)
+			d.originConn.originalRemoteIP = tt.controlIP
+			d.originAddr = tt.controlIP + ":21"
+			err := d.parsePASVresponse(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePASVresponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && d.originConn.remoteIP != tt.want {
+				t.Errorf("parsePASVresponse() ip = %q, want %q", d.originConn.remoteIP, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newContext_PassiveIPMap(t *testing.T) {
+	c := &Config{PassiveIPMap: map[string]string{"10.0.0.5": "198.51.100.5"}}
+
+	ctx := newContext(c)
+	ctx.PassiveIPMap["10.0.0.6"] = "198.51.100.6"
+	if len(c.PassiveIPMap) != 1 {
+		t.Errorf("passive_ip_map of config = %v, changed by session", c.PassiveIPMap)
+	}
+}
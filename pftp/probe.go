@@ -0,0 +1,167 @@
+package pftp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// actions of probe_rules
+const (
+	probeClose = "close"
+	probeReply = "reply"
+)
+
+// rule name of probes which closed connection before welcome message
+const probeClosedEarly = "closed_before_welcome"
+
+// ProbeRule answer connection of security scanner or health check without
+// session and origin connection. Pattern is regular expression matched to
+// first line client sent before welcome message, and Clients are IPs or
+// CIDRs of peer address. rule without Pattern matches on connect.
+type ProbeRule struct {
+	Name    string   `toml:"name"`
+	Pattern string   `toml:"pattern"`
+	Clients []string `toml:"clients"`
+	Action  string   `toml:"action"`
+	Reply   string   `toml:"reply"`
+}
+
+type probeRule struct {
+	ProbeRule
+	pattern *regexp.Regexp
+}
+
+// probeFilter detect probes by probe_rules before client handler is made.
+// probes are logged at debug level and counted by pftp_probes_total.
+type probeFilter struct {
+	rules   []probeRule
+	timeout time.Duration // wait for first line. 0 when no rule has pattern
+	metrics *metrics
+	debug   func(format string, args ...interface{})
+}
+
+// patterns of rules are validated by config
+func newProbeFilter(c *Config, m *metrics, debug func(format string, args ...interface{})) *probeFilter {
+	if len(c.ProbeRules) == 0 {
+		return nil
+	}
+
+	f := &probeFilter{metrics: m, debug: debug}
+	for _, r := range c.ProbeRules {
+		rule := probeRule{ProbeRule: r}
+		if len(r.Pattern) > 0 {
+			rule.pattern = regexp.MustCompile(r.Pattern)
+			f.timeout = time.Duration(c.ProbeDetectTimeout) * time.Millisecond
+		}
+		f.rules = append(f.rules, rule)
+	}
+
+	return f
+}
+
+// validate probe_rules
+func validateProbeRules(rules []ProbeRule) error {
+	for i, r := range rules {
+		if len(r.Name) == 0 {
+			return fmt.Errorf("configuration error: probe rule %d has no name", i)
+		}
+		if len(r.Pattern) == 0 && len(r.Clients) == 0 {
+			return fmt.Errorf("configuration error: probe rule %s needs pattern or clients", r.Name)
+		}
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("configuration error: pattern of probe rule %s is wrong: %s", r.Name, err.Error())
+		}
+		if err := validatePortAllowlist(r.Clients); err != nil {
+			return err
+		}
+		switch r.Action {
+		case probeClose:
+		case probeReply:
+			if len(r.Reply) == 0 {
+				return fmt.Errorf("configuration error: probe rule %s needs reply", r.Name)
+			}
+		default:
+			return fmt.Errorf("configuration error: action of probe rule %s must be close or reply", r.Name)
+		}
+	}
+
+	return nil
+}
+
+// answer conn when it is probe. otherwise return conn to serve, which has
+// bytes read for detection. deadline is read deadline of conn set on accept
+// and it is restored after detection.
+func (f *probeFilter) check(conn net.Conn, deadline time.Time) (net.Conn, bool) {
+	if f == nil {
+		return conn, false
+	}
+
+	ip := net.ParseIP(clientIP(conn.RemoteAddr().String()))
+	for _, r := range f.rules {
+		if r.pattern == nil && inAllowlist(ip, r.Clients) {
+			f.answer(conn, r, "")
+			return nil, true
+		}
+	}
+	if f.timeout <= 0 || !f.detects(ip) {
+		return conn, false
+	}
+
+	// FTP clients wait for welcome message, and probes send at once
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(f.timeout))
+	_, err := reader.Peek(1)
+	conn.SetReadDeadline(deadline)
+	var ne net.Error
+	if err != nil && !(errors.As(err, &ne) && ne.Timeout()) {
+		f.answer(conn, probeRule{ProbeRule: ProbeRule{Name: probeClosedEarly, Action: probeClose}}, "")
+		return nil, true
+	}
+
+	if reader.Buffered() == 0 {
+		return conn, false
+	}
+
+	b, _ := reader.Peek(reader.Buffered())
+	line := strings.TrimRight(strings.SplitN(string(b), "\n", 2)[0], "\r")
+	for _, r := range f.rules {
+		if r.pattern != nil && r.pattern.MatchString(line) && (len(r.Clients) == 0 || inAllowlist(ip, r.Clients)) {
+			f.answer(conn, r, line)
+			return nil, true
+		}
+	}
+
+	// bytes like PROXY protocol header are read by session
+	return &peekedConn{Conn: conn, reader: reader}, false
+}
+
+// return true when rule with pattern is applied to ip. welcome of other
+// clients is not delayed by probe_detect_timeout.
+func (f *probeFilter) detects(ip net.IP) bool {
+	for _, r := range f.rules {
+		if r.pattern != nil && (len(r.Clients) == 0 || inAllowlist(ip, r.Clients)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// send static reply of rule and close conn
+func (f *probeFilter) answer(conn net.Conn, r probeRule, line string) {
+	defer conn.Close()
+
+	f.metrics.inc("pftp_probes_total", "Connections answered by probe rules.", "rule", r.Name)
+	if f.debug != nil {
+		f.debug("probe from %s is answered by rule %s: %q", conn.RemoteAddr(), r.Name, line)
+	}
+	if r.Action == probeReply {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		conn.Write([]byte(r.Reply + "\r\n"))
+	}
+}
@@ -0,0 +1,174 @@
+package pftp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_probeFilter_check(t *testing.T) {
+	config := &Config{
+		ProbeDetectTimeout: 100,
+		ProbeRules: []ProbeRule{
+			{Name: "http", Pattern: "^(GET|HEAD) ", Action: probeClose},
+			{Name: "lb", Clients: []string{"192.0.2.0/24"}, Action: probeReply, Reply: "220 ok"},
+			{Name: "lb_local", Pattern: "^HEALTH$", Clients: []string{"127.0.0.1"}, Action: probeReply, Reply: "200 ok"},
+		},
+	}
+	if err := validateProbeRules(config.ProbeRules); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		send      string
+		close     bool
+		wantProbe bool
+		wantReply string
+		wantRead  string
+	}{
+		{name: "http", send: "GET / HTTP/1.1\r\nHost: x\r\n\r\n", wantProbe: true},
+		{name: "pattern and client", send: "HEALTH\r\n", wantProbe: true, wantReply: "200 ok\r\n"},
+		{name: "closed before welcome", close: true, wantProbe: true},
+		{name: "ftp client waits welcome"},
+		{name: "proxy protocol header", send: "PROXY TCP4 192.0.2.1 192.0.2.2 1234 21\r\n", wantRead: "PROXY TCP4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			client, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			conn, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			client.Write([]byte(tt.send))
+			if tt.close {
+				client.Close()
+			}
+
+			f := newProbeFilter(config, newMetrics(&Config{}), nil)
+			got, probe := f.check(conn, time.Time{})
+			if probe != tt.wantProbe {
+				t.Fatalf("check() probe = %v, want %v", probe, tt.wantProbe)
+			}
+			if probe {
+				if tt.close {
+					return
+				}
+				client.SetReadDeadline(time.Now().Add(time.Second))
+				b, _ := io.ReadAll(client)
+				if string(b) != tt.wantReply {
+					t.Errorf("reply = %q, want %q", b, tt.wantReply)
+				}
+				return
+			}
+			if len(tt.wantRead) > 0 {
+				line, _ := bufio.NewReader(got).ReadString('\n')
+				if !strings.HasPrefix(line, tt.wantRead) {
+					t.Errorf("session read %q, want %q", line, tt.wantRead)
+				}
+			} else if got != conn {
+				t.Error("connection is wrapped without read bytes")
+			}
+		})
+	}
+}
+
+func Test_probeFilter_clients(t *testing.T) {
+	f := newProbeFilter(&Config{ProbeRules: []ProbeRule{
+		{Name: "local", Clients: []string{"127.0.0.0/8"}, Action: probeReply, Reply: "220 ok"},
+	}}, nil, nil)
+	if f.timeout != 0 {
+		t.Errorf("timeout = %s, want 0 without pattern", f.timeout)
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	if _, probe := f.check(server, time.Time{}); probe {
+		t.Error("pipe without IP is probe")
+	}
+}
+
+func Test_validateProbeRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    ProbeRule
+		wantErr bool
+	}{
+		{name: "close", rule: ProbeRule{Name: "a", Pattern: "^GET", Action: probeClose}},
+		{name: "no name", rule: ProbeRule{Pattern: "^GET", Action: probeClose}, wantErr: true},
+		{name: "no match", rule: ProbeRule{Name: "a", Action: probeClose}, wantErr: true},
+		{name: "wrong pattern", rule: ProbeRule{Name: "a", Pattern: "(", Action: probeClose}, wantErr: true},
+		{name: "wrong clients", rule: ProbeRule{Name: "a", Clients: []string{"x"}, Action: probeClose}, wantErr: true},
+		{name: "reply without text", rule: ProbeRule{Name: "a", Pattern: "^GET", Action: probeReply}, wantErr: true},
+		{name: "unknown action", rule: ProbeRule{Name: "a", Pattern: "^GET", Action: "drop"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateProbeRules([]ProbeRule{tt.rule}); (err != nil) != tt.wantErr {
+				t.Errorf("validateProbeRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_probeFilter_check_wait(t *testing.T) {
+	tests := []struct {
+		name     string
+		clients  []string
+		wantWait bool
+	}{
+		{name: "pattern_for_all", wantWait: true},
+		{name: "pattern_for_client", clients: []string{"127.0.0.1"}, wantWait: true},
+		{name: "pattern_for_others", clients: []string{"192.0.2.0/24"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newProbeFilter(&Config{ProbeDetectTimeout: 300, ProbeRules: []ProbeRule{
+				{Name: "http", Pattern: "^GET ", Clients: tt.clients, Action: probeClose},
+			}}, newMetrics(&Config{}), nil)
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			client, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			conn, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			start := time.Now()
+			deadline := start.Add(500 * time.Millisecond)
+			conn.SetDeadline(deadline)
+			got, _ := f.check(conn, deadline)
+			if waited := time.Since(start) >= 300*time.Millisecond; waited != tt.wantWait {
+				t.Errorf("check() waited %s, want wait %v", time.Since(start), tt.wantWait)
+			}
+
+			// read deadline of accept is kept
+			if _, err := got.Read(make([]byte, 1)); err == nil || time.Now().After(deadline.Add(time.Second)) {
+				t.Errorf("read after check() = %v at %s, want timeout at deadline", err, time.Since(start))
+			}
+		})
+	}
+}
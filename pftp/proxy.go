@@ -23,10 +23,13 @@ const (
 	alreadyClosedMsg       = "use of closed"
 )
 
+var errOriginGreetingTimeout = errors.New("origin greeting timeout")
+
 type proxyServer struct {
 	clientReader          *bufio.Reader
 	clientWriter          *bufio.Writer
 	origin                net.Conn
+	originAddr            string
 	originReader          *bufio.Reader
 	originWriter          *bufio.Writer
 	tlsDatas              *tlsDataSet
@@ -38,11 +41,53 @@ type proxyServer struct {
 	stop                  bool
 	isLoggedin            bool
 	welcomeMsg            string
-	config                *config
+	notReadyMsg           string // translated text of 120 greeting, empty to relay origin text
+	timings               *SessionTimings
+	config                *Config
 	dataConnector         *dataHandler
+	dataMutex             sync.Mutex
+	health                *originHealth
+	breaker               *circuitBreaker
+	dynamic               *dynamicConfig
+	dialects              dialectSet // rewrite commands and replies of origin
+	originStats           *originStats
+	dialedAt              time.Time // dial time of origin whose greeting is not read yet
+	capabilities          *capabilityCache
+	redactor              *redactor
+	tail                  *sessionTail
+	loginResult           func(success bool)        // called with result of PASS
+	withNotices           func(reply string) string // add administrative notices to reply
+	events                *eventBus
+	sessionID             uint64
+	slowStart             time.Duration
+	originProxy           string // egress proxy URL to reach origin
+	originDialer          OriginDialer
+	tlsPins               []TLSPin // of session. origin_tls_pins of origin is used when empty
+	pendingDataHandlers   []*dataHandler
 	waitSwitching         chan bool
 	inDataTransfer        *abool.AtomicBool
 	isDataCommandResponse bool
+	inflight              []string      // commands waiting reply from origin in sent order
+	inflightSent          []time.Time   // sent time of commands in inflight
+	inflightReplies       []chan string // replies of commands sent by proxy itself. nil for client commands
+	inflightRetries       []*replyRetry // retries of commands on transient reply. nil when not retried
+	headReplied           bool          // oldest command in flight got preliminary reply
+	timedCommand          string        // command whose timeout is set to origin deadline
+	cwd                   string        // directory of origin tracked by CWD or PWD. empty while it is not known
+	pendingCwds           []string      // targets of CWD waiting reply
+	landings              []landing     // uploads of STOR waiting reply
+	retryCodes            []string      // codes retried for next command
+	originTimeoutMsg      string
+	dataConnectionMsg     string
+	features              []string
+	system                string
+	backendID             string
+	locale                string
+	modeZAnswered         bool // MODE is answered by proxy
+	modeZAdvertised       bool // FEAT of client has MODE Z
+	stateMutex            sync.Mutex
+	originMutex           sync.Mutex // guard originWriter written by client and resume of transfer
+	sequenceMutex         sync.Mutex // hold commands of client while internal sequence like RNFR and RNTO runs
 }
 
 type proxyServerConfig struct {
@@ -50,43 +95,106 @@ type proxyServerConfig struct {
 	clientWriter   *bufio.Writer
 	tlsDatas       *tlsDataSet
 	originAddr     string
+	failoverAddrs  []string
 	mutex          *sync.Mutex
 	log            *logger
-	config         *config
+	config         *Config
 	inDataTransfer *abool.AtomicBool
+	welcomeMsg     string
+	notReadyMsg    string
+	timings        *SessionTimings
+	// replies sent when command timeout expired
+	originTimeoutMsg  string
+	dataConnectionMsg string
+	originProxy       string
+	originDialer      OriginDialer
+	tlsPins           []TLSPin
+	health            *originHealth
+	breaker           *circuitBreaker
+	dynamic           *dynamicConfig
+	dialects          dialectSet
+	originStats       *originStats
+	capabilities      *capabilityCache
+	redactor          *redactor
+	tail              *sessionTail
+	loginResult       func(success bool)
+	withNotices       func(reply string) string
+	events            *eventBus
+	sessionID         uint64
 }
 
 func newProxyServer(conf *proxyServerConfig) (*proxyServer, error) {
-	c, err := net.DialTimeout("tcp",
-		conf.originAddr,
-		time.Duration(connectionTimeout)*time.Second)
+	// connect to origin. if failed, try failover origins in order.
+	// origin whose circuit is open is skipped.
+	var c net.Conn
+	var dialedAt time.Time
+	originAddr := ""
+	err := errOriginCircuitOpen
+	for _, addr := range append([]string{conf.originAddr}, conf.failoverAddrs...) {
+		if !conf.breaker.allow(addr) {
+			conf.log.info("circuit of origin %s is open. skip it", addr)
+			continue
+		}
+
+		dialedAt = time.Now()
+		c, err = dialEgress(conf.originDialer,
+			conf.originProxy,
+			addr,
+			time.Duration(connectionTimeout)*time.Second)
+		conf.originStats.dial(addr, err)
+		conf.breaker.report(addr, err == nil)
+		if err == nil {
+			originAddr = addr
+			break
+		}
+		conf.log.err("cannot connect to origin %s: %s", addr, err.Error())
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// set linger 0 and tcp keepalive setting between origin connection
-	tcpConn := c.(*net.TCPConn)
-	tcpConn.SetKeepAlive(true)
-	tcpConn.SetKeepAlivePeriod(time.Duration(conf.config.KeepaliveTime) * time.Second)
-	tcpConn.SetLinger(0)
+	setOriginSocketOptions(c, time.Duration(conf.config.KeepaliveTime)*time.Second)
+	c = conf.originStats.track(originAddr, c)
 
 	p := &proxyServer{
-		clientReader:   conf.clientReader,
-		clientWriter:   conf.clientWriter,
-		originWriter:   bufio.NewWriter(c),
-		originReader:   bufio.NewReader(c),
-		origin:         tcpConn,
-		tlsDatas:       conf.tlsDatas,
-		passThrough:    true,
-		mutex:          conf.mutex,
-		log:            conf.log,
-		stopChan:       make(chan struct{}),
-		stopChanDone:   make(chan struct{}),
-		welcomeMsg:     "220 " + conf.config.WelcomeMsg + "\r\n",
-		isLoggedin:     false,
-		config:         conf.config,
-		waitSwitching:  make(chan bool),
-		inDataTransfer: conf.inDataTransfer,
+		clientReader:      conf.clientReader,
+		clientWriter:      conf.clientWriter,
+		originWriter:      bufio.NewWriter(c),
+		originReader:      bufio.NewReader(c),
+		origin:            c,
+		originAddr:        originAddr,
+		tlsDatas:          conf.tlsDatas,
+		passThrough:       true,
+		mutex:             conf.mutex,
+		log:               conf.log,
+		stopChan:          make(chan struct{}),
+		stopChanDone:      make(chan struct{}),
+		welcomeMsg:        formatReply(220, conf.welcomeMsg),
+		notReadyMsg:       conf.notReadyMsg,
+		timings:           conf.timings,
+		originTimeoutMsg:  conf.originTimeoutMsg,
+		originProxy:       conf.originProxy,
+		originDialer:      conf.originDialer,
+		tlsPins:           conf.tlsPins,
+		dataConnectionMsg: conf.dataConnectionMsg,
+		isLoggedin:        false,
+		config:            conf.config,
+		waitSwitching:     make(chan bool),
+		inDataTransfer:    conf.inDataTransfer,
+		health:            conf.health,
+		breaker:           conf.breaker,
+		dynamic:           conf.dynamic,
+		dialects:          conf.dialects,
+		originStats:       conf.originStats,
+		dialedAt:          dialedAt,
+		capabilities:      conf.capabilities,
+		redactor:          conf.redactor,
+		tail:              conf.tail,
+		loginResult:       conf.loginResult,
+		withNotices:       conf.withNotices,
+		events:            conf.events,
+		sessionID:         conf.sessionID,
 	}
 
 	p.log.debug("new proxy from=%s to=%s", c.LocalAddr(), c.RemoteAddr())
@@ -135,8 +243,20 @@ func (s *proxyServer) sendToOrigin(line string) error {
 		return err
 	}
 
+	line = s.dialects.command(s.originAddr, line)
+
+	// wait for internal sequence that must not be interleaved
+	s.sequenceMutex.Lock()
+	s.sequenceMutex.Unlock()
+
 	s.commandLog(line)
+	s.pushRetryCommand(strings.ToUpper(getCommand(line)[0]), s.takeReplyRetry(line))
+	if s.inflightCount() == 1 {
+		s.armCommandTimeout()
+	}
 
+	s.originMutex.Lock()
+	defer s.originMutex.Unlock()
 	if _, err := s.originWriter.WriteString(line); err != nil {
 		s.log.err("send to origin error: %s", err.Error())
 		return err
@@ -160,6 +280,7 @@ func (s *proxyServer) sendToClient(line string) error {
 	}
 
 	s.log.debug("send to client: %s", line)
+	s.tail.reply(tailToClient, line)
 	return nil
 }
 
@@ -185,6 +306,8 @@ func (s *proxyServer) Close() error {
 		}
 	}
 
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
 	if s.dataConnector != nil {
 		s.DestroyDataHandler()
 	}
@@ -198,16 +321,119 @@ func (s *proxyServer) GetConn() net.Conn {
 
 // basically, this function never called during data transfer
 // in progress, so block by chan is not necessary.
+// previous data handler is closed and replaced atomically. it stays in
+// pending queue until origin responds to its data command.
 func (s *proxyServer) SetDataHandler(handler *dataHandler) {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+
 	// cleanup previous data connector.
 	if s.dataConnector != nil {
 		s.DestroyDataHandler()
 	}
 
 	s.dataConnector = handler
+	s.pendingDataHandlers = append(s.pendingDataHandlers, handler)
+}
+
+// return current data handler. nil when it is not set.
+func (s *proxyServer) getDataHandler() *dataHandler {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+
+	return s.dataConnector
 }
 
-// Destroy data handler
+// pop data handler waiting origin response to data command.
+// return current data handler too for detect stale one.
+func (s *proxyServer) popPendingDataHandler() (*dataHandler, *dataHandler) {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+
+	if len(s.pendingDataHandlers) == 0 {
+		return s.dataConnector, s.dataConnector
+	}
+
+	handler := s.pendingDataHandlers[0]
+	s.pendingDataHandlers = s.pendingDataHandlers[1:]
+
+	return handler, s.dataConnector
+}
+
+// close data handler and drop it from pending queue.
+// used when its data command never sent to origin.
+func (s *proxyServer) cancelDataHandler(handler *dataHandler) {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+
+	for i, h := range s.pendingDataHandlers {
+		if h == handler {
+			s.pendingDataHandlers = append(s.pendingDataHandlers[:i], s.pendingDataHandlers[i+1:]...)
+			break
+		}
+	}
+
+	handler.Close()
+}
+
+// return count of data handlers waiting origin response
+func (s *proxyServer) pendingDataHandlerCount() int {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+
+	return len(s.pendingDataHandlers)
+}
+
+// make data command response to client by data handler the response belongs to.
+// when handler has replaced by newer data command before origin responded,
+// reply 425 for it because its listener is already closed.
+func (s *proxyServer) dataCommandResponse(buff string) string {
+	handler, current := s.popPendingDataHandler()
+	if handler == nil || handler != current || handler.isClosed() {
+		if handler != nil && handler != current {
+			s.log.debug("data command replaced by newer one. reply 425 for stale one")
+		}
+		return "425 Can't open data connection\r\n"
+	}
+
+	if strings.HasPrefix(buff, "227 ") {
+		if err := handler.parsePASVresponse(buff); err == errUnroutablePassiveIP {
+			s.log.info("reject PASV reply of origin %s: %s", s.originAddr, strings.TrimSpace(buff))
+			handler.Close()
+			return "425 Can't open data connection\r\n"
+		}
+	}
+	if strings.HasPrefix(buff, "229 ") {
+		handler.parseEPSVresponse(buff)
+	}
+
+	switch handler.clientConn.mode {
+	case "PORT", "EPRT":
+		return fmt.Sprintf("200 %s command successful\r\n", handler.clientConn.mode)
+	case "PASV":
+		lPort, ok := handler.clientListenPort()
+		if !ok {
+			return "425 Can't open data connection\r\n"
+		}
+		// prepare PASV response line to client
+		listenPort, _ := strconv.Atoi(lPort)
+		return fmt.Sprintf("227 Entering Passive Mode (%s,%s,%s).\r\n",
+			strings.ReplaceAll(s.config.MasqueradeIP, ".", ","),
+			strconv.Itoa(listenPort/256),
+			strconv.Itoa(listenPort%256))
+	case "EPSV":
+		listenPort, ok := handler.clientListenPort()
+		if !ok {
+			return "425 Can't open data connection\r\n"
+		}
+		// prepare EPSV response line to client
+		return fmt.Sprintf("229 Entering Extended Passive Mode (|||%s|).\r\n", listenPort)
+	}
+
+	return buff
+}
+
+// Destroy data handler. it is called with dataMutex held.
 func (s *proxyServer) DestroyDataHandler() {
 	if s.dataConnector != nil {
 		connectionCloser(s.dataConnector, s.log)
@@ -216,6 +442,9 @@ func (s *proxyServer) DestroyDataHandler() {
 
 // return true when data handler is available now
 func (s *proxyServer) isDataHandlerAvailable() bool {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+
 	if s.dataConnector == nil {
 		return false
 	}
@@ -225,6 +454,9 @@ func (s *proxyServer) isDataHandlerAvailable() bool {
 
 // return true when data transfer in progress
 func (s *proxyServer) isDataTransferStarted() bool {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+
 	if s.dataConnector == nil {
 		return false
 	}
@@ -237,7 +469,11 @@ func (s *proxyServer) isLoggedIn() bool {
 	return s.isLoggedin
 }
 
-func (s *proxyServer) sendProxyHeader(clientAddr string, originAddr string) error {
+func (s *proxyServer) sendProxyHeader(conn net.Conn, clientAddr string, originAddr string) error {
+	if isUnixAddr(originAddr) {
+		originAddr = net.JoinHostPort(unixPeerIP, "0")
+	}
+
 	sourceAddr, sourcePort, err := net.SplitHostPort(clientAddr)
 	if err != nil {
 		return err
@@ -277,7 +513,7 @@ func (s *proxyServer) sendProxyHeader(clientAddr string, originAddr string) erro
 		DestinationAddr:   &net.TCPAddr{IP: net.ParseIP(hostIP[0].String()), Port: destinationPortInt},
 	}
 
-	_, err = proxyProtocolHeader.WriteTo(s.origin)
+	_, err = proxyProtocolHeader.WriteTo(conn)
 	return err
 }
 
@@ -304,7 +540,7 @@ func (s *proxyServer) sendTLSCommand(previousTLSCommands []string) error {
 			s.log.debug("response from origin: %s", strings.TrimSuffix(str, "\r\n"))
 
 			if strings.Compare(strings.ToUpper(getCommand(cmd)[0]), "AUTH") == 0 {
-				code := getCode(str)[0]
+				code := replyCode(str)
 				if code != "234" {
 					// when got 500 PROXY not understood, ignore it
 					// this ignore setting for complex origins.
@@ -320,8 +556,12 @@ func (s *proxyServer) sendTLSCommand(previousTLSCommands []string) error {
 					}
 				} else {
 					// SSL/TLS wrapping on connection
+					s.tlsDatas.forOrigin.setPins(originTLSPins(s.config, s.originAddr, s.tlsPins))
 					tlsConn := tls.Client(s.origin, s.tlsDatas.forOrigin.getTLSConfig())
 					err = tlsConn.Handshake()
+					if errors.Is(err, errOriginPinMismatch) {
+						return fmt.Errorf("TLS handshake with origin has failed: %s", err.Error())
+					}
 					if err != nil {
 						return fmt.Errorf("TLS handshake with origin has failed")
 					}
@@ -343,7 +583,7 @@ func (s *proxyServer) sendTLSCommand(previousTLSCommands []string) error {
 	return lastError
 }
 
-func (s *proxyServer) switchOrigin(clientAddr string, originAddr string, previousTLSCommands []string) error {
+func (s *proxyServer) switchOrigin(clientAddr string, originAddr string, failoverAddrs []string, previousTLSCommands []string) error {
 	// return error when user not found
 	if len(originAddr) == 0 {
 		return fmt.Errorf("user id not found")
@@ -376,37 +616,49 @@ func (s *proxyServer) switchOrigin(clientAddr string, originAddr string, previou
 		s.waitSwitching <- switchResult
 	}()
 
-	// change connection and reset reader and writer buffer
-	s.origin, err = net.DialTimeout("tcp", originAddr, time.Duration(connectionTimeout)*time.Second)
-	if err != nil {
-		return err
+	// connect to origin. if failed, try failover origins in order.
+	// origin in slow start after recovery is skipped when it has no room,
+	// but last candidate is always tried. origin whose circuit is open is skipped.
+	// origin drained by dynamic config is skipped in same way as slow start.
+	addrs := []string{}
+	candidates := append([]string{originAddr}, failoverAddrs...)
+	for i, addr := range candidates {
+		if i < len(candidates)-1 && s.dynamic.isDrained(addr) {
+			s.log.info("origin %s is drained. skip it", addr)
+			continue
+		}
+		if i < len(candidates)-1 && !s.health.admit(addr, s.slowStart) {
+			s.log.info("origin %s is in slow start. skip it", addr)
+			continue
+		}
+		if !s.breaker.allow(addr) {
+			s.log.info("circuit of origin %s is open. skip it", addr)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return errOriginCircuitOpen
 	}
-	s.originReader = bufio.NewReader(s.origin)
-	s.originWriter = bufio.NewWriter(s.origin)
 
-	// Send proxy protocol v1 header when set proxy protocol true
-	if s.config.ProxyProtocol {
-		s.log.debug("send proxy protocol to origin")
-		if err := s.sendProxyHeader(clientAddr, originAddr); err != nil {
-			return err
+	var o *originConnection
+	if s.config.ParallelConnectDelay > 0 && len(addrs) > 1 {
+		o, err = s.dialOriginsParallel(clientAddr, addrs, time.Duration(s.config.ParallelConnectDelay)*time.Millisecond)
+	} else {
+		for _, addr := range addrs {
+			o, err = s.dialOrigin(clientAddr, addr)
+			s.health.report(addr, err == nil)
+			s.breaker.report(addr, err == nil)
+			if err == nil {
+				break
+			}
+			s.log.err("cannot connect to origin %s: %s", addr, err.Error())
 		}
 	}
-
-	// Read welcome message from ftp connection
-	res, err := s.originReader.ReadString('\n')
 	if err != nil {
-		return errors.New("cannot connect to new origin server")
+		return err
 	}
-
-	s.log.debug("response from new origin: %s", strings.TrimSuffix(res, "\r\n"))
-
-	// set linger 0 and tcp keepalive setting between switched origin connection
-	tcpConn := s.origin.(*net.TCPConn)
-	tcpConn.SetKeepAlive(true)
-	tcpConn.SetKeepAlivePeriod(time.Duration(s.config.KeepaliveTime) * time.Second)
-	tcpConn.SetLinger(0)
-
-	s.origin = tcpConn
+	s.useOrigin(o)
 
 	// If client connect with TLS connection, make TLS connection to origin ftp server too.
 	if err := s.sendTLSCommand(previousTLSCommands); err != nil {
@@ -419,6 +671,39 @@ func (s *proxyServer) switchOrigin(clientAddr string, originAddr string, previou
 	return lastError
 }
 
+// connect to origin and read welcome message
+func (s *proxyServer) connectOrigin(clientAddr string, originAddr string) error {
+	o, err := s.dialOrigin(clientAddr, originAddr)
+	if err != nil {
+		return err
+	}
+	s.useOrigin(o)
+
+	return nil
+}
+
+// change connection and reset reader and writer buffer
+func (s *proxyServer) useOrigin(o *originConnection) {
+	s.origin = o.conn
+	s.originReader = o.reader
+	s.originWriter = bufio.NewWriter(o.conn)
+	s.originAddr = o.addr
+
+	// new origin has no command in flight and features are unknown
+	s.stateMutex.Lock()
+	s.inflight = nil
+	s.inflightSent = nil
+	s.inflightReplies = nil
+	s.inflightRetries = nil
+	s.cwd = ""
+	s.pendingCwds = nil
+	s.landings = nil
+	s.headReplied = false
+	s.features = nil
+	s.system = ""
+	s.stateMutex.Unlock()
+}
+
 func (s *proxyServer) startProxy() error {
 	// return if proxy still unsuspended or s.stop is true
 	if s.stop || !s.passThrough {
@@ -433,122 +718,35 @@ func (s *proxyServer) startProxy() error {
 
 	go func() {
 		for {
-			s.isDataCommandResponse = false
-			buff, err := s.originReader.ReadString('\n')
+			buff, err := s.readOriginReply()
 			if err != nil {
+				s.closeInternalReplies()
 				if !s.stop {
+					if command, ok := s.timedOutCommand(err); ok {
+						s.replyCommandTimeout(command)
+					}
 					safeSetChanel(errchan, err)
 				}
 				break
-			} else {
-				if s.config.ProxyTimeout > 0 {
-					// do not time out during transfer data
-					if s.inDataTransfer.IsSet() {
-						s.origin.SetDeadline(time.Time{})
-					} else {
-						s.origin.SetDeadline(time.Now().Add(time.Duration(s.config.ProxyTimeout) * time.Second))
-					}
-				}
-
-				s.log.debug("response from origin: %s", strings.TrimSuffix(buff, "\r\n"))
-
-				// response user setted welcome message
-				if strings.Compare(getCode(buff)[0], "220") == 0 && !s.isLoggedin {
-					buff = s.welcomeMsg
-				}
-
-				// check login and switch origin success
-				if strings.Compare(getCode(buff)[0], "230") == 0 {
-					s.isLoggedin = true
-				}
-
-				// when got 500 PROXY not understood, ignore it
-				// this ignore setting for complex origins.
-				// if some origins needs proxy protocol and some else is not,
-				// pftp cannot support both in same time. So, pftp ignore the
-				// 500 PROXY not understood then client can connect any servers.
-				if s.config.ProxyProtocol && strings.Contains(buff, "500 PROXY") {
-					continue
-				}
-
-				// is data channel proxy used
-				if s.config.DataChanProxy && s.isLoggedin {
-					if strings.HasPrefix(buff, "227 ") {
-						s.isDataCommandResponse = true
-						s.dataConnector.parsePASVresponse(buff)
-					}
-					if strings.HasPrefix(buff, "229 ") {
-						s.isDataCommandResponse = true
-						s.dataConnector.parseEPSVresponse(buff)
-					}
-					if strings.HasPrefix(buff, "200 PORT command successful") {
-						s.isDataCommandResponse = true
-					}
-
-					// when got 150 from origin, it means data transfer has started
-					// set transfer in progress flag to 1
-					if strings.HasPrefix(buff, "150 ") {
-						s.inDataTransfer.Set()
-					}
-
-					// when got 226 from origin, it means data transfer finished
-					// set data transfer in p rogress flag to 0 for accept next data transfers
-					if strings.HasPrefix(buff, "226 ") {
-						s.inDataTransfer.UnSet()
-					}
+			}
 
-					if s.isDataCommandResponse {
-						if s.isDataHandlerAvailable() {
-							switch s.dataConnector.clientConn.mode {
-							case "PORT", "EPRT":
-								buff = fmt.Sprintf("200 %s command successful\r\n", s.dataConnector.clientConn.mode)
-							case "PASV":
-								// prepare PASV response line to client
-								_, lPort, _ := net.SplitHostPort(s.dataConnector.clientConn.listener.Addr().String())
-								listenPort, _ := strconv.Atoi(lPort)
-								buff = fmt.Sprintf("227 Entering Passive Mode (%s,%s,%s).\r\n",
-									strings.ReplaceAll(s.config.MasqueradeIP, ".", ","),
-									strconv.Itoa(listenPort/256),
-									strconv.Itoa(listenPort%256))
-							case "EPSV":
-								// prepare EPSV response line to client
-								_, listenPort, _ := net.SplitHostPort(s.dataConnector.clientConn.listener.Addr().String())
-								buff = fmt.Sprintf("229 Entering Extended Passive Mode (|||%s|).\r\n", listenPort)
-							}
-						} else {
-							buff = "425 Can't open data connection\r\n"
-						}
-					}
+			if s.config.ProxyTimeout > 0 {
+				// do not time out during transfer data
+				if s.inDataTransfer.IsSet() {
+					s.origin.SetDeadline(time.Time{})
+				} else {
+					s.origin.SetDeadline(time.Now().Add(time.Duration(s.config.ProxyTimeout) * time.Second))
 				}
+			}
 
-				// handling multi-line response
-				if len(buff) >= 4 && buff[3] == '-' {
-					params := getCode(buff)
-					multiLine := buff
-
-					for {
-						res, err := s.originReader.ReadString('\n')
-						if err != nil {
-							safeSetChanel(errchan, err)
-							done <- struct{}{}
-							return
-						}
-
-						// store multi-line response
-						multiLine += res
-
-						// check multi-line end
-						if getCode(res)[0] == params[0] && res[3] == ' ' {
-							buff = multiLine
-							break
-						}
-					}
-				}
+			s.tail.reply(tailFromOrigin, buff)
+			buff, forward := s.processOriginReply(buff)
 
-				if s.passThrough {
-					read <- buff
-					<-send
-				}
+			// next command waiting reply may have its own timeout
+			s.armCommandTimeout()
+			if forward && s.passThrough {
+				read <- buff
+				<-send
 			}
 		}
 		done <- struct{}{}
@@ -584,13 +782,447 @@ loop:
 	return lastError
 }
 
+// read one reply from origin. multi-line reply is read until last line.
+func (s *proxyServer) readOriginReply() (string, error) {
+	buff, err := readReplyMode(s.originReader, s.lenientReplies())
+	if err != nil {
+		return "", err
+	}
+
+	s.log.debug("response from origin: %s", strings.TrimSuffix(buff, "\r\n"))
+
+	return buff, nil
+}
+
+// read one reply positionally. multi-line reply is read until its last line
+func readReplyLenient(reader *bufio.Reader) (string, error) {
+	buff, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	// handling multi-line response
+	if len(buff) >= 4 && buff[3] == '-' {
+		code := replyCode(buff)
+		for {
+			res, err := reader.ReadString('\n')
+			if err != nil {
+				return "", err
+			}
+
+			// store multi-line response
+			buff += res
+
+			// check multi-line end
+			if len(res) >= 4 && replyCode(res) == code && res[3] == ' ' {
+				break
+			}
+		}
+	}
+
+	return buff, nil
+}
+
+// check reply from origin by command it belongs to and rewrite it if needed.
+// return false when reply should not be sent to client.
+func (s *proxyServer) processOriginReply(buff string) (string, bool) {
+	s.isDataCommandResponse = false
+	r := parseFTPReply(buff)
+
+	// when got 500 PROXY not understood, ignore it
+	// this ignore setting for complex origins.
+	// if some origins needs proxy protocol and some else is not,
+	// pftp cannot support both in same time. So, pftp ignore the
+	// 500 PROXY not understood then client can connect any servers.
+	if s.config.ProxyProtocol && r.Code == "500" && strings.HasPrefix(r.Text, "PROXY") {
+		return buff, false
+	}
+
+	// replies are matched with in-flight commands in order.
+	// final reply (not 1xx) completes the command.
+	command := s.currentCommand()
+
+	// reply of non-standard origin is rewritten before proxy handles it
+	if len(s.dialects) > 0 {
+		buff = s.dialects.reply(s.originAddr, command, buff)
+		r = parseFTPReply(buff)
+	}
+
+	if len(command) == 0 && !(r.Code == "220" && !s.isLoggedin) {
+		return s.unsolicitedReply(buff, r.Code)
+	}
+	// 421 followed by close is notice of origin, and command in flight
+	// is not replied by it
+	if r.Code == "421" && s.originClosed() {
+		return s.unsolicitedReply(buff, r.Code)
+	}
+	if replies := s.currentReplies(); replies != nil {
+		if r.preliminary() {
+			s.setHeadReplied()
+		} else {
+			s.popCommand()
+		}
+		select {
+		case replies <- buff:
+		default:
+		}
+		return buff, false
+	}
+	// transient reply is retried before client sees it
+	if retry := s.shouldRetry(r.Code); retry != nil {
+		s.retryCommand(retry, r.Code)
+		return buff, false
+	}
+
+	if !r.preliminary() {
+		if isCwdCommand(command) {
+			s.finishCwd(r.completed())
+		}
+		latency, preliminary := s.popCommand()
+		if len(command) > 0 && !preliminary {
+			// transfer commands reply after data transfer
+			s.timings.Add(timingOriginRTT, latency)
+		}
+		if len(command) > 0 {
+			n, _ := strconv.Atoi(r.Code)
+			s.events.emit(&CommandReplyEvent{
+				Time:        time.Now(),
+				SessionID:   s.sessionID,
+				Origin:      s.originAddr,
+				Command:     command,
+				Code:        n,
+				Preliminary: preliminary,
+				Latency:     latency,
+			})
+		}
+
+		// RETR is resumed on new origin data connection
+		if s.config.DataChanProxy && s.holdTransferError(command, buff, preliminary) {
+			return buff, false
+		}
+
+		// striped RETR is replied after all stripes are sent to client
+		if s.config.DataChanProxy && s.holdStripedReply(command, buff) {
+			return buff, false
+		}
+	} else {
+		s.setHeadReplied()
+	}
+
+	// origin starting up before login is told by proxy's text
+	if r.Code == "120" && !s.isLoggedin && len(s.notReadyMsg) > 0 {
+		buff = formatReply(120, s.notReadyMsg)
+	}
+
+	// response user setted welcome message
+	if r.Code == "220" && !s.isLoggedin {
+		buff = s.welcomeMsg
+
+		// greeting of first origin is read here
+		if !s.dialedAt.IsZero() {
+			s.originStats.greeting(s.originAddr, time.Since(s.dialedAt))
+			s.dialedAt = time.Time{}
+		}
+	}
+
+	// check login and switch origin success
+	loginReply := false
+	if r.Code == "230" {
+		loginReply = !s.isLoggedin
+		s.isLoggedin = true
+	}
+
+	// report login result for brute-force protection
+	if command == "PASS" && s.loginResult != nil {
+		if r.Code == "230" {
+			s.loginResult(true)
+		} else if r.Code == "530" {
+			s.loginResult(false)
+		}
+	}
+
+	// is data channel proxy used
+	if s.config.DataChanProxy && s.isLoggedin {
+		if isDataCommand(command) {
+			if r.completed() {
+				s.isDataCommandResponse = true
+			} else if r.failed() && s.pendingDataHandlerCount() > 0 {
+				// origin refused data command. drop its handler from pending queue
+				s.popPendingDataHandler()
+			}
+		}
+
+		// when got 150 from origin, it means data transfer has started
+		// set transfer in progress flag to 1
+		if r.Code == "150" {
+			s.inDataTransfer.Set()
+		}
+
+		// when got 226 from origin, it means data transfer finished
+		// set data transfer in p rogress flag to 0 for accept next data transfers
+		if r.Code == "226" {
+			s.inDataTransfer.UnSet()
+		}
+
+		if s.isDataCommandResponse {
+			buff = s.dataCommandResponse(buff)
+		}
+	}
+
+	// show backend ID in login reply
+	if loginReply {
+		if id := s.getBackendID(); len(id) > 0 {
+			buff = "230-Backend: " + id + "\r\n" + buff
+		}
+	}
+
+	// store origin features from FEAT response
+	if r.Code == "211" && command == "FEAT" {
+		features := parseFeatures(buff)
+		s.setFeatures(features)
+		s.capabilities.set(s.originAddr, features, "")
+
+		// advertise LANG answered by proxy when origin does not support it
+		if len(s.config.Locales) > 0 && !hasFeature(features, "LANG") {
+			buff = addFeature(buff, langFeature(s.config.Locales, s.getLocale()))
+		}
+		buff = s.modeZFeature(buff, features)
+	}
+
+	// upload is verified and renamed from temporary name before reply is sent to client
+	if command == "STOR" && !r.preliminary() {
+		if l := s.popLanding(); (len(l.temp) > 0 || l.check != nil) && (r.Code == "226" || r.Code == "250") {
+			go s.finishLanding(l, buff)
+			return buff, false
+		}
+	}
+
+	// administrative notices are sent at command boundary
+	if len(command) > 0 && s.withNotices != nil {
+		buff = s.withNotices(buff)
+	}
+
+	return buff, true
+}
+
+// handle reply sent by origin without command by unsolicited_replies config
+func (s *proxyServer) unsolicitedReply(buff string, code string) (string, bool) {
+	action := unsolicitedAction(s.config.UnsolicitedReplies, code)
+	message := strings.TrimRight(buff, "\r\n")
+
+	s.log.info("unsolicited reply from origin: %s (%s)", message, action)
+	s.events.emit(&OriginNoticeEvent{
+		Time:      time.Now(),
+		SessionID: s.sessionID,
+		Origin:    s.originAddr,
+		Code:      code,
+		Message:   message,
+		Action:    action,
+	})
+
+	switch action {
+	case unsolicitedSuppress:
+		return buff, false
+	case unsolicitedTransform:
+		text, err := formatUnsolicitedReply(s.config.UnsolicitedReplyMsg, code, replyText(buff))
+		if err != nil {
+			s.log.err("cannot transform unsolicited reply: %s", err.Error())
+			return buff, true
+		}
+		code, _ := strconv.Atoi(code)
+		return formatReply(code, text), true
+	}
+
+	return buff, true
+}
+
+// add command waiting reply from origin
+func (s *proxyServer) pushCommand(command string) {
+	s.pushEntry(command, nil, nil)
+}
+
+// add command of client whose transient reply is retried by retry
+func (s *proxyServer) pushRetryCommand(command string, retry *replyRetry) {
+	s.pushEntry(command, nil, retry)
+}
+
+// add command whose replies are sent to replies instead of client
+func (s *proxyServer) pushInternalCommand(command string, replies chan string) {
+	s.pushEntry(command, replies, nil)
+}
+
+func (s *proxyServer) pushEntry(command string, replies chan string, retry *replyRetry) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	for len(s.inflightReplies) < len(s.inflight) {
+		s.inflightReplies = append(s.inflightReplies, nil)
+	}
+	for len(s.inflightRetries) < len(s.inflight) {
+		s.inflightRetries = append(s.inflightRetries, nil)
+	}
+	s.inflight = append(s.inflight, command)
+	s.inflightSent = append(s.inflightSent, time.Now())
+	s.inflightReplies = append(s.inflightReplies, replies)
+	s.inflightRetries = append(s.inflightRetries, retry)
+}
+
+// return reply channel of oldest command. nil when it is client command.
+func (s *proxyServer) currentReplies() chan string {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	if len(s.inflightReplies) == 0 || len(s.inflightReplies) != len(s.inflight) {
+		return nil
+	}
+	return s.inflightReplies[0]
+}
+
+// close reply channels of commands sent by proxy when origin connection is closed
+func (s *proxyServer) closeInternalReplies() {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	for i, replies := range s.inflightReplies {
+		if replies != nil {
+			close(replies)
+			s.inflightReplies[i] = nil
+		}
+	}
+}
+
+// return oldest command waiting reply. empty when no command is in flight.
+func (s *proxyServer) currentCommand() string {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	if len(s.inflight) == 0 {
+		return ""
+	}
+	return s.inflight[0]
+}
+
+// mark oldest command got preliminary reply
+func (s *proxyServer) setHeadReplied() {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.headReplied = true
+}
+
+// remove oldest command waiting reply. return time since it was sent
+// and whether it got preliminary reply.
+func (s *proxyServer) popCommand() (time.Duration, bool) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	preliminary := s.headReplied
+	s.headReplied = false
+	if len(s.inflight) == 0 {
+		return 0, preliminary
+	}
+
+	s.inflight = s.inflight[1:]
+	sent := s.inflightSent[0]
+	s.inflightSent = s.inflightSent[1:]
+	if len(s.inflightReplies) > 0 {
+		s.inflightReplies = s.inflightReplies[1:]
+	}
+	if len(s.inflightRetries) > 0 {
+		s.inflightRetries = s.inflightRetries[1:]
+	}
+
+	return time.Since(sent), preliminary
+}
+
+// return count of commands waiting reply
+func (s *proxyServer) inflightCount() int {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	return len(s.inflight)
+}
+
+func (s *proxyServer) setFeatures(features []string) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.features = features
+}
+
+func (s *proxyServer) setSystem(system string) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.system = system
+}
+
+func (s *proxyServer) getSystem() string {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	return s.system
+}
+
+func (s *proxyServer) setBackendID(id string) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.backendID = id
+}
+
+func (s *proxyServer) getBackendID() string {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	return s.backendID
+}
+
+func (s *proxyServer) setLocale(locale string) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.locale = locale
+}
+
+func (s *proxyServer) getLocale() string {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	return s.locale
+}
+
+// return copy of origin features
+func (s *proxyServer) getFeatures() []string {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	if s.features == nil {
+		return nil
+	}
+	return append([]string{}, s.features...)
+}
+
+// parse multi-line FEAT response to feature list
+// ex) "211-Features:\r\n EPSV\r\n UTF8\r\n211 End\r\n" -> ["EPSV", "UTF8"]
+func parseFeatures(response string) []string {
+	features := []string{}
+	for _, line := range strings.Split(response, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			continue
+		}
+		if feature := strings.TrimSpace(line); len(feature) > 0 {
+			features = append(features, feature)
+		}
+	}
+
+	return features
+}
+
 // Hide parameters from log
 func (s *proxyServer) commandLog(line string) {
-	if strings.Compare(strings.ToUpper(getCommand(line)[0]), secureCommand) == 0 {
-		s.log.debug("send to origin: %s ********", secureCommand)
-	} else {
-		s.log.debug("send to origin: %s", strings.TrimSuffix(line, "\r\n"))
+	s.log.debug("send to origin: %s", s.redactor.line(line))
+	s.tail.command(tailToOrigin, line)
+}
+
+// return true when reply is transient or permanent negative reply
+func isErrorReply(line string) bool {
+	return len(line) >= 3 && (line[0] == '4' || line[0] == '5')
+}
+
+// return true when command makes data connection
+func isDataCommand(command string) bool {
+	switch command {
+	case "PORT", "EPRT", "PASV", "EPSV":
+		return true
 	}
+
+	return false
 }
 
 // split response line
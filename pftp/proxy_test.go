@@ -0,0 +1,445 @@
+package pftp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+func Test_parseFeatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     []string
+	}{
+		{
+			name:     "multi_line",
+			response: "211-Features:\r\n EPSV\r\n UTF8\r\n MLST type*;size*;\r\n211 End\r\n",
+			want:     []string{"EPSV", "UTF8", "MLST type*;size*;"},
+		},
+		{
+			name:     "no_features",
+			response: "211 No features\r\n",
+			want:     []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseFeatures(tt.response); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFeatures() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_proxyServer_connectOrigin_greeting_timeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// accept connection but never send welcome message
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		time.Sleep(2 * time.Second)
+	}()
+
+	s := &proxyServer{
+		config: &Config{OriginGreetingTimeout: 1},
+		log:    &logger{},
+	}
+	if err := s.connectOrigin("127.0.0.1:10000", l.Addr().String()); err != errOriginGreetingTimeout {
+		t.Errorf("proxyServer.connectOrigin() error = %v, want %v", err, errOriginGreetingTimeout)
+	}
+}
+
+func Test_newProxyServer_failover(t *testing.T) {
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	p, err := newProxyServer(&proxyServerConfig{
+		originAddr:    down.Addr().String(),
+		failoverAddrs: []string{l.Addr().String()},
+		config:        &Config{},
+		log:           &logger{},
+	})
+	if err != nil {
+		t.Fatalf("newProxyServer() error = %v", err)
+	}
+	defer p.origin.Close()
+	if p.originAddr != l.Addr().String() {
+		t.Errorf("proxyServer.originAddr = %s, want %s", p.originAddr, l.Addr().String())
+	}
+}
+
+func Test_proxyServer_dataCommandResponse(t *testing.T) {
+	// steps are data commands sent by client ("PORT") or replies from origin
+	tests := []struct {
+		name  string
+		steps []string
+		want  []string
+	}{
+		{
+			name:  "single_port",
+			steps: []string{"PORT", "200 PORT command successful\r\n"},
+			want:  []string{"200 PORT command successful\r\n"},
+		},
+		{
+			name:  "rapid_fire_port",
+			steps: []string{"PORT", "PORT", "200 PORT command successful\r\n", "200 PORT command successful\r\n"},
+			want:  []string{"425 Can't open data connection\r\n", "200 PORT command successful\r\n"},
+		},
+		{
+			name:  "replaced_after_reply",
+			steps: []string{"PORT", "200 PORT command successful\r\n", "PORT", "200 PORT command successful\r\n"},
+			want:  []string{"200 PORT command successful\r\n", "200 PORT command successful\r\n"},
+		},
+		{
+			name:  "triple_port",
+			steps: []string{"PORT", "PORT", "PORT", "200 PORT command successful\r\n", "200 PORT command successful\r\n", "200 PORT command successful\r\n"},
+			want:  []string{"425 Can't open data connection\r\n", "425 Can't open data connection\r\n", "200 PORT command successful\r\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &proxyServer{
+				config: &Config{},
+				log:    &logger{},
+			}
+
+			var got []string
+			for _, step := range tt.steps {
+				if step == "PORT" {
+					handler, err := newDataHandler(&Config{}, &logger{}, nil, nil, step, nil, abool.New(), abool.New(), nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					s.SetDataHandler(handler)
+					continue
+				}
+				got = append(got, s.dataCommandResponse(step))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("proxyServer.dataCommandResponse() = %q, want %q", got, tt.want)
+			}
+			if n := s.pendingDataHandlerCount(); n != 0 {
+				t.Errorf("pending data handlers = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func Test_proxyServer_cancelDataHandler(t *testing.T) {
+	s := &proxyServer{
+		config: &Config{},
+		log:    &logger{},
+	}
+
+	first, _ := newDataHandler(&Config{}, &logger{}, nil, nil, "PORT", nil, abool.New(), abool.New(), nil)
+	s.SetDataHandler(first)
+	s.cancelDataHandler(first)
+
+	second, _ := newDataHandler(&Config{}, &logger{}, nil, nil, "PORT", nil, abool.New(), abool.New(), nil)
+	s.SetDataHandler(second)
+
+	if got := s.dataCommandResponse("200 PORT command successful\r\n"); got != "200 PORT command successful\r\n" {
+		t.Errorf("proxyServer.dataCommandResponse() = %q, want 200 reply for current handler", got)
+	}
+	if !first.isClosed() {
+		t.Errorf("canceled data handler is not closed")
+	}
+}
+
+// start fake origin sending welcome message after delay
+func startDelayedOrigin(t *testing.T, delay time.Duration) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				time.Sleep(delay)
+				conn.Write([]byte("220 ready\r\n"))
+				time.Sleep(time.Second)
+			}()
+		}
+	}()
+
+	return l
+}
+
+func Test_proxyServer_dialOriginsParallel(t *testing.T) {
+	slow := startDelayedOrigin(t, 800*time.Millisecond)
+	defer slow.Close()
+	fast := startDelayedOrigin(t, 0)
+	defer fast.Close()
+
+	// closed listener address refuses connection
+	refused := startDelayedOrigin(t, 0)
+	refusedAddr := refused.Addr().String()
+	refused.Close()
+
+	tests := []struct {
+		name  string
+		addrs []string
+		want  string
+	}{
+		{
+			name:  "slow_first",
+			addrs: []string{slow.Addr().String(), fast.Addr().String()},
+			want:  fast.Addr().String(),
+		},
+		{
+			name:  "refused_first",
+			addrs: []string{refusedAddr, fast.Addr().String()},
+			want:  fast.Addr().String(),
+		},
+		{
+			name:  "fast_first",
+			addrs: []string{fast.Addr().String(), slow.Addr().String()},
+			want:  fast.Addr().String(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &proxyServer{
+				config: &Config{OriginGreetingTimeout: 5},
+				log:    &logger{},
+			}
+
+			start := time.Now()
+			o, err := s.dialOriginsParallel("127.0.0.1:10000", tt.addrs, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer o.conn.Close()
+
+			if o.addr != tt.want {
+				t.Errorf("proxyServer.dialOriginsParallel() addr = %s, want %s", o.addr, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("proxyServer.dialOriginsParallel() took %s, want faster than slow origin", elapsed)
+			}
+		})
+	}
+}
+
+func Test_proxyServer_dialOriginsParallel_stale_failure(t *testing.T) {
+	// first origin fails after second one started
+	failing, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer failing.Close()
+	go func() {
+		for {
+			conn, err := failing.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				time.Sleep(400 * time.Millisecond)
+				conn.Close()
+			}()
+		}
+	}()
+	slow := startDelayedOrigin(t, 2*time.Second)
+	defer slow.Close()
+	fast := startDelayedOrigin(t, 0)
+	defer fast.Close()
+
+	s := &proxyServer{
+		config: &Config{OriginGreetingTimeout: 5},
+		log:    &logger{},
+	}
+	start := time.Now()
+	o, err := s.dialOriginsParallel("127.0.0.1:10000", []string{failing.Addr().String(), slow.Addr().String(), fast.Addr().String()}, 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.conn.Close()
+
+	// third dial waits delay after second one, not failure of first one
+	if elapsed := time.Since(start); o.addr != fast.Addr().String() || elapsed < 550*time.Millisecond {
+		t.Errorf("proxyServer.dialOriginsParallel() = %s after %s, want %s after delay", o.addr, elapsed, fast.Addr().String())
+	}
+}
+
+func Test_proxyServer_dialOriginsParallel_all_failed(t *testing.T) {
+	l := startDelayedOrigin(t, 0)
+	addr := l.Addr().String()
+	l.Close()
+
+	s := &proxyServer{
+		config: &Config{},
+		log:    &logger{},
+	}
+	if _, err := s.dialOriginsParallel("127.0.0.1:10000", []string{addr, addr}, 100*time.Millisecond); err == nil {
+		t.Errorf("proxyServer.dialOriginsParallel() error = nil, want error")
+	}
+}
+
+func Test_proxyServer_processOriginReply_pipelined(t *testing.T) {
+	clientConn, clientPeer := net.Pipe()
+	defer clientConn.Close()
+	defer clientPeer.Close()
+	originConn, originPeer := net.Pipe()
+	defer originConn.Close()
+	defer originPeer.Close()
+
+	config := &Config{DataChanProxy: true, TransferMode: "PASV", MasqueradeIP: "127.0.0.1"}
+	s := &proxyServer{
+		config:         config,
+		log:            &logger{},
+		isLoggedin:     true,
+		inDataTransfer: abool.New(),
+	}
+
+	handler, err := newDataHandler(config, &logger{}, clientConn, originConn, "PASV", nil, abool.New(), s.inDataTransfer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	lPort, _ := handler.clientListenPort()
+	listenPort, _ := strconv.Atoi(lPort)
+	pasvReply := fmt.Sprintf("227 Entering Passive Mode (127,0,0,1,%d,%d).\r\n", listenPort/256, listenPort%256)
+
+	// client pipelined PASV and STOR, then sent QUIT during transfer
+	s.SetDataHandler(handler)
+	s.pushCommand("PASV")
+	s.pushCommand("STOR")
+
+	steps := []struct {
+		send     string
+		reply    string
+		want     string
+		inflight int
+	}{
+		{reply: "227 Entering Passive Mode (10,0,0,1,100,10).\r\n", want: pasvReply, inflight: 1},
+		{reply: "150 Ok to send data.\r\n", want: "150 Ok to send data.\r\n", inflight: 1},
+		{send: "QUIT", reply: "226 Transfer complete.\r\n", want: "226 Transfer complete.\r\n", inflight: 1},
+		{reply: "221 Goodbye.\r\n", want: "221 Goodbye.\r\n", inflight: 0},
+	}
+	for i, step := range steps {
+		if len(step.send) > 0 {
+			s.pushCommand(step.send)
+		}
+
+		got, forward := s.processOriginReply(step.reply)
+		if !forward || got != step.want {
+			t.Errorf("step %d: proxyServer.processOriginReply() = %q, %v, want %q", i, got, forward, step.want)
+		}
+		if n := s.inflightCount(); n != step.inflight {
+			t.Errorf("step %d: in-flight commands = %d, want %d", i, n, step.inflight)
+		}
+	}
+
+	if s.inDataTransfer.IsSet() {
+		t.Errorf("data transfer flag is set after 226")
+	}
+}
+
+func Test_proxyServer_processOriginReply_pipelined_feat(t *testing.T) {
+	s := &proxyServer{
+		config:         &Config{},
+		log:            &logger{},
+		inDataTransfer: abool.New(),
+	}
+	s.pushCommand("FEAT")
+	s.pushCommand("PWD")
+
+	s.processOriginReply("211-Features:\r\n EPSV\r\n211 End\r\n")
+	s.processOriginReply("257 \"/\" is current directory\r\n")
+
+	if got := s.getFeatures(); !reflect.DeepEqual(got, []string{"EPSV"}) {
+		t.Errorf("proxyServer.getFeatures() = %v, want [EPSV]", got)
+	}
+	if n := s.inflightCount(); n != 0 {
+		t.Errorf("in-flight commands = %d, want 0", n)
+	}
+}
+
+func Test_proxyServer_readOriginReply(t *testing.T) {
+	s := &proxyServer{
+		log:          &logger{},
+		originReader: bufio.NewReader(strings.NewReader("211-Features:\r\n EPSV\r\n211 End\r\n257 \"/\"\r\n")),
+	}
+
+	for _, want := range []string{"211-Features:\r\n EPSV\r\n211 End\r\n", "257 \"/\"\r\n"} {
+		got, err := s.readOriginReply()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("proxyServer.readOriginReply() = %q, want %q", got, want)
+		}
+	}
+}
+
+func Test_proxyServer_processOriginReply_events(t *testing.T) {
+	s := &proxyServer{
+		config:         &Config{},
+		log:            &logger{},
+		inDataTransfer: abool.New(),
+		events:         newEventBus(),
+		originAddr:     "origin:21",
+		sessionID:      7,
+	}
+	s.pushCommand("RETR")
+	s.pushCommand("PWD")
+
+	for _, reply := range []string{"150 Opening data connection.\r\n", "226 Transfer complete.\r\n", "550 Permission denied.\r\n"} {
+		s.processOriginReply(reply)
+	}
+
+	want := []CommandReplyEvent{
+		{SessionID: 7, Origin: "origin:21", Command: "RETR", Code: 226, Preliminary: true},
+		{SessionID: 7, Origin: "origin:21", Command: "PWD", Code: 550},
+	}
+	for i, w := range want {
+		e := (<-s.events.ch).(*CommandReplyEvent)
+		if e.Latency <= 0 {
+			t.Errorf("event %d: latency = %v, want positive", i, e.Latency)
+		}
+		e.Time, e.Latency = time.Time{}, 0
+		if *e != w {
+			t.Errorf("event %d = %+v, want %+v", i, *e, w)
+		}
+	}
+	if len(s.events.ch) > 0 {
+		t.Errorf("unexpected event %+v", <-s.events.ch)
+	}
+}
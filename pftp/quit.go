@@ -0,0 +1,45 @@
+package pftp
+
+import "time"
+
+// policies of quit_during_transfer
+const (
+	quitFinishTransfer = "finish"
+	quitAbortTransfer  = "abort"
+)
+
+var quitPollInterval = 100 * time.Millisecond
+
+// return true while data is transferred or transfer command waits final reply
+func (s *proxyServer) transferInFlight() bool {
+	return s.isDataTransferStarted() || isTransferCommand(s.currentCommand())
+}
+
+// hold QUIT sent during transfer until origin replied to transfer command,
+// so client gets 226 (or 426 of aborted transfer) before 221. origin may
+// close connection by QUIT at once and lose end of transfer and its reply.
+// "finish" lets transfer complete as RFC 959 says and "abort" closes data
+// connection first. wait is up to transfer_timeout.
+func (c *clientHandler) quitDuringTransfer() {
+	if c.proxy == nil || !c.proxy.transferInFlight() {
+		return
+	}
+
+	policy := quitFinishTransfer
+	if len(c.config.QuitDuringTransfer) > 0 {
+		policy = c.config.QuitDuringTransfer
+	}
+	c.log.info("QUIT during transfer. %s transfer before closing session", policy)
+	c.metrics.inc("pftp_quit_during_transfer_total", "QUIT sent by clients during transfers.", "action", policy)
+
+	if policy == quitAbortTransfer {
+		c.proxy.abortResume()
+		c.proxy.abortStripes()
+		c.proxy.abortDataTransfer()
+	}
+
+	deadline := time.Now().Add(time.Duration(c.config.TransferTimeout) * time.Second)
+	for c.proxy.transferInFlight() && time.Now().Before(deadline) {
+		time.Sleep(quitPollInterval)
+	}
+}
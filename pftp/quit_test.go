@@ -0,0 +1,68 @@
+package pftp
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+func Test_clientHandler_quitDuringTransfer(t *testing.T) {
+	quitPollInterval = time.Millisecond
+	defer func() { quitPollInterval = 100 * time.Millisecond }()
+
+	tests := []struct {
+		name        string
+		policy      string
+		timeout     int
+		inflight    string
+		started     bool
+		wantWait    bool
+		wantAborted bool
+	}{
+		{name: "no transfer", inflight: "CWD", wantWait: false},
+		{name: "finish", policy: quitFinishTransfer, timeout: 10, inflight: "RETR", started: true, wantWait: true},
+		{name: "default is finish", timeout: 10, inflight: "STOR", wantWait: true},
+		{name: "abort", policy: quitAbortTransfer, timeout: 10, inflight: "RETR", started: true, wantWait: true, wantAborted: true},
+		{name: "transfer_timeout", policy: quitFinishTransfer, timeout: 0, inflight: "RETR", wantWait: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTransfer := abool.New()
+			if tt.started {
+				inTransfer.Set()
+			}
+			d := &dataHandler{log: &logger{}, mutex: &sync.Mutex{}, inDataTransfer: inTransfer}
+			s := &proxyServer{log: &logger{}, dataConnector: d}
+			s.pushCommand(tt.inflight)
+			c := &clientHandler{
+				config: &Config{QuitDuringTransfer: tt.policy, TransferTimeout: tt.timeout},
+				log:    &logger{},
+				proxy:  s,
+			}
+
+			// origin replies to transfer command after a while
+			replied := make(chan time.Time, 1)
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				inTransfer.UnSet()
+				s.popCommand()
+				replied <- time.Now()
+			}()
+
+			c.quitDuringTransfer()
+			done := time.Now()
+			at := <-replied
+			if waited := !done.Before(at); waited != tt.wantWait {
+				t.Errorf("quitDuringTransfer() waited = %v, want %v", waited, tt.wantWait)
+			}
+			d.mutex.Lock()
+			aborted := d.clientAborted
+			d.mutex.Unlock()
+			if aborted != tt.wantAborted {
+				t.Errorf("clientAborted = %v, want %v", aborted, tt.wantAborted)
+			}
+		})
+	}
+}
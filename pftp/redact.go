@@ -0,0 +1,87 @@
+package pftp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const redactedText = "********"
+
+// redactor hide secrets of command lines and usernames in logs and events.
+// nil redactor hides only PASS parameter.
+type redactor struct {
+	rules     [][]string
+	hashUsers bool
+	salt      string
+}
+
+// rules are command prefixes. parameters after prefix are hidden.
+// last word ending with "*" matches words starting with it.
+// ex) "ACCT", "SITE AUTH", "X-*"
+func newRedactor(c *Config) *redactor {
+	r := &redactor{
+		rules:     [][]string{{secureCommand}},
+		hashUsers: c.HashUsernames,
+		salt:      c.UsernameHashSalt,
+	}
+	if c.ResumptionTokenTTL > 0 {
+		r.rules = append(r.rules, []string{"SITE", "RESUME"})
+	}
+	for _, rule := range c.RedactCommands {
+		if words := strings.Fields(strings.ToUpper(rule)); len(words) > 0 {
+			r.rules = append(r.rules, words)
+		}
+	}
+
+	return r
+}
+
+// return command line for log
+// ex) "SITE AUTH token\r\n" -> "SITE AUTH ********"
+func (r *redactor) line(line string) string {
+	line = strings.TrimSuffix(line, "\r\n")
+	words := strings.Fields(line)
+
+	rules := [][]string{{secureCommand}}
+	if r != nil {
+		rules = r.rules
+	}
+
+	for _, rule := range rules {
+		if len(words) < len(rule) || !matchWords(words[:len(rule)], rule) {
+			continue
+		}
+		if len(words) == len(rule) {
+			return line
+		}
+		return strings.Join(words[:len(rule)], " ") + " " + redactedText
+	}
+
+	return line
+}
+
+func matchWords(words []string, rule []string) bool {
+	for i, word := range rule {
+		w := strings.ToUpper(words[i])
+		if i == len(rule)-1 && strings.HasSuffix(word, "*") {
+			if !strings.HasPrefix(w, strings.TrimSuffix(word, "*")) {
+				return false
+			}
+		} else if w != word {
+			return false
+		}
+	}
+
+	return true
+}
+
+// return username for logs and events. hashed when hash_usernames is set.
+func (r *redactor) user(user string) string {
+	if r == nil || !r.hashUsers || len(user) == 0 {
+		return user
+	}
+
+	sum := sha256.Sum256([]byte(r.salt + user))
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,83 @@
+package pftp
+
+import "testing"
+
+func Test_redactor_line(t *testing.T) {
+	r := newRedactor(&Config{RedactCommands: []string{"ACCT", "site auth", "X-*"}})
+
+	tests := []struct {
+		name string
+		r    *redactor
+		line string
+		want string
+	}{
+		{
+			name: "pass_without_config",
+			line: "PASS secret\r\n",
+			want: "PASS ********",
+		},
+		{
+			name: "acct_without_config",
+			line: "ACCT secret\r\n",
+			want: "ACCT secret",
+		},
+		{
+			name: "pass",
+			r:    r,
+			line: "pass secret\r\n",
+			want: "pass ********",
+		},
+		{
+			name: "acct",
+			r:    r,
+			line: "ACCT secret\r\n",
+			want: "ACCT ********",
+		},
+		{
+			name: "site_auth",
+			r:    r,
+			line: "SITE AUTH token value\r\n",
+			want: "SITE AUTH ********",
+		},
+		{
+			name: "other_site",
+			r:    r,
+			line: "SITE CHMOD 644 file\r\n",
+			want: "SITE CHMOD 644 file",
+		},
+		{
+			name: "custom_command",
+			r:    r,
+			line: "X-TOKEN abcdef\r\n",
+			want: "X-TOKEN ********",
+		},
+		{
+			name: "no_parameter",
+			r:    r,
+			line: "ACCT\r\n",
+			want: "ACCT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.line(tt.line); got != tt.want {
+				t.Errorf("redactor.line() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_redactor_user(t *testing.T) {
+	if got := newRedactor(&Config{}).user("pftp"); got != "pftp" {
+		t.Errorf("redactor.user() = %s, want pftp", got)
+	}
+
+	r := newRedactor(&Config{HashUsernames: true, UsernameHashSalt: "salt"})
+	got := r.user("pftp")
+	if len(got) != 12 || got == "pftp" || got != r.user("pftp") {
+		t.Errorf("redactor.user() = %s, want stable hash", got)
+	}
+	if other := newRedactor(&Config{HashUsernames: true, UsernameHashSalt: "other"}).user("pftp"); other == got {
+		t.Errorf("redactor.user() does not depend on salt")
+	}
+}
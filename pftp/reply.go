@@ -0,0 +1,146 @@
+package pftp
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// ftpReply is reply parsed by RFC 959. Text is text of first line and
+// Lines are all lines without line end.
+type ftpReply struct {
+	Code      string
+	Text      string
+	Lines     []string
+	Multiline bool
+	raw       string
+}
+
+// malformedReplyError is returned when reply is not RFC 959 reply and
+// lenient_replies is not set
+type malformedReplyError struct {
+	line string
+}
+
+func (e *malformedReplyError) Error() string {
+	return fmt.Sprintf("malformed reply: %q", e.line)
+}
+
+// return code, separator (' ' or '-') and text of reply line.
+// false when line does not start with 3 digit code and separator.
+func parseReplyLine(line string) (string, byte, string, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 3 {
+		// code without text
+		line += " "
+	}
+	if len(line) < 4 || line[0] < '1' || line[0] > '6' || !isDigit(line[1]) || !isDigit(line[2]) {
+		return "", 0, "", false
+	}
+	if line[3] != ' ' && line[3] != '-' {
+		return "", 0, "", false
+	}
+
+	return line[:3], line[3], line[4:], true
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// return code of first line of reply. malformed line, which is read only by
+// lenient_replies, is split positionally as before.
+func replyCode(reply string) string {
+	if code, _, _, ok := parseReplyLine(reply); ok {
+		return code
+	}
+
+	return getCode(reply)[0]
+}
+
+// read one reply by RFC 959. multi-line reply "ddd-" ends at line starting
+// with same code and space, and lines between are any text.
+func readFTPReply(reader *bufio.Reader) (*ftpReply, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	code, sep, text, ok := parseReplyLine(line)
+	if !ok {
+		return nil, &malformedReplyError{line: strings.TrimRight(line, "\r\n")}
+	}
+
+	r := &ftpReply{
+		Code:      code,
+		Text:      text,
+		Lines:     []string{strings.TrimRight(line, "\r\n")},
+		Multiline: sep == '-',
+		raw:       line,
+	}
+	for sep == '-' {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		r.raw += line
+		r.Lines = append(r.Lines, strings.TrimRight(line, "\r\n"))
+		if c, s, _, ok := parseReplyLine(line); ok && c == code && s == ' ' {
+			break
+		}
+	}
+
+	return r, nil
+}
+
+// parse reply read by readReplyMode. malformed reply, which is read only by
+// lenient_replies, has code split positionally.
+func parseFTPReply(buff string) *ftpReply {
+	if r, err := readFTPReply(bufio.NewReader(strings.NewReader(buff))); err == nil {
+		return r
+	}
+
+	words := getCode(buff)
+	r := &ftpReply{Code: words[0], raw: buff}
+	if len(words) > 1 {
+		r.Text = words[1]
+	}
+	for _, line := range strings.Split(strings.TrimRight(buff, "\r\n"), "\n") {
+		r.Lines = append(r.Lines, strings.TrimSuffix(line, "\r"))
+	}
+	r.Multiline = len(r.Lines) > 1
+
+	return r
+}
+
+// return true when reply is 1xx, which is followed by final reply
+func (r *ftpReply) preliminary() bool {
+	return strings.HasPrefix(r.Code, "1")
+}
+
+// return true when reply is 2xx
+func (r *ftpReply) completed() bool {
+	return strings.HasPrefix(r.Code, "2")
+}
+
+// return true when reply is 4xx or 5xx
+func (r *ftpReply) failed() bool {
+	return strings.HasPrefix(r.Code, "4") || strings.HasPrefix(r.Code, "5")
+}
+
+// read one reply strictly, or as before when lenient is set for broken origins
+func readReplyMode(reader *bufio.Reader, lenient bool) (string, error) {
+	if lenient {
+		return readReplyLenient(reader)
+	}
+
+	r, err := readFTPReply(reader)
+	if err != nil {
+		return "", err
+	}
+
+	return r.raw, nil
+}
+
+// config is nil when unit test
+func (s *proxyServer) lenientReplies() bool {
+	return s.config != nil && s.config.LenientReplies
+}
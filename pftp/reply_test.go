@@ -0,0 +1,118 @@
+package pftp
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func Test_parseReplyLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		wantCode string
+		wantSep  byte
+		wantText string
+		wantOK   bool
+	}{
+		{line: "200 Command okay\r\n", wantCode: "200", wantSep: ' ', wantText: "Command okay", wantOK: true},
+		{line: "211-Features:\r\n", wantCode: "211", wantSep: '-', wantText: "Features:", wantOK: true},
+		{line: "226\r\n", wantCode: "226", wantSep: ' ', wantOK: true},
+		{line: "631 protected\r\n", wantCode: "631", wantSep: ' ', wantText: "protected", wantOK: true},
+		{line: "20 short\r\n"},
+		{line: "2000 too long code\r\n"},
+		{line: "abc text\r\n"},
+		{line: "\r\n"},
+		{line: "099 low\r\n"},
+	}
+	for _, tt := range tests {
+		code, sep, text, ok := parseReplyLine(tt.line)
+		if code != tt.wantCode || sep != tt.wantSep || text != tt.wantText || ok != tt.wantOK {
+			t.Errorf("parseReplyLine(%q) = %q, %q, %q, %v", tt.line, code, sep, text, ok)
+		}
+	}
+}
+
+func Test_readReplyMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		lenient bool
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "single",
+			input: "220 ready\r\n230 next\r\n",
+			want:  "220 ready\r\n",
+		},
+		{
+			// lines between may start with other codes or same code and '-'
+			name:  "multi_line",
+			input: "211-Features:\r\n 211 inner\r\n200 other code\r\n211-still\r\n211 End\r\n230 next\r\n",
+			want:  "211-Features:\r\n 211 inner\r\n200 other code\r\n211-still\r\n211 End\r\n",
+		},
+		{
+			name:    "malformed",
+			input:   "hello\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "malformed_lenient",
+			input:   "hello\r\n",
+			lenient: true,
+			want:    "hello\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readReplyMode(bufio.NewReader(strings.NewReader(tt.input)), tt.lenient)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readReplyMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("readReplyMode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	r, err := readFTPReply(bufio.NewReader(strings.NewReader("250-first\r\n second\r\n250 last\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Code != "250" || r.Text != "first" || !r.Multiline || len(r.Lines) != 3 || r.Lines[2] != "250 last" {
+		t.Errorf("readFTPReply() = %+v", r)
+	}
+	if got := replyCode("2000 bad"); got != "2" {
+		t.Errorf("replyCode() of malformed line = %q, want positional split", got)
+	}
+}
+
+func Test_parseFTPReply(t *testing.T) {
+	tests := []struct {
+		name            string
+		buff            string
+		wantCode        string
+		wantText        string
+		wantLines       int
+		wantPreliminary bool
+		wantCompleted   bool
+		wantFailed      bool
+	}{
+		{name: "single", buff: "226 Transfer complete.\r\n", wantCode: "226", wantText: "Transfer complete.", wantLines: 1, wantCompleted: true},
+		{name: "multi_line", buff: "211-Features:\r\n EPSV\r\n211 End\r\n", wantCode: "211", wantText: "Features:", wantLines: 3, wantCompleted: true},
+		{name: "preliminary", buff: "150 Opening data connection.\r\n", wantCode: "150", wantText: "Opening data connection.", wantLines: 1, wantPreliminary: true},
+		{name: "failed", buff: "550 No such file.\r\n", wantCode: "550", wantText: "No such file.", wantLines: 1, wantFailed: true},
+		{name: "lenient", buff: "530\tLogin incorrect.\r\n", wantCode: "530", wantText: "Login incorrect.", wantLines: 1, wantFailed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := parseFTPReply(tt.buff)
+			if r.Code != tt.wantCode || r.Text != tt.wantText || len(r.Lines) != tt.wantLines {
+				t.Errorf("parseFTPReply() = %+v", r)
+			}
+			if r.preliminary() != tt.wantPreliminary || r.completed() != tt.wantCompleted || r.failed() != tt.wantFailed {
+				t.Errorf("parseFTPReply() class = %v %v %v", r.preliminary(), r.completed(), r.failed())
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package pftp
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+// states of ResourcePressureEvent
+const (
+	resourceStateHigh        = "high"
+	resourceStateNormal      = "normal"
+	resourceStateAcceptError = "accept_error"
+)
+
+// resourceUsage is open file descriptors and resident memory of process
+type resourceUsage struct {
+	fds int
+	rss int64
+}
+
+// read usage from /proc of Linux
+func readResourceUsage() (resourceUsage, error) {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return resourceUsage{}, err
+	}
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return resourceUsage{}, err
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return resourceUsage{}, fmt.Errorf("unknown /proc/self/statm format")
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return resourceUsage{}, err
+	}
+
+	return resourceUsage{fds: len(fds), rss: pages * int64(os.Getpagesize())}, nil
+}
+
+// resourceWatchdog check open file descriptors and RSS against max_open_fds
+// and max_rss_bytes. while usage is over ceiling, new sessions are refused and
+// idle sessions are closed from the longest idle one, so process sheds load
+// before OS limits.
+type resourceWatchdog struct {
+	maxFDs   int
+	maxRSS   int64
+	shed     int
+	shedIdle time.Duration
+	read     func() (resourceUsage, error)
+	pressure *abool.AtomicBool
+	clients  *sessionRegistry
+	events   *eventBus
+	metrics  *metrics
+	log      func(format string, args ...interface{})
+}
+
+func newResourceWatchdog(c *Config, clients *sessionRegistry, events *eventBus, m *metrics) *resourceWatchdog {
+	return &resourceWatchdog{
+		maxFDs:   c.MaxOpenFDs,
+		maxRSS:   c.MaxRSSBytes,
+		shed:     c.ResourceShedSessions,
+		shedIdle: time.Duration(c.ResourceShedIdle) * time.Second,
+		read:     readResourceUsage,
+		pressure: abool.New(),
+		clients:  clients,
+		events:   events,
+		metrics:  m,
+		log:      func(string, ...interface{}) {},
+	}
+}
+
+// return true while new sessions must be refused
+func (w *resourceWatchdog) underPressure() bool {
+	return w != nil && w.pressure.IsSet()
+}
+
+func (w *resourceWatchdog) enabled() bool {
+	return w != nil && (w.maxFDs > 0 || w.maxRSS > 0)
+}
+
+// check usage every interval until stop is closed. watchdog stops on systems
+// without /proc.
+func (w *resourceWatchdog) run(interval time.Duration, stop chan struct{}) {
+	if !w.enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.check(); err != nil {
+				w.log("resource watchdog is stopped: %s", err.Error())
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// update pressure by usage and shed sessions while it is over ceiling.
+// event is emitted when pressure starts and ends.
+func (w *resourceWatchdog) check() error {
+	u, err := w.read()
+	if err != nil {
+		return err
+	}
+	w.metrics.set("pftp_open_fds", "Open file descriptors of process.", float64(u.fds))
+	w.metrics.set("pftp_rss_bytes", "Resident memory of process.", float64(u.rss))
+
+	high := (w.maxFDs > 0 && u.fds >= w.maxFDs) || (w.maxRSS > 0 && u.rss >= w.maxRSS)
+	if !high {
+		if w.pressure.SetToIf(true, false) {
+			w.log("resource pressure ended: fds=%d rss=%d", u.fds, u.rss)
+			w.emit(resourceStateNormal, u, 0, nil)
+		}
+		return nil
+	}
+
+	started := w.pressure.SetToIf(false, true)
+	shed := w.shedSessions()
+	if started || shed > 0 {
+		w.log("resource pressure: fds=%d/%d rss=%d/%d, closed %d idle sessions", u.fds, w.maxFDs, u.rss, w.maxRSS, shed)
+		w.emit(resourceStateHigh, u, shed, nil)
+	}
+
+	return nil
+}
+
+// shed idle sessions because accept failed by file descriptor limit
+func (w *resourceWatchdog) acceptFailed(err error) {
+	shed := w.shedSessions()
+	u, _ := w.read()
+	w.emit(resourceStateAcceptError, u, shed, err)
+}
+
+// close up to resource_shed_sessions sessions idle longer than
+// resource_shed_idle, longest idle first. sessions in data transfer are kept.
+func (w *resourceWatchdog) shedSessions() int {
+	if w.shed <= 0 || w.clients == nil {
+		return 0
+	}
+
+	now := time.Now()
+	w.clients.mutex.Lock()
+	idle := []*clientHandler{}
+	for _, c := range w.clients.clients {
+		if !c.inDataTransfer.IsSet() && now.Sub(c.lastActive()) >= w.shedIdle {
+			idle = append(idle, c)
+		}
+	}
+	w.clients.mutex.Unlock()
+
+	sort.Slice(idle, func(i, j int) bool { return idle[i].lastActive().Before(idle[j].lastActive()) })
+	if len(idle) > w.shed {
+		idle = idle[:w.shed]
+	}
+	for _, c := range idle {
+		c.closeWithNotice(closeReasonResourcePressure)
+	}
+	if len(idle) > 0 {
+		w.metrics.add("pftp_resource_shed_sessions_total", "Idle sessions closed by resource pressure.", float64(len(idle)))
+	}
+
+	return len(idle)
+}
+
+func (w *resourceWatchdog) emit(state string, u resourceUsage, shed int, err error) {
+	e := &ResourcePressureEvent{
+		Time:         time.Now(),
+		State:        state,
+		OpenFDs:      u.fds,
+		MaxOpenFDs:   w.maxFDs,
+		RSSBytes:     u.rss,
+		MaxRSSBytes:  w.maxRSS,
+		ShedSessions: shed,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	w.events.emit(e)
+}
+
+// return true when accept failed by limit of file descriptors. listener is
+// kept because other sessions end and free them.
+func isFDLimitError(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// return time of last client command
+func (c *clientHandler) lastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastActivity))
+}
@@ -0,0 +1,146 @@
+package pftp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+func newIdleClient(t *testing.T, id uint64, idle time.Duration, transferring bool) *clientHandler {
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { peer.Close() })
+	go io.Copy(ioutil.Discard, peer)
+
+	c := &clientHandler{
+		id:             id,
+		conn:           conn,
+		writer:         bufio.NewWriter(conn),
+		mutex:          &sync.Mutex{},
+		inDataTransfer: abool.New(),
+		lastActivity:   time.Now().Add(-idle).UnixNano(),
+	}
+	c.inDataTransfer.SetTo(transferring)
+
+	return c
+}
+
+func Test_resourceWatchdog_check(t *testing.T) {
+	tests := []struct {
+		name         string
+		usage        []resourceUsage
+		wantPressure bool
+		wantStates   []string
+		wantClosed   []uint64
+	}{
+		{name: "normal", usage: []resourceUsage{{fds: 10, rss: 100}}},
+		{
+			name:         "fds_over_ceiling",
+			usage:        []resourceUsage{{fds: 100, rss: 100}},
+			wantPressure: true,
+			wantStates:   []string{resourceStateHigh},
+			wantClosed:   []uint64{1, 2},
+		},
+		{
+			name:         "rss_over_ceiling",
+			usage:        []resourceUsage{{fds: 10, rss: 2000}},
+			wantPressure: true,
+			wantStates:   []string{resourceStateHigh},
+			wantClosed:   []uint64{1, 2},
+		},
+		{
+			name:       "relieved",
+			usage:      []resourceUsage{{fds: 100}, {fds: 10}},
+			wantStates: []string{resourceStateHigh, resourceStateNormal},
+			wantClosed: []uint64{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := newSessionRegistry()
+			// 1 is idle longest, 4 is in transfer and 5 is active
+			for _, c := range []*clientHandler{
+				newIdleClient(t, 2, 10*time.Minute, false),
+				newIdleClient(t, 1, time.Hour, false),
+				newIdleClient(t, 3, 5*time.Minute, false),
+				newIdleClient(t, 4, 2*time.Hour, true),
+				newIdleClient(t, 5, time.Second, false),
+			} {
+				clients.add(c)
+			}
+
+			w := newResourceWatchdog(&Config{MaxOpenFDs: 50, MaxRSSBytes: 1000, ResourceShedSessions: 2, ResourceShedIdle: 60}, clients, newEventBus(), nil)
+			for _, u := range tt.usage {
+				u := u
+				w.read = func() (resourceUsage, error) { return u, nil }
+				if err := w.check(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if w.underPressure() != tt.wantPressure {
+				t.Errorf("underPressure() = %v, want %v", w.underPressure(), tt.wantPressure)
+			}
+			for _, state := range tt.wantStates {
+				e := (<-w.events.ch).(*ResourcePressureEvent)
+				if e.State != state {
+					t.Errorf("event state = %s, want %s", e.State, state)
+				}
+			}
+			if len(w.events.ch) != 0 {
+				t.Errorf("%d events left", len(w.events.ch))
+			}
+			for _, id := range tt.wantClosed {
+				c := clients.clients[id]
+				c.summaryMutex.Lock()
+				reason := c.closeReason
+				c.summaryMutex.Unlock()
+				if reason != closeReasonResourcePressure {
+					t.Errorf("session %d close reason = %q, want %s", id, reason, closeReasonResourcePressure)
+				}
+			}
+			for _, id := range []uint64{3, 4, 5} {
+				if reason := clients.clients[id].closeReason; len(reason) > 0 {
+					t.Errorf("session %d is closed by %s", id, reason)
+				}
+			}
+		})
+	}
+}
+
+func Test_isFDLimitError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "emfile", err: &net.OpError{Op: "accept", Err: syscall.EMFILE}, want: true},
+		{name: "enfile", err: &net.OpError{Op: "accept", Err: syscall.ENFILE}, want: true},
+		{name: "closed", err: net.ErrClosed},
+		{name: "other", err: errors.New("reset")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isFDLimitError(tt.err); got != tt.want {
+				t.Errorf("isFDLimitError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_readResourceUsage(t *testing.T) {
+	u, err := readResourceUsage()
+	if err != nil {
+		t.Skipf("no /proc: %s", err)
+	}
+	if u.fds <= 0 || u.rss <= 0 {
+		t.Errorf("readResourceUsage() = %+v, want open fds and rss", u)
+	}
+}
@@ -0,0 +1,90 @@
+package pftp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var errInvalidResumption = errors.New("invalid or expired resumption token")
+
+// Resumption is routing decision of previous session restored from
+// resumption token sent by SITE RESUME.
+type Resumption struct {
+	User       string    `json:"user"`
+	RemoteAddr string    `json:"remote_addr"`
+	Data       string    `json:"data,omitempty"`
+	Expires    time.Time `json:"expires"`
+}
+
+// resumptionCodec seal resumption tokens by AES-GCM with key made from
+// resumption_secret. tokens are valid on every process sharing the secret.
+type resumptionCodec struct {
+	aead cipher.AEAD
+	ttl  time.Duration
+	now  func() time.Time
+}
+
+func newResumptionCodec(c *Config) (*resumptionCodec, error) {
+	if c.ResumptionTokenTTL <= 0 {
+		return nil, nil
+	}
+
+	key := sha256.Sum256([]byte(c.ResumptionSecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resumptionCodec{
+		aead: aead,
+		ttl:  time.Duration(c.ResumptionTokenTTL) * time.Second,
+		now:  time.Now,
+	}, nil
+}
+
+// return token of r expiring after ttl
+func (rc *resumptionCodec) issue(r Resumption) (string, error) {
+	r.Expires = rc.now().Add(rc.ttl).UTC().Truncate(time.Second)
+	plain, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, rc.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(rc.aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+// return resumption of token when it is valid and not expired
+func (rc *resumptionCodec) open(token string) (*Resumption, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(sealed) < rc.aead.NonceSize() {
+		return nil, errInvalidResumption
+	}
+
+	nonce := sealed[:rc.aead.NonceSize()]
+	plain, err := rc.aead.Open(nil, nonce, sealed[len(nonce):], nil)
+	if err != nil {
+		return nil, errInvalidResumption
+	}
+
+	r := &Resumption{}
+	if err := json.Unmarshal(plain, r); err != nil || !rc.now().Before(r.Expires) {
+		return nil, errInvalidResumption
+	}
+
+	return r, nil
+}
@@ -0,0 +1,120 @@
+package pftp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_resumptionCodec_open(t *testing.T) {
+	now := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	codec := func(secret string) *resumptionCodec {
+		rc, err := newResumptionCodec(&Config{ResumptionTokenTTL: 60, ResumptionSecret: secret})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc.now = func() time.Time { return now }
+		return rc
+	}
+
+	issuer := codec("secret")
+	token, err := issuer.issue(Resumption{User: "alice", RemoteAddr: "origin:21", Data: "affinity"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		codec   *resumptionCodec
+		token   string
+		after   time.Duration
+		want    *Resumption
+		wantErr bool
+	}{
+		{
+			name:  "restarted_process",
+			codec: codec("secret"),
+			token: token,
+			after: 59 * time.Second,
+			want:  &Resumption{User: "alice", RemoteAddr: "origin:21", Data: "affinity", Expires: now.Add(time.Minute)},
+		},
+		{
+			name:    "expired",
+			codec:   codec("secret"),
+			token:   token,
+			after:   time.Minute,
+			wantErr: true,
+		},
+		{
+			name:    "other_secret",
+			codec:   codec("other"),
+			token:   token,
+			wantErr: true,
+		},
+		{
+			name:    "tampered",
+			codec:   codec("secret"),
+			token:   strings.ToUpper(token),
+			wantErr: true,
+		},
+		{
+			name:    "garbage",
+			codec:   codec("secret"),
+			token:   "!!",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.codec.now = func() time.Time { return now.Add(tt.after) }
+			got, err := tt.codec.open(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resumptionCodec.open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != *tt.want {
+				t.Errorf("resumptionCodec.open() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_handleSITE_resumption(t *testing.T) {
+	rc, err := newResumptionCodec(&Config{ResumptionTokenTTL: 60, ResumptionSecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// issue token in logged in session
+	old := &clientHandler{
+		config:     &Config{},
+		context:    &Context{ResumptionData: "affinity"},
+		proxy:      &proxyServer{isLoggedin: true, originAddr: "origin:21"},
+		resumption: rc,
+		user:       "alice",
+		param:      "TOKEN",
+	}
+	r := old.handleSITE()
+	if r.code != 200 || !strings.HasPrefix(r.msg, "RESUME ") {
+		t.Fatalf("SITE TOKEN = %d %s", r.code, r.msg)
+	}
+
+	// give it to new session before login
+	c := &clientHandler{
+		config:     &Config{},
+		context:    &Context{},
+		proxy:      &proxyServer{},
+		resumption: rc,
+		param:      r.msg,
+	}
+	if r := c.handleSITE(); r.code != 200 {
+		t.Fatalf("SITE RESUME = %d %s", r.code, r.msg)
+	}
+	if got := c.context.Resumption; got == nil || got.User != "alice" || got.RemoteAddr != "origin:21" || got.Data != "affinity" {
+		t.Errorf("Context.Resumption = %+v", got)
+	}
+
+	c.param = "RESUME wrong"
+	if r := c.handleSITE(); r.code != 501 {
+		t.Errorf("SITE RESUME wrong = %d %s, want 501", r.code, r.msg)
+	}
+}
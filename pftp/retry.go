@@ -0,0 +1,119 @@
+package pftp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// replyRetry is command sent by client, resent to origin when origin
+// replied one of transient codes. it is kept with the command in flight.
+type replyRetry struct {
+	line    string
+	command string
+	codes   []string
+	attempt int
+}
+
+// validate reply_retries and make verbs upper case
+func validateReplyRetries(retries map[string][]string) (map[string][]string, error) {
+	if len(retries) == 0 {
+		return retries, nil
+	}
+
+	normalized := map[string][]string{}
+	for command, codes := range retries {
+		if isTransferCommand(strings.ToUpper(command)) {
+			return nil, fmt.Errorf("configuration error: reply_retries %s is data transfer command, which cannot be retried", command)
+		}
+		for _, code := range codes {
+			if len(code) != 3 || code[0] != '4' || strings.Trim(code, "0123456789") != "" {
+				return nil, fmt.Errorf("configuration error: reply %s of reply_retries %s is not transient reply code", code, command)
+			}
+		}
+		normalized[strings.ToUpper(command)] = codes
+	}
+
+	return normalized, nil
+}
+
+// set reply codes retried for next command sent to origin
+func (s *proxyServer) setReplyRetry(codes []string) {
+	// proxy is not connected yet for PROXY command
+	if s == nil {
+		return
+	}
+
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.retryCodes = codes
+}
+
+// return retry of command line sent to origin, and clear codes set by
+// setReplyRetry. nil means transient reply of command is not retried. data
+// transfer command is not retried because its data connection is used once.
+func (s *proxyServer) takeReplyRetry(line string) *replyRetry {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	codes := s.retryCodes
+	s.retryCodes = nil
+
+	command := strings.ToUpper(getCommand(line)[0])
+	if len(codes) == 0 || isTransferCommand(command) {
+		return nil
+	}
+
+	return &replyRetry{line: line, command: command, codes: codes}
+}
+
+// return retry of oldest command when its reply is retried. command is
+// retried only when it is the only command in flight, so replies keep order
+// of commands.
+func (s *proxyServer) shouldRetry(code string) *replyRetry {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	if len(s.inflight) != 1 || len(s.inflightRetries) == 0 {
+		return nil
+	}
+	r := s.inflightRetries[0]
+	if r == nil || r.attempt >= s.config.ReplyRetryLimit {
+		return nil
+	}
+	for _, c := range r.codes {
+		if c == code {
+			r.attempt++
+			return r
+		}
+	}
+
+	return nil
+}
+
+// resend command to origin after backoff doubled by each attempt. it runs in
+// reply loop holding originMutex, so commands of client sent meanwhile are
+// written after it. command stays in flight until its final reply.
+func (s *proxyServer) retryCommand(r *replyRetry, code string) {
+	backoff := time.Duration(s.config.ReplyRetryBackoff) * time.Millisecond << uint(r.attempt-1)
+	s.log.info("origin replied %s to %s. retry %d after %s", code, r.command, r.attempt, backoff)
+	s.events.emit(&CommandRetryEvent{
+		Time:      time.Now(),
+		SessionID: s.sessionID,
+		Origin:    s.originAddr,
+		Command:   r.command,
+		Code:      code,
+		Attempt:   r.attempt,
+	})
+
+	s.originMutex.Lock()
+	defer s.originMutex.Unlock()
+	time.Sleep(backoff)
+	s.commandLog(r.line)
+	if _, err := s.originWriter.WriteString(r.line); err != nil {
+		s.log.err("cannot retry %s: %s", r.command, err.Error())
+		return
+	}
+	if err := s.originWriter.Flush(); err != nil {
+		s.log.err("cannot retry %s: %s", r.command, err.Error())
+	}
+}
@@ -0,0 +1,214 @@
+package pftp
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+func Test_validateReplyRetries(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries map[string][]string
+		want    []string
+		wantErr bool
+	}{
+		{name: "lower_case_command", retries: map[string][]string{"dele": {"450"}}, want: []string{"450"}},
+		{name: "permanent_reply", retries: map[string][]string{"DELE": {"550"}}, wantErr: true},
+		{name: "not_code", retries: map[string][]string{"DELE": {"4xx"}}, wantErr: true},
+		{name: "transfer_command", retries: map[string][]string{"stor": {"450"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateReplyRetries(tt.retries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateReplyRetries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(got["DELE"]) != len(tt.want) {
+				t.Errorf("validateReplyRetries() = %v, want DELE %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_proxyServer_retryCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		codes      []string
+		replies    []string
+		wantClient string
+		wantSent   int
+	}{
+		{
+			name:       "retried_until_success",
+			line:       "DELE a.txt\r\n",
+			codes:      []string{"450"},
+			replies:    []string{"450 File busy.\r\n", "450 File busy.\r\n", "250 Deleted.\r\n"},
+			wantClient: "250 Deleted.\r\n",
+			wantSent:   3,
+		},
+		{
+			name:       "limit_exceeded",
+			line:       "DELE a.txt\r\n",
+			codes:      []string{"450"},
+			replies:    []string{"450 File busy.\r\n", "450 File busy.\r\n", "450 Still busy.\r\n"},
+			wantClient: "450 Still busy.\r\n",
+			wantSent:   3,
+		},
+		{
+			name:       "not_transient_code",
+			line:       "DELE a.txt\r\n",
+			codes:      []string{"421"},
+			replies:    []string{"450 File busy.\r\n"},
+			wantClient: "450 File busy.\r\n",
+			wantSent:   1,
+		},
+		{
+			name:       "transfer_command",
+			line:       "RETR a.txt\r\n",
+			codes:      []string{"450"},
+			replies:    []string{"450 File busy.\r\n"},
+			wantClient: "450 File busy.\r\n",
+			wantSent:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originConn, originPeer := net.Pipe()
+			defer originConn.Close()
+			defer originPeer.Close()
+
+			s := &proxyServer{
+				config:         &Config{ReplyRetryLimit: 2, ReplyRetryBackoff: 1},
+				log:            &logger{},
+				isLoggedin:     true,
+				inDataTransfer: abool.New(),
+				originWriter:   bufio.NewWriter(originConn),
+				mutex:          &sync.Mutex{},
+			}
+
+			// lines sent to origin are read apart from replies, because
+			// retry is written by reply loop
+			lines := make(chan string, len(tt.replies)+1)
+			go func() {
+				r := bufio.NewReader(originPeer)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					lines <- line
+				}
+			}()
+
+			// origin replies to each line sent by proxy
+			forwarded := make(chan string, len(tt.replies))
+			sent := make(chan int)
+			go func() {
+				n := 0
+				for _, reply := range tt.replies {
+					select {
+					case line := <-lines:
+						if line != tt.line {
+							t.Errorf("sent %q, want %q", line, tt.line)
+						}
+					case <-time.After(500 * time.Millisecond):
+						sent <- n
+						return
+					}
+					n++
+					if got, forward := s.processOriginReply(reply); forward {
+						forwarded <- got
+					}
+				}
+				sent <- n
+			}()
+
+			s.setReplyRetry(tt.codes)
+			if err := s.sendToOrigin(tt.line); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case got := <-forwarded:
+				if got != tt.wantClient {
+					t.Errorf("reply to client = %q, want %q", got, tt.wantClient)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatal("no reply to client")
+			}
+			if n := <-sent; n != tt.wantSent {
+				t.Errorf("command is sent %d times, want %d", n, tt.wantSent)
+			}
+		})
+	}
+}
+
+func Test_proxyServer_retryCommand_pipelined(t *testing.T) {
+	originConn, originPeer := net.Pipe()
+	defer originConn.Close()
+	defer originPeer.Close()
+
+	s := &proxyServer{
+		config:       &Config{ReplyRetryLimit: 2, ReplyRetryBackoff: 50},
+		log:          &logger{},
+		isLoggedin:   true,
+		originWriter: bufio.NewWriter(originConn),
+		mutex:        &sync.Mutex{},
+	}
+	lines := make(chan string, 4)
+	go func() {
+		r := bufio.NewReader(originPeer)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+
+	s.setReplyRetry([]string{"450"})
+	if err := s.sendToOrigin("DELE a.txt\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	<-lines
+
+	// client sends NOOP while DELE waits for backoff of retry
+	go s.processOriginReply("450 File busy.\r\n")
+	time.Sleep(10 * time.Millisecond)
+	if err := s.sendToOrigin("NOOP\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"DELE a.txt\r\n", "NOOP\r\n"} {
+		if got := <-lines; got != want {
+			t.Errorf("sent %q, want %q", got, want)
+		}
+	}
+	if got := s.currentCommand(); got != "DELE" {
+		t.Errorf("oldest command = %q, want DELE", got)
+	}
+	if got, forward := s.processOriginReply("250 Deleted.\r\n"); !forward || got != "250 Deleted.\r\n" {
+		t.Errorf("reply of retried DELE = %q, %v", got, forward)
+	}
+	if got := s.currentCommand(); got != "NOOP" {
+		t.Errorf("oldest command = %q, want NOOP", got)
+	}
+}
+
+func Test_newContext_ReplyRetries(t *testing.T) {
+	c := &Config{ReplyRetries: map[string][]string{"DELE": {"450"}}}
+
+	ctx := newContext(c)
+	ctx.ReplyRetries["MKD"] = []string{"450"}
+	ctx.ReplyRetries["DELE"][0] = "421"
+	if len(c.ReplyRetries) != 1 || c.ReplyRetries["DELE"][0] != "450" {
+		t.Errorf("reply_retries of config = %v, changed by session", c.ReplyRetries)
+	}
+}
@@ -0,0 +1,177 @@
+package pftp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is access window in cron-like expression
+// "minute hour day-of-month month day-of-week". time is in
+// window when all fields match. ex) "* 9-17 * * 1-5" is 9:00-17:59 on weekdays.
+type schedule struct {
+	fields [5]uint64 // bit set of allowed values of each field
+}
+
+// ranges of schedule fields
+var scheduleFieldRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+func parseSchedule(expr string) (*schedule, error) {
+	words := strings.Fields(expr)
+	if len(words) != 5 {
+		return nil, fmt.Errorf("schedule %q must have 5 fields", expr)
+	}
+
+	s := &schedule{}
+	for i, word := range words {
+		bits, err := parseScheduleField(word, scheduleFieldRanges[i][0], scheduleFieldRanges[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q is wrong: %s", expr, err.Error())
+		}
+		s.fields[i] = bits
+	}
+
+	// 7 is Sunday as well as 0
+	if s.fields[4]&(1<<7) != 0 {
+		s.fields[4] |= 1
+	}
+
+	return s, nil
+}
+
+// parse field like "*", "5", "1-5", "*/15", "9-17/2" and "1,3,5"
+func parseScheduleField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("step of %s is wrong", part)
+			}
+			step, part = n, part[:i]
+		}
+
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("%s is not number", part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("%s is not number", part)
+				}
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("%s is out of range %d-%d", part, min, max)
+		}
+
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (s *schedule) match(t time.Time) bool {
+	values := [5]int{t.Minute(), t.Hour(), t.Day(), int(t.Month()), int(t.Weekday())}
+	for i, v := range values {
+		if s.fields[i]&(1<<uint(v)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// return true when t is in one of schedules. empty schedules allow any time.
+func inSchedules(exprs []string, t time.Time) (bool, error) {
+	if len(exprs) == 0 {
+		return true, nil
+	}
+
+	for _, expr := range exprs {
+		s, err := parseSchedule(expr)
+		if err != nil {
+			return false, err
+		}
+		if s.match(t) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// validate schedules of config
+func validateSchedules(exprs []string) error {
+	for _, expr := range exprs {
+		if _, err := parseSchedule(expr); err != nil {
+			return fmt.Errorf("configuration error: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// scheduleClock is current time in location of schedules.
+// it is shared by all client sessions of server.
+type scheduleClock struct {
+	location *time.Location
+	now      func() time.Time
+}
+
+func newScheduleClock(c *Config) (*scheduleClock, error) {
+	location := time.Local
+	if len(c.ScheduleTimezone) > 0 {
+		l, err := time.LoadLocation(c.ScheduleTimezone)
+		if err != nil {
+			return nil, fmt.Errorf("configuration error: schedule_timezone is wrong: %s", err.Error())
+		}
+		location = l
+	}
+
+	return &scheduleClock{location: location, now: time.Now}, nil
+}
+
+func (sc *scheduleClock) current() time.Time {
+	if sc == nil {
+		return time.Now()
+	}
+
+	return sc.now().In(sc.location)
+}
+
+// deny login and mutating commands outside of schedules of session.
+// return reply when command is rejected by proxy.
+func (c *clientHandler) enforceSchedules() *result {
+	var exprs []string
+	code := 0
+	switch {
+	case c.command == "USER" || c.command == "PASS":
+		exprs, code = c.context.AccessSchedules, 530
+	case isMutatingCommand(c.command, c.param):
+		exprs, code = c.context.WriteSchedules, 550
+	default:
+		return nil
+	}
+
+	ok, err := inSchedules(exprs, c.schedules.current())
+	if err != nil {
+		c.log.err("access is denied by wrong schedule: %s", err.Error())
+	}
+	if ok {
+		return nil
+	}
+
+	return &result{
+		code: code,
+		msg:  c.message(msgOutsideSchedule),
+	}
+}
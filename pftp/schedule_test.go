@@ -0,0 +1,86 @@
+package pftp
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parseSchedule(t *testing.T) {
+	// 2021-09-06 is Monday
+	monday := time.Date(2021, 9, 6, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expr    string
+		t       time.Time
+		want    bool
+		wantErr bool
+	}{
+		{expr: "* * * * *", t: monday, want: true},
+		{expr: "* 9-17 * * 1-5", t: monday, want: true},
+		{expr: "* 9-17 * * 1-5", t: monday.Add(-time.Hour), want: false},
+		{expr: "* 9-17 * * 1-5", t: monday.AddDate(0, 0, -1), want: false},
+		{expr: "*/15 * * * *", t: monday, want: true},
+		{expr: "*/20 * * * *", t: monday, want: false},
+		{expr: "0-29 9 6 9 *", t: monday, want: false},
+		{expr: "30,45 9 6 9 *", t: monday, want: true},
+		{expr: "* * * * 7", t: monday.AddDate(0, 0, -1), want: true},
+		{expr: "* * * *", wantErr: true},
+		{expr: "60 * * * *", wantErr: true},
+		{expr: "* 5-1 * * *", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+		{expr: "a * * * *", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := parseSchedule(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && s.match(tt.t) != tt.want {
+				t.Errorf("schedule.match(%s) = %v, want %v", tt.t, !tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_enforceSchedules(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	// 2021-09-06 01:00 UTC is 10:00 in JST
+	clock := &scheduleClock{
+		location: tokyo,
+		now:      func() time.Time { return time.Date(2021, 9, 6, 1, 0, 0, 0, time.UTC) },
+	}
+
+	tests := []struct {
+		name     string
+		command  string
+		access   []string
+		write    []string
+		wantCode int
+	}{
+		{name: "login_in_window", command: "USER", access: []string{"* 9-17 * * 1-5"}},
+		{name: "login_outside_window", command: "USER", access: []string{"* 18-23 * * *", "* 0-8 * * *"}, wantCode: 530},
+		{name: "no_schedule", command: "USER"},
+		{name: "write_outside_window", command: "STOR", write: []string{"* 2 * * *"}, wantCode: 550},
+		{name: "read_outside_write_window", command: "RETR", write: []string{"* 2 * * *"}},
+		{name: "wrong_schedule", command: "USER", access: []string{"* *"}, wantCode: 530},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{
+				config:    &Config{},
+				context:   &Context{AccessSchedules: tt.access, WriteSchedules: tt.write},
+				schedules: clock,
+				log:       &logger{},
+				command:   tt.command,
+				param:     "file",
+			}
+
+			r := c.enforceSchedules()
+			if tt.wantCode == 0 && r != nil {
+				t.Errorf("clientHandler.enforceSchedules() = %+v, want nil", r)
+			} else if tt.wantCode != 0 && (r == nil || r.code != tt.wantCode) {
+				t.Errorf("clientHandler.enforceSchedules() = %+v, want code %d", r, tt.wantCode)
+			}
+		})
+	}
+}
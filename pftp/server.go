@@ -1,53 +1,299 @@
 package pftp
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/lestrrat/go-server-starter/listener"
 	"github.com/sirupsen/logrus"
+	"github.com/tevino/abool"
 	"golang.org/x/sync/errgroup"
 )
 
 type middlewareFunc func(*Context, string) error
 type middleware map[string]middlewareFunc
+type tlsConfigFunc func(*Context, *tls.ClientHelloInfo, *tls.Config) error
+type tlsHandshakeFunc func(*Context, tls.ConnectionState) error
+
+// hooks called on connection level events
+type hooks struct {
+	tlsConfig       tlsConfigFunc
+	tlsHandshake    tlsHandshakeFunc
+	duplicateUpload duplicateUploadFunc
+	connect         connectFunc
+	disconnect      disconnectFunc
+}
+
+var errServerStopped = errors.New("server is stopped")
 
 // FtpServer struct type
 type FtpServer struct {
 	listener      net.Listener
 	clientCounter uint64
-	config        *config
+	config        *Config
 	serverTLSData *tlsData
 	middleware    middleware
-	shutdown      bool
+	hooks         *hooks
+	events        *eventBus
+	messages      *messageCatalog
+	transfers     *transferLimiter
+	sessions      *sessionLimiter
+	clients       *sessionRegistry
+	health        *originHealth
+	breaker       *circuitBreaker
+	originStats   *originStats
+	capabilities  *capabilityCache
+	redactor      *redactor
+	accounting    *accounting
+	bans          *banGuard
+	tarpit        *tarpit
+	schedules     *scheduleClock
+	dynamic       *dynamicConfig
+	spool         *spool
+	draining      *abool.AtomicBool
+	standby       *abool.AtomicBool
+	coordinator   HACoordinator // nil when standby is changed only by admin API or Promote
+	dialects      dialectSet
+	resumption    *resumptionCodec
+	metrics       *metrics
+	auditLog      *auditLog
+	clientKeyLog  *keyLog // nil unless tls_keylog_file is set out of production mode
+	originKeyLog  *keyLog
+	resources     *resourceWatchdog
+	disk          *diskWatchdog // nil when free disk space is not checked
+	throughput    *throughputStats
+	slo           *sloReporter
+	probes        *probeFilter
+	uploads       *uploadHistory
+	dataListeners *dataListenerGC
+	dataPorts     *dataPortAllocator
+	userBlocks    *userBlocks
+	anonymous     *anonymousAccess
+	// stores given by option or made from accounting_file and ban_db
+	accountingStore AccountingStore
+	banStore        BanStore
+	originDialer    OriginDialer
+	dynamicSource   DynamicSource
+	spoolStore      SpoolStore
+	customDialects  map[string]Dialect
+	admin           *http.Server
+	stopBackground  chan struct{}
+	stopOnce        sync.Once
+	logger          logrus.FieldLogger
+	shutdown        *abool.AtomicBool
+	listenerMutex   sync.Mutex // guard listener set by Serve and closed by Stop
+	startTime       time.Time
+	// current client connection count
+	currentConnection int32
+}
+
+// Option configure FtpServer on creation
+type Option func(*FtpServer)
+
+// WithListener set listener used instead of listen on ListenAddr
+func WithListener(l net.Listener) Option {
+	return func(server *FtpServer) {
+		server.listener = l
+	}
+}
+
+// WithLogger set logger of server and client sessions
+func WithLogger(l logrus.FieldLogger) Option {
+	return func(server *FtpServer) {
+		server.logger = l
+	}
+}
+
+// WithOriginDialer set function dialing origins of all routes.
+// It can reach origins on private overlay networks (ex. Dial of tailscale tsnet.Server).
+// Middleware can change it per route by Context.OriginDialer.
+func WithOriginDialer(d OriginDialer) Option {
+	return func(server *FtpServer) {
+		server.originDialer = d
+	}
 }
 
-// NewFtpServer load config and create new ftp server struct
-func NewFtpServer(confFile string) (*FtpServer, error) {
-	c, err := loadConfig(confFile)
+// WithRouter set function deciding origin by USER command.
+// It is same as Use("user", r).
+func WithRouter(r middlewareFunc) Option {
+	return func(server *FtpServer) {
+		server.Use("USER", r)
+	}
+}
+
+// NewFtpServer load config and create new ftp server struct.
+// Overlays of environments in PFTP_ENV (ex. config.prod.toml) are merged on config.
+func NewFtpServer(confFile string, opts ...Option) (*FtpServer, error) {
+	return NewFtpServerWithOverlays(confFile, configOverlays(confFile), opts...)
+}
+
+// NewFtpServerWithOverlays load config, merge overlay files on it in order
+// and create new ftp server struct.
+func NewFtpServerWithOverlays(confFile string, overlays []string, opts ...Option) (*FtpServer, error) {
+	c, err := loadConfig(confFile, overlays...)
 	if err != nil {
 		return nil, err
 	}
 
+	return newFtpServer(c, opts...)
+}
+
+// NewFtpServerWithConfig create new ftp server struct by given config.
+// It is for embedding pftp without config file.
+func NewFtpServerWithConfig(c *Config, opts ...Option) (*FtpServer, error) {
+	if c == nil {
+		c = DefaultConfig()
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return newFtpServer(c, opts...)
+}
+
+func newFtpServer(c *Config, opts ...Option) (*FtpServer, error) {
+	var err error
+
 	m := middleware{}
 	server := &FtpServer{
 		config:     c,
 		middleware: m,
+		hooks:      &hooks{},
+		events:     newEventBus(),
+		metrics:    newMetrics(c),
+		clients:    newSessionRegistry(),
+		draining:   abool.New(),
+		shutdown:   abool.New(),
+		standby:    abool.NewBool(c.Standby),
+		logger:     logrus.StandardLogger(),
+
+		stopBackground: make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(server)
+	}
+
+	server.messages, err = newMessageCatalog(server.config)
+	if err != nil {
+		return nil, err
+	}
+	if err := server.applyFDLimit(openFileLimit); err != nil {
+		return nil, err
+	}
+	server.transfers = newTransferLimiter(server.config)
+	server.sessions = newSessionLimiter(server.config)
+	server.health = newOriginHealth(server.config)
+	server.breaker = newCircuitBreaker(server.config, server.events)
+	server.originStats = newOriginStats(server.config, server.events, server.metrics)
+	if server.coordinator == nil && len(server.config.StandbyCheckScript) > 0 {
+		server.coordinator = &scriptCoordinator{script: server.config.StandbyCheckScript}
+	}
+	if server.coordinator != nil && server.config.StandbyCheckInterval <= 0 {
+		return nil, errors.New("configuration error: standby_check_interval must be positive with HA coordinator")
+	}
+	if server.Standby() {
+		server.metrics.set("pftp_standby", "1 while server is standby.", 1)
+	}
+	server.resources = newResourceWatchdog(server.config, server.clients, server.events, server.metrics)
+	server.throughput = newThroughputStats(server.config, server.metrics, server.events)
+	server.slo = newSLOReporter(server.config, server.metrics, server.events)
+	server.probes = newProbeFilter(server.config, server.metrics, server.logger.Debugf)
+	server.uploads = newUploadHistory(server.config)
+	server.dataListeners = newDataListenerGC(server.config, server.metrics)
+	server.dataPorts = newDataPortAllocator(server.config, server.metrics)
+	server.userBlocks = newUserBlocks()
+	server.tarpit = newTarpit(server.config, server.metrics)
+	server.anonymous = newAnonymousAccess(server.config)
+	if a := server.anonymous; a != nil && a.config.TransferRate > 0 && !server.config.DataChanProxy {
+		// data connections do not pass proxy, so they cannot be paced
+		server.logger.Warn("anonymous transfer_rate is not enforced without data_channel_proxy")
+	}
+	server.capabilities = newCapabilityCache(server.config)
+	server.redactor = newRedactor(server.config)
+	if server.resumption, err = newResumptionCodec(server.config); err != nil {
+		return nil, err
+	}
+
+	if server.accountingStore == nil && len(server.config.AccountingFile) > 0 {
+		if server.accountingStore, err = newFileAccountingStore(server.config.AccountingFile); err != nil {
+			return nil, err
+		}
+	}
+	server.accounting = newAccounting(server.accountingStore)
+
+	if len(server.config.AdminAuditLog) > 0 {
+		if server.auditLog, err = newAuditLog(server.config.AdminAuditLog); err != nil {
+			return nil, err
+		}
+	}
+
+	if server.banStore == nil && len(server.config.BanDB) > 0 {
+		if server.banStore, err = newBoltBanStore(server.config.BanDB); err != nil {
+			return nil, err
+		}
+	}
+	server.bans, err = newBanGuard(server.config, server.banStore, func(err error) {
+		server.logger.Error("cannot store login failures: ", err.Error())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if server.schedules, err = newScheduleClock(server.config); err != nil {
+		return nil, err
+	}
+	if server.dialects, err = newDialectSet(server.config, server.customDialects); err != nil {
+		return nil, err
+	}
+
+	if server.dynamicSource == nil {
+		if server.dynamicSource, err = newDynamicSource(server.config.DynamicConfig, server.config.DynamicConfigToken); err != nil {
+			return nil, err
+		}
+	}
+	server.dynamic = newDynamicConfig()
+
+	if server.spoolStore == nil && len(server.config.SpoolDir) > 0 {
+		if server.spoolStore, err = newFileSpoolStore(server.config.SpoolDir); err != nil {
+			return nil, err
+		}
+	}
+	if server.spoolStore == nil && server.config.SpoolUploads {
+		return nil, errors.New("configuration error: spool_uploads needs spool_dir")
+	}
+	server.spool = newSpool(server.config, server.spoolStore, server.events)
+	server.disk = newDiskWatchdog(server.config, server.diskDirs(), server.events, server.metrics)
+	if store, ok := server.spoolStore.(*fileSpoolStore); ok && server.disk != nil {
+		server.spool.lowDisk = func() bool { return server.disk.lowSpace(store.dir) }
 	}
 
 	// build and set TLS configuration
 	if server.config.TLS != nil {
-		logrus.Info("build server TLS configurations...")
+		server.logger.Info("build server TLS configurations...")
 		server.serverTLSData, err = buildTLSConfigForClient(server.config.TLS)
 		if err != nil {
 			return nil, err
 		}
-		logrus.Infof("TLS certificate successfully loaded")
+		server.logger.Infof("TLS certificate successfully loaded")
+	}
+	if err := server.openKeyLogs(); err != nil {
+		return nil, err
+	}
+	if server.serverTLSData != nil {
+		server.serverTLSData.config.KeyLogWriter = server.clientKeyLog.writer()
 	}
 
 	return server, nil
@@ -58,62 +304,189 @@ func (server *FtpServer) Use(command string, m middlewareFunc) {
 	server.middleware[strings.ToUpper(command)] = m
 }
 
+// UseTLSConfig set function called before TLS handshake with client.
+// It can change TLS parameters of the connection by modifying given config
+// (ex. require TLSv1.3 for specific SNI names). Returning error aborts handshake.
+func (server *FtpServer) UseTLSConfig(f tlsConfigFunc) {
+	server.hooks.tlsConfig = f
+}
+
+// UseTLSHandshake set function called after TLS handshake with client.
+// It can inspect ALPN, SNI and client certificates for routing.
+// Returning error rejects the connection.
+func (server *FtpServer) UseTLSHandshake(f tlsHandshakeFunc) {
+	server.hooks.tlsHandshake = f
+}
+
+// Events return channel of server events.
+// Events are dropped when nobody reads channel and its buffer is full.
+func (server *FtpServer) Events() <-chan Event {
+	return server.events.ch
+}
+
 func (server *FtpServer) listen() (err error) {
+	// listener is given by option
+	if l := server.getListener(); l != nil {
+		server.logger.Info("Listening address ", l.Addr())
+		return nil
+	}
+
 	if os.Getenv("SERVER_STARTER_PORT") != "" {
 		listeners, err := listener.ListenAll()
 		if listeners == nil || err != nil {
 			return err
 		}
-		server.listener = listeners[0]
+		if !server.setListener(listeners[0]) {
+			return errServerStopped
+		}
 	} else {
-		l, err := net.Listen("tcp", server.config.ListenAddr)
+		l, err := listenNetworkAddr(server.config.ListenAddr)
 		if err != nil {
 			return err
 		}
-		server.listener = l
+		if !server.setListener(l) {
+			l.Close()
+			return errServerStopped
+		}
 	}
 
-	logrus.Info("Listening address ", server.listener.Addr())
+	server.logger.Info("Listening address ", server.getListener().Addr())
 
 	return err
 }
 
+// set listener unless server is already stopped
+func (server *FtpServer) setListener(l net.Listener) bool {
+	server.listenerMutex.Lock()
+	defer server.listenerMutex.Unlock()
+	if server.shutdown.IsSet() {
+		return false
+	}
+	server.listener = l
+
+	return true
+}
+
+func (server *FtpServer) getListener() net.Listener {
+	server.listenerMutex.Lock()
+	defer server.listenerMutex.Unlock()
+	return server.listener
+}
+
 func (server *FtpServer) serve() error {
-	currentConnection := int32(0)
 	eg := errgroup.Group{}
+	listener := server.getListener()
+
+	if err := server.startAdmin(); err != nil {
+		return err
+	}
+	if server.accounting != nil {
+		go server.accounting.run(time.Duration(server.config.AccountingFlushInterval)*time.Second, server.stopBackground, func(err error) {
+			server.logger.Error("cannot store accounting: ", err.Error())
+		})
+	}
+	if server.dynamicSource != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-server.stopBackground
+			cancel()
+		}()
+		go server.dynamic.run(ctx, server.dynamicSource, func(err error) {
+			server.logger.Error("cannot apply dynamic config: ", err.Error())
+		})
+	}
+	if server.spool != nil {
+		server.spool.run(server.stopBackground, func(err error) {
+			server.logger.Error(err.Error())
+		})
+	}
+	server.resources.log = server.logger.Warnf
+	go server.resources.run(time.Duration(server.config.ResourceCheckInterval)*time.Second, server.stopBackground)
+	if server.disk != nil {
+		server.disk.log = server.logger.Warnf
+		go server.disk.run(time.Duration(server.config.ResourceCheckInterval)*time.Second, server.stopBackground)
+	}
+	go server.runCoordinator(time.Duration(server.config.StandbyCheckInterval)*time.Second, server.stopBackground)
+	go server.throughput.run(time.Duration(server.config.ThroughputSummaryInterval)*time.Second, server.stopBackground)
+	go server.dataListeners.run(server.stopBackground)
+	go server.slo.run(time.Duration(server.config.SLOInterval)*time.Second, server.stopBackground)
+	go server.tarpit.run(server.stopBackground)
+
+	server.startTime = time.Now()
+	server.events.emit(&ServerStartEvent{
+		Time: server.startTime,
+		Addr: listener.Addr().String(),
+	})
 
+	var acceptBackoff time.Duration
 	for {
-		netConn, err := server.listener.Accept()
+		netConn, err := listener.Accept()
+		if err != nil && !server.shutdown.IsSet() && isFDLimitError(err) {
+			// keep accepting after sessions free file descriptors
+			if acceptBackoff == 0 {
+				acceptBackoff = 5 * time.Millisecond
+			} else if acceptBackoff *= 2; acceptBackoff > time.Second {
+				acceptBackoff = time.Second
+			}
+			server.logger.Error("cannot accept connection, retrying in ", acceptBackoff, ": ", err.Error())
+			server.resources.acceptFailed(err)
+			time.Sleep(acceptBackoff)
+			continue
+		}
+		acceptBackoff = 0
 		if err != nil {
+			if server.shutdown.IsSet() {
+				server.emitShutdown()
+			} else {
+				server.events.emit(&ListenerErrorEvent{
+					Time:  time.Now(),
+					Addr:  listener.Addr().String(),
+					Error: err.Error(),
+				})
+			}
+
 			// if use server starter, break for while all childs end
 			if os.Getenv("SERVER_STARTER_PORT") != "" {
-				logrus.Info("Close listener")
+				server.logger.Info("Close listener")
 				break
 			}
 
-			if server.listener != nil {
-				return err
-			}
+			return err
 		}
 
 		// set linger 0 and tcp keepalive setting between client connection
-		conn := netConn.(*net.TCPConn)
-		conn.SetKeepAlive(true)
-		conn.SetKeepAlivePeriod(time.Duration(server.config.KeepaliveTime) * time.Second)
-		conn.SetLinger(0)
+		// listener given by option may not be TCP
+		conn := netConn
+		if tcpConn, ok := netConn.(*net.TCPConn); ok {
+			tcpConn.SetKeepAlive(true)
+			tcpConn.SetKeepAlivePeriod(time.Duration(server.config.KeepaliveTime) * time.Second)
+			tcpConn.SetLinger(0)
+		}
 
+		var deadline time.Time
 		if server.config.IdleTimeout > 0 {
-			conn.SetDeadline(time.Now().Add(time.Duration(server.config.IdleTimeout) * time.Second))
+			deadline = time.Now().Add(time.Duration(server.config.IdleTimeout) * time.Second)
+			conn.SetDeadline(deadline)
 		}
 
-		server.clientCounter++
-
-		c := newClientHandler(conn, server.config, server.serverTLSData, server.middleware, server.clientCounter, &currentConnection)
 		eg.Go(func() error {
+			// scanners and health checks get static reply without session
+			conn, probe := server.probes.check(conn, deadline)
+			if probe {
+				return nil
+			}
+
+			// read by admin API
+			id := atomic.AddUint64(&server.clientCounter, 1)
+
+			c := newClientHandler(conn, server, id, &server.currentConnection)
+			c.updateSummary()
+			server.clients.add(c)
+			defer server.clients.remove(c)
 			err := c.handleCommands()
-			logrus.Info("handle command end runtime goroutine count: ", runtime.NumGoroutine())
+			server.logger.Info("handle command end runtime goroutine count: ", runtime.NumGoroutine())
 			if err != nil {
-				logrus.Error(err.Error())
+				server.logger.Error(err.Error())
 			}
 			return err
 		})
@@ -122,6 +495,16 @@ func (server *FtpServer) serve() error {
 	return eg.Wait()
 }
 
+// emit shutdown event with sessions still connected at the time
+func (server *FtpServer) emitShutdown() {
+	server.events.emit(&ServerShutdownEvent{
+		Time:           time.Now(),
+		Uptime:         time.Since(server.startTime),
+		TotalSessions:  atomic.LoadUint64(&server.clientCounter),
+		ActiveSessions: atomic.LoadInt32(&server.currentConnection),
+	})
+}
+
 // Start start pFTP server
 func (server *FtpServer) Start() error {
 	var lastError error
@@ -131,11 +514,11 @@ func (server *FtpServer) Start() error {
 		return err
 	}
 
-	logrus.Info("Starting...")
+	server.logger.Info("Starting...")
 
 	go func() {
 		if err := server.serve(); err != nil {
-			if !server.shutdown {
+			if !server.shutdown.IsSet() {
 				lastError = err
 			}
 		}
@@ -147,7 +530,22 @@ func (server *FtpServer) Start() error {
 L:
 	for {
 		switch <-ch {
-		case syscall.SIGHUP, syscall.SIGTERM:
+		case syscall.SIGTERM:
+			// drain sessions like rolling update of Kubernetes
+			if server.config.DrainTimeout > 0 {
+				server.recordSignal("SIGTERM", "drain")
+				if err := server.Drain(server.drainGrace()); err != nil {
+					lastError = err
+				}
+				break L
+			}
+			server.recordSignal("SIGTERM", "stop")
+			if err := server.stop(); err != nil {
+				lastError = err
+			}
+			break L
+		case syscall.SIGHUP:
+			server.recordSignal("SIGHUP", "stop")
 			if err := server.stop(); err != nil {
 				lastError = err
 			}
@@ -159,12 +557,62 @@ L:
 	return lastError
 }
 
+// Serve accept client connections on given listener until it is closed or Stop is called.
+// It is for embedders using pre-created listeners (systemd sockets, in-memory listeners etc).
+// Unlike Start, it does not handle signals.
+func (server *FtpServer) Serve(l net.Listener) error {
+	// Stop called before Serve closes listener
+	if !server.setListener(l) {
+		return l.Close()
+	}
+	server.logger.Info("Listening address ", l.Addr())
+
+	if err := server.serve(); err != nil && !server.shutdown.IsSet() {
+		return err
+	}
+
+	return nil
+}
+
+// Stop close listener and stop accepting new connections
+func (server *FtpServer) Stop() error {
+	return server.stop()
+}
+
 func (server *FtpServer) stop() error {
-	server.shutdown = true
-	if server.listener != nil {
-		if err := server.listener.Close(); err != nil {
+	server.listenerMutex.Lock()
+	server.shutdown.Set()
+	listener := server.listener
+	server.listenerMutex.Unlock()
+
+	server.stopOnce.Do(func() {
+		close(server.stopBackground)
+		// audit log is closed after admin requests ended, and
+		// requests left after adminShutdownTimeout are closed
+		if server.admin != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+			server.admin.Shutdown(ctx)
+			cancel()
+			server.admin.Close()
+		}
+		if s, ok := server.banStore.(*boltBanStore); ok {
+			s.Close()
+		}
+		server.auditLog.close()
+		server.clientKeyLog.close()
+		server.originKeyLog.close()
+	})
+	if listener != nil {
+		if err := listener.Close(); err != nil {
 			return err
 		}
 	}
+
+	// server starter waits sessions of old process end. otherwise
+	// tell clients closing reason before process exits.
+	if os.Getenv("SERVER_STARTER_PORT") == "" {
+		server.clients.killAll()
+	}
+
 	return nil
 }
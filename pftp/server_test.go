@@ -0,0 +1,93 @@
+package pftp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNewFtpServerWithConfig(t *testing.T) {
+	invalid := DefaultConfig()
+	invalid.TransferMode = "unknown"
+
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{name: "default", config: DefaultConfig()},
+		{name: "nil", config: nil},
+		{name: "invalid", config: invalid, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := logrus.New()
+			server, err := NewFtpServerWithConfig(tt.config, WithLogger(l))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewFtpServerWithConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && server.logger != l {
+				t.Errorf("NewFtpServerWithConfig() logger is not set by option")
+			}
+		})
+	}
+}
+
+func TestFtpServer_Serve(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewFtpServerWithConfig(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() { done <- server.Serve(l) }()
+
+	if e := <-server.Events(); e.EventType() != "server_start" {
+		t.Errorf("FtpServer.Serve() event = %s, want server_start", e.EventType())
+	}
+	if err := server.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("FtpServer.Serve() error = %v, want nil", err)
+	}
+	if e := <-server.Events(); e.EventType() != "server_shutdown" {
+		t.Errorf("FtpServer.Serve() event = %s, want server_shutdown", e.EventType())
+	}
+}
+
+func TestFtpServer_Stop_beforeServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewFtpServerWithConfig(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() { done <- server.Serve(l) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("FtpServer.Serve() error = %v, want nil", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("FtpServer.Serve() does not return after Stop")
+	}
+	if _, err := l.Accept(); err == nil {
+		t.Error("listener is not closed")
+	}
+}
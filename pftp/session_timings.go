@@ -0,0 +1,135 @@
+package pftp
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// kinds of time recorded by pftp
+const (
+	timingMiddleware = "middleware"
+	timingOriginRTT  = "origin_rtt"
+	timingDataCopy   = "data_copy"
+)
+
+// TimingStat is count, sum and max of time spent in part of session
+type TimingStat struct {
+	Count int64         `json:"count"`
+	Total time.Duration `json:"total"`
+	Max   time.Duration `json:"max"`
+}
+
+// SessionTimings is time spent by session in middleware, commands of origin
+// (origin_rtt, commands without preliminary reply) and data relay
+// (data_copy). it is set to Context when session_timings is enabled, and
+// middleware can add its own kinds by Add.
+type SessionTimings struct {
+	mutex sync.Mutex
+	stats map[string]*TimingStat
+}
+
+func newSessionTimings(c *Config) *SessionTimings {
+	if c == nil || !c.SessionTimings {
+		return nil
+	}
+
+	return &SessionTimings{stats: map[string]*TimingStat{}}
+}
+
+// Add record time spent in kind. nil SessionTimings records nothing.
+func (t *SessionTimings) Add(kind string, d time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	s, ok := t.stats[kind]
+	if !ok {
+		s = &TimingStat{}
+		t.stats[kind] = s
+	}
+	s.Count++
+	s.Total += d
+	if d > s.Max {
+		s.Max = d
+	}
+}
+
+// Snapshot return copy of recorded times by kind
+func (t *SessionTimings) Snapshot() map[string]TimingStat {
+	stats := map[string]TimingStat{}
+	if t == nil {
+		return stats
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for kind, s := range t.stats {
+		stats[kind] = *s
+	}
+
+	return stats
+}
+
+// ex) "data_copy=2/1.2s(max 1s) middleware=5/3ms(max 1ms)"
+func (t *SessionTimings) String() string {
+	stats := t.Snapshot()
+	kinds := make([]string, 0, len(stats))
+	for kind := range stats {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	parts := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		s := stats[kind]
+		parts = append(parts, fmt.Sprintf("%s=%d/%s(max %s)", kind, s.Count, s.Total, s.Max))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// return timings of session. ok is false when session is not found.
+func (r *sessionRegistry) timings(id uint64) (*SessionTimings, bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c, ok := r.clients[id]
+	if !ok {
+		return nil, false
+	}
+
+	return c.timings, true
+}
+
+// GET /sessions/:id/timings
+// return time spent by session in middleware, origin commands and data relay
+func (server *FtpServer) handleSessionTimings(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: "session id must be number"})
+		return
+	}
+	t, ok := server.clients.timings(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "unknown session"})
+		return
+	}
+	if t == nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "session timings are not enabled"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, t.Snapshot())
+}
@@ -0,0 +1,74 @@
+package pftp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSessionTimings(t *testing.T) {
+	if newSessionTimings(&Config{}) != nil {
+		t.Error("timings are created while session_timings is disabled")
+	}
+	var disabled *SessionTimings
+	disabled.Add(timingMiddleware, time.Second)
+	if s := disabled.String(); s != "" {
+		t.Errorf("String() of disabled timings = %q", s)
+	}
+
+	timings := newSessionTimings(&Config{SessionTimings: true})
+	timings.Add(timingMiddleware, time.Millisecond)
+	timings.Add(timingMiddleware, 2*time.Millisecond)
+	timings.Add(timingDataCopy, time.Second)
+
+	want := map[string]TimingStat{
+		timingMiddleware: {Count: 2, Total: 3 * time.Millisecond, Max: 2 * time.Millisecond},
+		timingDataCopy:   {Count: 1, Total: time.Second, Max: time.Second},
+	}
+	if got := timings.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot() = %+v, want %+v", got, want)
+	}
+	if got, want := timings.String(), "data_copy=1/1s(max 1s) middleware=2/3ms(max 2ms)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func Test_FtpServer_handleSessionTimings(t *testing.T) {
+	server := &FtpServer{clients: newSessionRegistry()}
+	timings := newSessionTimings(&Config{SessionTimings: true})
+	timings.Add(timingOriginRTT, time.Millisecond)
+	server.clients.add(&clientHandler{id: 1, timings: timings})
+	server.clients.add(&clientHandler{id: 2})
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/sessions/1/timings", http.StatusOK},
+		{"/sessions/2/timings", http.StatusNotFound},
+		{"/sessions/3/timings", http.StatusNotFound},
+		{"/sessions/x/timings", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			server.adminHandler().ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+			if rec.Code != tt.code {
+				t.Fatalf("GET %s code = %d, want %d", tt.path, rec.Code, tt.code)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var got map[string]TimingStat
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got[timingOriginRTT].Count != 1 {
+				t.Errorf("GET %s = %+v", tt.path, got)
+			}
+		})
+	}
+}
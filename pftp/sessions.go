@@ -0,0 +1,126 @@
+package pftp
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionInfo is summary of connected client session
+type SessionInfo struct {
+	ID          uint64    `json:"id"`
+	ClientAddr  string    `json:"client_addr"`
+	User        string    `json:"user"`
+	Origin      string    `json:"origin"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// reasons of closing client session
+const (
+	closeReasonQuit             = "client_quit"
+	closeReasonClientClosed     = "client_closed"
+	closeReasonIdleTimeout      = "idle_timeout"
+	closeReasonTransferTimeout  = "transfer_timeout"
+	closeReasonOriginFailure    = "origin_failure"
+	closeReasonPolicyKill       = "policy_kill"
+	closeReasonShutdown         = "server_shutdown"
+	closeReasonResourcePressure = "resource_pressure"
+	closeReasonStandby          = "standby"
+)
+
+// sessionRegistry keep connected client sessions to list and close them
+type sessionRegistry struct {
+	mutex   sync.Mutex
+	clients map[uint64]*clientHandler
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{clients: map[uint64]*clientHandler{}}
+}
+
+func (r *sessionRegistry) add(c *clientHandler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.clients[c.id] = c
+}
+
+func (r *sessionRegistry) remove(c *clientHandler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.clients, c.id)
+	c.tail.close()
+}
+
+// return sessions ordered by id
+func (r *sessionRegistry) list() []SessionInfo {
+	sessions := []SessionInfo{}
+	if r == nil {
+		return sessions
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, c := range r.clients {
+		sessions = append(sessions, c.sessionInfo())
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+
+	return sessions
+}
+
+// return count of sessions connected to origin
+func (r *sessionRegistry) countOrigin(addr string) int32 {
+	if r == nil {
+		return 0
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var n int32
+	for _, c := range r.clients {
+		if c.sessionInfo().Origin == addr {
+			n++
+		}
+	}
+
+	return n
+}
+
+// send 421 to session and close it. return false when session is not found.
+func (r *sessionRegistry) kill(id uint64) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mutex.Lock()
+	c, ok := r.clients[id]
+	r.mutex.Unlock()
+
+	if ok {
+		c.closeWithNotice(closeReasonPolicyKill)
+	}
+
+	return ok
+}
+
+// send 421 to all sessions and close them
+func (r *sessionRegistry) killAll() {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	clients := make([]*clientHandler, 0, len(r.clients))
+	for _, c := range r.clients {
+		clients = append(clients, c)
+	}
+	r.mutex.Unlock()
+
+	for _, c := range clients {
+		c.closeWithNotice(closeReasonShutdown)
+	}
+}
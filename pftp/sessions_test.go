@@ -0,0 +1,158 @@
+package pftp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_sessionRegistry_kill(t *testing.T) {
+	origin := startDelayedOrigin(t, 0)
+	defer origin.Close()
+
+	tests := []struct {
+		name       string
+		kill       func(server *FtpServer, id uint64)
+		wantReason string
+	}{
+		{
+			name:       "server_stop",
+			kill:       func(server *FtpServer, id uint64) { server.Stop() },
+			wantReason: closeReasonShutdown,
+		},
+		{
+			name:       "admin_api",
+			wantReason: closeReasonPolicyKill,
+			kill: func(server *FtpServer, id uint64) {
+				rec := httptest.NewRecorder()
+				server.adminHandler().ServeHTTP(rec, httptest.NewRequest("DELETE", fmt.Sprintf("/sessions/%d", id), nil))
+				if rec.Code != 204 {
+					t.Errorf("DELETE /sessions/%d code = %d, want 204", id, rec.Code)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := DefaultConfig()
+			c.RemoteAddr = origin.Addr().String()
+			c.MaxConnections = 10
+			server, err := NewFtpServerWithConfig(c, WithListener(l))
+			if err != nil {
+				t.Fatal(err)
+			}
+			go server.Serve(l)
+			defer server.Stop()
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(3 * time.Second))
+			reader := bufio.NewReader(conn)
+			if line, err := reader.ReadString('\n'); err != nil || line != "220 FTP proxy ready\r\n" {
+				t.Fatalf("welcome = %q, %v", line, err)
+			}
+
+			sessions := server.clients.list()
+			if len(sessions) != 1 || sessions[0].Origin != c.RemoteAddr {
+				t.Fatalf("sessions = %+v, want one session to %s", sessions, c.RemoteAddr)
+			}
+
+			tt.kill(server, sessions[0].ID)
+
+			if line, err := reader.ReadString('\n'); err != nil || line != "421 Service closing control connection\r\n" {
+				t.Errorf("reply on close = %q, %v", line, err)
+			}
+			if _, err := reader.ReadString('\n'); err == nil {
+				t.Errorf("connection is not closed")
+			}
+
+			if e := waitDisconnectEvent(t, server.events); e.Reason != tt.wantReason {
+				t.Errorf("disconnect reason = %s, want %s", e.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+// return first disconnect event. other events are skipped.
+func waitDisconnectEvent(t *testing.T, events *eventBus) *ClientDisconnectEvent {
+	t.Helper()
+
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case e := <-events.ch:
+			if d, ok := e.(*ClientDisconnectEvent); ok {
+				return d
+			}
+		case <-timeout:
+			t.Fatal("disconnect event is not emitted")
+		}
+	}
+}
+
+func Test_clientHandler_emitDisconnect(t *testing.T) {
+	tests := []struct {
+		name    string
+		reasons []string
+		want    string
+	}{
+		{name: "default", want: closeReasonClientClosed},
+		{name: "quit", reasons: []string{closeReasonQuit}, want: closeReasonQuit},
+		{name: "first_wins", reasons: []string{closeReasonIdleTimeout, closeReasonOriginFailure}, want: closeReasonIdleTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := newEventBus()
+			c := &clientHandler{
+				id:          3,
+				srcIP:       "192.0.2.1:50000",
+				log:         &logger{user: "alice"},
+				events:      events,
+				connectedAt: time.Now().Add(-time.Minute),
+				transferred: 1024,
+			}
+			for _, r := range tt.reasons {
+				c.setCloseReason(r)
+			}
+			c.emitDisconnect()
+
+			e := waitDisconnectEvent(t, events)
+			if e.Reason != tt.want || e.SessionID != 3 || e.User != "alice" || e.Bytes != 1024 || e.Duration < time.Minute {
+				t.Errorf("event = %+v, want reason %s", e, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_closeWithNotice_blockedClient(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	c := &clientHandler{conn: conn, mutex: &sync.Mutex{}}
+	// session goroutine is blocked by client which does not read
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.closeWithNotice(closeReasonPolicyKill)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("closeWithNotice() is blocked by client")
+	}
+}
@@ -0,0 +1,174 @@
+package pftp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shadowTimeout is timeout of shadow connections and PASV reply. it is short
+// because slow shadow must not delay client session.
+const shadowTimeout = 3 * time.Second
+
+// shadowOrigin is second origin connection for validate new backend.
+// commands are mirrored to shadow and its responses are discarded.
+type shadowOrigin struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	log     *logger
+	mutex   *sync.Mutex
+	pasv    chan string
+	closed  bool
+	timeout time.Duration
+}
+
+func newShadowOrigin(addr string, log *logger) (*shadowOrigin, error) {
+	network, address := splitNetworkAddr(addr)
+	conn, err := net.DialTimeout(network, address, shadowTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &shadowOrigin{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		writer:  bufio.NewWriter(conn),
+		log:     log,
+		mutex:   &sync.Mutex{},
+		pasv:    make(chan string, 1),
+		timeout: shadowTimeout,
+	}
+
+	s.log.debug("new shadow from=%s to=%s", conn.LocalAddr(), conn.RemoteAddr())
+
+	go s.discardResponses()
+
+	return s, nil
+}
+
+// read and drop all responses from shadow.
+// only PASV response is kept for mirror upload data connection.
+func (s *shadowOrigin) discardResponses() {
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			if err != io.EOF && !strings.Contains(err.Error(), alreadyClosedMsg) {
+				s.log.debug("error from shadow connection: %s", err.Error())
+			}
+			connectionCloser(s, s.log)
+			return
+		}
+
+		s.log.debug("response from shadow: %s", strings.TrimSuffix(line, "\r\n"))
+
+		if strings.HasPrefix(line, "227 ") {
+			select {
+			case s.pasv <- line:
+			default:
+			}
+		}
+	}
+}
+
+// send command line to shadow. when failed, shadow will be closed
+// but client session is not affected.
+func (s *shadowOrigin) mirror(line string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	if !strings.HasSuffix(line, "\r\n") {
+		line = strings.TrimRight(line, "\r\n") + "\r\n"
+	}
+
+	if _, err := s.writer.WriteString(line); err == nil {
+		if err = s.writer.Flush(); err == nil {
+			return
+		}
+	}
+
+	s.log.debug("cannot mirror command to shadow. close shadow connection")
+	s.closed = true
+	s.conn.Close()
+}
+
+// open passive data connection to shadow and send upload command line.
+// returned connection receives copy of uploaded data. it is called by
+// goroutine of upload mirror, so control loop does not wait shadow.
+func (s *shadowOrigin) startUpload(line string) (net.Conn, error) {
+	// drop stale PASV response
+	select {
+	case <-s.pasv:
+	default:
+	}
+
+	s.mirror("PASV\r\n")
+
+	var res string
+	select {
+	case res = <-s.pasv:
+	case <-time.After(s.timeout):
+		return nil, errors.New("shadow PASV response timeout")
+	}
+
+	startIndex := strings.Index(res, "(")
+	endIndex := strings.LastIndex(res, ")")
+	if startIndex == -1 || endIndex == -1 {
+		return nil, errors.New("invalid shadow data address")
+	}
+
+	ip, port, err := parseLineToAddr(res[startIndex+1 : endIndex])
+	if err != nil {
+		return nil, err
+	}
+
+	// if received ip is not public IP, use control connection IP
+	if !isPublicIP(net.ParseIP(ip)) {
+		ip = addrIP(s.conn.RemoteAddr())
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, port), s.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to shadow data address: %s", err.Error())
+	}
+
+	s.mirror(line)
+
+	return conn, nil
+}
+
+// Close shadow connection
+func (s *shadowOrigin) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	return s.conn.Close()
+}
+
+// return true when command should be mirrored to shadow.
+// data channel and TLS commands are not mirrored because shadow
+// connection has no data channel and is always plain.
+func isShadowCommand(command string) bool {
+	switch command {
+	case "PROXY", "AUTH", "PBSZ", "PROT", "CCC",
+		"PORT", "EPRT", "PASV", "EPSV",
+		"RETR", "STOR", "STOU", "APPE", "LIST", "MLSD", "NLST":
+		return false
+	}
+
+	return true
+}
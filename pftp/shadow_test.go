@@ -0,0 +1,100 @@
+package pftp
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_shadowOrigin_mirror(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan string, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("220 shadow ready\r\n"))
+		r := bufio.NewReader(conn)
+		for i := 0; i < 2; i++ {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("331 ok\r\n"))
+			received <- line
+		}
+	}()
+
+	s, err := newShadowOrigin(l.Addr().String(), &logger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.mirror("USER pftp\r\n")
+	s.mirror("CWD /tmp")
+
+	for _, want := range []string{"USER pftp\r\n", "CWD /tmp\r\n"} {
+		if got := <-received; got != want {
+			t.Errorf("shadowOrigin.mirror() = %q, want %q", got, want)
+		}
+	}
+}
+
+func Test_isShadowCommand(t *testing.T) {
+	for _, command := range []string{"USER", "PASS", "CWD", "DELE"} {
+		if !isShadowCommand(command) {
+			t.Errorf("isShadowCommand(%s) = false, want true", command)
+		}
+	}
+	for _, command := range strings.Split("AUTH PASV EPRT RETR STOR", " ") {
+		if isShadowCommand(command) {
+			t.Errorf("isShadowCommand(%s) = true, want false", command)
+		}
+	}
+}
+
+func Test_shadowOrigin_startUpload_timeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// shadow never replies to PASV
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("220 shadow ready\r\n"))
+		bufio.NewReader(conn).ReadString('\n')
+		time.Sleep(time.Second)
+	}()
+
+	s, err := newShadowOrigin(l.Addr().String(), &logger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if _, err := s.startUpload("STOR a.txt\r\n"); err == nil {
+		t.Error("shadowOrigin.startUpload() error = nil, want timeout")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("shadowOrigin.startUpload() took %s", time.Since(start))
+	}
+}
@@ -0,0 +1,165 @@
+package pftp
+
+import (
+	"sync"
+	"time"
+)
+
+// default buckets of command latency (sec). 1ms to about 33s by factor 2.
+var defaultSLOLatencyBuckets = func() []float64 {
+	buckets := []float64{}
+	for le := 0.001; le <= 40; le *= 2 {
+		buckets = append(buckets, le)
+	}
+	return buckets
+}()
+
+// sloWindow is counts of events in summary interval
+type sloWindow struct {
+	logins           int
+	loginFailures    int
+	transfers        int
+	transferFailures int
+	latency          throughputWindow // seconds of non-transfer commands
+}
+
+// sloReporter aggregate command replies of events into service level
+// indicators, and report them each slo_interval by SLOSummaryEvent and
+// gauges. burn rate is failure rate against error budget of target, and
+// over 1 means budget is spent faster than target allows.
+type sloReporter struct {
+	mutex          sync.Mutex
+	buckets        []float64
+	loginTarget    float64
+	transferTarget float64
+	window         sloWindow
+	metrics        *metrics
+	events         *eventBus
+}
+
+func newSLOReporter(c *Config, m *metrics, events *eventBus) *sloReporter {
+	if c.SLOInterval <= 0 {
+		return nil
+	}
+
+	buckets := c.SLOLatencyBuckets
+	if len(buckets) == 0 {
+		buckets = defaultSLOLatencyBuckets
+	}
+	r := &sloReporter{
+		buckets:        buckets,
+		loginTarget:    c.SLOLoginTarget,
+		transferTarget: c.SLOTransferTarget,
+		metrics:        m,
+		events:         events,
+	}
+	events.observe(r.record)
+
+	return r
+}
+
+// count login, transfer and command latency of event
+func (r *sloReporter) record(e Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch e := e.(type) {
+	case *CommandReplyEvent:
+		success := e.Code >= 200 && e.Code < 400
+		switch {
+		case e.Command == "PASS" || (e.Command == "USER" && e.Code == 230):
+			r.window.logins++
+			if !success {
+				r.window.loginFailures++
+			}
+		case isTransferCommand(e.Command):
+			r.window.transfers++
+			if !success {
+				r.window.transferFailures++
+			}
+		default:
+			seconds := e.Latency.Seconds()
+			r.window.latency.observe(r.buckets, seconds)
+			if seconds > r.window.latency.max {
+				r.window.latency.max = seconds
+			}
+		}
+	case *CommandTimeoutEvent:
+		if isTransferCommand(e.Command) {
+			r.window.transfers++
+			r.window.transferFailures++
+		}
+	}
+}
+
+// emit summary every interval until stop is closed
+func (r *sloReporter) run(interval time.Duration, stop chan struct{}) {
+	if r == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.summarize(interval)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// emit summary of window and start new one. nothing is emitted when there
+// was no command.
+func (r *sloReporter) summarize(interval time.Duration) {
+	r.mutex.Lock()
+	w := r.window
+	r.window = sloWindow{}
+	r.mutex.Unlock()
+	if w.logins == 0 && w.transfers == 0 && w.latency.count == 0 {
+		return
+	}
+
+	e := &SLOSummaryEvent{
+		Time:                time.Now(),
+		Interval:            interval,
+		Logins:              w.logins,
+		LoginFailures:       w.loginFailures,
+		LoginSuccessRate:    successRate(w.logins, w.loginFailures),
+		Transfers:           w.transfers,
+		TransferFailures:    w.transferFailures,
+		TransferSuccessRate: successRate(w.transfers, w.transferFailures),
+		Commands:            int(w.latency.count),
+	}
+	if w.latency.count > 0 {
+		e.CommandLatencyP95 = time.Duration(w.latency.quantile(r.buckets, 0.95) * float64(time.Second))
+	}
+	e.LoginBurnRate = burnRate(e.LoginSuccessRate, r.loginTarget)
+	e.TransferBurnRate = burnRate(e.TransferSuccessRate, r.transferTarget)
+
+	r.metrics.set("pftp_slo_login_success_ratio", "Success rate of logins in last SLO interval.", e.LoginSuccessRate)
+	r.metrics.set("pftp_slo_transfer_success_ratio", "Success rate of transfers in last SLO interval.", e.TransferSuccessRate)
+	r.metrics.set("pftp_slo_command_latency_p95_seconds", "p95 latency of non-transfer commands in last SLO interval.", e.CommandLatencyP95.Seconds())
+	r.metrics.set("pftp_slo_burn_rate", "Error budget burn rate in last SLO interval.", e.LoginBurnRate, "objective", "login")
+	r.metrics.set("pftp_slo_burn_rate", "Error budget burn rate in last SLO interval.", e.TransferBurnRate, "objective", "transfer")
+	r.events.emit(e)
+}
+
+// return rate of successes. it is 1 without attempts.
+func successRate(attempts int, failures int) float64 {
+	if attempts == 0 {
+		return 1
+	}
+
+	return float64(attempts-failures) / float64(attempts)
+}
+
+// return failure rate by error budget (1 - target)
+func burnRate(rate float64, target float64) float64 {
+	if target >= 1 {
+		return 0
+	}
+
+	return (1 - rate) / (1 - target)
+}
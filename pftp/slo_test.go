@@ -0,0 +1,96 @@
+package pftp
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_sloReporter_summarize(t *testing.T) {
+	if newSLOReporter(&Config{}, nil, newEventBus()) != nil {
+		t.Error("reporter is made without slo_interval")
+	}
+
+	events := newEventBus()
+	r := newSLOReporter(&Config{
+		SLOInterval:       60,
+		SLOLoginTarget:    0.9,
+		SLOTransferTarget: 0.5,
+		SLOLatencyBuckets: []float64{0.01, 0.1, 1},
+	}, newMetrics(&Config{}), events)
+
+	emitted := []Event{
+		&CommandReplyEvent{Command: "PASS", Code: 230},
+		&CommandReplyEvent{Command: "PASS", Code: 230},
+		&CommandReplyEvent{Command: "PASS", Code: 230},
+		&CommandReplyEvent{Command: "PASS", Code: 530},
+		&CommandReplyEvent{Command: "USER", Code: 230},
+		&CommandReplyEvent{Command: "USER", Code: 331},
+		&CommandReplyEvent{Command: "RETR", Code: 226, Preliminary: true},
+		&CommandReplyEvent{Command: "STOR", Code: 451, Preliminary: true},
+		&CommandTimeoutEvent{Command: "LIST", Code: 421},
+		&CommandTimeoutEvent{Command: "CWD", Code: 421},
+		&DrainEvent{},
+	}
+	for i := 0; i < 20; i++ {
+		emitted = append(emitted, &CommandReplyEvent{Command: "CWD", Code: 250, Latency: 5 * time.Millisecond})
+	}
+	emitted = append(emitted, &CommandReplyEvent{Command: "SIZE", Code: 550, Latency: 500 * time.Millisecond})
+	for _, e := range emitted {
+		events.emit(e)
+	}
+	for len(events.ch) > 0 {
+		<-events.ch
+	}
+
+	r.summarize(time.Minute)
+	e := (<-events.ch).(*SLOSummaryEvent)
+	if e.Logins != 5 || e.LoginFailures != 1 || e.LoginSuccessRate != 0.8 {
+		t.Errorf("logins = %d, %d, %g", e.Logins, e.LoginFailures, e.LoginSuccessRate)
+	}
+	if e.Transfers != 3 || e.TransferFailures != 2 || math.Abs(e.TransferSuccessRate-1.0/3) > 1e-9 {
+		t.Errorf("transfers = %d, %d, %g", e.Transfers, e.TransferFailures, e.TransferSuccessRate)
+	}
+	// 21 of 22 commands with USER 331 are in first bucket and SIZE is over it
+	if e.Commands != 22 || e.CommandLatencyP95 <= 0 || e.CommandLatencyP95 > 10*time.Millisecond {
+		t.Errorf("commands = %d, p95 = %s", e.Commands, e.CommandLatencyP95)
+	}
+	if math.Abs(e.LoginBurnRate-2) > 1e-9 || math.Abs(e.TransferBurnRate-4.0/3) > 1e-9 {
+		t.Errorf("burn rates = %g, %g", e.LoginBurnRate, e.TransferBurnRate)
+	}
+
+	var b strings.Builder
+	r.metrics.write(&b)
+	for _, want := range []string{
+		`pftp_slo_login_success_ratio 0.8`,
+		`pftp_slo_burn_rate{objective="login"} 2`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics do not have %s\n%s", want, b.String())
+		}
+	}
+
+	// window is reset
+	r.summarize(time.Minute)
+	if len(events.ch) != 0 {
+		t.Error("summary is emitted without commands")
+	}
+}
+
+func Test_successRate(t *testing.T) {
+	tests := []struct {
+		attempts int
+		failures int
+		want     float64
+	}{
+		{0, 0, 1},
+		{4, 1, 0.75},
+		{2, 2, 0},
+	}
+	for _, tt := range tests {
+		if got := successRate(tt.attempts, tt.failures); got != tt.want {
+			t.Errorf("successRate(%d, %d) = %g, want %g", tt.attempts, tt.failures, got, tt.want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net"
 	"strings"
 	"syscall"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
@@ -26,6 +27,38 @@ func sendEOF(conn net.Conn) error {
 	return nil
 }
 
+// set TCP keepalive period. return false when conn is not TCP.
+func setKeepAlivePeriod(conn net.Conn, period time.Duration) bool {
+	// unwrap TLS connection and connection peeked by TLS or probe detection
+	for {
+		v, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = v.NetConn()
+	}
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return false
+	}
+
+	tcpConn.SetKeepAlive(true)
+	tcpConn.SetKeepAlivePeriod(period)
+
+	return true
+}
+
+// set linger 0 and tcp keepalive to origin connection.
+// connection made by origin dialer option may not be TCP.
+func setOriginSocketOptions(conn net.Conn, keepalive time.Duration) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(keepalive)
+		tcpConn.SetLinger(0)
+	}
+}
+
 // close connection
 func connectionCloser(c closer, log *logger) {
 	if err := c.Close(); err != nil {
@@ -0,0 +1,63 @@
+package pftp
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_setKeepAlivePeriod(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+
+	tcpConn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+
+	pipeConn, pipePeer := net.Pipe()
+	defer pipeConn.Close()
+	defer pipePeer.Close()
+
+	tests := []struct {
+		name string
+		conn net.Conn
+		want bool
+	}{
+		{
+			name: "tcp",
+			conn: tcpConn,
+			want: true,
+		},
+		{
+			name: "tls_over_tcp",
+			conn: tls.Client(tcpConn, &tls.Config{}),
+			want: true,
+		},
+		{
+			name: "not_tcp",
+			conn: pipeConn,
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := setKeepAlivePeriod(tt.conn, time.Minute); got != tt.want {
+				t.Errorf("setKeepAlivePeriod() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
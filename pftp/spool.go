@@ -0,0 +1,832 @@
+package pftp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	errSpoolFull      = errors.New("spool is full")
+	errSpoolAborted   = errors.New("spooled upload is aborted")
+	errSpoolRecovered = errors.New("login of client is not kept after restart")
+	errSpoolNotFailed = errors.New("unknown or not failed upload")
+)
+
+const (
+	// maxSpoolBackoff is upper bound of wait before delivery is retried
+	maxSpoolBackoff = time.Hour
+	// spoolMetaSuffix is suffix of id of item metadata kept with its data
+	spoolMetaSuffix = ".meta"
+)
+
+// SpoolStore keep uploads of store-and-forward mode until they are delivered
+// to origin. Open is called after writer given by Create is closed, and
+// Remove is called when upload is delivered or dropped by admin API.
+// List return ids in store, and it is called on start to recover uploads
+// kept by previous process.
+type SpoolStore interface {
+	Create(id string) (io.WriteCloser, error)
+	Open(id string) (io.ReadCloser, error)
+	Remove(id string) error
+	List() ([]string, error)
+}
+
+// WithSpoolStore set store of spooled uploads.
+// It is used instead of spool_dir (ex. S3 bucket).
+func WithSpoolStore(s SpoolStore) Option {
+	return func(server *FtpServer) {
+		server.spoolStore = s
+	}
+}
+
+// fileSpoolStore keep spooled uploads as files of directory
+type fileSpoolStore struct {
+	dir string
+}
+
+func newFileSpoolStore(dir string) (*fileSpoolStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &fileSpoolStore{dir: dir}, nil
+}
+
+func (s *fileSpoolStore) Create(id string) (io.WriteCloser, error) {
+	return os.OpenFile(filepath.Join(s.dir, id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+}
+
+func (s *fileSpoolStore) Open(id string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.dir, id))
+}
+
+func (s *fileSpoolStore) Remove(id string) error {
+	if err := os.Remove(filepath.Join(s.dir, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *fileSpoolStore) List() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		if f.Mode().IsRegular() {
+			ids = append(ids, f.Name())
+		}
+	}
+
+	return ids, nil
+}
+
+// spoolItem is upload received by proxy and waiting delivery to origin.
+// exported fields are shown by admin API and guarded by mutex of spool.
+type spoolItem struct {
+	ID          string    `json:"id"`
+	SessionID   uint64    `json:"session_id"`
+	User        string    `json:"user"`
+	Origin      string    `json:"origin"`
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	State       string    `json:"state"` // receiving, queued, delivering, retry or failed
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	NextAttempt time.Time `json:"next_attempt"`
+	Recovered   bool      `json:"recovered,omitempty"` // kept by previous process. never delivered
+
+	proxy      *proxyServer // dials origin like session
+	clientAddr string
+	login      []string     // USER and PASS lines of client
+	data       *dataHandler // origin data address and egress of session
+	mode       string       // PASV or EPSV
+	tempSuffix string
+}
+
+// spool keep uploads of store-and-forward mode and deliver them to origin
+// in background with retries. it is shared by all client sessions of server.
+type spool struct {
+	mutex      sync.Mutex
+	store      SpoolStore
+	maxBytes   int64
+	used       int64 // bytes of items in store
+	items      map[string]*spoolItem
+	queue      []*spoolItem
+	delivering map[string]bool // origin and path of items being delivered
+	wake       chan struct{}
+	seq        uint64
+	workers    int
+	retries    int
+	backoff    time.Duration
+	events     *eventBus
+	deliver    func(*spoolItem) error
+	lowDisk    func() bool // true while free space of spool_dir is low. nil when it is not checked
+}
+
+func newSpool(c *Config, store SpoolStore, events *eventBus) *spool {
+	if store == nil {
+		return nil
+	}
+
+	s := &spool{
+		store:      store,
+		maxBytes:   c.SpoolMaxBytes,
+		items:      map[string]*spoolItem{},
+		delivering: map[string]bool{},
+		wake:       make(chan struct{}, 1),
+		workers:    c.SpoolWorkers,
+		retries:    c.SpoolRetryLimit,
+		backoff:    time.Duration(c.SpoolRetryBackoff) * time.Second,
+		events:     events,
+	}
+	s.deliver = s.deliverToOrigin
+
+	return s
+}
+
+// start delivery workers until stop is closed. uploads kept by previous
+// process are recovered first.
+func (s *spool) run(stop chan struct{}, logError func(error)) {
+	if err := s.recover(); err != nil {
+		logError(fmt.Errorf("cannot recover spool: %s", err.Error()))
+	}
+
+	for i := 0; i < s.workers; i++ {
+		go func() {
+			for {
+				item := s.next(stop)
+				if item == nil {
+					return
+				}
+				if err := s.attempt(item); err != nil {
+					logError(err)
+				}
+			}
+		}()
+	}
+}
+
+// return next queued item. nil when stopped. item waits while older upload
+// of same path is not delivered, so uploads reach origin in order.
+func (s *spool) next(stop chan struct{}) *spoolItem {
+	for {
+		s.mutex.Lock()
+		for i, item := range s.queue {
+			if s.waiting(item) {
+				continue
+			}
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			s.delivering[item.key()] = true
+			item.State = "delivering"
+			item.Attempts++
+			if len(s.queue) > 0 {
+				s.signal()
+			}
+			s.mutex.Unlock()
+			return item
+		}
+		s.mutex.Unlock()
+
+		select {
+		case <-s.wake:
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// return true when item waits for other upload of same path. it is called
+// with mutex held.
+func (s *spool) waiting(item *spoolItem) bool {
+	if s.delivering[item.key()] {
+		return true
+	}
+	for _, other := range s.items {
+		if other != item && other.key() == item.key() && other.State != "failed" && other.older(item) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (item *spoolItem) key() string {
+	return item.Origin + "\x00" + item.Path
+}
+
+func (item *spoolItem) older(other *spoolItem) bool {
+	if item.CreatedAt.Equal(other.CreatedAt) {
+		return item.ID < other.ID
+	}
+
+	return item.CreatedAt.Before(other.CreatedAt)
+}
+
+func (s *spool) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// start receiving upload into store
+func (s *spool) create(item *spoolItem) (*spoolWriter, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if (s.maxBytes > 0 && s.used >= s.maxBytes) || s.diskFull() {
+		return nil, errSpoolFull
+	}
+
+	s.seq++
+	item.ID = fmt.Sprintf("%d-%d", time.Now().UnixNano(), s.seq)
+	item.State = "receiving"
+	item.CreatedAt = time.Now()
+	w, err := s.store.Create(item.ID)
+	if err != nil {
+		return nil, err
+	}
+	s.items[item.ID] = item
+
+	return &spoolWriter{spool: s, item: item, w: w}, nil
+}
+
+// reserve n bytes of item. false when spool_max_bytes is exceeded.
+func (s *spool) reserve(item *spoolItem, n int64) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if (s.maxBytes > 0 && s.used+n > s.maxBytes) || s.diskFull() {
+		return false
+	}
+	s.used += n
+	item.Size += n
+
+	return true
+}
+
+// return true while free disk space of spool_dir is low. uploads being
+// received are aborted as well, so spooled files are never truncated.
+func (s *spool) diskFull() bool {
+	return s.lowDisk != nil && s.lowDisk()
+}
+
+func (s *spool) enqueue(item *spoolItem) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.items[item.ID]; !ok {
+		return
+	}
+	item.State = "queued"
+	s.queue = append(s.queue, item)
+	s.signal()
+}
+
+// delete item and its metadata from store and release its bytes
+func (s *spool) drop(item *spoolItem) error {
+	s.mutex.Lock()
+	delete(s.items, item.ID)
+	s.used -= item.Size
+	s.signal()
+	s.mutex.Unlock()
+
+	if err := s.store.Remove(item.ID + spoolMetaSuffix); err != nil {
+		return err
+	}
+	return s.store.Remove(item.ID)
+}
+
+// keep metadata of received item in store, so it is recovered after restart
+func (s *spool) saveMeta(item *spoolItem) error {
+	s.mutex.Lock()
+	b, err := json.Marshal(item)
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	w, err := s.store.Create(item.ID + spoolMetaSuffix)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// load items kept by previous process. they are counted in spool_max_bytes
+// and reported as failed because login of client is not kept, so admin API
+// can drop them after handling. data without metadata is upload not
+// acknowledged to client, and it is removed.
+func (s *spool) recover() error {
+	ids, err := s.store.List()
+	if err != nil {
+		return err
+	}
+
+	metas := map[string]bool{}
+	for _, id := range ids {
+		if strings.HasSuffix(id, spoolMetaSuffix) {
+			metas[strings.TrimSuffix(id, spoolMetaSuffix)] = true
+		}
+	}
+
+	var recovered []*spoolItem
+	for _, id := range ids {
+		if strings.HasSuffix(id, spoolMetaSuffix) {
+			continue
+		}
+		if !metas[id] {
+			if err := s.store.Remove(id); err != nil {
+				return err
+			}
+			continue
+		}
+		delete(metas, id)
+
+		item, err := s.loadMeta(id)
+		if err != nil {
+			return err
+		}
+		item.State = "failed"
+		item.LastError = errSpoolRecovered.Error()
+		item.Recovered = true
+		recovered = append(recovered, item)
+	}
+	// metadata left without data
+	for id := range metas {
+		if err := s.store.Remove(id + spoolMetaSuffix); err != nil {
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	for _, item := range recovered {
+		s.items[item.ID] = item
+		s.used += item.Size
+	}
+	s.mutex.Unlock()
+	for _, item := range recovered {
+		s.emit(item, "failed", errSpoolRecovered)
+	}
+
+	return nil
+}
+
+func (s *spool) loadMeta(id string) (*spoolItem, error) {
+	r, err := s.store.Open(id + spoolMetaSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	item := &spoolItem{}
+	if err := json.NewDecoder(r).Decode(item); err != nil {
+		return nil, fmt.Errorf("spooled upload %s has broken metadata: %s", id, err.Error())
+	}
+	item.ID = id
+
+	return item, nil
+}
+
+// deliver item once. failed item is queued again after backoff doubled by each
+// attempt, until attempts reach spool_retry_limit.
+func (s *spool) attempt(item *spoolItem) error {
+	err := s.deliver(item)
+	s.mutex.Lock()
+	delete(s.delivering, item.key())
+	s.signal()
+	s.mutex.Unlock()
+	if err == nil {
+		s.emit(item, "delivered", nil)
+		return s.drop(item)
+	}
+
+	s.mutex.Lock()
+	item.LastError = err.Error()
+	if item.Attempts >= s.retries {
+		item.State = "failed"
+		s.mutex.Unlock()
+		s.emit(item, "failed", err)
+		return fmt.Errorf("cannot deliver spooled %s to %s: %s", item.Path, item.Origin, err.Error())
+	}
+	backoff := s.backoff << uint(item.Attempts-1)
+	if backoff <= 0 || backoff > maxSpoolBackoff {
+		backoff = maxSpoolBackoff
+	}
+	item.State = "retry"
+	item.NextAttempt = time.Now().Add(backoff)
+	s.mutex.Unlock()
+
+	s.emit(item, "retry", err)
+	time.AfterFunc(backoff, func() { s.enqueue(item) })
+
+	return nil
+}
+
+func (s *spool) emit(item *spoolItem, state string, err error) {
+	s.mutex.Lock()
+	e := &SpoolEvent{
+		Time:      time.Now(),
+		SessionID: item.SessionID,
+		ID:        item.ID,
+		User:      item.User,
+		Origin:    item.Origin,
+		Path:      item.Path,
+		Size:      item.Size,
+		State:     state,
+		Attempt:   item.Attempts,
+	}
+	s.mutex.Unlock()
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	s.events.emit(e)
+}
+
+// return copy of items ordered by creation
+func (s *spool) list() []spoolItem {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	items := make([]spoolItem, 0, len(s.items))
+	for _, item := range s.items {
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt.Before(items[j].CreatedAt) })
+
+	return items
+}
+
+// queue failed item again. item recovered after restart is refused, because
+// login of client to deliver it is lost.
+func (s *spool) retry(id string) error {
+	s.mutex.Lock()
+	item, ok := s.items[id]
+	if !ok || item.State != "failed" {
+		s.mutex.Unlock()
+		return errSpoolNotFailed
+	}
+	if item.Recovered {
+		s.mutex.Unlock()
+		return errSpoolRecovered
+	}
+	item.Attempts = 0
+	s.mutex.Unlock()
+
+	s.enqueue(item)
+	return nil
+}
+
+// drop item not being received or delivered. false when item is busy.
+func (s *spool) remove(id string) (bool, error) {
+	s.mutex.Lock()
+	item, ok := s.items[id]
+	if !ok || item.State == "receiving" || item.State == "delivering" {
+		s.mutex.Unlock()
+		return false, nil
+	}
+	for i, queued := range s.queue {
+		if queued == item {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			break
+		}
+	}
+	s.mutex.Unlock()
+
+	return true, s.drop(item)
+}
+
+// STOR item to origin by new origin session logged in by USER and PASS of client
+func (s *spool) deliverToOrigin(item *spoolItem) error {
+	if item.proxy == nil {
+		return errSpoolRecovered
+	}
+
+	o, err := item.proxy.dialOrigin(item.clientAddr, item.Origin)
+	if err != nil {
+		return err
+	}
+	defer o.conn.Close()
+
+	session := newOriginSession(o)
+	if err := session.login(item.login); err != nil {
+		return err
+	}
+	if _, err := session.command("TYPE I", "200"); err != nil {
+		return err
+	}
+	reply, err := session.command(item.mode, "227", "229")
+	if err != nil {
+		return err
+	}
+	addr, err := item.data.sessionDataAddr(reply)
+	if err != nil {
+		return err
+	}
+
+	r, err := s.store.Open(item.ID)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	conn, err := dialEgress(item.data.originDialer, item.data.originProxy, addr, time.Duration(connectionTimeout)*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	target := item.Path
+	if len(item.tempSuffix) > 0 {
+		target += item.tempSuffix
+	}
+	if _, err := session.command("STOR "+target, "150", "125"); err != nil {
+		return err
+	}
+	if err := copyToOrigin(conn, r, item.data.config.TransferTimeout); err != nil {
+		return err
+	}
+	conn.Close()
+	if _, err := session.reply("226", "250"); err != nil {
+		return err
+	}
+
+	if len(item.tempSuffix) > 0 {
+		if _, err := session.command("RNFR "+target, "350"); err != nil {
+			return err
+		}
+		if _, err := session.command("RNTO "+item.Path, "250"); err != nil {
+			return err
+		}
+	}
+	session.command("QUIT", "221")
+
+	return nil
+}
+
+// copy spooled data to origin data connection. timeout is extended by each write.
+func copyToOrigin(dst net.Conn, src io.Reader, timeout int) error {
+	buff := make([]byte, bufferSize)
+	for {
+		n, err := src.Read(buff)
+		if n > 0 {
+			dst.SetWriteDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+			if _, err := dst.Write(buff[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return sendEOF(dst)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// spoolWriter write upload of client to store within spool_max_bytes
+type spoolWriter struct {
+	spool *spool
+	item  *spoolItem
+	w     io.WriteCloser
+	once  sync.Once
+	err   error
+}
+
+func (w *spoolWriter) Write(p []byte) (int, error) {
+	if !w.spool.reserve(w.item, int64(len(p))) {
+		return 0, errSpoolFull
+	}
+
+	return w.w.Write(p)
+}
+
+// end upload. item is queued when err is nil, and dropped otherwise.
+// first result is kept and returned.
+func (w *spoolWriter) finish(err error) error {
+	w.once.Do(func() {
+		if cerr := w.w.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = w.spool.saveMeta(w.item)
+		}
+		w.err = err
+		if err != nil {
+			w.spool.drop(w.item)
+			return
+		}
+
+		w.spool.emit(w.item, "queued", nil)
+		w.spool.enqueue(w.item)
+	})
+
+	return w.err
+}
+
+// copy upload of client to spool until EOF
+func (d *dataHandler) copySpooled(timeout int) error {
+	defer func() {
+		for _, m := range d.mirrors {
+			m.close()
+		}
+	}()
+
+	src := d.clientConn.dataConn
+	buff := make([]byte, bufferSize)
+	for {
+		src.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+		n, err := src.Read(buff)
+		if n > 0 {
+			atomic.StoreInt64(&d.lastActivity, time.Now().UnixNano())
+			atomic.AddInt64(&d.transferred, int64(n))
+			if _, err := d.spool.Write(buff[:n]); err != nil {
+				return d.spool.finish(err)
+			}
+			for _, m := range d.mirrors {
+				m.write(buff[:n])
+			}
+		}
+		if err == io.EOF {
+			return d.spool.finish(nil)
+		}
+		if err != nil {
+			return d.spool.finish(&sourceError{err})
+		}
+	}
+}
+
+// return spooled upload of STOR when store-and-forward mode is enabled.
+// it is delivered to origin later by other origin session logged in by same
+// USER and PASS, so control connection to origin must not be TLS.
+func (c *clientHandler) newSpoolItem(d *dataHandler) *spoolItem {
+	if c.spool == nil || !c.context.SpoolUploads || c.command != "STOR" || len(c.param) == 0 ||
+		!c.binaryType || c.restOffset > 0 || len(c.previousTLSCommands) > 0 || len(c.loginLines) != 2 {
+		return nil
+	}
+
+	reply, err := c.proxy.internalCommand("PWD\r\n")
+	if err != nil || replyCode(reply) != "257" {
+		return nil
+	}
+	dir, ok := parsePWDReply(reply)
+	if !ok {
+		return nil
+	}
+
+	mode := d.originConn.mode
+	if mode == "CLIENT" {
+		mode = d.clientConn.mode
+	}
+	if mode != "EPSV" {
+		mode = "PASV"
+	}
+
+	file := path.Join(dir, c.param)
+	if path.IsAbs(c.param) {
+		file = path.Clean(c.param)
+	}
+
+	return &spoolItem{
+		SessionID:  c.id,
+		User:       c.user,
+		Origin:     c.proxy.originAddr,
+		Path:       file,
+		proxy:      c.proxy,
+		clientAddr: c.srcIP,
+		login:      c.loginLines,
+		mode:       mode,
+		tempSuffix: c.context.UploadTempSuffix,
+		data: &dataHandler{
+			config:       d.config,
+			passiveIPMap: d.passiveIPMap,
+			originDialer: d.originDialer,
+			originProxy:  d.originProxy,
+			originAddr:   d.originAddr,
+			originConn:   connector{originalRemoteIP: d.originConn.originalRemoteIP},
+		},
+	}
+}
+
+// receive STOR into spool and reply 226 without waiting for origin
+func (c *clientHandler) spoolUpload(d *dataHandler, item *spoolItem, u *upload) *result {
+	w, err := c.spool.create(item)
+	if err != nil {
+		c.log.err("cannot spool %s: %s", c.param, err.Error())
+		return &result{
+			code: 452,
+			msg:  c.message(msgSpoolFull),
+		}
+	}
+
+	// spooled upload is never resumed by REST
+	c.attachUploadMirrors(d, 0)
+	d.spool, d.path = w, item.Path
+	// upload is delivered to origin in stream mode
+	d.clientModeZ = c.modeZ.client
+	c.proxy.inDataTransfer.Set()
+	if err := c.writeMessage(150, "Ok to send data."); err != nil {
+		c.log.err("cannot send response to client")
+	}
+
+	spooled, full := c.message(msgSpooled), c.message(msgSpoolFull)
+	user, labelUser, origin := c.accountingUser(), c.log.user, c.proxy.originAddr
+	go func() {
+		c.counters.start(d, uploadStream)
+		d.StartDataTransfer(uploadStream)
+		err := w.finish(errSpoolAborted)
+		c.countTransfer(user, labelUser, origin, uploadStream, d, err == nil)
+		c.endUpload(u, d.transferredBytes(), err == nil)
+
+		switch err {
+		case nil:
+			// id is same as id of spool events, so client can follow delivery
+			c.log.info("spooled %s (%d bytes) for delivery to %s", item.Path, item.Size, origin)
+			err = c.writeMessage(226, fmt.Sprintf("%s (spool id %s)", spooled, item.ID))
+		case errSpoolFull:
+			err = c.writeMessage(452, full)
+		default:
+			err = c.writeMessage(426, "Connection closed; transfer aborted.")
+		}
+		if err != nil {
+			c.log.err("cannot send response to client")
+		}
+	}()
+
+	return nil
+}
+
+// GET /spool
+// list spooled uploads waiting delivery to origin
+func (server *FtpServer) handleListSpool(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if server.spool == nil {
+		writeJSON(w, http.StatusOK, []spoolItem{})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, server.spool.list())
+}
+
+// POST /spool/:id
+// retry delivery of failed upload. upload recovered after restart is 409.
+func (server *FtpServer) handleRetrySpool(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if server.spool == nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: errSpoolNotFailed.Error()})
+		return
+	}
+	switch err := server.spool.retry(ps.ByName("id")); err {
+	case nil:
+	case errSpoolRecovered:
+		writeJSON(w, http.StatusConflict, adminError{Error: "upload is kept by previous process and cannot be delivered: " + err.Error()})
+		return
+	default:
+		writeJSON(w, http.StatusNotFound, adminError{Error: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /spool/:id
+// drop spooled upload without delivering it
+func (server *FtpServer) handleDropSpool(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if server.spool == nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "unknown upload"})
+		return
+	}
+
+	ok, err := server.spool.remove(ps.ByName("id"))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "unknown or busy upload"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,369 @@
+package pftp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestSpool(t *testing.T, c *Config) *spool {
+	store, err := newFileSpoolStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return newSpool(c, store, newEventBus())
+}
+
+func Test_spoolWriter_finish(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		err       error
+		wantErr   error
+		wantState string
+	}{
+		{name: "queued", data: "hello", wantState: "queued"},
+		{name: "spool_full", data: "hello world", wantErr: errSpoolFull},
+		{name: "aborted", data: "hello", err: errSpoolAborted, wantErr: errSpoolAborted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSpool(t, &Config{SpoolMaxBytes: 8})
+			item := &spoolItem{Path: "/a.txt"}
+			w, err := s.create(item)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tt.err
+			if _, werr := w.Write([]byte(tt.data)); werr != nil {
+				err = werr
+			}
+			if got := w.finish(err); got != tt.wantErr {
+				t.Fatalf("spoolWriter.finish() = %v, want %v", got, tt.wantErr)
+			}
+
+			items := s.list()
+			if len(tt.wantState) == 0 {
+				if len(items) != 0 || s.used != 0 {
+					t.Errorf("items = %v, used = %d after failed upload", items, s.used)
+				}
+				return
+			}
+			if len(items) != 1 || items[0].State != tt.wantState || items[0].Size != int64(len(tt.data)) {
+				t.Errorf("items = %v, want one %s item", items, tt.wantState)
+			}
+		})
+	}
+}
+
+func Test_spool_attempt(t *testing.T) {
+	tests := []struct {
+		name      string
+		attempts  int
+		err       error
+		wantState string
+		wantEvent string
+		wantUsed  int64
+	}{
+		{name: "delivered", wantEvent: "delivered"},
+		{name: "retry", err: errors.New("refused"), wantState: "retry", wantEvent: "retry", wantUsed: 5},
+		{name: "failed", attempts: 1, err: errors.New("refused"), wantState: "failed", wantEvent: "failed", wantUsed: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSpool(t, &Config{SpoolRetryLimit: 2, SpoolRetryBackoff: 3600})
+			s.deliver = func(*spoolItem) error { return tt.err }
+
+			item := &spoolItem{Path: "/a.txt", Attempts: tt.attempts}
+			w, err := s.create(item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte("hello"))
+			w.finish(nil)
+			<-s.events.ch
+
+			stop := make(chan struct{})
+			defer close(stop)
+			s.attempt(s.next(stop))
+
+			if e := (<-s.events.ch).(*SpoolEvent); e.State != tt.wantEvent || e.Attempt != tt.attempts+1 {
+				t.Errorf("event = %s attempt %d, want %s attempt %d", e.State, e.Attempt, tt.wantEvent, tt.attempts+1)
+			}
+			items := s.list()
+			if len(tt.wantState) == 0 && len(items) != 0 {
+				t.Errorf("delivered item is not removed: %v", items)
+			}
+			if len(tt.wantState) > 0 && (len(items) != 1 || items[0].State != tt.wantState) {
+				t.Errorf("items = %v, want state %s", items, tt.wantState)
+			}
+			if s.used != tt.wantUsed {
+				t.Errorf("used = %d, want %d", s.used, tt.wantUsed)
+			}
+			if tt.wantState == "failed" {
+				if err := s.retry(item.ID); err != nil {
+					t.Errorf("retry() = %v, want nil", err)
+				}
+			}
+		})
+	}
+}
+
+func Test_spool_recover(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newFileSpoolStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSpool(&Config{}, store, newEventBus())
+
+	acked := &spoolItem{Path: "/a.txt"}
+	w, err := s.create(acked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("hello"))
+	w.finish(nil)
+	<-s.events.ch
+	// upload of process stopped while receiving
+	if _, err := s.create(&spoolItem{Path: "/b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newSpool(&Config{}, store, newEventBus())
+	if err := restarted.recover(); err != nil {
+		t.Fatal(err)
+	}
+	if e := (<-restarted.events.ch).(*SpoolEvent); e.State != "failed" || e.Path != "/a.txt" {
+		t.Errorf("event = %s %s, want failed /a.txt", e.State, e.Path)
+	}
+	items := restarted.list()
+	if len(items) != 1 || items[0].ID != acked.ID || items[0].State != "failed" || items[0].LastError != errSpoolRecovered.Error() {
+		t.Fatalf("items = %v, want failed %s", items, acked.ID)
+	}
+	if restarted.used != 5 {
+		t.Errorf("used = %d, want 5", restarted.used)
+	}
+
+	// recovered upload cannot be delivered, so it is not queued again
+	if err := restarted.retry(acked.ID); err != errSpoolRecovered {
+		t.Errorf("retry() = %v, want %v", err, errSpoolRecovered)
+	}
+	server := &FtpServer{config: DefaultConfig(), spool: restarted}
+	rec := httptest.NewRecorder()
+	server.adminHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/spool/"+acked.ID, nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("POST /spool/%s code = %d, want %d", acked.ID, rec.Code, http.StatusConflict)
+	}
+	if items := restarted.list(); items[0].State != "failed" {
+		t.Errorf("recovered item state = %s after retry, want failed", items[0].State)
+	}
+
+	if ok, err := restarted.remove(acked.ID); !ok || err != nil {
+		t.Fatalf("remove() = %v, %v", ok, err)
+	}
+	if ids, _ := store.List(); len(ids) != 0 {
+		t.Errorf("files left in spool_dir: %v", ids)
+	}
+}
+
+func Test_spool_next_samePath(t *testing.T) {
+	s := newTestSpool(t, &Config{})
+	var items []*spoolItem
+	for _, p := range []string{"/a.txt", "/a.txt", "/b.txt"} {
+		item := &spoolItem{Path: p}
+		w, err := s.create(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.finish(nil)
+		<-s.events.ch
+		items = append(items, item)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	if got := s.next(stop); got != items[0] {
+		t.Fatalf("next() = %s, want %s", got.ID, items[0].ID)
+	}
+	// second upload of /a.txt waits for first one
+	if got := s.next(stop); got != items[2] {
+		t.Fatalf("next() = %s, want %s", got.ID, items[2].ID)
+	}
+
+	s.deliver = func(*spoolItem) error { return nil }
+	s.attempt(items[0])
+	if got := s.next(stop); got != items[1] {
+		t.Fatalf("next() = %s, want %s", got.ID, items[1].ID)
+	}
+}
+
+// accept STOR by EPSV and keep received files
+func serveSpoolOrigin(t *testing.T) (string, func() ([]string, map[string][]byte)) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var mutex sync.Mutex
+	var commands []string
+	files := map[string][]byte{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				fmt.Fprint(conn, "220 ready\r\n")
+				var data net.Listener
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					line = strings.TrimSpace(line)
+					mutex.Lock()
+					commands = append(commands, line)
+					mutex.Unlock()
+
+					word := strings.SplitN(line, " ", 2)
+					switch word[0] {
+					case "USER":
+						fmt.Fprint(conn, "331 password\r\n")
+					case "PASS":
+						fmt.Fprint(conn, "230 ok\r\n")
+					case "TYPE":
+						fmt.Fprint(conn, "200 ok\r\n")
+					case "EPSV":
+						data, _ = net.Listen("tcp", "127.0.0.1:0")
+						fmt.Fprintf(conn, "229 Entering Extended Passive Mode (|||%d|)\r\n", data.Addr().(*net.TCPAddr).Port)
+					case "STOR":
+						fmt.Fprint(conn, "150 opening\r\n")
+						dc, err := data.Accept()
+						data.Close()
+						if err != nil {
+							return
+						}
+						b, _ := io.ReadAll(dc)
+						dc.Close()
+						mutex.Lock()
+						files[word[1]] = b
+						mutex.Unlock()
+						fmt.Fprint(conn, "226 done\r\n")
+					case "RNFR":
+						fmt.Fprint(conn, "350 ok\r\n")
+					case "RNTO":
+						fmt.Fprint(conn, "250 ok\r\n")
+					case "QUIT":
+						fmt.Fprint(conn, "221 bye\r\n")
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	return l.Addr().String(), func() ([]string, map[string][]byte) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string{}, commands...), files
+	}
+}
+
+func Test_spool_deliverToOrigin(t *testing.T) {
+	addr, received := serveSpoolOrigin(t)
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+
+	s := newTestSpool(t, &Config{})
+	config := &Config{TransferTimeout: 10}
+	item := &spoolItem{
+		Origin:     addr,
+		Path:       "/data/big.bin",
+		proxy:      &proxyServer{config: config, log: &logger{}},
+		login:      []string{"USER alice", "PASS secret"},
+		mode:       "EPSV",
+		tempSuffix: ".part",
+		data:       &dataHandler{config: config, originConn: connector{originalRemoteIP: "127.0.0.1"}},
+	}
+	w, err := s.create(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(content)
+	if err := w.finish(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.deliverToOrigin(item); err != nil {
+		t.Fatalf("spool.deliverToOrigin() error = %v", err)
+	}
+
+	commands, files := received()
+	if !bytes.Equal(files["/data/big.bin.part"], content) {
+		t.Errorf("origin received %d bytes, want %d", len(files["/data/big.bin.part"]), len(content))
+	}
+	got := strings.Join(commands, "\n")
+	for _, want := range []string{"PASS secret", "TYPE I", "STOR /data/big.bin.part", "RNFR /data/big.bin.part", "RNTO /data/big.bin"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("origin commands %q do not have %q", got, want)
+		}
+	}
+}
+
+func Test_dataHandler_copySpooled(t *testing.T) {
+	s := newTestSpool(t, &Config{})
+	item := &spoolItem{Path: "/a.txt"}
+	w, err := s.create(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, clientPeer := net.Pipe()
+	defer clientConn.Close()
+	d := &dataHandler{
+		config:     &Config{},
+		log:        &logger{},
+		mutex:      &sync.Mutex{},
+		clientConn: connector{dataConn: clientConn},
+		spool:      w,
+	}
+	go func() {
+		clientPeer.Write([]byte("hello"))
+		clientPeer.Close()
+	}()
+
+	done := make(chan error)
+	go func() { done <- d.copySpooled(10) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("copySpooled() error = %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("copySpooled() did not end")
+	}
+
+	r, err := s.store.Open(item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if b, _ := io.ReadAll(r); string(b) != "hello" || d.transferredBytes() != 5 {
+		t.Errorf("spooled %q (%d bytes transferred), want hello", b, d.transferredBytes())
+	}
+}
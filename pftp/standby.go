@@ -0,0 +1,177 @@
+package pftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// states and sources of StandbyEvent
+const (
+	standbyStateStandby = "standby"
+	standbyStateActive  = "active"
+
+	standbySourceAdmin       = "admin"
+	standbySourceCoordinator = "coordinator"
+	standbySourceAPI         = "api"
+)
+
+// HACoordinator decide whether server of active/passive pair is active (ex.
+// VRRP state of keepalived). it is polled every standby_check_interval and
+// server is promoted or demoted by result. error keeps current state.
+type HACoordinator interface {
+	Active(ctx context.Context) (bool, error)
+}
+
+// WithHACoordinator set coordinator of standby mode.
+// It is used instead of standby_check_script.
+func WithHACoordinator(h HACoordinator) Option {
+	return func(server *FtpServer) {
+		server.coordinator = h
+	}
+}
+
+// scriptCoordinator run standby_check_script. exit status 0 means active
+// and others mean standby, like track_script of keepalived. 126 and 127 of
+// sh mean script cannot be run, and they are errors keeping current state.
+type scriptCoordinator struct {
+	script string
+}
+
+func (s *scriptCoordinator) Active(ctx context.Context) (bool, error) {
+	err := exec.CommandContext(ctx, "/bin/sh", "-c", s.script).Run()
+	if ctx.Err() != nil {
+		return false, fmt.Errorf("standby check script timed out")
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		switch code := exit.ExitCode(); code {
+		case 126, 127:
+			return false, fmt.Errorf("standby check script cannot be run (exit status %d)", code)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Standby return true while server refuses logins as standby
+func (server *FtpServer) Standby() bool {
+	return server.standby != nil && server.standby.IsSet()
+}
+
+// Promote make standby server active. logins are accepted after it.
+func (server *FtpServer) Promote() {
+	server.setStandby(false, standbySourceAPI)
+}
+
+// Demote make server standby. readiness is false and new logins are refused
+// with 421 after it. sessions already logged in are kept.
+func (server *FtpServer) Demote() {
+	server.setStandby(true, standbySourceAPI)
+}
+
+// change state and report it when it changed
+func (server *FtpServer) setStandby(standby bool, source string) {
+	if !server.standby.SetToIf(!standby, standby) {
+		return
+	}
+
+	state := standbyStateActive
+	if standby {
+		state = standbyStateStandby
+		server.metrics.set("pftp_standby", "1 while server is standby.", 1)
+	} else {
+		server.metrics.set("pftp_standby", "1 while server is standby.", 0)
+	}
+	server.logger.Infof("server is %s by %s", state, source)
+	server.events.emit(&StandbyEvent{
+		Time:           time.Now(),
+		State:          state,
+		Source:         source,
+		ActiveSessions: atomic.LoadInt32(&server.currentConnection),
+	})
+}
+
+// poll coordinator every interval until stop is closed. check is timed out
+// by interval.
+func (server *FtpServer) runCoordinator(interval time.Duration, stop chan struct{}) {
+	if server.coordinator == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		server.checkCoordinator(interval)
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (server *FtpServer) checkCoordinator(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	active, err := server.coordinator.Active(ctx)
+	if err != nil {
+		server.logger.Warnf("cannot check HA coordinator. keep %s: %s", server.standbyState(), err.Error())
+		return
+	}
+	server.setStandby(!active, standbySourceCoordinator)
+}
+
+func (server *FtpServer) standbyState() string {
+	if server.Standby() {
+		return standbyStateStandby
+	}
+
+	return standbyStateActive
+}
+
+// GET /standby
+// return state of server
+func (server *FtpServer) handleStandby(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, map[string]string{"state": server.standbyState()})
+}
+
+// POST /promote
+// make server active. coordinator may demote it again by next check.
+func (server *FtpServer) handlePromote(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	server.setStandby(false, standbySourceAdmin)
+	writeJSON(w, http.StatusOK, map[string]string{"state": server.standbyState()})
+}
+
+// POST /demote
+// make server standby
+func (server *FtpServer) handleDemote(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	server.setStandby(true, standbySourceAdmin)
+	writeJSON(w, http.StatusOK, map[string]string{"state": server.standbyState()})
+}
+
+// refuse login while server is standby
+func (c *clientHandler) refuseStandby() *result {
+	if c.standby == nil || !c.standby.IsSet() {
+		return nil
+	}
+
+	c.setCloseReason(closeReasonStandby)
+	return &result{
+		code: 421,
+		msg:  c.message(msgStandby),
+		err:  fmt.Errorf("server is standby"),
+		log:  c.log,
+	}
+}
@@ -0,0 +1,149 @@
+package pftp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+type testCoordinator struct {
+	active bool
+	err    error
+}
+
+func (c *testCoordinator) Active(context.Context) (bool, error) { return c.active, c.err }
+
+func nextStandbyEvent(t *testing.T, server *FtpServer) *StandbyEvent {
+	for {
+		select {
+		case e := <-server.Events():
+			if se, ok := e.(*StandbyEvent); ok {
+				return se
+			}
+		case <-time.After(time.Second):
+			t.Fatal("standby event is not emitted")
+		}
+	}
+}
+
+func Test_FtpServer_standbyAdmin(t *testing.T) {
+	c := DefaultConfig()
+	c.Standby = true
+	server, err := NewFtpServerWithConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := server.adminHandler()
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		wantCode  int
+		wantBody  string
+		wantEvent string
+	}{
+		{name: "initial", method: "GET", path: "/standby", wantCode: http.StatusOK, wantBody: "{\"state\":\"standby\"}\n"},
+		{name: "not_ready", method: "GET", path: "/readyz", wantCode: http.StatusServiceUnavailable},
+		{name: "promote", method: "POST", path: "/promote", wantCode: http.StatusOK, wantBody: "{\"state\":\"active\"}\n", wantEvent: standbyStateActive},
+		{name: "ready", method: "GET", path: "/readyz", wantCode: http.StatusOK},
+		{name: "promote_again", method: "POST", path: "/promote", wantCode: http.StatusOK, wantBody: "{\"state\":\"active\"}\n"},
+		{name: "demote", method: "POST", path: "/demote", wantCode: http.StatusOK, wantBody: "{\"state\":\"standby\"}\n", wantEvent: standbyStateStandby},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantCode || (len(tt.wantBody) > 0 && rec.Body.String() != tt.wantBody) {
+				t.Errorf("%s %s = %d %s", tt.method, tt.path, rec.Code, rec.Body.String())
+			}
+			if len(tt.wantEvent) > 0 {
+				if e := nextStandbyEvent(t, server); e.State != tt.wantEvent || e.Source != standbySourceAdmin {
+					t.Errorf("standby event = %+v, want %s by admin", e, tt.wantEvent)
+				}
+			}
+		})
+	}
+}
+
+func Test_FtpServer_checkCoordinator(t *testing.T) {
+	coordinator := &testCoordinator{}
+	server, err := NewFtpServerWithConfig(DefaultConfig(), WithHACoordinator(coordinator))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		active      bool
+		err         error
+		wantStandby bool
+	}{
+		{name: "backup", wantStandby: true},
+		{name: "error_keeps_state", active: true, err: errors.New("unreachable"), wantStandby: true},
+		{name: "master", active: true},
+		{name: "error_keeps_active", err: errors.New("unreachable")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coordinator.active, coordinator.err = tt.active, tt.err
+			server.checkCoordinator(time.Second)
+			if server.Standby() != tt.wantStandby {
+				t.Errorf("Standby() = %v, want %v", server.Standby(), tt.wantStandby)
+			}
+		})
+	}
+}
+
+func Test_scriptCoordinator_Active(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     string
+		wantActive bool
+		wantErr    bool
+	}{
+		{name: "exit_0", script: "exit 0", wantActive: true},
+		{name: "exit_1", script: "exit 1"},
+		{name: "not_executable", script: "exit 126", wantErr: true},
+		{name: "not_found", script: "/nonexistent/standby-check", wantErr: true},
+		{name: "timeout", script: "sleep 5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			active, err := (&scriptCoordinator{script: tt.script}).Active(ctx)
+			if active != tt.wantActive || (err != nil) != tt.wantErr {
+				t.Errorf("Active() = %v, %v, want %v, error %v", active, err, tt.wantActive, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_refuseStandby(t *testing.T) {
+	standby := abool.New()
+	c := &clientHandler{
+		config:  &Config{},
+		context: &Context{},
+		log:     &logger{},
+		standby: standby,
+	}
+
+	if r := c.refuseStandby(); r != nil {
+		t.Errorf("clientHandler.refuseStandby() = %+v while active", r)
+	}
+
+	standby.Set()
+	if r := c.refuseStandby(); r == nil || r.code != 421 {
+		t.Errorf("clientHandler.refuseStandby() = %+v, want 421", r)
+	}
+	if c.closeReason != closeReasonStandby {
+		t.Errorf("close reason = %s, want %s", c.closeReason, closeReasonStandby)
+	}
+}
@@ -0,0 +1,183 @@
+package pftp
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// StatsSnapshot is point-in-time state of server for dashboards which can
+// not scrape Prometheus
+type StatsSnapshot struct {
+	Time          time.Time       `json:"time"`
+	StartedAt     time.Time       `json:"started_at"`
+	UptimeSeconds float64         `json:"uptime_seconds"`
+	Draining      bool            `json:"draining"`
+	Standby       bool            `json:"standby"`
+	TotalSessions uint64          `json:"total_sessions"`
+	Sessions      []SessionStats  `json:"sessions"`
+	Origins       []OriginSummary `json:"origins"`
+	Limits        []LimitUsage    `json:"limits"`
+}
+
+// SessionStats is details of connected client session
+type SessionStats struct {
+	SessionInfo
+	LastActive       time.Time `json:"last_active"`
+	IdleSeconds      float64   `json:"idle_seconds"`
+	InTransfer       bool      `json:"in_transfer"`
+	TransferredBytes int64     `json:"transferred_bytes"`
+}
+
+// OriginSummary is connection statistics of origin with its client sessions
+type OriginSummary struct {
+	OriginStats
+	Sessions         int   `json:"sessions"`
+	Transfers        int   `json:"transfers"`
+	TransferredBytes int64 `json:"transferred_bytes"`
+}
+
+// LimitUsage is configured limit and its current usage. origin is set for
+// limits per origin.
+type LimitUsage struct {
+	Name   string `json:"name"`
+	Origin string `json:"origin,omitempty"`
+	Limit  int64  `json:"limit"`
+	Used   int64  `json:"used"`
+}
+
+// return details of sessions ordered by id
+func (r *sessionRegistry) stats(now time.Time) []SessionStats {
+	sessions := []SessionStats{}
+	if r == nil {
+		return sessions
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, c := range r.clients {
+		last := c.lastActive()
+		sessions = append(sessions, SessionStats{
+			SessionInfo:      c.sessionInfo(),
+			LastActive:       last,
+			IdleSeconds:      now.Sub(last).Seconds(),
+			InTransfer:       c.inDataTransfer != nil && c.inDataTransfer.IsSet(),
+			TransferredBytes: atomic.LoadInt64(&c.transferred),
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+
+	return sessions
+}
+
+// return transfer slots used by each limited origin
+func (l *transferLimiter) usage() []LimitUsage {
+	usage := []LimitUsage{}
+	if l == nil {
+		return usage
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for origin, limit := range l.limits {
+		if _, ok := l.slots[origin]; !ok && limit > 0 {
+			usage = append(usage, LimitUsage{Name: "origin_transfers", Origin: origin, Limit: int64(limit)})
+		}
+	}
+	for origin, slots := range l.slots {
+		if slots != nil {
+			usage = append(usage, LimitUsage{Name: "origin_transfers", Origin: origin, Limit: int64(cap(slots)), Used: int64(len(slots))})
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Origin < usage[j].Origin })
+
+	return usage
+}
+
+// return bytes of spooled uploads and spool_max_bytes
+func (s *spool) usage() (int64, int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.used, s.maxBytes
+}
+
+// return snapshot of sessions, origins and limits
+func (server *FtpServer) stats() *StatsSnapshot {
+	now := time.Now()
+	s := &StatsSnapshot{
+		Time:          now,
+		StartedAt:     server.startTime,
+		Draining:      server.Draining(),
+		Standby:       server.Standby(),
+		TotalSessions: atomic.LoadUint64(&server.clientCounter),
+		Sessions:      server.clients.stats(now),
+		Origins:       []OriginSummary{},
+		Limits:        server.limits(),
+	}
+	if !server.startTime.IsZero() {
+		s.UptimeSeconds = now.Sub(server.startTime).Seconds()
+	}
+
+	origins := map[string]*OriginSummary{}
+	for _, o := range server.originStats.list() {
+		origins[o.Addr] = &OriginSummary{OriginStats: o}
+	}
+	for _, c := range s.Sessions {
+		if len(c.Origin) == 0 {
+			continue
+		}
+		o, ok := origins[c.Origin]
+		if !ok {
+			o = &OriginSummary{OriginStats: OriginStats{Addr: c.Origin}}
+			origins[c.Origin] = o
+		}
+		o.Sessions++
+		o.TransferredBytes += c.TransferredBytes
+		if c.InTransfer {
+			o.Transfers++
+		}
+	}
+	for _, o := range origins {
+		s.Origins = append(s.Origins, *o)
+	}
+	sort.Slice(s.Origins, func(i, j int) bool { return s.Origins[i].Addr < s.Origins[j].Addr })
+
+	return s
+}
+
+// return configured limits and their usage
+func (server *FtpServer) limits() []LimitUsage {
+	limits := []LimitUsage{}
+	if server.config == nil {
+		return limits
+	}
+
+	connections := int64(atomic.LoadInt32(&server.currentConnection))
+	if max := server.dynamic.maxConns(server.config.MaxConnections); max > 0 {
+		limits = append(limits, LimitUsage{Name: "max_connections", Limit: int64(max), Used: connections})
+	}
+	if server.sessions != nil {
+		limits = append(limits, LimitUsage{Name: "soft_max_connections", Limit: int64(cap(server.sessions.slots)), Used: int64(len(server.sessions.slots))})
+	}
+	limits = append(limits, server.transfers.usage()...)
+	if server.spool != nil {
+		if used, max := server.spool.usage(); max > 0 {
+			limits = append(limits, LimitUsage{Name: "spool_max_bytes", Limit: max, Used: used})
+		}
+	}
+	if w := server.resources; w.enabled() {
+		if u, err := readResourceUsage(); err == nil {
+			if w.maxFDs > 0 {
+				limits = append(limits, LimitUsage{Name: "max_open_fds", Limit: int64(w.maxFDs), Used: int64(u.fds)})
+			}
+			if w.maxRSS > 0 {
+				limits = append(limits, LimitUsage{Name: "max_rss_bytes", Limit: w.maxRSS, Used: u.rss})
+			}
+		}
+	}
+
+	return limits
+}
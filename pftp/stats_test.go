@@ -0,0 +1,63 @@
+package pftp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+func Test_FtpServer_handleStats(t *testing.T) {
+	config := &Config{MaxConnections: 10, OriginTransferLimits: map[string]int{"origin:21": 2, "idle:21": 1}}
+	server := &FtpServer{
+		config:            config,
+		clients:           newSessionRegistry(),
+		transfers:         newTransferLimiter(config),
+		startTime:         time.Now().Add(-time.Minute),
+		clientCounter:     5,
+		currentConnection: 2,
+	}
+	release, _ := server.transfers.acquire("origin:21", 0)
+	defer release()
+
+	inTransfer := abool.New()
+	inTransfer.Set()
+	for _, c := range []*clientHandler{
+		{id: 1, summary: SessionInfo{ID: 1, Origin: "origin:21"}, inDataTransfer: inTransfer, transferred: 100},
+		{id: 2, summary: SessionInfo{ID: 2, Origin: "origin:21"}, inDataTransfer: abool.New(), transferred: 20},
+		{id: 3, summary: SessionInfo{ID: 3}, inDataTransfer: abool.New()},
+	} {
+		c.lastActivity = time.Now().UnixNano()
+		server.clients.add(c)
+	}
+
+	rec := httptest.NewRecorder()
+	server.adminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/stats.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /stats.json code = %d", rec.Code)
+	}
+
+	var got StatsSnapshot
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.UptimeSeconds < 60 || got.TotalSessions != 5 || len(got.Sessions) != 3 || !got.Sessions[0].InTransfer {
+		t.Errorf("GET /stats.json = %+v", got)
+	}
+	wantOrigins := []OriginSummary{{OriginStats: OriginStats{Addr: "origin:21"}, Sessions: 2, Transfers: 1, TransferredBytes: 120}}
+	if !reflect.DeepEqual(got.Origins, wantOrigins) {
+		t.Errorf("origins = %+v, want %+v", got.Origins, wantOrigins)
+	}
+	wantLimits := []LimitUsage{
+		{Name: "max_connections", Limit: 10, Used: 2},
+		{Name: "origin_transfers", Origin: "idle:21", Limit: 1},
+		{Name: "origin_transfers", Origin: "origin:21", Limit: 2, Used: 1},
+	}
+	if !reflect.DeepEqual(got.Limits, wantLimits) {
+		t.Errorf("limits = %+v, want %+v", got.Limits, wantLimits)
+	}
+}
@@ -0,0 +1,519 @@
+package pftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+var errStripeAborted = errors.New("striped transfer is aborted")
+
+// stripedTransfer download RETR by several origin data connections at once.
+// file is split to stripes by REST offsets. last stripe is read by RETR of
+// session, and others by other origin sessions logged in by same USER and PASS.
+// stripes are sent to client in order, and buffered in temporary files until then.
+type stripedTransfer struct {
+	proxy      *proxyServer
+	clientAddr string
+	login      []string // USER and PASS lines of client
+	dir        string   // working directory of session on origin
+	path       string
+	mode       string // PASV or EPSV
+	starts     []int64
+	bufferDir  string
+	aborted    *abool.AtomicBool
+	mutex      sync.Mutex
+	buffers    []*stripeBuffer // guarded by mutex
+	conns      []net.Conn      // connections of other sessions closed by abort. guarded by mutex
+	endOnce    sync.Once
+	ended      chan struct{}
+	err        error // result of transfer to client
+}
+
+// remember USER and PASS for other sessions of striped transfer and spooled uploads
+func (c *clientHandler) trackLogin() {
+	switch c.command {
+	case "USER":
+		c.loginLines = []string{"USER " + c.param}
+	case "PASS":
+		// password is kept only when it is used
+		if (c.context.TransferStreams > 1 || c.context.SpoolUploads) && len(c.loginLines) == 1 {
+			c.loginLines = append(c.loginLines, "PASS "+c.param)
+		}
+	}
+}
+
+// return striped transfer of RETR when it is enabled and file is large enough.
+// REST of last stripe is sent to origin before RETR.
+func (c *clientHandler) newStripedTransfer(d *dataHandler) *stripedTransfer {
+	streams := c.context.TransferStreams
+	if c.command != "RETR" || streams <= 1 || !c.binaryType || d.originConn.needsListen ||
+		len(c.previousTLSCommands) > 0 || len(c.loginLines) != 2 || c.modeZ.client || c.modeZ.origin {
+		return nil
+	}
+
+	mode := d.originConn.mode
+	if mode == "CLIENT" {
+		mode = d.clientConn.mode
+	}
+	if mode != "PASV" && mode != "EPSV" {
+		return nil
+	}
+	// stripes are buffered in files. file is downloaded by single connection
+	if c.disk.lowSpace(streamBufferDir(c.config)) {
+		c.log.info("download %s by single stream. free disk space of %s is low", c.param, streamBufferDir(c.config))
+		return nil
+	}
+
+	reply, err := c.proxy.internalCommand("SIZE " + c.param + "\r\n")
+	if err != nil || replyCode(reply) != "213" {
+		return nil
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(replyText(reply)), 10, 64)
+	if err != nil || size-c.restOffset < c.config.TransferStreamMinSize {
+		return nil
+	}
+
+	// other sessions start in login directory
+	reply, err = c.proxy.internalCommand("PWD\r\n")
+	if err != nil || replyCode(reply) != "257" {
+		return nil
+	}
+	dir, ok := parsePWDReply(reply)
+	if !ok {
+		return nil
+	}
+
+	t := &stripedTransfer{
+		proxy:      c.proxy,
+		clientAddr: c.srcIP,
+		login:      c.loginLines,
+		dir:        dir,
+		path:       c.param,
+		mode:       mode,
+		bufferDir:  c.config.TransferStreamDir,
+		aborted:    abool.New(),
+		ended:      make(chan struct{}),
+	}
+	chunk := (size - c.restOffset) / int64(streams)
+	for i := 0; i < streams; i++ {
+		t.starts = append(t.starts, c.restOffset+int64(i)*chunk)
+	}
+
+	last := t.starts[len(t.starts)-1]
+	reply, err = c.proxy.internalCommand(fmt.Sprintf("REST %d\r\n", last))
+	if err != nil || replyCode(reply) != "350" {
+		return nil
+	}
+
+	c.log.info("download %s (%d bytes) by %d streams", c.param, size, streams)
+
+	return t
+}
+
+// parse directory of 257 reply. quote in directory is doubled.
+func parsePWDReply(reply string) (string, bool) {
+	start := strings.Index(reply, "\"")
+	if start < 0 {
+		return "", false
+	}
+
+	var b strings.Builder
+	for i := start + 1; i < len(reply); i++ {
+		if reply[i] != '"' {
+			b.WriteByte(reply[i])
+			continue
+		}
+		if i+1 < len(reply) && reply[i+1] == '"' {
+			b.WriteByte('"')
+			i++
+			continue
+		}
+		return b.String(), true
+	}
+
+	return "", false
+}
+
+// return length of stripe i. last stripe is read until EOF.
+func (t *stripedTransfer) length(i int) int64 {
+	if i == len(t.starts)-1 {
+		return -1
+	}
+
+	return t.starts[i+1] - t.starts[i]
+}
+
+// copy stripes to client in order while they are downloaded in parallel
+func (d *dataHandler) copyStriped(timeout int) error {
+	t := d.stripes
+	err := t.run(d, timeout)
+	if err != nil {
+		t.abort()
+	} else {
+		err = sendEOF(d.clientConn.dataConn)
+	}
+
+	t.mutex.Lock()
+	for _, b := range t.buffers {
+		b.close()
+	}
+	t.mutex.Unlock()
+
+	t.end(err)
+	return err
+}
+
+// record result of transfer to client. first result is kept.
+func (t *stripedTransfer) end(err error) {
+	if t == nil {
+		return
+	}
+
+	t.endOnce.Do(func() {
+		t.err = err
+		close(t.ended)
+	})
+}
+
+func (t *stripedTransfer) run(d *dataHandler, timeout int) error {
+	buffers := make([]*stripeBuffer, 0, len(t.starts))
+	for range t.starts {
+		b, err := newStripeBuffer(t.bufferDir)
+		if err != nil {
+			for _, b := range buffers {
+				b.close()
+			}
+			return err
+		}
+		buffers = append(buffers, b)
+	}
+
+	t.mutex.Lock()
+	t.buffers = buffers
+	t.mutex.Unlock()
+	if t.aborted.IsSet() {
+		return errStripeAborted
+	}
+
+	last := len(t.starts) - 1
+	d.mutex.Lock()
+	src := d.originConn.dataConn
+	d.mutex.Unlock()
+	go func() {
+		err := d.fillStripe(buffers[last], src, -1, timeout)
+		if err != nil {
+			t.abort()
+		}
+		buffers[last].finish(err)
+	}()
+	for i := 0; i < last; i++ {
+		go func(i int) {
+			err := t.fetch(d, i, buffers[i], timeout)
+			if err != nil {
+				d.log.err("stripe %d of %s failed: %s", i, t.path, err.Error())
+				t.abort()
+			}
+			buffers[i].finish(err)
+		}(i)
+	}
+
+	for i, b := range buffers {
+		n, err := b.copyTo(d.clientConn.dataConn, func(n int) {
+			atomic.StoreInt64(&d.lastActivity, time.Now().UnixNano())
+			atomic.AddInt64(&d.transferred, int64(n))
+		})
+		if err != nil {
+			return err
+		}
+		if l := t.length(i); l >= 0 && n != l {
+			return fmt.Errorf("stripe %d of %s has %d bytes, want %d", i, t.path, n, l)
+		}
+	}
+
+	return nil
+}
+
+// read stripe from origin data connection. limit < 0 is until EOF.
+func (d *dataHandler) fillStripe(b *stripeBuffer, src net.Conn, limit int64, timeout int) error {
+	buff := make([]byte, bufferSize)
+	var read int64
+	for limit < 0 || read < limit {
+		p := buff
+		if limit >= 0 && limit-read < int64(len(p)) {
+			p = p[:limit-read]
+		}
+
+		src.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+		n, err := src.Read(p)
+		if n > 0 {
+			atomic.StoreInt64(&d.lastActivity, time.Now().UnixNano())
+			read += int64(n)
+			if _, err := b.Write(p[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			if limit >= 0 && read < limit {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// download stripe i by other origin session
+func (t *stripedTransfer) fetch(d *dataHandler, i int, b *stripeBuffer, timeout int) error {
+	o, err := t.proxy.dialOrigin(t.clientAddr, t.proxy.originAddr)
+	if err != nil {
+		return err
+	}
+	defer o.conn.Close()
+	if !t.track(o.conn) {
+		return errStripeAborted
+	}
+
+	session := newOriginSession(o)
+	if err := session.login(t.login); err != nil {
+		return err
+	}
+	if _, err := session.command("TYPE I", "200"); err != nil {
+		return err
+	}
+	if _, err := session.command("CWD "+t.dir, "250"); err != nil {
+		return err
+	}
+	reply, err := session.command(t.mode, "227", "229")
+	if err != nil {
+		return err
+	}
+	addr, err := d.sessionDataAddr(reply)
+	if err != nil {
+		return err
+	}
+	if _, err := session.command(fmt.Sprintf("REST %d", t.starts[i]), "350"); err != nil {
+		return err
+	}
+
+	conn, err := dialEgress(d.originDialer, d.originProxy, addr, time.Duration(connectionTimeout)*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if !t.track(conn) {
+		return errStripeAborted
+	}
+	if _, err := session.command("RETR "+t.path, "150", "125"); err != nil {
+		return err
+	}
+
+	// origin replies 426 to data connection closed before end of file
+	if err := d.fillStripe(b, conn, t.length(i), timeout); err != nil {
+		return err
+	}
+	conn.Close()
+	session.command("QUIT", "221")
+
+	return nil
+}
+
+// return origin data address of PASV or EPSV reply of other origin session
+func (d *dataHandler) sessionDataAddr(reply string) (string, error) {
+	p := &dataHandler{
+		config:       d.config,
+		passiveIPMap: d.passiveIPMap,
+		originAddr:   d.originAddr,
+		originConn: connector{
+			originalRemoteIP: d.originConn.originalRemoteIP,
+			remoteIP:         d.originConn.originalRemoteIP,
+		},
+	}
+
+	var err error
+	if strings.HasPrefix(reply, "227") {
+		err = p.parsePASVresponse(reply)
+	} else {
+		err = p.parseEPSVresponse(reply)
+	}
+
+	return net.JoinHostPort(p.originConn.remoteIP, p.originConn.remotePort), err
+}
+
+// keep connection to close it by abort. return false when already aborted.
+func (t *stripedTransfer) track(conn net.Conn) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.aborted.IsSet() {
+		return false
+	}
+	t.conns = append(t.conns, conn)
+
+	return true
+}
+
+// stop all stripes
+func (t *stripedTransfer) abort() {
+	if t == nil || !t.aborted.SetToIf(false, true) {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, conn := range t.conns {
+		conn.Close()
+	}
+	for _, b := range t.buffers {
+		b.finish(errStripeAborted)
+	}
+}
+
+// send reply of RETR after stripes are sent to client. reply is 426 when
+// origin completed last stripe but other stripes failed.
+func (t *stripedTransfer) forwardReply(reply string, inDataTransfer *abool.AtomicBool) {
+	<-t.ended
+	if t.err != nil && !isErrorReply(reply) {
+		reply = "426 Connection closed; transfer aborted.\r\n"
+	}
+	if strings.HasPrefix(reply, "226 ") {
+		inDataTransfer.UnSet()
+	}
+
+	if err := t.proxy.sendToClient(strings.TrimRight(reply, "\r\n")); err != nil {
+		t.proxy.log.err("cannot send response to client")
+	}
+}
+
+// return true when reply of RETR is held until striped transfer ends
+func (s *proxyServer) holdStripedReply(command string, reply string) bool {
+	if command != "RETR" {
+		return false
+	}
+
+	s.dataMutex.Lock()
+	d := s.dataConnector
+	s.dataMutex.Unlock()
+	if d == nil || d.stripes == nil {
+		return false
+	}
+
+	go d.stripes.forwardReply(reply, s.inDataTransfer)
+	return true
+}
+
+// stop striped transfer because client aborted it
+func (s *proxyServer) abortStripes() {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+
+	if s.dataConnector != nil {
+		s.dataConnector.stripes.abort()
+	}
+}
+
+// stripeBuffer is stripe in unlinked temporary file. it is read while it is written.
+type stripeBuffer struct {
+	file    *os.File
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	written int64
+	done    bool
+	err     error
+}
+
+func newStripeBuffer(dir string) (*stripeBuffer, error) {
+	f, err := os.CreateTemp(dir, "pftp-stripe-")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+
+	b := &stripeBuffer{file: f}
+	b.cond = sync.NewCond(&b.mutex)
+
+	return b, nil
+}
+
+func (b *stripeBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	offset := b.written
+	b.mutex.Unlock()
+
+	n, err := b.file.WriteAt(p, offset)
+
+	b.mutex.Lock()
+	b.written += int64(n)
+	b.cond.Broadcast()
+	b.mutex.Unlock()
+
+	return n, err
+}
+
+// mark end of stripe. first result is kept.
+func (b *stripeBuffer) finish(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.done {
+		return
+	}
+	b.done = true
+	b.err = err
+	b.cond.Broadcast()
+}
+
+// copy stripe to dst until it is finished. progress is called with bytes written.
+func (b *stripeBuffer) copyTo(dst io.Writer, progress func(n int)) (int64, error) {
+	buff := make([]byte, bufferSize)
+	var pos int64
+	for {
+		b.mutex.Lock()
+		for pos == b.written && !b.done {
+			b.cond.Wait()
+		}
+		written, done, err := b.written, b.done, b.err
+		b.mutex.Unlock()
+
+		if pos == written {
+			if done {
+				return pos, err
+			}
+			continue
+		}
+		if err != nil {
+			return pos, err
+		}
+
+		p := buff
+		if written-pos < int64(len(p)) {
+			p = p[:written-pos]
+		}
+		n, rerr := b.file.ReadAt(p, pos)
+		if n > 0 {
+			if _, err := dst.Write(p[:n]); err != nil {
+				return pos, err
+			}
+			pos += int64(n)
+			progress(n)
+		}
+		if rerr != nil && rerr != io.EOF {
+			return pos, rerr
+		}
+	}
+}
+
+func (b *stripeBuffer) close() {
+	b.file.Close()
+}
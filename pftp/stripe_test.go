@@ -0,0 +1,213 @@
+package pftp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+func Test_parsePWDReply(t *testing.T) {
+	tests := []struct {
+		reply  string
+		want   string
+		wantOk bool
+	}{
+		{reply: "257 \"/home/user\" is current directory.\r\n", want: "/home/user", wantOk: true},
+		{reply: "257 \"/a \"\"b\"\"\" created.\r\n", want: "/a \"b\"", wantOk: true},
+		{reply: "257 /home/user\r\n"},
+		{reply: "257 \"/home\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reply, func(t *testing.T) {
+			got, ok := parsePWDReply(tt.reply)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("parsePWDReply() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+// serve RETR of content by EPSV for other sessions of striped transfer
+func serveStripeOrigin(t *testing.T, content []byte) (string, func() []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var mutex sync.Mutex
+	var commands []string
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				fmt.Fprint(conn, "220 ready\r\n")
+				var data net.Listener
+				var rest int64
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					line = strings.TrimSpace(line)
+					mutex.Lock()
+					commands = append(commands, line)
+					mutex.Unlock()
+
+					word := strings.Fields(line)
+					switch word[0] {
+					case "USER":
+						fmt.Fprint(conn, "331 password\r\n")
+					case "PASS":
+						fmt.Fprint(conn, "230 ok\r\n")
+					case "TYPE":
+						fmt.Fprint(conn, "200 ok\r\n")
+					case "CWD":
+						fmt.Fprint(conn, "250 ok\r\n")
+					case "EPSV":
+						data, _ = net.Listen("tcp", "127.0.0.1:0")
+						fmt.Fprintf(conn, "229 Entering Extended Passive Mode (|||%d|)\r\n", data.Addr().(*net.TCPAddr).Port)
+					case "REST":
+						rest, _ = strconv.ParseInt(word[1], 10, 64)
+						fmt.Fprint(conn, "350 ok\r\n")
+					case "RETR":
+						fmt.Fprint(conn, "150 opening\r\n")
+						dc, err := data.Accept()
+						data.Close()
+						if err != nil {
+							return
+						}
+						dc.Write(content[rest:])
+						dc.Close()
+						fmt.Fprint(conn, "226 done\r\n")
+					case "QUIT":
+						fmt.Fprint(conn, "221 bye\r\n")
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	return l.Addr().String(), func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string{}, commands...)
+	}
+}
+
+func Test_dataHandler_copyStriped(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdefghijklmnopqrstuvwxyz"), 10000)
+	addr, commands := serveStripeOrigin(t, content)
+
+	clientConn, clientPeer := net.Pipe()
+	defer clientConn.Close()
+	defer clientPeer.Close()
+	originConn, originPeer := net.Pipe()
+	defer originConn.Close()
+	defer originPeer.Close()
+
+	s := &proxyServer{config: &Config{}, log: &logger{}, originAddr: addr}
+	chunk := int64(len(content) / 3)
+	stripes := &stripedTransfer{
+		proxy:   s,
+		login:   []string{"USER alice", "PASS secret"},
+		dir:     "/data",
+		path:    "big.bin",
+		mode:    "EPSV",
+		starts:  []int64{0, chunk, 2 * chunk},
+		aborted: abool.New(),
+		ended:   make(chan struct{}),
+	}
+	d := &dataHandler{
+		config:     &Config{},
+		log:        &logger{},
+		mutex:      &sync.Mutex{},
+		clientConn: connector{dataConn: clientConn},
+		originConn: connector{dataConn: originConn, originalRemoteIP: "127.0.0.1"},
+		stripes:    stripes,
+	}
+
+	// session RETR sends last stripe
+	go func() {
+		originPeer.Write(content[2*chunk:])
+		originPeer.Close()
+	}()
+	received := make(chan []byte)
+	go func() {
+		b := make([]byte, len(content))
+		io.ReadFull(clientPeer, b)
+		received <- b
+	}()
+
+	done := make(chan error)
+	go func() { done <- d.copyStriped(10) }()
+
+	select {
+	case got := <-received:
+		if !bytes.Equal(got, content) {
+			t.Errorf("client received wrong content")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not receive content")
+	}
+	if err := <-done; err != nil {
+		t.Errorf("copyStriped() error = %v", err)
+	}
+	if n := d.transferredBytes(); n != int64(len(content)) {
+		t.Errorf("transferred = %d, want %d", n, len(content))
+	}
+
+	// each other session restarts from own stripe in session directory
+	got := strings.Join(commands(), "\n")
+	for _, want := range []string{"PASS secret", "CWD /data", "REST 0", fmt.Sprintf("REST %d", chunk), "RETR big.bin"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("origin commands %q do not have %q", got, want)
+		}
+	}
+}
+
+func Test_stripedTransfer_forwardReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		reply string
+		want  string
+	}{
+		{name: "completed", reply: "226 Transfer complete.\r\n", want: "226 Transfer complete.\r\n"},
+		{name: "stripe_failed", err: errStripeAborted, reply: "226 Transfer complete.\r\n", want: "426 Connection closed; transfer aborted.\r\n"},
+		{name: "origin_error", err: errStripeAborted, reply: "550 No such file.\r\n", want: "550 No such file.\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConn, clientPeer := net.Pipe()
+			defer clientConn.Close()
+			defer clientPeer.Close()
+
+			s := &proxyServer{log: &logger{}, clientWriter: bufio.NewWriter(clientConn), mutex: &sync.Mutex{}}
+			st := &stripedTransfer{proxy: s, aborted: abool.New(), ended: make(chan struct{})}
+			go st.forwardReply(tt.reply, abool.New())
+			st.end(tt.err)
+
+			clientPeer.SetDeadline(time.Now().Add(3 * time.Second))
+			got, err := bufio.NewReader(clientPeer).ReadString('\n')
+			if err != nil || got != tt.want {
+				t.Errorf("reply to client = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
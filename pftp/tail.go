@@ -0,0 +1,218 @@
+package pftp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// directions of control connection lines in session tail
+const (
+	tailFromClient = "from_client"
+	tailToClient   = "to_client"
+	tailToOrigin   = "to_origin"
+	tailFromOrigin = "from_origin"
+)
+
+// lines buffered for each tail subscriber. lines are dropped while
+// subscriber is slower than session.
+const tailBufferSize = 256
+
+// TailLine is control connection line of session streamed by admin API
+type TailLine struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Line      string    `json:"line"`
+}
+
+// sessionTail fan out control connection dialogue of session to admin API
+// subscribers and capture, and keep last session_history lines for error context.
+// nothing is kept while session has no subscriber and history is disabled.
+type sessionTail struct {
+	mutex       sync.Mutex
+	subscribers map[chan TailLine]struct{}
+	closed      bool
+	redactor    *redactor
+	history     []TailLine // ring of last lines
+	historySize int
+	historyNext int             // index of oldest line when ring is full
+	capture     *sessionCapture // started by admin API. nil when not captured
+}
+
+func newSessionTail(r *redactor, history int) *sessionTail {
+	return &sessionTail{subscribers: map[chan TailLine]struct{}{}, redactor: r, historySize: history}
+}
+
+// publish command line sent by client or proxy with redaction rules applied
+func (t *sessionTail) command(direction string, line string) {
+	if t == nil || !t.active() {
+		return
+	}
+
+	line = t.redactor.line(line)
+	if words := strings.SplitN(line, " ", 2); len(words) == 2 && strings.EqualFold(words[0], "USER") {
+		line = words[0] + " " + t.redactor.user(words[1])
+	}
+	t.publish(direction, line)
+}
+
+// publish reply lines
+func (t *sessionTail) reply(direction string, reply string) {
+	if t == nil || !t.active() {
+		return
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(reply, "\r\n"), "\n") {
+		t.publish(direction, strings.TrimSuffix(line, "\r"))
+	}
+}
+
+// return true when lines are streamed, captured or kept in history
+func (t *sessionTail) active() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return len(t.subscribers) > 0 || t.historySize > 0 || t.capture != nil
+}
+
+func (t *sessionTail) publish(direction string, line string) {
+	l := TailLine{Time: time.Now(), Direction: direction, Line: line}
+
+	t.mutex.Lock()
+	t.remember(l)
+	c := t.capture
+	for ch := range t.subscribers {
+		select {
+		case ch <- l:
+		default:
+		}
+	}
+	t.mutex.Unlock()
+
+	// capture file is written out of lock
+	c.write(CaptureRecord{Time: l.Time, Kind: captureLine, Direction: direction, Line: line})
+}
+
+// return channel of lines closed when session ends, and function to stop it.
+// returned channel is nil when session already ended.
+func (t *sessionTail) subscribe() (chan TailLine, func()) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return nil, func() {}
+	}
+
+	ch := make(chan TailLine, tailBufferSize)
+	t.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		if _, ok := t.subscribers[ch]; ok {
+			delete(t.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// end streams of subscribers when session ends
+func (t *sessionTail) close() {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	t.closed = true
+	c := t.capture
+	t.capture = nil
+	for ch := range t.subscribers {
+		delete(t.subscribers, ch)
+		close(ch)
+	}
+	t.mutex.Unlock()
+
+	// wait for capture file out of lock
+	if c != nil {
+		c.close()
+	}
+}
+
+// return tail of session. ok is false when session is not found.
+func (r *sessionRegistry) tail(id uint64) (*sessionTail, bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c, ok := r.clients[id]
+	if !ok {
+		return nil, false
+	}
+
+	return c.tail, true
+}
+
+// GET /sessions/:id/tail
+// stream control connection lines of session as server-sent events until
+// session ends. it needs operator role, so it is disabled while admin API
+// is not authenticated.
+func (server *FtpServer) handleTailSession(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !server.adminAuthEnabled() {
+		writeJSON(w, http.StatusForbidden, adminError{Error: "session tail needs admin authentication"})
+		return
+	}
+
+	id, err := strconv.ParseUint(ps.ByName("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: "session id must be number"})
+		return
+	}
+	t, ok := server.clients.tail(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "unknown session"})
+		return
+	}
+	lines, stop := t.subscribe()
+	defer stop()
+	if lines == nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "unknown session"})
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case l, ok := <-lines:
+			if !ok {
+				fmt.Fprint(w, "event: end\ndata: {}\n\n")
+				return
+			}
+			b, _ := json.Marshal(l)
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		case <-server.stopBackground:
+			// let admin server shut down
+			return
+		}
+	}
+}
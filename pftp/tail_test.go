@@ -0,0 +1,98 @@
+package pftp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_sessionTail_command(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		line   string
+		want   string
+	}{
+		{name: "pass", config: &Config{}, line: "PASS secret\r\n", want: "PASS ********"},
+		{name: "redact_commands", config: &Config{RedactCommands: []string{"SITE AUTH"}}, line: "SITE AUTH token\r\n", want: "SITE AUTH ********"},
+		{name: "hash_usernames", config: &Config{HashUsernames: true}, line: "USER alice\r\n", want: "USER " + newRedactor(&Config{HashUsernames: true}).user("alice")},
+		{name: "plain", config: &Config{}, line: "RETR a.txt\r\n", want: "RETR a.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tail := newSessionTail(newRedactor(tt.config), 0)
+			lines, stop := tail.subscribe()
+			defer stop()
+
+			tail.command(tailFromClient, tt.line)
+			if got := <-lines; got.Line != tt.want || got.Direction != tailFromClient {
+				t.Errorf("sessionTail.command() = %+v, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_FtpServer_handleTailSession(t *testing.T) {
+	tests := []struct {
+		name     string
+		tokens   map[string]string
+		auth     string
+		path     string
+		wantCode int
+	}{
+		{name: "disabled", path: "/sessions/1/tail", wantCode: http.StatusForbidden},
+		{name: "observer", tokens: map[string]string{"t0ken": "observer"}, auth: "Bearer t0ken", path: "/sessions/1/tail", wantCode: http.StatusForbidden},
+		{name: "unknown_session", tokens: map[string]string{"t0ken": "operator"}, auth: "Bearer t0ken", path: "/sessions/2/tail", wantCode: http.StatusNotFound},
+		{name: "stream", tokens: map[string]string{"t0ken": "operator"}, auth: "Bearer t0ken", path: "/sessions/1/tail", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{id: 1, tail: newSessionTail(nil, 0)}
+			server := &FtpServer{config: &Config{AdminTokens: tt.tokens}, clients: newSessionRegistry()}
+			server.clients.add(c)
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if len(tt.auth) > 0 {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			done := make(chan struct{})
+			go func() {
+				server.adminHandler().ServeHTTP(rec, req)
+				close(done)
+			}()
+
+			if tt.wantCode == http.StatusOK {
+				for !c.tail.active() {
+					time.Sleep(10 * time.Millisecond)
+				}
+				c.tail.command(tailFromClient, "PASS secret\r\n")
+				c.tail.reply(tailToClient, "230-Welcome\r\n230 Logged in\r\n")
+				server.clients.remove(c)
+			}
+			select {
+			case <-done:
+			case <-time.After(3 * time.Second):
+				t.Fatal("handleTailSession() did not end")
+			}
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			body := rec.Body.String()
+			for _, want := range []string{`"line":"PASS ********"`, `"line":"230-Welcome"`, `"line":"230 Logged in"`, "event: end"} {
+				if !strings.Contains(body, want) {
+					t.Errorf("stream %q does not have %q", body, want)
+				}
+			}
+			if strings.Contains(body, "secret") {
+				t.Errorf("stream %q has password", body)
+			}
+		})
+	}
+}
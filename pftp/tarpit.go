@@ -0,0 +1,153 @@
+package pftp
+
+import (
+	"sync"
+	"time"
+)
+
+// max shift of tarpit delay for failures over threshold (delay * 1024)
+const maxTarpitEscalation = 10
+
+type tarpitRecord struct {
+	failures int
+	last     time.Time
+}
+
+// tarpit slow sessions of client IPs which failed logins, by delay before
+// each command until login. delay doubles by each failure over threshold up
+// to max delay, so credential stuffing gets slow without clean failure like
+// ban. failures are forgotten after login_failure_window from last one.
+// failures are not counted by user, because user is chosen by client and
+// other clients could slow sessions of victim by it.
+type tarpit struct {
+	mutex     sync.Mutex
+	records   map[string]*tarpitRecord // by client IP
+	threshold int
+	delay     time.Duration
+	maxDelay  time.Duration
+	window    time.Duration
+	metrics   *metrics
+	now       func() time.Time
+}
+
+func newTarpit(c *Config, m *metrics) *tarpit {
+	if c.TarpitThreshold <= 0 {
+		return nil
+	}
+
+	return &tarpit{
+		records:   map[string]*tarpitRecord{},
+		threshold: c.TarpitThreshold,
+		delay:     time.Duration(c.TarpitDelay) * time.Millisecond,
+		maxDelay:  time.Duration(c.TarpitMaxDelay) * time.Millisecond,
+		window:    time.Duration(c.LoginFailureWindow) * time.Second,
+		metrics:   m,
+		now:       time.Now,
+	}
+}
+
+// record result of login. success does not clear failures of IP, which
+// may try other users.
+func (t *tarpit) loginResult(ip string, success bool) {
+	if t == nil || success {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	r, ok := t.records[ip]
+	if !ok || now.Sub(r.last) > t.window {
+		r = &tarpitRecord{}
+		t.records[ip] = r
+	}
+	r.failures++
+	r.last = now
+}
+
+// return delay before next command of session from ip. 0 when it is not
+// suspicious.
+func (t *tarpit) delayOf(ip string) time.Duration {
+	if t == nil {
+		return 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	r, ok := t.records[ip]
+	if !ok {
+		return 0
+	}
+	if t.now().Sub(r.last) > t.window {
+		delete(t.records, ip)
+		return 0
+	}
+	if r.failures < t.threshold {
+		return 0
+	}
+
+	shift := r.failures - t.threshold
+	if shift > maxTarpitEscalation {
+		shift = maxTarpitEscalation
+	}
+	d := t.delay << uint(shift)
+	if d > t.maxDelay {
+		d = t.maxDelay
+	}
+
+	return d
+}
+
+// forget expired failures every window until stop is closed
+func (t *tarpit) run(stop chan struct{}) {
+	if t == nil {
+		return
+	}
+
+	ticker := time.NewTicker(t.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.prune()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (t *tarpit) prune() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	for key, r := range t.records {
+		if now.Sub(r.last) > t.window {
+			delete(t.records, key)
+		}
+	}
+}
+
+// wait before handling command of suspicious session until login. wait
+// ends when session is closed.
+func (c *clientHandler) tarpitDelay() {
+	if c.proxy != nil && c.proxy.isLoggedIn() {
+		return
+	}
+	d := c.tarpit.delayOf(clientIP(c.srcIP))
+	if d <= 0 {
+		return
+	}
+
+	c.log.debug("command is delayed %s by tarpit", d)
+	c.metrics.inc("pftp_tarpit_delays_total", "Commands delayed by tarpit.")
+	c.metrics.add("pftp_tarpit_delay_seconds_total", "Seconds of delays inserted by tarpit.", d.Seconds())
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-c.closing:
+	}
+}
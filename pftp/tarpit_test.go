@@ -0,0 +1,132 @@
+package pftp
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_tarpit_delayOf(t *testing.T) {
+	type login struct {
+		ip      string
+		user    string
+		success bool
+	}
+	tests := []struct {
+		name    string
+		logins  []login
+		elapsed time.Duration // from last login to check
+		ip      string
+		want    time.Duration
+	}{
+		{
+			name:   "under_threshold",
+			logins: []login{{ip: "192.0.2.1", user: "a"}},
+			ip:     "192.0.2.1",
+			want:   0,
+		},
+		{
+			name:   "threshold_by_ip",
+			logins: []login{{ip: "192.0.2.1", user: "a"}, {ip: "192.0.2.1", user: "b"}},
+			ip:     "192.0.2.1",
+			want:   100 * time.Millisecond,
+		},
+		{
+			// user is chosen by client and does not slow other IPs
+			name:   "same_user_other_ip",
+			logins: []login{{ip: "192.0.2.1", user: "a"}, {ip: "192.0.2.2", user: "a"}},
+			ip:     "192.0.2.3",
+			want:   0,
+		},
+		{
+			name: "growing",
+			logins: []login{
+				{ip: "192.0.2.1", user: "a"}, {ip: "192.0.2.1", user: "b"},
+				{ip: "192.0.2.1", user: "c"}, {ip: "192.0.2.1", user: "d"},
+			},
+			ip:   "192.0.2.1",
+			want: 400 * time.Millisecond,
+		},
+		{
+			name: "capped",
+			logins: []login{
+				{ip: "192.0.2.1"}, {ip: "192.0.2.1"}, {ip: "192.0.2.1"}, {ip: "192.0.2.1"},
+				{ip: "192.0.2.1"}, {ip: "192.0.2.1"}, {ip: "192.0.2.1"},
+			},
+			ip:   "192.0.2.1",
+			want: time.Second,
+		},
+		{
+			name:   "success_keeps_ip",
+			logins: []login{{ip: "192.0.2.1", user: "a"}, {ip: "192.0.2.1", user: "b"}, {ip: "192.0.2.1", user: "c", success: true}},
+			ip:     "192.0.2.1",
+			want:   100 * time.Millisecond,
+		},
+		{
+			name:    "expired",
+			logins:  []login{{ip: "192.0.2.1", user: "a"}, {ip: "192.0.2.1", user: "b"}},
+			elapsed: 301 * time.Second,
+			ip:      "192.0.2.1",
+			want:    0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+			tp := newTarpit(&Config{TarpitThreshold: 2, TarpitDelay: 100, TarpitMaxDelay: 1000, LoginFailureWindow: 300}, nil)
+			tp.now = func() time.Time { return now }
+			for _, l := range tt.logins {
+				tp.loginResult(l.ip, l.success)
+			}
+			now = now.Add(tt.elapsed)
+
+			if got := tp.delayOf(tt.ip); got != tt.want {
+				t.Errorf("delayOf() = %s, want %s", got, tt.want)
+			}
+
+			tp.prune()
+			if tt.elapsed > 0 && len(tp.records) > 0 {
+				t.Errorf("prune() kept %d expired records", len(tp.records))
+			}
+		})
+	}
+}
+
+func Test_clientHandler_tarpitDelay(t *testing.T) {
+	tp := newTarpit(&Config{TarpitThreshold: 1, TarpitDelay: 10000, TarpitMaxDelay: 10000, LoginFailureWindow: 300}, nil)
+	tp.loginResult("192.0.2.1", false)
+
+	tests := []struct {
+		name     string
+		loggedIn bool
+		close    bool
+	}{
+		{name: "logged_in", loggedIn: true},
+		{name: "closed", close: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{
+				srcIP:   "192.0.2.1:1234",
+				tarpit:  tp,
+				proxy:   &proxyServer{isLoggedin: tt.loggedIn},
+				closing: make(chan struct{}),
+				log:     &logger{},
+				metrics: newMetrics(&Config{}),
+			}
+			if tt.close {
+				go c.closeWithNotice(closeReasonPolicyKill)
+			}
+
+			done := make(chan struct{})
+			go func() {
+				c.tarpitDelay()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(3 * time.Second):
+				t.Fatal("tarpitDelay() is not ended")
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package pftp
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// default buckets of transfer throughput (bytes/sec). 16KiB/s to 2GiB/s by factor 2.
+var defaultThroughputBuckets = func() []float64 {
+	buckets := []float64{}
+	for le := float64(16 * 1024); le <= 2*1024*1024*1024; le *= 2 {
+		buckets = append(buckets, le)
+	}
+	return buckets
+}()
+
+// ThroughputSummary is throughput of file transfers of one direction, origin
+// and user in summary interval. percentiles are bytes/sec estimated by
+// buckets of throughput_buckets.
+type ThroughputSummary struct {
+	Direction string  `json:"direction"`
+	Origin    string  `json:"origin"`
+	User      string  `json:"user"`
+	Transfers int     `json:"transfers"`
+	Bytes     int64   `json:"bytes"`
+	P50       float64 `json:"p50"`
+	P95       float64 `json:"p95"`
+	P99       float64 `json:"p99"`
+	Max       float64 `json:"max"`
+}
+
+type throughputKey struct {
+	direction string
+	origin    string
+	user      string
+}
+
+type throughputWindow struct {
+	histogram
+	bytes int64
+	max   float64
+}
+
+// throughputStats record throughput of file transfers to histogram metric
+// and summarize it each interval by ThroughputSummaryEvent.
+type throughputStats struct {
+	mutex    sync.Mutex
+	buckets  []float64
+	minBytes int64
+	windows  map[throughputKey]*throughputWindow
+	metrics  *metrics
+	events   *eventBus
+}
+
+func newThroughputStats(c *Config, m *metrics, events *eventBus) *throughputStats {
+	buckets := c.ThroughputBuckets
+	if len(buckets) == 0 {
+		buckets = defaultThroughputBuckets
+	}
+
+	return &throughputStats{
+		buckets:  buckets,
+		minBytes: c.ThroughputMinBytes,
+		windows:  map[throughputKey]*throughputWindow{},
+		metrics:  m,
+		events:   events,
+	}
+}
+
+// record transfer of n bytes in d. transfers smaller than throughput_min_bytes
+// are not recorded because their throughput is mostly latency.
+func (s *throughputStats) observe(direction string, origin string, user string, n int64, d time.Duration) {
+	if s == nil || n < s.minBytes || n == 0 || d <= 0 {
+		return
+	}
+
+	bps := float64(n) / d.Seconds()
+	s.metrics.observe("pftp_transfer_throughput_bytes_per_second", "Throughput of file transfers.", s.buckets, bps,
+		"direction", direction, "origin", origin, "user", user)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := throughputKey{direction: direction, origin: origin, user: user}
+	w, ok := s.windows[key]
+	if !ok {
+		w = &throughputWindow{}
+		s.windows[key] = w
+	}
+	w.observe(s.buckets, bps)
+	w.bytes += n
+	if bps > w.max {
+		w.max = bps
+	}
+}
+
+// emit summary every interval until stop is closed
+func (s *throughputStats) run(interval time.Duration, stop chan struct{}) {
+	if s == nil || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.summarize(interval)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// emit summary of transfers since last summary and start new window.
+// nothing is emitted when there was no transfer.
+func (s *throughputStats) summarize(interval time.Duration) {
+	s.mutex.Lock()
+	windows := s.windows
+	s.windows = map[throughputKey]*throughputWindow{}
+	s.mutex.Unlock()
+	if len(windows) == 0 {
+		return
+	}
+
+	routes := make([]ThroughputSummary, 0, len(windows))
+	for key, w := range windows {
+		routes = append(routes, ThroughputSummary{
+			Direction: key.direction,
+			Origin:    key.origin,
+			User:      key.user,
+			Transfers: int(w.count),
+			Bytes:     w.bytes,
+			P50:       w.quantile(s.buckets, 0.5),
+			P95:       w.quantile(s.buckets, 0.95),
+			P99:       w.quantile(s.buckets, 0.99),
+			Max:       w.max,
+		})
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		a, b := routes[i], routes[j]
+		if a.Origin != b.Origin {
+			return a.Origin < b.Origin
+		}
+		if a.User != b.User {
+			return a.User < b.User
+		}
+		return a.Direction < b.Direction
+	})
+
+	s.events.emit(&ThroughputSummaryEvent{
+		Time:     time.Now(),
+		Interval: interval,
+		Routes:   routes,
+	})
+}
+
+// estimate quantile q by linear interpolation in bucket. observations over
+// last bucket are estimated by max.
+func (w *throughputWindow) quantile(buckets []float64, q float64) float64 {
+	rank := q * float64(w.count)
+	var cumulative float64
+	for i, le := range buckets {
+		n := float64(w.counts[i])
+		if n > 0 && cumulative+n >= rank {
+			lower := 0.0
+			if i > 0 {
+				lower = buckets[i-1]
+			}
+			upper := le
+			if w.max < upper {
+				upper = w.max
+			}
+			return lower + (upper-lower)*(rank-cumulative)/n
+		}
+		cumulative += n
+	}
+
+	return w.max
+}
@@ -0,0 +1,83 @@
+package pftp
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_throughputStats_summarize(t *testing.T) {
+	s := newThroughputStats(&Config{ThroughputBuckets: []float64{100, 200, 400}, ThroughputMinBytes: 10}, newMetrics(&Config{}), newEventBus())
+
+	// 100 uploads of alice at 150 bytes/sec and one at 300 bytes/sec
+	for i := 0; i < 100; i++ {
+		s.observe(uploadStream, "a:21", "alice", 150, time.Second)
+	}
+	s.observe(uploadStream, "a:21", "alice", 300, time.Second)
+	s.observe(downloadStream, "a:21", "bob", 1000, time.Second)
+	// too small and listing like transfers
+	s.observe(downloadStream, "a:21", "bob", 5, time.Millisecond)
+	s.observe(downloadStream, "a:21", "bob", 100, 0)
+
+	s.summarize(time.Minute)
+	e := (<-s.events.ch).(*ThroughputSummaryEvent)
+	if len(e.Routes) != 2 {
+		t.Fatalf("routes = %+v, want alice and bob", e.Routes)
+	}
+	alice, bob := e.Routes[0], e.Routes[1]
+	if alice.User != "alice" || alice.Transfers != 101 || alice.Bytes != 15300 || alice.Max != 300 {
+		t.Errorf("alice = %+v", alice)
+	}
+	// p50 and p95 are in (100, 200] bucket and p99 is not over it
+	if alice.P50 <= 100 || alice.P50 > 200 || alice.P95 <= alice.P50 || alice.P99 > 200 {
+		t.Errorf("alice percentiles = %g, %g, %g", alice.P50, alice.P95, alice.P99)
+	}
+	// over last bucket is max
+	if bob.User != "bob" || bob.Transfers != 1 || bob.P95 != 1000 {
+		t.Errorf("bob = %+v", bob)
+	}
+
+	// window is reset
+	s.summarize(time.Minute)
+	if len(s.events.ch) != 0 {
+		t.Error("summary is emitted without transfers")
+	}
+
+	var b strings.Builder
+	s.metrics.write(&b)
+	for _, want := range []string{
+		`pftp_transfer_throughput_bytes_per_second_bucket{direction="upload",origin="a:21",le="200"} 100`,
+		`pftp_transfer_throughput_bytes_per_second_bucket{direction="upload",origin="a:21",le="400"} 101`,
+		`pftp_transfer_throughput_bytes_per_second_bucket{direction="download",origin="a:21",le="+Inf"} 1`,
+		`pftp_transfer_throughput_bytes_per_second_count{direction="upload",origin="a:21"} 101`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics do not have %s:\n%s", want, b.String())
+		}
+	}
+}
+
+func Test_throughputWindow_quantile(t *testing.T) {
+	buckets := []float64{10, 20, 40}
+	w := &throughputWindow{}
+	for _, v := range []float64{5, 15, 15, 30} {
+		w.observe(buckets, v)
+		w.max = math.Max(w.max, v)
+	}
+
+	tests := []struct {
+		q    float64
+		want float64
+	}{
+		{q: 0.25, want: 10},
+		{q: 0.5, want: 15},
+		{q: 0.75, want: 20},
+		{q: 1, want: 30},
+	}
+	for _, tt := range tests {
+		if got := w.quantile(buckets, tt.q); got != tt.want {
+			t.Errorf("quantile(%g) = %g, want %g", tt.q, got, tt.want)
+		}
+	}
+}
@@ -53,22 +53,25 @@ type tlsData struct {
 	rootCA *x509.CertPool
 	cert   *tls.Certificate
 	config *tls.Config
+	pins   []TLSPin // of origin. guarded by mutex
 	mutex  sync.Mutex
 }
 
 // tls configset for client and origin
 type tlsDataSet struct {
-	forClient   *tlsData
-	forOrigin   *tlsData
-	version     uint16
-	cipherSuite uint16
-	serverName  string
+	forClient *tlsData
+	forOrigin *tlsData
+	// per-connection config for client built by TLS config hook
+	forClientConn *tls.Config
+	version       uint16
+	cipherSuite   uint16
+	serverName    string
 }
 
 // build origin side tls config
 // it is working TLS client
 func buildTLSConfigForOrigin() *tlsData {
-	return &tlsData{
+	t := &tlsData{
 		config: &tls.Config{
 			InsecureSkipVerify:     true,
 			ClientSessionCache:     tls.NewLRUClientSessionCache(10),
@@ -77,11 +80,14 @@ func buildTLSConfigForOrigin() *tlsData {
 		rootCA: nil,
 		cert:   nil,
 	}
+	t.config.VerifyConnection = t.verifyOriginPins
+
+	return t
 }
 
 // build client side tls config (pftp works like server)
 // it is working TLS server
-func buildTLSConfigForClient(TLS *tlsPair) (*tlsData, error) {
+func buildTLSConfigForClient(TLS *TLSConfig) (*tlsData, error) {
 	var t *tlsData
 
 	caCertFile := TLS.CACert
@@ -150,6 +156,16 @@ func (t *tlsData) verifyTLSConnection(cs tls.ConnectionState) error {
 	return nil
 }
 
+// get tls config for client connection.
+// per-connection config is used when it has set.
+func (t *tlsDataSet) clientTLSConfig() *tls.Config {
+	if t.forClientConn != nil {
+		return t.forClientConn
+	}
+
+	return t.forClient.getTLSConfig()
+}
+
 // get tls config
 func (t *tlsData) getTLSConfig() *tls.Config {
 	t.mutex.Lock()
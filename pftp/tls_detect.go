@@ -0,0 +1,72 @@
+package pftp
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"time"
+)
+
+// first byte of TLS record of ClientHello
+const tlsHandshakeRecord = 0x16
+
+// peekedConn read bytes peeked by reader before rest of connection
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (p *peekedConn) Read(b []byte) (int, error) {
+	return p.reader.Read(b)
+}
+
+// NetConn return underlying connection
+func (p *peekedConn) NetConn() net.Conn {
+	return p.Conn
+}
+
+// start TLS on connect when first byte of client is TLS ClientHello and
+// tls_auto_detect is set, so one port serves implicit FTPS and plain FTP.
+// plain clients wait for welcome message, and client sending nothing in
+// tls_detect_timeout (msec) is plain. origin gets AUTH TLS at login as if
+// client sent it. handshake must finish in connection timeout, and idle
+// deadline set at accept is restored after detection.
+func (c *clientHandler) detectImplicitTLS() error {
+	if !c.config.TLSAutoDetect || c.tlsDatas == nil || c.tlsDatas.forClient.getTLSConfig() == nil {
+		return nil
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(time.Duration(c.config.TLSDetectTimeout) * time.Millisecond))
+	b, err := c.reader.Peek(1)
+	defer c.restoreIdleDeadline()
+	var ne net.Error
+	if err != nil && !(errors.As(err, &ne) && ne.Timeout()) {
+		return err
+	}
+
+	if len(b) == 0 || b[0] != tlsHandshakeRecord {
+		c.metrics.inc("pftp_control_protocol_total", "Control connections by protocol detected on connect.", "protocol", "plain")
+		return nil
+	}
+
+	c.buildConnTLSConfig()
+	c.conn.SetDeadline(time.Now().Add(time.Duration(connectionTimeout) * time.Second))
+	if err := c.startControlTLS(&peekedConn{Conn: c.conn, reader: c.reader}); err != nil {
+		return err
+	}
+	c.metrics.inc("pftp_control_protocol_total", "Control connections by protocol detected on connect.", "protocol", "tls")
+	c.log.debug("implicit TLS is detected")
+	c.context.TLSImplicit = true
+	c.previousTLSCommands = append(c.previousTLSCommands, "AUTH TLS\r\n")
+
+	return nil
+}
+
+// set deadline of client connection back to idle timeout
+func (c *clientHandler) restoreIdleDeadline() {
+	if t := c.idleTimeout(); t > 0 {
+		c.conn.SetDeadline(time.Now().Add(time.Duration(t) * time.Second))
+		return
+	}
+	c.conn.SetDeadline(time.Time{})
+}
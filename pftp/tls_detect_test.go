@@ -0,0 +1,118 @@
+package pftp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+func Test_detectImplicitTLS(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("../tls/server.crt", "../tls/server.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		client  func(conn net.Conn)
+		wantTLS bool
+	}{
+		{
+			name: "tls",
+			client: func(conn net.Conn) {
+				tc := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+				tc.Write([]byte("USER anonymous\r\n"))
+			},
+			wantTLS: true,
+		},
+		{
+			name: "plain_command",
+			client: func(conn net.Conn) {
+				conn.Write([]byte("USER anonymous\r\n"))
+			},
+		},
+		{
+			// plain client waits for welcome message
+			name:   "plain_silent",
+			client: func(conn net.Conn) {},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConn, clientConn := net.Pipe()
+			defer serverConn.Close()
+			defer clientConn.Close()
+			go tt.client(clientConn)
+
+			c := &clientHandler{
+				config:       &Config{TLSAutoDetect: true, TLSDetectTimeout: 100},
+				conn:         serverConn,
+				reader:       bufio.NewReader(serverConn),
+				writer:       bufio.NewWriter(serverConn),
+				controlInTLS: abool.New(),
+				context:      newContext(&Config{}),
+				log:          &logger{},
+				mutex:        &sync.Mutex{},
+				tlsDatas: &tlsDataSet{
+					forClient: &tlsData{config: &tls.Config{Certificates: []tls.Certificate{cert}}},
+					forOrigin: &tlsData{config: &tls.Config{}},
+				},
+			}
+			if err := c.detectImplicitTLS(); err != nil {
+				t.Fatalf("detectImplicitTLS() error = %v", err)
+			}
+			if c.context.TLSImplicit != tt.wantTLS || c.controlInTLS.IsSet() != tt.wantTLS || (len(c.previousTLSCommands) == 1) != tt.wantTLS {
+				t.Fatalf("implicit TLS = %v, in TLS = %v, commands = %v, want %v", c.context.TLSImplicit, c.controlInTLS.IsSet(), c.previousTLSCommands, tt.wantTLS)
+			}
+			if tt.name == "plain_silent" {
+				return
+			}
+
+			// first command is read after detection
+			line, err := c.reader.ReadString('\n')
+			if err != nil || line != "USER anonymous\r\n" {
+				t.Errorf("line = %q, %v", line, err)
+			}
+		})
+	}
+}
+
+func Test_detectImplicitTLS_idleDeadline(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	c := &clientHandler{
+		config:  &Config{TLSAutoDetect: true, TLSDetectTimeout: 10, IdleTimeout: 1},
+		conn:    serverConn,
+		reader:  bufio.NewReader(serverConn),
+		context: newContext(&Config{}),
+		log:     &logger{},
+		tlsDatas: &tlsDataSet{
+			forClient: &tlsData{config: &tls.Config{}},
+		},
+	}
+	if err := c.detectImplicitTLS(); err != nil {
+		t.Fatalf("detectImplicitTLS() error = %v", err)
+	}
+
+	// silent client is closed by idle timeout after detection
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.reader.ReadString('\n')
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("read error = %v, want timeout", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("idle deadline is cleared by detection")
+	}
+}
@@ -0,0 +1,99 @@
+package pftp
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errOriginPinMismatch = errors.New("certificate of origin does not match TLS pins")
+
+// TLSPin is base64 SHA-256 hash of SubjectPublicKeyInfo of certificate in
+// chain of origin (like pin-sha256 of HPKP). pin is used from NotBefore until
+// NotAfter, and zero time is unbounded, so rotation is done without
+// downtime by overlapping windows of old and new pins.
+type TLSPin struct {
+	SHA256    string    `toml:"sha256"`
+	NotBefore time.Time `toml:"not_before"`
+	NotAfter  time.Time `toml:"not_after"`
+}
+
+// return pin of certificate
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func (p TLSPin) activeAt(now time.Time) bool {
+	return (p.NotBefore.IsZero() || !now.Before(p.NotBefore)) && (p.NotAfter.IsZero() || now.Before(p.NotAfter))
+}
+
+// return nil when one of certificates matches one of pins active at now.
+// certificates are matched from leaf along the chain only while each one is
+// signed by the next, so certificate appended by man in the middle (leaf of
+// origin or pinned CA are public) does not match. possession of key of leaf
+// is proved by handshake.
+func verifyPins(pins []TLSPin, certs []*x509.Certificate, now time.Time) error {
+	for i, cert := range certs {
+		if i > 0 && certs[i-1].CheckSignatureFrom(cert) != nil {
+			break
+		}
+		pin := spkiPin(cert)
+		for _, p := range pins {
+			if p.SHA256 == pin && p.activeAt(now) {
+				return nil
+			}
+		}
+	}
+
+	return errOriginPinMismatch
+}
+
+func validateTLSPins(pins map[string][]TLSPin) error {
+	for origin, list := range pins {
+		for _, p := range list {
+			if b, err := base64.StdEncoding.DecodeString(p.SHA256); err != nil || len(b) != sha256.Size {
+				return fmt.Errorf("configuration error: TLS pin %q of origin %s is not base64 SHA-256", p.SHA256, origin)
+			}
+			if !p.NotBefore.IsZero() && !p.NotAfter.IsZero() && !p.NotBefore.Before(p.NotAfter) {
+				return fmt.Errorf("configuration error: TLS pin %s of origin %s has not_before after not_after", p.SHA256, origin)
+			}
+		}
+	}
+
+	return nil
+}
+
+// return pins of origin. pins of session set by middleware have priority
+// over origin_tls_pins.
+func originTLSPins(c *Config, originAddr string, session []TLSPin) []TLSPin {
+	if len(session) > 0 {
+		return session
+	}
+
+	return c.OriginTLSPins[originAddr]
+}
+
+// set pins checked by TLS connections to origin. nil disables pinning.
+func (t *tlsData) setPins(pins []TLSPin) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.pins = pins
+}
+
+// verify certificates of origin by pins. it is used for control and data
+// connections to origin, including resumed sessions.
+func (t *tlsData) verifyOriginPins(cs tls.ConnectionState) error {
+	t.mutex.Lock()
+	pins := t.pins
+	t.mutex.Unlock()
+	if len(pins) == 0 {
+		return nil
+	}
+
+	return verifyPins(pins, cs.PeerCertificates, time.Now())
+}
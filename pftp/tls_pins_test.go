@@ -0,0 +1,194 @@
+package pftp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadTestCertificate(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	cert, err := tls.LoadX509KeyPair("../tls/server.crt", "../tls/server.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, leaf
+}
+
+func Test_verifyPins(t *testing.T) {
+	_, leaf := loadTestCertificate(t)
+	pin := spkiPin(leaf)
+	other := strings.Repeat("A", 43) + "="
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		pins    []TLSPin
+		wantErr bool
+	}{
+		{name: "match", pins: []TLSPin{{SHA256: pin}}},
+		{name: "mismatch", pins: []TLSPin{{SHA256: other}}, wantErr: true},
+		{name: "one_of_pins", pins: []TLSPin{{SHA256: other}, {SHA256: pin}}},
+		{name: "in_window", pins: []TLSPin{{SHA256: pin, NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}}},
+		{name: "expired", pins: []TLSPin{{SHA256: pin, NotAfter: now}}, wantErr: true},
+		{name: "not_yet", pins: []TLSPin{{SHA256: pin, NotBefore: now.Add(time.Hour)}}, wantErr: true},
+		{
+			name: "rotation_to_new_pin",
+			pins: []TLSPin{{SHA256: other, NotAfter: now.Add(time.Hour)}, {SHA256: pin, NotBefore: now.Add(-time.Hour)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyPins(tt.pins, []*x509.Certificate{leaf}, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyPins() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// create certificate signed by parent. nil parent creates self-signed CA.
+func createTestCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func Test_verifyPins_chain(t *testing.T) {
+	ca, caKey := createTestCertificate(t, "ca", nil, nil)
+	leaf, _ := createTestCertificate(t, "origin", ca, caKey)
+	attacker, _ := createTestCertificate(t, "attacker", nil, nil)
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		pin     *x509.Certificate
+		certs   []*x509.Certificate
+		wantErr bool
+	}{
+		{name: "pinned_ca", pin: ca, certs: []*x509.Certificate{leaf, ca}},
+		{name: "forged_leaf_with_pinned_ca", pin: ca, certs: []*x509.Certificate{attacker, ca}, wantErr: true},
+		{name: "forged_leaf_with_pinned_leaf", pin: leaf, certs: []*x509.Certificate{attacker, leaf, ca}, wantErr: true},
+		{name: "forged_chain_after_valid_link", pin: ca, certs: []*x509.Certificate{attacker, attacker, ca}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyPins([]TLSPin{{SHA256: spkiPin(tt.pin)}}, tt.certs, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyPins() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateTLSPins(t *testing.T) {
+	pin := strings.Repeat("A", 43) + "="
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		pins    []TLSPin
+		wantErr bool
+	}{
+		{name: "valid", pins: []TLSPin{{SHA256: pin, NotBefore: now, NotAfter: now.Add(time.Hour)}}},
+		{name: "not_base64", pins: []TLSPin{{SHA256: "not base64"}}, wantErr: true},
+		{name: "short_hash", pins: []TLSPin{{SHA256: "AAAA"}}, wantErr: true},
+		{name: "empty_window", pins: []TLSPin{{SHA256: pin, NotBefore: now, NotAfter: now}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTLSPins(map[string][]TLSPin{"10.0.0.5:21": tt.pins})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateTLSPins() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_tlsData_verifyOriginPins(t *testing.T) {
+	cert, leaf := loadTestCertificate(t)
+
+	tests := []struct {
+		name    string
+		pins    []TLSPin
+		wantErr bool
+	}{
+		{name: "no_pins"},
+		{name: "match", pins: []TLSPin{{SHA256: spkiPin(leaf)}}},
+		{name: "mismatch", pins: []TLSPin{{SHA256: strings.Repeat("A", 43) + "="}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			go func() {
+				defer server.Close()
+				tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+			}()
+
+			origin := buildTLSConfigForOrigin()
+			origin.setPins(tt.pins)
+			err := tls.Client(client, origin.getTLSConfig()).Handshake()
+			if tt.wantErr {
+				if !errors.Is(err, errOriginPinMismatch) {
+					t.Errorf("Handshake() error = %v, want %v", err, errOriginPinMismatch)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Handshake() error = %v", err)
+			}
+		})
+	}
+}
+
+func Test_originTLSPins(t *testing.T) {
+	c := &Config{OriginTLSPins: map[string][]TLSPin{"10.0.0.5:21": {{SHA256: "config"}}}}
+	session := []TLSPin{{SHA256: "session"}}
+
+	if got := originTLSPins(c, "10.0.0.5:21", nil); len(got) != 1 || got[0].SHA256 != "config" {
+		t.Errorf("originTLSPins() = %v, want pins of config", got)
+	}
+	if got := originTLSPins(c, "10.0.0.5:21", session); len(got) != 1 || got[0].SHA256 != "session" {
+		t.Errorf("originTLSPins() = %v, want pins of session", got)
+	}
+	if got := originTLSPins(c, "10.0.0.6:21", nil); len(got) != 0 {
+		t.Errorf("originTLSPins() = %v, want none", got)
+	}
+}
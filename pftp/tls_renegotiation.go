@@ -0,0 +1,100 @@
+package pftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+const tlsRecordHeaderLen = 5
+
+// errClientRenegotiation is returned by read of client TLS connection when
+// client started TLS renegotiation
+var errClientRenegotiation = errors.New("client attempted TLS renegotiation")
+
+// renegotiationConn is under TLS connection of client and stops handshake
+// record sent after handshake, which is renegotiation of TLS 1.2 and older.
+// Go TLS server never renegotiates and fails such connection with unexpected
+// message, so it is reported clearly instead. TLS 1.3 has no renegotiation.
+type renegotiationConn struct {
+	net.Conn
+	established bool // set after handshake
+	header      [tlsRecordHeaderLen]byte
+	headerRead  int
+	remaining   int // bytes of record body not read yet
+	err         error
+	detected    func()
+}
+
+func newRenegotiationConn(conn net.Conn, detected func()) *renegotiationConn {
+	return &renegotiationConn{Conn: conn, detected: detected}
+}
+
+// read bytes of records before renegotiation, and then errClientRenegotiation
+func (r *renegotiationConn) Read(b []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err := r.Conn.Read(b)
+	for i := 0; i < n; {
+		if r.remaining > 0 {
+			k := n - i
+			if k > r.remaining {
+				k = r.remaining
+			}
+			r.remaining -= k
+			i += k
+			continue
+		}
+
+		if r.headerRead == 0 && r.established && b[i] == tlsHandshakeRecord {
+			r.err = errClientRenegotiation
+			r.detected()
+			if i == 0 {
+				return 0, r.err
+			}
+			return i, nil
+		}
+		r.header[r.headerRead] = b[i]
+		r.headerRead++
+		i++
+		if r.headerRead == tlsRecordHeaderLen {
+			r.remaining = int(binary.BigEndian.Uint16(r.header[3:]))
+			r.headerRead = 0
+		}
+	}
+
+	return n, err
+}
+
+// NetConn return underlying connection
+func (r *renegotiationConn) NetConn() net.Conn {
+	return r.Conn
+}
+
+// report renegotiation of client on control or data channel
+func reportRenegotiation(events *eventBus, m *metrics, log *logger, sessionID uint64, clientAddr string, channel string) func() {
+	return func() {
+		log.err("client attempted TLS renegotiation on %s connection. it is not supported and connection is closed", channel)
+		m.inc("pftp_tls_renegotiation_attempts_total", "TLS renegotiations attempted by clients.", "channel", channel)
+		events.emit(&TLSRenegotiationEvent{
+			Time:       time.Now(),
+			SessionID:  sessionID,
+			ClientAddr: clientAddr,
+			Channel:    channel,
+		})
+	}
+}
+
+// return true when client is in early_client_cert_clients. they are legacy
+// clients which renegotiate or expect post-handshake auth to send client
+// certificate, and are asked for it in first handshake instead.
+func (c *clientHandler) earlyClientCert() bool {
+	if c.config == nil || c.config.TLS == nil || len(c.config.TLS.EarlyClientCertClients) == 0 {
+		return false
+	}
+
+	return inAllowlist(net.ParseIP(clientIP(c.srcIP)), c.config.TLS.EarlyClientCertClients)
+}
@@ -0,0 +1,100 @@
+package pftp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// readerConn read bytes of reader as connection
+type readerConn struct {
+	net.Conn
+	reader *bytes.Reader
+}
+
+func (r *readerConn) Read(b []byte) (int, error) {
+	return r.reader.Read(b)
+}
+
+func Test_renegotiationConn_Read(t *testing.T) {
+	handshake := []byte{tlsHandshakeRecord, 3, 3, 0, 2, 1, 2}
+	appData := []byte{0x17, 3, 3, 0, 3, tlsHandshakeRecord, tlsHandshakeRecord, tlsHandshakeRecord}
+
+	tests := []struct {
+		name        string
+		input       []byte
+		established bool
+		want        int
+		wantErr     error
+		detected    bool
+	}{
+		{
+			name:  "handshake",
+			input: handshake,
+			want:  len(handshake),
+		},
+		{
+			// handshake type bytes in record body are not record header
+			name:        "application_data",
+			input:       appData,
+			established: true,
+			want:        len(appData),
+		},
+		{
+			name:        "renegotiation_after_data",
+			input:       append(append([]byte{}, appData...), handshake...),
+			established: true,
+			want:        len(appData),
+			detected:    true,
+		},
+		{
+			name:        "renegotiation",
+			input:       handshake,
+			established: true,
+			wantErr:     errClientRenegotiation,
+			detected:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detected := 0
+			r := newRenegotiationConn(&readerConn{reader: bytes.NewReader(tt.input)}, func() { detected++ })
+			r.established = tt.established
+
+			n, err := r.Read(make([]byte, 64))
+			if n != tt.want || err != tt.wantErr {
+				t.Fatalf("Read() = %d, %v, want %d, %v", n, err, tt.want, tt.wantErr)
+			}
+			if (detected == 1) != tt.detected {
+				t.Errorf("detected = %d, want %v", detected, tt.detected)
+			}
+			if _, err := r.Read(make([]byte, 64)); tt.detected && err != errClientRenegotiation {
+				t.Errorf("Read() after renegotiation = %v", err)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_buildConnTLSConfig_earlyClientCertClients(t *testing.T) {
+	base := &tls.Config{}
+	config := &Config{TLS: &TLSConfig{EarlyClientCertClients: []string{"192.0.2.0/24"}}}
+
+	for addr, want := range map[string]tls.ClientAuthType{
+		"192.0.2.10:1021":   tls.RequestClientCert,
+		"198.51.100.1:1021": tls.NoClientCert,
+	} {
+		c := &clientHandler{
+			config:   config,
+			srcIP:    addr,
+			tlsDatas: &tlsDataSet{forClient: &tlsData{config: base}},
+		}
+		c.buildConnTLSConfig()
+		if got := c.tlsDatas.clientTLSConfig().ClientAuth; got != want {
+			t.Errorf("ClientAuth of %s = %v, want %v", addr, got, want)
+		}
+	}
+	if base.ClientAuth != tls.NoClientCert {
+		t.Errorf("base config is changed")
+	}
+}
@@ -0,0 +1,92 @@
+package pftp
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// classes of client TLS handshake failures
+const (
+	tlsErrorProtocolVersion = "protocol_version"
+	tlsErrorNoSharedCipher  = "no_shared_cipher"
+	tlsErrorBadCertificate  = "bad_certificate"
+	tlsErrorPlainText       = "plain_text"
+	tlsErrorTimeout         = "timeout"
+	tlsErrorClosed          = "closed"
+	tlsErrorOther           = "other"
+)
+
+// classify handshake error by error of crypto/tls and alert from client
+func classifyTLSError(err error) string {
+	if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+		return tlsErrorTimeout
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return tlsErrorClosed
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "first record does not look like a TLS handshake"),
+		strings.Contains(msg, "unsupported SSLv2 handshake"):
+		return tlsErrorPlainText
+	case strings.Contains(msg, "unsupported versions"),
+		strings.Contains(msg, "protocol version"):
+		return tlsErrorProtocolVersion
+	case strings.Contains(msg, "no cipher suite supported"),
+		strings.Contains(msg, "handshake failure"),
+		strings.Contains(msg, "insufficient security"):
+		return tlsErrorNoSharedCipher
+	case strings.Contains(msg, "certificate"):
+		return tlsErrorBadCertificate
+	case strings.Contains(msg, "connection reset"):
+		return tlsErrorClosed
+	}
+
+	return tlsErrorOther
+}
+
+// return copy of config which store ClientHello to hello before handshake
+func recordClientHello(conf *tls.Config, hello **tls.ClientHelloInfo) *tls.Config {
+	recorder := conf.Clone()
+	getConfig := conf.GetConfigForClient
+	recorder.GetConfigForClient = func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+		*hello = info
+		if getConfig != nil {
+			return getConfig(info)
+		}
+		return nil, nil
+	}
+
+	return recorder
+}
+
+// report failed client handshake as event and metrics
+func reportTLSError(events *eventBus, m *metrics, sessionID uint64, clientAddr string, channel string, err error, hello *tls.ClientHelloInfo) {
+	e := &TLSHandshakeErrorEvent{
+		Time:       time.Now(),
+		SessionID:  sessionID,
+		ClientAddr: clientAddr,
+		Channel:    channel,
+		Reason:     classifyTLSError(err),
+		Error:      err.Error(),
+	}
+
+	if hello != nil {
+		e.ServerName = hello.ServerName
+		e.ALPN = hello.SupportedProtos
+		for _, v := range hello.SupportedVersions {
+			e.Versions = append(e.Versions, getTLSProtocolName(v))
+		}
+		for _, c := range hello.CipherSuites {
+			e.CipherSuites = append(e.CipherSuites, tls.CipherSuiteName(c))
+		}
+	}
+
+	m.inc("pftp_tls_handshake_errors_total", "Client TLS handshake failures by reason.", "channel", channel, "reason", e.Reason)
+	events.emit(e)
+}
@@ -0,0 +1,119 @@
+package pftp
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_classifyTLSError(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("../tls/server.crt", "../tls/server.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		server     *tls.Config
+		client     func(conn net.Conn)
+		want       string
+		wantHello  bool
+		wantServer string
+	}{
+		{
+			name:   "protocol_version",
+			server: &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13},
+			client: func(conn net.Conn) {
+				tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: "ftp.example", MaxVersion: tls.VersionTLS12}).Handshake()
+			},
+			want:       tlsErrorProtocolVersion,
+			wantHello:  true,
+			wantServer: "ftp.example",
+		},
+		{
+			name: "no_shared_cipher",
+			server: &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				MaxVersion:   tls.VersionTLS12,
+				CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+			},
+			client: func(conn net.Conn) {
+				tls.Client(conn, &tls.Config{
+					InsecureSkipVerify: true,
+					MaxVersion:         tls.VersionTLS12,
+					CipherSuites:       []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+				}).Handshake()
+			},
+			want:      tlsErrorNoSharedCipher,
+			wantHello: true,
+		},
+		{
+			name:   "plain_text",
+			server: &tls.Config{Certificates: []tls.Certificate{cert}},
+			client: func(conn net.Conn) {
+				conn.Write([]byte("USER anonymous\r\n"))
+				conn.Close()
+			},
+			want: tlsErrorPlainText,
+		},
+		{
+			name:   "closed",
+			server: &tls.Config{Certificates: []tls.Certificate{cert}},
+			client: func(conn net.Conn) {
+				conn.Close()
+			},
+			want: tlsErrorClosed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConn, clientConn := net.Pipe()
+			defer serverConn.Close()
+			go func() {
+				defer clientConn.Close()
+				tt.client(clientConn)
+			}()
+
+			var hello *tls.ClientHelloInfo
+			err := tls.Server(serverConn, recordClientHello(tt.server, &hello)).Handshake()
+			if err == nil {
+				t.Fatal("handshake succeeded")
+			}
+
+			if got := classifyTLSError(err); got != tt.want {
+				t.Errorf("classifyTLSError(%v) = %s, want %s", err, got, tt.want)
+			}
+			if (hello != nil) != tt.wantHello {
+				t.Fatalf("ClientHello recorded = %v, want %v", hello != nil, tt.wantHello)
+			}
+			if hello != nil && hello.ServerName != tt.wantServer {
+				t.Errorf("ClientHello server name = %s, want %s", hello.ServerName, tt.wantServer)
+			}
+		})
+	}
+}
+
+func Test_reportTLSError(t *testing.T) {
+	events := newEventBus()
+	m := newMetrics(&Config{})
+	hello := &tls.ClientHelloInfo{
+		ServerName:        "ftp.example",
+		SupportedVersions: []uint16{tls.VersionTLS11, tls.VersionTLS10},
+		CipherSuites:      []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA},
+	}
+
+	reportTLSError(events, m, 1, "127.0.0.1:10000", "control", errors.New("remote error: tls: protocol version not supported"), hello)
+
+	e, ok := (<-events.ch).(*TLSHandshakeErrorEvent)
+	if !ok || e.Reason != tlsErrorProtocolVersion || strings.Join(e.Versions, ",") != "TLSv1.1,TLSv1" || e.CipherSuites[0] != "TLS_RSA_WITH_AES_128_CBC_SHA" {
+		t.Errorf("TLSHandshakeErrorEvent = %+v", e)
+	}
+
+	var b strings.Builder
+	m.write(&b)
+	if !strings.Contains(b.String(), `pftp_tls_handshake_errors_total{channel="control",reason="protocol_version"} 1`) {
+		t.Errorf("metrics = %s", b.String())
+	}
+}
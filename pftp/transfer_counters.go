@@ -0,0 +1,110 @@
+package pftp
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// TransferCounters is live bytes of data transfers of session relayed by data
+// channel proxy. it is set to Context, so middleware and SITE handlers can
+// answer usage of session and enforce policies during it. bytes of transfer
+// in progress are counted as they are copied. methods are safe to call from
+// any goroutine.
+type TransferCounters struct {
+	mutex     sync.Mutex
+	bytesIn   int64 // of finished uploads
+	bytesOut  int64 // of finished downloads and listings
+	completed int64
+	current   *dataHandler
+	direction string
+}
+
+func newTransferCounters() *TransferCounters {
+	return &TransferCounters{}
+}
+
+// count transfer of d in direction from now
+func (t *TransferCounters) start(d *dataHandler, direction string) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.current, t.direction = d, direction
+}
+
+// add finished transfer of d. file transfers succeeded are counted as completed.
+func (t *TransferCounters) end(d *dataHandler, direction string, ok bool) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if direction == uploadStream {
+		t.bytesIn += d.transferredBytes()
+	} else {
+		t.bytesOut += d.transferredBytes()
+	}
+	if ok && len(d.path) > 0 {
+		t.completed++
+	}
+	if t.current == d {
+		t.current, t.direction = nil, ""
+	}
+}
+
+// return bytes of transfer in progress in direction
+func (t *TransferCounters) currentOf(direction string) int64 {
+	if t.current == nil || t.direction != direction {
+		return 0
+	}
+
+	return atomic.LoadInt64(&t.current.transferred)
+}
+
+// BytesIn return bytes uploaded by client in session
+func (t *TransferCounters) BytesIn() int64 {
+	if t == nil {
+		return 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.bytesIn + t.currentOf(uploadStream)
+}
+
+// BytesOut return bytes downloaded by client in session, including listings
+func (t *TransferCounters) BytesOut() int64 {
+	if t == nil {
+		return 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.bytesOut + t.currentOf(downloadStream)
+}
+
+// CurrentBytes return bytes of transfer in progress. 0 when no data is transferred.
+func (t *TransferCounters) CurrentBytes() int64 {
+	if t == nil {
+		return 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.currentOf(t.direction)
+}
+
+// Completed return count of file transfers (RETR, STOR, STOU and APPE) which
+// succeeded. failed and aborted transfers are counted only in bytes.
+func (t *TransferCounters) Completed() int64 {
+	if t == nil {
+		return 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.completed
+}
@@ -0,0 +1,46 @@
+package pftp
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func Test_TransferCounters(t *testing.T) {
+	c := newTransferCounters()
+
+	retr := &dataHandler{path: "a.txt"}
+	c.start(retr, downloadStream)
+	atomic.AddInt64(&retr.transferred, 100)
+	if c.CurrentBytes() != 100 || c.BytesOut() != 100 || c.BytesIn() != 0 || c.Completed() != 0 {
+		t.Errorf("during RETR: current=%d out=%d in=%d completed=%d", c.CurrentBytes(), c.BytesOut(), c.BytesIn(), c.Completed())
+	}
+	c.end(retr, downloadStream, true)
+
+	list := &dataHandler{}
+	c.start(list, downloadStream)
+	atomic.AddInt64(&list.transferred, 10)
+	c.end(list, downloadStream, true)
+
+	// failed transfer is counted in bytes but not completed
+	aborted := &dataHandler{path: "c.txt"}
+	c.start(aborted, downloadStream)
+	atomic.AddInt64(&aborted.transferred, 5)
+	c.end(aborted, downloadStream, false)
+
+	stor := &dataHandler{path: "b.txt"}
+	c.start(stor, uploadStream)
+	atomic.AddInt64(&stor.transferred, 50)
+	if c.CurrentBytes() != 50 || c.BytesIn() != 50 || c.BytesOut() != 115 {
+		t.Errorf("during STOR: current=%d in=%d out=%d", c.CurrentBytes(), c.BytesIn(), c.BytesOut())
+	}
+	c.end(stor, uploadStream, true)
+
+	if c.CurrentBytes() != 0 || c.BytesIn() != 50 || c.BytesOut() != 115 || c.Completed() != 2 {
+		t.Errorf("after transfers: current=%d in=%d out=%d completed=%d", c.CurrentBytes(), c.BytesIn(), c.BytesOut(), c.Completed())
+	}
+
+	var none *TransferCounters
+	if none.BytesIn() != 0 || none.CurrentBytes() != 0 {
+		t.Error("nil TransferCounters must count nothing")
+	}
+}
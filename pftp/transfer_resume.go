@@ -0,0 +1,309 @@
+package pftp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+// time to wait for end of origin data connection after error reply to RETR.
+// origin may reply before proxy notices reset of data connection.
+const resumeVerdictWait = 5 * time.Second
+
+// sourceError is read error of source connection in copyPackets
+type sourceError struct {
+	error
+}
+
+// transferResume re-issue REST and RETR to origin when origin data
+// connection of RETR is reset while client is still connected.
+type transferResume struct {
+	proxy    *proxyServer
+	path     string
+	offset   int64 // REST offset sent by client before RETR
+	retries  int   // left retries
+	attempts int
+	aborted  *abool.AtomicBool // client sent ABOR
+	failed   *abool.AtomicBool // resume failed and 426 is sent to client
+	verdict  chan bool         // result of each origin data connection. true while resuming
+}
+
+// return resume of RETR when it is enabled and origin data connection is dialed by proxy
+func (c *clientHandler) newTransferResume(d *dataHandler) *transferResume {
+	if c.command != "RETR" || c.config.TransferResumeRetries <= 0 || !c.binaryType || d.originConn.needsListen {
+		return nil
+	}
+
+	return &transferResume{
+		proxy:   c.proxy,
+		path:    c.param,
+		offset:  c.restOffset,
+		retries: c.config.TransferResumeRetries,
+		aborted: abool.New(),
+		failed:  abool.New(),
+		verdict: make(chan bool, 1),
+	}
+}
+
+// copy origin data to client. when origin connection of RETR failed,
+// connect to origin again and continue from bytes sent to client.
+func (d *dataHandler) copyFromOrigin(timeout int) error {
+	if d.listing != nil {
+		return d.copyListing(timeout)
+	}
+	if d.stripes != nil {
+		return d.copyStriped(timeout)
+	}
+
+	for {
+		d.mutex.Lock()
+		src := d.originConn.dataConn
+		d.mutex.Unlock()
+
+		err := d.copyPackets(d.clientConn.dataConn, src, timeout, nil)
+		if d.resume == nil {
+			return err
+		}
+
+		resuming := d.shouldResume(err)
+		select {
+		case d.resume.verdict <- resuming:
+		default:
+		}
+		if !resuming {
+			return err
+		}
+
+		d.resume.retries--
+		d.resume.attempts++
+		offset := d.resume.offset + d.transferredBytes()
+		d.log.info("origin data connection failed: %s. resume RETR from %d (%d/%d)",
+			err.Error(), offset, d.resume.attempts, d.resume.attempts+d.resume.retries)
+
+		rerr := d.resumeOrigin(offset)
+		e := &TransferResumeEvent{
+			Time:      time.Now(),
+			SessionID: d.sessionID,
+			Origin:    d.resume.proxy.originAddr,
+			Offset:    offset,
+			Attempt:   d.resume.attempts,
+		}
+		if rerr != nil {
+			e.Error = rerr.Error()
+		}
+		d.events.emit(e)
+		if rerr != nil {
+			d.log.err("cannot resume RETR: %s", rerr.Error())
+			// error reply of origin was held for resume
+			d.resume.failed.Set()
+			d.resume.proxy.sendToClient("426 Connection closed; transfer aborted")
+			return err
+		}
+	}
+}
+
+// resume only network errors on origin side while client and handler are alive
+func (d *dataHandler) shouldResume(err error) bool {
+	srcErr, ok := err.(*sourceError)
+	if !ok || d.resume.retries <= 0 || d.resume.aborted.IsSet() || d.isClosed() {
+		return false
+	}
+	if strings.Contains(srcErr.Error(), alreadyClosedMsg) {
+		return false
+	}
+	if nErr, ok := srcErr.error.(net.Error); ok && nErr.Timeout() {
+		return false
+	}
+
+	return true
+}
+
+// make new origin data connection and restart RETR from offset
+func (d *dataHandler) resumeOrigin(offset int64) error {
+	s := d.resume.proxy
+
+	mode := d.originConn.mode
+	if mode == "CLIENT" {
+		mode = d.clientConn.mode
+	}
+	reply, err := s.internalCommand(mode + "\r\n")
+	if err != nil {
+		return err
+	}
+	switch {
+	case strings.HasPrefix(reply, "227 "):
+		err = d.parsePASVresponse(reply)
+	case strings.HasPrefix(reply, "229 "):
+		err = d.parseEPSVresponse(reply)
+	default:
+		err = fmt.Errorf("%s is refused: %s", mode, strings.TrimSpace(reply))
+	}
+	if err != nil {
+		return err
+	}
+
+	conn, err := d.dialOriginData()
+	if err != nil {
+		return err
+	}
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		conn.Close()
+		return fmt.Errorf("abort: data handler already closed")
+	}
+	old := d.originConn.dataConn
+	d.originConn.dataConn = d.originLeg(conn, false)
+	d.mutex.Unlock()
+	old.Close()
+
+	if reply, err := s.internalCommand(fmt.Sprintf("REST %d\r\n", offset)); err != nil {
+		return err
+	} else if !strings.HasPrefix(reply, "350") {
+		return fmt.Errorf("REST is refused: %s", strings.TrimSpace(reply))
+	}
+
+	replies, err := s.sendInternal("RETR " + d.resume.path + "\r\n")
+	if err != nil {
+		return err
+	}
+	reply, err = waitInternalReply(replies)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(reply, "1") {
+		return fmt.Errorf("RETR is refused: %s", strings.TrimSpace(reply))
+	}
+
+	// final reply of resumed RETR is reply to client's RETR
+	go d.forwardResumedReply(replies)
+
+	return d.secureOriginData()
+}
+
+// send final reply of resumed RETR to client unless it is resumed again
+func (d *dataHandler) forwardResumedReply(replies chan string) {
+	for reply := range replies {
+		if strings.HasPrefix(reply, "1") {
+			continue
+		}
+		if d.resume.failed.IsSet() || (isErrorReply(reply) && d.waitResumeVerdict()) {
+			return
+		}
+		if strings.HasPrefix(reply, "226 ") {
+			d.inDataTransfer.UnSet()
+		}
+		if err := d.resume.proxy.sendToClient(strings.TrimRight(reply, "\r\n")); err != nil {
+			d.log.err("cannot send response to client")
+		}
+		return
+	}
+}
+
+// return true when origin data connection failed and RETR is resumed
+func (d *dataHandler) waitResumeVerdict() bool {
+	if d == nil || d.resume == nil {
+		return false
+	}
+
+	t := time.NewTimer(resumeVerdictWait)
+	defer t.Stop()
+
+	select {
+	case resuming := <-d.resume.verdict:
+		return resuming
+	case <-t.C:
+		return false
+	}
+}
+
+// return true when error reply to RETR should be held because RETR is resumed.
+// only RETR which started transfer (got 1xx) can be resumed.
+func (s *proxyServer) holdTransferError(command string, buff string, preliminary bool) bool {
+	if command != "RETR" || !preliminary || !isErrorReply(buff) {
+		return false
+	}
+
+	s.dataMutex.Lock()
+	d := s.dataConnector
+	s.dataMutex.Unlock()
+
+	return d.waitResumeVerdict()
+}
+
+// send command to origin whose replies are sent to returned channel
+// instead of client
+func (s *proxyServer) sendInternal(line string) (chan string, error) {
+	replies := make(chan string, 4)
+
+	s.commandLog(line)
+	s.pushInternalCommand(strings.ToUpper(getCommand(line)[0]), replies)
+
+	s.originMutex.Lock()
+	defer s.originMutex.Unlock()
+	if _, err := s.originWriter.WriteString(line); err != nil {
+		return nil, err
+	}
+
+	return replies, s.originWriter.Flush()
+}
+
+// send command to origin and return its final reply
+func (s *proxyServer) internalCommand(line string) (string, error) {
+	replies, err := s.sendInternal(line)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		reply, err := waitInternalReply(replies)
+		if err != nil || !strings.HasPrefix(reply, "1") {
+			return reply, err
+		}
+	}
+}
+
+func waitInternalReply(replies chan string) (string, error) {
+	t := time.NewTimer(time.Duration(connectionTimeout) * time.Second)
+	defer t.Stop()
+
+	select {
+	case reply, ok := <-replies:
+		if !ok {
+			return "", fmt.Errorf("origin connection is closed")
+		}
+		return reply, nil
+	case <-t.C:
+		return "", fmt.Errorf("origin did not reply")
+	}
+}
+
+// stop resume of current transfer because client aborted it
+func (s *proxyServer) abortResume() {
+	s.dataMutex.Lock()
+	defer s.dataMutex.Unlock()
+
+	if s.dataConnector != nil && s.dataConnector.resume != nil {
+		s.dataConnector.resume.aborted.Set()
+	}
+}
+
+// track TYPE and REST of client for resume of RETR
+func (c *clientHandler) trackTransferParams() {
+	switch c.command {
+	case "TYPE":
+		c.binaryType = isBinaryType(c.param)
+	case "REST":
+		c.restOffset, _ = strconv.ParseInt(strings.TrimSpace(c.param), 10, 64)
+	case "ALLO":
+		c.alloSize = parseAlloSize(c.param)
+	case "ABOR":
+		c.proxy.abortResume()
+		c.proxy.abortStripes()
+		c.proxy.abortDataTransfer()
+	}
+}
@@ -0,0 +1,202 @@
+package pftp
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tevino/abool"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func Test_dataHandler_shouldResume(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		retries int
+		aborted bool
+		closed  bool
+		want    bool
+	}{
+		{name: "origin_reset", err: &sourceError{errors.New("connection reset by peer")}, retries: 1, want: true},
+		{name: "client_error", err: errors.New("broken pipe"), retries: 1, want: false},
+		{name: "completed", err: nil, retries: 1, want: false},
+		{name: "no_retries", err: &sourceError{errors.New("connection reset by peer")}, retries: 0, want: false},
+		{name: "timeout", err: &sourceError{timeoutError{}}, retries: 1, want: false},
+		{name: "closed_by_proxy", err: &sourceError{errors.New(alreadyClosedMsg)}, retries: 1, want: false},
+		{name: "aborted", err: &sourceError{errors.New("connection reset by peer")}, retries: 1, aborted: true, want: false},
+		{name: "handler_closed", err: &sourceError{errors.New("connection reset by peer")}, retries: 1, closed: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dataHandler{
+				mutex:  &sync.Mutex{},
+				closed: tt.closed,
+				resume: &transferResume{retries: tt.retries, aborted: abool.New()},
+			}
+			d.resume.aborted.SetTo(tt.aborted)
+
+			if got := d.shouldResume(tt.err); got != tt.want {
+				t.Errorf("dataHandler.shouldResume() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_trackTransferParams(t *testing.T) {
+	c := &clientHandler{proxy: &proxyServer{}}
+	steps := []struct {
+		command    string
+		param      string
+		wantBinary bool
+		wantOffset int64
+	}{
+		{command: "TYPE", param: "I", wantBinary: true},
+		{command: "REST", param: "1024", wantBinary: true, wantOffset: 1024},
+		{command: "TYPE", param: "A N", wantBinary: false, wantOffset: 1024},
+		{command: "TYPE", param: "L 8", wantBinary: true, wantOffset: 1024},
+		{command: "REST", param: "abc", wantBinary: true, wantOffset: 0},
+	}
+	for i, step := range steps {
+		c.command, c.param = step.command, step.param
+		c.trackTransferParams()
+		if c.binaryType != step.wantBinary || c.restOffset != step.wantOffset {
+			t.Errorf("step %d: binaryType, restOffset = %v, %d, want %v, %d", i, c.binaryType, c.restOffset, step.wantBinary, step.wantOffset)
+		}
+	}
+}
+
+func Test_clientHandler_newTransferResume(t *testing.T) {
+	tests := []struct {
+		name        string
+		command     string
+		retries     int
+		binary      bool
+		needsListen bool
+		want        bool
+	}{
+		{name: "enabled", command: "RETR", retries: 2, binary: true, want: true},
+		{name: "disabled", command: "RETR", retries: 0, binary: true, want: false},
+		{name: "ascii", command: "RETR", retries: 2, binary: false, want: false},
+		{name: "upload", command: "STOR", retries: 2, binary: true, want: false},
+		{name: "active_origin", command: "RETR", retries: 2, binary: true, needsListen: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{
+				config:     &Config{TransferResumeRetries: tt.retries},
+				command:    tt.command,
+				param:      "file",
+				binaryType: tt.binary,
+				restOffset: 10,
+			}
+			d := &dataHandler{originConn: connector{needsListen: tt.needsListen}}
+
+			got := c.newTransferResume(d)
+			if (got != nil) != tt.want {
+				t.Fatalf("clientHandler.newTransferResume() = %v, want %v", got, tt.want)
+			}
+			if got != nil && (got.path != "file" || got.offset != 10 || got.retries != tt.retries) {
+				t.Errorf("clientHandler.newTransferResume() = %+v", got)
+			}
+		})
+	}
+}
+
+func Test_proxyServer_internalCommand(t *testing.T) {
+	originConn, originPeer := net.Pipe()
+	defer originConn.Close()
+	defer originPeer.Close()
+
+	s := &proxyServer{
+		config:         &Config{},
+		log:            &logger{},
+		isLoggedin:     true,
+		inDataTransfer: abool.New(),
+		originWriter:   bufio.NewWriter(originConn),
+	}
+
+	// client RETR is in flight while proxy sends REST
+	s.pushCommand("RETR")
+
+	go func() {
+		r := bufio.NewReader(originPeer)
+		for {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+		}
+	}()
+
+	replies, err := s.sendInternal("REST 100\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, forward := s.processOriginReply("426 Connection reset.\r\n"); !forward || got != "426 Connection reset.\r\n" {
+		t.Errorf("proxyServer.processOriginReply(RETR) = %q, %v", got, forward)
+	}
+	if got, forward := s.processOriginReply("350 Restart position accepted.\r\n"); forward {
+		t.Errorf("proxyServer.processOriginReply(REST) = %q is forwarded to client", got)
+	}
+	if got, err := waitInternalReply(replies); err != nil || got != "350 Restart position accepted.\r\n" {
+		t.Errorf("internal reply = %q, %v", got, err)
+	}
+	if n := s.inflightCount(); n != 0 {
+		t.Errorf("in-flight commands = %d, want 0", n)
+	}
+
+	// channel of internal command is closed with origin connection
+	replies, err = s.sendInternal("PASV\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.closeInternalReplies()
+	if _, err := waitInternalReply(replies); err == nil {
+		t.Errorf("waitInternalReply() after close is not error")
+	}
+}
+
+func Test_proxyServer_holdTransferError(t *testing.T) {
+	tests := []struct {
+		name        string
+		command     string
+		preliminary bool
+		reply       string
+		verdict     bool
+		want        bool
+	}{
+		{name: "resuming", command: "RETR", preliminary: true, reply: "426 Connection reset.\r\n", verdict: true, want: true},
+		{name: "not_resuming", command: "RETR", preliminary: true, reply: "426 Connection reset.\r\n", verdict: false, want: false},
+		{name: "success", command: "RETR", preliminary: true, reply: "226 Transfer complete.\r\n", verdict: true, want: false},
+		{name: "not_started", command: "RETR", preliminary: false, reply: "550 No such file.\r\n", verdict: true, want: false},
+		{name: "upload", command: "STOR", preliminary: true, reply: "426 Connection reset.\r\n", verdict: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dataHandler{resume: &transferResume{verdict: make(chan bool, 1)}}
+			d.resume.verdict <- tt.verdict
+			s := &proxyServer{dataConnector: d}
+
+			done := make(chan bool)
+			go func() { done <- s.holdTransferError(tt.command, tt.reply, tt.preliminary) }()
+
+			select {
+			case got := <-done:
+				if got != tt.want {
+					t.Errorf("proxyServer.holdTransferError() = %v, want %v", got, tt.want)
+				}
+			case <-time.After(3 * time.Second):
+				t.Errorf("proxyServer.holdTransferError() did not return")
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package pftp
+
+import (
+	"strings"
+)
+
+// return true when TYPE parameter is binary (image or local byte size 8)
+func isBinaryType(param string) bool {
+	words := strings.Fields(strings.ToUpper(param))
+	if len(words) == 0 {
+		return false
+	}
+
+	switch words[0] {
+	case "I":
+		return true
+	case "L":
+		return len(words) == 2 && words[1] == "8"
+	}
+
+	return false
+}
+
+// reject TYPE, MODE and STRU parameters not allowed for session and make
+// sure origin is in binary type before transfers when binary is forced.
+// return reply when command is rejected by proxy.
+func (c *clientHandler) enforceTransferParams() *result {
+	unsupported := &result{
+		code: 504,
+		msg:  c.message(msgUnsupportedParameter),
+	}
+	param := strings.ToUpper(strings.TrimSpace(c.param))
+
+	switch c.command {
+	case "TYPE":
+		if c.context.ForceBinary && !isBinaryType(param) {
+			return unsupported
+		}
+	case "MODE":
+		// only stream mode is supported by data channel proxy and most origins
+		if c.context.StrictTransferMode && param != "S" && !(param == "Z" && c.context.ClientModeZ) {
+			return unsupported
+		}
+	case "STRU":
+		if c.context.StrictTransferMode && param != "F" {
+			return unsupported
+		}
+	case "REST", "RETR", "STOR", "STOU", "APPE":
+		// origin starts in ASCII type. TYPE I is sent before REST because
+		// REST must be followed by transfer command.
+		if c.context.ForceBinary && !c.binaryType && c.proxy.isLoggedIn() {
+			if reply, err := c.proxy.internalCommand("TYPE I\r\n"); err != nil {
+				c.log.err("cannot set binary type of origin: %s", err.Error())
+			} else if !strings.HasPrefix(reply, "2") {
+				c.log.err("origin refused binary type: %s", strings.TrimSpace(reply))
+			} else {
+				c.binaryType = true
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package pftp
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/tevino/abool"
+)
+
+func Test_isBinaryType(t *testing.T) {
+	tests := []struct {
+		param string
+		want  bool
+	}{
+		{param: "I", want: true},
+		{param: "i", want: true},
+		{param: "L 8", want: true},
+		{param: "L 7", want: false},
+		{param: "A", want: false},
+		{param: "A N", want: false},
+		{param: "E", want: false},
+		{param: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			if got := isBinaryType(tt.param); got != tt.want {
+				t.Errorf("isBinaryType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_enforceTransferParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  string
+		param    string
+		binary   bool
+		strict   bool
+		wantCode int
+	}{
+		{name: "type_a_forced_binary", command: "TYPE", param: "A", binary: true, wantCode: 504},
+		{name: "type_i_forced_binary", command: "TYPE", param: "I", binary: true},
+		{name: "type_a", command: "TYPE", param: "A"},
+		{name: "mode_b_strict", command: "MODE", param: "B", strict: true, wantCode: 504},
+		{name: "mode_s_strict", command: "MODE", param: "s", strict: true},
+		{name: "mode_c", command: "MODE", param: "C"},
+		{name: "stru_r_strict", command: "STRU", param: "R", strict: true, wantCode: 504},
+		{name: "stru_f_strict", command: "STRU", param: "F", strict: true},
+		{name: "retr_before_login", command: "RETR", param: "file", binary: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clientHandler{
+				config:  &Config{},
+				context: &Context{ForceBinary: tt.binary, StrictTransferMode: tt.strict},
+				proxy:   &proxyServer{},
+				log:     &logger{},
+				command: tt.command,
+				param:   tt.param,
+			}
+
+			r := c.enforceTransferParams()
+			if tt.wantCode == 0 && r != nil {
+				t.Errorf("clientHandler.enforceTransferParams() = %+v, want nil", r)
+			} else if tt.wantCode != 0 && (r == nil || r.code != tt.wantCode) {
+				t.Errorf("clientHandler.enforceTransferParams() = %+v, want code %d", r, tt.wantCode)
+			}
+		})
+	}
+}
+
+func Test_clientHandler_enforceTransferParams_sets_binary(t *testing.T) {
+	originConn, originPeer := net.Pipe()
+	defer originConn.Close()
+	defer originPeer.Close()
+
+	s := &proxyServer{
+		config:         &Config{},
+		log:            &logger{},
+		isLoggedin:     true,
+		inDataTransfer: abool.New(),
+		originWriter:   bufio.NewWriter(originConn),
+	}
+	c := &clientHandler{
+		config:  &Config{},
+		context: &Context{ForceBinary: true},
+		proxy:   s,
+		log:     &logger{},
+		command: "RETR",
+		param:   "file",
+	}
+
+	got := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(originPeer).ReadString('\n')
+		got <- line
+		s.processOriginReply("200 Switching to Binary mode.\r\n")
+	}()
+
+	if r := c.enforceTransferParams(); r != nil {
+		t.Fatalf("clientHandler.enforceTransferParams() = %+v", r)
+	}
+	if line := <-got; line != "TYPE I\r\n" {
+		t.Errorf("command sent to origin = %q, want TYPE I", line)
+	}
+	if !c.binaryType {
+		t.Errorf("binaryType is not set after TYPE I")
+	}
+
+	// TYPE I is sent only once
+	if r := c.enforceTransferParams(); r != nil {
+		t.Errorf("clientHandler.enforceTransferParams() = %+v", r)
+	}
+}
@@ -0,0 +1,114 @@
+package pftp
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// policies of max_connections over file descriptor limit
+const (
+	fdLimitClamp = "clamp"
+	fdLimitWarn  = "warn"
+)
+
+// file descriptors kept for listeners, admin API, logs and stores
+const reservedFDs = 64
+
+// return file descriptors one session uses at most during data transfer
+func fdsPerSession(c *Config) int {
+	// client and origin control connections
+	n := 2
+	if c.DataChanProxy {
+		// client and origin data connections and passive listener
+		n += 3
+	}
+	if len(c.ShadowAddr) > 0 {
+		// control connection of shadow origin
+		n++
+		if c.ShadowUploads {
+			n++
+		}
+	}
+	if len(c.MirrorUploadAddr) > 0 {
+		// control and data connections of mirror
+		n += 2
+	}
+	if c.TransferStreams > 1 {
+		// other origin sessions of stripes and their buffer files
+		n += (c.TransferStreams - 1) * 3
+	}
+	if c.SpoolUploads {
+		n++
+	}
+
+	return n
+}
+
+// return max_connections attainable by file descriptor limit
+func safeMaxConnections(c *Config, limit uint64) int32 {
+	reserved := uint64(reservedFDs)
+	if c.SpoolUploads {
+		// origin control and data connections and spooled file of workers
+		reserved += uint64(c.SpoolWorkers) * 3
+	}
+	if limit <= reserved {
+		return 1
+	}
+
+	n := (limit - reserved) / uint64(fdsPerSession(c))
+	if n < 1 {
+		return 1
+	}
+	if n > 1<<31-1 {
+		return 1<<31 - 1
+	}
+
+	return int32(n)
+}
+
+// return soft limit of RLIMIT_NOFILE
+func openFileLimit() (uint64, error) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, err
+	}
+
+	return uint64(rl.Cur), nil
+}
+
+// set max_connections by file descriptor limit when it is not set. configured
+// value over the limit is clamped, or only warned by fd_limit_policy "warn".
+// soft_max_connections is lowered under clamped max_connections.
+func (server *FtpServer) applyFDLimit(limit func() (uint64, error)) error {
+	c := server.config
+	n, err := limit()
+	if err != nil {
+		if c.MaxConnections <= 0 {
+			return fmt.Errorf("max_connections is not set and file descriptor limit is unknown: %s", err)
+		}
+		server.logger.Warnf("cannot check max_connections by file descriptor limit: %s", err)
+		return nil
+	}
+
+	safe := safeMaxConnections(c, n)
+	switch {
+	case c.MaxConnections <= 0:
+		c.MaxConnections = safe
+		server.logger.Infof("max_connections is %d by file descriptor limit %d (%d per session)", safe, n, fdsPerSession(c))
+	case c.MaxConnections <= safe:
+		return nil
+	case c.FDLimitPolicy == fdLimitWarn:
+		server.logger.Warnf("max_connections %d is over %d sessions attainable by file descriptor limit %d (%d per session)", c.MaxConnections, safe, n, fdsPerSession(c))
+		return nil
+	default:
+		server.logger.Warnf("max_connections %d is clamped to %d by file descriptor limit %d (%d per session)", c.MaxConnections, safe, n, fdsPerSession(c))
+		c.MaxConnections = safe
+	}
+
+	if c.SoftMaxConnections >= c.MaxConnections {
+		c.SoftMaxConnections = c.MaxConnections - 1
+		server.logger.Warnf("soft_max_connections is lowered to %d", c.SoftMaxConnections)
+	}
+
+	return nil
+}
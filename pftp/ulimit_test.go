@@ -0,0 +1,64 @@
+package pftp
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func Test_FtpServer_applyFDLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *Config
+		limit    uint64
+		err      error
+		wantMax  int32
+		wantSoft int32
+		wantErr  bool
+	}{
+		{name: "derived", config: &Config{}, limit: 1064, wantMax: 500},
+		{name: "derived_data_proxy", config: &Config{DataChanProxy: true}, limit: 1064, wantMax: 200},
+		{name: "attainable", config: &Config{MaxConnections: 100}, limit: 1064, wantMax: 100},
+		{name: "clamped", config: &Config{MaxConnections: 1000, SoftMaxConnections: 800}, limit: 1064, wantMax: 500, wantSoft: 499},
+		{name: "warned", config: &Config{MaxConnections: 1000, FDLimitPolicy: fdLimitWarn}, limit: 1064, wantMax: 1000},
+		{name: "limit_unknown", config: &Config{MaxConnections: 1000}, err: errors.New("not supported"), wantMax: 1000},
+		{name: "limit_unknown_not_set", config: &Config{}, err: errors.New("not supported"), wantErr: true},
+		{name: "small_limit", config: &Config{}, limit: 10, wantMax: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &FtpServer{config: tt.config, logger: logrus.New()}
+			err := server.applyFDLimit(func() (uint64, error) { return tt.limit, tt.err })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyFDLimit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.config.MaxConnections != tt.wantMax || tt.config.SoftMaxConnections != tt.wantSoft {
+				t.Errorf("max_connections = %d, soft_max_connections = %d, want %d, %d", tt.config.MaxConnections, tt.config.SoftMaxConnections, tt.wantMax, tt.wantSoft)
+			}
+		})
+	}
+}
+
+func Test_fdsPerSession(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		want   int
+	}{
+		{name: "control_only", config: &Config{}, want: 2},
+		{name: "data_proxy", config: &Config{DataChanProxy: true}, want: 5},
+		{name: "shadow_uploads", config: &Config{ShadowAddr: "127.0.0.1:21", ShadowUploads: true}, want: 4},
+		{name: "stripes", config: &Config{DataChanProxy: true, TransferStreams: 4}, want: 14},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fdsPerSession(tt.config); got != tt.want {
+				t.Errorf("fdsPerSession() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package pftp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// scheme of unix domain socket addresses of listen_addr and origins.
+// ex) "unix:///var/run/ftp.sock"
+const unixScheme = "unix://"
+
+// peers of unix domain socket are on same host, so their address is loopback
+// for data connections, logs and PROXY protocol
+const unixPeerIP = "127.0.0.1"
+
+// return network and address to listen or dial addr
+func splitNetworkAddr(addr string) (string, string) {
+	if strings.HasPrefix(addr, unixScheme) {
+		return "unix", strings.TrimPrefix(addr, unixScheme)
+	}
+
+	return "tcp", addr
+}
+
+func isUnixAddr(addr string) bool {
+	return strings.HasPrefix(addr, unixScheme)
+}
+
+// validate unix domain socket address
+func validateNetworkAddr(name string, addr string) error {
+	if network, path := splitNetworkAddr(addr); network == "unix" && len(path) == 0 {
+		return errors.New("configuration error: " + name + " has no socket path. use unix:///path/to.sock")
+	}
+
+	return nil
+}
+
+// listen addr. stale socket file left by killed process is removed, and
+// socket in use by other process is an error.
+func listenNetworkAddr(addr string) (net.Listener, error) {
+	network, address := splitNetworkAddr(addr)
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+
+	if fi, err := os.Stat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		conn, err := net.DialTimeout("unix", address, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil, &net.OpError{Op: "listen", Net: network, Err: syscall.EADDRINUSE}
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			os.Remove(address)
+		}
+	}
+
+	return net.Listen(network, address)
+}
+
+// dial addr by dialer, through unix domain socket for unix:// address
+func dialNetworkAddr(ctx context.Context, dialer OriginDialer, addr string) (net.Conn, error) {
+	network, address := splitNetworkAddr(addr)
+	return dialer(ctx, network, address)
+}
+
+// return IP of connection address, loopback for unix domain socket
+func addrIP(a net.Addr) string {
+	if a == nil || a.Network() == "unix" {
+		return unixPeerIP
+	}
+
+	host, _, _ := net.SplitHostPort(a.String())
+	return host
+}
+
+// return "ip:port" of connection address. unix domain socket peers have no
+// address, so they are loopback with port 0.
+func hostPort(a net.Addr) string {
+	if a == nil || a.Network() == "unix" {
+		return net.JoinHostPort(unixPeerIP, "0")
+	}
+
+	return a.String()
+}
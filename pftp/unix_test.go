@@ -0,0 +1,77 @@
+package pftp
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_splitNetworkAddr(t *testing.T) {
+	tests := []struct {
+		addr        string
+		wantNetwork string
+		wantAddr    string
+	}{
+		{"127.0.0.1:21", "tcp", "127.0.0.1:21"},
+		{"unix:///var/run/ftp.sock", "unix", "/var/run/ftp.sock"},
+		{"unix://ftp.sock", "unix", "ftp.sock"},
+	}
+	for _, tt := range tests {
+		network, addr := splitNetworkAddr(tt.addr)
+		if network != tt.wantNetwork || addr != tt.wantAddr {
+			t.Errorf("splitNetworkAddr(%s) = %s, %s, want %s, %s", tt.addr, network, addr, tt.wantNetwork, tt.wantAddr)
+		}
+	}
+
+	if err := validateNetworkAddr("listen_addr", "unix://"); err == nil {
+		t.Error("validateNetworkAddr() accepts unix address without path")
+	}
+}
+
+func Test_listenNetworkAddr(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ftp.sock")
+
+	// socket file left by killed process
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := listenNetworkAddr(unixScheme + path)
+	if err != nil {
+		t.Fatalf("listenNetworkAddr() over stale socket error = %v", err)
+	}
+	defer l.Close()
+
+	// socket in use
+	if other, err := listenNetworkAddr(unixScheme + path); err == nil {
+		other.Close()
+		t.Fatal("listenNetworkAddr() listens socket in use")
+	}
+
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Write([]byte("220 ready\r\n"))
+			conn.Close()
+		}
+	}()
+	conn, err := dialEgress(nil, "", unixScheme+path, time.Second)
+	if err != nil {
+		t.Fatalf("dialEgress() error = %v", err)
+	}
+	defer conn.Close()
+	if got := hostPort(conn.RemoteAddr()); got != "127.0.0.1:0" {
+		t.Errorf("hostPort() = %s, want 127.0.0.1:0", got)
+	}
+	if got := addrIP(conn.LocalAddr()); got != unixPeerIP {
+		t.Errorf("addrIP() = %s, want %s", got, unixPeerIP)
+	}
+
+	if _, err := dialEgress(nil, "socks5://127.0.0.1:1080", unixScheme+path, time.Second); err == nil {
+		t.Error("dialEgress() dials unix socket through origin proxy")
+	}
+}
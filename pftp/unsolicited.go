@@ -0,0 +1,97 @@
+package pftp
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// actions for reply sent by origin without command
+const (
+	unsolicitedForward   = "forward"
+	unsolicitedSuppress  = "suppress"
+	unsolicitedTransform = "transform"
+)
+
+// wait for origin closing control connection after 421 up to this
+const originCloseWait = 100 * time.Millisecond
+
+// return true when origin closed control connection after reply. it reads
+// ahead origin reader, so it must be called from origin read goroutine.
+func (s *proxyServer) originClosed() bool {
+	if s.origin == nil || s.originReader == nil || s.originReader.Buffered() > 0 {
+		return false
+	}
+
+	s.origin.SetReadDeadline(time.Now().Add(originCloseWait))
+	_, err := s.originReader.Peek(1)
+	s.origin.SetReadDeadline(s.idleDeadline())
+	if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+		return false
+	}
+
+	return err != nil
+}
+
+// return action for reply code. "default" key is used when code is not set.
+func unsolicitedAction(actions map[string]string, code string) string {
+	if action, ok := actions[code]; ok {
+		return action
+	}
+	if action, ok := actions["default"]; ok {
+		return action
+	}
+
+	return unsolicitedForward
+}
+
+// validate unsolicited_replies config
+func validateUnsolicitedReplies(actions map[string]string, message string) error {
+	for code, action := range actions {
+		switch action {
+		case unsolicitedForward, unsolicitedSuppress, unsolicitedTransform:
+		default:
+			return fmt.Errorf("configuration error: unsolicited reply action %s for %s is wrong", action, code)
+		}
+	}
+
+	if _, err := formatUnsolicitedReply(message, "421", "check"); err != nil {
+		return fmt.Errorf("configuration error: unsolicited reply message is wrong: %s", err.Error())
+	}
+
+	return nil
+}
+
+// make transformed text of unsolicited reply.
+// template can use {{.Code}} and {{.Text}} (text of original reply).
+func formatUnsolicitedReply(message string, code string, text string) (string, error) {
+	t, err := template.New("unsolicited").Option("missingkey=error").Parse(message)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, struct{ Code, Text string }{code, text}); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// return text of reply without codes
+// ex) "421-Server is going down\r\n421 in 5 minutes\r\n" -> "Server is going down\nin 5 minutes"
+func replyText(reply string) string {
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimRight(reply, "\r\n"), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) >= 4 && (line[3] == '-' || line[3] == ' ') {
+			line = line[4:]
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,166 @@
+package pftp
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/tevino/abool"
+)
+
+func Test_proxyServer_unsolicitedReply(t *testing.T) {
+	tests := []struct {
+		name        string
+		actions     map[string]string
+		message     string
+		reply       string
+		want        string
+		wantForward bool
+		wantAction  string
+	}{
+		{
+			name:        "forward_by_default",
+			reply:       "421 Timeout.\r\n",
+			want:        "421 Timeout.\r\n",
+			wantForward: true,
+			wantAction:  "forward",
+		},
+		{
+			name:        "suppress_code",
+			actions:     map[string]string{"421": "suppress"},
+			reply:       "421 Timeout.\r\n",
+			want:        "421 Timeout.\r\n",
+			wantForward: false,
+			wantAction:  "suppress",
+		},
+		{
+			name:        "transform_default",
+			actions:     map[string]string{"default": "transform", "421": "forward"},
+			message:     "Notice from server: {{.Text}}",
+			reply:       "220-Server is going down\r\n220 in 5 minutes\r\n",
+			want:        "220-Notice from server: Server is going down\r\n220 in 5 minutes\r\n",
+			wantForward: true,
+			wantAction:  "transform",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := newEventBus()
+			s := &proxyServer{
+				config:         &Config{UnsolicitedReplies: tt.actions, UnsolicitedReplyMsg: tt.message},
+				log:            &logger{},
+				isLoggedin:     true,
+				inDataTransfer: abool.New(),
+				events:         events,
+				sessionID:      3,
+			}
+
+			got, forward := s.processOriginReply(tt.reply)
+			if got != tt.want || forward != tt.wantForward {
+				t.Errorf("proxyServer.processOriginReply() = %q, %v, want %q, %v", got, forward, tt.want, tt.wantForward)
+			}
+
+			e, ok := (<-events.ch).(*OriginNoticeEvent)
+			if !ok || e.Action != tt.wantAction || e.SessionID != 3 {
+				t.Errorf("OriginNoticeEvent = %+v, want action %s", e, tt.wantAction)
+			}
+		})
+	}
+}
+
+func Test_proxyServer_processOriginReply_not_unsolicited(t *testing.T) {
+	s := &proxyServer{
+		config:         &Config{UnsolicitedReplies: map[string]string{"default": "suppress"}},
+		log:            &logger{},
+		inDataTransfer: abool.New(),
+		welcomeMsg:     "220 FTP proxy ready\r\n",
+	}
+
+	// greeting before login and reply to command are not unsolicited
+	if got, forward := s.processOriginReply("220 origin ready\r\n"); !forward || got != "220 FTP proxy ready\r\n" {
+		t.Errorf("proxyServer.processOriginReply(greeting) = %q, %v", got, forward)
+	}
+
+	s.pushCommand("USER")
+	if _, forward := s.processOriginReply("331 Please specify the password.\r\n"); !forward {
+		t.Errorf("proxyServer.processOriginReply(reply to USER) is suppressed")
+	}
+}
+
+func Test_proxyServer_processOriginReply_closing(t *testing.T) {
+	tests := []struct {
+		name        string
+		closed      bool
+		wantCommand string
+		wantNotice  bool // OriginNoticeEvent is emitted
+	}{
+		{name: "closed", closed: true, wantCommand: "RETR", wantNotice: true},
+		{name: "reply", wantCommand: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originConn, originPeer := net.Pipe()
+			defer originConn.Close()
+			if tt.closed {
+				originPeer.Close()
+			} else {
+				defer originPeer.Close()
+			}
+
+			events := newEventBus()
+			s := &proxyServer{
+				config:         &Config{},
+				log:            &logger{},
+				isLoggedin:     true,
+				inDataTransfer: abool.New(),
+				events:         events,
+				origin:         originConn,
+				originReader:   bufio.NewReader(originConn),
+			}
+			s.pushCommand("RETR")
+
+			if got, forward := s.processOriginReply("421 Timeout.\r\n"); !forward || got != "421 Timeout.\r\n" {
+				t.Errorf("proxyServer.processOriginReply() = %q, %v", got, forward)
+			}
+			if command := s.currentCommand(); command != tt.wantCommand {
+				t.Errorf("command in flight = %q, want %q", command, tt.wantCommand)
+			}
+			_, notice := (<-events.ch).(*OriginNoticeEvent)
+			if notice != tt.wantNotice {
+				t.Errorf("OriginNoticeEvent emitted = %v, want %v", notice, tt.wantNotice)
+			}
+		})
+	}
+}
+
+func Test_validateUnsolicitedReplies(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions map[string]string
+		message string
+		wantErr bool
+	}{
+		{
+			name:    "ok",
+			actions: map[string]string{"421": "suppress", "default": "transform"},
+			message: "{{.Code}}: {{.Text}}",
+		},
+		{
+			name:    "wrong_action",
+			actions: map[string]string{"421": "drop"},
+			wantErr: true,
+		},
+		{
+			name:    "wrong_template",
+			message: "{{.Reply}}",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateUnsolicitedReplies(tt.actions, tt.message); (err != nil) != tt.wantErr {
+				t.Errorf("validateUnsolicitedReplies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package pftp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DuplicateUpload is STOR of same user, origin, path and size as upload in
+// duplicate_upload_window. it is given to function of UseDuplicateUpload.
+// Size is announced by ALLO.
+type DuplicateUpload struct {
+	Key               string
+	Path              string
+	Size              int64
+	PreviousSessionID uint64
+	PreviousSize      int64
+	PreviousCompleted bool
+	Since             time.Duration
+}
+
+type duplicateUploadFunc func(*Context, *DuplicateUpload) error
+
+// UseDuplicateUpload set function called before STOR when same file was
+// uploaded in duplicate_upload_window, ex. by client retrying after proxy
+// failure. Returning error rejects STOR with 553.
+func (server *FtpServer) UseDuplicateUpload(f duplicateUploadFunc) {
+	server.hooks.duplicateUpload = f
+}
+
+type uploadRecord struct {
+	key       string
+	sessionID uint64
+	size      int64
+	completed bool
+	time      time.Time
+}
+
+// uploadHistory keep last upload of each user, origin and path for
+// duplicate_upload_window. it is shared by all client sessions of server.
+type uploadHistory struct {
+	mutex   sync.Mutex
+	window  time.Duration
+	records map[string]*uploadRecord
+	order   []uploadExpiry // records in order of time
+	now     func() time.Time
+}
+
+type uploadExpiry struct {
+	id   string
+	time time.Time
+}
+
+func newUploadHistory(c *Config) *uploadHistory {
+	if c.DuplicateUploadWindow <= 0 {
+		return nil
+	}
+
+	return &uploadHistory{
+		window:  time.Duration(c.DuplicateUploadWindow) * time.Second,
+		records: map[string]*uploadRecord{},
+		now:     time.Now,
+	}
+}
+
+// return last upload of id in window. expired records are removed.
+func (h *uploadHistory) previous(id string) *uploadRecord {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.expire()
+	if r, ok := h.records[id]; ok {
+		copied := *r
+		return &copied
+	}
+
+	return nil
+}
+
+// record upload of id at now
+func (h *uploadHistory) add(id string, r *uploadRecord) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	r.time = h.now()
+	h.records[id] = r
+	h.order = append(h.order, uploadExpiry{id: id, time: r.time})
+	h.expire()
+}
+
+// remove records older than window. it is called with mutex held.
+func (h *uploadHistory) expire() {
+	now := h.now()
+	n := 0
+	for _, e := range h.order {
+		if now.Sub(e.time) <= h.window {
+			break
+		}
+		// record may be replaced by newer upload
+		if r, ok := h.records[e.id]; ok && r.time.Equal(e.time) {
+			delete(h.records, e.id)
+		}
+		n++
+	}
+	h.order = h.order[n:]
+}
+
+// return size of ALLO parameter "<size> [R <record size>]", -1 when it is invalid
+func parseAlloSize(param string) int64 {
+	fields := strings.Fields(param)
+	if len(fields) == 0 {
+		return -1
+	}
+	n, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+
+	return n
+}
+
+// upload is STOR of session checked for duplicate
+type upload struct {
+	id       string
+	path     string
+	started  time.Time
+	previous *uploadRecord
+}
+
+// return correlation key of upload. same key is given to duplicates of it.
+func uploadKey(id string, size int64, t time.Time) string {
+	sum := sha256.Sum256([]byte(id + "\x00" + strconv.FormatInt(size, 10) + "\x00" + strconv.FormatInt(t.UnixNano(), 10)))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// check STOR against uploads in duplicate_upload_window. result is returned
+// when duplicate is rejected by reject_duplicate_uploads or hook. STOR is
+// checked only when its size is announced by ALLO, because other files of
+// same path are not duplicates.
+func (c *clientHandler) startUpload() (*upload, *result) {
+	if c.uploads == nil || c.command != "STOR" {
+		return nil, nil
+	}
+
+	p := strings.TrimSpace(c.param)
+	if cwd := c.proxy.getCwd(); len(cwd) > 0 && !strings.HasPrefix(p, "/") {
+		p = path.Join(cwd, p)
+	}
+	u := &upload{
+		id:      strings.Join([]string{c.user, c.proxy.originAddr, p}, "\x00"),
+		path:    p,
+		started: c.uploads.now(),
+	}
+	u.previous = c.uploads.previous(u.id)
+	size := c.alloSize
+	c.alloSize = -1
+	if u.previous == nil || size < 0 || size != u.previous.size {
+		return u, nil
+	}
+
+	d := &DuplicateUpload{
+		Key:               u.previous.key,
+		Path:              p,
+		Size:              size,
+		PreviousSessionID: u.previous.sessionID,
+		PreviousSize:      u.previous.size,
+		PreviousCompleted: u.previous.completed,
+		Since:             u.started.Sub(u.previous.time),
+	}
+	var err error
+	if c.config.RejectDuplicateUploads && u.previous.completed {
+		err = fmt.Errorf("same file was uploaded by session %d %s ago", d.PreviousSessionID, d.Since.Round(time.Second))
+	} else if c.hooks != nil && c.hooks.duplicateUpload != nil {
+		err = c.hooks.duplicateUpload(c.context, d)
+	}
+	if err == nil {
+		return u, nil
+	}
+
+	c.log.info("duplicate upload of %s is rejected: %s", p, err.Error())
+	c.events.emit(&UploadEvent{
+		Time:              time.Now(),
+		SessionID:         c.id,
+		User:              c.log.user,
+		Origin:            c.proxy.originAddr,
+		Path:              p,
+		Size:              size,
+		Key:               d.Key,
+		Duplicate:         true,
+		Rejected:          true,
+		PreviousSessionID: d.PreviousSessionID,
+	})
+
+	return nil, &result{
+		code: 553,
+		msg:  c.message(msgDuplicateUpload),
+	}
+}
+
+// record upload of n bytes. it is duplicate when previous upload in window
+// had same size, and gets key of previous upload.
+func (c *clientHandler) endUpload(u *upload, n int64, completed bool) {
+	if u == nil {
+		return
+	}
+
+	e := &UploadEvent{
+		Time:      time.Now(),
+		SessionID: c.id,
+		User:      c.log.user,
+		Origin:    c.proxy.originAddr,
+		Path:      u.path,
+		Size:      n,
+		Completed: completed,
+		Key:       uploadKey(u.id, n, u.started),
+	}
+	if u.previous != nil && u.previous.size == n {
+		e.Key, e.Duplicate, e.PreviousSessionID = u.previous.key, true, u.previous.sessionID
+		c.log.info("upload of %s (%d bytes) is duplicate of session %d", u.path, n, u.previous.sessionID)
+		c.metrics.inc("pftp_duplicate_uploads_total", "Uploads of same file as upload in duplicate_upload_window.", "origin", c.proxy.originAddr)
+	}
+
+	c.uploads.add(u.id, &uploadRecord{
+		key:       e.Key,
+		sessionID: c.id,
+		size:      n,
+		completed: completed,
+	})
+	c.events.emit(e)
+}
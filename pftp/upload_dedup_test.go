@@ -0,0 +1,146 @@
+package pftp
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newDedupClient(config *Config, uploads *uploadHistory, id uint64) *clientHandler {
+	return &clientHandler{
+		id:       id,
+		config:   config,
+		uploads:  uploads,
+		hooks:    &hooks{},
+		events:   newEventBus(),
+		context:  newContext(config),
+		log:      &logger{user: "alice"},
+		user:     "alice",
+		alloSize: -1,
+		proxy:    &proxyServer{originAddr: "origin:21"},
+	}
+}
+
+func Test_duplicateUpload(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       *Config
+		first        int64
+		completed    bool
+		allo         string
+		line         string
+		elapsed      time.Duration
+		hook         error
+		second       int64
+		wantRejected bool
+		wantDup      bool
+	}{
+		{name: "retry", config: &Config{DuplicateUploadWindow: 60}, first: 100, completed: false, line: "STOR a.txt", second: 100, wantDup: true},
+		{name: "other_size", config: &Config{DuplicateUploadWindow: 60}, first: 100, completed: true, line: "STOR a.txt", second: 50},
+		{name: "other_path", config: &Config{DuplicateUploadWindow: 60}, first: 100, completed: true, line: "STOR b.txt", second: 100},
+		{name: "expired", config: &Config{DuplicateUploadWindow: 60}, first: 100, completed: true, line: "STOR a.txt", elapsed: 2 * time.Minute, second: 100},
+		{name: "no_reject_unknown_size", config: &Config{DuplicateUploadWindow: 60, RejectDuplicateUploads: true}, first: 100, completed: true, line: "STOR a.txt", second: 100, wantDup: true},
+		{name: "reject_allo", config: &Config{DuplicateUploadWindow: 60, RejectDuplicateUploads: true}, first: 100, completed: true, allo: "ALLO 100", line: "STOR a.txt", wantRejected: true},
+		{name: "allo_other_size", config: &Config{DuplicateUploadWindow: 60, RejectDuplicateUploads: true}, first: 100, completed: true, allo: "ALLO 200", line: "STOR a.txt", second: 200},
+		{name: "no_reject_incomplete", config: &Config{DuplicateUploadWindow: 60, RejectDuplicateUploads: true}, first: 100, completed: false, line: "STOR a.txt", second: 100, wantDup: true},
+		{name: "hook", config: &Config{DuplicateUploadWindow: 60}, first: 100, completed: true, allo: "ALLO 100", line: "STOR a.txt", hook: errors.New("dup"), wantRejected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploads := newUploadHistory(tt.config)
+			now := time.Now()
+			uploads.now = func() time.Time { return now }
+
+			first := newDedupClient(tt.config, uploads, 1)
+			first.parseLine("STOR a.txt")
+			u, r := first.startUpload()
+			if u == nil || r != nil {
+				t.Fatalf("startUpload() of first = %+v, %+v", u, r)
+			}
+			first.endUpload(u, tt.first, tt.completed)
+			e1 := (<-first.events.ch).(*UploadEvent)
+			if e1.Duplicate || e1.Size != tt.first || e1.Completed != tt.completed || len(e1.Key) == 0 {
+				t.Fatalf("first event = %+v", e1)
+			}
+
+			uploads.now = func() time.Time { return now.Add(tt.elapsed) }
+			second := newDedupClient(tt.config, uploads, 2)
+			if tt.hook != nil {
+				second.hooks.duplicateUpload = func(_ *Context, d *DuplicateUpload) error {
+					if d.PreviousSessionID != 1 || d.PreviousSize != tt.first || d.Key != e1.Key {
+						t.Errorf("duplicate = %+v", d)
+					}
+					return tt.hook
+				}
+			}
+			if len(tt.allo) > 0 {
+				second.parseLine(tt.allo)
+				second.trackTransferParams()
+			}
+			second.parseLine(tt.line)
+			u, r = second.startUpload()
+			if tt.wantRejected {
+				if r == nil || r.code != 553 || u != nil {
+					t.Fatalf("startUpload() = %+v, %+v, want 553", u, r)
+				}
+				e := (<-second.events.ch).(*UploadEvent)
+				if !e.Rejected || !e.Duplicate || e.Key != e1.Key || e.PreviousSessionID != 1 {
+					t.Errorf("rejected event = %+v", e)
+				}
+				return
+			}
+			if r != nil {
+				t.Fatalf("startUpload() = %+v, want accepted", r)
+			}
+
+			second.endUpload(u, tt.second, true)
+			e2 := (<-second.events.ch).(*UploadEvent)
+			if e2.Duplicate != tt.wantDup || (e2.Key == e1.Key) != tt.wantDup {
+				t.Errorf("second event = %+v, first key %s, want duplicate %v", e2, e1.Key, tt.wantDup)
+			}
+			if tt.wantDup && e2.PreviousSessionID != 1 {
+				t.Errorf("previous session = %d, want 1", e2.PreviousSessionID)
+			}
+		})
+	}
+}
+
+func Test_parseAlloSize(t *testing.T) {
+	tests := []struct {
+		param string
+		want  int64
+	}{
+		{"1024", 1024},
+		{"1024 R 128", 1024},
+		{"", -1},
+		{"-5", -1},
+		{"abc", -1},
+	}
+	for _, tt := range tests {
+		if got := parseAlloSize(tt.param); got != tt.want {
+			t.Errorf("parseAlloSize(%q) = %d, want %d", tt.param, got, tt.want)
+		}
+	}
+}
+
+func Test_uploadHistory_expire(t *testing.T) {
+	uploads := newUploadHistory(&Config{DuplicateUploadWindow: 60})
+	now := time.Now()
+	uploads.now = func() time.Time { return now }
+
+	uploads.add("a", &uploadRecord{size: 1})
+	uploads.add("b", &uploadRecord{size: 1})
+	now = now.Add(30 * time.Second)
+	uploads.add("a", &uploadRecord{size: 2})
+	now = now.Add(40 * time.Second)
+
+	if r := uploads.previous("a"); r == nil || r.size != 2 {
+		t.Errorf("previous(a) = %+v, want newer upload", r)
+	}
+	if r := uploads.previous("b"); r != nil {
+		t.Errorf("previous(b) = %+v, want expired", r)
+	}
+	if len(uploads.records) != 1 || len(uploads.order) != 1 {
+		t.Errorf("records = %d, order = %d, want 1", len(uploads.records), len(uploads.order))
+	}
+}
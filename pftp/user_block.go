@@ -0,0 +1,225 @@
+package pftp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// actions of UserBlockEvent
+const (
+	userBlockBlocked  = "blocked"
+	userBlockLifted   = "lifted"
+	userBlockRejected = "login_rejected"
+)
+
+// UserBlock is user whose logins are refused until Until
+type UserBlock struct {
+	User  string    `json:"user"`
+	Until time.Time `json:"until"`
+}
+
+// userBlocks refuse logins of users blocked by admin API, for example by
+// leaked credentials. blocks are kept in memory and end by themselves.
+// users are matched without case, because many origins accept USER in
+// any case.
+type userBlocks struct {
+	mutex  sync.Mutex
+	blocks map[string]time.Time
+	now    func() time.Time
+}
+
+func newUserBlocks() *userBlocks {
+	return &userBlocks{blocks: map[string]time.Time{}, now: time.Now}
+}
+
+// return user name compared by blocks
+func normalizeUser(user string) string {
+	return strings.ToLower(strings.TrimSpace(user))
+}
+
+// block logins of user until d passes
+func (b *userBlocks) block(user string, d time.Duration) time.Time {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	until := b.now().Add(d)
+	b.blocks[normalizeUser(user)] = until
+
+	return until
+}
+
+// lift block of user. return false when user is not blocked.
+func (b *userBlocks) lift(user string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	user = normalizeUser(user)
+	until, ok := b.blocks[user]
+	delete(b.blocks, user)
+
+	return ok && b.now().Before(until)
+}
+
+// return end of block when user is blocked
+func (b *userBlocks) blocked(user string) (time.Time, bool) {
+	if b == nil {
+		return time.Time{}, false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	user = normalizeUser(user)
+	until, ok := b.blocks[user]
+	if !ok {
+		return time.Time{}, false
+	}
+	if !b.now().Before(until) {
+		delete(b.blocks, user)
+		return time.Time{}, false
+	}
+
+	return until, true
+}
+
+// return blocks in effect ordered by user
+func (b *userBlocks) list() []UserBlock {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	blocks := []UserBlock{}
+	for user, until := range b.blocks {
+		if !now.Before(until) {
+			delete(b.blocks, user)
+			continue
+		}
+		blocks = append(blocks, UserBlock{User: user, Until: until})
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].User < blocks[j].User })
+
+	return blocks
+}
+
+// send 421 to sessions of user and close them. return count of sessions.
+func (r *sessionRegistry) killUser(user string) int {
+	if r == nil {
+		return 0
+	}
+
+	r.mutex.Lock()
+	clients := []*clientHandler{}
+	for _, c := range r.clients {
+		if normalizeUser(c.loginUser()) == normalizeUser(user) {
+			clients = append(clients, c)
+		}
+	}
+	r.mutex.Unlock()
+
+	for _, c := range clients {
+		c.closeWithNotice(closeReasonPolicyKill)
+	}
+
+	return len(clients)
+}
+
+// return user sent by USER. it is safe to call from other goroutines.
+func (c *clientHandler) loginUser() string {
+	c.summaryMutex.Lock()
+	defer c.summaryMutex.Unlock()
+
+	return c.summaryUser
+}
+
+// refuse USER of blocked user by 530
+func (c *clientHandler) refuseBlockedUser() *result {
+	until, blocked := c.userBlocks.blocked(c.param)
+	if !blocked {
+		return nil
+	}
+
+	c.events.emit(&UserBlockEvent{
+		Time:       time.Now(),
+		User:       c.redactor.user(c.param),
+		Action:     userBlockRejected,
+		Until:      until,
+		SessionID:  c.id,
+		ClientAddr: c.srcIP,
+	})
+	c.metrics.inc("pftp_blocked_logins_total", "Logins refused by blocks of users.")
+
+	return &result{
+		code: 530,
+		msg:  c.message(msgUserBlocked),
+		err:  fmt.Errorf("user is blocked until %s", until.Format(time.RFC3339)),
+		log:  c.log,
+	}
+}
+
+// GET /blocks
+// return users whose logins are blocked
+func (server *FtpServer) handleListBlocks(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, server.userBlocks.list())
+}
+
+// POST /blocks/:user {"duration": 3600}
+// close all sessions of user and block its logins for duration (sec).
+// duration is optional and user_block_duration is used when it is omitted.
+func (server *FtpServer) handleBlockUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req struct {
+		Duration int `json:"duration"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, adminError{Error: "invalid request body"})
+			return
+		}
+	}
+	if req.Duration < 0 {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: "duration must not be negative"})
+		return
+	}
+	if req.Duration == 0 && server.config != nil {
+		req.Duration = server.config.UserBlockDuration
+	}
+
+	user := ps.ByName("user")
+	until := server.userBlocks.block(user, time.Duration(req.Duration)*time.Second)
+	killed := server.clients.killUser(user)
+	server.events.emit(&UserBlockEvent{
+		Time:     time.Now(),
+		User:     server.redactor.user(user),
+		Action:   userBlockBlocked,
+		Until:    until,
+		Sessions: killed,
+	})
+
+	writeJSON(w, http.StatusOK, struct {
+		UserBlock
+		Sessions int `json:"sessions"`
+	}{UserBlock{User: user, Until: until}, killed})
+}
+
+// DELETE /blocks/:user
+// lift block of user
+func (server *FtpServer) handleLiftBlock(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user := ps.ByName("user")
+	if !server.userBlocks.lift(user) {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "user is not blocked"})
+		return
+	}
+	server.events.emit(&UserBlockEvent{
+		Time:   time.Now(),
+		User:   server.redactor.user(user),
+		Action: userBlockLifted,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,161 @@
+package pftp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_userBlocks(t *testing.T) {
+	now := time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC)
+	b := newUserBlocks()
+	b.now = func() time.Time { return now }
+
+	b.block("alice", time.Minute)
+	b.block("bob", time.Hour)
+	if until, ok := b.blocked("alice"); !ok || !until.Equal(now.Add(time.Minute)) {
+		t.Errorf("blocked(alice) = %s, %v", until, ok)
+	}
+	if _, ok := b.blocked("carol"); ok {
+		t.Error("carol is blocked")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := b.blocked("alice"); ok {
+		t.Error("block of alice did not end")
+	}
+	if got := b.list(); len(got) != 1 || got[0].User != "bob" {
+		t.Errorf("list() = %+v", got)
+	}
+	if !b.lift("bob") || b.lift("bob") {
+		t.Error("lift(bob) is not true only first time")
+	}
+}
+
+func Test_clientHandler_refuseBlockedUser(t *testing.T) {
+	events := newEventBus()
+	blocks := newUserBlocks()
+	blocks.block("alice", time.Hour)
+
+	tests := []struct {
+		user     string
+		wantCode int
+	}{
+		{"alice", 530},
+		{"Alice", 530},
+		{"bob", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			c := &clientHandler{config: &Config{}, context: &Context{}, userBlocks: blocks, events: events, log: &logger{}, param: tt.user}
+			got := c.refuseBlockedUser()
+			if tt.wantCode == 0 {
+				if got != nil {
+					t.Errorf("refuseBlockedUser() = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.code != tt.wantCode || got.msg != defaultMessages[msgUserBlocked] {
+				t.Fatalf("refuseBlockedUser() = %v, want %d", got, tt.wantCode)
+			}
+			select {
+			case e := <-events.ch:
+				if ev, ok := e.(*UserBlockEvent); !ok || ev.Action != userBlockRejected || ev.User != tt.user {
+					t.Errorf("event = %+v, want login_rejected", e)
+				}
+			default:
+				t.Error("refuseBlockedUser() did not emit event")
+			}
+		})
+	}
+}
+
+// bufferConn keep bytes written to connection
+type bufferConn struct {
+	net.Conn
+	buffer *bytes.Buffer
+}
+
+func (b *bufferConn) Write(p []byte) (int, error)      { return b.buffer.Write(p) }
+func (b *bufferConn) SetWriteDeadline(time.Time) error { return nil }
+func (b *bufferConn) Close() error                     { return nil }
+
+func Test_FtpServer_handleBlockUser(t *testing.T) {
+	server := &FtpServer{
+		config:     &Config{UserBlockDuration: 60},
+		clients:    newSessionRegistry(),
+		userBlocks: newUserBlocks(),
+		events:     newEventBus(),
+	}
+	outputs := []*bytes.Buffer{}
+	for i, user := range []string{"alice", "ALICE", "bob"} {
+		out := &bytes.Buffer{}
+		outputs = append(outputs, out)
+		server.clients.add(&clientHandler{
+			id:          uint64(i + 1),
+			summaryUser: user,
+			conn:        &bufferConn{buffer: out},
+			writer:      bufio.NewWriter(out),
+			mutex:       &sync.Mutex{},
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	server.adminHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/blocks/alice", strings.NewReader(`{"duration": 3600}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /blocks/alice code = %d", rec.Code)
+	}
+	var got struct {
+		User     string    `json:"user"`
+		Until    time.Time `json:"until"`
+		Sessions int       `json:"sessions"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.User != "alice" || got.Sessions != 2 || time.Until(got.Until) < 59*time.Minute {
+		t.Errorf("POST /blocks/alice = %+v", got)
+	}
+	for i, out := range outputs {
+		if closed := strings.HasPrefix(out.String(), "421 "); closed != (i < 2) {
+			t.Errorf("session %d got %q", i+1, out.String())
+		}
+	}
+	if e, ok := (<-server.events.ch).(*UserBlockEvent); !ok || e.Action != userBlockBlocked || e.Sessions != 2 {
+		t.Errorf("event = %+v, want blocked", e)
+	}
+
+	// default duration without body
+	rec = httptest.NewRecorder()
+	server.adminHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/blocks/bob", nil))
+	if until, ok := server.userBlocks.blocked("bob"); rec.Code != http.StatusOK || !ok || time.Until(until) > time.Minute {
+		t.Errorf("POST /blocks/bob code = %d, until = %s", rec.Code, until)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"DELETE", "/blocks/alice", http.StatusNoContent},
+		{"DELETE", "/blocks/alice", http.StatusNotFound},
+		{"GET", "/blocks", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		server.adminHandler().ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%s %s code = %d, want %d", tt.method, tt.path, rec.Code, tt.code)
+		}
+	}
+	if blocks := server.userBlocks.list(); len(blocks) != 1 || blocks[0].User != "bob" {
+		t.Errorf("blocks = %+v", blocks)
+	}
+}
@@ -0,0 +1,313 @@
+// Package resolver is helper to write middleware which resolves origin of
+// user by web API. Requests to web API have timeout, retries with jitter,
+// circuit breaker and response cache keyed by username.
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pyama86/pftp/pftp"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrCircuitOpen is returned while web API is failing repeatedly
+var ErrCircuitOpen = errors.New("circuit of resolver web API is open")
+
+// Response from web API server will contain 3 elements with JSON type.
+//
+//	{
+//	  code : response code (200 when user is found)
+//	  message : response message from server
+//	  data : origin address
+//	}
+type Response struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data"`
+}
+
+// ResponseError is returned when web API answered other than 200.
+// Message is message of response.
+type ResponseError struct {
+	Code    int
+	Message string
+}
+
+func (e *ResponseError) Error() string {
+	return e.Message
+}
+
+// retry server errors only. client errors (ex. unknown user) are answer of server.
+func (e *ResponseError) retryable() bool {
+	return e.Code >= 500
+}
+
+// Resolver request origin of user to web API
+type Resolver struct {
+	uri         string
+	client      *http.Client
+	timeout     time.Duration
+	retries     int
+	retryWait   time.Duration
+	cacheTTL    time.Duration
+	failures    int // failures opening circuit. 0 disables circuit breaker.
+	cooldown    time.Duration
+	mutex       sync.Mutex
+	cache       map[string]cacheEntry
+	nextSweep   time.Time
+	consecutive int
+	openedAt    time.Time
+	now         func() time.Time
+	sleep       func(context.Context, time.Duration) error
+}
+
+type cacheEntry struct {
+	response *Response
+	expires  time.Time
+}
+
+// Option is functional option of Resolver
+type Option func(*Resolver)
+
+// WithHTTPClient set HTTP client used for requests
+func WithHTTPClient(c *http.Client) Option {
+	return func(r *Resolver) {
+		r.client = c
+	}
+}
+
+// WithTimeout set timeout of each request (default: 5s)
+func WithTimeout(d time.Duration) Option {
+	return func(r *Resolver) {
+		r.timeout = d
+	}
+}
+
+// WithRetry set count of retries and base wait between them (default: 2, 100ms).
+// wait is doubled on each retry and randomized by jitter of +-50%.
+func WithRetry(retries int, wait time.Duration) Option {
+	return func(r *Resolver) {
+		r.retries = retries
+		r.retryWait = wait
+	}
+}
+
+// WithCache cache found users for ttl (default: disabled)
+func WithCache(ttl time.Duration) Option {
+	return func(r *Resolver) {
+		r.cacheTTL = ttl
+	}
+}
+
+// WithCircuitBreaker stop requests for cooldown after failures consecutive
+// failed resolutions (default: disabled)
+func WithCircuitBreaker(failures int, cooldown time.Duration) Option {
+	return func(r *Resolver) {
+		r.failures = failures
+		r.cooldown = cooldown
+	}
+}
+
+// New return resolver requesting uri. "%s" in uri is replaced by escaped username.
+// ex) "http://127.0.0.1:8080/getDomain?username=%s"
+func New(uri string, opts ...Option) *Resolver {
+	r := &Resolver{
+		uri:       uri,
+		client:    http.DefaultClient,
+		timeout:   5 * time.Second,
+		retries:   2,
+		retryWait: 100 * time.Millisecond,
+		cache:     map[string]cacheEntry{},
+		now:       time.Now,
+		sleep:     sleep,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Resolve return response of web API for username
+func (r *Resolver) Resolve(ctx context.Context, username string) (*Response, error) {
+	if res, ok := r.cached(username); ok {
+		return res, nil
+	}
+
+	if !r.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	res, err := r.requestWithRetry(ctx, username)
+	r.report(err)
+	if err != nil {
+		return nil, err
+	}
+
+	r.store(username, res)
+
+	return res, nil
+}
+
+// User is USER middleware setting origin of user to Context.RemoteAddr.
+// when origin cannot be resolved, RemoteAddr is not changed.
+func (r *Resolver) User(c *pftp.Context, param string) error {
+	res, err := r.Resolve(context.Background(), param)
+	if err != nil {
+		logrus.Debugf("cannot get origin host from webapi server: %v", err)
+		return nil
+	}
+
+	c.RemoteAddr = res.Data
+
+	return nil
+}
+
+func (r *Resolver) requestWithRetry(ctx context.Context, username string) (*Response, error) {
+	wait := r.retryWait
+	for i := 0; ; i++ {
+		res, err := r.request(ctx, username)
+		if err == nil {
+			return res, nil
+		}
+
+		var resErr *ResponseError
+		if i >= r.retries || (errors.As(err, &resErr) && !resErr.retryable()) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		// jitter spreads retries of many sessions failed at same time
+		if err := r.sleep(ctx, wait/2+time.Duration(rand.Int63n(int64(wait)+1))); err != nil {
+			return nil, err
+		}
+		wait *= 2
+	}
+}
+
+func (r *Resolver) request(ctx context.Context, username string) (*Response, error) {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(r.uri, url.QueryEscape(username)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Response{}
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, &ResponseError{Code: resp.StatusCode, Message: fmt.Sprintf("invalid response: %v", err)}
+	}
+	if res.Code != 200 {
+		return nil, &ResponseError{Code: res.Code, Message: res.Message}
+	}
+
+	return res, nil
+}
+
+func (r *Resolver) cached(username string) (*Response, bool) {
+	if r.cacheTTL <= 0 {
+		return nil, false
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	e, ok := r.cache[username]
+	if !ok || !r.now().Before(e.expires) {
+		return nil, false
+	}
+
+	return e.response, true
+}
+
+func (r *Resolver) store(username string, res *Response) {
+	if r.cacheTTL <= 0 {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.now()
+	// remove expired users once per ttl
+	if now.After(r.nextSweep) {
+		for u, e := range r.cache {
+			if !now.Before(e.expires) {
+				delete(r.cache, u)
+			}
+		}
+		r.nextSweep = now.Add(r.cacheTTL)
+	}
+	r.cache[username] = cacheEntry{response: res, expires: now.Add(r.cacheTTL)}
+}
+
+// return true when circuit is closed or cooldown is passed.
+// after cooldown, requests are tried until one succeeds or fails.
+func (r *Resolver) allow() bool {
+	if r.failures <= 0 {
+		return true
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.consecutive < r.failures || r.now().Sub(r.openedAt) >= r.cooldown
+}
+
+// count failures of web API. unknown user is not failure.
+func (r *Resolver) report(err error) {
+	if r.failures <= 0 {
+		return
+	}
+
+	var resErr *ResponseError
+	failed := err != nil && !(errors.As(err, &resErr) && !resErr.retryable())
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !failed {
+		r.consecutive = 0
+		return
+	}
+
+	r.consecutive++
+	if r.consecutive >= r.failures {
+		r.openedAt = r.now()
+	}
+}
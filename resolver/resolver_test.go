@@ -0,0 +1,179 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pyama86/pftp/pftp"
+	"github.com/pyama86/pftp/test"
+)
+
+func noSleep(context.Context, time.Duration) error { return nil }
+
+// return server which fails first n requests by 500
+func flakyServer(n int32, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(requests, 1) <= n {
+			rw.WriteHeader(500)
+			fmt.Fprint(rw, `{"code":500,"message":"internal error"}`)
+			return
+		}
+		fmt.Fprintf(rw, `{"code":200,"message":"Username found","data":"%s"}`, r.FormValue("username"))
+	}))
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int32
+		retries      int
+		user         string
+		want         string
+		wantErr      bool
+		wantRequests int32
+	}{
+		{name: "found", user: "vsuser", want: "vsuser", wantRequests: 1},
+		{name: "escaped", user: "a&b=c", want: "a&b=c", wantRequests: 1},
+		{name: "retried", failures: 2, retries: 2, user: "vsuser", want: "vsuser", wantRequests: 3},
+		{name: "retries_exhausted", failures: 3, retries: 2, user: "vsuser", wantErr: true, wantRequests: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			srv := flakyServer(tt.failures, &requests)
+			defer srv.Close()
+
+			r := New(srv.URL+"/getDomain?username=%s", WithRetry(tt.retries, time.Millisecond))
+			r.sleep = noSleep
+
+			got, err := r.Resolve(context.Background(), tt.user)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Data != tt.want {
+				t.Errorf("Resolve() = %s, want %s", got.Data, tt.want)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestResolver_Resolve_notFound(t *testing.T) {
+	srv := test.LaunchUnitTestRestServer(t)
+	defer srv.Close()
+
+	r := New(srv.URL+"/getDomain?username=%s", WithCircuitBreaker(1, time.Minute))
+	r.sleep = noSleep
+
+	// unknown user is not retried and does not open circuit
+	for i := 0; i < 2; i++ {
+		_, err := r.Resolve(context.Background(), "hogemoge")
+		var resErr *ResponseError
+		if !errors.As(err, &resErr) || resErr.Code != 400 || err.Error() != "Username not found" {
+			t.Fatalf("Resolve() error = %v, want Username not found", err)
+		}
+	}
+}
+
+func TestResolver_Resolve_timeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	r := New(srv.URL+"/%s", WithTimeout(20*time.Millisecond), WithRetry(0, 0))
+	start := time.Now()
+	if _, err := r.Resolve(context.Background(), "vsuser"); err == nil {
+		t.Fatal("Resolve() error = nil, want timeout")
+	}
+	if d := time.Since(start); d > 150*time.Millisecond {
+		t.Errorf("Resolve() took %s, want timeout", d)
+	}
+}
+
+func TestResolver_Resolve_cache(t *testing.T) {
+	var requests int32
+	srv := flakyServer(0, &requests)
+	defer srv.Close()
+
+	now := time.Now()
+	r := New(srv.URL+"/getDomain?username=%s", WithCache(time.Minute))
+	r.now = func() time.Time { return now }
+
+	for _, user := range []string{"vsuser", "vsuser", "prouser"} {
+		if _, err := r.Resolve(context.Background(), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("requests = %d, want 2 (cached)", requests)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := r.Resolve(context.Background(), "vsuser"); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Errorf("requests after ttl = %d, want 3", requests)
+	}
+}
+
+func TestResolver_Resolve_circuitBreaker(t *testing.T) {
+	var requests int32
+	srv := flakyServer(2, &requests)
+	defer srv.Close()
+
+	now := time.Now()
+	r := New(srv.URL+"/getDomain?username=%s", WithRetry(0, 0), WithCircuitBreaker(2, time.Minute))
+	r.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Resolve(context.Background(), "vsuser"); err == nil {
+			t.Fatal("Resolve() error = nil, want server error")
+		}
+	}
+	if _, err := r.Resolve(context.Background(), "vsuser"); err != ErrCircuitOpen {
+		t.Fatalf("Resolve() error = %v, want %v", err, ErrCircuitOpen)
+	}
+	if requests != 2 {
+		t.Errorf("requests = %d, want 2", requests)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := r.Resolve(context.Background(), "vsuser"); err != nil {
+		t.Fatalf("Resolve() after cooldown error = %v", err)
+	}
+}
+
+func TestResolver_User(t *testing.T) {
+	srv := test.LaunchUnitTestRestServer(t)
+	defer srv.Close()
+
+	r := New(srv.URL+"/getDomain?username=%s", WithRetry(0, 0))
+	tests := []struct {
+		user string
+		want string
+	}{
+		{user: "vsuser", want: "127.0.0.1:10021"},
+		{user: "hogemoge", want: "127.0.0.1:21"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			c := &pftp.Context{RemoteAddr: "127.0.0.1:21"}
+			if err := r.User(c, tt.user); err != nil {
+				t.Fatal(err)
+			}
+			if c.RemoteAddr != tt.want {
+				t.Errorf("RemoteAddr = %s, want %s", c.RemoteAddr, tt.want)
+			}
+		})
+	}
+}